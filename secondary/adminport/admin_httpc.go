@@ -16,6 +16,8 @@ import "io/ioutil"
 import "net/http"
 import "strings"
 
+import c "github.com/couchbase/indexing/secondary/common"
+
 // httpClient is a concrete type implementing Client interface.
 type httpClient struct {
 	serverAddr string
@@ -23,13 +25,22 @@ type httpClient struct {
 	httpc      *http.Client
 }
 
-// NewHTTPClient returns a new instance of Client over HTTP.
+// NewHTTPClient returns a new instance of Client over HTTP, or over
+// HTTPS if transport encryption is set up for this process.
 func NewHTTPClient(listenAddr, urlPrefix string) Client {
-	if !strings.HasPrefix(listenAddr, "http://") {
-		listenAddr = "http://" + listenAddr
+	listenAddr = strings.TrimPrefix(listenAddr, "http://")
+	listenAddr = strings.TrimPrefix(listenAddr, "https://")
+	if config := c.TransportClientTLSConfig(listenAddr); config != nil {
+		return &httpClient{
+			serverAddr: "https://" + listenAddr,
+			urlPrefix:  urlPrefix,
+			httpc: &http.Client{
+				Transport: &http.Transport{TLSClientConfig: config},
+			},
+		}
 	}
 	return &httpClient{
-		serverAddr: listenAddr,
+		serverAddr: "http://" + listenAddr,
 		urlPrefix:  urlPrefix,
 		httpc:      http.DefaultClient,
 	}
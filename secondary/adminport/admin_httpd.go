@@ -184,7 +184,7 @@ func (s *httpServer) Start() (err error) {
 		return ErrorServerStarted
 	}
 
-	if s.lis, err = net.Listen("tcp", s.srv.Addr); err != nil {
+	if s.lis, err = c.TransportListen(s.srv.Addr); err != nil {
 		logging.Errorf("%v listen failed %v\n", s.logPrefix, err)
 		return err
 	}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
 	c "github.com/couchbase/indexing/secondary/common"
+	qclient "github.com/couchbase/indexing/secondary/queryport/client"
 	"github.com/couchbase/indexing/secondary/stats"
 )
 
@@ -24,6 +28,15 @@ type ScanConfig struct {
 	NInterval   uint32 // Stats dump nrequests interval
 	Consistency bool   // Use session consistency
 
+	// MultiScan
+	Scans           qclient.Scans
+	IndexProjection *qclient.IndexProjection
+	Filter          string // N1QL predicate on index keys
+	Distinct        bool
+	Offset          int64
+
+	Weight uint32 // Share of requests in a weighted mix, default 1
+
 	iteration uint32
 }
 
@@ -33,15 +46,38 @@ type Config struct {
 	Concurrency    int
 	Clients        int
 	ClientBootTime int
+
+	// Requests picked from ScanSpecs by Weight, Repeat is ignored
+	Requests uint64
+
+	// Replay scans of a query log instead of ScanSpecs, ReplayRate
+	// scales the rate at which they were captured, default 1
+	QueryLog   string
+	ReplayRate float64
+}
+
+// QueryLogEntry is a scan request captured in a query log, which holds
+// one JSON object per line. Scans are grouped for reporting by bucket,
+// index and type.
+type QueryLogEntry struct {
+	Timestamp time.Time
+	ScanConfig
 }
 
 type ScanResult struct {
 	Id           uint64
+	Bucket       string
+	Index        string
+	Type         string
 	Rows         uint64
 	Duration     int64
 	LatencyHisto stats.Histogram
 	ErrorCount   uint64
 
+	// request latency, percentiles are computed at the end of the run
+	RequestLatencyHisto stats.Histogram
+	LatencyPercentiles  map[string]int64
+
 	// periodic stats
 	iter          uint32
 	statsRows     uint64
@@ -67,8 +103,92 @@ func parseConfig(filepath string) (*Config, error) {
 		return nil, err
 	}
 
-	err = json.Unmarshal(data, &cfg)
-	return &cfg, err
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	var raw struct {
+		ScanSpecs []json.RawMessage
+	}
+	if err = json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	for i, spec := range cfg.ScanSpecs {
+		if err = setUnbounded(raw.ScanSpecs[i], spec); err != nil {
+			return nil, err
+		}
+	}
+	return &cfg, nil
+}
+
+func parseQueryLog(filepath string) ([]*QueryLogEntry, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []*QueryLogEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry := new(QueryLogEntry)
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, err
+		}
+		if err := setUnbounded(scanner.Bytes(), &entry.ScanConfig); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// setUnbounded sets filter keys of MultiScan specs that are missing in
+// JSON to unbounded, a JSON null is a null key.
+func setUnbounded(data []byte, spec *ScanConfig) error {
+	if len(spec.Scans) == 0 {
+		return nil
+	}
+
+	var raw struct {
+		Scans []struct {
+			Filter []map[string]json.RawMessage
+		}
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	has := func(filter map[string]json.RawMessage, field string) bool {
+		for key := range filter {
+			if strings.EqualFold(key, field) {
+				return true
+			}
+		}
+		return false
+	}
+	for i, scan := range raw.Scans {
+		if i >= len(spec.Scans) || spec.Scans[i] == nil {
+			continue
+		}
+		for j, f := range scan.Filter {
+			if j >= len(spec.Scans[i].Filter) || spec.Scans[i].Filter[j] == nil {
+				continue
+			}
+			filter := spec.Scans[i].Filter[j]
+			if !has(f, "Low") {
+				filter.Low = c.MinUnbounded
+			}
+			if !has(f, "High") {
+				filter.High = c.MaxUnbounded
+			}
+		}
+	}
+	return nil
 }
 
 func writeResults(r *Result, filepath string) error {
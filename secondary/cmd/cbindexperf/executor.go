@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"math/rand"
 	"os"
 	"sync"
 	"sync/atomic"
@@ -22,6 +23,8 @@ var (
 
 	clientBootTime = 5 // Seconds
 	requestCounter = uint64(0)
+
+	latencyPercentiles = []float64{50, 90, 95, 99}
 )
 
 type Job struct {
@@ -91,6 +94,28 @@ func RunJob(client *qclient.GsiClient, job *Job, aggrQ chan *JobResult) {
 		requestID := os.Args[0] + uuid
 		err = client.Lookup(spec.DefnId, requestID, spec.Lookups, false,
 			spec.Limit, cons, nil, callb)
+	case "MultiScan":
+		requestID := os.Args[0] + uuid
+		err = client.MultiScan(spec.DefnId, requestID, spec.Scans, false,
+			spec.Distinct, spec.IndexProjection, nil, nil, spec.Filter,
+			spec.Offset, spec.Limit, cons, nil, callb)
+	case "Count":
+		requestID := os.Args[0] + uuid
+		if len(spec.Scans) > 0 {
+			_, err = client.MultiScanCount(spec.DefnId, requestID, spec.Scans,
+				spec.Distinct, cons, nil)
+		} else if len(spec.Lookups) > 0 {
+			_, err = client.CountLookup(spec.DefnId, requestID, spec.Lookups,
+				cons, nil)
+		} else {
+			_, err = client.CountRange(spec.DefnId, requestID, spec.Low,
+				spec.High, qclient.Inclusion(spec.Inclusion), cons, nil)
+		}
+		if err == nil {
+			rows = 1
+		}
+	default:
+		err = fmt.Errorf("unknown scan type %v", spec.Type)
 	}
 
 	if err != nil {
@@ -133,6 +158,7 @@ func ResultAggregator(ch chan *JobResult, sw io.Writer, wg *sync.WaitGroup) {
 			lat = jr.dur / jr.rows
 		}
 		result.LatencyHisto.Add(lat)
+		result.RequestLatencyHisto.Add(jr.dur)
 
 		result.iter++
 		if sw != nil && spec.NInterval > 0 &&
@@ -153,6 +179,7 @@ func RunCommands(cluster string, cfg *Config, statsW io.Writer) (*Result, error)
 	var jobQ chan *Job
 	var aggrQ chan *JobResult
 	var wg1, wg2 sync.WaitGroup
+	var err error
 
 	if len(cfg.LatencyBuckets) == 0 {
 		cfg.LatencyBuckets = defaultLatencyBuckets
@@ -162,6 +189,13 @@ func RunCommands(cluster string, cfg *Config, statsW io.Writer) (*Result, error)
 		cfg.ClientBootTime = clientBootTime
 	}
 
+	var queryLog []*QueryLogEntry
+	if cfg.QueryLog != "" {
+		if queryLog, err = parseQueryLog(cfg.QueryLog); err != nil {
+			return nil, err
+		}
+	}
+
 	config := c.SystemConfig.SectionConfig("queryport.client.", true)
 	config.SetValue("settings.poolSize", int(cfg.Concurrency))
 	config.SetValue("readDeadline", 0)
@@ -201,32 +235,23 @@ func RunCommands(cluster string, cfg *Config, statsW io.Writer) (*Result, error)
 	wg2.Add(1)
 	go ResultAggregator(aggrQ, statsW, &wg2)
 
+	defnIds := make(map[string]uint64)
+	for _, index := range indexes {
+		key := index.Definition.Bucket + ":" + index.Definition.Name
+		defnIds[key] = uint64(index.Definition.DefnId)
+	}
+	resolve := func(spec *ScanConfig) {
+		if defnId, ok := defnIds[spec.Bucket+":"+spec.Index]; ok {
+			spec.DefnId = defnId
+		}
+	}
+
 	for i, spec := range cfg.ScanSpecs {
 		if spec.Id == 0 {
 			spec.Id = uint64(i)
 		}
-
-		for _, index := range indexes {
-			if index.Definition.Bucket == spec.Bucket &&
-				index.Definition.Name == spec.Index {
-				spec.DefnId = uint64(index.Definition.DefnId)
-			}
-		}
-
-		hFn := func(v int64) string {
-			if v == math.MinInt64 {
-				return "0"
-			} else if v == math.MaxInt64 {
-				return "inf"
-			}
-			return fmt.Sprint(time.Nanosecond * time.Duration(v))
-		}
-
-		res := new(ScanResult)
-		res.ErrorCount = 0
-		res.LatencyHisto.Init(cfg.LatencyBuckets, hFn)
-		res.Id = spec.Id
-		result.ScanResults = append(result.ScanResults, res)
+		resolve(spec)
+		result.ScanResults = append(result.ScanResults, newScanResult(spec, cfg))
 	}
 
 	// warming up GsiClient
@@ -241,7 +266,52 @@ func RunCommands(cluster string, cfg *Config, statsW io.Writer) (*Result, error)
 	fmt.Println("GsiClients warmed up ...")
 	result.WarmupDuration = float64(time.Since(t0).Nanoseconds()) / float64(time.Second)
 
-	// Round robin scheduling of jobs
+	switch {
+	case cfg.QueryLog != "":
+		replayQueryLog(queryLog, cfg, resolve, jobQ, &result)
+	case cfg.Requests > 0:
+		scheduleWeighted(cfg, jobQ, result.ScanResults)
+	default:
+		scheduleRoundRobin(cfg, jobQ, result.ScanResults)
+	}
+
+	close(jobQ)
+	wg1.Wait()
+	close(aggrQ)
+	wg2.Wait()
+
+	for _, res := range result.ScanResults {
+		res.LatencyPercentiles = make(map[string]int64)
+		for _, p := range latencyPercentiles {
+			key := fmt.Sprintf("p%v", p)
+			res.LatencyPercentiles[key] = res.RequestLatencyHisto.Percentile(p)
+		}
+	}
+
+	return &result, err
+}
+
+func newScanResult(spec *ScanConfig, cfg *Config) *ScanResult {
+	hFn := func(v int64) string {
+		if v == math.MinInt64 {
+			return "0"
+		} else if v == math.MaxInt64 {
+			return "inf"
+		}
+		return fmt.Sprint(time.Nanosecond * time.Duration(v))
+	}
+
+	res := new(ScanResult)
+	res.ErrorCount = 0
+	res.LatencyHisto.Init(cfg.LatencyBuckets, hFn)
+	res.RequestLatencyHisto.Init(cfg.LatencyBuckets, hFn)
+	res.Id = spec.Id
+	res.Bucket, res.Index, res.Type = spec.Bucket, spec.Index, spec.Type
+	return res
+}
+
+// Round robin scheduling of jobs, each spec is run Repeat+1 times.
+func scheduleRoundRobin(cfg *Config, jobQ chan *Job, results []*ScanResult) {
 	var allFinished bool
 
 loop:
@@ -251,7 +321,7 @@ loop:
 			if iter := atomic.LoadUint32(&spec.iteration); iter < spec.Repeat+1 {
 				j := &Job{
 					spec:   spec,
-					result: result.ScanResults[i],
+					result: results[i],
 				}
 
 				jobQ <- j
@@ -264,11 +334,70 @@ loop:
 			break loop
 		}
 	}
+}
 
-	close(jobQ)
-	wg1.Wait()
-	close(aggrQ)
-	wg2.Wait()
+// Weighted scheduling of jobs, cfg.Requests jobs are picked at random
+// from specs in proportion to their Weight.
+func scheduleWeighted(cfg *Config, jobQ chan *Job, results []*ScanResult) {
+	weights := make([]uint64, len(cfg.ScanSpecs))
+	total := uint64(0)
+	for i, spec := range cfg.ScanSpecs {
+		if spec.Weight == 0 {
+			spec.Weight = 1
+		}
+		total += uint64(spec.Weight)
+		weights[i] = total
+	}
+	if total == 0 {
+		return
+	}
 
-	return &result, err
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for n := uint64(0); n < cfg.Requests; n++ {
+		pick := uint64(rnd.Int63n(int64(total)))
+		for i, w := range weights {
+			if pick < w {
+				jobQ <- &Job{spec: cfg.ScanSpecs[i], result: results[i]}
+				break
+			}
+		}
+	}
+}
+
+// Replay scans of a query log, preserving the intervals between them
+// scaled by cfg.ReplayRate. Scans of the same bucket, index and type
+// share a ScanResult.
+func replayQueryLog(entries []*QueryLogEntry, cfg *Config,
+	resolve func(*ScanConfig), jobQ chan *Job, result *Result) {
+
+	rate := cfg.ReplayRate
+	if rate <= 0 {
+		rate = 1
+	}
+
+	results := make(map[string]*ScanResult)
+	var t0, start time.Time
+	for i, entry := range entries {
+		spec := &entry.ScanConfig
+		key := spec.Bucket + ":" + spec.Index + ":" + spec.Type
+		res, ok := results[key]
+		if !ok {
+			spec.Id = uint64(len(results))
+			res = newScanResult(spec, cfg)
+			results[key] = res
+			result.ScanResults = append(result.ScanResults, res)
+		}
+		spec.Id = res.Id
+		resolve(spec)
+
+		if i == 0 {
+			t0, start = entry.Timestamp, time.Now()
+		} else {
+			offset := float64(entry.Timestamp.Sub(t0)) / rate
+			if d := time.Duration(offset) - time.Since(start); d > 0 {
+				time.Sleep(d)
+			}
+		}
+		jobQ <- &Job{spec: spec, result: res}
+	}
 }
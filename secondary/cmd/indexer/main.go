@@ -49,6 +49,9 @@ func main() {
 	httpsPort := fset.String("httpsPort", "", "Index https mgmt port")
 	certFile := fset.String("certFile", "", "Index https X509 certificate file")
 	keyFile := fset.String("keyFile", "", "Index https cert key file")
+	caFile := fset.String("caFile", "", "CA certificate file to verify TLS peers, defaults to certFile")
+	encryptTransport := fset.Bool("encryptTransport", false, "Use TLS for scan, stream and projector connections")
+	allowPlaintext := fset.Bool("allowPlaintext", false, "Accept plaintext scan and stream connections with encryptTransport")
 	isEnterprise := fset.Bool("isEnterprise", true, "Enterprise Edition")
 
 	for i := 1; i < len(os.Args); i++ {
@@ -85,6 +88,9 @@ func main() {
 	config.SetValue("indexer.httpsPort", *httpsPort)
 	config.SetValue("indexer.certFile", *certFile)
 	config.SetValue("indexer.keyFile", *keyFile)
+	config.SetValue("indexer.caFile", *caFile)
+	config.SetValue("indexer.encryptTransport", *encryptTransport)
+	config.SetValue("indexer.allowPlaintext", *allowPlaintext)
 	config.SetValue("indexer.streamInitPort", *streamInitPort)
 	config.SetValue("indexer.streamCatchupPort", *streamCatchupPort)
 	config.SetValue("indexer.streamMaintPort", *streamMaintPort)
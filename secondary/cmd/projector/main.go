@@ -25,6 +25,11 @@ var options struct {
 	auth        string
 	loglevel    string
 	diagDir     string
+	certFile    string
+	keyFile     string
+	caFile      string
+	encrypt     bool
+	plaintext   bool
 }
 
 func argParse() string {
@@ -36,6 +41,11 @@ func argParse() string {
 	fset.StringVar(&options.loglevel, "logLevel", "Info", "Log Level - Silent, Fatal, Error, Info, Debug, Trace")
 	fset.StringVar(&options.auth, "auth", "", "Auth user and password")
 	fset.StringVar(&options.diagDir, "diagDir", "./", "Directory for writing projector diagnostic information")
+	fset.StringVar(&options.certFile, "certFile", "", "X509 certificate file for TLS")
+	fset.StringVar(&options.keyFile, "keyFile", "", "X509 certificate key file for TLS")
+	fset.StringVar(&options.caFile, "caFile", "", "CA certificate file to verify TLS peers, defaults to certFile")
+	fset.BoolVar(&options.encrypt, "encryptTransport", false, "Use TLS for adminport and stream connections")
+	fset.BoolVar(&options.plaintext, "allowPlaintext", false, "Accept plaintext adminport connections with encryptTransport")
 
 	logging.Infof("Parsing the args")
 
@@ -83,6 +93,11 @@ func main() {
 	config.SetValue("projector.clusterAddr", cluster)
 	config.SetValue("projector.adminport.listenAddr", options.adminport)
 	config.SetValue("projector.diagnostics_dir", options.diagDir)
+	config.SetValue("projector.certFile", options.certFile)
+	config.SetValue("projector.keyFile", options.keyFile)
+	config.SetValue("projector.caFile", options.caFile)
+	config.SetValue("projector.encryptTransport", options.encrypt)
+	config.SetValue("projector.allowPlaintext", options.plaintext)
 
 	if err := os.MkdirAll(options.diagDir, 0755); err != nil {
 		c.CrashOnError(err)
@@ -94,6 +109,15 @@ func main() {
 		if _, err := cbauth.InternalRetryDefaultInit(cluster, up[0], up[1]); err != nil {
 			logging.Fatalf("Failed to initialize cbauth: %s", err)
 		}
+		// on failure to reload, previous certificate continues to be
+		// used for new connections, and the error is reported back.
+		cbauth.RegisterCertRefreshCallback(func() error {
+			if err := c.ReloadTransportCertificate(); err != nil {
+				logging.Errorf("Failed to reload transport certificate: %v", err)
+				return err
+			}
+			return nil
+		})
 	}
 
 	epfactory := NewEndpointFactory(cluster, options.numVbuckets)
@@ -269,6 +269,47 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	// projector transport security
+	"projector.encryptTransport": ConfigValue{
+		false,
+		"use TLS for adminport listener and for dataport connections " +
+			"with indexer, certificate is reloaded without restart " +
+			"when certFile is modified",
+		false,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"projector.allowPlaintext": ConfigValue{
+		false,
+		"with encryptTransport, adminport listener also accept plaintext " +
+			"connections, from clients and nodes that do not encrypt " +
+			"yet, explicit opt-in during upgrade, disable once the " +
+			"whole cluster is encrypted",
+		false,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"projector.certFile": ConfigValue{
+		"",
+		"X509 certificate presented by adminport listener",
+		"",
+		true, // immutable
+		true, // case-sensitive
+	},
+	"projector.keyFile": ConfigValue{
+		"",
+		"X509 certificate key",
+		"",
+		true, // immutable
+		true, // case-sensitive
+	},
+	"projector.caFile": ConfigValue{
+		"",
+		"CA certificate to verify TLS peers, if empty certFile is used",
+		"",
+		true, // immutable
+		true, // case-sensitive
+	},
 	// projector adminport parameters
 	"projector.adminport.name": ConfigValue{
 		"projector.adminport",
@@ -373,6 +414,15 @@ var SystemConfig = Config{
 		false,         // mutable
 		false,         // case-insensitive
 	},
+	"projector.dataport.compression": ConfigValue{
+		"none",
+		"compression for mutations sent to downstream client, can be " +
+			"none, snappy or gzip. Applied only if downstream client " +
+			"accepts the compression.",
+		"none",
+		false, // mutable
+		true,  // case-insensitive
+	},
 	"projector.gogc": ConfigValue{
 		100, // 100 percent
 		"set GOGC percent",
@@ -476,6 +526,14 @@ var SystemConfig = Config{
 		true,  // immutable
 		false, // case-insensitive
 	},
+	"indexer.queryport.compression": ConfigValue{
+		"none",
+		"compression for scan responses, can be none, snappy or gzip. " +
+			"Applied only if client accepts the compression.",
+		"none",
+		true, // immutable
+		true, // case-insensitive
+	},
 	// queryport client configuration
 	"queryport.client.maxPayload": ConfigValue{
 		1000 * 1024,
@@ -498,6 +556,21 @@ var SystemConfig = Config{
 		true,  // immutable
 		false, // case-insensitive
 	},
+	"queryport.client.encryptTransport": ConfigValue{
+		false,
+		"use TLS for connections with indexer's queryport",
+		false,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.caFile": ConfigValue{
+		"",
+		"CA certificate to verify queryport, if empty system roots " +
+			"are used",
+		"",
+		true, // immutable
+		true, // case-sensitive
+	},
 	"queryport.client.settings.poolSize": ConfigValue{
 		1000,
 		"number simultaneous active connections connections in a pool",
@@ -542,6 +615,29 @@ var SystemConfig = Config{
 		true,  // immutable
 		false, // case-insensitive
 	},
+	"queryport.client.scanRejectRetries": ConfigValue{
+		5,
+		"number of times to retry a scan rejected by indexer admission " +
+			"control, after trying all replicas",
+		5,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.scanRejectBackoff": ConfigValue{
+		20,
+		"wait, in milliseconds, before re-trying a rejected scan, " +
+			"doubled on every retry",
+		20,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.scanRejectMaxBackoff": ConfigValue{
+		1000,
+		"maximum wait, in milliseconds, before re-trying a rejected scan",
+		1000,
+		true,  // immutable
+		false, // case-insensitive
+	},
 	"queryport.client.servicesNotifierRetryTm": ConfigValue{
 		1000,
 		"wait, in milliseconds, before restarting the ServicesNotifier",
@@ -603,6 +699,29 @@ var SystemConfig = Config{
 		false, // immutable
 		false, // case-insensitive
 	},
+	"queryport.client.scanHedge": ConfigValue{
+		false,
+		"hedge scans, by sending the scan to another replica when the " +
+			"chosen replica has not responded within hedge delay",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"queryport.client.scanHedgePercentile": ConfigValue{
+		0.95,
+		"hedge delay is this percentile of the latency of first response " +
+			"from a replica, value between (0, 1.0]",
+		0.95,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"queryport.client.scanHedgeMinDelay": ConfigValue{
+		2,
+		"minimum hedge delay, in milliseconds",
+		2,
+		false, // mutable
+		false, // case-insensitive
+	},
 	// projector's adminport client, can be used by indexer.
 	"indexer.projectorclient.retryInterval": ConfigValue{
 		16,
@@ -674,6 +793,32 @@ var SystemConfig = Config{
 		true, // immutable
 		true, // case-sensitive
 	},
+	"indexer.caFile": ConfigValue{
+		"",
+		"CA certificate to verify TLS peers, if empty certFile is used",
+		"",
+		true, // immutable
+		true, // case-sensitive
+	},
+	"indexer.encryptTransport": ConfigValue{
+		false,
+		"use TLS for queryport and dataport listeners and for " +
+			"adminport connections with projector, certificate is " +
+			"reloaded without restart when certFile is modified",
+		false,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.allowPlaintext": ConfigValue{
+		false,
+		"with encryptTransport, queryport, dataport and adminport listeners also accept plaintext " +
+			"connections, from clients and nodes that do not encrypt " +
+			"yet, explicit opt-in during upgrade, disable once the " +
+			"whole cluster is encrypted",
+		false,
+		true,  // immutable
+		false, // case-insensitive
+	},
 	"indexer.isEnterprise": ConfigValue{
 		true,
 		"enterprise edition",
@@ -1149,6 +1294,46 @@ var SystemConfig = Config{
 		false,
 	},
 
+	"indexer.lsm.memtableSize": ConfigValue{
+		16 * 1024 * 1024,
+		"Size of in-memory writes of an lsm slice after which they " +
+			"are flushed to a segment file",
+		16 * 1024 * 1024,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.lsm.maxSegments": ConfigValue{
+		8,
+		"Number of segment files of an lsm slice after which " +
+			"adjacent segments are merged",
+		8,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.lsm.blockSize": ConfigValue{
+		16 * 1024,
+		"Size of data blocks in lsm segment files",
+		16 * 1024,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.lsm.bloomBitsPerKey": ConfigValue{
+		10,
+		"Bits per key in bloom filter of lsm segment files, " +
+			"0 disables the filter",
+		10,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.storage.lsm.commitPollInterval": ConfigValue{
+		uint64(1),
+		"Time in milliseconds for a slice to poll for " +
+			"any outstanding writes before commit",
+		uint64(1),
+		false, // mutable
+		false, // case-insensitive
+	},
+
 	"indexer.stream_reader.plasma.workerBuffer": ConfigValue{
 		uint64(10000),
 		"Buffer Size for stream reader worker to hold mutations " +
@@ -1379,6 +1564,13 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.settings.lsm.recovery.max_rollbacks": ConfigValue{
+		2,
+		"Maximum number of committed rollback points",
+		2,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.recovery.max_rollbacks": ConfigValue{
 		5, // keep in sync with index_settings_manager.erl
 		"Maximum number of committed rollback points",
@@ -1416,6 +1608,111 @@ var SystemConfig = Config{
 		true,  // immutable
 		false, // case-insensitive
 	},
+	"indexer.settings.scan_admission.bucket_concurrency": ConfigValue{
+		0,
+		"maximum number of concurrent scans on a bucket, scans beyond the " +
+			"limit are rejected and retried by the client, 0 is unlimited",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_admission.bucket_rate": ConfigValue{
+		0,
+		"maximum number of scans per second on a bucket, scans beyond the " +
+			"limit are rejected and retried by the client, 0 is unlimited",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_admission.bucket_burst": ConfigValue{
+		0,
+		"number of scans on a bucket that can be admitted in a burst above " +
+			"bucket_rate, 0 defaults to bucket_rate",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_admission.index_concurrency": ConfigValue{
+		0,
+		"maximum number of concurrent scans on an index, scans beyond the " +
+			"limit are rejected and retried by the client, 0 is unlimited",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_admission.index_rate": ConfigValue{
+		0,
+		"maximum number of scans per second on an index, scans beyond the " +
+			"limit are rejected and retried by the client, 0 is unlimited",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_admission.index_burst": ConfigValue{
+		0,
+		"number of scans on an index that can be admitted in a burst above " +
+			"index_rate, 0 defaults to index_rate",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.snapshot_lease.default_time": ConfigValue{
+		60000,
+		"lease time, in milliseconds, of a snapshot pinned for scans at a " +
+			"point in time, if not specified by the client",
+		60000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.snapshot_lease.max_time": ConfigValue{
+		600000,
+		"maximum lease time, in milliseconds, of a snapshot pinned for " +
+			"scans at a point in time",
+		600000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.snapshot_lease.max_per_index": ConfigValue{
+		4,
+		"maximum number of snapshots pinned for an index, pinned snapshots " +
+			"hold on to memory and disk of older versions, 0 is unlimited",
+		4,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_log.sample_rate": ConfigValue{
+		float64(0),
+		"fraction of scan requests, between 0 and 1, logged to the scan " +
+			"log in diagnostics_dir, 0 disables sampling. Failed and " +
+			"rejected requests are logged while sampling or slow_threshold " +
+			"is enabled",
+		float64(0),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_log.slow_threshold": ConfigValue{
+		0,
+		"scan requests taking longer than this, in milliseconds, are " +
+			"always logged to the scan log, 0 disables",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_log.max_file_size": ConfigValue{
+		10 * 1024 * 1024,
+		"size, in bytes, at which the scan log is rotated",
+		10 * 1024 * 1024,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_log.num_files": ConfigValue{
+		5,
+		"number of scan log files retained, including the one being " +
+			"written",
+		5,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.max_array_seckey_size": ConfigValue{
 		10240,
 		"Maximum size of secondary index key size for array index",
@@ -1519,6 +1816,23 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.settings.docidFilter.enable": ConfigValue{
+		false,
+		"Maintain a bloom filter of docids in the back-index of " +
+			"each slice, to skip back-index lookups for new docids. " +
+			"Applies to slices opened after the change.",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.docidFilter.initialCapacity": ConfigValue{
+		uint64(65536),
+		"Number of docids the bloom filter of a slice writer is " +
+			"sized for initially, filter grows as more docids are added.",
+		uint64(65536),
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.bufferPoolBlockSize": ConfigValue{
 		16 * 1024,
 		"Size of memory block in memory pool",
@@ -1608,6 +1922,16 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.settings.moi.persistence.max_delta_snapshots": ConfigValue{
+		4,
+		"Maximum number of delta snapshots persisted on top of a full disk snapshot, " +
+			"before persisting a full snapshot again. 0 disables delta snapshots. " +
+			"Last persisted snapshot is kept open as base of the next delta, so items " +
+			"deleted in between are not garbage collected till the next persist",
+		4,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.moi.recovery_threads": ConfigValue{
 		runtime.NumCPU(),
 		"Number of concurrent threads for rebuilding index from disk snapshot",
@@ -1617,7 +1941,7 @@ var SystemConfig = Config{
 	},
 	"indexer.settings.storage_mode": ConfigValue{
 		"",
-		"Storage Type e.g. forestdb, memory_optimized",
+		"Storage Type e.g. forestdb, memory_optimized, lsm",
 		"",
 		false, // mutable
 		false, // case-insensitive
@@ -1674,6 +1998,14 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.rebalance.peer_transfer": ConfigValue{
+		false,
+		"copy persisted snapshots of moved memory optimized indexes " +
+			"from the source indexer, instead of building them from DCP.",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.rebalance.disable_index_move": ConfigValue{
 		false,
 		"disable index movement on node add/remove",
@@ -1739,6 +2071,53 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.build.throttle.enable": ConfigValue{
+		true,
+		"Throttle initial index build when maintenance stream, " +
+			"mutation queue or cpu is under pressure",
+		true,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.build.throttle.interval": ConfigValue{
+		uint64(1000),
+		"time in milliseconds between evaluations of build throttling",
+		uint64(1000),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.build.throttle.memHighFrac": ConfigValue{
+		0.7,
+		"fraction of mutation queue memory in use by streams other than " +
+			"INIT_STREAM above which initial index build is throttled",
+		0.7,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.build.throttle.cpuHighFrac": ConfigValue{
+		0.9,
+		"fraction of available cpu in use, excluding the share of initial " +
+			"builds, above which initial index build is throttled",
+		0.9,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.build.throttle.maintLag": ConfigValue{
+		uint64(100000),
+		"number of mutations pending for an index in maintenance stream " +
+			"above which initial index build is throttled",
+		uint64(100000),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.build.throttle.minPercent": ConfigValue{
+		uint64(10),
+		"minimum percent of mutation queue memory available " +
+			"to initial index build when throttled",
+		uint64(10),
+		false, // mutable
+		false, // case-insensitive
+	},
 }
 
 // NewConfig from another
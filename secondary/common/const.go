@@ -49,6 +49,10 @@ var ErrClientCancel = errors.New("Client requested cancel")
 
 var ErrIndexerInBootstrap = errors.New("Indexer In Warmup State. Please retry the request later.")
 
+// ErrScanRejected when indexer is not admitting more scans on the bucket
+// or index, scan can be retried after backing off.
+var ErrScanRejected = errors.New("Index scan rejected by admission control. Please retry the request later.")
+
 const INDEXER_45_VERSION = 1
 const INDEXER_50_VERSION = 2
 const INDEXER_CUR_VERSION = INDEXER_50_VERSION
@@ -33,6 +33,35 @@ const (
 	MaxUnbounded           = 1
 )
 
+// AggrFuncType is the aggregate function computed by indexer, per group,
+// when group-by/aggregate is pushed down to an index scan.
+type AggrFuncType uint32
+
+const (
+	AGG_COUNT AggrFuncType = iota
+	AGG_COUNTN
+	AGG_SUM
+	AGG_MIN
+	AGG_MAX
+)
+
+func (a AggrFuncType) String() string {
+	switch a {
+	case AGG_COUNT:
+		return "COUNT"
+	case AGG_COUNTN:
+		return "COUNTN"
+	case AGG_SUM:
+		return "SUM"
+	case AGG_MIN:
+		return "MIN"
+	case AGG_MAX:
+		return "MAX"
+	default:
+		return "UNKNOWN"
+	}
+}
+
 // IndexStatistics captures statistics for a range or a single key.
 type IndexStatistics interface {
 	Count() (int64, error)
@@ -62,6 +91,14 @@ const (
 	SINGLE                 = "SINGLE"
 )
 
+// Expiry index, created with {"expiry": true}, indexes documents that
+// expire on their absolute expiry time in seconds since epoch. Documents
+// without expiration are not indexed.
+const (
+	EXPIRY_INDEX_KEY   = "meta().expiration"
+	EXPIRY_INDEX_WHERE = "meta().expiration > 0"
+)
+
 type IndexState int
 
 const (
@@ -187,11 +224,18 @@ type IndexDefn struct {
 	Nodes           []string        `json:"nodes,omitempty"`
 	IsArrayIndex    bool            `json:"isArrayIndex,omitempty"`
 	NumReplica      uint32          `json:"numReplica,omitempty"`
+	NumPartitions   uint32          `json:"numPartitions,omitempty"`
+	PartitionSplits []string        `json:"partitionSplits,omitempty"`
+	BuildPriority   int             `json:"buildPriority,omitempty"`
+	Include         []string        `json:"include,omitempty"`
+	Expiry          bool            `json:"expiry,omitempty"`
 
 	// transient field (not part of index metadata)
 	InstVersion int         `json:"instanceVersion,omitempty"`
 	ReplicaId   int         `json:"replicaId,omitempty"`
 	InstId      IndexInstId `json:"instanceId,omitempty"`
+	// partitions hosted by the instance, all partitions if empty.
+	Partitions []PartitionId `json:"partitions,omitempty"`
 }
 
 //IndexInst is an instance of an Index(aka replica)
@@ -225,9 +269,14 @@ func (idx IndexDefn) String() string {
 	str += fmt.Sprintf("InstVersion: %v ", idx.InstVersion)
 	str += fmt.Sprintf("\n\t\tSecExprs: %v ", idx.SecExprs)
 	str += fmt.Sprintf("\n\t\tDesc: %v", idx.Desc)
+	str += fmt.Sprintf("\n\t\tInclude: %v ", idx.Include)
 	str += fmt.Sprintf("\n\t\tPartitionScheme: %v ", idx.PartitionScheme)
 	str += fmt.Sprintf("PartitionKey: %v ", idx.PartitionKey)
+	str += fmt.Sprintf("NumPartitions: %v ", idx.NumPartitions)
+	str += fmt.Sprintf("PartitionSplits: %v ", idx.PartitionSplits)
 	str += fmt.Sprintf("WhereExpr: %v ", idx.WhereExpr)
+	str += fmt.Sprintf("BuildPriority: %v ", idx.BuildPriority)
+	str += fmt.Sprintf("Expiry: %v ", idx.Expiry)
 	return str
 
 }
@@ -253,7 +302,61 @@ func (idx IndexDefn) Clone() *IndexDefn {
 		Nodes:           idx.Nodes,
 		IsArrayIndex:    idx.IsArrayIndex,
 		NumReplica:      idx.NumReplica,
+		NumPartitions:   idx.NumPartitions,
+		PartitionSplits: idx.PartitionSplits,
+		BuildPriority:   idx.BuildPriority,
+		Include:         idx.Include,
+		Expiry:          idx.Expiry,
+	}
+}
+
+// GetNumPartitions returns the number of partitions for the index,
+// which is always 1 unless the index is hash or range partitioned.
+// N split points of a range partitioned index make N+1 partitions.
+func (idx *IndexDefn) GetNumPartitions() int {
+	if idx.PartitionScheme == HASH && idx.NumPartitions > 1 {
+		return int(idx.NumPartitions)
+	}
+	if idx.PartitionScheme == RANGE && len(idx.PartitionSplits) > 0 {
+		return len(idx.PartitionSplits) + 1
 	}
+	return 1
+}
+
+// HostedPartitions returns the partitions of the index hosted by the
+// instance, as placed on index creation.
+func (idx *IndexDefn) HostedPartitions() []PartitionId {
+	if len(idx.Partitions) > 0 {
+		return idx.Partitions
+	}
+	partnIds := make([]PartitionId, 0, idx.GetNumPartitions())
+	for i := 0; i < idx.GetNumPartitions(); i++ {
+		partnIds = append(partnIds, PartitionId(i))
+	}
+	return partnIds
+}
+
+// PlacePartitions assigns partitions of the index to numNodes nodes,
+// returning the partitions hosted by each node. Range partitions are
+// assigned in contiguous runs, so that a node scans its partitions in
+// index order; hash partitions are assigned round-robin.
+func (idx *IndexDefn) PlacePartitions(numNodes int) [][]PartitionId {
+	numPartitions := idx.GetNumPartitions()
+	if numNodes > numPartitions {
+		numNodes = numPartitions
+	}
+	if numNodes < 1 {
+		numNodes = 1
+	}
+	placement := make([][]PartitionId, numNodes)
+	for i := 0; i < numPartitions; i++ {
+		node := i % numNodes
+		if idx.PartitionScheme == RANGE {
+			node = i * numNodes / numPartitions
+		}
+		placement[node] = append(placement[node], PartitionId(i))
+	}
+	return placement
 }
 
 func (idx *IndexDefn) HasDescending() bool {
@@ -445,11 +548,12 @@ const (
 	MemDB           = "memdb"
 	MemoryOptimized = "memory_optimized"
 	PlasmaDB        = "plasma"
+	LSMDB           = "lsm"
 )
 
 func IsValidIndexType(t string) bool {
 	switch strings.ToLower(t) {
-	case ForestDB, MemDB, MemoryOptimized, PlasmaDB:
+	case ForestDB, MemDB, MemoryOptimized, PlasmaDB, LSMDB:
 		return true
 	}
 
@@ -463,7 +567,8 @@ func IsEquivalentIndex(d1, d2 *IndexDefn) bool {
 		d1.ExprType != d2.ExprType ||
 		d1.PartitionScheme != d2.PartitionScheme ||
 		d1.PartitionKey != d2.PartitionKey ||
-		d1.WhereExpr != d2.WhereExpr {
+		d1.WhereExpr != d2.WhereExpr ||
+		d1.Expiry != d2.Expiry {
 
 		return false
 	}
@@ -488,6 +593,26 @@ func IsEquivalentIndex(d1, d2 *IndexDefn) bool {
 		}
 	}
 
+	if len(d1.PartitionSplits) != len(d2.PartitionSplits) {
+		return false
+	}
+
+	for i, s1 := range d1.PartitionSplits {
+		if s1 != d2.PartitionSplits[i] {
+			return false
+		}
+	}
+
+	if len(d1.Include) != len(d2.Include) {
+		return false
+	}
+
+	for i, s1 := range d1.Include {
+		if s1 != d2.Include[i] {
+			return false
+		}
+	}
+
 	return true
 }
 
@@ -10,8 +10,10 @@
 package common
 
 import (
+	"bytes"
 	"github.com/couchbase/indexing/secondary/logging"
 	"hash/crc32"
+	"sort"
 )
 
 //KeyPartitionDefn defines a key based partition in terms of topology
@@ -34,6 +36,7 @@ func (kp KeyPartitionDefn) Endpoints() []Endpoint {
 type KeyPartitionContainer struct {
 	PartitionMap  map[PartitionId]KeyPartitionDefn
 	NumPartitions int
+	Splits        [][]byte //collated split points, for range partitions
 }
 
 //NewKeyPartitionContainer initializes a new KeyPartitionContainer and returns
@@ -45,6 +48,62 @@ func NewKeyPartitionContainer() PartitionContainer {
 
 }
 
+//NewHashPartitionContainer initializes a KeyPartitionContainer for an
+//index hashed into numPartitions partitions, of which partitions
+//partnIds are hosted by the endpoint. All partitions are hosted if
+//partnIds is empty.
+func NewHashPartitionContainer(numPartitions int, partnIds []PartitionId,
+	endpt Endpoint) PartitionContainer {
+
+	pc := NewKeyPartitionContainer()
+	if len(partnIds) == 0 {
+		for i := 0; i < numPartitions; i++ {
+			partnIds = append(partnIds, PartitionId(i))
+		}
+	}
+	for _, partnId := range partnIds {
+		pc.AddPartition(partnId, KeyPartitionDefn{Id: partnId,
+			Endpts: []Endpoint{endpt}})
+	}
+	//entries are hashed across all partitions of the index
+	pc.(*KeyPartitionContainer).NumPartitions = numPartitions
+	return pc
+}
+
+//HashKeyPartition returns the partition for a partition key, when
+//index entries are hashed into numPartitions partitions. Projector
+//and indexer shall agree on this function.
+func HashKeyPartition(key []byte, numPartitions int) PartitionId {
+	if numPartitions <= 1 {
+		return PartitionId(0)
+	}
+	hash := crc32.ChecksumIEEE(key)
+	return PartitionId(hash % uint32(numPartitions))
+}
+
+//NewRangePartitionContainer initializes a KeyPartitionContainer with
+//len(splits)+1 range partitions, of which partitions partnIds are
+//hosted by the endpoint, all if partnIds is empty. Split points are
+//expected in collated form and in ascending order.
+func NewRangePartitionContainer(splits [][]byte, partnIds []PartitionId,
+	endpt Endpoint) PartitionContainer {
+
+	pc := NewHashPartitionContainer(len(splits)+1, partnIds, endpt)
+	pc.(*KeyPartitionContainer).Splits = splits
+	return pc
+}
+
+//RangeKeyPartition returns the partition for a collated partition key,
+//when index entries are split into ranges by the collated split points.
+//Partition i holds keys in [splits[i-1], splits[i]). Projector and
+//indexer shall agree on this function.
+func RangeKeyPartition(key []byte, splits [][]byte) PartitionId {
+	i := sort.Search(len(splits), func(i int) bool {
+		return bytes.Compare(key, splits[i]) < 0
+	})
+	return PartitionId(i)
+}
+
 //AddPartition adds a partition to the container
 func (pc *KeyPartitionContainer) AddPartition(id PartitionId, p PartitionDefn) {
 	pc.PartitionMap[id] = p.(KeyPartitionDefn)
@@ -75,10 +134,11 @@ func (pc *KeyPartitionContainer) GetEndpointsByPartitionKey(key PartitionKey) []
 //GetPartitionIdByPartitionKey returns the partitionId for the partition to which the
 //partitionKey belongs.
 func (pc *KeyPartitionContainer) GetPartitionIdByPartitionKey(key PartitionKey) PartitionId {
+	if pc.Splits != nil {
+		return RangeKeyPartition([]byte(key), pc.Splits)
+	}
 	//run hash function on partition key and return partition id
-	hash := crc32.ChecksumIEEE([]byte(key))
-	partnId := int(hash) % pc.NumPartitions
-	return PartitionId(partnId)
+	return HashKeyPartition([]byte(key), pc.NumPartitions)
 }
 
 //GetEndpointsByPartitionId returns the list of Endpoints hosting the give partitionId
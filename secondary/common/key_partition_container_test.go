@@ -0,0 +1,89 @@
+package common
+
+import "fmt"
+import "testing"
+
+func TestHashKeyPartition(t *testing.T) {
+	pc := NewHashPartitionContainer(8, nil, Endpoint("localhost:9105"))
+	if pc.GetNumPartitions() != 8 {
+		t.Fatalf("expected 8 partitions, got %v", pc.GetNumPartitions())
+	}
+
+	counts := make(map[PartitionId]int)
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf(`"key-%d"`, i))
+		id := pc.GetPartitionIdByPartitionKey(PartitionKey(key))
+		if id != HashKeyPartition(key, 8) {
+			t.Fatalf("container and projector disagree on partition for %s", key)
+		}
+		if pc.GetPartitionById(id) == nil {
+			t.Fatalf("unknown partition %v for %s", id, key)
+		}
+		counts[id]++
+	}
+	if len(counts) != 8 {
+		t.Fatalf("expected keys in all partitions, got %v", counts)
+	}
+
+	if id := HashKeyPartition([]byte(`"key"`), 1); id != 0 {
+		t.Fatalf("expected partition 0 for single partition, got %v", id)
+	}
+}
+
+func TestRangeKeyPartition(t *testing.T) {
+	splits := [][]byte{[]byte("d"), []byte("m"), []byte("t")}
+	pc := NewRangePartitionContainer(splits, nil, Endpoint("localhost:9105"))
+	if pc.GetNumPartitions() != 4 {
+		t.Fatalf("expected 4 partitions, got %v", pc.GetNumPartitions())
+	}
+
+	tests := map[string]PartitionId{
+		"": 0, "a": 0, "d": 1, "k": 1, "m": 2, "s": 2, "t": 3, "z": 3,
+	}
+	for key, expected := range tests {
+		id := pc.GetPartitionIdByPartitionKey(PartitionKey(key))
+		if id != expected {
+			t.Errorf("expected partition %v for %q, got %v", expected, key, id)
+		}
+		if id != RangeKeyPartition([]byte(key), splits) {
+			t.Errorf("container and projector disagree on partition for %q", key)
+		}
+	}
+
+	if id := RangeKeyPartition([]byte("key"), nil); id != 0 {
+		t.Fatalf("expected partition 0 without splits, got %v", id)
+	}
+}
+
+func TestHostedPartitions(t *testing.T) {
+	defn := &IndexDefn{PartitionScheme: HASH, NumPartitions: 5}
+	placement := defn.PlacePartitions(2)
+	if fmt.Sprint(placement) != "[[0 2 4] [1 3]]" {
+		t.Fatalf("unexpected hash placement %v", placement)
+	}
+
+	// a node hosting some partitions hashes keys across all of them.
+	defn.Partitions = placement[1]
+	pc := NewHashPartitionContainer(defn.GetNumPartitions(),
+		defn.HostedPartitions(), Endpoint("localhost:9105"))
+	if pc.GetNumPartitions() != 5 || len(pc.GetAllPartitions()) != 2 {
+		t.Fatalf("unexpected partitions %v of %v",
+			pc.GetAllPartitions(), pc.GetNumPartitions())
+	}
+	key := PartitionKey(`"key"`)
+	if id := pc.GetPartitionIdByPartitionKey(key); id != HashKeyPartition([]byte(key), 5) {
+		t.Fatalf("container and projector disagree on partition for %s", key)
+	}
+
+	// range partitions are placed in contiguous runs.
+	defn = &IndexDefn{PartitionScheme: RANGE, PartitionSplits: []string{"1", "2", "3", "4"}}
+	if placement = defn.PlacePartitions(2); fmt.Sprint(placement) != "[[0 1 2] [3 4]]" {
+		t.Fatalf("unexpected range placement %v", placement)
+	}
+	if placement = defn.PlacePartitions(10); len(placement) != 5 {
+		t.Fatalf("expected a node per partition, got %v", placement)
+	}
+	if hosted := defn.HostedPartitions(); len(hosted) != 5 {
+		t.Fatalf("expected all partitions hosted, got %v", hosted)
+	}
+}
@@ -20,8 +20,64 @@ import (
 	"github.com/couchbase/indexing/secondary/logging"
 )
 
+// MetakvStore is the cluster wide metadata store shared by indexing
+// services. It defaults to ns_server's metakv accessed via cbauth,
+// tests can substitute an in-process store with SetMetakvStore.
+type MetakvStore interface {
+	Get(path string) ([]byte, interface{}, error)
+	Set(path string, value []byte, rev interface{}) error
+	Delete(path string, rev interface{}) error
+	RecursiveDelete(dirpath string) error
+	ListAllChildren(dirpath string) ([]metakv.KVEntry, error)
+	RunObserveChildren(dirpath string,
+		callb func(path string, value []byte, rev interface{}) error,
+		cancel <-chan struct{}) error
+}
+
+type cbauthMetakv struct{}
+
+func (cbauthMetakv) Get(path string) ([]byte, interface{}, error) {
+	return metakv.Get(path)
+}
+
+func (cbauthMetakv) Set(path string, value []byte, rev interface{}) error {
+	return metakv.Set(path, value, rev)
+}
+
+func (cbauthMetakv) Delete(path string, rev interface{}) error {
+	return metakv.Delete(path, rev)
+}
+
+func (cbauthMetakv) RecursiveDelete(dirpath string) error {
+	return metakv.RecursiveDelete(dirpath)
+}
+
+func (cbauthMetakv) ListAllChildren(dirpath string) ([]metakv.KVEntry, error) {
+	return metakv.ListAllChildren(dirpath)
+}
+
+func (cbauthMetakv) RunObserveChildren(dirpath string,
+	callb func(path string, value []byte, rev interface{}) error,
+	cancel <-chan struct{}) error {
+
+	return metakv.RunObserveChildren(dirpath, callb, cancel)
+}
+
+var metakvStore MetakvStore = cbauthMetakv{}
+
+// SetMetakvStore replaces the metadata store used by this process, it
+// should be called before any of the indexing services are started.
+func SetMetakvStore(store MetakvStore) {
+	metakvStore = store
+}
+
+// Metakv returns the metadata store used by this process.
+func Metakv() MetakvStore {
+	return metakvStore
+}
+
 func MetakvGet(path string, v interface{}) (bool, error) {
-	raw, _, err := metakv.Get(path)
+	raw, _, err := Metakv().Get(path)
 	if err != nil {
 		logging.Fatalf("MetakvGet: Failed to fetch %s from metakv: %s", path, err.Error())
 	}
@@ -48,7 +104,7 @@ func MetakvSet(path string, v interface{}) error {
 		return err
 	}
 
-	err = metakv.Set(path, raw, nil)
+	err = Metakv().Set(path, raw, nil)
 	if err != nil {
 		logging.Fatalf("MetakvSet Failed to set %s: %s", path, err.Error())
 	}
@@ -57,7 +113,7 @@ func MetakvSet(path string, v interface{}) error {
 
 func MetakvDel(path string) error {
 
-	err := metakv.Delete(path, nil)
+	err := Metakv().Delete(path, nil)
 	if err != nil {
 		logging.Fatalf("MetakvDel: Failed to delete %s: %s", path, err.Error())
 	}
@@ -66,7 +122,7 @@ func MetakvDel(path string) error {
 
 func MetakvRecurciveDel(dirpath string) error {
 
-	err := metakv.RecursiveDelete(dirpath)
+	err := Metakv().RecursiveDelete(dirpath)
 	if err != nil {
 		logging.Fatalf("MetakvRecurciveDel: Failed to delete %s: %s", dirpath, err.Error())
 	}
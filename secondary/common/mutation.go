@@ -185,6 +185,7 @@ type KeyVersions struct {
 	Keys      [][]byte // list of key-versions for each index
 	Oldkeys   [][]byte // previous key-versions, if available
 	Partnkeys [][]byte // partition key for each key-version
+	Payloads  [][]byte // include payload for each key-version
 	Ctime     int64
 }
 
@@ -212,6 +213,24 @@ func (kv *KeyVersions) addKey(uuid uint64, command byte, key, oldkey []byte) {
 	kv.Oldkeys = append(kv.Oldkeys, oldkey)
 }
 
+// AddPartnKey set partition key for the last added key-version, key
+// versions without a partition key are padded with nil.
+func (kv *KeyVersions) AddPartnKey(partnkey []byte) {
+	for len(kv.Partnkeys) < len(kv.Uuids)-1 {
+		kv.Partnkeys = append(kv.Partnkeys, nil)
+	}
+	kv.Partnkeys = append(kv.Partnkeys, partnkey)
+}
+
+// AddPayload set include payload for the last added key-version, key
+// versions without a payload are padded with nil.
+func (kv *KeyVersions) AddPayload(payload []byte) {
+	for len(kv.Payloads) < len(kv.Uuids)-1 {
+		kv.Payloads = append(kv.Payloads, nil)
+	}
+	kv.Payloads = append(kv.Payloads, payload)
+}
+
 // Equal compares for equality of two KeyVersions object.
 func (kv *KeyVersions) Equal(other *KeyVersions) bool {
 	if kv.Seqno != other.Seqno || bytes.Compare(kv.Docid, other.Docid) != 0 {
@@ -0,0 +1,52 @@
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package common
+
+import (
+	"net/http"
+
+	"github.com/couchbase/cbauth"
+)
+
+// ServiceAuth supplies credentials for talking to other services of the
+// cluster and authenticates incoming REST requests. It defaults to
+// cbauth, tests can substitute it with SetServiceAuth.
+type ServiceAuth interface {
+	// GetHTTPServiceAuth returns credentials for REST endpoint hostport.
+	GetHTTPServiceAuth(hostport string) (string, string, error)
+
+	// GetMemcachedServiceAuth returns credentials for memcached hostport.
+	GetMemcachedServiceAuth(hostport string) (string, string, error)
+
+	// AuthWebCreds authenticates an incoming REST request.
+	AuthWebCreds(r *http.Request) (cbauth.Creds, error)
+}
+
+type cbauthServiceAuth struct{}
+
+func (cbauthServiceAuth) GetHTTPServiceAuth(hostport string) (string, string, error) {
+	return cbauth.GetHTTPServiceAuth(hostport)
+}
+
+func (cbauthServiceAuth) GetMemcachedServiceAuth(hostport string) (string, string, error) {
+	return cbauth.GetMemcachedServiceAuth(hostport)
+}
+
+func (cbauthServiceAuth) AuthWebCreds(r *http.Request) (cbauth.Creds, error) {
+	return cbauth.AuthWebCreds(r)
+}
+
+var serviceAuth ServiceAuth = cbauthServiceAuth{}
+
+// SetServiceAuth replaces the authenticator used by this process, it
+// should be called before any of the indexing services are started.
+func SetServiceAuth(auth ServiceAuth) {
+	serviceAuth = auth
+}
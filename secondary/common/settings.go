@@ -13,7 +13,6 @@ import (
 	"os"
 	"time"
 
-	"github.com/couchbase/cbauth/metakv"
 	"github.com/couchbase/indexing/secondary/logging"
 )
 
@@ -27,7 +26,7 @@ const (
 
 func GetSettingsConfig(cfg Config) (Config, error) {
 	newConfig := cfg.Clone()
-	current, _, err := metakv.Get(IndexingSettingsMetaPath)
+	current, _, err := Metakv().Get(IndexingSettingsMetaPath)
 	if err == nil {
 		if len(current) > 0 {
 			newConfig.Update(current)
@@ -54,7 +53,7 @@ func SetupSettingsNotifier(callb func(Config), cancelCh chan struct{}) {
 			if r > 0 {
 				logging.Errorf("metakv notifier failed (%v)..Retrying %v", err, r)
 			}
-			err = metakv.RunObserveChildren(IndexingSettingsMetaDir, metaKvCb, cancelCh)
+			err = Metakv().RunObserveChildren(IndexingSettingsMetaDir, metaKvCb, cancelCh)
 			return err
 		}
 		rh := NewRetryHelper(MAX_METAKV_RETRIES, time.Second, 2, fn)
@@ -25,6 +25,7 @@ const (
 	PLASMA
 	FORESTDB
 	MIXED
+	LSM
 )
 
 func (s StorageMode) String() string {
@@ -37,6 +38,8 @@ func (s StorageMode) String() string {
 		return ForestDB
 	case PLASMA:
 		return PlasmaDB
+	case LSM:
+		return LSMDB
 	default:
 		return "invalid"
 	}
@@ -49,6 +52,7 @@ var smStrMap = map[string]StorageMode{
 	MemoryOptimized: MOI,
 	ForestDB:        FORESTDB,
 	PlasmaDB:        PLASMA,
+	LSMDB:           LSM,
 }
 
 //Storage Mode
@@ -132,6 +136,8 @@ func IndexTypeToStorageMode(t IndexType) StorageMode {
 		return FORESTDB
 	case PlasmaDB:
 		return PLASMA
+	case LSMDB:
+		return LSM
 	default:
 		return NOT_SET
 	}
@@ -145,6 +151,8 @@ func StorageModeToIndexType(m StorageMode) IndexType {
 		return ForestDB
 	case PLASMA:
 		return PlasmaDB
+	case LSM:
+		return LSMDB
 	default:
 		return ""
 	}
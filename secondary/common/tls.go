@@ -0,0 +1,402 @@
+//  Copyright (c) 2014 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package common
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// ErrorTLSNotConfigured for encrypted transport without certificate.
+var ErrorTLSNotConfigured = errors.New("tls.notConfigured")
+
+// ErrorTLSInvalidCA when CA file has no PEM encoded certificates.
+var ErrorTLSInvalidCA = errors.New("tls.invalidCA")
+
+// Certificate files are checked for modification at most once in this
+// interval, modified certificate is loaded for subsequent handshakes.
+const tlsReloadInterval = 10 * time.Second
+
+// TLSCertificate is a certificate and key pair loaded from files, that
+// is reloaded when files are modified or when explicitly asked to, so
+// that certificates can be rotated without restarting the process.
+type TLSCertificate struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewTLSCertificate loads certificate and key from files.
+func NewTLSCertificate(certFile, keyFile string) (*TLSCertificate, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, ErrorTLSNotConfigured
+	}
+	tc := &TLSCertificate{certFile: certFile, keyFile: keyFile}
+	if err := tc.Reload(); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// Reload certificate and key from files, on failure the previously
+// loaded certificate continues to be used.
+func (tc *TLSCertificate) Reload() error {
+	modTime := tc.fileModTime()
+	cert, err := tls.LoadX509KeyPair(tc.certFile, tc.keyFile)
+	if err != nil {
+		return err
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.cert, tc.modTime, tc.checkedAt = &cert, modTime, time.Now()
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate, loading modified
+// certificate files.
+func (tc *TLSCertificate) GetCertificate(
+	*tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+	tc.mu.RLock()
+	cert, modTime := tc.cert, tc.modTime
+	check := time.Since(tc.checkedAt) > tlsReloadInterval
+	tc.mu.RUnlock()
+
+	if check {
+		tc.mu.Lock()
+		tc.checkedAt = time.Now()
+		tc.mu.Unlock()
+
+		if mt := tc.fileModTime(); mt.After(modTime) {
+			if err := tc.Reload(); err != nil {
+				logging.Errorf("TLSCertificate: reloading %v: %v\n", tc.certFile, err)
+			} else {
+				logging.Infof("TLSCertificate: reloaded %v\n", tc.certFile)
+				tc.mu.RLock()
+				cert = tc.cert
+				tc.mu.RUnlock()
+			}
+		}
+	}
+	return cert, nil
+}
+
+// latest modification time of certificate and key files.
+func (tc *TLSCertificate) fileModTime() time.Time {
+	var modTime time.Time
+	for _, file := range []string{tc.certFile, tc.keyFile} {
+		if fi, err := os.Stat(file); err == nil && fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+	return modTime
+}
+
+// ServerTLSConfig returns configuration for servers presenting
+// certificate `tc`.
+func ServerTLSConfig(tc *TLSCertificate) *tls.Config {
+	return &tls.Config{
+		GetCertificate:           tc.GetCertificate,
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: true,
+	}
+}
+
+// ClientTLSConfig returns configuration for clients verifying servers
+// with certificates in `caFile`, system roots are used if caFile is
+// empty.
+func ClientTLSConfig(caFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return config, nil
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, ErrorTLSInvalidCA
+	}
+	return config, nil
+}
+
+//---------------------------------
+// encryption in transit, per process
+//---------------------------------
+
+// Transport security of a process, listeners and dialers for queryport,
+// dataport and adminport use it. Set up once during process startup,
+// before the listeners and dialers are created.
+var gTLS struct {
+	sync.RWMutex
+	cert      *TLSCertificate
+	server    *tls.Config
+	client    *tls.Config
+	transport *http.Transport // for https connections
+	plaintext bool            // TLS listeners also accept plaintext connections
+}
+
+// SetupTransportTLS enables TLS for listeners and dialers of this
+// process, using the certificate and key files to serve and the CA file
+// to verify servers. If `encrypt` is false, transport is plaintext.
+// Listeners accept only TLS connections, unless plaintext is allowed
+// with AllowTransportPlaintext.
+func SetupTransportTLS(encrypt bool, certFile, keyFile, caFile string) error {
+	gTLS.Lock()
+	defer gTLS.Unlock()
+
+	if !encrypt {
+		gTLS.cert, gTLS.server, gTLS.client = nil, nil, nil
+		gTLS.transport = nil
+		return nil
+	}
+
+	cert, err := NewTLSCertificate(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	if caFile == "" { // typically a self-signed cluster certificate
+		caFile = certFile
+	}
+	client, err := ClientTLSConfig(caFile)
+	if err != nil {
+		return err
+	}
+	gTLS.cert, gTLS.server, gTLS.client = cert, ServerTLSConfig(cert), client
+	gTLS.transport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: client,
+	}
+	return nil
+}
+
+// SetupTransportTLSFromConfig same as SetupTransportTLS, for a component
+// configuration with "encryptTransport", "allowPlaintext", "certFile",
+// "keyFile" and "caFile" parameters.
+func SetupTransportTLSFromConfig(config Config) error {
+	err := SetupTransportTLS(
+		config["encryptTransport"].Bool(), config["certFile"].String(),
+		config["keyFile"].String(), config["caFile"].String())
+	if err != nil {
+		return err
+	}
+	if cv, ok := config["allowPlaintext"]; ok {
+		AllowTransportPlaintext(cv.Bool())
+	}
+	return nil
+}
+
+// AllowTransportPlaintext makes TLS listeners of this process accept
+// plaintext connections as well, so that peers that don't encrypt yet,
+// like older nodes and clients during an upgrade, can still connect.
+func AllowTransportPlaintext(allow bool) {
+	gTLS.Lock()
+	defer gTLS.Unlock()
+	gTLS.plaintext = allow
+}
+
+// IsTransportEncrypted returns true if TLS is set up for this process.
+func IsTransportEncrypted() bool {
+	gTLS.RLock()
+	defer gTLS.RUnlock()
+	return gTLS.server != nil
+}
+
+// ReloadTransportCertificate reloads certificate files of this process,
+// new connections are served with the reloaded certificate.
+func ReloadTransportCertificate() error {
+	gTLS.RLock()
+	cert := gTLS.cert
+	gTLS.RUnlock()
+
+	if cert == nil {
+		return nil
+	}
+	return cert.Reload()
+}
+
+// TransportListen announces on `laddr`, connections accepted from the
+// listener are TLS if set up for this process.
+func TransportListen(laddr string) (net.Listener, error) {
+	lis, err := net.Listen("tcp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	return TransportListener(lis), nil
+}
+
+// TransportListener wraps `lis` with TLS if set up for this process,
+// if plaintext is allowed connections are TLS only when the peer starts
+// with a TLS handshake.
+func TransportListener(lis net.Listener) net.Listener {
+	gTLS.RLock()
+	defer gTLS.RUnlock()
+
+	if gTLS.server == nil {
+		return lis
+	} else if gTLS.plaintext {
+		return &mixedListener{Listener: lis, config: gTLS.server}
+	}
+	return tls.NewListener(lis, gTLS.server)
+}
+
+// first byte of a TLS handshake record.
+const tlsRecordHandshake = 0x16
+
+// mixedListener accepts both TLS and plaintext connections.
+type mixedListener struct {
+	net.Listener
+	config *tls.Config
+}
+
+func (ml *mixedListener) Accept() (net.Conn, error) {
+	conn, err := ml.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &mixedConn{Conn: conn, config: ml.config}, nil
+}
+
+// mixedConn decides between TLS and plaintext on first read or write,
+// by peeking the first byte sent by the client. Deadlines and Close
+// apply to the underlying connection.
+type mixedConn struct {
+	net.Conn
+	config *tls.Config
+
+	once sync.Once
+	conn net.Conn
+	err  error
+}
+
+func (mc *mixedConn) detect() {
+	r := bufio.NewReader(mc.Conn)
+	b, err := r.Peek(1)
+	if err != nil {
+		mc.err = err
+		return
+	}
+	peeked := &peekedConn{Conn: mc.Conn, r: r}
+	if b[0] == tlsRecordHandshake {
+		mc.conn = tls.Server(peeked, mc.config)
+	} else {
+		logging.Warnf("TransportListener: accepted plaintext connection "+
+			"from %v on TLS listener\n", mc.RemoteAddr())
+		mc.conn = peeked
+	}
+}
+
+func (mc *mixedConn) Read(b []byte) (int, error) {
+	if mc.once.Do(mc.detect); mc.err != nil {
+		return 0, mc.err
+	}
+	return mc.conn.Read(b)
+}
+
+func (mc *mixedConn) Write(b []byte) (int, error) {
+	if mc.once.Do(mc.detect); mc.err != nil {
+		return 0, mc.err
+	}
+	return mc.conn.Write(b)
+}
+
+// peekedConn reads through the buffer that peeked the connection.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (pc *peekedConn) Read(b []byte) (int, error) {
+	return pc.r.Read(b)
+}
+
+// TransportDial connects to `raddr`, using TLS if set up for this
+// process.
+func TransportDial(raddr string) (net.Conn, error) {
+	return TransportDialTimeout(raddr, 0)
+}
+
+// TransportDialTimeout is same as TransportDial with a timeout, zero
+// timeout means no timeout.
+func TransportDialTimeout(raddr string, timeout time.Duration) (net.Conn, error) {
+	config := TransportClientTLSConfig(raddr)
+	if config == nil {
+		return net.DialTimeout("tcp", raddr, timeout)
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	return tls.DialWithDialer(dialer, "tcp", raddr, config)
+}
+
+// TransportClientTLSConfig returns TLS configuration to connect with
+// `raddr`, nil if TLS is not set up for this process.
+func TransportClientTLSConfig(raddr string) *tls.Config {
+	gTLS.RLock()
+	defer gTLS.RUnlock()
+
+	if gTLS.client == nil {
+		return nil
+	}
+	return TLSConfigForAddr(gTLS.client, raddr)
+}
+
+// TLSConfigForAddr returns a copy of client configuration `config` that
+// verifies the host name of `raddr`.
+func TLSConfigForAddr(config *tls.Config, raddr string) *tls.Config {
+	host, _, err := net.SplitHostPort(raddr)
+	if err != nil {
+		host = raddr
+	}
+	return &tls.Config{
+		RootCAs:      config.RootCAs,
+		Certificates: config.Certificates,
+		MinVersion:   config.MinVersion,
+		ServerName:   host,
+	}
+}
+
+// TransportHTTPURL returns `url` with https scheme if TLS is set up for
+// this process, with http scheme otherwise.
+func TransportHTTPURL(url string) string {
+	url = strings.TrimPrefix(url, "http://")
+	url = strings.TrimPrefix(url, "https://")
+	if IsTransportEncrypted() {
+		return "https://" + url
+	}
+	return "http://" + url
+}
+
+// TransportHTTPClient returns a client for urls from TransportHTTPURL,
+// zero `timeout` means no timeout.
+func TransportHTTPClient(timeout time.Duration) *http.Client {
+	gTLS.RLock()
+	defer gTLS.RUnlock()
+
+	if gTLS.transport == nil {
+		return &http.Client{Timeout: timeout}
+	}
+	return &http.Client{Timeout: timeout, Transport: gTLS.transport}
+}
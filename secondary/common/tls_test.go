@@ -0,0 +1,228 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTransportTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, 1)
+
+	if err := SetupTransportTLS(true, certFile, keyFile, ""); err != nil {
+		t.Fatal(err)
+	}
+	defer SetupTransportTLS(false, "", "", "")
+
+	lis, err := TransportListen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("ok"))
+			conn.Close()
+		}
+	}()
+
+	serial := func() int64 {
+		conn, err := TransportDial(lis.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		buf := make([]byte, 2)
+		if _, err := conn.Read(buf); err != nil || string(buf) != "ok" {
+			t.Fatalf("unexpected read %q %v", buf, err)
+		}
+		state := conn.(*tls.Conn).ConnectionState()
+		return state.PeerCertificates[0].SerialNumber.Int64()
+	}
+	if s := serial(); s != 1 {
+		t.Fatalf("expected serial 1, received %v", s)
+	}
+
+	// rotate certificate, new connections must present it.
+	writeTestCertificate(t, certFile, keyFile, 2)
+	if err := ReloadTransportCertificate(); err != nil {
+		t.Fatal(err)
+	}
+	SetupTransportTLS(true, certFile, keyFile, "") // trust the new CA
+	if s := serial(); s != 2 {
+		t.Fatalf("expected serial 2, received %v", s)
+	}
+}
+
+func TestTransportPlaintext(t *testing.T) {
+	if err := SetupTransportTLS(false, "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if IsTransportEncrypted() {
+		t.Fatalf("expected plaintext transport")
+	}
+	lis, err := TransportListen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	if _, ok := lis.(*net.TCPListener); !ok {
+		t.Fatalf("expected tcp listener, received %T", lis)
+	}
+	if err := SetupTransportTLS(true, "", "", ""); err != ErrorTLSNotConfigured {
+		t.Fatalf("expected %v, received %v", ErrorTLSNotConfigured, err)
+	}
+}
+
+func TestTransportMixed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, 1)
+
+	if err := SetupTransportTLS(true, certFile, keyFile, ""); err != nil {
+		t.Fatal(err)
+	}
+	AllowTransportPlaintext(true)
+	defer AllowTransportPlaintext(false)
+	defer SetupTransportTLS(false, "", "", "")
+
+	lis, err := TransportListen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 4)
+				if n, err := conn.Read(buf); err == nil {
+					conn.Write(buf[:n])
+				}
+			}()
+		}
+	}()
+
+	echo := func(conn net.Conn) {
+		defer conn.Close()
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4)
+		if _, err := conn.Read(buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("unexpected read %q %v", buf, err)
+		}
+	}
+
+	// both TLS and plaintext clients are served on the same listener.
+	conn, err := TransportDial(lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	} else if _, ok := conn.(*tls.Conn); !ok {
+		t.Fatalf("expected tls connection, received %T", conn)
+	}
+	echo(conn)
+	if conn, err = net.Dial("tcp", lis.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	echo(conn)
+}
+
+func TestTransportPlaintextOptIn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, 1)
+
+	// listeners accept only TLS connections unless plaintext is allowed.
+	for _, prefix := range []string{"indexer.", "projector."} {
+		config := SystemConfig.SectionConfig(prefix, true)
+		config.SetValue("encryptTransport", true)
+		config.SetValue("certFile", certFile)
+		config.SetValue("keyFile", keyFile)
+		if err := SetupTransportTLSFromConfig(config); err != nil {
+			t.Fatal(err)
+		}
+		lis, err := TransportListen("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lis.Close()
+		if _, ok := lis.(*mixedListener); ok {
+			t.Fatalf("%v: expected TLS only listener by default", prefix)
+		}
+	}
+	SetupTransportTLS(false, "", "", "")
+}
+
+func writeTestCertificate(t *testing.T, certFile, keyFile string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(certFile, certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -233,7 +233,7 @@ func (ah *CbAuthHandler) GetCredentials() (string, string) {
 			logging.Warnf("CbAuthHandler::GetCredentials error=%v Retrying (%d)", err, r)
 		}
 
-		u, p, err = cbauth.GetHTTPServiceAuth(ah.Hostport)
+		u, p, err = serviceAuth.GetHTTPServiceAuth(ah.Hostport)
 		return err
 	}
 
@@ -255,7 +255,7 @@ func (ah *CbAuthHandler) AuthenticateMemcachedConn(host string, conn *memcached.
 			logging.Warnf("CbAuthHandler::AuthenticateMemcachedConn error=%v Retrying (%d)", err, r)
 		}
 
-		u, p, err = cbauth.GetMemcachedServiceAuth(host)
+		u, p, err = serviceAuth.GetMemcachedServiceAuth(host)
 		return err
 	}
 
@@ -413,7 +413,7 @@ func ClusterAuthUrl(cluster string) (string, error) {
 		cluster = u.Host
 	}
 
-	adminUser, adminPasswd, err := cbauth.GetHTTPServiceAuth(cluster)
+	adminUser, adminPasswd, err := serviceAuth.GetHTTPServiceAuth(cluster)
 	if err != nil {
 		return "", err
 	}
@@ -576,7 +576,7 @@ func BucketTs(bucket *couchbase.Bucket, maxvb int) (seqnos, vbuuids []uint64, er
 
 func IsAuthValid(r *http.Request) (cbauth.Creds, bool, error) {
 
-	creds, err := cbauth.AuthWebCreds(r)
+	creds, err := serviceAuth.AuthWebCreds(r)
 	if err != nil {
 		if strings.Contains(err.Error(), cbauthimpl.ErrNoAuth.Error()) {
 			return nil, false, nil
@@ -658,6 +658,22 @@ func IndexStatement(def IndexDefn, printNodes bool) string {
 		withExpr += fmt.Sprintf(" \"num_replica\":%v", def.NumReplica)
 	}
 
+	if len(def.Include) != 0 {
+		if len(withExpr) != 0 {
+			withExpr += ","
+		}
+		withExpr += " \"include\":[ "
+
+		for i, expr := range def.Include {
+			withExpr += strconv.Quote(expr)
+			if i < len(def.Include)-1 {
+				withExpr += ","
+			}
+		}
+
+		withExpr += " ]"
+	}
+
 	if len(withExpr) != 0 {
 		stmt += fmt.Sprintf(" WITH { %s }", withExpr)
 	}
@@ -101,7 +101,7 @@ func NewClient(
 	c.logPrefix = fmt.Sprintf("ENDC[%v<-%v #%v]", raddr, cluster, topic)
 	// open connections with remote
 	for i := 0; i < parConns; i++ {
-		if conn, err = net.Dial("tcp", raddr); err != nil {
+		if conn, err = common.TransportDial(raddr); err != nil {
 			logging.Errorf("%v Dialing to %q: %v\n", c.logPrefix, raddr, err)
 			c.doClose()
 			return nil, err
@@ -61,6 +61,7 @@ type RouterEndpoint struct {
 	snapCount   int64
 	flushCount  int64
 	prjLatency  *Average
+	compStats   transport.CompressionStats
 }
 
 // NewRouterEndpoint instantiate a new RouterEndpoint
@@ -69,7 +70,7 @@ func NewRouterEndpoint(
 	cluster, topic, raddr string, maxvbs int,
 	config c.Config) (*RouterEndpoint, error) {
 
-	conn, err := net.Dial("tcp", raddr)
+	conn, err := c.TransportDial(raddr)
 	if err != nil {
 		return nil, err
 	}
@@ -95,6 +96,8 @@ func NewRouterEndpoint(
 	endpoint.pkt = transport.NewTransportPacket(maxPayload, flags)
 	endpoint.pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
 	endpoint.pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+	endpoint.pkt.SetStats(&endpoint.compStats, nil)
+	endpoint.setCompression(config["compression"].String())
 
 	endpoint.statTick *= time.Millisecond
 	endpoint.bufferTm *= time.Millisecond
@@ -104,11 +107,35 @@ func NewRouterEndpoint(
 		"ENDP[<-(%v,%4x)<-%v #%v]",
 		endpoint.raddr, uint16(endpoint.timestamp), cluster, topic)
 
+	go endpoint.negotiate()
 	go endpoint.run(endpoint.ch)
 	logging.Infof("%v started ...\n", endpoint.logPrefix)
 	return endpoint, nil
 }
 
+// negotiate compression with the downstream node, until then mutations
+// are sent uncompressed. Older nodes never advertise, the routine exits
+// when connection is closed.
+func (endpoint *RouterEndpoint) negotiate() {
+	flags, err := transport.ReceiveAccept(endpoint.conn)
+	if err != nil {
+		logging.Debugf("%v negotiate: %v\n", endpoint.logPrefix, err)
+		return
+	}
+	endpoint.pkt.SetPeer(flags)
+	logging.Infof("%v downstream accepts compression %x\n",
+		endpoint.logPrefix, flags.GetAccept())
+}
+
+func (endpoint *RouterEndpoint) setCompression(name string) {
+	compression, err := transport.ParseCompression(name)
+	if err != nil {
+		fmsg := "%v compression %q: %v, sending uncompressed\n"
+		logging.Errorf(fmsg, endpoint.logPrefix, name, err)
+	}
+	endpoint.pkt.SetCompression(compression)
+}
+
 // commands
 const (
 	endpCmdPing byte = iota + 1
@@ -192,7 +219,7 @@ func (endpoint *RouterEndpoint) run(ch chan []interface{}) {
 	}()
 
 	statSince := time.Now()
-	var stitems [14]string
+	var stitems [16]string
 	logstats := func() {
 		prjLatency := endpoint.prjLatency
 		stitems[0] = `"topic":"` + endpoint.topic + `"`
@@ -209,6 +236,10 @@ func (endpoint *RouterEndpoint) run(ch chan []interface{}) {
 		stitems[11] = `"latency.min":` + strconv.Itoa(int(prjLatency.Min()))
 		stitems[12] = `"latency.max":` + strconv.Itoa(int(prjLatency.Max()))
 		stitems[13] = `"latency.avg":` + strconv.Itoa(int(prjLatency.Mean()))
+		rawBytes := endpoint.compStats.RawBytes()
+		compressedBytes := endpoint.compStats.CompressedBytes()
+		stitems[14] = `"rawBytes":` + strconv.FormatInt(rawBytes, 10)
+		stitems[15] = `"compressedBytes":` + strconv.FormatInt(compressedBytes, 10)
 		statjson := strings.Join(stitems[:], ",")
 		fmsg := "%v stats {%v}\n"
 		logging.Infof(fmsg, endpoint.logPrefix, statjson)
@@ -296,6 +327,9 @@ loop:
 						logging.Infof(fmsg, prefix, endpoint.harakiriTm)
 					}
 				}
+				if cv, ok := config["compression"]; ok {
+					endpoint.setCompression(cv.String())
+				}
 				respch := msg[2].(chan []interface{})
 				respch <- []interface{}{nil}
 
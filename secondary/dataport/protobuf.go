@@ -53,6 +53,14 @@ func protobufEncode(payload interface{}) (data []byte, err error) {
 					pkv.Keys = append(pkv.Keys, kv.Keys[i])
 					pkv.Oldkeys = append(pkv.Oldkeys, kv.Oldkeys[i])
 				}
+				if len(kv.Partnkeys) > 0 {
+					pkv.Partnkeys = make([][]byte, l)
+					copy(pkv.Partnkeys, kv.Partnkeys)
+				}
+				if len(kv.Payloads) > 0 {
+					pkv.Payloads = make([][]byte, l)
+					copy(pkv.Payloads, kv.Payloads)
+				}
 				pvb.Kvs = append(pvb.Kvs, pkv)
 			}
 			pl.Vbkeys = append(pl.Vbkeys, pvb)
@@ -124,6 +132,12 @@ func protobuf2KeyVersions(keys []*protobuf.KeyVersions) []*c.KeyVersions {
 			kv.Keys = append(kv.Keys, newkeys[i])
 			kv.Oldkeys = append(kv.Oldkeys, oldkeys[i])
 		}
+		if partnkeys := key.GetPartnkeys(); len(partnkeys) > 0 {
+			kv.Partnkeys = partnkeys
+		}
+		if payloads := key.GetPayloads(); len(payloads) > 0 {
+			kv.Payloads = payloads
+		}
 		kvs = append(kvs, kv)
 	}
 	return kvs
@@ -167,7 +167,7 @@ func NewServer(
 		readDeadline: time.Duration(config["tcpReadDeadline"].Int()),
 	}
 	s.logPrefix = fmt.Sprintf("DATP[->dataport %q]", laddr)
-	if s.lis, err = net.Listen("tcp", laddr); err != nil {
+	if s.lis, err = c.TransportListen(laddr); err != nil {
 		logging.Errorf("%v failed starting ! %v\n", s.logPrefix, err)
 		return nil, err
 	}
@@ -299,6 +299,11 @@ func (s *Server) genServer(reqch, datach chan []interface{}) {
 				conn.Close()
 
 			} else { // connection accepted
+				// advertise compressions accepted by this end.
+				if err := transport.SendAccept(conn); err != nil {
+					fmsg := "%v %q advertising compression: %v\n"
+					logging.Warnf(fmsg, s.logPrefix, raddr, err)
+				}
 				worker := make(chan interface{}, s.maxVbuckets)
 				s.conns[raddr] = &netConn{
 					conn: conn, worker: worker,
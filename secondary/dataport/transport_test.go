@@ -61,6 +61,93 @@ func TestPktVbmap(t *testing.T) {
 	}
 }
 
+func TestPktCompression(t *testing.T) {
+	seqno, nVbs, nMuts, nIndexes := 1, 20, 5, 5
+	vbsRef := constructVbKeyVersions("default", seqno, nVbs, nMuts, nIndexes)
+	for _, name := range []string{"snappy", "gzip"} {
+		compression, err := transport.ParseCompression(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc := newTestConnection()
+		tc.reset()
+		flags := transport.TransportFlag(0).SetProtobuf()
+		pkt := transport.NewTransportPacket(1000*1024, flags)
+		pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
+		pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+		pkt.SetCompression(compression)
+		stats := &transport.CompressionStats{}
+		pkt.SetStats(stats, nil)
+
+		// not compressed until the other end accepts the compression.
+		if err := pkt.Send(tc, vbsRef); err != nil {
+			t.Fatal(err)
+		}
+		if stats.RawBytes() != stats.CompressedBytes() {
+			t.Fatalf("%v: unexpected compression before negotiation", name)
+		}
+		if _, err := pkt.Receive(tc); err != nil {
+			t.Fatal(err)
+		}
+		if !pkt.Peer().Accepts(compression) {
+			t.Fatalf("%v: expected peer to accept compression", name)
+		}
+
+		tc.reset()
+		*stats = transport.CompressionStats{}
+		if err := pkt.Send(tc, vbsRef); err != nil {
+			t.Fatal(err)
+		}
+		if stats.CompressedBytes() >= stats.RawBytes() {
+			t.Fatalf("%v: expected compressed payload, %v >= %v",
+				name, stats.CompressedBytes(), stats.RawBytes())
+		}
+		payload, err := pkt.Receive(tc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vbs := protobuf2VbKeyVersions(payload.([]*protobuf.VbKeyVersions))
+		if len(vbsRef) != len(vbs) {
+			t.Fatalf("%v: mismatch in length", name)
+		}
+		for i, vb := range vbs {
+			if vb.Equal(vbsRef[i]) == false {
+				t.Fatalf("%v: mismatch in VbKeyVersions", name)
+			}
+		}
+	}
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	if _, err := transport.ParseCompression("bzip2"); err != transport.ErrorCompressionUnsupported {
+		t.Fatalf("expected %v, got %v", transport.ErrorCompressionUnsupported, err)
+	}
+	if _, err := transport.ParseCompression("lz4"); err != transport.ErrorCompressionUnknown {
+		t.Fatalf("expected %v, got %v", transport.ErrorCompressionUnknown, err)
+	}
+
+	tc := newTestConnection()
+	tc.reset()
+	if err := transport.SendAccept(tc); err != nil {
+		t.Fatal(err)
+	}
+	flags, err := transport.ReceiveAccept(tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, compression := range []byte{
+		transport.CompressionNone, transport.CompressionSnappy,
+		transport.CompressionGzip, transport.CompressionBzip2} {
+		if !flags.Accepts(compression) {
+			t.Fatalf("expected compression %v to be accepted", compression)
+		}
+	}
+	// older peers do not advertise.
+	if transport.TransportFlag(0).Accepts(transport.CompressionSnappy) {
+		t.Fatalf("unexpected compression accepted by older peer")
+	}
+}
+
 func BenchmarkSendVbKeyVersions(b *testing.B) {
 	seqno, nVbs, nMuts, nIndexes := 1, 20, 5, 5
 	vbs := constructVbKeyVersions("default", seqno, nVbs, nMuts, nIndexes)
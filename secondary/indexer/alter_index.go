@@ -0,0 +1,353 @@
+// @copyright 2016 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/fdb"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// Online alter of index keys builds a shadow instance of the index with
+// the new definition, using a higher instance version. The shadow is
+// built in INIT_STREAM like any other deferred build, while the old
+// instance keeps serving scans. The shadow is kept in REBAL_PENDING and
+// hidden from the index metadata till it merges into MAINT_STREAM.
+// Once merged, scans are switched over to the shadow, the metadata is
+// updated to point to the shadow and the old instance is dropped.
+//
+// Replicas of the index are swapped in independently. Scan clients
+// send the instance version they expect and the scan is rejected by
+// an indexer serving another version of the instance.
+//
+// The files of a shadow interrupted by indexer restart are removed
+// during bootstrap, the old instance keeps serving. Lifecycle manager
+// resumes the alter once indexer is ready, building a new shadow.
+
+// local metadata key for instances whose files need to be removed
+// if they are not found in the metadata on bootstrap.
+const ALTER_INDEX_CLEANUP_KEY = "AlterIndexCleanup"
+
+func (idx *indexer) handleAlterIndex(msg Message) {
+
+	shadow := msg.(*MsgAlterIndex).GetIndexInst()
+	oldInstId := msg.(*MsgAlterIndex).GetOldInstId()
+	clientCh := msg.(*MsgAlterIndex).GetResponseChannel()
+	reqCtx := msg.(*MsgAlterIndex).GetRequestCtx()
+
+	logging.Infof("Indexer::handleAlterIndex OldInstId %v Shadow %v", oldInstId, shadow)
+
+	sendError := func(code errCode, cause error) {
+		logging.Errorf("Indexer::handleAlterIndex OldInstId %v Error %v", oldInstId, cause)
+		if clientCh != nil {
+			clientCh <- &MsgError{
+				err: Error{code: code,
+					severity: FATAL,
+					cause:    cause,
+					category: INDEXER}}
+		}
+	}
+
+	if is := idx.getIndexerState(); is != common.INDEXER_ACTIVE {
+		sendError(ERROR_INDEXER_NOT_ACTIVE,
+			fmt.Errorf("Indexer Cannot Process Alter Index In %v State", is))
+		return
+	}
+
+	if idx.rebalanceRunning || idx.rebalanceToken != nil {
+		sendError(ERROR_INDEXER_REBALANCE_IN_PROGRESS,
+			errors.New("Indexer Cannot Process Alter Index - Rebalance In Progress"))
+		return
+	}
+
+	oldInst, ok := idx.indexInstMap[oldInstId]
+	if !ok || oldInst.State == common.INDEX_STATE_DELETED {
+		sendError(ERROR_INDEXER_UNKNOWN_INDEX, common.ErrIndexNotFound)
+		return
+	}
+
+	if oldInst.State != common.INDEX_STATE_ACTIVE || oldInst.RState != common.REBAL_ACTIVE {
+		sendError(ERROR_INDEXER_INTERNAL_ERROR,
+			fmt.Errorf("Index must be active to be altered. State %v", oldInst.State))
+		return
+	}
+
+	for _, instId := range idx.shadowInsts {
+		if instId == oldInstId {
+			sendError(ERROR_INDEX_BUILD_IN_PROGRESS,
+				errors.New("Alter Index Already In Progress"))
+			return
+		}
+	}
+
+	if _, ok := idx.indexInstMap[shadow.InstId]; ok {
+		sendError(ERROR_INDEX_ALREADY_EXISTS, errors.New("Duplicate Index Instance"))
+		return
+	}
+
+	bucket := shadow.Defn.Bucket
+	initState := idx.getStreamBucketState(common.INIT_STREAM, bucket)
+	maintState := idx.getStreamBucketState(common.MAINT_STREAM, bucket)
+
+	if initState == STREAM_RECOVERY ||
+		initState == STREAM_PREPARE_RECOVERY ||
+		maintState == STREAM_RECOVERY ||
+		maintState == STREAM_PREPARE_RECOVERY {
+		sendError(ERROR_INDEXER_IN_RECOVERY, ErrIndexerInRecovery)
+		return
+	}
+
+	idx.stats.AddIndex(shadow.InstId, bucket, shadow.Defn.Name, shadow.ReplicaId)
+
+	partnInstMap, err := idx.initPartnInstance(shadow, clientCh)
+	if err != nil {
+		return
+	}
+
+	idx.indexInstMap[shadow.InstId] = shadow
+	idx.indexPartnMap[shadow.InstId] = partnInstMap
+	idx.shadowInsts[shadow.InstId] = oldInstId
+	idx.recordAlterCleanup(shadow)
+
+	msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
+	msgUpdateIndexPartnMap := &MsgUpdatePartnMap{indexPartnMap: idx.indexPartnMap}
+
+	if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, msgUpdateIndexPartnMap); err != nil {
+		sendError(ERROR_INDEXER_INTERNAL_ERROR, err)
+		common.CrashOnError(err)
+	}
+
+	//build the shadow instance, it catches up in INIT_STREAM
+	//as the bucket is already in MAINT_STREAM for the old instance
+	respCh := make(MsgChannel, 2)
+	idx.handleBuildIndex(&MsgBuildIndex{
+		indexInstList: []common.IndexInstId{shadow.InstId},
+		bucketList:    []string{bucket},
+		respCh:        respCh,
+		reqCtx:        reqCtx})
+
+	resp := <-respCh
+	if resp.GetMsgType() == CLUST_MGR_BUILD_INDEX_DDL_RESPONSE {
+		if err := resp.(*MsgBuildIndexResponse).GetErrorMap()[shadow.InstId]; err != nil {
+			logging.Errorf("Indexer::handleAlterIndex Build Failed for Shadow %v. Error %v",
+				shadow.InstId, err)
+			idx.dropShadowInst(shadow.InstId)
+			if clientCh != nil {
+				clientCh <- &MsgError{
+					err: Error{code: ERROR_INDEXER_INTERNAL_ERROR,
+						severity: FATAL,
+						cause:    err,
+						category: INDEXER}}
+			}
+			return
+		}
+	} else if resp.GetMsgType() == MSG_ERROR {
+		idx.dropShadowInst(shadow.InstId)
+		if clientCh != nil {
+			clientCh <- resp
+		}
+		return
+	}
+
+	if clientCh != nil {
+		clientCh <- &MsgSuccess{}
+	}
+}
+
+//isShadowInst returns true if the instance is being built for
+//an alter and has not been swapped in yet
+func (idx *indexer) isShadowInst(instId common.IndexInstId) bool {
+	_, ok := idx.shadowInsts[instId]
+	return ok
+}
+
+//swapAlteredIndexes swaps in the shadow instances which have been
+//merged to MAINT_STREAM and drops the instances they replace.
+func (idx *indexer) swapAlteredIndexes(mergeList []common.IndexInst) {
+
+	for _, inst := range mergeList {
+
+		oldInstId, ok := idx.shadowInsts[inst.InstId]
+		if !ok {
+			continue
+		}
+
+		shadow, ok := idx.indexInstMap[inst.InstId]
+		if !ok || shadow.State != common.INDEX_STATE_ACTIVE {
+			continue
+		}
+
+		logging.Infof("Indexer::swapAlteredIndexes Swap Index %v OldInstId %v NewInstId %v",
+			shadow.Defn.Name, oldInstId, shadow.InstId)
+
+		delete(idx.shadowInsts, shadow.InstId)
+
+		//switch scans over to the shadow instance
+		shadow.RState = common.REBAL_ACTIVE
+		idx.indexInstMap[shadow.InstId] = shadow
+
+		msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
+		if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, nil); err != nil {
+			common.CrashOnError(err)
+		}
+
+		//metadata points to the shadow instance from here on
+		defn := shadow.Defn
+		defn.InstId = shadow.InstId
+		defn.InstVersion = shadow.Version
+		if err := idx.sendMsgToClusterMgr(&MsgClustMgrSwapAlterIndex{defn: defn}); err != nil {
+			common.CrashOnError(err)
+		}
+
+		if oldInst, ok := idx.indexInstMap[oldInstId]; ok {
+			idx.recordAlterCleanup(oldInst)
+			idx.dropAlteredInst(oldInstId, oldInst.Defn.Bucket)
+		}
+	}
+}
+
+//dropShadowInst drops the shadow instance of an alter which
+//has failed or whose old instance is being dropped.
+func (idx *indexer) dropShadowInst(shadowInstId common.IndexInstId) {
+
+	oldInstId, ok := idx.shadowInsts[shadowInstId]
+	if !ok {
+		return
+	}
+
+	logging.Infof("Indexer::dropShadowInst Drop Shadow %v OldInstId %v",
+		shadowInstId, oldInstId)
+
+	delete(idx.shadowInsts, shadowInstId)
+
+	if shadow, ok := idx.indexInstMap[shadowInstId]; ok {
+		idx.dropAlteredInst(shadowInstId, shadow.Defn.Bucket)
+	}
+}
+
+//dropShadowForInst drops the shadow instance being built
+//for an alter of the given instance, if any.
+func (idx *indexer) dropShadowForInst(instId common.IndexInstId) {
+
+	for shadowInstId, oldInstId := range idx.shadowInsts {
+		if oldInstId == instId {
+			idx.dropShadowInst(shadowInstId)
+			return
+		}
+	}
+}
+
+func (idx *indexer) dropAlteredInst(instId common.IndexInstId, bucket string) {
+
+	//drop may respond more than once on error, the response is
+	//only logged as there is no client waiting on it
+	respCh := make(MsgChannel, 2)
+	idx.handleDropIndex(&MsgDropIndex{mType: CLUST_MGR_DROP_INDEX_DDL,
+		indexInstId: instId,
+		bucket:      bucket,
+		respCh:      respCh})
+
+	go func() {
+		if resp := <-respCh; resp.GetMsgType() != MSG_SUCCESS {
+			logging.Errorf("Indexer::dropAlteredInst Error Dropping Instance %v. %v",
+				instId, resp)
+		}
+	}()
+}
+
+//recordAlterCleanup persists the instance in local metadata, so that
+//its files are removed on bootstrap if it is not found in the metadata.
+func (idx *indexer) recordAlterCleanup(inst common.IndexInst) {
+
+	idx.alterCleanupInsts = append(idx.alterCleanupInsts, inst)
+
+	val, err := json.Marshal(idx.alterCleanupInsts)
+	if err != nil {
+		logging.Errorf("Indexer::recordAlterCleanup Error Marshalling %v", err)
+		return
+	}
+
+	idx.clustMgrAgentCmdCh <- &MsgClustMgrLocal{
+		mType: CLUST_MGR_SET_LOCAL,
+		key:   ALTER_INDEX_CLEANUP_KEY,
+		value: string(val),
+	}
+
+	respMsg := <-idx.clustMgrAgentCmdCh
+	if err := respMsg.(*MsgClustMgrLocal).GetError(); err != nil {
+		logging.Errorf("Indexer::recordAlterCleanup Error Storing In Local "+
+			"Meta Storage. Err %v", err)
+	}
+}
+
+//cleanupAbandonedAlters removes the files of shadow instances of
+//alters interrupted by restart, and of altered instances whose drop
+//did not complete.
+func (idx *indexer) cleanupAbandonedAlters() {
+
+	idx.clustMgrAgentCmdCh <- &MsgClustMgrLocal{
+		mType: CLUST_MGR_GET_LOCAL,
+		key:   ALTER_INDEX_CLEANUP_KEY,
+	}
+
+	respMsg := <-idx.clustMgrAgentCmdCh
+	resp := respMsg.(*MsgClustMgrLocal)
+
+	val := resp.GetValue()
+	err := resp.GetError()
+
+	if err != nil {
+		if !strings.Contains(err.Error(), forestdb.FDB_RESULT_KEY_NOT_FOUND.Error()) {
+			logging.Errorf("Indexer::cleanupAbandonedAlters Error Fetching From Local "+
+				"Meta Storage. Err %v", err)
+		}
+		return
+	}
+
+	var insts []common.IndexInst
+	if err := json.Unmarshal([]byte(val), &insts); err != nil {
+		logging.Errorf("Indexer::cleanupAbandonedAlters Error Unmarshalling %v", err)
+	}
+
+	storage_dir := idx.config["storage_dir"].String()
+	for _, inst := range insts {
+		if _, ok := idx.indexInstMap[inst.InstId]; ok {
+			continue
+		}
+
+		logging.Infof("Indexer::cleanupAbandonedAlters Remove Files for Index %v InstId %v",
+			inst.Defn.Name, inst.InstId)
+
+		for _, partnId := range inst.Defn.HostedPartitions() {
+			path := filepath.Join(storage_dir, IndexPath(&inst, partnId, SliceId(0)))
+			if err := os.RemoveAll(path); err != nil {
+				logging.Errorf("Indexer::cleanupAbandonedAlters Error Removing %v. %v", path, err)
+			}
+		}
+	}
+
+	idx.clustMgrAgentCmdCh <- &MsgClustMgrLocal{
+		mType: CLUST_MGR_DEL_LOCAL,
+		key:   ALTER_INDEX_CLEANUP_KEY,
+	}
+	<-idx.clustMgrAgentCmdCh
+}
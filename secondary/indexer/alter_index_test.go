@@ -0,0 +1,86 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestAlterIndexScanInst(t *testing.T) {
+	defn := common.IndexDefn{DefnId: common.IndexDefnId(1), SecExprs: []string{"`a`"}}
+	old := common.IndexInst{InstId: common.IndexInstId(10), Defn: defn,
+		State: common.INDEX_STATE_ACTIVE, RState: common.REBAL_ACTIVE}
+
+	altered := defn
+	altered.SecExprs = []string{"`b`"}
+	shadow := common.IndexInst{InstId: common.IndexInstId(20), Defn: altered,
+		State: common.INDEX_STATE_CREATED, RState: common.REBAL_PENDING, Version: 1}
+
+	idx := &indexer{
+		indexInstMap: common.IndexInstMap{old.InstId: old},
+		shadowInsts:  map[common.IndexInstId]common.IndexInstId{shadow.InstId: old.InstId},
+	}
+
+	scanInst := func() common.IndexInstId {
+		inst := findScanInst(idx.indexInstMap, defn.DefnId)
+		if inst == nil {
+			t.Fatalf("no instance serving scans")
+		}
+		return inst.InstId
+	}
+
+	// old instance serves scans while the shadow instance is built,
+	// and after the shadow is built till it is swapped in.
+	for _, state := range []common.IndexState{common.INDEX_STATE_CREATED,
+		common.INDEX_STATE_INITIAL, common.INDEX_STATE_ACTIVE} {
+
+		shadow.State = state
+		idx.indexInstMap[shadow.InstId] = shadow
+		if instId := scanInst(); instId != old.InstId {
+			t.Fatalf("expected old instance to serve scans in %v, received %v", state, instId)
+		}
+		if instId := idx.getInstIdFromDefnId(defn.DefnId); instId != old.InstId {
+			t.Fatalf("expected old instance for definition, received %v", instId)
+		}
+		if !idx.isShadowInst(shadow.InstId) || idx.isShadowInst(old.InstId) {
+			t.Fatalf("unexpected shadow instances %v", idx.shadowInsts)
+		}
+	}
+
+	// scans are switched over when the shadow is swapped in.
+	delete(idx.shadowInsts, shadow.InstId)
+	shadow.RState = common.REBAL_ACTIVE
+	idx.indexInstMap[shadow.InstId] = shadow
+	if instId := scanInst(); instId != shadow.InstId {
+		t.Fatalf("expected shadow instance to serve scans, received %v", instId)
+	}
+
+	old.State = common.INDEX_STATE_DELETED
+	idx.indexInstMap[old.InstId] = old
+	if instId := scanInst(); instId != shadow.InstId {
+		t.Fatalf("expected shadow instance to serve scans, received %v", instId)
+	}
+	delete(idx.indexInstMap, old.InstId)
+	if instId := idx.getInstIdFromDefnId(defn.DefnId); instId != shadow.InstId {
+		t.Fatalf("expected shadow instance for definition, received %v", instId)
+	}
+}
+
+func TestAlterIndexScanVersion(t *testing.T) {
+	inst := &common.IndexInst{InstId: common.IndexInstId(20), Version: 1}
+
+	version := func(v uint64) *uint64 { return &v }
+	if err := checkScanInstVersion(inst, nil); err != nil {
+		t.Errorf("unexpected error without version %v", err)
+	}
+	if err := checkScanInstVersion(inst, version(1)); err != nil {
+		t.Errorf("unexpected error for expected version %v", err)
+	}
+	// replica not swapped in yet, or client not refreshed after swap.
+	if err := checkScanInstVersion(inst, version(0)); err != ErrVersionMismatch {
+		t.Errorf("expected %v, received %v", ErrVersionMismatch, err)
+	}
+	if err := checkScanInstVersion(inst, version(2)); err != ErrVersionMismatch {
+		t.Errorf("expected %v, received %v", ErrVersionMismatch, err)
+	}
+}
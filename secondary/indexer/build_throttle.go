@@ -0,0 +1,293 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+//Build throttling limits the share of mutation queue memory available
+//to INIT_STREAM. When the maintenance stream falls behind, the mutation
+//queue fills up or cpu is saturated, INIT_STREAM queues stop accepting
+//mutations earlier. This blocks the INIT_STREAM reader and in turn slows
+//down DCP backfill from KV, leaving room for MAINT_STREAM.
+//
+//The share is reduced by half every interval the indexer is under
+//pressure and grows back gradually once the pressure is gone.
+//
+//Pressure is measured excluding the initial builds themselves. Memory
+//used by INIT_STREAM queues is not counted, and cpu is scaled down by
+//the share of INIT_STREAM in documents indexed during the interval.
+//Otherwise a build running alone would throttle itself.
+
+const buildThrottleMaxPct = 100
+const buildThrottleStepPct = 10
+
+//pressure below this fraction of the thresholds lets the
+//share grow back
+const buildThrottleLowWater = 0.8
+
+type buildThrottleConfig struct {
+	enabled  bool
+	interval time.Duration
+	memHigh  float64
+	cpuHigh  float64
+	maintLag int64
+	minPct   int64
+}
+
+type buildPressure struct {
+	memFrac  float64 //fraction of mutation queue memory in use, other than INIT_STREAM
+	cpuFrac  float64 //fraction of available cpu in use, other than initial builds
+	maintLag int64   //max mutations pending for an index in MAINT_STREAM
+}
+
+func newBuildThrottleConfig(config common.Config) buildThrottleConfig {
+
+	return buildThrottleConfig{
+		enabled:  config["build.throttle.enable"].Bool(),
+		interval: time.Duration(config["build.throttle.interval"].Uint64()) * time.Millisecond,
+		memHigh:  config["build.throttle.memHighFrac"].Float64(),
+		cpuHigh:  config["build.throttle.cpuHighFrac"].Float64(),
+		maintLag: int64(config["build.throttle.maintLag"].Uint64()),
+		minPct:   int64(config["build.throttle.minPercent"].Uint64()),
+	}
+}
+
+//level returns the highest pressure relative to its threshold,
+//1.0 or above means the indexer is under pressure.
+func (p buildPressure) level(cfg buildThrottleConfig) float64 {
+
+	level := float64(0)
+	if cfg.memHigh > 0 && p.memFrac/cfg.memHigh > level {
+		level = p.memFrac / cfg.memHigh
+	}
+	if cfg.cpuHigh > 0 && p.cpuFrac/cfg.cpuHigh > level {
+		level = p.cpuFrac / cfg.cpuHigh
+	}
+	if cfg.maintLag > 0 && float64(p.maintLag)/float64(cfg.maintLag) > level {
+		level = float64(p.maintLag) / float64(cfg.maintLag)
+	}
+	return level
+}
+
+//nextBuildThrottlePct computes the share of mutation queue memory
+//available to INIT_STREAM for the next interval.
+func nextBuildThrottlePct(curr int64, p buildPressure, cfg buildThrottleConfig) int64 {
+
+	if !cfg.enabled {
+		return buildThrottleMaxPct
+	}
+
+	minPct := cfg.minPct
+	if minPct <= 0 || minPct > buildThrottleMaxPct {
+		minPct = 1
+	}
+
+	level := p.level(cfg)
+
+	next := curr
+	if level >= 1.0 {
+		next = curr / 2
+	} else if level < buildThrottleLowWater {
+		next = curr + buildThrottleStepPct
+	}
+
+	if next < minPct {
+		next = minPct
+	}
+	if next > buildThrottleMaxPct {
+		next = buildThrottleMaxPct
+	}
+	return next
+}
+
+type buildThrottle struct {
+	pct int64
+
+	lock sync.Mutex
+	cfg  buildThrottleConfig
+
+	//docs indexed per instance at the last evaluation,
+	//only accessed by runBuildThrottle
+	indexed map[common.IndexInstId]int64
+}
+
+func newBuildThrottle(config common.Config) *buildThrottle {
+
+	return &buildThrottle{
+		pct: buildThrottleMaxPct,
+		cfg: newBuildThrottleConfig(config),
+	}
+}
+
+func (t *buildThrottle) setConfig(config common.Config) {
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.cfg = newBuildThrottleConfig(config)
+}
+
+func (t *buildThrottle) getConfig() buildThrottleConfig {
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.cfg
+}
+
+func (t *buildThrottle) getPct() int64 {
+	return atomic.LoadInt64(&t.pct)
+}
+
+//runBuildThrottle periodically evaluates the pressure on the indexer and
+//adjusts the memory limit of INIT_STREAM mutation queues.
+func (m *mutationMgr) runBuildThrottle() {
+
+	cfg := m.throttle.getConfig()
+	ticker := time.NewTicker(cfg.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if newCfg := m.throttle.getConfig(); newCfg.interval != cfg.interval {
+				ticker.Stop()
+				ticker = time.NewTicker(newCfg.interval)
+			}
+			cfg = m.throttle.getConfig()
+
+			curr := m.throttle.getPct()
+			next := nextBuildThrottlePct(curr, m.getBuildPressure(), cfg)
+			if next != curr {
+				logging.Infof("MutationMgr::runBuildThrottle INIT_STREAM Queue "+
+					"Memory Changed From %v%% To %v%%", curr, next)
+			}
+
+			atomic.StoreInt64(&m.throttle.pct, next)
+			m.setInitStreamMaxMemory()
+
+			if stats := m.stats.Get(); stats != nil {
+				stats.buildThrottlePct.Set(next)
+			}
+
+		case <-m.shutdownCh:
+			return
+		}
+	}
+}
+
+func (m *mutationMgr) getBuildPressure() buildPressure {
+
+	var p buildPressure
+
+	if maxMem := atomic.LoadInt64(&m.maxMemory); maxMem > 0 {
+		used := atomic.LoadInt64(&m.memUsed) - atomic.LoadInt64(&m.initMemUsed)
+		if used < 0 {
+			used = 0
+		}
+		p.memFrac = float64(used) / float64(maxMem)
+	}
+
+	p.cpuFrac = getCpuPercent() / float64(100*runtime.GOMAXPROCS(0))
+
+	stats := m.stats.Get()
+	if stats == nil {
+		return p
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var initDocs, totalDocs int64
+	indexed := make(map[common.IndexInstId]int64)
+
+	for instId, inst := range m.indexInstMap {
+		idxStats, ok := stats.indexes[instId]
+		if !ok {
+			continue
+		}
+
+		curr := idxStats.numDocsIndexed.Value()
+		indexed[instId] = curr
+		if last, ok := m.throttle.indexed[instId]; ok && curr > last {
+			totalDocs += curr - last
+			if inst.Stream == common.INIT_STREAM {
+				initDocs += curr - last
+			}
+		}
+
+		if inst.Stream != common.MAINT_STREAM || inst.State != common.INDEX_STATE_ACTIVE {
+			continue
+		}
+		lag := idxStats.numDocsPending.Value() + idxStats.numDocsQueued.Value()
+		if lag > p.maintLag {
+			p.maintLag = lag
+		}
+	}
+
+	m.throttle.indexed = indexed
+	p.cpuFrac = nonBuildCpuFrac(p.cpuFrac, initDocs, totalDocs)
+
+	return p
+}
+
+//nonBuildCpuFrac scales down the fraction of cpu in use by the share
+//of INIT_STREAM in the documents indexed during the interval.
+func nonBuildCpuFrac(cpuFrac float64, initDocs, totalDocs int64) float64 {
+
+	if totalDocs <= 0 || initDocs <= 0 {
+		return cpuFrac
+	}
+	if initDocs >= totalDocs {
+		return 0
+	}
+	return cpuFrac * float64(totalDocs-initDocs) / float64(totalDocs)
+}
+
+//setInitStreamMaxMemory sets the memory limit of INIT_STREAM mutation
+//queues as the throttled share of the mutation queue memory.
+func (m *mutationMgr) setInitStreamMaxMemory() {
+
+	maxMem := atomic.LoadInt64(&m.maxMemory)
+	atomic.StoreInt64(&m.initMaxMemory, maxMem*m.throttle.getPct()/buildThrottleMaxPct)
+}
+
+//newMutationQueue allocates the mutation queue of the stream for
+//bucket. Memory used by INIT_STREAM queues is tracked separately,
+//so that the build throttle measures the pressure from other streams.
+func (m *mutationMgr) newMutationQueue(streamId common.StreamId,
+	bucket string) MutationQueue {
+
+	q := NewAtomicMutationQueue(bucket, m.numVbuckets,
+		m.getQueueMaxMemory(streamId), &m.memUsed, m.config)
+	if q == nil {
+		return nil
+	}
+	if streamId == common.INIT_STREAM {
+		q.streamMemUsed = &m.initMemUsed
+	}
+	return q
+}
+
+//getQueueMaxMemory returns the memory limit for mutation
+//queues of the stream
+func (m *mutationMgr) getQueueMaxMemory(streamId common.StreamId) *int64 {
+
+	if streamId == common.INIT_STREAM {
+		return &m.initMaxMemory
+	}
+	return &m.maxMemory
+}
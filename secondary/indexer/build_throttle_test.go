@@ -0,0 +1,112 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestBuildThrottlePct(t *testing.T) {
+	config := common.SystemConfig.SectionConfig("indexer.", true).Clone()
+	config.SetValue("build.throttle.minPercent", 10)
+	cfg := newBuildThrottleConfig(config)
+
+	idle := buildPressure{}
+	memFull := buildPressure{memFrac: 0.95}
+	lagging := buildPressure{maintLag: cfg.maintLag * 2}
+	busy := buildPressure{cpuFrac: 1.0}
+
+	// backs off by half under any kind of pressure, down to the minimum
+	pct := int64(buildThrottleMaxPct)
+	for _, p := range []buildPressure{memFull, lagging, busy} {
+		next := nextBuildThrottlePct(pct, p, cfg)
+		if next != pct/2 {
+			t.Fatalf("expected %v under pressure %+v, received %v", pct/2, p, next)
+		}
+		pct = next
+	}
+	for i := 0; i < 10; i++ {
+		pct = nextBuildThrottlePct(pct, memFull, cfg)
+	}
+	if pct != 10 {
+		t.Fatalf("expected throttle to stop at minimum 10, received %v", pct)
+	}
+
+	// pressure just below the threshold holds the current share
+	hold := buildPressure{memFrac: cfg.memHigh * 0.9}
+	if next := nextBuildThrottlePct(pct, hold, cfg); next != pct {
+		t.Fatalf("expected %v to be held, received %v", pct, next)
+	}
+
+	// grows back gradually once the pressure is gone
+	if next := nextBuildThrottlePct(pct, idle, cfg); next != pct+buildThrottleStepPct {
+		t.Fatalf("expected %v, received %v", pct+buildThrottleStepPct, next)
+	}
+	for i := 0; i < 20; i++ {
+		pct = nextBuildThrottlePct(pct, idle, cfg)
+	}
+	if pct != buildThrottleMaxPct {
+		t.Fatalf("expected throttle to recover to %v, received %v", buildThrottleMaxPct, pct)
+	}
+
+	// disabled throttle never limits INIT_STREAM
+	config.SetValue("build.throttle.enable", false)
+	cfg = newBuildThrottleConfig(config)
+	if next := nextBuildThrottlePct(10, memFull, cfg); next != buildThrottleMaxPct {
+		t.Fatalf("expected %v with throttle disabled, received %v", buildThrottleMaxPct, next)
+	}
+}
+
+func TestBuildPressureExcludesInitStream(t *testing.T) {
+	conf := common.SystemConfig.SectionConfig("indexer.", true)
+	m := &mutationMgr{
+		maxMemory:   100 * 1024 * 1024,
+		numVbuckets: 1,
+		config:      conf,
+		throttle:    newBuildThrottle(conf),
+	}
+	m.setInitStreamMaxMemory()
+
+	initQ := m.newMutationQueue(common.INIT_STREAM, "default")
+	maintQ := m.newMutationQueue(common.MAINT_STREAM, "default")
+	mut := &MutationKeys{meta: &MutationMeta{vbucket: 0, seqno: 1}}
+
+	// memory used by INIT_STREAM queues is not pressure on the indexer
+	initQ.Enqueue(mut, 0, nil)
+	if m.initMemUsed != mut.Size() || m.memUsed != mut.Size() {
+		t.Fatalf("unexpected memory used %v %v", m.initMemUsed, m.memUsed)
+	}
+	if p := m.getBuildPressure(); p.memFrac != 0 {
+		t.Fatalf("expected no memory pressure from INIT_STREAM, received %v", p.memFrac)
+	}
+
+	maintQ.Enqueue(mut, 0, nil)
+	expected := float64(mut.Size()) / float64(m.maxMemory)
+	if p := m.getBuildPressure(); p.memFrac != expected {
+		t.Fatalf("expected memory pressure %v, received %v", expected, p.memFrac)
+	}
+
+	initQ.DequeueSingleElement(0)
+	maintQ.DequeueSingleElement(0)
+	if m.initMemUsed != 0 || m.memUsed != 0 {
+		t.Fatalf("unexpected memory used after dequeue %v %v", m.initMemUsed, m.memUsed)
+	}
+}
+
+func TestNonBuildCpuFrac(t *testing.T) {
+	tests := []struct {
+		initDocs, totalDocs int64
+		expected            float64
+	}{
+		{0, 0, 0.8},    // nothing indexed
+		{0, 100, 0.8},  // only MAINT_STREAM
+		{50, 100, 0.4}, // half of the documents for initial build
+		{100, 100, 0},  // only initial build
+	}
+	for _, test := range tests {
+		if frac := nonBuildCpuFrac(0.8, test.initDocs, test.totalDocs); frac != test.expected {
+			t.Errorf("expected %v for %v/%v, received %v",
+				test.expected, test.initDocs, test.totalDocs, frac)
+		}
+	}
+}
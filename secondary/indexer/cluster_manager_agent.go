@@ -126,6 +126,9 @@ func (c *clustMgrAgent) handleSupvervisorCommands(cmd Message) {
 	case CLUST_MGR_RESET_INDEX:
 		c.handleResetIndex(cmd)
 
+	case CLUST_MGR_SWAP_ALTER_INDEX:
+		c.handleSwapAlterIndex(cmd)
+
 	case CLUST_MGR_GET_GLOBAL_TOPOLOGY:
 		c.handleGetGlobalTopology(cmd)
 
@@ -221,6 +224,19 @@ func (c *clustMgrAgent) handleResetIndex(cmd Message) {
 	c.supvCmdch <- &MsgSuccess{}
 }
 
+func (c *clustMgrAgent) handleSwapAlterIndex(cmd Message) {
+
+	logging.Infof("ClustMgr:handleSwapAlterIndex %v", cmd)
+
+	index := cmd.(*MsgClustMgrSwapAlterIndex).GetIndex()
+
+	if err := c.mgr.SwapAlteredIndex(index); err != nil {
+		common.CrashOnError(err)
+	}
+
+	c.supvCmdch <- &MsgSuccess{}
+}
+
 func (c *clustMgrAgent) handleIndexMap(cmd Message) {
 
 	logging.Infof("ClustMgr:handleIndexMap %v", cmd)
@@ -301,6 +317,14 @@ func (c *clustMgrAgent) handleGetGlobalTopology(cmd Message) {
 			idxDefn.Desc = make([]bool, len(idxDefn.SecExprs))
 		}
 
+		//partitions placed on this indexer
+		if idxDefn.GetNumPartitions() > 1 {
+			for _, part := range inst.Partitions {
+				idxDefn.Partitions = append(idxDefn.Partitions,
+					common.PartitionId(part.PartId))
+			}
+		}
+
 		idxInst := common.IndexInst{InstId: common.IndexInstId(inst.InstId),
 			Defn:           idxDefn,
 			State:          common.IndexState(inst.State),
@@ -461,7 +485,10 @@ func (meta *metaNotifier) OnIndexCreate(indexDefn *common.IndexDefn, instId comm
 	logging.Infof("clustMgrAgent::OnIndexCreate Notification "+
 		"Received for Create Index %v %v", indexDefn, reqCtx)
 
-	pc := meta.makeDefaultPartitionContainer()
+	pc, err := meta.makeDefaultPartitionContainer(indexDefn)
+	if err != nil {
+		return err
+	}
 
 	idxInst := common.IndexInst{InstId: instId,
 		Defn:      *indexDefn,
@@ -613,6 +640,66 @@ func (meta *metaNotifier) OnIndexDelete(instId common.IndexInstId,
 	return nil
 }
 
+func (meta *metaNotifier) OnIndexAlter(indexDefn *common.IndexDefn,
+	oldInstId common.IndexInstId, reqCtx *common.MetadataRequestContext) error {
+
+	logging.Infof("clustMgrAgent::OnIndexAlter Notification "+
+		"Received for Alter Index %v OldInstId %v %v", indexDefn, oldInstId, reqCtx)
+
+	pc, err := meta.makeDefaultPartitionContainer(indexDefn)
+	if err != nil {
+		return err
+	}
+
+	//shadow instance stays in REBAL_PENDING till it is swapped in
+	idxInst := common.IndexInst{InstId: indexDefn.InstId,
+		Defn:      *indexDefn,
+		State:     common.INDEX_STATE_CREATED,
+		RState:    common.REBAL_PENDING,
+		Pc:        pc,
+		ReplicaId: indexDefn.ReplicaId,
+		Version:   indexDefn.InstVersion,
+	}
+
+	respCh := make(MsgChannel)
+
+	meta.adminCh <- &MsgAlterIndex{indexInst: idxInst,
+		oldInstId: oldInstId,
+		respCh:    respCh,
+		reqCtx:    reqCtx}
+
+	//wait for response
+	if res, ok := <-respCh; ok {
+
+		switch res.GetMsgType() {
+
+		case MSG_SUCCESS:
+			logging.Infof("clustMgrAgent::OnIndexAlter Success "+
+				"for Alter Index %v", indexDefn)
+			return nil
+
+		case MSG_ERROR:
+			logging.Errorf("clustMgrAgent::OnIndexAlter Error "+
+				"for Alter Index %v. Error %v.", indexDefn, res)
+			err := res.(*MsgError).GetError()
+			return &common.IndexerError{Reason: err.String(), Code: err.convertError()}
+
+		default:
+			logging.Fatalf("clustMgrAgent::OnIndexAlter Unknown Response "+
+				"Received for Alter Index %v. Response %v", indexDefn, res)
+			common.CrashOnError(errors.New("Unknown Response"))
+
+		}
+
+	} else {
+		logging.Fatalf("clustMgrAgent::OnIndexAlter Unexpected Channel Close "+
+			"for Alter Index %v", indexDefn)
+		common.CrashOnError(errors.New("Unknown Response"))
+	}
+
+	return nil
+}
+
 func (meta *metaNotifier) OnFetchStats() error {
 
 	go meta.fetchStats()
@@ -639,18 +726,12 @@ func (meta *metaNotifier) fetchStats() {
 	}
 }
 
-func (meta *metaNotifier) makeDefaultPartitionContainer() common.PartitionContainer {
+func (meta *metaNotifier) makeDefaultPartitionContainer(
+	indexDefn *common.IndexDefn) (common.PartitionContainer, error) {
 
-	pc := common.NewKeyPartitionContainer()
-
-	//Add one partition for now
+	//partitions of the index hosted locally, a single partition
+	//unless the index is hash or range partitioned.
 	addr := net.JoinHostPort("", meta.config["streamMaintPort"].String())
-	endpt := []common.Endpoint{common.Endpoint(addr)}
-
-	partnDefn := common.KeyPartitionDefn{Id: common.PartitionId(1),
-		Endpts: endpt}
-	pc.AddPartition(common.PartitionId(1), partnDefn)
-
-	return pc
+	return NewLocalPartitionContainer(indexDefn, common.Endpoint(addr))
 
 }
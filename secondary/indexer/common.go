@@ -110,6 +110,9 @@ type IndexStorageStats struct {
 	Name   string
 	Bucket string
 	Stats  StorageStatistics
+
+	// Partitions has storage stats of each partition of the instance.
+	Partitions map[common.PartitionId]StorageStatistics
 }
 
 func (s IndexStorageStats) String() string {
@@ -223,7 +223,8 @@ loop:
 		case _, ok := <-cd.timer.C:
 
 			conf := cd.config.Load()
-			if common.GetStorageMode() == common.FORESTDB {
+			if common.GetStorageMode() == common.FORESTDB ||
+				common.GetStorageMode() == common.LSM {
 
 				if ok {
 					replych := make(chan []IndexStorageStats)
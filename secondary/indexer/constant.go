@@ -71,4 +71,6 @@ const MAX_METAKV_RETRIES = 100
 
 const PLASMA_MEMQUOTA_FRAC = 0.9
 
+const LSM_BLOCKCACHE_FRAC = 0.5
+
 const SCAN_ROLLBACK_ERROR_BATCHSIZE = 1000
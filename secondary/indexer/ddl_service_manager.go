@@ -16,7 +16,6 @@
 package indexer
 
 import (
-	"github.com/couchbase/cbauth/metakv"
 	"github.com/couchbase/cbauth/service"
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/logging"
@@ -168,7 +167,7 @@ func (m *DDLServiceMgr) rebalanceDone(change *service.TopologyChange, isCancel b
 //
 func (m *DDLServiceMgr) handleDropCommand() {
 
-	entries, err := metakv.ListAllChildren(client.DeleteDDLCommandTokenPath)
+	entries, err := common.Metakv().ListAllChildren(client.DeleteDDLCommandTokenPath)
 	if err != nil {
 		logging.Warnf("DDLServiceMgr: Fail to cleanup delete index token upon rebalancing.  Skip cleanup.  Internal Error = %v", err)
 		return
@@ -218,7 +217,7 @@ func (m *DDLServiceMgr) handleDropCommand() {
 //
 func (m *DDLServiceMgr) handleBuildCommand() {
 
-	entries, err := metakv.ListAllChildren(client.BuildDDLCommandTokenPath)
+	entries, err := common.Metakv().ListAllChildren(client.BuildDDLCommandTokenPath)
 	if err != nil {
 		logging.Warnf("DDLServiceMgr: Fail to cleanup build index token upon rebalancing.  Skip cleanup.  Internal Error = %v", err)
 		return
@@ -285,14 +284,14 @@ func (m *DDLServiceMgr) handleListMetadataTokens(w http.ResponseWriter, r *http.
 
 		logging.Infof("DDLServiceMgr::handleListMetadataTokens Processing Request %v", r)
 
-		buildTokens, err := metakv.ListAllChildren(client.BuildDDLCommandTokenPath)
+		buildTokens, err := common.Metakv().ListAllChildren(client.BuildDDLCommandTokenPath)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error() + "\n"))
 			return
 		}
 
-		deleteTokens, err1 := metakv.ListAllChildren(client.DeleteDDLCommandTokenPath)
+		deleteTokens, err1 := common.Metakv().ListAllChildren(client.DeleteDDLCommandTokenPath)
 		if err1 != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error() + "\n"))
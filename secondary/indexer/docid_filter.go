@@ -0,0 +1,225 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync/atomic"
+)
+
+// Docid filter is a scalable bloom filter of docids added to the back-index
+// of a slice writer. Mutations for docids that are certainly not in the
+// filter skip the back-index lookup, which is the common case during the
+// initial build.
+//
+// Docids are never removed from the filter, a filter is a superset of the
+// docids in back-index, hence it remains valid when back-index is rolled
+// back. Filter starts with a bloom filter sized for the initial capacity,
+// when it is full a new bloom filter with twice the capacity and half the
+// false positive rate is added, keeping the compounded false positive rate
+// under 2 * docidFilterFpRate.
+//
+// Filter is not thread safe, it is operated by the slice writer owning the
+// back-index.
+
+const (
+	docidFilterFpRate       = 0.01
+	docidFilterMinCapacity  = 1024
+	docidFilterMagic        = uint32(0x64666c74) // "dflt"
+	docidFilterHeaderLen    = 8
+	docidFilterBloomInfoLen = 28
+)
+
+var errDocidFilterCorrupt = errors.New("docid filter is corrupt")
+
+type docidFilter struct {
+	capacity uint64
+	blooms   []*bloomFilter
+	size     int64 // memory used by blooms, read by stats
+}
+
+type bloomFilter struct {
+	words    []uint64
+	nbits    uint64
+	k        uint32
+	count    uint64
+	capacity uint64
+}
+
+func newDocidFilter(capacity uint64) *docidFilter {
+	if capacity < docidFilterMinCapacity {
+		capacity = docidFilterMinCapacity
+	}
+	f := &docidFilter{capacity: capacity}
+	f.Reset()
+	return f
+}
+
+// Add docid to the filter.
+func (f *docidFilter) Add(docid []byte) {
+	b := f.blooms[len(f.blooms)-1]
+	if b.count >= b.capacity {
+		b = newBloomFilter(b.capacity*2, b.k+1)
+		f.blooms = append(f.blooms, b)
+		atomic.AddInt64(&f.size, int64(len(b.words)*8))
+	}
+	b.add(hashKey(docid))
+}
+
+// MayContain returns false if docid was certainly not added to the
+// filter.
+func (f *docidFilter) MayContain(docid []byte) bool {
+	h := hashKey(docid)
+	for _, b := range f.blooms {
+		if b.contains(h) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reset the filter to be empty.
+func (f *docidFilter) Reset() {
+	k := uint32(math.Ceil(math.Log2(1 / docidFilterFpRate)))
+	b := newBloomFilter(f.capacity, k)
+	f.blooms = []*bloomFilter{b}
+	atomic.StoreInt64(&f.size, int64(len(b.words)*8))
+}
+
+// MemoryInUse by the filter, can be called concurrently with writer.
+func (f *docidFilter) MemoryInUse() int64 {
+	return atomic.LoadInt64(&f.size)
+}
+
+// MarshalBinary encodes the filter for persistence.
+func (f *docidFilter) MarshalBinary() ([]byte, error) {
+	n := docidFilterHeaderLen
+	for _, b := range f.blooms {
+		n += docidFilterBloomInfoLen + len(b.words)*8
+	}
+
+	data := make([]byte, n)
+	binary.LittleEndian.PutUint32(data[0:], docidFilterMagic)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(f.blooms)))
+	off := docidFilterHeaderLen
+	for _, b := range f.blooms {
+		binary.LittleEndian.PutUint32(data[off:], b.k)
+		binary.LittleEndian.PutUint64(data[off+4:], b.count)
+		binary.LittleEndian.PutUint64(data[off+12:], b.capacity)
+		binary.LittleEndian.PutUint64(data[off+20:], uint64(len(b.words)))
+		off += docidFilterBloomInfoLen
+		for _, w := range b.words {
+			binary.LittleEndian.PutUint64(data[off:], w)
+			off += 8
+		}
+	}
+	return data, nil
+}
+
+// UnmarshalBinary restores a filter encoded by MarshalBinary, filter
+// is left unmodified on error.
+func (f *docidFilter) UnmarshalBinary(data []byte) error {
+	if len(data) < docidFilterHeaderLen ||
+		binary.LittleEndian.Uint32(data[0:]) != docidFilterMagic {
+		return errDocidFilterCorrupt
+	}
+
+	nblooms := int(binary.LittleEndian.Uint32(data[4:]))
+	if nblooms == 0 {
+		return errDocidFilterCorrupt
+	}
+	blooms := make([]*bloomFilter, 0, nblooms)
+	var size int64
+	off := docidFilterHeaderLen
+	for i := 0; i < nblooms; i++ {
+		if len(data)-off < docidFilterBloomInfoLen {
+			return errDocidFilterCorrupt
+		}
+		b := &bloomFilter{
+			k:        binary.LittleEndian.Uint32(data[off:]),
+			count:    binary.LittleEndian.Uint64(data[off+4:]),
+			capacity: binary.LittleEndian.Uint64(data[off+12:]),
+		}
+		nwords := binary.LittleEndian.Uint64(data[off+20:])
+		off += docidFilterBloomInfoLen
+		if b.k == 0 || b.capacity == 0 || nwords == 0 ||
+			uint64(len(data)-off)/8 < nwords {
+			return errDocidFilterCorrupt
+		}
+		b.words = make([]uint64, nwords)
+		for j := range b.words {
+			b.words[j] = binary.LittleEndian.Uint64(data[off:])
+			off += 8
+		}
+		b.nbits = nwords * 64
+		size += int64(nwords * 8)
+		blooms = append(blooms, b)
+	}
+	if off != len(data) {
+		return errDocidFilterCorrupt
+	}
+
+	f.blooms = blooms
+	atomic.StoreInt64(&f.size, size)
+	return nil
+}
+
+// newBloomFilter with `k` hash functions, sized for `capacity` items
+// with a false positive rate of 2^-k.
+func newBloomFilter(capacity uint64, k uint32) *bloomFilter {
+	nbits := uint64(math.Ceil(float64(capacity) * float64(k) / math.Ln2))
+	nwords := (nbits + 63) / 64
+	return &bloomFilter{
+		words:    make([]uint64, nwords),
+		nbits:    nwords * 64,
+		k:        k,
+		capacity: capacity,
+	}
+}
+
+// bits are located by double hashing, with the two halves of `h`.
+func (b *bloomFilter) add(h uint64) {
+	h1, h2 := h&0xffffffff, h>>32|1
+	for i := uint64(0); i < uint64(b.k); i++ {
+		pos := (h1 + i*h2) % b.nbits
+		b.words[pos/64] |= 1 << (pos % 64)
+	}
+	b.count++
+}
+
+func (b *bloomFilter) contains(h uint64) bool {
+	h1, h2 := h&0xffffffff, h>>32|1
+	for i := uint64(0); i < uint64(b.k); i++ {
+		pos := (h1 + i*h2) % b.nbits
+		if b.words[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// mayHaveBackIndexEntry checks docid with the filter of a slice writer,
+// returns false if back-index lookup can be skipped. Nil filter means
+// filter is disabled.
+func mayHaveBackIndexEntry(
+	filter *docidFilter, docid []byte, idxStats *IndexStats) bool {
+
+	if filter == nil {
+		return true
+	}
+	idxStats.docidFilterLookups.Add(1)
+	if filter.MayContain(docid) {
+		return true
+	}
+	idxStats.docidFilterSkips.Add(1)
+	return false
+}
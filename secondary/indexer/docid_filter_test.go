@@ -0,0 +1,58 @@
+package indexer
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDocidFilter(t *testing.T) {
+	filter := newDocidFilter(docidFilterMinCapacity)
+	n := 20 * docidFilterMinCapacity
+	for i := 0; i < n; i++ {
+		filter.Add([]byte(fmt.Sprintf("doc-%08d", i)))
+	}
+	if len(filter.blooms) < 2 {
+		t.Fatalf("Expected filter to grow, %v bloom filters", len(filter.blooms))
+	}
+
+	for i := 0; i < n; i++ {
+		if !filter.MayContain([]byte(fmt.Sprintf("doc-%08d", i))) {
+			t.Fatalf("Expected doc-%08d in filter", i)
+		}
+	}
+
+	var fp int
+	for i := n; i < 2*n; i++ {
+		if filter.MayContain([]byte(fmt.Sprintf("doc-%08d", i))) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / float64(n); rate > 2*docidFilterFpRate {
+		t.Errorf("Expected false positive rate < %v, received %v", 2*docidFilterFpRate, rate)
+	}
+
+	data, err := filter.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := newDocidFilter(docidFilterMinCapacity)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if restored.MemoryInUse() != filter.MemoryInUse() {
+		t.Errorf("Expected size %v, received %v", filter.MemoryInUse(), restored.MemoryInUse())
+	}
+	for i := 0; i < n; i++ {
+		if !restored.MayContain([]byte(fmt.Sprintf("doc-%08d", i))) {
+			t.Fatalf("Expected doc-%08d in restored filter", i)
+		}
+	}
+	if err := restored.UnmarshalBinary(data[:len(data)-1]); err != errDocidFilterCorrupt {
+		t.Errorf("Expected %v, received %v", errDocidFilterCorrupt, err)
+	}
+
+	filter.Reset()
+	if filter.MayContain([]byte("doc-00000000")) || len(filter.blooms) != 1 {
+		t.Errorf("Expected filter to be empty after reset")
+	}
+}
@@ -395,6 +395,13 @@ func (f *flusher) flush(mutk *MutationKeys, streamId common.StreamId) {
 				continue
 			}
 
+			//document was upserted into the partition of the partition
+			//key, remove it from the other partitions.
+			if len(mut.partnkey) != 0 {
+				f.processUpsertDeletion(mut, mutk.docid, mutk.meta)
+				continue
+			}
+
 			var skipUpsertDeletion bool
 			//if Upsert has been processed for this IndexInstId,
 			//skip processing UpsertDeletion
@@ -431,9 +438,11 @@ func (f *flusher) processUpsert(mut *Mutation, docid []byte, meta *MutationMeta)
 		return
 	}
 
-	if partnInst := partnInstMap[partnId]; ok {
+	//for partitioned index, the document is removed from other partitions
+	//on UpsertDeletion from projector.
+	if partnInst, ok := partnInstMap[partnId]; ok {
 		slice := partnInst.Sc.GetSliceByIndexKey(common.IndexKey(mut.key))
-		if err := slice.Insert(mut.key, docid, meta); err != nil {
+		if err := slice.Insert(mut.key, docid, mut.payload, meta); err != nil {
 			logging.Errorf("Flusher::processUpsert Error indexing Key: %s "+
 				"docid: %s in Slice: %v. Error: %v. Skipped.",
 				mut.key, docid, slice.Id(), err)
@@ -464,7 +473,19 @@ func (f *flusher) processDelete(mut *Mutation, docid []byte, meta *MutationMeta)
 		return
 	}
 
-	if partnInst := partnInstMap[partnId]; ok {
+	//partition key is not known, delete from all partitions
+	if len(partnInstMap) > 1 && len(mut.partnkey) == 0 {
+		for id, partnInst := range partnInstMap {
+			slice := partnInst.Sc.GetSliceByIndexKey(common.IndexKey(mut.key))
+			if err := slice.Delete(docid, meta); err != nil {
+				logging.Errorf("Flusher::processDelete Error Deleting DocId: %v "+
+					"from Partition: %v Slice: %v", docid, id, slice.Id())
+			}
+		}
+		return
+	}
+
+	if partnInst, ok := partnInstMap[partnId]; ok {
 		slice := partnInst.Sc.GetSliceByIndexKey(common.IndexKey(mut.key))
 		if err := slice.Delete(docid, meta); err != nil {
 			logging.Errorf("Flusher::processDelete Error Deleting DocId: %v "+
@@ -476,6 +497,34 @@ func (f *flusher) processDelete(mut *Mutation, docid []byte, meta *MutationMeta)
 	}
 }
 
+//processUpsertDeletion removes a document upserted into the partition
+//of the partition key from all other partitions hosted by the indexer.
+func (f *flusher) processUpsertDeletion(mut *Mutation, docid []byte, meta *MutationMeta) {
+
+	idxInst, _ := f.indexInstMap[mut.uuid]
+
+	partnId := idxInst.Pc.GetPartitionIdByPartitionKey(mut.partnkey)
+
+	var partnInstMap PartitionInstMap
+	var ok bool
+	if partnInstMap, ok = f.indexPartnMap[mut.uuid]; !ok {
+		logging.Errorf("Flusher:processUpsertDeletion Missing Partition Instance Map"+
+			"for IndexInstId: %v. Skipped Mutation Key: %v", mut.uuid, mut.key)
+		return
+	}
+
+	for id, partnInst := range partnInstMap {
+		if id == partnId {
+			continue
+		}
+		slice := partnInst.Sc.GetSliceByIndexKey(common.IndexKey(mut.key))
+		if err := slice.Delete(docid, meta); err != nil {
+			logging.Errorf("Flusher::processUpsertDeletion Error Deleting DocId: %v "+
+				"from Partition: %v Slice: %v", docid, id, slice.Id())
+		}
+	}
+}
+
 //IsTimestampGreaterThanQueueLWT checks if each Vbucket in the Queue has
 //mutation with Seqno lower than the corresponding Seqno present in the
 //specified timestamp.
@@ -11,6 +11,7 @@ package indexer
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -31,6 +32,7 @@ import (
 
 var (
 	snapshotMetaListKey = []byte("snapshots-list")
+	docidFilterMetaKey  = []byte("docid-filter")
 )
 
 //NewForestDBSlice initiailizes a new slice with forestdb backend.
@@ -154,6 +156,19 @@ retry:
 
 	slice.isPrimary = isPrimary
 
+	// restore key statistics from the latest snapshot
+	slice.keyStats = newKeyStatistics()
+	if infos, err := slice.getSnapshotsMeta(); err == nil {
+		if info := NewSnapshotInfoContainer(infos).GetLatest(); info != nil {
+			slice.keyStats.Restore(info.(*fdbSnapshotInfo).KeyStats)
+		}
+	}
+
+	if !isPrimary && sysconf["settings.docidFilter.enable"].Bool() {
+		capacity := sysconf["settings.docidFilter.initialCapacity"].Uint64()
+		slice.docidFilter = slice.restoreDocidFilter(capacity)
+	}
+
 	for i := 0; i < slice.numWriters; i++ {
 		slice.stopCh[i] = make(DoneChannel)
 		slice.workerDone[i] = make(chan bool)
@@ -245,6 +260,11 @@ type fdbSlice struct {
 	// Array processing
 	arrayExprPosition int
 	isArrayDistinct   bool
+
+	keyStats *keyStatistics
+
+	// filter of docids in back index, nil if disabled
+	docidFilter *docidFilter
 }
 
 func (fdb *fdbSlice) IncrRef() {
@@ -273,8 +293,8 @@ func (fdb *fdbSlice) DecrRef() {
 //Internally the request is buffered and executed async.
 //If forestdb has encountered any fatal error condition,
 //it will be returned as error.
-func (fdb *fdbSlice) Insert(rawKey []byte, docid []byte, meta *MutationMeta) error {
-	key, err := GetIndexEntryBytes(rawKey, docid, fdb.idxDefn.IsPrimary, fdb.idxDefn.IsArrayIndex, 1, fdb.idxDefn.Desc)
+func (fdb *fdbSlice) Insert(rawKey []byte, docid []byte, payload []byte, meta *MutationMeta) error {
+	key, err := GetIndexEntryBytes(rawKey, docid, payload, fdb.idxDefn.IsPrimary, fdb.idxDefn.IsArrayIndex, 1, fdb.idxDefn.Desc)
 	if err != nil {
 		return err
 	}
@@ -429,6 +449,7 @@ func (fdb *fdbSlice) insertSecIndex(key []byte, docid []byte, workerId int) (nmu
 				"entry from main index %v", fdb.id, fdb.idxInstId, err)
 			return
 		}
+		fdb.keyStats.DeleteEntry(secondaryIndexEntry(oldkey))
 		fdb.idxStats.Timings.stKVDelete.Put(time.Now().Sub(t0))
 		atomic.AddInt64(&fdb.delete_bytes, int64(len(oldkey)))
 
@@ -465,6 +486,9 @@ func (fdb *fdbSlice) insertSecIndex(key []byte, docid []byte, workerId int) (nmu
 	}
 	fdb.idxStats.Timings.stKVSet.Put(time.Now().Sub(t0))
 	atomic.AddInt64(&fdb.insert_bytes, int64(len(docid)+len(key)))
+	if oldkey == nil && fdb.docidFilter != nil {
+		fdb.docidFilter.Add(docid)
+	}
 
 	t0 = time.Now()
 	//set in main index
@@ -476,6 +500,7 @@ func (fdb *fdbSlice) insertSecIndex(key []byte, docid []byte, workerId int) (nmu
 	}
 	fdb.idxStats.Timings.stKVSet.Put(time.Now().Sub(t0))
 	atomic.AddInt64(&fdb.insert_bytes, int64(len(key)))
+	fdb.keyStats.AddEntry(secondaryIndexEntry(key))
 	fdb.isDirty = true
 
 	nmut = 1
@@ -613,6 +638,7 @@ func (fdb *fdbSlice) insertSecArrayIndex(key []byte, rawKey []byte, docid []byte
 				"entry from main index %v", fdb.id, fdb.idxInstId, err)
 			return
 		}
+		fdb.keyStats.DeleteEntry(secondaryIndexEntry(keyToBeDeleted))
 		fdb.idxStats.Timings.stKVDelete.Put(time.Now().Sub(t0))
 		atomic.AddInt64(&fdb.delete_bytes, int64(len(oldkey)))
 		nmut++
@@ -629,6 +655,7 @@ func (fdb *fdbSlice) insertSecArrayIndex(key []byte, rawKey []byte, docid []byte
 		}
 		fdb.idxStats.Timings.stKVSet.Put(time.Now().Sub(t0))
 		atomic.AddInt64(&fdb.insert_bytes, int64(len(key)))
+		fdb.keyStats.AddEntry(secondaryIndexEntry(keyToBeAdded))
 		nmut++
 	}
 
@@ -660,6 +687,9 @@ func (fdb *fdbSlice) insertSecArrayIndex(key []byte, rawKey []byte, docid []byte
 		}
 		fdb.idxStats.Timings.stKVSet.Put(time.Now().Sub(t0))
 		atomic.AddInt64(&fdb.insert_bytes, int64(len(docid)+len(key)))
+		if oldkey == nil && fdb.docidFilter != nil {
+			fdb.docidFilter.Add(docid)
+		}
 	}
 
 	fdb.isDirty = true
@@ -744,6 +774,7 @@ func (fdb *fdbSlice) deleteSecIndex(docid []byte, workerId int) (nmut int) {
 			docid, olditm, err)
 		return
 	}
+	fdb.keyStats.DeleteEntry(secondaryIndexEntry(olditm))
 	fdb.idxStats.Timings.stKVDelete.Put(time.Now().Sub(t0))
 	atomic.AddInt64(&fdb.delete_bytes, int64(len(olditm)))
 
@@ -834,6 +865,7 @@ func (fdb *fdbSlice) deleteSecArrayIndex(docid []byte, workerId int) (nmut int)
 				"entry from main index %v", fdb.id, fdb.idxInstId, err)
 			return
 		}
+		fdb.keyStats.DeleteEntry(secondaryIndexEntry(keyToBeDeleted))
 		fdb.idxStats.Timings.stKVDelete.Put(time.Now().Sub(t0))
 		atomic.AddInt64(&fdb.delete_bytes, int64(len(keyToBeDeleted)))
 
@@ -863,6 +895,11 @@ func (fdb *fdbSlice) getBackIndexEntry(docid []byte, workerId int) ([]byte, erro
 	var kbytes []byte
 	var err error
 
+	//skip the lookup if docid was never added to back index
+	if !mayHaveBackIndexEntry(fdb.docidFilter, docid, fdb.idxStats) {
+		return nil, nil
+	}
+
 	t0 := time.Now()
 	kbytes, err = fdb.back[workerId].GetKV(docid)
 	fdb.idxStats.Timings.stKVGet.Put(time.Now().Sub(t0))
@@ -874,9 +911,89 @@ func (fdb *fdbSlice) getBackIndexEntry(docid []byte, workerId int) ([]byte, erro
 		return nil, err
 	}
 
+	if kbytes == nil && fdb.docidFilter != nil {
+		fdb.idxStats.docidFilterFalsePositives.Add(1)
+	}
+
 	return kbytes, nil
 }
 
+//restoreDocidFilter loads the docid filter persisted with the last
+//commit, filter is rebuilt from back index if it is missing or if back
+//index was modified after the filter was persisted. Returns nil if
+//filter cannot be built.
+func (fdb *fdbSlice) restoreDocidFilter(capacity uint64) *docidFilter {
+	filter := newDocidFilter(capacity)
+
+	backInfo, err := fdb.back[0].Info()
+	if err != nil {
+		logging.Errorf("ForestDBSlice::restoreDocidFilter SliceId %v IndexInstId %v "+
+			"Error reading back index info %v. Filter disabled.", fdb.id, fdb.idxInstId, err)
+		return nil
+	}
+
+	data, err := fdb.meta.GetKV(docidFilterMetaKey)
+	if err == nil && len(data) > 8 {
+		backSeq := forestdb.SeqNum(binary.LittleEndian.Uint64(data[:8]))
+		if backSeq == backInfo.LastSeqNum() && filter.UnmarshalBinary(data[8:]) == nil {
+			logging.Infof("ForestDBSlice::restoreDocidFilter SliceId %v IndexInstId %v "+
+				"Restored filter at back index seqno %v", fdb.id, fdb.idxInstId, backSeq)
+			return filter
+		}
+	}
+
+	t0 := time.Now()
+	var count int
+	it, err := fdb.back[0].IteratorInit(nil, nil, forestdb.ITR_NO_DELETES)
+	if err == nil {
+		for {
+			doc, err := it.GetMetaOnly()
+			if err != nil {
+				break
+			}
+			filter.Add(doc.Key())
+			doc.Close()
+			count++
+			if it.Next() != nil {
+				break
+			}
+		}
+		it.Close()
+	} else if err != forestdb.FDB_RESULT_ITERATOR_FAIL { // empty back index
+		logging.Errorf("ForestDBSlice::restoreDocidFilter SliceId %v IndexInstId %v "+
+			"Error iterating back index %v. Filter disabled.", fdb.id, fdb.idxInstId, err)
+		return nil
+	}
+
+	logging.Infof("ForestDBSlice::restoreDocidFilter SliceId %v IndexInstId %v "+
+		"Rebuilt filter with %v docids in %v", fdb.id, fdb.idxInstId, count, time.Since(t0))
+	return filter
+}
+
+//persistDocidFilter saves the docid filter in meta store along with the
+//back index seqno it is consistent with. Filter is saved before commit
+//so that it is atomically updated with snapshot meta.
+func (fdb *fdbSlice) persistDocidFilter(backSeq forestdb.SeqNum) error {
+	data, err := fdb.docidFilter.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	val := make([]byte, 8+len(data))
+	binary.LittleEndian.PutUint64(val[:8], uint64(backSeq))
+	copy(val[8:], data)
+
+	fdb.metaLock.Lock()
+	defer fdb.metaLock.Unlock()
+
+	t0 := time.Now()
+	if err := fdb.meta.SetKV(docidFilterMetaKey, val); err != nil {
+		return err
+	}
+	fdb.idxStats.Timings.stKVMetaSet.Put(time.Now().Sub(t0))
+	return nil
+}
+
 //checkFatalDbError checks if the error returned from DB
 //is fatal and stores it. This error will be returned
 //to caller on next DB operation
@@ -912,6 +1029,7 @@ func (fdb *fdbSlice) OpenSnapshot(info SnapshotInfo) (Snapshot, error) {
 			ts:         snapInfo.Timestamp(),
 			mainSeqNum: snapInfo.MainSeq,
 			committed:  info.IsCommitted(),
+			keyStats:   snapInfo.KeyStats,
 		}
 	} else {
 		s = &fdbSnapshot{slice: fdb,
@@ -921,6 +1039,7 @@ func (fdb *fdbSlice) OpenSnapshot(info SnapshotInfo) (Snapshot, error) {
 			ts:         snapInfo.Timestamp(),
 			mainSeqNum: snapInfo.MainSeq,
 			committed:  info.IsCommitted(),
+			keyStats:   snapInfo.KeyStats,
 		}
 	}
 
@@ -990,6 +1109,7 @@ func (fdb *fdbSlice) Rollback(info SnapshotInfo) error {
 	}
 
 	fdb.setCommittedCount()
+	fdb.keyStats.Restore(snapInfo.KeyStats)
 
 	//rollback back-index only for non-primary indexes
 	if !fdb.isPrimary {
@@ -1035,6 +1155,10 @@ func (fdb *fdbSlice) RollbackToZero() error {
 	}
 
 	fdb.setCommittedCount()
+	fdb.keyStats.Reset()
+	if fdb.docidFilter != nil {
+		fdb.docidFilter.Reset()
+	}
 
 	//rollback back-index only for non-primary indexes
 	if !fdb.isPrimary {
@@ -1103,6 +1227,9 @@ func (fdb *fdbSlice) NewSnapshot(ts *common.TsVbuuid, commit bool) (SnapshotInfo
 		MainSeq:   mainDbInfo.LastSeqNum(),
 		Committed: commit,
 	}
+	if !fdb.isPrimary {
+		newSnapshotInfo.KeyStats = fdb.keyStats.Data()
+	}
 
 	//for non-primary index add info for back-index
 	if !fdb.isPrimary {
@@ -1142,6 +1269,11 @@ func (fdb *fdbSlice) NewSnapshot(ts *common.TsVbuuid, commit bool) (SnapshotInfo
 
 		// Meta update should be done before commit
 		// Otherwise, metadata will not be atomically updated along with disk commit.
+		if fdb.docidFilter != nil {
+			if err = fdb.persistDocidFilter(newSnapshotInfo.BackSeq); err != nil {
+				return nil, err
+			}
+		}
 		err = fdb.updateSnapshotsMeta(sic.List())
 		if err != nil {
 			return nil, err
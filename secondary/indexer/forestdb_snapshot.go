@@ -29,6 +29,7 @@ type fdbSnapshotInfo struct {
 	BackSeq   forestdb.SeqNum
 	MetaSeq   forestdb.SeqNum
 	Committed bool
+	KeyStats  *keyStatsData `json:",omitempty"`
 }
 
 func (info *fdbSnapshotInfo) Timestamp() *common.TsVbuuid {
@@ -54,6 +55,7 @@ type fdbSnapshot struct {
 	idxInstId common.IndexInstId //index instance id
 	ts        *common.TsVbuuid   //timestamp
 	committed bool
+	keyStats  *keyStatsData
 
 	refCount int32 //Reader count for this snapshot
 }
@@ -171,5 +173,6 @@ func (s *fdbSnapshot) Info() SnapshotInfo {
 		MainSeq:   s.mainSeqNum,
 		Committed: s.committed,
 		Ts:        s.ts,
+		KeyStats:  s.keyStats,
 	}
 }
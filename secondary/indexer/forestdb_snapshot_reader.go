@@ -30,6 +30,10 @@ func (s *fdbSnapshot) StatCountTotal() (uint64, error) {
 	return c, nil
 }
 
+func (s *fdbSnapshot) KeyStatistics() *keyStatsData {
+	return s.keyStats
+}
+
 func (s *fdbSnapshot) CountTotal(ctx IndexReaderContext, stopch StopChannel) (uint64, error) {
 	return s.CountRange(ctx, MinIndexKey, MaxIndexKey, Both, stopch)
 }
@@ -10,9 +10,10 @@ import (
 )
 
 var (
-	ErrSecKeyNil     = errors.New("Secondary key array is empty")
-	ErrSecKeyTooLong = errors.New(fmt.Sprintf("Secondary key is too long (> %d)", maxSecKeyLen))
-	ErrDocIdTooLong  = errors.New(fmt.Sprintf("DocID is too long (>%d)", MAX_DOCID_LEN))
+	ErrSecKeyNil      = errors.New("Secondary key array is empty")
+	ErrSecKeyTooLong  = errors.New(fmt.Sprintf("Secondary key is too long (> %d)", maxSecKeyLen))
+	ErrDocIdTooLong   = errors.New(fmt.Sprintf("DocID is too long (>%d)", MAX_DOCID_LEN))
+	ErrPayloadTooLong = errors.New(fmt.Sprintf("Include payload is too long (> %d)", maxPayloadLen))
 )
 
 // Special index keys
@@ -38,6 +39,9 @@ var (
 	maxIndexEntrySize  = maxSecKeyBufferLen + MAX_DOCID_LEN + 2
 
 	allowLargeKeys = common.SystemConfig["indexer.settings.allow_large_keys"].Bool()
+
+	// length of payload is encoded in 2 bytes
+	maxPayloadLen = 0xffff
 )
 
 func init() {
@@ -111,8 +115,12 @@ func (e *primaryIndexEntry) String() string {
 
 // Storage encoding for secondary index entry
 // Format:
-// [collate_json_encoded_sec_key][raw_docid_bytes][optional_count_2_bytes][len_of_docid_2_bytes]
+// [collate_json_encoded_sec_key][raw_docid_bytes][optional_payload][optional_payload_len_2_bytes]
+//     [optional_count_2_bytes][len_of_docid_2_bytes]
 // The MSB of right byte of docid length indicates whether count is encoded or not
+// and the next bit indicates whether payload is encoded or not.
+// Payload holds the collate_json encoded values of INCLUDE expressions, it follows
+// the docid so that it does not take part in ordering or matching of entries.
 type secondaryIndexEntry []byte
 
 func NewSecondaryIndexEntry(key []byte, docid []byte, isArray bool, count int, desc []bool, buf []byte) (secondaryIndexEntry, error) {
@@ -120,6 +128,13 @@ func NewSecondaryIndexEntry(key []byte, docid []byte, isArray bool, count int, d
 }
 
 func NewSecondaryIndexEntry2(key []byte, docid []byte, isArray bool,
+	count int, desc []bool, buf []byte, validateSize bool) (secondaryIndexEntry, error) {
+	return NewSecondaryIndexEntry3(key, docid, nil, isArray, count, desc, buf, validateSize)
+}
+
+// NewSecondaryIndexEntry3 encodes entry with include payload, payload
+// is either JSON or collate_json encoded array.
+func NewSecondaryIndexEntry3(key []byte, docid []byte, payload []byte, isArray bool,
 	count int, desc []bool, buf []byte, validateSize bool) (secondaryIndexEntry, error) {
 	var err error
 	var offset int
@@ -156,18 +171,37 @@ func NewSecondaryIndexEntry2(key []byte, docid []byte, isArray bool,
 
 	buf = append(buf, docid...)
 
+	if len(payload) > 0 {
+		if payload[0] == '[' { // JSON
+			code := make([]byte, 0, 3*len(payload)+collatejson.MinBufferSize)
+			if payload, err = jsonEncoder.Encode(payload, code); err != nil {
+				return nil, err
+			}
+		}
+		if len(payload) > maxPayloadLen {
+			return nil, ErrPayloadTooLong
+		}
+		buf = append(buf, payload...)
+		buf = append(buf, 0, 0)
+		offset = len(buf) - 2
+		binary.LittleEndian.PutUint16(buf[offset:offset+2], uint16(len(payload)))
+	}
+
 	if count > 1 {
-		buf = buf[:len(buf)+2]
+		buf = append(buf, 0, 0)
 		offset = len(buf) - 2
 		binary.LittleEndian.PutUint16(buf[offset:offset+2], uint16(count))
 	}
 
-	buf = buf[:len(buf)+2]
+	buf = append(buf, 0, 0)
 	offset = len(buf) - 2
 	binary.LittleEndian.PutUint16(buf[offset:offset+2], uint16(len(docid)))
 	if count > 1 {
 		buf[offset+1] |= byte(uint8(1) << 7)
 	}
+	if len(payload) > 0 {
+		buf[offset+1] |= byte(uint8(1) << 6)
+	}
 
 	e := secondaryIndexEntry(buf)
 	return e, nil
@@ -182,15 +216,36 @@ func (e *secondaryIndexEntry) lenDocId() int {
 	rbuf := []byte(*e)
 	offset := len(rbuf) - 2
 	l := binary.LittleEndian.Uint16(rbuf[offset : offset+2])
-	len := l & 0x7fff // Length & 0111111 11111111 (as MSB of length is used to indicate presence of count)
+	len := l & 0x3fff // Length & 0011111 11111111 (as 2 MSBs of length are used to indicate presence of count and payload)
 	return int(len)
 }
 
 func (e *secondaryIndexEntry) lenKey() int {
-	if e.isCountEncoded() == true {
-		return len(*e) - e.lenDocId() - 4
+	return len(*e) - e.lenDocId() - e.lenTrailer()
+}
+
+// lenTrailer returns the number of bytes following the docid.
+func (e *secondaryIndexEntry) lenTrailer() int {
+	l := 2
+	if e.isCountEncoded() {
+		l += 2
+	}
+	if e.isPayloadEncoded() {
+		l += e.lenPayload() + 2
+	}
+	return l
+}
+
+func (e *secondaryIndexEntry) lenPayload() int {
+	if !e.isPayloadEncoded() {
+		return 0
+	}
+	rbuf := []byte(*e)
+	offset := len(rbuf) - 4
+	if e.isCountEncoded() {
+		offset -= 2
 	}
-	return len(*e) - e.lenDocId() - 2
+	return int(binary.LittleEndian.Uint16(rbuf[offset : offset+2]))
 }
 
 func (e *secondaryIndexEntry) isCountEncoded() bool {
@@ -199,18 +254,29 @@ func (e *secondaryIndexEntry) isCountEncoded() bool {
 	return (rbuf[offset] & 0x80) == 0x80
 }
 
+func (e *secondaryIndexEntry) isPayloadEncoded() bool {
+	rbuf := []byte(*e)
+	offset := len(rbuf) - 1 // Decode length byte to see if payload is encoded
+	return (rbuf[offset] & 0x40) == 0x40
+}
+
 func (e secondaryIndexEntry) ReadDocId(buf []byte) ([]byte, error) {
 	docidlen := e.lenDocId()
-	var offset int
-	if e.isCountEncoded() == true {
-		offset = len(e) - docidlen - 4
-	} else {
-		offset = len(e) - docidlen - 2
-	}
+	offset := e.lenKey()
 	buf = append(buf, e[offset:offset+docidlen]...)
 	return buf, nil
 }
 
+// ReadPayload returns the collate_json encoded payload of entry, nil if
+// entry has no payload.
+func (e secondaryIndexEntry) ReadPayload() []byte {
+	if !e.isPayloadEncoded() {
+		return nil
+	}
+	offset := e.lenKey() + e.lenDocId()
+	return e[offset : offset+e.lenPayload()]
+}
+
 func (e secondaryIndexEntry) Count() int {
 	rbuf := []byte(e)
 	if e.isCountEncoded() {
@@ -224,13 +290,7 @@ func (e secondaryIndexEntry) Count() int {
 
 func (e secondaryIndexEntry) ReadSecKey(buf []byte) ([]byte, error) {
 	var err error
-	var encoded []byte
-	doclen := e.lenDocId()
-	if e.isCountEncoded() {
-		encoded = e[0 : len(e)-doclen-4]
-	} else {
-		encoded = e[0 : len(e)-doclen-2]
-	}
+	encoded := e[0:e.lenKey()]
 
 	if buf, err = jsonEncoder.Decode(encoded, buf); err != nil {
 		return nil, err
@@ -494,7 +554,7 @@ func GetIndexEntryBytes2(key []byte, docid []byte,
 	return bs, err
 }
 
-func GetIndexEntryBytes(key []byte, docid []byte,
+func GetIndexEntryBytes(key []byte, docid []byte, payload []byte,
 	isPrimary bool, isArray bool, count int, desc []bool) (entry []byte, err error) {
 
 	var bufPool *common.BytesBufPool
@@ -511,8 +571,8 @@ func GetIndexEntryBytes(key []byte, docid []byte,
 		bufPtr = bufPool.Get()
 		buf = (*bufPtr)[:0]
 
-		if allowLargeKeys && len(key)+MAX_KEY_EXTRABYTES_LEN > cap(*bufPtr) {
-			newSize := len(key) + MAX_DOCID_LEN + ENCODE_BUF_SAFE_PAD
+		if allowLargeKeys && len(key)+len(payload)+MAX_KEY_EXTRABYTES_LEN > cap(*bufPtr) {
+			newSize := len(key) + len(payload) + MAX_DOCID_LEN + ENCODE_BUF_SAFE_PAD
 			buf = make([]byte, 0, newSize)
 			bufPtr = &buf
 		}
@@ -522,6 +582,13 @@ func GetIndexEntryBytes(key []byte, docid []byte,
 		}()
 	}
 
-	entry, err = GetIndexEntryBytes2(key, docid, isPrimary, isArray, count, desc, buf)
+	if len(payload) > 0 && !isPrimary {
+		entry, err = NewSecondaryIndexEntry3(key, docid, payload, isArray, count, desc, buf, true)
+		if err == ErrSecKeyNil {
+			return nil, nil
+		}
+	} else {
+		entry, err = GetIndexEntryBytes2(key, docid, isPrimary, isArray, count, desc, buf)
+	}
 	return append([]byte(nil), entry...), err
 }
@@ -186,3 +186,50 @@ func TestLongDocIdEntry(t *testing.T) {
 		t.Errorf("Expected lenght to be 258 but instead got ", e.lenDocId())
 	}
 }
+
+func TestSecondaryIndexEntryPayload(t *testing.T) {
+	key := []byte(`["field1","field2"]`)
+	docid := []byte("doc-1")
+	payload := []byte(`["inc1",10]`)
+
+	e, err := NewSecondaryIndexEntry3(key, docid, payload, false, 3, nil,
+		make([]byte, 0, 4096), true)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	e1, _ := newSKEntry(key, docid)
+
+	buf, _ := e.ReadDocId(nil)
+	if !bytes.Equal(docid, buf) {
+		t.Errorf("Expected %v, received %v", string(docid), string(buf))
+	}
+	if !bytes.Equal(docid, docIdFromEntryBytes(e)) {
+		t.Errorf("Expected %v, received %v", string(docid), string(docIdFromEntryBytes(e)))
+	}
+
+	buf, _ = e.ReadSecKey(make([]byte, 0, 300))
+	if !bytes.Equal(key, buf) {
+		t.Errorf("Expected %v, received %v", string(key), string(buf))
+	}
+
+	if e.Count() != 3 {
+		t.Errorf("Expected count 3, received %v", e.Count())
+	}
+
+	buf, _ = jsonEncoder.Decode(e.ReadPayload(), make([]byte, 0, 300))
+	if !bytes.Equal(payload, buf) {
+		t.Errorf("Expected %v, received %v", string(payload), string(buf))
+	}
+
+	// payload does not take part in matching
+	k, _ := NewSecondaryKey(key, make([]byte, 100))
+	if k.Compare(&e) != 0 || k.ComparePrefixFields(&e) != 0 {
+		t.Errorf("Expected match")
+	}
+	if !bytes.Equal(e[:e.lenKey()+e.lenDocId()], e1[:e1.lenKey()+e1.lenDocId()]) {
+		t.Errorf("Expected same key and docid with and without payload")
+	}
+	if e1.ReadPayload() != nil {
+		t.Errorf("Expected no payload")
+	}
+}
@@ -126,3 +126,24 @@ func GetSliceSnapshots(is IndexSnapshot) (s []SliceSnapshot) {
 
 	return
 }
+
+// GetPartitionSliceSnapshots returns slice snapshots of the specified
+// partitions, or of all partitions if partnIds is empty.
+func GetPartitionSliceSnapshots(is IndexSnapshot,
+	partnIds []common.PartitionId) (s []SliceSnapshot) {
+
+	if is == nil || len(partnIds) == 0 {
+		return GetSliceSnapshots(is)
+	}
+
+	partns := is.Partitions()
+	for _, partnId := range partnIds {
+		if p, ok := partns[partnId]; ok {
+			for _, sl := range p.Slices() {
+				s = append(s, sl)
+			}
+		}
+	}
+
+	return
+}
@@ -30,8 +30,9 @@ type StorageStatistics struct {
 
 type IndexWriter interface {
 
-	//Persist a key/value pair
-	Insert(key []byte, docid []byte, meta *MutationMeta) error
+	//Persist a key/value pair, payload of include columns is stored
+	//alongside the entry
+	Insert(key []byte, docid []byte, payload []byte, meta *MutationMeta) error
 
 	//Delete a key/value pair by docId
 	Delete(docid []byte, meta *MutationMeta) error
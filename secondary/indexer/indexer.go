@@ -31,6 +31,7 @@ import (
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/fdb"
 	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/lsm"
 	"github.com/couchbase/indexing/secondary/memdb"
 	"github.com/couchbase/indexing/secondary/memdb/nodetable"
 	projClient "github.com/couchbase/indexing/secondary/projector/client"
@@ -145,6 +146,9 @@ type indexer struct {
 
 	rebalanceRunning bool
 	rebalanceToken   *RebalanceToken
+
+	shadowInsts       map[common.IndexInstId]common.IndexInstId //shadow instance to altered instance
+	alterCleanupInsts []common.IndexInst                        //instances to cleanup on bootstrap
 }
 
 type kvRequest struct {
@@ -193,6 +197,7 @@ func NewIndexer(config common.Config) (Indexer, Message) {
 		bucketBuildTs:                make(map[string]Timestamp),
 		bucketRollbackTimes:          make(map[string]int64),
 		bucketCreateClientChMap:      make(map[string]MsgChannel),
+		shadowInsts:                  make(map[common.IndexInstId]common.IndexInstId),
 	}
 
 	logging.Infof("Indexer::NewIndexer Status Warmup")
@@ -205,12 +210,40 @@ func NewIndexer(config common.Config) (Indexer, Message) {
 		return nil, res
 	}
 
+	// TLS for queryport and dataport listeners, and for adminport
+	// connections with projector.
+	if err := common.SetupTransportTLSFromConfig(idx.config); err != nil {
+		logging.Fatalf("Indexer::NewIndexer Transport TLS Init Error %v", err)
+		return nil, &MsgError{
+			err: Error{code: ERROR_INDEXER_INTERNAL_ERROR,
+				severity: FATAL,
+				category: INDEXER,
+				cause:    err}}
+	}
+	if common.IsTransportEncrypted() {
+		logging.Infof("Indexer::NewIndexer Transport encryption enabled, allowPlaintext %v",
+			idx.config["allowPlaintext"].Bool())
+		// certificate refresh callback for ssl port also reloads the
+		// transport certificate.
+		if idx.config["httpsPort"].String() == "" {
+			cbauth.RegisterCertRefreshCallback(func() error {
+				if err := common.ReloadTransportCertificate(); err != nil {
+					logging.Errorf("Indexer::NewIndexer Error in reloading transport certificate, "+
+						"continuing with previous certificate: %v", err)
+					return err
+				}
+				return nil
+			})
+		}
+	}
+
 	idx.stats = NewIndexerStats()
 
 	// Read memquota setting
 	memQuota := int64(idx.config["settings.memory_quota"].Uint64())
 	idx.stats.memoryQuota.Set(memQuota)
 	plasma.SetMemoryQuota(int64(float64(memQuota) * PLASMA_MEMQUOTA_FRAC))
+	lsm.SetBlockCacheSize(int64(float64(memQuota) * LSM_BLOCKCACHE_FRAC))
 	memdb.Debug(idx.config["settings.moi.debug"].Bool())
 	reclaimBlockSize := int64(idx.config["plasma.LSSReclaimBlockSize"].Int())
 	plasma.SetLogReclaimBlockSize(reclaimBlockSize)
@@ -288,7 +321,13 @@ func NewIndexer(config common.Config) (Indexer, Message) {
 	addr := net.JoinHostPort("", idx.config["httpPort"].String())
 	logging.PeriodicProfile(logging.Debug, addr, "goroutine")
 	go func() {
-		if err := http.ListenAndServe(addr, nil); err != nil {
+		// TLS if transport is encrypted, for rebalance and snapshot
+		// transfer between indexers.
+		lsnr, err := common.TransportListen(addr)
+		if err == nil {
+			err = http.Serve(lsnr, nil)
+		}
+		if err != nil {
 			logging.Fatalf("indexer:: Error Starting Http Server: %v", err)
 			common.CrashOnError(err)
 		}
@@ -304,6 +343,9 @@ func NewIndexer(config common.Config) (Indexer, Message) {
 		var tlslsnr *net.Listener = nil
 
 		cbauth.RegisterCertRefreshCallback(func() error {
+			if err := common.ReloadTransportCertificate(); err != nil {
+				logging.Errorf("indexer:: Error in reloading transport certificate: %v", err)
+			}
 			if tlslsnr != nil {
 				reload = true
 				(*tlslsnr).Close()
@@ -895,6 +937,9 @@ func (idx *indexer) handleWorkerMsgs(msg Message) {
 	case INDEXER_UPDATE_RSTATE:
 		idx.handleUpdateIndexRState(msg)
 
+	case INDEXER_GET_INDEX_SLICES:
+		idx.handleGetIndexSlices(msg)
+
 	default:
 		logging.Fatalf("Indexer::handleWorkerMsgs Unknown Message %+v", msg)
 		common.CrashOnError(errors.New("Unknown Msg On Worker Channel"))
@@ -959,6 +1004,7 @@ func (idx *indexer) handleConfigUpdate(msg Message) {
 		memQuota := int64(newConfig["settings.memory_quota"].Uint64())
 		idx.stats.memoryQuota.Set(memQuota)
 		plasma.SetMemoryQuota(int64(float64(memQuota) * PLASMA_MEMQUOTA_FRAC))
+		lsm.SetBlockCacheSize(int64(float64(memQuota) * LSM_BLOCKCACHE_FRAC))
 
 		if common.GetStorageMode() == common.FORESTDB ||
 			common.GetStorageMode() == common.NOT_SET {
@@ -1024,6 +1070,9 @@ func (idx *indexer) handleAdminMsgs(msg Message) {
 	case CLUST_MGR_BUILD_INDEX_DDL:
 		idx.handleBuildIndex(msg)
 
+	case CLUST_MGR_ALTER_INDEX_DDL:
+		idx.handleAlterIndex(msg)
+
 	case CLUST_MGR_DROP_INDEX_DDL,
 		CBQ_DROP_INDEX_DDL:
 
@@ -1370,6 +1419,10 @@ func (idx *indexer) handleBuildIndex(msg Message) {
 			continue
 		}
 
+		//indexes with snapshots copied from a peer indexer during rebalance
+		//catch up from the snapshot instead of building from scratch
+		restartTs := idx.restartTsForTransferredSnapshots(bucket, instIdList)
+
 		//if there is already an index for this bucket in MAINT_STREAM,
 		//add this index to INIT_STREAM
 		var buildStream common.StreamId
@@ -1411,7 +1464,8 @@ func (idx *indexer) handleBuildIndex(msg Message) {
 		}
 
 		//send Stream Update to workers
-		idx.sendStreamUpdateForBuildIndex(instIdList, buildStream, bucket, buildTs, clientCh)
+		idx.sendStreamUpdateForBuildIndex(instIdList, buildStream, bucket, buildTs,
+			restartTs, clientCh)
 
 		idx.stateLock.Lock()
 		if _, ok := idx.streamBucketStatus[buildStream]; !ok {
@@ -1511,6 +1565,9 @@ func (idx *indexer) handleDropIndex(msg Message) {
 
 	}
 
+	//an alter in progress for the index is abandoned
+	idx.dropShadowForInst(indexInst.InstId)
+
 	idx.stats.RemoveIndex(indexInst.InstId)
 	//if the index state is Created/Ready/Deleted, only data cleanup is
 	//required. No stream updates are required.
@@ -1850,6 +1907,8 @@ func (idx *indexer) handleMergeStreamAck(msg Message) {
 				delete(idx.bucketCreateClientChMap, bucket)
 			}
 		} else {
+			idx.swapAlteredIndexes(mergeList)
+
 			var instIdList []common.IndexInstId
 			for _, inst := range mergeList {
 				instIdList = append(instIdList, inst.InstId)
@@ -2046,7 +2105,8 @@ func (idx *indexer) Shutdown() Message {
 }
 
 func (idx *indexer) sendStreamUpdateForBuildIndex(instIdList []common.IndexInstId,
-	buildStream common.StreamId, bucket string, buildTs Timestamp, clientCh MsgChannel) bool {
+	buildStream common.StreamId, bucket string, buildTs Timestamp,
+	restartTs *common.TsVbuuid, clientCh MsgChannel) bool {
 
 	var cmd Message
 	var indexList []common.IndexInst
@@ -2065,7 +2125,7 @@ func (idx *indexer) sendStreamUpdateForBuildIndex(instIdList []common.IndexInstI
 		indexList:    indexList,
 		buildTs:      buildTs,
 		respCh:       respCh,
-		restartTs:    nil,
+		restartTs:    restartTs,
 		rollbackTime: idx.bucketRollbackTimes[bucket]}
 
 	//send stream update to timekeeper
@@ -2139,6 +2199,19 @@ func (idx *indexer) sendStreamUpdateForBuildIndex(instIdList []common.IndexInstI
 					}
 
 				case INDEXER_ROLLBACK:
+					//stream started from a copied snapshot is rolled back
+					//like a stream restarted from a persisted snapshot
+					if restartTs != nil {
+						logging.Infof("Indexer::sendStreamUpdateForBuildIndex Rollback from "+
+							"Projector For Stream %v Bucket %v", buildStream, bucket)
+						rollbackTs := resp.(*MsgRollback).GetRollbackTs()
+						idx.internalRecvCh <- &MsgRecovery{mType: INDEXER_INIT_PREP_RECOVERY,
+							streamId:  buildStream,
+							bucket:    bucket,
+							restartTs: rollbackTs}
+						break retryloop
+					}
+
 					//an initial build request should never receive rollback message
 					logging.Errorf("Indexer::sendStreamUpdateForBuildIndex Unexpected Rollback from "+
 						"Projector during Initial Stream Request %v", resp)
@@ -2329,9 +2402,10 @@ func (idx *indexer) initPartnInstance(indexInst common.IndexInst,
 	//get all partitions for this index
 	partnDefnList := indexInst.Pc.GetAllPartitions()
 
-	for i, partnDefn := range partnDefnList {
+	for _, partnDefn := range partnDefnList {
 		//TODO: Ignore partitions which do not belong to this
 		//indexer node(based on the endpoints)
+		partnId := partnDefn.GetPartitionId()
 		partnInst := PartitionInst{Defn: partnDefn,
 			Sc: NewHashedSliceContainer()}
 
@@ -2339,12 +2413,12 @@ func (idx *indexer) initPartnInstance(indexInst common.IndexInst,
 			indexInst.InstId, partnInst)
 
 		//add a single slice per partition for now
-		if slice, err := NewSlice(SliceId(0), &indexInst, idx.config, idx.stats); err == nil {
+		if slice, err := NewSlice(partnId, SliceId(0), &indexInst, idx.config, idx.stats); err == nil {
 			partnInst.Sc.AddSlice(0, slice)
 			logging.Infof("Indexer::initPartnInstance Initialized Slice: \n\t Index: %v Slice: %v",
 				indexInst.InstId, slice)
 
-			partnInstMap[common.PartitionId(i)] = partnInst
+			partnInstMap[partnId] = partnInst
 		} else {
 			errStr := fmt.Sprintf("Error creating slice %v", err)
 			logging.Errorf("Indexer::initPartnInstance %v. Abort.", errStr)
@@ -2604,6 +2678,25 @@ func (idx *indexer) handleUpdateIndexRState(msg Message) {
 
 }
 
+func (idx *indexer) handleGetIndexSlices(msg Message) {
+
+	req := msg.(*MsgIndexSlices)
+	respCh := req.GetRespCh()
+
+	inst, ok := idx.indexInstMap[req.GetInstId()]
+	if !ok || inst.State == common.INDEX_STATE_DELETED {
+		respCh <- nil
+		return
+	}
+
+	slices := make(map[common.PartitionId]Slice)
+	for partnId, partnInst := range idx.indexPartnMap[inst.InstId] {
+		slices[partnId] = partnInst.Sc.GetSliceById(0)
+	}
+
+	respCh <- &indexSlices{inst: inst, slices: slices}
+}
+
 //TODO If this function gets error before its finished, the state
 //can be inconsistent. This needs to be fixed.
 func (idx *indexer) handleInitialBuildDone(msg Message) {
@@ -2645,7 +2738,9 @@ func (idx *indexer) handleInitialBuildDone(msg Message) {
 				index.State = common.INDEX_STATE_CATCHUP
 			} else {
 				index.State = common.INDEX_STATE_ACTIVE
-				index.RState = common.REBAL_ACTIVE
+				if !idx.isShadowInst(index.InstId) {
+					index.RState = common.REBAL_ACTIVE
+				}
 			}
 			indexList = append(indexList, index)
 			instIdList = append(instIdList, index.InstId)
@@ -2829,7 +2924,9 @@ func (idx *indexer) handleMergeInitStream(msg Message) {
 			index.State == common.INDEX_STATE_CATCHUP {
 
 			index.State = common.INDEX_STATE_ACTIVE
-			index.RState = common.REBAL_ACTIVE
+			if !idx.isShadowInst(index.InstId) {
+				index.RState = common.REBAL_ACTIVE
+			}
 			index.Stream = common.MAINT_STREAM
 			indexList = append(indexList, index)
 			bucketUUIDList = append(bucketUUIDList, index.Defn.BucketUUID)
@@ -3561,6 +3658,10 @@ func (idx *indexer) initFromPersistedState() error {
 
 	idx.upgradeStorage()
 
+	if idx.enableManager {
+		idx.cleanupAbandonedAlters()
+	}
+
 	// Set the storage mode specific to this indexer node
 	common.SetStorageMode(idx.getLocalStorageMode(idx.config))
 	initStorageSettings(idx.config)
@@ -3571,21 +3672,15 @@ func (idx *indexer) initFromPersistedState() error {
 			idx.stats.AddIndex(inst.InstId, inst.Defn.Bucket, inst.Defn.Name, inst.ReplicaId)
 		}
 
-		newpc := common.NewKeyPartitionContainer()
-
-		//Add one partition for now
-		partnId := common.PartitionId(0)
+		//partitions of the index hosted locally
 		addr := net.JoinHostPort("", idx.config["streamMaintPort"].String())
-		endpt := []common.Endpoint{common.Endpoint(addr)}
-		partnDefn := common.KeyPartitionDefn{Id: partnId,
-			Endpts: endpt}
-		newpc.AddPartition(partnId, partnDefn)
-
-		inst.Pc = newpc
+		var err error
+		if inst.Pc, err = NewLocalPartitionContainer(&inst.Defn, common.Endpoint(addr)); err != nil {
+			return err
+		}
 
 		//allocate partition/slice
 		var partnInstMap PartitionInstMap
-		var err error
 		if partnInstMap, err = idx.initPartnInstance(inst, nil); err != nil {
 			return err
 		}
@@ -3757,9 +3852,11 @@ func (idx *indexer) upgradeSingleIndex(inst *common.IndexInst, storageMode commo
 
 	// remove old files
 	storage_dir := idx.config["storage_dir"].String()
-	path := filepath.Join(storage_dir, IndexPath(inst, SliceId(0)))
-	if err := os.RemoveAll(path); err != nil {
-		common.CrashOnError(err)
+	for _, partnId := range inst.Defn.HostedPartitions() {
+		path := filepath.Join(storage_dir, IndexPath(inst, partnId, SliceId(0)))
+		if err := os.RemoveAll(path); err != nil {
+			common.CrashOnError(err)
+		}
 	}
 
 	// update metadata
@@ -3819,7 +3916,12 @@ func (idx *indexer) validateIndexInstMap() {
 			if index.State == common.INDEX_STATE_DELETED {
 				logging.Warnf("Indexer::validateIndexInstMap Found Index in State %v. "+
 					"Cleaning up Index Data %v", index.State, index)
-				err := idx.forceCleanupIndexData(&index, SliceId(0))
+				var err error
+				for _, partnId := range index.Defn.HostedPartitions() {
+					if err = idx.forceCleanupIndexData(&index, partnId, SliceId(0)); err != nil {
+						break
+					}
+				}
 				if err == nil {
 					idx.cleanupIndexMetadata(index)
 				}
@@ -3889,10 +3991,11 @@ func (idx *indexer) validateIndexInstMap() {
 
 //force cleanup of index data should only be used when storage manager has not yet
 //been initialized
-func (idx *indexer) forceCleanupIndexData(inst *common.IndexInst, sliceId SliceId) error {
+func (idx *indexer) forceCleanupIndexData(inst *common.IndexInst,
+	partnId common.PartitionId, sliceId SliceId) error {
 
 	storage_dir := idx.config["storage_dir"].String()
-	path := filepath.Join(storage_dir, IndexPath(inst, sliceId))
+	path := filepath.Join(storage_dir, IndexPath(inst, partnId, sliceId))
 
 	logging.Infof("Indexer::forceCleanupIndexData Cleaning Up Slice Id %v, "+
 		"IndexInstId %v, IndexDefnId %v ", sliceId, inst.InstId, inst.Defn.DefnId)
@@ -4013,44 +4116,127 @@ func (idx *indexer) makeRestartTs(streamId common.StreamId) map[string]*common.T
 
 		if idxInst.Stream == streamId {
 
-			//there is only one partition for now
-			partnInst := partnMap[0]
-			sc := partnInst.Sc
+			//restart from the oldest snapshot across partitions
+			for _, partnInst := range partnMap {
+				sc := partnInst.Sc
 
-			//there is only one slice for now
-			slice := sc.GetSliceById(0)
+				//there is only one slice for now
+				slice := sc.GetSliceById(0)
 
-			infos, err := slice.GetSnapshots()
-			// TODO: Proper error handling if possible
-			if err != nil {
-				panic("Unable read snapinfo -" + err.Error())
-			}
+				infos, err := slice.GetSnapshots()
+				// TODO: Proper error handling if possible
+				if err != nil {
+					panic("Unable read snapinfo -" + err.Error())
+				}
 
-			s := NewSnapshotInfoContainer(infos)
-			latestSnapInfo := s.GetLatest()
+				s := NewSnapshotInfoContainer(infos)
+				latestSnapInfo := s.GetLatest()
 
-			//There may not be a valid snapshot info if no flush
-			//happened for this index
-			if latestSnapInfo != nil {
-				ts := latestSnapInfo.Timestamp()
-				if oldTs, ok := restartTs[idxInst.Defn.Bucket]; ok {
-					if !ts.AsRecent(oldTs) {
+				//There may not be a valid snapshot info if no flush
+				//happened for this index
+				if latestSnapInfo != nil {
+					ts := latestSnapInfo.Timestamp()
+					if oldTs, ok := restartTs[idxInst.Defn.Bucket]; ok {
+						if !ts.AsRecent(oldTs) {
+							restartTs[idxInst.Defn.Bucket] = ts
+						}
+					} else {
 						restartTs[idxInst.Defn.Bucket] = ts
 					}
 				} else {
-					restartTs[idxInst.Defn.Bucket] = ts
+					//set restartTs to nil for this bucket
+					if _, ok := restartTs[idxInst.Defn.Bucket]; !ok {
+						restartTs[idxInst.Defn.Bucket] = nil
+					}
 				}
-			} else {
-				//set restartTs to nil for this bucket
-				if _, ok := restartTs[idxInst.Defn.Bucket]; !ok {
-					restartTs[idxInst.Defn.Bucket] = nil
+			}
+		}
+	}
+	return restartTs
+}
+
+//restartTsForTransferredSnapshots returns the timestamp to start the build
+//stream of indexes in instIdList from, if all the indexes have persisted
+//snapshots copied from a peer indexer during rebalance. An index being
+//built has a persisted snapshot only if it was copied. Copied snapshots
+//are discarded if some of the indexes are to be built from scratch, as
+//the stream then starts from zero.
+func (idx *indexer) restartTsForTransferredSnapshots(bucket string,
+	instIdList []common.IndexInstId) *common.TsVbuuid {
+
+	var restartTs *common.TsVbuuid
+	var copied []common.IndexInstId
+	for _, instId := range instIdList {
+		ts := idx.latestSnapshotTs(instId)
+		if ts == nil {
+			continue
+		}
+		copied = append(copied, instId)
+
+		//restart from the oldest snapshot across indexes
+		if restartTs == nil || restartTs.AsRecent(ts) {
+			restartTs = ts
+		}
+	}
+
+	if len(copied) == 0 {
+		return nil
+	}
+
+	if len(copied) != len(instIdList) {
+		logging.Infof("Indexer::restartTsForTransferredSnapshots Bucket %v Discarding "+
+			"copied snapshots of %v. Indexes %v are built from scratch.", bucket,
+			copied, instIdList)
+		for _, instId := range copied {
+			for _, partnInst := range idx.indexPartnMap[instId] {
+				for _, slice := range partnInst.Sc.GetAllSlices() {
+					if err := slice.RollbackToZero(); err != nil {
+						common.CrashOnError(err)
+					}
 				}
 			}
 		}
+		return nil
 	}
+
+	idx.storageMgrCmdCh <- &MsgOpenSnapshots{instIds: copied}
+	<-idx.storageMgrCmdCh
+
+	logging.Infof("Indexer::restartTsForTransferredSnapshots Bucket %v Indexes %v "+
+		"catch up from copied snapshots. RestartTs %v", bucket, copied, restartTs)
 	return restartTs
 }
 
+//latestSnapshotTs returns the oldest timestamp of the latest persisted
+//snapshots of all partitions of an index, nil if a partition has none.
+func (idx *indexer) latestSnapshotTs(instId common.IndexInstId) *common.TsVbuuid {
+
+	var ts *common.TsVbuuid
+	partnMap := idx.indexPartnMap[instId]
+	for _, partnInst := range partnMap {
+		//there is only one slice for now
+		slice := partnInst.Sc.GetSliceById(0)
+
+		infos, err := slice.GetSnapshots()
+		if err != nil {
+			logging.Errorf("Indexer::latestSnapshotTs Index %v Unable to read "+
+				"snapinfo %v", instId, err)
+			return nil
+		}
+
+		latestSnapInfo := NewSnapshotInfoContainer(infos).GetLatest()
+		if latestSnapInfo == nil || latestSnapInfo.Timestamp() == nil {
+			return nil
+		}
+
+		partnTs := latestSnapInfo.Timestamp()
+		if ts == nil || ts.AsRecent(partnTs) {
+			ts = partnTs
+		}
+	}
+	return ts
+}
+
 func (idx *indexer) closeAllStreams() {
 
 	respCh := make(MsgChannel)
@@ -4130,11 +4316,20 @@ func (idx *indexer) updateMetaInfoForIndexList(instIdList []common.IndexInstId,
 	updateBuildTs bool, updateRState bool, syncUpdate bool,
 	respCh chan error) error {
 
+	//shadow instances of alter are not part of index metadata
+	//till they are swapped in
 	var indexList []common.IndexInst
 	for _, instId := range instIdList {
+		if idx.isShadowInst(instId) {
+			continue
+		}
 		indexList = append(indexList, idx.indexInstMap[instId])
 	}
 
+	if len(indexList) == 0 {
+		return nil
+	}
+
 	updatedFields := MetaUpdateFields{
 		state:   updateState,
 		stream:  updateStream,
@@ -4305,7 +4500,7 @@ func (idx *indexer) bulkUpdateRState(instIdList []common.IndexInstId, reqCtx *co
 
 	for _, instId := range instIdList {
 		idxInst := idx.indexInstMap[instId]
-		if reqCtx.ReqSource == common.DDLRequestSourceRebalance {
+		if reqCtx.ReqSource == common.DDLRequestSourceRebalance || idx.isShadowInst(instId) {
 			idxInst.RState = common.REBAL_PENDING
 		} else {
 			idxInst.RState = common.REBAL_ACTIVE
@@ -4485,11 +4680,11 @@ func (idx *indexer) handleResetStats() {
 }
 
 func (idx *indexer) memoryUsedStorage() int64 {
-	mem_used := int64(forestdb.BufferCacheUsed()) + int64(memdb.MemoryInUse()) + int64(plasma.MemoryInUse()) + int64(nodetable.MemoryInUse())
+	mem_used := int64(forestdb.BufferCacheUsed()) + int64(memdb.MemoryInUse()) + int64(plasma.MemoryInUse()) + int64(nodetable.MemoryInUse()) + lsm.MemoryInUse()
 	return mem_used
 }
 
-func NewSlice(id SliceId, indInst *common.IndexInst,
+func NewSlice(partnId common.PartitionId, id SliceId, indInst *common.IndexInst,
 	conf common.Config, stats *IndexerStats) (slice Slice, err error) {
 	// Default storage is forestdb
 	storage_dir := conf["storage_dir"].String()
@@ -4497,7 +4692,7 @@ func NewSlice(id SliceId, indInst *common.IndexInst,
 	if _, e := os.Stat(storage_dir); e != nil {
 		common.CrashOnError(e)
 	}
-	path := filepath.Join(storage_dir, IndexPath(indInst, id))
+	path := filepath.Join(storage_dir, IndexPath(indInst, partnId, id))
 
 	ephemeral, err := IsEphemeral(conf["clusterAddr"].String(), indInst.Defn.Bucket)
 	if err != nil {
@@ -4511,6 +4706,8 @@ func NewSlice(id SliceId, indInst *common.IndexInst,
 		slice, err = NewForestDBSlice(path, id, indInst.Defn, indInst.InstId, indInst.Defn.IsPrimary, conf, stats.indexes[indInst.InstId])
 	case common.PlasmaDB:
 		slice, err = NewPlasmaSlice(path, id, indInst.Defn, indInst.InstId, indInst.Defn.IsPrimary, conf, stats.indexes[indInst.InstId])
+	case common.LSMDB:
+		slice, err = NewLSMSlice(path, id, indInst.Defn, indInst.InstId, indInst.Defn.IsPrimary, conf, stats.indexes[indInst.InstId])
 	}
 
 	return
@@ -5201,7 +5398,7 @@ func (idx *indexer) getStorageModeOverride() common.StorageMode {
 func (idx *indexer) getInstIdFromDefnId(defnId common.IndexDefnId) common.IndexInstId {
 
 	for instId, inst := range idx.indexInstMap {
-		if inst.Defn.DefnId == defnId {
+		if inst.Defn.DefnId == defnId && !idx.isShadowInst(instId) {
 			return instId
 		}
 	}
@@ -0,0 +1,459 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Key statistics are estimates of the distribution of keys in a slice,
+// maintained at flush time and persisted along with snapshots:
+//
+//   - distinct keys are counted using a HyperLogLog sketch.
+//   - equi-depth histograms are built from a reservoir sample of keys.
+//   - min and max are bounds of keys inserted into the slice.
+//
+// Keys are collated and stored in index order, i.e. with descending
+// fields reversed. Deleted keys are removed from the sample, and the
+// distinct count is scaled down by the fraction of keys deleted since
+// a sketch cannot forget keys. min and max are not narrowed on deletes.
+
+const (
+	// HyperLogLog precision, 2^12 registers with ~1.6% standard error.
+	keyStatsHllPrecision = 12
+	keyStatsHllRegisters = 1 << keyStatsHllPrecision
+
+	// Maximum number of keys sampled per slice.
+	keyStatsSampleSize = 256
+
+	// Number of equi-depth histogram bins returned for an index.
+	keyStatsHistogramBins = 16
+)
+
+type keyStatistics struct {
+	mu      sync.Mutex
+	hll     hyperLogLog
+	sample  [][]byte // reservoir of sampled keys
+	seen    uint64   // number of keys offered to reservoir
+	deleted uint64   // number of keys deleted
+	min     []byte
+	max     []byte
+	rnd     *rand.Rand
+	data    *keyStatsData // cached Data(), nil if statistics changed
+}
+
+// keyStatsData is the serializable form of keyStatistics, persisted
+// with snapshot information of a slice.
+type keyStatsData struct {
+	Hll     []byte   `json:"hll,omitempty"`
+	Sample  [][]byte `json:"sample,omitempty"`
+	Seen    uint64   `json:"seen,omitempty"`
+	Deleted uint64   `json:"deleted,omitempty"`
+	Min     []byte   `json:"min,omitempty"`
+	Max     []byte   `json:"max,omitempty"`
+}
+
+func newKeyStatistics() *keyStatistics {
+	return &keyStatistics{
+		hll: newHyperLogLog(),
+		rnd: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// AddEntry account the key of a secondary index entry.
+func (ks *keyStatistics) AddEntry(entry secondaryIndexEntry) {
+	ks.Add(entry[:entry.lenKey()])
+}
+
+// Add a collated key, key is copied if retained.
+func (ks *keyStatistics) Add(key []byte) {
+	h := hashKey(key)
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.data = nil
+	ks.hll.add(h)
+	if ks.min == nil || bytes.Compare(key, ks.min) < 0 {
+		ks.min = append(ks.min[:0], key...)
+	}
+	if ks.max == nil || bytes.Compare(key, ks.max) > 0 {
+		ks.max = append(ks.max[:0], key...)
+	}
+
+	ks.seen++
+	if len(ks.sample) < keyStatsSampleSize {
+		ks.sample = append(ks.sample, copyKey(key))
+	} else if i := ks.rnd.Int63n(int64(ks.seen)); i < keyStatsSampleSize {
+		ks.sample[i] = copyKey(key)
+	}
+}
+
+// DeleteEntry account the deleted key of a secondary index entry.
+func (ks *keyStatistics) DeleteEntry(entry secondaryIndexEntry) {
+	ks.Delete(entry[:entry.lenKey()])
+}
+
+// Delete a collated key, one sampled copy of the key is dropped.
+func (ks *keyStatistics) Delete(key []byte) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.data = nil
+	if ks.deleted < ks.seen {
+		ks.deleted++
+	}
+	for i, sampled := range ks.sample {
+		if bytes.Equal(sampled, key) {
+			last := len(ks.sample) - 1
+			ks.sample[i] = ks.sample[last]
+			ks.sample = ks.sample[:last]
+			break
+		}
+	}
+}
+
+// Reset statistics, typically when slice is rolled back to zero.
+func (ks *keyStatistics) Reset() {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.data = nil
+	ks.hll = newHyperLogLog()
+	ks.sample, ks.seen, ks.deleted = nil, 0, 0
+	ks.min, ks.max = nil, nil
+}
+
+// Data returns a copy of statistics that is safe to persist and read,
+// typically taken with every snapshot of the slice. The copy is shared
+// till statistics change and shall not be modified.
+func (ks *keyStatistics) Data() *keyStatsData {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.data != nil {
+		return ks.data
+	}
+	ks.data = &keyStatsData{
+		Hll:     append([]byte(nil), ks.hll...),
+		Sample:  append([][]byte(nil), ks.sample...), // keys are immutable
+		Seen:    ks.seen,
+		Deleted: ks.deleted,
+		Min:     copyKey(ks.min),
+		Max:     copyKey(ks.max),
+	}
+	return ks.data
+}
+
+// Restore statistics persisted with a snapshot, nil data resets the
+// statistics.
+func (ks *keyStatistics) Restore(data *keyStatsData) {
+	if data == nil || len(data.Hll) != keyStatsHllRegisters {
+		ks.Reset()
+		return
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.data = nil
+	ks.hll = append(hyperLogLog(nil), data.Hll...)
+	ks.sample = append([][]byte(nil), data.Sample...)
+	ks.seen, ks.deleted = data.Seen, data.Deleted
+	ks.min, ks.max = copyKey(data.Min), copyKey(data.Max)
+}
+
+func copyKey(key []byte) []byte {
+	if key == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(key)), key...)
+}
+
+func hashKey(key []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	// fnv does not mix high bits well, finalize with splitmix64.
+	x := h.Sum64()
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+//---------------------
+// HyperLogLog sketch
+//---------------------
+
+type hyperLogLog []uint8
+
+func newHyperLogLog() hyperLogLog {
+	return make(hyperLogLog, keyStatsHllRegisters)
+}
+
+func (hll hyperLogLog) add(h uint64) {
+	idx := h >> (64 - keyStatsHllPrecision)
+	w := h<<keyStatsHllPrecision | 1<<(keyStatsHllPrecision-1)
+	rank := uint8(1)
+	for w&(1<<63) == 0 {
+		rank++
+		w <<= 1
+	}
+	if rank > hll[idx] {
+		hll[idx] = rank
+	}
+}
+
+// merge `other` into hll, for union of distinct keys.
+func (hll hyperLogLog) merge(other []byte) {
+	if len(other) != len(hll) {
+		return
+	}
+	for i, r := range other {
+		if r > hll[i] {
+			hll[i] = r
+		}
+	}
+}
+
+func (hll hyperLogLog) estimate() uint64 {
+	m := float64(len(hll))
+	sum, zeros := 0.0, 0
+	for _, r := range hll {
+		sum += 1.0 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros > 0 { // small range correction
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(e + 0.5)
+}
+
+//----------------------------------
+// estimates across slice statistics
+//----------------------------------
+
+type keyStatsBin struct {
+	rows     uint64
+	distinct uint64
+	min, max []byte
+}
+
+type weightedKey struct {
+	key    []byte
+	weight float64
+}
+
+type weightedKeys []weightedKey
+
+func (wk weightedKeys) Len() int           { return len(wk) }
+func (wk weightedKeys) Less(i, j int) bool { return bytes.Compare(wk[i].key, wk[j].key) < 0 }
+func (wk weightedKeys) Swap(i, j int)      { wk[i], wk[j] = wk[j], wk[i] }
+
+// keyStatsEstimator combine statistics of one or more slices, optionally
+// restricted to keys between low and high. Sampled keys are weighted by
+// the number of rows they represent in their slice.
+type keyStatsEstimator struct {
+	low, high []byte // nil if unbounded
+
+	hll      hyperLogLog
+	seen     uint64 // keys inserted into slices
+	deleted  uint64 // keys deleted from slices
+	nsample  int    // sampled keys from all slices, irrespective of range
+	distinct int    // distinct sampled keys in range
+	keys     weightedKeys
+	rows     uint64
+	min, max []byte
+	sorted   bool
+}
+
+func newKeyStatsEstimator(low, high []byte) *keyStatsEstimator {
+	return &keyStatsEstimator{low: low, high: high, hll: newHyperLogLog()}
+}
+
+// Add statistics of a slice with `rows` in the range of estimator.
+func (e *keyStatsEstimator) Add(data *keyStatsData, rows uint64) {
+	e.rows += rows
+	if data == nil {
+		return
+	}
+
+	e.hll.merge(data.Hll)
+	e.seen += data.Seen
+	e.deleted += data.Deleted
+	e.nsample += len(data.Sample)
+	inRange := make([][]byte, 0, len(data.Sample))
+	for _, key := range data.Sample {
+		if e.contains(key) {
+			inRange = append(inRange, key)
+		}
+	}
+	if len(inRange) > 0 {
+		weight := float64(rows) / float64(len(inRange))
+		for _, key := range inRange {
+			e.keys = append(e.keys, weightedKey{key: key, weight: weight})
+		}
+	}
+
+	if e.low == nil && e.high == nil {
+		if data.Min != nil && (e.min == nil || bytes.Compare(data.Min, e.min) < 0) {
+			e.min = data.Min
+		}
+		if data.Max != nil && (e.max == nil || bytes.Compare(data.Max, e.max) > 0) {
+			e.max = data.Max
+		}
+	}
+	e.sorted = false
+}
+
+// contains return true if key is between low and high, comparing only
+// the prefix fields supplied in low and high. Bounds are collated keys,
+// as in scan requests.
+func (e *keyStatsEstimator) contains(key []byte) bool {
+	if e.low != nil && compareKeyPrefix(key, e.low) < 0 {
+		return false
+	}
+	if e.high != nil && compareKeyPrefix(key, e.high) > 0 {
+		return false
+	}
+	return true
+}
+
+// compareKeyPrefix compare key with the fields of bound, like
+// ComparePrefixFields, ignoring the terminator of the collated bound.
+func compareKeyPrefix(key, bound []byte) int {
+	if len(bound) == 0 {
+		return 0
+	}
+	l := len(bound) - 1
+	if len(bound) > len(key) {
+		l = len(key)
+	}
+	return bytes.Compare(key[:l], bound[:l])
+}
+
+func (e *keyStatsEstimator) sort() {
+	if !e.sorted {
+		sort.Sort(e.keys)
+		e.distinct = 0
+		var prev []byte
+		for i, wk := range e.keys {
+			if i == 0 || !bytes.Equal(wk.key, prev) {
+				e.distinct++
+			}
+			prev = wk.key
+		}
+		e.sorted = true
+	}
+}
+
+// Rows returns the number of rows added to estimator.
+func (e *keyStatsEstimator) Rows() uint64 {
+	return e.rows
+}
+
+// MinMax returns the bounds of keys in range, nil if not known.
+func (e *keyStatsEstimator) MinMax() ([]byte, []byte) {
+	if e.low == nil && e.high == nil && e.min != nil {
+		return e.min, e.max
+	}
+	e.sort()
+	if len(e.keys) == 0 {
+		return nil, nil
+	}
+	return e.keys[0].key, e.keys[len(e.keys)-1].key
+}
+
+// Distinct returns the estimated number of distinct keys in range.
+func (e *keyStatsEstimator) Distinct() uint64 {
+	if e.low == nil && e.high == nil {
+		return e.clamp(e.estimate(), e.rows)
+	}
+	var distinct uint64
+	for _, bin := range e.Bins(keyStatsHistogramBins) {
+		distinct += bin.distinct
+	}
+	return distinct
+}
+
+// Bins returns upto `n` equi-depth bins for keys in range, equal keys
+// are never split across bins.
+func (e *keyStatsEstimator) Bins(n int) []keyStatsBin {
+	e.sort()
+	if len(e.keys) == 0 || n <= 0 {
+		return nil
+	}
+
+	// sampled distinct keys scale to estimated distinct keys in index.
+	scale := 1.0
+	if e.nsample > 0 && e.distinct > 0 {
+		fraction := float64(len(e.keys)) / float64(e.nsample)
+		scale = float64(e.estimate()) * fraction / float64(e.distinct)
+	}
+
+	var total float64
+	for _, wk := range e.keys {
+		total += wk.weight
+	}
+	depth := total / float64(n)
+
+	bins := make([]keyStatsBin, 0, n)
+	var acc, weight float64
+	var distinct int
+	from := 0
+	for i, wk := range e.keys {
+		if i == from || !bytes.Equal(wk.key, e.keys[i-1].key) {
+			distinct++
+		}
+		acc, weight = acc+wk.weight, weight+wk.weight
+		last := i == len(e.keys)-1
+		if !last && bytes.Equal(wk.key, e.keys[i+1].key) {
+			continue
+		}
+		if last || acc >= depth*float64(len(bins)+1) {
+			rows := uint64(weight + 0.5)
+			bins = append(bins, keyStatsBin{
+				rows:     rows,
+				distinct: e.clamp(uint64(float64(distinct)*scale+0.5), rows),
+				min:      e.keys[from].key,
+				max:      wk.key,
+			})
+			from, weight, distinct = i+1, 0, 0
+		}
+	}
+	return bins
+}
+
+// estimate distinct keys in slices, sketch counts deleted keys as well.
+func (e *keyStatsEstimator) estimate() uint64 {
+	distinct := e.hll.estimate()
+	if e.deleted > 0 && e.deleted <= e.seen {
+		distinct = uint64(float64(distinct)*float64(e.seen-e.deleted)/float64(e.seen) + 0.5)
+	}
+	return distinct
+}
+
+func (e *keyStatsEstimator) clamp(distinct, rows uint64) uint64 {
+	if distinct > rows {
+		return rows
+	} else if distinct == 0 && rows > 0 {
+		return 1
+	}
+	return distinct
+}
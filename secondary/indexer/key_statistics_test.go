@@ -0,0 +1,185 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// encodeStatsKey collate a JSON array, the way keys are stored in index
+// entries and supplied as scan bounds.
+func encodeStatsKey(t *testing.T, key string) []byte {
+	buf := make([]byte, 0, 3*len(key)+64)
+	code, err := jsonEncoder.Encode([]byte(key), buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte(nil), code...)
+}
+
+func TestKeyStatisticsDistinct(t *testing.T) {
+	ks := newKeyStatistics()
+	distinct := 10000
+	for i := 0; i < 3*distinct; i++ {
+		n := i % distinct
+		ks.Add(encodeStatsKey(t, fmt.Sprintf(`["city-%05d",%d]`, n, n)))
+	}
+
+	e := newKeyStatsEstimator(nil, nil)
+	e.Add(ks.Data(), uint64(3*distinct))
+	estimate := e.Distinct()
+	if estimate < uint64(distinct)*95/100 || estimate > uint64(distinct)*105/100 {
+		t.Errorf("Expected distinct ~%v, received %v", distinct, estimate)
+	}
+
+	min, max := e.MinMax()
+	if !bytes.Equal(min, encodeStatsKey(t, `["city-00000",0]`)) ||
+		!bytes.Equal(max, encodeStatsKey(t, `["city-09999",9999]`)) {
+		t.Errorf("Unexpected bounds %v %v", min, max)
+	}
+}
+
+func TestKeyStatisticsBins(t *testing.T) {
+	ks := newKeyStatistics()
+	rows := 100000
+	for i := 0; i < rows; i++ {
+		ks.Add(encodeStatsKey(t, fmt.Sprintf(`["k%02d",%d]`, i/10000, i)))
+	}
+	if len(ks.Data().Sample) != keyStatsSampleSize {
+		t.Fatalf("Expected sample of %v keys", keyStatsSampleSize)
+	}
+
+	e := newKeyStatsEstimator(nil, nil)
+	e.Add(ks.Data(), uint64(rows))
+	bins := e.Bins(keyStatsHistogramBins)
+	if len(bins) != keyStatsHistogramBins {
+		t.Fatalf("Expected %v bins, received %v", keyStatsHistogramBins, len(bins))
+	}
+
+	var total uint64
+	for i, bin := range bins {
+		total += bin.rows
+		if i > 0 && bytes.Compare(bins[i-1].max, bin.min) >= 0 {
+			t.Errorf("Overlapping bins %v %v", bins[i-1], bin)
+		}
+	}
+	if total < uint64(rows)*99/100 || total > uint64(rows)*101/100 {
+		t.Errorf("Expected %v rows in bins, received %v", rows, total)
+	}
+
+	// range on the leading key, ["k03"] is a prefix of composite keys.
+	bound := encodeStatsKey(t, `["k03"]`)
+	e = newKeyStatsEstimator(bound, bound)
+	e.Add(ks.Data(), 10000)
+	if e.Rows() != 10000 {
+		t.Errorf("Expected 10000 rows, received %v", e.Rows())
+	}
+	bins = e.Bins(keyStatsHistogramBins)
+	if len(bins) == 0 {
+		t.Fatalf("Expected bins for keys in range")
+	}
+	for _, bin := range bins {
+		if compareKeyPrefix(bin.min, bound) != 0 || compareKeyPrefix(bin.max, bound) != 0 {
+			t.Errorf("Bin %v %v out of range", bin.min, bin.max)
+		}
+	}
+}
+
+func TestKeyStatisticsComparePrefix(t *testing.T) {
+	key := encodeStatsKey(t, `["b",10]`)
+	tests := []struct {
+		bound string
+		cmp   int
+	}{
+		{`["b"]`, 0},
+		{`["a"]`, 1},
+		{`["c"]`, -1},
+		{`["b",10]`, 0},
+		{`["b",9]`, 1},
+		{`["b",10,"x"]`, -1},
+	}
+	for _, test := range tests {
+		cmp := compareKeyPrefix(key, encodeStatsKey(t, test.bound))
+		if cmp < 0 {
+			cmp = -1
+		} else if cmp > 0 {
+			cmp = 1
+		}
+		if cmp != test.cmp {
+			t.Errorf("Expected %v for bound %v, received %v", test.cmp, test.bound, cmp)
+		}
+	}
+}
+
+func TestKeyStatisticsDelete(t *testing.T) {
+	ks := newKeyStatistics()
+	keys := make([][]byte, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		key := encodeStatsKey(t, fmt.Sprintf(`["key",%d]`, i))
+		keys = append(keys, key)
+		ks.Add(key)
+	}
+	for _, key := range keys[:500] {
+		ks.Delete(key)
+	}
+
+	data := ks.Data()
+	if data.Seen != 1000 || data.Deleted != 500 {
+		t.Fatalf("Unexpected seen %v deleted %v", data.Seen, data.Deleted)
+	}
+	deleted := make(map[string]bool)
+	for _, key := range keys[:500] {
+		deleted[string(key)] = true
+	}
+	for _, key := range data.Sample {
+		if deleted[string(key)] {
+			t.Fatalf("Deleted key %v in sample", key)
+		}
+	}
+
+	e := newKeyStatsEstimator(nil, nil)
+	e.Add(data, 500)
+	if estimate := e.Distinct(); estimate < 450 || estimate > 500 {
+		t.Errorf("Expected distinct ~500, received %v", estimate)
+	}
+}
+
+func TestKeyStatisticsRestore(t *testing.T) {
+	ks := newKeyStatistics()
+	for i := 0; i < 1000; i++ {
+		ks.Add(encodeStatsKey(t, fmt.Sprintf(`["key",%d]`, i%100)))
+	}
+	ks.Delete(encodeStatsKey(t, `["key",0]`))
+
+	// statistics are shared by snapshots till they change.
+	if ks.Data() != ks.Data() {
+		t.Errorf("Expected statistics to be shared")
+	}
+
+	data, err := json.Marshal(ks.Data())
+	if err != nil {
+		t.Fatal(err)
+	}
+	persisted := &keyStatsData{}
+	if err := json.Unmarshal(data, persisted); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newKeyStatistics()
+	restored.Restore(persisted)
+	if restored.Data().Deleted != 1 {
+		t.Errorf("Expected deletes to be restored")
+	}
+	e1, e2 := newKeyStatsEstimator(nil, nil), newKeyStatsEstimator(nil, nil)
+	e1.Add(ks.Data(), 999)
+	e2.Add(restored.Data(), 999)
+	if e1.Distinct() != e2.Distinct() {
+		t.Errorf("Expected distinct %v, received %v", e1.Distinct(), e2.Distinct())
+	}
+
+	restored.Restore(nil)
+	if data := restored.Data(); data.Seen != 0 || data.Min != nil {
+		t.Errorf("Expected statistics to be reset")
+	}
+}
@@ -1159,16 +1159,17 @@ func convertIndexDefnToProtobuf(indexDefn c.IndexDefn) *protobuf.IndexDefn {
 		protobuf.PartitionScheme_value[string(indexDefn.PartitionScheme)]).Enum()
 
 	defn := &protobuf.IndexDefn{
-		DefnID:          proto.Uint64(uint64(indexDefn.DefnId)),
-		Bucket:          proto.String(indexDefn.Bucket),
-		IsPrimary:       proto.Bool(indexDefn.IsPrimary),
-		Name:            proto.String(indexDefn.Name),
-		Using:           using,
-		ExprType:        exprType,
-		SecExpressions:  indexDefn.SecExprs,
-		PartitionScheme: partnScheme,
-		PartnExpression: proto.String(indexDefn.PartitionKey),
-		WhereExpression: proto.String(indexDefn.WhereExpr),
+		DefnID:             proto.Uint64(uint64(indexDefn.DefnId)),
+		Bucket:             proto.String(indexDefn.Bucket),
+		IsPrimary:          proto.Bool(indexDefn.IsPrimary),
+		Name:               proto.String(indexDefn.Name),
+		Using:              using,
+		ExprType:           exprType,
+		SecExpressions:     indexDefn.SecExprs,
+		PartitionScheme:    partnScheme,
+		PartnExpression:    proto.String(indexDefn.PartitionKey),
+		WhereExpression:    proto.String(indexDefn.WhereExpr),
+		IncludeExpressions: indexDefn.Include,
 	}
 
 	return defn
@@ -1193,8 +1194,8 @@ func addPartnInfoToProtoInst(cfg c.Config, cinfo *c.ClusterInfoCache,
 	switch partn := indexInst.Pc.(type) {
 	case *c.KeyPartitionContainer:
 
-		//Right now the fill the SinglePartition as that is the only
-		//partition structure supported
+		//Fill the HashPartition or RangePartition for partitioned
+		//index, else the SinglePartition
 		partnDefn := partn.GetAllPartitions()
 
 		//TODO move this to indexer init. These addresses cannot change.
@@ -1212,8 +1213,8 @@ func addPartnInfoToProtoInst(cfg c.Config, cinfo *c.ClusterInfoCache,
 		streamInitAddr := net.JoinHostPort(host, cfg["streamInitPort"].String())
 		streamCatchupAddr := net.JoinHostPort(host, cfg["streamCatchupPort"].String())
 
-		var endpoints []string
-		for _, p := range partnDefn {
+		streamEndpoints := func(p c.PartitionDefn) []string {
+			var endpoints []string
 			for _, e := range p.Endpoints() {
 				//Set the right endpoint based on streamId
 				switch streamId {
@@ -1226,6 +1227,32 @@ func addPartnInfoToProtoInst(cfg c.Config, cinfo *c.ClusterInfoCache,
 				}
 				endpoints = append(endpoints, string(e))
 			}
+			return endpoints
+		}
+
+		if indexInst.Defn.PartitionScheme == c.RANGE {
+			rangePartn := protobuf.NewRangePartition(partn.Splits)
+			for _, p := range partnDefn {
+				rangePartn.AddPartitionEndpoints(uint64(p.GetPartitionId()),
+					streamEndpoints(p))
+			}
+			protoInst.RangePartn = rangePartn
+			return
+		}
+
+		if indexInst.Defn.PartitionScheme == c.HASH {
+			hashPartn := protobuf.NewHashPartition(uint32(partn.GetNumPartitions()))
+			for _, p := range partnDefn {
+				hashPartn.AddPartitionEndpoints(uint64(p.GetPartitionId()),
+					streamEndpoints(p))
+			}
+			protoInst.HashPartn = hashPartn
+			return
+		}
+
+		var endpoints []string
+		for _, p := range partnDefn {
+			endpoints = append(endpoints, streamEndpoints(p)...)
 		}
 		protoInst.SinglePartn = &protobuf.SinglePartition{
 			Endpoints: endpoints,
@@ -0,0 +1,1560 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/common/queryutil"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/lsm"
+)
+
+// main and back index share a single lsm store, so that both are
+// persisted atomically.
+const (
+	lsmMainIndex = lsm.Keyspace(0)
+	lsmBackIndex = lsm.Keyspace(1)
+)
+
+//NewLSMSlice initializes a new slice with the pure Go lsm backend.
+//Slice is opened at the latest persisted snapshot. Slice methods are
+//not thread-safe and application needs to handle the synchronization.
+//The only exception being Insert and Delete can be called concurrently.
+func NewLSMSlice(path string, sliceId SliceId, idxDefn common.IndexDefn,
+	idxInstId common.IndexInstId, isPrimary bool,
+	sysconf common.Config, idxStats *IndexStats) (*lsmSlice, error) {
+
+	slice := &lsmSlice{}
+	slice.idxStats = idxStats
+	slice.sysconf = sysconf
+	slice.path = path
+	slice.idxInstId = idxInstId
+	slice.idxDefnId = idxDefn.DefnId
+	slice.idxDefn = idxDefn
+	slice.id = sliceId
+	slice.isPrimary = isPrimary
+
+	var err error
+	if slice.store, err = lsm.Open(path, slice.storeConfig(sysconf)); err != nil {
+		logging.Errorf("LSMSlice:NewLSMSlice Id %v IndexInstId %v Error opening "+
+			"store at %v. Error %v", sliceId, idxInstId, path, err)
+		return nil, err
+	}
+
+	// Array related initialization
+	_, slice.isArrayDistinct, slice.arrayExprPosition, err = queryutil.GetArrayExpressionPosition(idxDefn.SecExprs)
+	if err != nil {
+		slice.store.Close()
+		return nil, err
+	}
+
+	// lsm does not support multiwriters, updates of a docid must be
+	// applied in order
+	slice.numWriters = 1
+	sliceBufSize := sysconf["settings.sliceBufSize"].Uint64()
+	slice.cmdCh = make(chan interface{}, sliceBufSize)
+	slice.workerDone = make([]chan bool, slice.numWriters)
+	slice.stopCh = make([]DoneChannel, slice.numWriters)
+
+	// restore key statistics and item count from the latest snapshot
+	slice.keyStats = newKeyStatistics()
+	if infos, err := slice.getSnapshotsMeta(); err == nil {
+		if info := NewSnapshotInfoContainer(infos).GetLatest(); info != nil {
+			snapInfo := info.(*lsmSnapshotInfo)
+			slice.keyStats.Restore(snapInfo.KeyStats)
+			slice.itemCount = snapInfo.ItemCount
+			slice.committedCount = uint64(snapInfo.ItemCount)
+		}
+	}
+
+	for i := 0; i < slice.numWriters; i++ {
+		slice.stopCh[i] = make(DoneChannel)
+		slice.workerDone[i] = make(chan bool)
+		go slice.handleCommandsWorker(i)
+	}
+
+	logging.Infof("LSMSlice:NewLSMSlice Created New Slice Id %v IndexInstId %v "+
+		"WriterThreads %v", sliceId, idxInstId, slice.numWriters)
+
+	return slice, nil
+}
+
+//lsmSlice represents a slice with lsm backend
+type lsmSlice struct {
+	get_bytes, insert_bytes, delete_bytes int64
+	//flushed count
+	flushedCount uint64
+	// persisted items count
+	committedCount uint64
+	// items in main index
+	itemCount int64
+
+	qCount int64
+
+	path string
+	id   SliceId //slice id
+
+	refCount int
+	lock     sync.RWMutex
+	store    *lsm.Store
+
+	idxDefn   common.IndexDefn
+	idxDefnId common.IndexDefnId
+	idxInstId common.IndexInstId
+
+	status        SliceStatus
+	isActive      bool
+	isDirty       bool
+	isPrimary     bool
+	isSoftDeleted bool
+	isSoftClosed  bool
+
+	cmdCh  chan interface{} //internal channel to buffer commands
+	stopCh []DoneChannel    //internal channel to signal shutdown
+
+	workerDone []chan bool //worker status check channel
+
+	fatalDbErr error //store any fatal DB error
+
+	numWriters int //number of writer threads
+
+	totalFlushTime  time.Duration
+	totalCommitTime time.Duration
+
+	idxStats *IndexStats
+	sysconf  common.Config
+	confLock sync.RWMutex
+
+	// Array processing
+	arrayExprPosition int
+	isArrayDistinct   bool
+
+	keyStats *keyStatistics
+}
+
+func (slice *lsmSlice) storeConfig(sysconf common.Config) lsm.Config {
+	cfg := lsm.DefaultConfig()
+	cfg.MemtableSize = int64(sysconf["lsm.memtableSize"].Int())
+	cfg.MaxSegments = sysconf["lsm.maxSegments"].Int()
+	cfg.BlockSize = sysconf["lsm.blockSize"].Int()
+	cfg.BloomBitsPerKey = sysconf["lsm.bloomBitsPerKey"].Int()
+	cfg.KeepManifests = sysconf["settings.lsm.recovery.max_rollbacks"].Int()
+	if cfg.KeepManifests < 1 {
+		cfg.KeepManifests = 1
+	}
+	return cfg
+}
+
+func (slice *lsmSlice) IncrRef() {
+	slice.lock.Lock()
+	defer slice.lock.Unlock()
+
+	slice.refCount++
+}
+
+func (slice *lsmSlice) DecrRef() {
+	slice.lock.Lock()
+	defer slice.lock.Unlock()
+
+	slice.refCount--
+	if slice.refCount == 0 {
+		if slice.isSoftClosed {
+			tryCloseLSMSlice(slice)
+		}
+		if slice.isSoftDeleted {
+			tryDeleteLSMSlice(slice)
+		}
+	}
+}
+
+//Insert will insert the given key/value pair from slice.
+//Internally the request is buffered and executed async.
+//If lsm has encountered any fatal error condition,
+//it will be returned as error.
+func (slice *lsmSlice) Insert(rawKey []byte, docid []byte, payload []byte, meta *MutationMeta) error {
+	key, err := GetIndexEntryBytes(rawKey, docid, payload, slice.idxDefn.IsPrimary, slice.idxDefn.IsArrayIndex, 1, slice.idxDefn.Desc)
+	if err != nil {
+		return err
+	}
+
+	slice.idxStats.numDocsFlushQueued.Add(1)
+	atomic.AddInt64(&slice.qCount, 1)
+	slice.cmdCh <- &indexItem{key: key, rawKey: rawKey, docid: docid}
+	return slice.fatalDbErr
+}
+
+//Delete will delete the given document from slice.
+//Internally the request is buffered and executed async.
+//If lsm has encountered any fatal error condition,
+//it will be returned as error.
+func (slice *lsmSlice) Delete(docid []byte, meta *MutationMeta) error {
+	slice.idxStats.numDocsFlushQueued.Add(1)
+	atomic.AddInt64(&slice.qCount, 1)
+	slice.cmdCh <- docid
+	return slice.fatalDbErr
+}
+
+//handleCommandsWorker keeps listening to any buffered
+//write requests for the slice and processes
+//those. This will shut itself down internal
+//shutdown channel is closed.
+func (slice *lsmSlice) handleCommandsWorker(workerId int) {
+
+	var start time.Time
+	var elapsed time.Duration
+	var c interface{}
+	var icmd *indexItem
+	var dcmd []byte
+
+loop:
+	for {
+		var nmut int
+		select {
+		case c = <-slice.cmdCh:
+			switch c.(type) {
+			case *indexItem:
+				icmd = c.(*indexItem)
+				start = time.Now()
+				nmut = slice.insert((*icmd).key, (*icmd).rawKey, (*icmd).docid)
+				elapsed = time.Since(start)
+				slice.totalFlushTime += elapsed
+
+			case []byte:
+				dcmd = c.([]byte)
+				start = time.Now()
+				nmut = slice.delete(dcmd)
+				elapsed = time.Since(start)
+				slice.totalFlushTime += elapsed
+
+			default:
+				logging.Errorf("LSMSlice::handleCommandsWorker \n\tSliceId %v IndexInstId %v Received "+
+					"Unknown Command %v", slice.id, slice.idxInstId, c)
+			}
+
+			slice.idxStats.numItemsFlushed.Add(int64(nmut))
+			slice.idxStats.numDocsIndexed.Add(1)
+			atomic.AddInt64(&slice.qCount, -1)
+
+		case <-slice.stopCh[workerId]:
+			slice.stopCh[workerId] <- true
+			break loop
+
+			//worker gets a status check message on this channel, it responds
+			//when its not processing any mutation
+		case <-slice.workerDone[workerId]:
+			slice.workerDone[workerId] <- true
+
+		}
+	}
+}
+
+//insert does the actual insert in lsm
+func (slice *lsmSlice) insert(key []byte, rawKey []byte, docid []byte) int {
+	var nmut int
+
+	if slice.isPrimary {
+		nmut = slice.insertPrimaryIndex(key, docid)
+	} else if !slice.idxDefn.IsArrayIndex {
+		nmut = slice.insertSecIndex(key, docid)
+	} else {
+		nmut = slice.insertSecArrayIndex(key, rawKey, docid)
+	}
+
+	slice.logWriterStat()
+	return nmut
+}
+
+func (slice *lsmSlice) insertPrimaryIndex(key []byte, docid []byte) int {
+
+	logging.Tracef("LSMSlice::insert \n\tSliceId %v IndexInstId %v Set Key - %s", slice.id, slice.idxInstId, docid)
+
+	//check if the docid exists in the main index
+	t0 := time.Now()
+	_, found, err := slice.store.Get(lsmMainIndex, key)
+	slice.idxStats.Timings.stKVGet.Put(time.Now().Sub(t0))
+	if err != nil {
+		slice.checkFatalDbError(err)
+		logging.Errorf("LSMSlice::insert \n\tSliceId %v IndexInstId %v Error locating "+
+			"mainindex entry %v", slice.id, slice.idxInstId, err)
+	} else if found {
+		//skip
+		logging.Tracef("LSMSlice::insert \n\tSliceId %v IndexInstId %v Key %v Already Exists. "+
+			"Primary Index Update Skipped.", slice.id, slice.idxInstId, string(docid))
+	} else {
+		//set in main index
+		t0 := time.Now()
+		slice.store.Set(lsmMainIndex, key, nil)
+		slice.idxStats.Timings.stKVSet.Put(time.Now().Sub(t0))
+		atomic.AddInt64(&slice.insert_bytes, int64(len(key)))
+		atomic.AddInt64(&slice.itemCount, 1)
+		slice.isDirty = true
+	}
+
+	return 1
+}
+
+func (slice *lsmSlice) insertSecIndex(key []byte, docid []byte) (nmut int) {
+	var err error
+	var oldkey []byte
+
+	//check if the docid exists in the back index
+	if oldkey, err = slice.getBackIndexEntry(docid); err != nil {
+		slice.checkFatalDbError(err)
+		logging.Errorf("LSMSlice::insert \n\tSliceId %v IndexInstId %v Error locating "+
+			"backindex entry %v", slice.id, slice.idxInstId, err)
+		return
+	} else if oldkey != nil {
+		//If old-key from backindex matches with the new-key
+		//in mutation, skip it.
+		if bytes.Equal(oldkey, key) {
+			logging.Tracef("LSMSlice::insert \n\tSliceId %v IndexInstId %v Received Unchanged Key for "+
+				"Doc Id %v. Key %v. Skipped.", slice.id, slice.idxInstId, string(docid), key)
+			return
+		}
+
+		//there is already an entry in main index for this docid
+		//delete from main index
+		t0 := time.Now()
+		slice.store.Delete(lsmMainIndex, oldkey)
+		slice.keyStats.DeleteEntry(secondaryIndexEntry(oldkey))
+		slice.idxStats.Timings.stKVDelete.Put(time.Now().Sub(t0))
+		atomic.AddInt64(&slice.delete_bytes, int64(len(oldkey)))
+		atomic.AddInt64(&slice.itemCount, -1)
+
+		// If a field value changed from "existing" to "missing" (ie, key = nil),
+		// we need to remove back index entry corresponding to the previous "existing" value.
+		if key == nil {
+			t0 := time.Now()
+			slice.store.Delete(lsmBackIndex, docid)
+			slice.idxStats.Timings.stKVDelete.Put(time.Now().Sub(t0))
+			atomic.AddInt64(&slice.delete_bytes, int64(len(docid)))
+		}
+		slice.isDirty = true
+	}
+
+	if key == nil {
+		logging.Tracef("LSMSlice::insert \n\tSliceId %v IndexInstId %v Received NIL Key for "+
+			"Doc Id %s. Skipped.", slice.id, slice.idxInstId, docid)
+		return
+	}
+
+	//set the back index entry <docid, encodedkey>
+	t0 := time.Now()
+	slice.store.Set(lsmBackIndex, docid, key)
+	slice.idxStats.Timings.stKVSet.Put(time.Now().Sub(t0))
+	atomic.AddInt64(&slice.insert_bytes, int64(len(docid)+len(key)))
+
+	//set in main index
+	t0 = time.Now()
+	slice.store.Set(lsmMainIndex, key, nil)
+	slice.idxStats.Timings.stKVSet.Put(time.Now().Sub(t0))
+	atomic.AddInt64(&slice.insert_bytes, int64(len(key)))
+	atomic.AddInt64(&slice.itemCount, 1)
+	slice.keyStats.AddEntry(secondaryIndexEntry(key))
+	slice.isDirty = true
+
+	nmut = 1
+	return
+}
+
+func (slice *lsmSlice) insertSecArrayIndex(key []byte, rawKey []byte, docid []byte) (nmut int) {
+	var err error
+	var oldkey []byte
+
+	//check if the docid exists in the back index and Get old key from back index
+	if oldkey, err = slice.getBackIndexEntry(docid); err != nil {
+		slice.checkFatalDbError(err)
+		logging.Errorf("LSMSlice::insert \n\tSliceId %v IndexInstId %v Error locating "+
+			"backindex entry %v", slice.id, slice.idxInstId, err)
+		return
+	}
+
+	var oldEntriesBytes, newEntriesBytes [][]byte
+	var oldKeyCount, newKeyCount []int
+	if oldkey != nil {
+		if bytes.Equal(oldkey, key) {
+			logging.Tracef("LSMSlice::insert \n\tSliceId %v IndexInstId %v Received Unchanged Key for "+
+				"Doc Id %s. Key %v. Skipped.", slice.id, slice.idxInstId, docid, key)
+			return
+		}
+
+		var tmpBuf []byte
+		// If old key is larger than max array limit, always handle it
+		if len(oldkey) > maxArrayIndexEntrySize {
+			// Allocate thrice the size of old key for array explosion
+			tmpBuf = make([]byte, 0, len(oldkey)*3)
+		} else {
+			tmpBufPtr := arrayEncBufPool.Get()
+			defer arrayEncBufPool.Put(tmpBufPtr)
+			tmpBuf = (*tmpBufPtr)[:0]
+		}
+
+		//get the key in original form
+		if slice.idxDefn.Desc != nil {
+			jsonEncoder.ReverseCollate(oldkey, slice.idxDefn.Desc)
+		}
+
+		if oldEntriesBytes, oldKeyCount, _, err = ArrayIndexItems(oldkey, slice.arrayExprPosition,
+			tmpBuf, slice.isArrayDistinct, false); err != nil {
+			logging.Errorf("LSMSlice::insert SliceId %v IndexInstId %v Error in retrieving "+
+				"compostite old secondary keys. Skipping docid:%s Error: %v", slice.id, slice.idxInstId, docid, err)
+			return slice.deleteSecArrayIndex(docid)
+		}
+	}
+	if key != nil {
+
+		//get the key in original form
+		if slice.idxDefn.Desc != nil {
+			jsonEncoder.ReverseCollate(key, slice.idxDefn.Desc)
+		}
+
+		tmpBufPtr := arrayEncBufPool.Get()
+		defer arrayEncBufPool.Put(tmpBufPtr)
+		newEntriesBytes, newKeyCount, _, err = ArrayIndexItems(key, slice.arrayExprPosition,
+			(*tmpBufPtr)[:0], slice.isArrayDistinct, true)
+		if err != nil {
+			logging.Errorf("LSMSlice::insert SliceId %v IndexInstId %v Error in creating "+
+				"compostite new secondary keys. Skipping docid:%s Error: %v", slice.id, slice.idxInstId, docid, err)
+			return slice.deleteSecArrayIndex(docid)
+		}
+	}
+
+	var indexEntriesToBeAdded, indexEntriesToBeDeleted [][]byte
+	if len(oldEntriesBytes) == 0 { // It is a new key. Nothing to delete
+		indexEntriesToBeDeleted = nil
+		indexEntriesToBeAdded = newEntriesBytes
+	} else if len(newEntriesBytes) == 0 { // New key is nil. Nothing to add
+		indexEntriesToBeAdded = nil
+		indexEntriesToBeDeleted = oldEntriesBytes
+	} else {
+		indexEntriesToBeAdded, indexEntriesToBeDeleted = CompareArrayEntriesWithCount(newEntriesBytes, oldEntriesBytes, newKeyCount, oldKeyCount)
+	}
+
+	nmut = 0
+
+	// Form entries to be deleted from main index
+	var keysToBeDeleted [][]byte
+	for i, item := range indexEntriesToBeDeleted {
+		if item != nil { // nil item indicates it should not be deleted
+			var keyToBeDeleted []byte
+			var tmpBuf []byte
+			tmpBufPtr := encBufPool.Get()
+			defer encBufPool.Put(tmpBufPtr)
+
+			if len(item)+MAX_KEY_EXTRABYTES_LEN > maxSecKeyBufferLen {
+				tmpBuf = make([]byte, 0, len(item)+MAX_KEY_EXTRABYTES_LEN)
+			} else {
+				tmpBuf = (*tmpBufPtr)[:0]
+			}
+			if keyToBeDeleted, err = GetIndexEntryBytes3(item, docid, false, false,
+				oldKeyCount[i], slice.idxDefn.Desc, tmpBuf); err != nil {
+
+				encBufPool.Put(tmpBufPtr)
+				logging.Errorf("LSMSlice::insert SliceId %v IndexInstId %v Error forming entry "+
+					"to be deleted from main index. Skipping docid:%s Error: %v", slice.id, slice.idxInstId, docid, err)
+				return slice.deleteSecArrayIndex(docid)
+			}
+			keysToBeDeleted = append(keysToBeDeleted, keyToBeDeleted)
+		}
+	}
+
+	// Form entries to be inserted into main index
+	var keysToBeAdded [][]byte
+	for i, item := range indexEntriesToBeAdded {
+		if item != nil { // nil item indicates it should not be added
+			var keyToBeAdded []byte
+			tmpBufPtr := encBufPool.Get()
+			defer encBufPool.Put(tmpBufPtr)
+			if keyToBeAdded, err = GetIndexEntryBytes2(item, docid, false, false,
+				newKeyCount[i], slice.idxDefn.Desc, (*tmpBufPtr)[:0]); err != nil {
+
+				encBufPool.Put(tmpBufPtr)
+				logging.Errorf("LSMSlice::insert SliceId %v IndexInstId %v Error forming entry "+
+					"to be added to main index. Skipping docid:%s Error: %v", slice.id, slice.idxInstId, docid, err)
+				return slice.deleteSecArrayIndex(docid)
+			}
+			keysToBeAdded = append(keysToBeAdded, keyToBeAdded)
+		}
+	}
+
+	for _, keyToBeDeleted := range keysToBeDeleted {
+		t0 := time.Now()
+		slice.store.Delete(lsmMainIndex, keyToBeDeleted)
+		slice.keyStats.DeleteEntry(secondaryIndexEntry(keyToBeDeleted))
+		slice.idxStats.Timings.stKVDelete.Put(time.Now().Sub(t0))
+		atomic.AddInt64(&slice.delete_bytes, int64(len(keyToBeDeleted)))
+		atomic.AddInt64(&slice.itemCount, -1)
+		nmut++
+	}
+
+	for _, keyToBeAdded := range keysToBeAdded {
+		t0 := time.Now()
+		//set in main index
+		slice.store.Set(lsmMainIndex, keyToBeAdded, nil)
+		slice.idxStats.Timings.stKVSet.Put(time.Now().Sub(t0))
+		atomic.AddInt64(&slice.insert_bytes, int64(len(keyToBeAdded)))
+		atomic.AddInt64(&slice.itemCount, 1)
+		slice.keyStats.AddEntry(secondaryIndexEntry(keyToBeAdded))
+		nmut++
+	}
+
+	// If a field value changed from "existing" to "missing" (ie, key = nil),
+	// we need to remove back index entry corresponding to the previous "existing" value.
+	if key == nil {
+		t0 := time.Now()
+		slice.store.Delete(lsmBackIndex, docid)
+		slice.idxStats.Timings.stKVDelete.Put(time.Now().Sub(t0))
+		atomic.AddInt64(&slice.delete_bytes, int64(len(docid)))
+	} else { //set the back index entry <docid, encodedkey>
+
+		//convert to storage format
+		if slice.idxDefn.Desc != nil {
+			jsonEncoder.ReverseCollate(key, slice.idxDefn.Desc)
+		}
+
+		t0 := time.Now()
+		slice.store.Set(lsmBackIndex, docid, key)
+		slice.idxStats.Timings.stKVSet.Put(time.Now().Sub(t0))
+		atomic.AddInt64(&slice.insert_bytes, int64(len(docid)+len(key)))
+	}
+
+	slice.isDirty = true
+	return nmut
+}
+
+//delete does the actual delete in lsm
+func (slice *lsmSlice) delete(docid []byte) int {
+	var nmut int
+
+	if slice.isPrimary {
+		nmut = slice.deletePrimaryIndex(docid)
+	} else if !slice.idxDefn.IsArrayIndex {
+		nmut = slice.deleteSecIndex(docid)
+	} else {
+		nmut = slice.deleteSecArrayIndex(docid)
+	}
+
+	slice.logWriterStat()
+	return nmut
+}
+
+func (slice *lsmSlice) deletePrimaryIndex(docid []byte) (nmut int) {
+
+	if docid == nil {
+		common.CrashOnError(errors.New("Nil Primary Key"))
+		return
+	}
+
+	//docid -> key format
+	entry, err := NewPrimaryIndexEntry(docid)
+	common.CrashOnError(err)
+
+	//check if the docid exists, deletes of documents that were
+	//never indexed are common during initial build
+	t0 := time.Now()
+	_, found, err := slice.store.Get(lsmMainIndex, entry.Bytes())
+	slice.idxStats.Timings.stKVGet.Put(time.Now().Sub(t0))
+	if err != nil {
+		slice.checkFatalDbError(err)
+		logging.Errorf("LSMSlice::delete \n\tSliceId %v IndexInstId %v. Error locating "+
+			"mainindex entry for Doc %s. Error %v", slice.id, slice.idxInstId, docid, err)
+		return
+	} else if !found {
+		return
+	}
+
+	//delete from main index
+	t0 = time.Now()
+	slice.store.Delete(lsmMainIndex, entry.Bytes())
+	slice.idxStats.Timings.stKVDelete.Put(time.Now().Sub(t0))
+	atomic.AddInt64(&slice.delete_bytes, int64(len(entry.Bytes())))
+	atomic.AddInt64(&slice.itemCount, -1)
+	slice.isDirty = true
+
+	return 1
+}
+
+func (slice *lsmSlice) deleteSecIndex(docid []byte) (nmut int) {
+
+	var olditm []byte
+	var err error
+
+	if olditm, err = slice.getBackIndexEntry(docid); err != nil {
+		slice.checkFatalDbError(err)
+		logging.Errorf("LSMSlice::delete \n\tSliceId %v IndexInstId %v. Error locating "+
+			"backindex entry for Doc %s. Error %v", slice.id, slice.idxInstId, docid, err)
+		return
+	}
+
+	//if the oldkey is nil, nothing needs to be done. This is the case of deletes
+	//which happened before index was created.
+	if olditm == nil {
+		logging.Tracef("LSMSlice::delete \n\tSliceId %v IndexInstId %v Received NIL Key for "+
+			"Doc Id %v. Skipped.", slice.id, slice.idxInstId, docid)
+		return
+	}
+
+	//delete from main index
+	t0 := time.Now()
+	slice.store.Delete(lsmMainIndex, olditm)
+	slice.keyStats.DeleteEntry(secondaryIndexEntry(olditm))
+	slice.idxStats.Timings.stKVDelete.Put(time.Now().Sub(t0))
+	atomic.AddInt64(&slice.delete_bytes, int64(len(olditm)))
+	atomic.AddInt64(&slice.itemCount, -1)
+
+	//delete from the back index
+	t0 = time.Now()
+	slice.store.Delete(lsmBackIndex, docid)
+	slice.idxStats.Timings.stKVDelete.Put(time.Now().Sub(t0))
+	atomic.AddInt64(&slice.delete_bytes, int64(len(docid)))
+	slice.isDirty = true
+	return 1
+}
+
+func (slice *lsmSlice) deleteSecArrayIndex(docid []byte) (nmut int) {
+	var olditm []byte
+	var err error
+
+	if olditm, err = slice.getBackIndexEntry(docid); err != nil {
+		slice.checkFatalDbError(err)
+		logging.Errorf("LSMSlice::delete \n\tSliceId %v IndexInstId %v. Error locating "+
+			"backindex entry for Doc %s. Error %v", slice.id, slice.idxInstId, docid, err)
+		return
+	}
+
+	if olditm == nil {
+		logging.Tracef("LSMSlice::delete \n\tSliceId %v IndexInstId %v Received NIL Key for "+
+			"Doc Id %v. Skipped.", slice.id, slice.idxInstId, docid)
+		return
+	}
+
+	var tmpBuf []byte
+	// If old key is larger than max array limit, always handle it
+	if len(olditm) > maxArrayIndexEntrySize {
+		// Allocate thrice the size of old key for array explosion
+		tmpBuf = make([]byte, 0, len(olditm)*3)
+	} else {
+		tmpBufPtr := arrayEncBufPool.Get()
+		defer arrayEncBufPool.Put(tmpBufPtr)
+		tmpBuf = (*tmpBufPtr)[:0]
+	}
+
+	//get the key in original form
+	if slice.idxDefn.Desc != nil {
+		jsonEncoder.ReverseCollate(olditm, slice.idxDefn.Desc)
+	}
+
+	indexEntriesToBeDeleted, keyCount, _, err := ArrayIndexItems(olditm, slice.arrayExprPosition,
+		tmpBuf, slice.isArrayDistinct, false)
+
+	if err != nil {
+		slice.checkFatalDbError(err)
+		logging.Errorf("LSMSlice::insert \n\tSliceId %v IndexInstId %v Error in retrieving "+
+			"compostite old secondary keys %v", slice.id, slice.idxInstId, err)
+		return
+	}
+
+	// Delete each of indexEntriesToBeDeleted from main index
+	for i, item := range indexEntriesToBeDeleted {
+		var keyToBeDeleted []byte
+		var tmpBuf []byte
+
+		tmpBufPtr := encBufPool.Get()
+		defer encBufPool.Put(tmpBufPtr)
+
+		if len(item)+MAX_KEY_EXTRABYTES_LEN > maxSecKeyBufferLen {
+			tmpBuf = make([]byte, 0, len(item)+MAX_KEY_EXTRABYTES_LEN)
+		} else {
+			tmpBuf = (*tmpBufPtr)[:0]
+		}
+		if keyToBeDeleted, err = GetIndexEntryBytes3(item, docid, false, false, keyCount[i],
+			slice.idxDefn.Desc, tmpBuf); err != nil {
+			encBufPool.Put(tmpBufPtr)
+			slice.checkFatalDbError(err)
+			logging.Errorf("LSMSlice::insert \n\tSliceId %v IndexInstId %v Error from GetIndexEntryBytes3 "+
+				"for entry to be deleted from main index %v", slice.id, slice.idxInstId, err)
+			return
+		}
+		t0 := time.Now()
+		slice.store.Delete(lsmMainIndex, keyToBeDeleted)
+		slice.keyStats.DeleteEntry(secondaryIndexEntry(keyToBeDeleted))
+		slice.idxStats.Timings.stKVDelete.Put(time.Now().Sub(t0))
+		atomic.AddInt64(&slice.delete_bytes, int64(len(keyToBeDeleted)))
+		atomic.AddInt64(&slice.itemCount, -1)
+	}
+
+	//delete from the back index
+	t0 := time.Now()
+	slice.store.Delete(lsmBackIndex, docid)
+	slice.idxStats.Timings.stKVDelete.Put(time.Now().Sub(t0))
+	atomic.AddInt64(&slice.delete_bytes, int64(len(docid)))
+	slice.isDirty = true
+	return len(indexEntriesToBeDeleted)
+}
+
+//getBackIndexEntry returns a copy of an existing back index
+//entry given the docid. Bloom filters of segment files let
+//lookup of new docids skip the disk.
+func (slice *lsmSlice) getBackIndexEntry(docid []byte) ([]byte, error) {
+
+	t0 := time.Now()
+	kbytes, found, err := slice.store.Get(lsmBackIndex, docid)
+	slice.idxStats.Timings.stKVGet.Put(time.Now().Sub(t0))
+	if err != nil || !found {
+		return nil, err
+	}
+	atomic.AddInt64(&slice.get_bytes, int64(len(kbytes)))
+
+	//entry is owned by the store, caller may modify the key
+	return append([]byte(nil), kbytes...), nil
+}
+
+//checkFatalDbError checks if the error returned from DB
+//is fatal and stores it. This error will be returned
+//to caller on next DB operation
+func (slice *lsmSlice) checkFatalDbError(err error) {
+
+	//panic on all DB errors and recover rather than risk
+	//inconsistent db state
+	common.CrashOnError(err)
+
+	if err == lsm.ErrCorruptSegment {
+		slice.fatalDbErr = err
+	}
+}
+
+// Creates an open snapshot handle from snapshot info
+// Snapshot info is obtained from NewSnapshot() or GetSnapshots() API
+// Returns error if snapshot handle cannot be created.
+func (slice *lsmSlice) OpenSnapshot(info SnapshotInfo) (Snapshot, error) {
+	snapInfo := info.(*lsmSnapshotInfo)
+
+	s := &lsmSnapshot{slice: slice,
+		idxDefnId: slice.idxDefnId,
+		idxInstId: slice.idxInstId,
+		ts:        snapInfo.Timestamp(),
+		info:      snapInfo,
+		committed: info.IsCommitted(),
+	}
+
+	t0 := time.Now()
+	if snapInfo.snap != nil {
+		// snapshot created by NewSnapshot
+		s.snap, snapInfo.snap = snapInfo.snap, nil
+	} else {
+		var err error
+		if s.snap, err = slice.store.OpenSnapshot(snapInfo.Seq); err != nil {
+			logging.Errorf("LSMSlice::OpenSnapshot SliceId %v IndexInstId %v Error "+
+				"Opening Snapshot %v. Error %v", slice.id, slice.idxInstId, snapInfo, err)
+			return nil, err
+		}
+	}
+	if s.committed {
+		slice.idxStats.Timings.stPersistSnapshotCreate.Put(time.Now().Sub(t0))
+	} else {
+		slice.idxStats.Timings.stSnapshotCreate.Put(time.Now().Sub(t0))
+	}
+
+	slice.IncrRef()
+	atomic.StoreInt32(&s.refCount, 1)
+
+	logging.Infof("LSMSlice::OpenSnapshot SliceId %v IndexInstId %v Creating New "+
+		"Snapshot %v", slice.id, slice.idxInstId, snapInfo)
+
+	return s, nil
+}
+
+func (slice *lsmSlice) GetCommittedCount() uint64 {
+	return atomic.LoadUint64(&slice.committedCount)
+}
+
+//Rollback slice to given snapshot. Return error if
+//not possible
+func (slice *lsmSlice) Rollback(info SnapshotInfo) error {
+
+	//before rollback make sure there are no mutations
+	//in the slice buffer. Timekeeper will make sure there
+	//are no flush workers before calling rollback.
+	slice.waitPersist()
+
+	qc := atomic.LoadInt64(&slice.qCount)
+	if qc > 0 {
+		common.CrashOnError(errors.New("Slice Invariant Violation - rollback with pending mutations"))
+	}
+
+	snapInfo := info.(*lsmSnapshotInfo)
+	if err := slice.store.Rollback(snapInfo.Seq); err != nil {
+		logging.Errorf("LSMSlice::Rollback \n\tSliceId %v IndexInstId %v. Error Rollback "+
+			"to Snapshot %v. Error %v", slice.id, slice.idxInstId, info, err)
+		return err
+	}
+
+	atomic.StoreInt64(&slice.itemCount, snapInfo.ItemCount)
+	atomic.StoreUint64(&slice.committedCount, uint64(snapInfo.ItemCount))
+	slice.keyStats.Restore(snapInfo.KeyStats)
+	return nil
+}
+
+//RollbackToZero rollbacks the slice to initial state. Return error if
+//not possible
+func (slice *lsmSlice) RollbackToZero() error {
+
+	slice.waitPersist()
+	slice.store.Reset()
+
+	atomic.StoreInt64(&slice.itemCount, 0)
+	atomic.StoreUint64(&slice.committedCount, 0)
+	slice.keyStats.Reset()
+	return nil
+}
+
+//slice insert/delete methods are async. There
+//can be outstanding mutations in internal queue to flush even
+//after insert/delete have return success to caller.
+//This method provides a mechanism to wait till internal
+//queue is empty.
+func (slice *lsmSlice) waitPersist() {
+
+	if !slice.checkAllWorkersDone() {
+		//every SLICE_COMMIT_POLL_INTERVAL milliseconds,
+		//check for outstanding mutations. If there are
+		//none, proceed with the commit.
+		slice.confLock.RLock()
+		commitPollInterval := slice.sysconf["storage.lsm.commitPollInterval"].Uint64()
+		slice.confLock.RUnlock()
+		ticker := time.NewTicker(time.Millisecond * time.Duration(commitPollInterval))
+		defer ticker.Stop()
+
+		for _ = range ticker.C {
+			if slice.checkAllWorkersDone() {
+				break
+			}
+		}
+	}
+
+}
+
+//NewSnapshot creates an in-memory snapshot of the slice, the
+//snapshot is persisted to disk if commit is requested. If
+//NewSnapshot returns error, slice should be rolled back to
+//previous snapshot.
+func (slice *lsmSlice) NewSnapshot(ts *common.TsVbuuid, commit bool) (SnapshotInfo, error) {
+
+	flushStart := time.Now()
+	slice.waitPersist()
+	flushTime := time.Since(flushStart)
+
+	qc := atomic.LoadInt64(&slice.qCount)
+	if qc > 0 {
+		common.CrashOnError(errors.New("Slice Invariant Violation - commit with pending mutations"))
+	}
+
+	slice.isDirty = false
+
+	newSnapshotInfo := &lsmSnapshotInfo{
+		Ts:        ts,
+		Committed: commit,
+		ItemCount: atomic.LoadInt64(&slice.itemCount),
+		snap:      slice.store.NewSnapshot(),
+	}
+	if !slice.isPrimary {
+		newSnapshotInfo.KeyStats = slice.keyStats.Data()
+	}
+	atomic.StoreUint64(&slice.committedCount, uint64(newSnapshotInfo.ItemCount))
+
+	if commit {
+		meta, err := json.Marshal(newSnapshotInfo)
+		if err != nil {
+			newSnapshotInfo.snap.Close()
+			return nil, err
+		}
+
+		start := time.Now()
+		newSnapshotInfo.Seq, err = slice.store.Persist(newSnapshotInfo.snap, meta)
+		elapsed := time.Since(start)
+		slice.idxStats.Timings.stCommit.Put(elapsed)
+
+		slice.totalCommitTime += elapsed
+		logging.Infof("LSMSlice::Commit SliceId %v IndexInstId %v FlushTime %v CommitTime %v TotalFlushTime %v "+
+			"TotalCommitTime %v", slice.id, slice.idxInstId, flushTime, elapsed, slice.totalFlushTime, slice.totalCommitTime)
+
+		if err != nil {
+			logging.Errorf("LSMSlice::Commit \n\tSliceId %v IndexInstId %v Error in "+
+				"Index Commit %v", slice.id, slice.idxInstId, err)
+			newSnapshotInfo.snap.Close()
+			return nil, err
+		}
+	}
+
+	return newSnapshotInfo, nil
+}
+
+//checkAllWorkersDone return true if all workers have
+//finished processing
+func (slice *lsmSlice) checkAllWorkersDone() bool {
+
+	//if there are mutations in the cmdCh, workers are
+	//not yet done
+	qc := atomic.LoadInt64(&slice.qCount)
+	if qc > 0 {
+		return false
+	}
+
+	//worker queue is empty, make sure both workers are done
+	//processing the last mutation
+	for i := 0; i < slice.numWriters; i++ {
+		slice.workerDone[i] <- true
+		<-slice.workerDone[i]
+	}
+	return true
+}
+
+func (slice *lsmSlice) Close() {
+	slice.lock.Lock()
+	defer slice.lock.Unlock()
+
+	logging.Infof("LSMSlice::Close Closing Slice Id %v, IndexInstId %v, "+
+		"IndexDefnId %v", slice.id, slice.idxInstId, slice.idxDefnId)
+
+	//signal shutdown for command handler routines
+	for i := 0; i < slice.numWriters; i++ {
+		slice.stopCh[i] <- true
+		<-slice.stopCh[i]
+	}
+
+	if slice.refCount > 0 {
+		slice.isSoftClosed = true
+	} else {
+		tryCloseLSMSlice(slice)
+	}
+}
+
+//Destroy removes the database files from disk.
+//Slice is not recoverable after this.
+func (slice *lsmSlice) Destroy() {
+	slice.lock.Lock()
+	defer slice.lock.Unlock()
+
+	if slice.refCount > 0 {
+		logging.Infof("LSMSlice::Destroy Softdeleted Slice Id %v, IndexInstId %v, "+
+			"IndexDefnId %v", slice.id, slice.idxInstId, slice.idxDefnId)
+		slice.isSoftDeleted = true
+	} else {
+		tryDeleteLSMSlice(slice)
+	}
+}
+
+//Id returns the Id for this Slice
+func (slice *lsmSlice) Id() SliceId {
+	return slice.id
+}
+
+// Path returns the directory of this Slice
+func (slice *lsmSlice) Path() string {
+	return slice.path
+}
+
+//IsActive returns if the slice is active
+func (slice *lsmSlice) IsActive() bool {
+	return slice.isActive
+}
+
+//SetActive sets the active state of this slice
+func (slice *lsmSlice) SetActive(isActive bool) {
+	slice.isActive = isActive
+}
+
+//Status returns the status for this slice
+func (slice *lsmSlice) Status() SliceStatus {
+	return slice.status
+}
+
+//SetStatus set new status for this slice
+func (slice *lsmSlice) SetStatus(status SliceStatus) {
+	slice.status = status
+}
+
+//IndexInstId returns the Index InstanceId this
+//slice is associated with
+func (slice *lsmSlice) IndexInstId() common.IndexInstId {
+	return slice.idxInstId
+}
+
+//IndexDefnId returns the Index DefnId this slice
+//is associated with
+func (slice *lsmSlice) IndexDefnId() common.IndexDefnId {
+	return slice.idxDefnId
+}
+
+// Returns snapshot info list
+func (slice *lsmSlice) GetSnapshots() ([]SnapshotInfo, error) {
+	return slice.getSnapshotsMeta()
+}
+
+// IsDirty returns true if there has been any change in
+// in the slice storage after last in-mem/persistent snapshot
+func (slice *lsmSlice) IsDirty() bool {
+	slice.waitPersist()
+	return slice.isDirty
+}
+
+//Compact merges segment files of the slice, it is done in steps
+//so that compaction can be stopped once it runs past abortTime.
+func (slice *lsmSlice) Compact(abortTime time.Time) error {
+	slice.IncrRef()
+	defer slice.DecrRef()
+
+	if !slice.canRunCompaction(abortTime) {
+		logging.Infof("LSMSlice::Skip Compaction outside of compaction interval."+
+			"Slice Id %v, IndexInstId %v, IndexDefnId %v", slice.id, slice.idxInstId, slice.idxDefnId)
+		return nil
+	}
+
+	before := slice.store.Statistics()
+	stop := func() bool {
+		slice.lock.RLock()
+		closed := slice.isSoftClosed || slice.isSoftDeleted
+		slice.lock.RUnlock()
+		return closed || !slice.canRunCompaction(abortTime)
+	}
+	err := slice.store.Compact(stop)
+	after := slice.store.Statistics()
+
+	logging.Infof("LSMSlice::Compact Slice Id %v, IndexInstId %v, IndexDefnId %v "+
+		"Segments %v -> %v DiskSize %v -> %v Error %v", slice.id, slice.idxInstId,
+		slice.idxDefnId, before.NumSegments, after.NumSegments, before.DiskSize,
+		after.DiskSize, err)
+	return err
+}
+
+func (slice *lsmSlice) Statistics() (StorageStatistics, error) {
+	var sts StorageStatistics
+
+	st := slice.store.Statistics()
+	sts.DataSize = st.DataSize
+	sts.DiskSize = st.DiskSize
+	// segments retained only by older snapshots
+	if st.DiskSize > st.DataSize {
+		sts.ExtraSnapDataSize = st.DiskSize - st.DataSize
+	}
+
+	sts.GetBytes = atomic.LoadInt64(&slice.get_bytes)
+	sts.InsertBytes = atomic.LoadInt64(&slice.insert_bytes)
+	sts.DeleteBytes = atomic.LoadInt64(&slice.delete_bytes)
+
+	if logging.IsEnabled(logging.Timing) {
+		hits, misses := lsm.BlockCacheStats()
+		sts.InternalData = append(sts.InternalData, fmt.Sprintf(
+			"{\"runs\":%v,\"segments\":%v,\"mem_size\":%v,\"flushes\":%v,"+
+				"\"compactions\":%v,\"bytes_written\":%v,\"cache_hits\":%v,\"cache_misses\":%v}",
+			st.NumRuns, st.NumSegments, st.MemSize, st.NumFlushes, st.NumCompacts,
+			st.BytesWritten, hits, misses))
+	}
+
+	return sts, nil
+}
+
+func (slice *lsmSlice) UpdateConfig(cfg common.Config) {
+	slice.confLock.Lock()
+	defer slice.confLock.Unlock()
+
+	slice.sysconf = cfg
+}
+
+func (slice *lsmSlice) String() string {
+
+	str := fmt.Sprintf("SliceId: %v ", slice.id)
+	str += fmt.Sprintf("Path: %v ", slice.path)
+	str += fmt.Sprintf("Index: %v ", slice.idxInstId)
+
+	return str
+
+}
+
+//getSnapshotsMeta returns snapshot info of persisted snapshots,
+//snapshot info is stored as meta of the store manifest.
+func (slice *lsmSlice) getSnapshotsMeta() ([]SnapshotInfo, error) {
+	var snapList []SnapshotInfo
+
+	for _, m := range slice.store.Manifests() {
+		info := &lsmSnapshotInfo{}
+		if err := json.Unmarshal(m.Meta, info); err != nil {
+			return snapList, errors.New("Failed to retrieve snapshots list -" + err.Error())
+		}
+		info.Seq = m.Seq
+		snapList = append(snapList, info)
+	}
+
+	return snapList, nil
+}
+
+func tryDeleteLSMSlice(slice *lsmSlice) {
+	logging.Infof("LSMSlice::Destroy Destroying Slice Id %v, IndexInstId %v, "+
+		"IndexDefnId %v", slice.id, slice.idxInstId, slice.idxDefnId)
+
+	//cleanup the disk directory
+	if err := os.RemoveAll(slice.path); err != nil {
+		logging.Errorf("LSMSlice::Destroy Error Cleaning Up Slice Id %v, "+
+			"IndexInstId %v, IndexDefnId %v. Error %v", slice.id, slice.idxInstId, slice.idxDefnId, err)
+	}
+}
+
+func tryCloseLSMSlice(slice *lsmSlice) {
+	slice.store.Close()
+}
+
+func (slice *lsmSlice) logWriterStat() {
+	count := atomic.AddUint64(&slice.flushedCount, 1)
+	if (count%10000 == 0) || count == 1 {
+		logging.Infof("logWriterStat:: %v "+
+			"FlushedCount %v QueuedCount %v", slice.idxInstId,
+			count, len(slice.cmdCh))
+	}
+
+}
+
+func (slice *lsmSlice) canRunCompaction(abortTime time.Time) bool {
+
+	slice.confLock.RLock()
+	defer slice.confLock.RUnlock()
+
+	// Once compaction starts, only need to find out if it past the end date.
+	mode := strings.ToLower(slice.sysconf["settings.compaction.compaction_mode"].String())
+	abort := slice.sysconf["settings.compaction.abort_exceed_interval"].Bool()
+	interval := slice.sysconf["settings.compaction.interval"].String()
+
+	// No need to stop running compaction if in full compaction mode
+	if mode == "full" {
+		return true
+	}
+
+	// No need to stop compaction if it is ok to exceed time interval
+	if !abort {
+		return true
+	}
+
+	if time.Now().After(abortTime) {
+		return false
+	}
+
+	var start_hr, start_min, end_hr, end_min int
+	n, err := fmt.Sscanf(interval, "%d:%d,%d:%d", &start_hr, &start_min, &end_hr, &end_min)
+	start_min += start_hr * 60
+	end_min += end_hr * 60
+
+	if n == 4 && err == nil && end_min != 0 {
+		hr, min, _ := time.Now().Clock()
+		min += hr * 60
+
+		// If end time is next day from current time, add minutes.
+		if start_min > end_min && min > start_min {
+			end_min += 24 * 60
+		}
+
+		if min > end_min {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (slice *lsmSlice) GetReaderContext() IndexReaderContext {
+	return &cursorCtx{}
+}
+
+// ==============================
+// Snapshot implementation
+// ==============================
+
+type lsmSnapshotInfo struct {
+	Ts        *common.TsVbuuid
+	Committed bool
+	ItemCount int64
+	KeyStats  *keyStatsData `json:",omitempty"`
+
+	// sequence number of the persisted snapshot in store
+	Seq uint64 `json:"-"`
+	// snapshot created by NewSnapshot, until it is opened
+	snap *lsm.Snapshot
+}
+
+func (info *lsmSnapshotInfo) Timestamp() *common.TsVbuuid {
+	return info.Ts
+}
+
+func (info *lsmSnapshotInfo) IsCommitted() bool {
+	return info.Committed
+}
+
+func (info *lsmSnapshotInfo) String() string {
+	return fmt.Sprintf("SnapshotInfo: seq: %v count: %v committed:%v", info.Seq,
+		info.ItemCount, info.Committed)
+}
+
+type lsmSnapshot struct {
+	slice *lsmSlice
+	snap  *lsm.Snapshot
+	info  *lsmSnapshotInfo
+
+	idxDefnId common.IndexDefnId //index definition id
+	idxInstId common.IndexInstId //index instance id
+	ts        *common.TsVbuuid   //timestamp
+	committed bool
+
+	refCount int32 //Reader count for this snapshot
+}
+
+func (s *lsmSnapshot) Open() error {
+	atomic.AddInt32(&s.refCount, int32(1))
+
+	return nil
+}
+
+func (s *lsmSnapshot) IsOpen() bool {
+
+	count := atomic.LoadInt32(&s.refCount)
+	return count > 0
+}
+
+func (s *lsmSnapshot) Id() SliceId {
+	return s.slice.Id()
+}
+
+func (s *lsmSnapshot) IndexInstId() common.IndexInstId {
+	return s.idxInstId
+}
+
+func (s *lsmSnapshot) IndexDefnId() common.IndexDefnId {
+	return s.idxDefnId
+}
+
+func (s *lsmSnapshot) Timestamp() *common.TsVbuuid {
+	return s.ts
+}
+
+//Close the snapshot
+func (s *lsmSnapshot) Close() error {
+
+	count := atomic.AddInt32(&s.refCount, int32(-1))
+
+	if count < 0 {
+		logging.Errorf("LSMSnapshot::Close Close operation requested " +
+			"on already closed snapshot")
+		return errors.New("Snapshot Already Closed")
+
+	} else if count == 0 {
+		go s.Destroy()
+	}
+
+	return nil
+}
+
+func (s *lsmSnapshot) Destroy() {
+
+	defer s.slice.DecrRef()
+
+	t0 := time.Now()
+	s.snap.Close()
+	if !s.committed {
+		s.slice.idxStats.Timings.stSnapshotClose.Put(time.Now().Sub(t0))
+	}
+}
+
+func (s *lsmSnapshot) String() string {
+
+	str := fmt.Sprintf("Index: %v ", s.idxInstId)
+	str += fmt.Sprintf("SliceId: %v ", s.slice.Id())
+	str += fmt.Sprintf("Seq: %v ", s.info.Seq)
+	str += fmt.Sprintf("TS: %v ", s.ts)
+	return str
+}
+
+func (s *lsmSnapshot) Info() SnapshotInfo {
+	return s.info
+}
+
+// ==============================
+// Snapshot reader implementation
+// ==============================
+
+// Approximate items count
+func (s *lsmSnapshot) StatCountTotal() (uint64, error) {
+	c := s.slice.GetCommittedCount()
+	return c, nil
+}
+
+func (s *lsmSnapshot) KeyStatistics() *keyStatsData {
+	return s.info.KeyStats
+}
+
+func (s *lsmSnapshot) CountTotal(ctx IndexReaderContext, stopch StopChannel) (uint64, error) {
+	return s.CountRange(ctx, MinIndexKey, MaxIndexKey, Both, stopch)
+}
+
+func (s *lsmSnapshot) CountRange(ctx IndexReaderContext, low, high IndexKey, inclusion Inclusion,
+	stopch StopChannel) (uint64, error) {
+
+	var count uint64
+	callb := func([]byte) error {
+		select {
+		case <-stopch:
+			return common.ErrClientCancel
+		default:
+			count++
+		}
+
+		return nil
+	}
+
+	err := s.Range(ctx, low, high, inclusion, callb)
+	return count, err
+}
+
+func (s *lsmSnapshot) MultiScanCount(ctx IndexReaderContext, low, high IndexKey, inclusion Inclusion,
+	scan Scan, distinct bool,
+	stopch StopChannel) (uint64, error) {
+
+	var err error
+	var scancount uint64
+	count := 1
+	checkDistinct := distinct && !s.isPrimary()
+	isIndexComposite := len(s.slice.idxDefn.SecExprs) > 1
+
+	buf := secKeyBufPool.Get()
+	defer secKeyBufPool.Put(buf)
+
+	previousRow := ctx.GetCursorKey()
+
+	revbuf := secKeyBufPool.Get()
+	defer secKeyBufPool.Put(revbuf)
+
+	callb := func(entry []byte) error {
+		select {
+		case <-stopch:
+			return common.ErrClientCancel
+		default:
+			skipRow := false
+			var ck [][]byte
+
+			//get the key in original format
+			if s.slice.idxDefn.Desc != nil {
+				revbuf := (*revbuf)[:0]
+				//copy is required, entries are owned by the store
+				revbuf = append(revbuf, entry...)
+				jsonEncoder.ReverseCollate(revbuf, s.slice.idxDefn.Desc)
+				entry = revbuf
+			}
+			if scan.ScanType == FilterRangeReq {
+				if len(entry) > cap(*buf) {
+					*buf = make([]byte, 0, len(entry)+RESIZE_PAD)
+				}
+
+				skipRow, ck, err = filterScanRow(entry, scan, (*buf)[:0])
+				if err != nil {
+					return err
+				}
+			}
+			if skipRow {
+				return nil
+			}
+
+			if checkDistinct {
+				if isIndexComposite {
+					entry, err = projectLeadingKey(ck, entry, buf)
+				}
+				if len(*previousRow) != 0 && distinctCompare(entry, *previousRow) {
+					return nil // Ignore the entry as it is same as previous entry
+				}
+			}
+
+			if !s.isPrimary() {
+				e := secondaryIndexEntry(entry)
+				count = e.Count()
+			}
+
+			if checkDistinct {
+				scancount++
+				*previousRow = append((*previousRow)[:0], entry...)
+			} else {
+				scancount += uint64(count)
+			}
+		}
+		return nil
+	}
+
+	e := s.Range(ctx, low, high, inclusion, callb)
+	return scancount, e
+}
+
+func (s *lsmSnapshot) CountLookup(ctx IndexReaderContext, keys []IndexKey, stopch StopChannel) (uint64, error) {
+	var err error
+	var count uint64
+
+	callb := func([]byte) error {
+		select {
+		case <-stopch:
+			return common.ErrClientCancel
+		default:
+			count++
+		}
+
+		return nil
+	}
+
+	for _, k := range keys {
+		if err = s.Lookup(ctx, k, callb); err != nil {
+			break
+		}
+	}
+
+	return count, err
+}
+
+func (s *lsmSnapshot) Exists(ctx IndexReaderContext, key IndexKey, stopch StopChannel) (bool, error) {
+	var count uint64
+	callb := func([]byte) error {
+		select {
+		case <-stopch:
+			return common.ErrClientCancel
+		default:
+			count++
+		}
+
+		return nil
+	}
+
+	err := s.Lookup(ctx, key, callb)
+	return count != 0, err
+}
+
+func (s *lsmSnapshot) Lookup(ctx IndexReaderContext, key IndexKey, callb EntryCallback) error {
+	return s.Iterate(ctx, key, key, Both, compareExact, callb)
+}
+
+func (s *lsmSnapshot) Range(ctx IndexReaderContext, low, high IndexKey, inclusion Inclusion,
+	callb EntryCallback) error {
+
+	var cmpFn CmpEntry
+	if s.isPrimary() {
+		cmpFn = compareExact
+	} else {
+		cmpFn = comparePrefix
+	}
+
+	return s.Iterate(ctx, low, high, inclusion, cmpFn, callb)
+}
+
+func (s *lsmSnapshot) All(ctx IndexReaderContext, callb EntryCallback) error {
+	return s.Range(ctx, MinIndexKey, MaxIndexKey, Both, callb)
+}
+
+func (s *lsmSnapshot) Iterate(ctx IndexReaderContext, low, high IndexKey, inclusion Inclusion,
+	cmpFn CmpEntry, callback EntryCallback) error {
+
+	var entry IndexEntry
+	var err error
+	t0 := time.Now()
+	it := s.snap.NewIterator(lsmMainIndex)
+	defer it.Close()
+
+	defer func() {
+		s.slice.idxStats.Timings.stScanPipelineIterate.Put(time.Now().Sub(t0))
+	}()
+
+	if low.Bytes() == nil {
+		it.SeekFirst()
+	} else {
+		it.Seek(low.Bytes())
+
+		// Discard equal keys if low inclusion is requested
+		if inclusion == Neither || inclusion == High {
+			err = s.iterEqualKeys(low, it, cmpFn, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	s.slice.idxStats.Timings.stNewIterator.Put(time.Since(t0))
+
+loop:
+	for ; it.Valid(); it.Next() {
+		entry = s.newIndexEntry(it.Key())
+
+		// Iterator has reached past the high key, no need to scan further
+		if cmpFn(high, entry) <= 0 {
+			break loop
+		}
+
+		err = callback(it.Key())
+		if err != nil {
+			return err
+		}
+	}
+
+	// Include equal keys if high inclusion is requested
+	if inclusion == Both || inclusion == High {
+		err = s.iterEqualKeys(high, it, cmpFn, callback)
+		if err != nil {
+			return err
+		}
+	}
+
+	return it.Err()
+}
+
+func (s *lsmSnapshot) isPrimary() bool {
+	return s.slice.isPrimary
+}
+
+func (s *lsmSnapshot) newIndexEntry(b []byte) IndexEntry {
+	var entry IndexEntry
+	var err error
+
+	if s.slice.isPrimary {
+		entry, err = BytesToPrimaryIndexEntry(b)
+	} else {
+		entry, err = BytesToSecondaryIndexEntry(b)
+	}
+	common.CrashOnError(err)
+	return entry
+}
+
+func (s *lsmSnapshot) iterEqualKeys(k IndexKey, it *lsm.Iterator,
+	cmpFn CmpEntry, callback func([]byte) error) error {
+	var err error
+
+	var entry IndexEntry
+	for ; it.Valid(); it.Next() {
+		entry = s.newIndexEntry(it.Key())
+		if cmpFn(k, entry) == 0 {
+			if callback != nil {
+				err = callback(it.Key())
+				if err != nil {
+					return err
+				}
+			}
+		} else {
+			break
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,208 @@
+package indexer
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func newTestLSMSlice(t *testing.T, path string) *lsmSlice {
+	stats := &IndexStats{}
+	stats.Init()
+	cfg := common.SystemConfig.SectionConfig("indexer.", true)
+	idxDefn := common.IndexDefn{
+		DefnId:   common.IndexDefnId(1),
+		SecExprs: []string{"name"},
+	}
+	slice, err := NewLSMSlice(path, SliceId(0), idxDefn,
+		common.IndexInstId(1), false, cfg, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return slice
+}
+
+// lsmSliceEntries returns "docid:key" of entries in snapshot `info`.
+func lsmSliceEntries(t *testing.T, slice *lsmSlice, info SnapshotInfo) []string {
+	snap, err := slice.OpenSnapshot(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+
+	entries := make([]string, 0)
+	err = snap.All(slice.GetReaderContext(), func(entry []byte) error {
+		e := secondaryIndexEntry(entry)
+		docid, _ := e.ReadDocId(nil)
+		key, err := e.ReadSecKey(make([]byte, 0, 1024))
+		if err != nil {
+			return err
+		}
+		entries = append(entries, string(docid)+":"+string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// waitLSMSnapshots waits for closed snapshots to release the slice.
+func waitLSMSnapshots(t *testing.T, slice *lsmSlice) {
+	for i := 0; i < 500; i++ {
+		slice.lock.Lock()
+		refCount := slice.refCount
+		slice.lock.Unlock()
+		if refCount == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("snapshots not closed")
+}
+
+func TestLSMSliceInsertDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lsmslice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	slice := newTestLSMSlice(t, dir)
+	meta := NewMutationMeta()
+	defer meta.Free()
+
+	for _, doc := range []string{"doc1", "doc2", "doc3"} {
+		if err := slice.Insert([]byte(`["`+doc+`"]`), []byte(doc), nil, meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts := common.NewTsVbuuid("default", 4)
+	ts.Seqnos[0] = 3
+	committed, err := slice.NewSnapshot(ts, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{`doc1:["doc1"]`, `doc2:["doc2"]`, `doc3:["doc3"]`}
+	if entries := lsmSliceEntries(t, slice, committed); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %v, received %v", expected, entries)
+	}
+
+	// update replaces the old entry of the document.
+	slice.Insert([]byte(`["updated"]`), []byte("doc1"), nil, meta)
+	slice.Delete([]byte("doc2"), meta)
+	info, err := slice.NewSnapshot(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{`doc1:["updated"]`, `doc3:["doc3"]`}
+	if entries := lsmSliceEntries(t, slice, info); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %v, received %v", expected, entries)
+	}
+	if count := slice.GetCommittedCount(); count != 2 {
+		t.Fatalf("expected 2 items, received %v", count)
+	}
+
+	// rollback to the persisted snapshot.
+	infos, err := slice.GetSnapshots()
+	if err != nil || len(infos) != 1 {
+		t.Fatalf("unexpected snapshots %v %v", infos, err)
+	}
+	if err := slice.Rollback(infos[0]); err != nil {
+		t.Fatal(err)
+	}
+	if count := slice.GetCommittedCount(); count != 3 {
+		t.Fatalf("expected 3 items after rollback, received %v", count)
+	}
+	info, err = slice.NewSnapshot(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{`doc1:["doc1"]`, `doc2:["doc2"]`, `doc3:["doc3"]`}
+	if entries := lsmSliceEntries(t, slice, info); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %v after rollback, received %v", expected, entries)
+	}
+
+	// back index is rolled back too, delete removes the old entry.
+	slice.Delete([]byte("doc1"), meta)
+	info, err = slice.NewSnapshot(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{`doc2:["doc2"]`, `doc3:["doc3"]`}
+	if entries := lsmSliceEntries(t, slice, info); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %v, received %v", expected, entries)
+	}
+
+	if err := slice.RollbackToZero(); err != nil {
+		t.Fatal(err)
+	}
+	info, err = slice.NewSnapshot(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := lsmSliceEntries(t, slice, info); len(entries) != 0 {
+		t.Fatalf("expected no entries after rollback to zero, received %v", entries)
+	}
+	waitLSMSnapshots(t, slice)
+	slice.Close()
+}
+
+func TestLSMSliceRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lsmslice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	slice := newTestLSMSlice(t, dir)
+	meta := NewMutationMeta()
+	defer meta.Free()
+
+	for _, doc := range []string{"doc1", "doc2"} {
+		slice.Insert([]byte(`["`+doc+`"]`), []byte(doc), nil, meta)
+	}
+	ts := common.NewTsVbuuid("default", 4)
+	ts.Seqnos[1] = 2
+	if _, err := slice.NewSnapshot(ts, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// mutations after the persisted snapshot are lost on restart.
+	slice.Insert([]byte(`["doc3"]`), []byte("doc3"), nil, meta)
+	info, err := slice.NewSnapshot(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := slice.OpenSnapshot(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap.Close()
+	waitLSMSnapshots(t, slice)
+	slice.Close()
+
+	slice = newTestLSMSlice(t, dir)
+	defer slice.Close()
+	infos, err := slice.GetSnapshots()
+	if err != nil || len(infos) != 1 {
+		t.Fatalf("unexpected snapshots %v %v", infos, err)
+	}
+	if seqno := infos[0].Timestamp().Seqnos[1]; seqno != 2 {
+		t.Fatalf("expected snapshot at seqno 2, received %v", seqno)
+	}
+	if count := slice.GetCommittedCount(); count != 2 {
+		t.Fatalf("expected 2 items, received %v", count)
+	}
+	expected := []string{`doc1:["doc1"]`, `doc2:["doc2"]`}
+	if entries := lsmSliceEntries(t, slice, infos[0]); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %v, received %v", expected, entries)
+	}
+	waitLSMSnapshots(t, slice)
+}
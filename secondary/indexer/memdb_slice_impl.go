@@ -44,24 +44,27 @@ const (
 const tmpDirName = ".tmp"
 
 type indexMutation struct {
-	op    int
-	key   []byte
-	docid []byte
+	op      int
+	key     []byte
+	docid   []byte
+	payload []byte
 }
 
 func docIdFromEntryBytes(e []byte) []byte {
 	offset := len(e) - 2
 	l := binary.LittleEndian.Uint16(e[offset : offset+2])
-	// Length & 0111111 00000000
-	// as MSB of length is used to indicate presence of count
-	docidlen := int(l & 0x7fff)
-	offset = len(e) - 1
-	if (e[offset] & 0x80) == 0x80 { // if count is encoded
-		offset = len(e) - docidlen - 4
-	} else {
-		offset = len(e) - docidlen - 2
+	// Length & 0011111 11111111
+	// as 2 MSBs of length are used to indicate presence of count and payload
+	docidlen := int(l & 0x3fff)
+	flags := e[len(e)-1]
+	if (flags & 0x80) == 0x80 { // if count is encoded
+		offset -= 2
+	}
+	if (flags & 0x40) == 0x40 { // if payload is encoded
+		plen := int(binary.LittleEndian.Uint16(e[offset-2 : offset]))
+		offset -= plen + 2
 	}
-	return e[offset : offset+docidlen]
+	return e[offset-docidlen : offset]
 }
 
 func entryBytesFromDocId(docid []byte) []byte {
@@ -134,6 +137,11 @@ type memdbSlice struct {
 	// Each table is only operated by the writer owner
 	back []*nodetable.NodeTable
 
+	// One docid filter per back index table, nil if disabled.
+	// Filters are rebuilt along with back index when loading a
+	// snapshot from disk.
+	docidFilters []*docidFilter
+
 	idxDefn   common.IndexDefn
 	idxDefnId common.IndexDefnId
 	idxInstId common.IndexInstId
@@ -165,12 +173,23 @@ type memdbSlice struct {
 
 	isPersistorActive int32
 
+	// Last persisted snapshot, kept open as base of the next delta
+	// snapshot along with its directory and the number of delta
+	// snapshots it is chained to. Holding it delays garbage collection
+	// of items deleted since, until the next snapshot is persisted.
+	deltaLock  sync.Mutex
+	deltaBase  *memdb.Snapshot
+	deltaDir   string
+	deltaCount int
+
 	// Array processing
 	arrayExprPosition int
 	isArrayDistinct   bool
 
 	encodeBuf [][]byte
 	arrayBuf  [][]byte
+
+	keyStats *keyStatistics
 }
 
 func NewMemDBSlice(path string, sliceId SliceId, idxDefn common.IndexDefn,
@@ -220,6 +239,7 @@ func NewMemDBSlice(path string, sliceId SliceId, idxDefn common.IndexDefn,
 
 	slice.isPrimary = isPrimary
 	slice.hasPersistence = hasPersistance
+	slice.keyStats = newKeyStatistics()
 	slice.initStores()
 
 	// Array related initialization
@@ -263,7 +283,24 @@ func (slice *memdbSlice) initStores() {
 		for i := 0; i < slice.numWriters; i++ {
 			slice.back[i] = nodetable.New(hashDocId, nodeEquality)
 		}
+
+		slice.docidFilters = nil
+		if slice.sysconf["settings.docidFilter.enable"].Bool() {
+			capacity := slice.sysconf["settings.docidFilter.initialCapacity"].Uint64()
+			slice.docidFilters = make([]*docidFilter, slice.numWriters)
+			for i := 0; i < slice.numWriters; i++ {
+				slice.docidFilters[i] = newDocidFilter(capacity)
+			}
+		}
+	}
+}
+
+// docidFilter of a writer, nil if filter is disabled.
+func (mdb *memdbSlice) docidFilter(workerId int) *docidFilter {
+	if mdb.docidFilters == nil {
+		return nil
 	}
+	return mdb.docidFilters[workerId]
 }
 
 func (mdb *memdbSlice) IncrRef() {
@@ -288,11 +325,12 @@ func (mdb *memdbSlice) DecrRef() {
 	}
 }
 
-func (mdb *memdbSlice) Insert(key []byte, docid []byte, meta *MutationMeta) error {
+func (mdb *memdbSlice) Insert(key []byte, docid []byte, payload []byte, meta *MutationMeta) error {
 	mut := indexMutation{
-		op:    opUpdate,
-		key:   key,
-		docid: docid,
+		op:      opUpdate,
+		key:     key,
+		docid:   docid,
+		payload: payload,
 	}
 	atomic.AddInt64(&mdb.qCount, 1)
 	mdb.cmdCh[int(meta.vbucket)%mdb.numWriters] <- mut
@@ -320,7 +358,7 @@ loop:
 			switch icmd.op {
 			case opUpdate:
 				start = time.Now()
-				nmut = mdb.insert(icmd.key, icmd.docid, workerId)
+				nmut = mdb.insert(icmd.key, icmd.docid, icmd.payload, workerId)
 				elapsed = time.Since(start)
 				mdb.totalFlushTime += elapsed
 
@@ -350,7 +388,7 @@ loop:
 	}
 }
 
-func (mdb *memdbSlice) insert(key []byte, docid []byte, workerId int) int {
+func (mdb *memdbSlice) insert(key []byte, docid []byte, payload []byte, workerId int) int {
 	var nmut int
 
 	if mdb.isPrimary {
@@ -361,7 +399,7 @@ func (mdb *memdbSlice) insert(key []byte, docid []byte, workerId int) int {
 		if mdb.idxDefn.IsArrayIndex {
 			nmut = mdb.insertSecArrayIndex(key, docid, workerId)
 		} else {
-			nmut = mdb.insertSecIndex(key, docid, workerId)
+			nmut = mdb.insertSecIndex(key, docid, payload, workerId)
 		}
 	}
 
@@ -382,16 +420,16 @@ func (mdb *memdbSlice) insertPrimaryIndex(key []byte, docid []byte, workerId int
 	return 1
 }
 
-func (mdb *memdbSlice) insertSecIndex(key []byte, docid []byte, workerId int) int {
+func (mdb *memdbSlice) insertSecIndex(key []byte, docid []byte, payload []byte, workerId int) int {
 	// 1. Insert entry into main index
 	// 2. Upsert into backindex with docid, mainnode pointer
 	// 3. Delete old entry from main index if back index had
 	// a previous mainnode pointer entry
 	t0 := time.Now()
 
-	mdb.encodeBuf[workerId] = resizeEncodeBuf(mdb.encodeBuf[workerId], len(key), allowLargeKeys)
-	entry, err := NewSecondaryIndexEntry(key, docid, mdb.idxDefn.IsArrayIndex,
-		1, mdb.idxDefn.Desc, mdb.encodeBuf[workerId])
+	mdb.encodeBuf[workerId] = resizeEncodeBuf(mdb.encodeBuf[workerId], len(key)+len(payload), allowLargeKeys)
+	entry, err := NewSecondaryIndexEntry3(key, docid, payload, mdb.idxDefn.IsArrayIndex,
+		1, mdb.idxDefn.Desc, mdb.encodeBuf[workerId], true)
 	if err != nil {
 		logging.Errorf("MemDBSlice::insertSecIndex Slice Id %v IndexInstId %v "+
 			"Skipping docid:%s (%v)", mdb.Id, mdb.idxInstId, docid, err)
@@ -404,11 +442,19 @@ func (mdb *memdbSlice) insertSecIndex(key []byte, docid []byte, workerId int) in
 
 	// Insert succeeded. Failure means same entry already exist.
 	if newNode != nil {
-		if updated, oldNode := mdb.back[workerId].Update(entry, unsafe.Pointer(newNode)); updated {
+		mdb.keyStats.AddEntry(entry)
+		filter := mdb.docidFilter(workerId)
+		if !mayHaveBackIndexEntry(filter, docid, mdb.idxStats) {
+			mdb.back[workerId].Insert(entry, unsafe.Pointer(newNode))
+			filter.Add(docid)
+		} else if updated, oldNode := mdb.back[workerId].Update(entry, unsafe.Pointer(newNode)); updated {
 			t0 := time.Now()
-			mdb.main[workerId].DeleteNode((*skiplist.Node)(oldNode))
+			mdb.deleteNode(workerId, (*skiplist.Node)(oldNode))
 			mdb.idxStats.Timings.stKVDelete.Put(time.Since(t0))
 			atomic.AddInt64(&mdb.delete_bytes, int64(len(docid)))
+		} else if filter != nil {
+			mdb.idxStats.docidFilterFalsePositives.Add(1)
+			filter.Add(docid)
 		}
 	}
 
@@ -443,7 +489,14 @@ func (mdb *memdbSlice) insertSecArrayIndex(keys []byte, docid []byte, workerId i
 	// Remove should be done before performing delete of nodes (SMR)
 	// Otherwise, by the time back update happens the pointing node
 	// may be freed and update operation may crash.
-	_, ptr := mdb.back[workerId].Remove(lookupentry)
+	var ptr unsafe.Pointer
+	filter := mdb.docidFilter(workerId)
+	if mayHaveBackIndexEntry(filter, docid, mdb.idxStats) {
+		_, ptr = mdb.back[workerId].Remove(lookupentry)
+		if ptr == nil && filter != nil {
+			mdb.idxStats.docidFilterFalsePositives.Add(1)
+		}
+	}
 
 	list := memdb.NewNodeList((*skiplist.Node)(ptr))
 	oldEntriesBytes := list.Keys()
@@ -469,7 +522,7 @@ func (mdb *memdbSlice) insertSecArrayIndex(keys []byte, docid []byte, workerId i
 		entriesToRemove := list.Keys()
 		for _, item := range entriesToRemove {
 			node := list.Remove(item)
-			mdb.main[workerId].DeleteNode(node)
+			mdb.deleteNode(workerId, node)
 		}
 		mdb.isDirty = true
 		return 0
@@ -495,7 +548,7 @@ func (mdb *memdbSlice) insertSecArrayIndex(keys []byte, docid []byte, workerId i
 				return emptyList()
 			}
 			node := list.Remove(entry)
-			mdb.main[workerId].DeleteNode(node)
+			mdb.deleteNode(workerId, node)
 			nmut++
 		}
 	}
@@ -516,6 +569,7 @@ func (mdb *memdbSlice) insertSecArrayIndex(keys []byte, docid []byte, workerId i
 			nmut++
 			if newNode != nil { // Ignore if duplicate key
 				list.Add(newNode)
+				mdb.keyStats.AddEntry(entry)
 				mdb.idxStats.Timings.stKVSet.Put(time.Now().Sub(t0))
 				atomic.AddInt64(&mdb.insert_bytes, int64(len(entry)))
 			}
@@ -524,6 +578,9 @@ func (mdb *memdbSlice) insertSecArrayIndex(keys []byte, docid []byte, workerId i
 
 	// Update back index entry
 	mdb.back[workerId].Update(lookupentry, unsafe.Pointer(list.Head()))
+	if ptr == nil && filter != nil {
+		filter.Add(docid)
+	}
 	mdb.isDirty = true
 	return nmut
 }
@@ -565,6 +622,9 @@ func (mdb *memdbSlice) deletePrimaryIndex(docid []byte, workerId int) (nmut int)
 }
 
 func (mdb *memdbSlice) deleteSecIndex(docid []byte, workerId int) int {
+	if !mayHaveBackIndexEntry(mdb.docidFilter(workerId), docid, mdb.idxStats) {
+		return 1
+	}
 	lookupentry := entryBytesFromDocId(docid)
 
 	// Delete entry from back and main index if present
@@ -574,7 +634,7 @@ func (mdb *memdbSlice) deleteSecIndex(docid []byte, workerId int) int {
 		mdb.idxStats.Timings.stKVDelete.Put(time.Since(t0))
 		atomic.AddInt64(&mdb.delete_bytes, int64(len(docid)))
 		t0 = time.Now()
-		mdb.main[workerId].DeleteNode((*skiplist.Node)(node))
+		mdb.deleteNode(workerId, (*skiplist.Node)(node))
 		mdb.idxStats.Timings.stKVDelete.Put(time.Since(t0))
 	}
 	mdb.isDirty = true
@@ -583,6 +643,9 @@ func (mdb *memdbSlice) deleteSecIndex(docid []byte, workerId int) int {
 
 func (mdb *memdbSlice) deleteSecArrayIndex(docid []byte, workerId int) (nmut int) {
 	// Get old back index entry
+	if !mayHaveBackIndexEntry(mdb.docidFilter(workerId), docid, mdb.idxStats) {
+		return
+	}
 	lookupentry := entryBytesFromDocId(docid)
 	ptr := (*skiplist.Node)(mdb.back[workerId].Get(lookupentry))
 	if ptr == nil {
@@ -599,7 +662,7 @@ func (mdb *memdbSlice) deleteSecArrayIndex(docid []byte, workerId int) (nmut int
 	// Delete each entry in oldEntriesBytes
 	for _, item := range oldEntriesBytes {
 		node := list.Remove(item)
-		mdb.main[workerId].DeleteNode(node)
+		mdb.deleteNode(workerId, node)
 	}
 
 	mdb.isDirty = true
@@ -607,6 +670,16 @@ func (mdb *memdbSlice) deleteSecArrayIndex(docid []byte, workerId int) (nmut int
 	return len(oldEntriesBytes)
 }
 
+// deleteNode removes node of a secondary index entry from main index,
+// its key is accounted as deleted in key statistics.
+func (mdb *memdbSlice) deleteNode(workerId int, node *skiplist.Node) {
+	if node != nil {
+		itm := (*memdb.Item)(node.Item())
+		mdb.keyStats.DeleteEntry(secondaryIndexEntry(itm.Bytes()))
+	}
+	mdb.main[workerId].DeleteNode(node)
+}
+
 //checkFatalDbError checks if the error returned from DB
 //is fatal and stores it. This error will be returned
 //to caller on next DB operation
@@ -630,6 +703,7 @@ func (mdb *memdbSlice) checkFatalDbError(err error) {
 type memdbSnapshotInfo struct {
 	Ts       *common.TsVbuuid
 	MainSnap *memdb.Snapshot `json:"-"`
+	KeyStats *keyStatsData   `json:",omitempty"`
 
 	Committed bool `json:"-"`
 	dataPath  string
@@ -691,6 +765,7 @@ func (mdb *memdbSlice) doPersistSnapshot(s *memdbSnapshot) {
 		os.RemoveAll(tmpdir)
 		mdb.confLock.RLock()
 		maxThreads := mdb.sysconf["settings.moi.persistence_threads"].Int()
+		maxDeltas := mdb.sysconf["settings.moi.persistence.max_delta_snapshots"].Int()
 		total := atomic.LoadInt64(&totalMemDBItems)
 		indexCount := mdb.GetCommittedCount()
 		// Compute number of workers to be used for taking backup
@@ -699,7 +774,33 @@ func (mdb *memdbSlice) doPersistSnapshot(s *memdbSnapshot) {
 		}
 
 		mdb.confLock.RUnlock()
-		err := mdb.mainstore.StoreToDisk(tmpdir, s.info.MainSnap, concurrency, nil)
+
+		// Persist a delta of last persisted snapshot, unless the chain of
+		// deltas is long enough to be consolidated into a full snapshot.
+		// Items deleted since the base snapshot could not be garbage
+		// collected while it was kept open, they are counted to log the
+		// memory held back.
+		var err error
+		var gcItems, gcBytes int64
+		delCallback := func(e *memdb.ItemEntry) {
+			atomic.AddInt64(&gcItems, 1)
+			atomic.AddInt64(&gcBytes, int64(len(e.Item().Bytes())))
+		}
+
+		base, baseDir, deltaCount := mdb.getDeltaBase()
+		s.info.MainSnap.Open() // retained as base of next delta snapshot
+		if base != nil && deltaCount < maxDeltas {
+			deltaCount++
+			err = mdb.mainstore.StoreDeltaToDisk(tmpdir, filepath.Base(baseDir),
+				base, s.info.MainSnap, concurrency, nil, delCallback)
+		} else {
+			deltaCount = 0
+			err = mdb.mainstore.StoreToDisk(tmpdir, s.info.MainSnap, concurrency, nil)
+		}
+		if base != nil {
+			base.Close()
+		}
+
 		if err == nil {
 			var fd *os.File
 			var bs []byte
@@ -715,6 +816,7 @@ func (mdb *memdbSlice) doPersistSnapshot(s *memdbSnapshot) {
 			if err == nil {
 				err = os.Rename(tmpdir, dir)
 				if err == nil {
+					mdb.setDeltaBase(s.info.MainSnap, dir, deltaCount)
 					mdb.cleanupOldSnapshotFiles(mdb.maxRollbacks)
 				}
 			}
@@ -723,13 +825,18 @@ func (mdb *memdbSlice) doPersistSnapshot(s *memdbSnapshot) {
 		if err == nil {
 			dur := time.Since(t0)
 			logging.Infof("MemDBSlice Slice Id %v, Threads %d, IndexInstId %v created ondisk"+
-				" snapshot %v. Took %v", mdb.id, concurrency, mdb.idxInstId, dir, dur)
+				" snapshot %v (delta %v). Took %v. Delta base held back %v deleted items"+
+				" (%v bytes) from garbage collection", mdb.id, concurrency, mdb.idxInstId, dir,
+				deltaCount, dur, gcItems, gcBytes)
 			mdb.idxStats.diskSnapStoreDuration.Set(int64(dur / time.Millisecond))
 		} else {
 			logging.Errorf("MemDBSlice Slice Id %v, IndexInstId %v failed to"+
 				" create ondisk snapshot %v (error=%v)", mdb.id, mdb.idxInstId, dir, err)
 			os.RemoveAll(tmpdir)
 			os.RemoveAll(dir)
+			// Next snapshot is persisted in full
+			s.info.MainSnap.Close()
+			mdb.setDeltaBase(nil, "", 0)
 		}
 	} else {
 		logging.Infof("MemDBSlice Slice Id %v, IndexInstId %v Skipping ondisk"+
@@ -738,13 +845,50 @@ func (mdb *memdbSlice) doPersistSnapshot(s *memdbSnapshot) {
 	}
 }
 
+// getDeltaBase returns the last persisted snapshot, opened for the caller,
+// its directory and its number of deltas.
+func (mdb *memdbSlice) getDeltaBase() (*memdb.Snapshot, string, int) {
+	mdb.deltaLock.Lock()
+	defer mdb.deltaLock.Unlock()
+
+	if mdb.deltaBase == nil || !mdb.deltaBase.Open() {
+		return nil, "", 0
+	}
+	return mdb.deltaBase, mdb.deltaDir, mdb.deltaCount
+}
+
+// setDeltaBase takes over the reference of snap, persisted in dir, as the
+// base of next delta snapshot and closes the previous base.
+func (mdb *memdbSlice) setDeltaBase(snap *memdb.Snapshot, dir string, deltaCount int) {
+	mdb.deltaLock.Lock()
+	defer mdb.deltaLock.Unlock()
+
+	if mdb.deltaBase != nil {
+		mdb.deltaBase.Close()
+	}
+	mdb.deltaBase, mdb.deltaDir, mdb.deltaCount = snap, dir, deltaCount
+}
+
 func (mdb *memdbSlice) cleanupOldSnapshotFiles(keepn int) {
 	manifests := mdb.getSnapshotManifests()
 	if len(manifests) > keepn {
 		toRemove := len(manifests) - keepn
+
+		// Retain snapshots which delta snapshots being kept depend on
+		retain := make(map[string]bool)
+		for _, m := range manifests[toRemove:] {
+			chain, _ := memdb.SnapshotChain(filepath.Dir(m))
+			for _, dir := range chain {
+				retain[dir] = true
+			}
+		}
+
 		manifests = manifests[:toRemove]
 		for _, m := range manifests {
 			dir := filepath.Dir(m)
+			if retain[dir] {
+				continue
+			}
 			logging.Infof("MemDBSlice Removing disk snapshot %v", dir)
 			os.RemoveAll(dir)
 		}
@@ -811,6 +955,8 @@ func (mdb *memdbSlice) GetCommittedCount() uint64 {
 }
 
 func (mdb *memdbSlice) resetStores() {
+	mdb.setDeltaBase(nil, "", 0)
+
 	// This is blocking call if snap refcounts != 0
 	go mdb.mainstore.Close()
 	if !mdb.isPrimary {
@@ -819,6 +965,7 @@ func (mdb *memdbSlice) resetStores() {
 		}
 	}
 
+	mdb.keyStats.Reset()
 	mdb.initStores()
 }
 
@@ -887,12 +1034,15 @@ func (mdb *memdbSlice) loadSnapshot(snapInfo *memdbSnapshotInfo) (err error) {
 			partShardCh[wId] = make(chan *memdb.ItemEntry, 1000)
 			go func(i int, wg *sync.WaitGroup) {
 				defer wg.Done()
+				filter := mdb.docidFilter(i)
 				for entry := range partShardCh[i] {
 					if !mdb.isPrimary {
 						entryBytes := entry.Item().Bytes()
 						if updated, oldPtr := mdb.back[i].Update(entryBytes, unsafe.Pointer(entry.Node())); updated {
 							oldNode := (*skiplist.Node)(oldPtr)
 							entry.Node().SetLink(oldNode)
+						} else if filter != nil {
+							filter.Add(docIdFromEntryBytes(entryBytes))
 						}
 					}
 				}
@@ -911,6 +1061,7 @@ func (mdb *memdbSlice) loadSnapshot(snapInfo *memdbSnapshotInfo) (err error) {
 
 	var snap *memdb.Snapshot
 	snap, err = mdb.mainstore.LoadFromDisk(snapInfo.dataPath, concurrency, backIndexCallback)
+	mdb.keyStats.Restore(snapInfo.KeyStats)
 
 	if !mdb.isPrimary {
 		for wId := 0; wId < mdb.numWriters; wId++ {
@@ -923,6 +1074,12 @@ func (mdb *memdbSlice) loadSnapshot(snapInfo *memdbSnapshotInfo) (err error) {
 	if err == nil {
 		snapInfo.MainSnap = snap
 		mdb.setCommittedCount()
+
+		// Loaded snapshot is the base of next delta snapshot
+		if chain, e := memdb.SnapshotChain(snapInfo.dataPath); e == nil {
+			snap.Open()
+			mdb.setDeltaBase(snap, snapInfo.dataPath, len(chain)-1)
+		}
 		logging.Infof("MemDBSlice::loadSnapshot Slice Id %v, IndexInstId %v finished reading %v. Took %v",
 			mdb.id, mdb.idxInstId, snapInfo.dataPath, dur)
 	} else {
@@ -993,6 +1150,9 @@ func (mdb *memdbSlice) NewSnapshot(ts *common.TsVbuuid, commit bool) (SnapshotIn
 		MainSnap:  snap,
 		Committed: commit,
 	}
+	if !mdb.isPrimary {
+		newSnapshotInfo.KeyStats = mdb.keyStats.Data()
+	}
 	mdb.setCommittedCount()
 
 	return newSnapshotInfo, err
@@ -1120,6 +1280,10 @@ func (mdb *memdbSlice) Statistics() (StorageStatistics, error) {
 			internalData = append(internalData, ",\n")
 			internalData = append(internalData, fmt.Sprintf(`"BackStore_%d": %s`, i, mdb.back[i].Stats()))
 		}
+		for i, filter := range mdb.docidFilters {
+			internalData = append(internalData, ",\n")
+			internalData = append(internalData, fmt.Sprintf(`"DocidFilter_%d": {"MemoryInUse": %d}`, i, filter.MemoryInUse()))
+		}
 	}
 
 	internalData = append(internalData, "\n}")
@@ -1161,6 +1325,7 @@ func tryDeletememdbSlice(mdb *memdbSlice) {
 }
 
 func tryClosememdbSlice(mdb *memdbSlice) {
+	mdb.setDeltaBase(nil, "", 0)
 	mdb.mainstore.Close()
 	if !mdb.isPrimary {
 		for i := 0; i < mdb.numWriters; i++ {
@@ -1276,6 +1441,10 @@ func (s *memdbSnapshot) StatCountTotal() (uint64, error) {
 	return c, nil
 }
 
+func (s *memdbSnapshot) KeyStatistics() *keyStatsData {
+	return s.info.KeyStats
+}
+
 func (s *memdbSnapshot) CountTotal(ctx IndexReaderContext, stopch StopChannel) (uint64, error) {
 	return uint64(s.info.MainSnap.Count()), nil
 }
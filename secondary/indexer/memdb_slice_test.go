@@ -77,7 +77,7 @@ func flushWorker(wg *sync.WaitGroup, stream chan *ientry, n int, slice Slice) {
 
 	for i := 0; i < n; i++ {
 		entry := <-stream
-		slice.Insert(entry.e, entry.docid, entry.m)
+		slice.Insert(entry.e, entry.docid, nil, entry.m)
 		entry.m.Free()
 	}
 }
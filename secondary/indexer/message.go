@@ -65,6 +65,7 @@ const (
 	STORAGE_INDEX_STORAGE_STATS
 	STORAGE_INDEX_COMPACT
 	STORAGE_SNAP_DONE
+	STORAGE_OPEN_SNAPSHOTS
 
 	//KVSender
 	KV_SENDER_SHUTDOWN
@@ -92,6 +93,8 @@ const (
 	CLUST_MGR_DEL_BUCKET
 	CLUST_MGR_INDEXER_READY
 	CLUST_MGR_CLEANUP_INDEX
+	CLUST_MGR_ALTER_INDEX_DDL
+	CLUST_MGR_SWAP_ALTER_INDEX
 
 	//CBQ_BRIDGE_SHUTDOWN
 	CBQ_BRIDGE_SHUTDOWN
@@ -117,6 +120,7 @@ const (
 	INDEXER_DEL_LOCAL_META
 	INDEXER_CHECK_DDL_IN_PROGRESS
 	INDEXER_UPDATE_RSTATE
+	INDEXER_GET_INDEX_SLICES
 
 	//SCAN COORDINATOR
 	SCAN_COORD_SHUTDOWN
@@ -711,6 +715,45 @@ func (m *MsgCreateIndex) GetString() string {
 	return str
 }
 
+//CLUST_MGR_ALTER_INDEX_DDL
+type MsgAlterIndex struct {
+	indexInst common.IndexInst
+	oldInstId common.IndexInstId
+	respCh    MsgChannel
+	reqCtx    *common.MetadataRequestContext
+}
+
+func (m *MsgAlterIndex) GetMsgType() MsgType {
+	return CLUST_MGR_ALTER_INDEX_DDL
+}
+
+//GetIndexInst returns the shadow instance built with the new keys
+func (m *MsgAlterIndex) GetIndexInst() common.IndexInst {
+	return m.indexInst
+}
+
+//GetOldInstId returns the instance which keeps serving scans till
+//the shadow instance is swapped in
+func (m *MsgAlterIndex) GetOldInstId() common.IndexInstId {
+	return m.oldInstId
+}
+
+func (m *MsgAlterIndex) GetResponseChannel() MsgChannel {
+	return m.respCh
+}
+
+func (m *MsgAlterIndex) GetRequestCtx() *common.MetadataRequestContext {
+	return m.reqCtx
+}
+
+func (m *MsgAlterIndex) GetString() string {
+
+	str := "\n\tMessage: MsgAlterIndex"
+	str += fmt.Sprintf("\n\tOldInstId: %v", m.oldInstId)
+	str += fmt.Sprintf("\n\tIndex: %v", m.indexInst)
+	return str
+}
+
 //CLUST_MGR_BUILD_INDEX_DDL
 type MsgBuildIndex struct {
 	indexInstList []common.IndexInstId
@@ -1085,6 +1128,19 @@ func (m *MsgIndexCompact) GetAbortTime() time.Time {
 	return m.abortTime
 }
 
+//STORAGE_OPEN_SNAPSHOTS
+type MsgOpenSnapshots struct {
+	instIds []common.IndexInstId
+}
+
+func (m *MsgOpenSnapshots) GetMsgType() MsgType {
+	return STORAGE_OPEN_SNAPSHOTS
+}
+
+func (m *MsgOpenSnapshots) GetInstIds() []common.IndexInstId {
+	return m.instIds
+}
+
 //KV_STREAM_REPAIR
 type MsgKVStreamRepair struct {
 	streamId  common.StreamId
@@ -1121,6 +1177,19 @@ func (m *MsgClustMgrResetIndex) GetIndex() common.IndexDefn {
 	return m.defn
 }
 
+//CLUST_MGR_SWAP_ALTER_INDEX
+type MsgClustMgrSwapAlterIndex struct {
+	defn common.IndexDefn
+}
+
+func (m *MsgClustMgrSwapAlterIndex) GetMsgType() MsgType {
+	return CLUST_MGR_SWAP_ALTER_INDEX
+}
+
+func (m *MsgClustMgrSwapAlterIndex) GetIndex() common.IndexDefn {
+	return m.defn
+}
+
 //CLUST_MGR_UPDATE_TOPOLOGY_FOR_INDEX
 type MsgClustMgrUpdate struct {
 	mType         MsgType
@@ -1280,6 +1349,30 @@ func (m *MsgUpdateIndexRState) GetRState() common.RebalanceState {
 	return m.rstate
 }
 
+//INDEXER_GET_INDEX_SLICES
+type MsgIndexSlices struct {
+	instId common.IndexInstId
+	respch chan *indexSlices
+}
+
+//indexSlices of an index instance, by partition.
+type indexSlices struct {
+	inst   common.IndexInst
+	slices map[common.PartitionId]Slice
+}
+
+func (m *MsgIndexSlices) GetMsgType() MsgType {
+	return INDEXER_GET_INDEX_SLICES
+}
+
+func (m *MsgIndexSlices) GetInstId() common.IndexInstId {
+	return m.instId
+}
+
+func (m *MsgIndexSlices) GetRespCh() chan *indexSlices {
+	return m.respch
+}
+
 //Helper function to return string for message type
 
 func (m MsgType) String() string {
@@ -1403,6 +1496,8 @@ func (m MsgType) String() string {
 		return "INDEXER_CHECK_DDL_IN_PROGRESS"
 	case INDEXER_UPDATE_RSTATE:
 		return "INDEXER_UPDATE_RSTATE"
+	case INDEXER_GET_INDEX_SLICES:
+		return "INDEXER_GET_INDEX_SLICES"
 
 	case SCAN_COORD_SHUTDOWN:
 		return "SCAN_COORD_SHUTDOWN"
@@ -1454,6 +1549,10 @@ func (m MsgType) String() string {
 		return "CLUST_MGR_INDEXER_READY"
 	case CLUST_MGR_CLEANUP_INDEX:
 		return "CLUST_MGR_CLEANUP_INDEX"
+	case CLUST_MGR_ALTER_INDEX_DDL:
+		return "CLUST_MGR_ALTER_INDEX_DDL"
+	case CLUST_MGR_SWAP_ALTER_INDEX:
+		return "CLUST_MGR_SWAP_ALTER_INDEX"
 
 	case CBQ_CREATE_INDEX_DDL:
 		return "CBQ_CREATE_INDEX_DDL"
@@ -1468,6 +1567,8 @@ func (m MsgType) String() string {
 		return "STORAGE_INDEX_COMPACT"
 	case STORAGE_SNAP_DONE:
 		return "STORAGE_SNAP_DONE"
+	case STORAGE_OPEN_SNAPSHOTS:
+		return "STORAGE_OPEN_SNAPSHOTS"
 
 	case CONFIG_SETTINGS_UPDATE:
 		return "CONFIG_SETTINGS_UPDATE"
@@ -16,12 +16,12 @@ package indexer
 
 import (
 	"encoding/json"
-	"github.com/couchbase/cbauth/metakv"
+	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/logging"
 )
 
 func MetakvGet(path string, v interface{}) (bool, error) {
-	raw, _, err := metakv.Get(path)
+	raw, _, err := common.Metakv().Get(path)
 	if err != nil {
 		logging.Fatalf("MetakvGet: Failed to fetch %s from metakv: %s", path, err.Error())
 	}
@@ -48,7 +48,7 @@ func MetakvSet(path string, v interface{}) error {
 		return err
 	}
 
-	err = metakv.Set(path, raw, nil)
+	err = common.Metakv().Set(path, raw, nil)
 	if err != nil {
 		logging.Fatalf("MetakvSet Failed to set %s: %s", path, err.Error())
 	}
@@ -57,7 +57,7 @@ func MetakvSet(path string, v interface{}) error {
 
 func MetakvDel(path string) error {
 
-	err := metakv.Delete(path, nil)
+	err := common.Metakv().Delete(path, nil)
 	if err != nil {
 		logging.Fatalf("MetakvDel: Failed to delete %s: %s", path, err.Error())
 	}
@@ -66,7 +66,7 @@ func MetakvDel(path string) error {
 
 func MetakvRecurciveDel(dirpath string) error {
 
-	err := metakv.RecursiveDelete(dirpath)
+	err := common.Metakv().RecursiveDelete(dirpath)
 	if err != nil {
 		logging.Fatalf("MetakvRecurciveDel: Failed to delete %s: %s", dirpath, err.Error())
 	}
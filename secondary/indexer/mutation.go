@@ -128,6 +128,7 @@ type Mutation struct {
 	key      []byte             // key-version for index
 	oldkey   []byte             // previous key-version, if available
 	partnkey []byte             // partition key
+	payload  []byte             // include payload
 }
 
 var mutPool = sync.Pool{New: newMutation}
@@ -149,6 +150,7 @@ func (m *Mutation) Size() int64 {
 	var size int64
 	size = int64(len(m.key))
 	size += int64(len(m.partnkey))
+	size += int64(len(m.payload))
 	size += 8 + 1        //instId + command
 	size += 16 + 16 + 16 //fixed cost of members
 	return size
@@ -160,6 +162,7 @@ func (m *Mutation) Free() {
 		m.key = m.key[:0]
 		m.oldkey = m.oldkey[:0]
 		m.partnkey = m.partnkey[:0]
+		m.payload = m.payload[:0]
 		mutPool.Put(m)
 	}
 }
@@ -30,8 +30,12 @@ type BucketQueueMap map[string]IndexerMutationQueue
 type BucketStopChMap map[string]StopChannel
 
 type mutationMgr struct {
-	memUsed   int64 //memory used by queue
-	maxMemory int64 //max memory to be used
+	memUsed       int64 //memory used by queue
+	maxMemory     int64 //max memory to be used
+	initMemUsed   int64 //memory used by INIT_STREAM queues
+	initMaxMemory int64 //max memory to be used by INIT_STREAM queues
+
+	throttle *buildThrottle //throttles INIT_STREAM under pressure
 
 	streamBucketQueueMap map[common.StreamId]BucketQueueMap
 	streamIndexQueueMap  map[common.StreamId]IndexQueueMap
@@ -96,6 +100,8 @@ func NewMutationManager(supvCmdch MsgChannel, supvRespch MsgChannel,
 		config:                 config,
 		memUsed:                0,
 		maxMemory:              0,
+		initMaxMemory:          0,
+		throttle:               newBuildThrottle(config),
 	}
 
 	//start Mutation Manager loop which listens to commands from its supervisor
@@ -114,6 +120,7 @@ func (m *mutationMgr) run() {
 
 	go m.handleWorkerMsgs()
 	go m.listenWorkerMsgs()
+	go m.runBuildThrottle()
 
 	//main Mutation Manager loop
 loop:
@@ -366,7 +373,7 @@ func (m *mutationMgr) handleOpenStream(cmd Message) {
 		if _, ok := bucketQueueMap[i.Defn.Bucket]; !ok {
 			//init mutation queue
 			var queue MutationQueue
-			if queue = NewAtomicMutationQueue(i.Defn.Bucket, m.numVbuckets, &m.maxMemory, &m.memUsed, m.config); queue == nil {
+			if queue = m.newMutationQueue(streamId, i.Defn.Bucket); queue == nil {
 				m.supvCmdch <- &MsgError{
 					err: Error{code: ERROR_MUTATION_QUEUE_INIT,
 						severity: FATAL,
@@ -453,7 +460,7 @@ func (m *mutationMgr) addIndexListToExistingStream(streamId common.StreamId,
 		if _, ok := bucketQueueMap[i.Defn.Bucket]; !ok {
 			//init mutation queue
 			var queue MutationQueue
-			if queue = NewAtomicMutationQueue(i.Defn.Bucket, m.numVbuckets, &m.maxMemory, &m.memUsed, m.config); queue == nil {
+			if queue = m.newMutationQueue(streamId, i.Defn.Bucket); queue == nil {
 				return &MsgError{
 					err: Error{code: ERROR_MUTATION_QUEUE_INIT,
 						severity: FATAL,
@@ -1061,6 +1068,7 @@ func (m *mutationMgr) handleConfigUpdate(cmd Message) {
 	m.config = cfgUpdate.GetConfig()
 
 	m.setMaxMemoryFromQuota()
+	m.throttle.setConfig(m.config)
 
 	m.supvCmdch <- &MsgSuccess{}
 }
@@ -1078,6 +1086,7 @@ func (m *mutationMgr) setMaxMemoryFromQuota() {
 	}
 
 	atomic.StoreInt64(&m.maxMemory, maxMem)
+	m.setInitStreamMaxMemory()
 	logging.Infof("MutationMgr::MaxQueueMemoryQuota %v", maxMem)
 
 }
@@ -71,6 +71,8 @@ type atomicMutationQueue struct {
 	memUsed   *int64           //memory used by queue
 	maxMemory *int64           //max memory to be used
 
+	streamMemUsed *int64 //memory used by queues of the stream, if tracked
+
 	allocPollInterval   uint64 //poll interval for new allocs, if queue is full
 	dequeuePollInterval uint64 //poll interval for dequeue, if waiting for mutations
 	resultChanSize      uint64 //size of buffered result channel
@@ -150,7 +152,7 @@ func (q *atomicMutationQueue) Enqueue(mutation *MutationKeys,
 	n.mutation = mutation
 	n.next = nil
 
-	atomic.AddInt64(q.memUsed, n.mutation.Size())
+	q.addMemUsed(n.mutation.Size())
 
 	//point tail's next to new node
 	tail := (*node)(atomic.LoadPointer(&q.tail[vbucket]))
@@ -210,7 +212,7 @@ func (q *atomicMutationQueue) dequeueUptoSeqno(vbucket Vbucket, seqno Seqno,
 				//move head to next
 				atomic.StorePointer(&q.head[vbucket], unsafe.Pointer(head.next))
 				atomic.AddInt64(&q.size[vbucket], -1)
-				atomic.AddInt64(q.memUsed, -m.Size())
+				q.addMemUsed(-m.Size())
 				//send mutation to caller
 				dequeueSeq = m.meta.seqno
 				datach <- m
@@ -290,7 +292,7 @@ func (q *atomicMutationQueue) DequeueSingleElement(vbucket Vbucket) *MutationKey
 		//move head to next
 		atomic.StorePointer(&q.head[vbucket], unsafe.Pointer(head.next))
 		atomic.AddInt64(&q.size[vbucket], -1)
-		atomic.AddInt64(q.memUsed, -m.Size())
+		q.addMemUsed(-m.Size())
 		return m
 	}
 	return nil
@@ -373,6 +375,14 @@ func (q *atomicMutationQueue) allocNode(vbucket Vbucket, appch StopChannel) *nod
 
 }
 
+func (q *atomicMutationQueue) addMemUsed(size int64) {
+
+	atomic.AddInt64(q.memUsed, size)
+	if q.streamMemUsed != nil {
+		atomic.AddInt64(q.streamMemUsed, size)
+	}
+}
+
 func (q *atomicMutationQueue) checkMemAndAlloc(vbucket Vbucket) *node {
 
 	currMem := atomic.LoadInt64(q.memUsed)
@@ -95,6 +95,9 @@ type plasmaSlice struct {
 	arrayBuf2 [][]byte
 
 	hasPersistence bool
+
+	// key statistics are persisted with recovery points.
+	keyStats *keyStatistics
 }
 
 func newPlasmaSlice(path string, sliceId SliceId, idxDefn common.IndexDefn,
@@ -156,6 +159,7 @@ func newPlasmaSlice(path string, sliceId SliceId, idxDefn common.IndexDefn,
 	slice.readers = make(chan *plasma.Reader, numReaders)
 
 	slice.isPrimary = isPrimary
+	slice.keyStats = newKeyStatistics()
 	if err := slice.initStores(); err != nil {
 		return nil, err
 	}
@@ -358,11 +362,12 @@ func (mdb *plasmaSlice) DecrRef() {
 	}
 }
 
-func (mdb *plasmaSlice) Insert(key []byte, docid []byte, meta *MutationMeta) error {
+func (mdb *plasmaSlice) Insert(key []byte, docid []byte, payload []byte, meta *MutationMeta) error {
 	mut := indexMutation{
-		op:    opUpdate,
-		key:   key,
-		docid: docid,
+		op:      opUpdate,
+		key:     key,
+		docid:   docid,
+		payload: payload,
 	}
 	atomic.AddInt64(&mdb.qCount, 1)
 	mdb.cmdCh[int(meta.vbucket)%mdb.numWriters] <- mut
@@ -390,7 +395,7 @@ loop:
 			switch icmd.op {
 			case opUpdate:
 				start = time.Now()
-				nmut = mdb.insert(icmd.key, icmd.docid, workerId)
+				nmut = mdb.insert(icmd.key, icmd.docid, icmd.payload, workerId)
 				elapsed = time.Since(start)
 				mdb.totalFlushTime += elapsed
 
@@ -420,7 +425,7 @@ loop:
 	}
 }
 
-func (mdb *plasmaSlice) insert(key []byte, docid []byte, workerId int) int {
+func (mdb *plasmaSlice) insert(key []byte, docid []byte, payload []byte, workerId int) int {
 	var nmut int
 
 	if mdb.isPrimary {
@@ -431,7 +436,7 @@ func (mdb *plasmaSlice) insert(key []byte, docid []byte, workerId int) int {
 		if mdb.idxDefn.IsArrayIndex {
 			nmut = mdb.insertSecArrayIndex(key, docid, workerId)
 		} else {
-			nmut = mdb.insertSecIndex(key, docid, workerId)
+			nmut = mdb.insertSecIndex(key, docid, payload, workerId)
 		}
 	}
 
@@ -461,14 +466,14 @@ func (mdb *plasmaSlice) insertPrimaryIndex(key []byte, docid []byte, workerId in
 	return 0
 }
 
-func (mdb *plasmaSlice) insertSecIndex(key []byte, docid []byte, workerId int) int {
+func (mdb *plasmaSlice) insertSecIndex(key []byte, docid []byte, payload []byte, workerId int) int {
 	t0 := time.Now()
 
 	ndel := mdb.deleteSecIndex(docid, workerId)
 
-	mdb.encodeBuf[workerId] = resizeEncodeBuf(mdb.encodeBuf[workerId], len(key), allowLargeKeys)
-	entry, err := NewSecondaryIndexEntry(key, docid, mdb.idxDefn.IsArrayIndex,
-		1, mdb.idxDefn.Desc, mdb.encodeBuf[workerId])
+	mdb.encodeBuf[workerId] = resizeEncodeBuf(mdb.encodeBuf[workerId], len(key)+len(payload), allowLargeKeys)
+	entry, err := NewSecondaryIndexEntry3(key, docid, payload, mdb.idxDefn.IsArrayIndex,
+		1, mdb.idxDefn.Desc, mdb.encodeBuf[workerId], true)
 	if err != nil {
 		logging.Errorf("plasmaSlice::insertSecIndex Slice Id %v IndexInstId %v "+
 			"Skipping docid:%s (%v)", mdb.Id, mdb.idxInstId, docid, err)
@@ -484,6 +489,7 @@ func (mdb *plasmaSlice) insertSecIndex(key []byte, docid []byte, workerId int) i
 		mdb.main[workerId].InsertKV(entry, nil)
 		backEntry := entry2BackEntry(entry)
 		mdb.back[workerId].InsertKV(docid, backEntry)
+		mdb.keyStats.AddEntry(entry)
 
 		mdb.idxStats.Timings.stKVSet.Put(time.Now().Sub(t0))
 		atomic.AddInt64(&mdb.insert_bytes, int64(len(docid)+len(entry)))
@@ -585,6 +591,7 @@ func (mdb *plasmaSlice) insertSecArrayIndex(key []byte, docid []byte, workerId i
 				common.CrashOnError(err)
 				// Add back
 				mdb.main[workerId].InsertKV(entry, nil)
+				mdb.keyStats.AddEntry(entry)
 			}
 		}
 	}
@@ -598,6 +605,7 @@ func (mdb *plasmaSlice) insertSecArrayIndex(key []byte, docid []byte, workerId i
 				common.CrashOnError(err)
 				// Delete back
 				mdb.main[workerId].DeleteKV(entry)
+				mdb.keyStats.DeleteEntry(entry)
 			}
 		}
 	}
@@ -617,6 +625,7 @@ func (mdb *plasmaSlice) insertSecArrayIndex(key []byte, docid []byte, workerId i
 			}
 			t0 := time.Now()
 			mdb.main[workerId].DeleteKV(keyToBeDeleted)
+			mdb.keyStats.DeleteEntry(secondaryIndexEntry(keyToBeDeleted))
 			mdb.idxStats.Timings.stKVDelete.Put(time.Now().Sub(t0))
 			atomic.AddInt64(&mdb.delete_bytes, int64(len(keyToBeDeleted)))
 			nmut++
@@ -641,6 +650,7 @@ func (mdb *plasmaSlice) insertSecArrayIndex(key []byte, docid []byte, workerId i
 			mdb.main[workerId].InsertKV(keyToBeAdded, nil)
 			mdb.idxStats.Timings.stKVSet.Put(time.Now().Sub(t0))
 			atomic.AddInt64(&mdb.insert_bytes, int64(len(keyToBeAdded)))
+			mdb.keyStats.AddEntry(secondaryIndexEntry(keyToBeAdded))
 			nmut++
 		}
 	}
@@ -731,6 +741,7 @@ func (mdb *plasmaSlice) deleteSecIndex(docid []byte, workerId int) int {
 		mdb.back[workerId].DeleteKV(docid)
 		entry := backEntry2entry(docid, backEntry, buf)
 		mdb.main[workerId].DeleteKV(entry)
+		mdb.keyStats.DeleteEntry(secondaryIndexEntry(entry))
 		mdb.idxStats.Timings.stKVDelete.Put(time.Since(t0))
 	}
 
@@ -796,6 +807,7 @@ func (mdb *plasmaSlice) deleteSecArrayIndex(docid []byte, workerId int) (nmut in
 		}
 		t0 := time.Now()
 		mdb.main[workerId].DeleteKV(keyToBeDeleted)
+		mdb.keyStats.DeleteEntry(secondaryIndexEntry(keyToBeDeleted))
 		mdb.idxStats.Timings.stKVDelete.Put(time.Now().Sub(t0))
 		atomic.AddInt64(&mdb.delete_bytes, int64(len(keyToBeDeleted)))
 	}
@@ -833,10 +845,31 @@ type plasmaSnapshotInfo struct {
 	Ts        *common.TsVbuuid
 	Committed bool
 	Count     int64
+	KeyStats  *keyStatsData
 
 	mRP, bRP *plasma.RecoveryPoint
 }
 
+// plasmaRPMeta is the meta of a recovery point, following the time
+// header. Older recovery points have only the timestamp as meta.
+type plasmaRPMeta struct {
+	Ts       *common.TsVbuuid
+	KeyStats *keyStatsData `json:",omitempty"`
+}
+
+func decodeRPMeta(data []byte) (*plasmaRPMeta, error) {
+	meta := &plasmaRPMeta{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	if meta.Ts == nil {
+		if err := json.Unmarshal(data, &meta.Ts); err != nil {
+			return nil, err
+		}
+	}
+	return meta, nil
+}
+
 type plasmaSnapshot struct {
 	slice     *plasmaSlice
 	idxDefnId common.IndexDefnId
@@ -900,7 +933,10 @@ func (mdb *plasmaSlice) doPersistSnapshot(s *plasmaSnapshot) {
 			logging.Infof("PlasmaSlice Slice Id %v, IndexInstId %v Creating recovery point ...", mdb.id, mdb.idxInstId)
 			t0 := time.Now()
 
-			meta, err := json.Marshal(s.ts)
+			meta, err := json.Marshal(&plasmaRPMeta{
+				Ts:       s.ts,
+				KeyStats: s.info.(*plasmaSnapshotInfo).KeyStats,
+			})
 			common.CrashOnError(err)
 			timeHdr := make([]byte, 8)
 			binary.BigEndian.PutUint64(timeHdr, uint64(time.Now().UnixNano()))
@@ -1001,9 +1037,11 @@ func (mdb *plasmaSlice) GetSnapshots() ([]SnapshotInfo, error) {
 			Count: mRPs[i].ItemsCount(),
 		}
 
-		if err := json.Unmarshal(info.mRP.Meta()[8:], &info.Ts); err != nil {
+		meta, err := decodeRPMeta(info.mRP.Meta()[8:])
+		if err != nil {
 			return nil, fmt.Errorf("Unable to decode snapshot meta err %v", err)
 		}
+		info.Ts, info.KeyStats = meta.Ts, meta.KeyStats
 
 		if !mdb.isPrimary {
 			info.bRP = bRPs[i]
@@ -1093,6 +1131,7 @@ func (mdb *plasmaSlice) restore(o SnapshotInfo) error {
 		return fmt.Errorf("Rollback error %v %v", mErr, bErr)
 	}
 
+	mdb.keyStats.Restore(info.KeyStats)
 	return nil
 }
 
@@ -1103,6 +1142,7 @@ func (mdb *plasmaSlice) RollbackToZero() error {
 	mdb.waitForPersistorThread()
 
 	mdb.resetStores()
+	mdb.keyStats.Reset()
 
 	return nil
 }
@@ -1151,6 +1191,9 @@ func (mdb *plasmaSlice) NewSnapshot(ts *common.TsVbuuid, commit bool) (SnapshotI
 		Committed: commit,
 		Count:     mdb.mainstore.ItemsCount(),
 	}
+	if !mdb.isPrimary {
+		newSnapshotInfo.KeyStats = mdb.keyStats.Data()
+	}
 
 	return newSnapshotInfo, nil
 }
@@ -1483,6 +1526,10 @@ func (s *plasmaSnapshot) StatCountTotal() (uint64, error) {
 	return c, nil
 }
 
+func (s *plasmaSnapshot) KeyStatistics() *keyStatsData {
+	return s.info.(*plasmaSnapshotInfo).KeyStats
+}
+
 func (s *plasmaSnapshot) CountTotal(ctx IndexReaderContext, stopch StopChannel) (uint64, error) {
 	return uint64(s.MainSnap.Count()), nil
 }
@@ -1761,7 +1808,19 @@ func (s *plasmaSnapshot) iterEqualKeys(k IndexKey, it *plasma.MVCCIterator,
 func entry2BackEntry(entry secondaryIndexEntry) []byte {
 	buf := entry.Bytes()
 	kl := entry.lenKey()
-	if entry.isCountEncoded() {
+	if entry.isPayloadEncoded() {
+		// Store payload and its length, MSB of count indicates
+		// presence of payload
+		payload := entry.ReadPayload()
+		bentry := make([]byte, 0, kl+len(payload)+4)
+		bentry = append(bentry, buf[:kl]...)
+		bentry = append(bentry, payload...)
+		bentry = append(bentry, 0, 0, 0, 0)
+		l := len(bentry)
+		binary.LittleEndian.PutUint16(bentry[l-4:l-2], uint16(len(payload)))
+		bentry[l-1] |= byte(uint8(1) << 7)
+		return bentry
+	} else if entry.isCountEncoded() {
 		// Store count
 		dl := entry.lenDocId()
 		copy(buf[kl:kl+2], buf[kl+dl:kl+dl+2])
@@ -1779,6 +1838,12 @@ func entry2BackEntry(entry secondaryIndexEntry) []byte {
 func backEntry2entry(docid []byte, bentry []byte, buf []byte) []byte {
 	l := len(bentry)
 	count := int(binary.LittleEndian.Uint16(bentry[l-2 : l]))
-	entry, _ := NewSecondaryIndexEntry2(bentry[:l-2], docid, false, count, nil, buf[:0], false)
+	key, payload := bentry[:l-2], []byte(nil)
+	if count&0x8000 == 0x8000 {
+		count &= 0x7fff
+		plen := int(binary.LittleEndian.Uint16(bentry[l-4 : l-2]))
+		key, payload = bentry[:l-4-plen], bentry[l-4-plen:l-4]
+	}
+	entry, _ := NewSecondaryIndexEntry3(key, docid, payload, false, count, nil, buf[:0], false)
 	return entry.Bytes()
 }
@@ -33,7 +33,6 @@ import (
 	"encoding/json"
 
 	"github.com/couchbase/cbauth"
-	"github.com/couchbase/cbauth/metakv"
 	"github.com/couchbase/cbauth/service"
 	c "github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/fdb"
@@ -75,6 +74,8 @@ type ServiceMgr struct {
 	localhttp string
 
 	moveStatusCh chan error
+
+	snapTransfer *snapshotTransfer
 }
 
 type rebalanceContext struct {
@@ -148,6 +149,7 @@ func NewRebalanceMgr(supvCmdch MsgChannel, supvMsgch MsgChannel, config c.Config
 	mgr.rebalanceRunning = rebalanceRunning
 	mgr.rebalanceToken = rebalanceToken
 	mgr.localhttp = mgr.getLocalHttpAddr()
+	mgr.snapTransfer = newSnapshotTransfer(supvMsgch, config)
 
 	go mgr.recoverRebalance()
 	go mgr.run()
@@ -170,6 +172,9 @@ func (m *ServiceMgr) initService() {
 	http.HandleFunc("/moveIndex", m.handleMoveIndex)
 	http.HandleFunc("/moveIndexInternal", m.handleMoveIndexInternal)
 	http.HandleFunc("/nodeuuid", m.handleNodeuuid)
+	http.HandleFunc("/snapshotTransfer/manifest", m.snapTransfer.handleManifest)
+	http.HandleFunc("/snapshotTransfer/file", m.snapTransfer.handleFile)
+	http.HandleFunc("/snapshotTransfer/release", m.snapTransfer.handleRelease)
 }
 
 //update node list after restart
@@ -645,6 +650,8 @@ func (m *ServiceMgr) startRebalance(change service.TopologyChange) error {
 		}
 		elapsed := time.Since(start)
 		l.Infof("ServiceMgr::startRebalance Planner Time Taken %v", elapsed)
+
+		m.setBuildSource(transferTokens)
 	}
 
 	ctx := &rebalanceContext{
@@ -1811,7 +1818,7 @@ func (m *ServiceMgr) handleNodeuuid(w http.ResponseWriter, r *http.Request) {
 
 func (m *ServiceMgr) getCurrRebalTokens() (*RebalTokens, error) {
 
-	metainfo, err := metakv.ListAllChildren(RebalanceMetakvDir)
+	metainfo, err := c.Metakv().ListAllChildren(RebalanceMetakvDir)
 	if err != nil {
 		return nil, err
 	}
@@ -1943,7 +1950,7 @@ func (m *ServiceMgr) listenMoveIndex() {
 
 	cancel := make(chan struct{})
 	for {
-		err := metakv.RunObserveChildren(RebalanceMetakvDir, m.processMoveIndex, cancel)
+		err := c.Metakv().RunObserveChildren(RebalanceMetakvDir, m.processMoveIndex, cancel)
 		if err != nil {
 			l.Infof("ServiceMgr::listenMoveIndex metakv err %v. Retrying...", err)
 			time.Sleep(2 * time.Second)
@@ -2202,6 +2209,8 @@ func (m *ServiceMgr) initMoveIndex(req *manager.IndexRequest, nodes []string) (e
 		return nil, true
 	}
 
+	m.setBuildSource(transferTokens)
+
 	if err = m.registerRebalanceRunning(true); err != nil {
 		m.runCleanupPhaseLOCKED(MoveIndexTokenPath, false)
 		return err, false
@@ -2434,6 +2443,29 @@ func (m *ServiceMgr) genTransferToken(indexInst *c.IndexInst, sourceId string, d
 
 }
 
+//setBuildSource marks the indexes moved from another node to be built
+//from snapshots copied from the source, if peer transfer is enabled.
+//Only memory optimized snapshots can be copied, other indexes are built
+//from DCP.
+func (m *ServiceMgr) setBuildSource(transferTokens map[string]*c.TransferToken) {
+
+	cfg := m.config.Load()
+	if !cfg["rebalance.peer_transfer"].Bool() {
+		return
+	}
+
+	for ttid, tt := range transferTokens {
+		if tt.SourceId == "" {
+			continue
+		}
+		if !isSnapshotTransferSupported(&tt.IndexInst.Defn) {
+			continue
+		}
+		tt.BuildSource = c.TokenBuildSourcePeer
+		l.Infof("ServiceMgr::setBuildSource Token %v Build From Peer %v", ttid, tt.SourceId)
+	}
+}
+
 func (m *ServiceMgr) moveIndexDoneCallback(err error, cancel <-chan struct{}) {
 	m.runRebalanceCallback(cancel, func() { m.onMoveIndexDoneLOCKED(err) })
 }
@@ -2593,9 +2625,7 @@ func (m *ServiceMgr) getLocalHttpAddr() string {
 
 func getWithAuth(url string) (*http.Response, error) {
 
-	if !strings.HasPrefix(url, "http://") {
-		url = "http://" + url
-	}
+	url = c.TransportHTTPURL(url)
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -2606,15 +2636,13 @@ func getWithAuth(url string) (*http.Response, error) {
 		l.Errorf("ServiceMgr::getWithAuth Error setting auth %v", err)
 	}
 
-	client := http.Client{Timeout: time.Duration(rebalanceHttpTimeout) * time.Second}
+	client := c.TransportHTTPClient(time.Duration(rebalanceHttpTimeout) * time.Second)
 	return client.Do(req)
 }
 
 func postWithAuth(url string, bodyType string, body io.Reader) (*http.Response, error) {
 
-	if !strings.HasPrefix(url, "http://") {
-		url = "http://" + url
-	}
+	url = c.TransportHTTPURL(url)
 
 	req, err := http.NewRequest("POST", url, body)
 	if err != nil {
@@ -2627,7 +2655,7 @@ func postWithAuth(url string, bodyType string, body io.Reader) (*http.Response,
 		return nil, err
 	}
 
-	client := http.Client{Timeout: time.Duration(rebalanceHttpTimeout) * time.Second}
+	client := c.TransportHTTPClient(time.Duration(rebalanceHttpTimeout) * time.Second)
 	return client.Do(req)
 }
 
@@ -24,7 +24,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/couchbase/cbauth/metakv"
 	c "github.com/couchbase/indexing/secondary/common"
 	l "github.com/couchbase/indexing/secondary/logging"
 	"github.com/couchbase/indexing/secondary/manager"
@@ -44,6 +43,9 @@ type Rebalancer struct {
 	acceptedTokens map[string]*c.TransferToken
 	sourceTokens   map[string]*c.TransferToken
 
+	peerTransfers map[string]bool   //tokens with snapshot copy started
+	nodeAddrs     map[string]string //node uuid to http address
+
 	rebalToken *RebalanceToken
 	nodeId     string
 	master     bool
@@ -99,6 +101,8 @@ func NewRebalancer(transferTokens map[string]*c.TransferToken, rebalToken *Rebal
 
 		acceptedTokens: make(map[string]*c.TransferToken),
 		sourceTokens:   make(map[string]*c.TransferToken),
+		peerTransfers:  make(map[string]bool),
+		nodeAddrs:      make(map[string]string),
 		localaddr:      localaddr,
 
 		waitForTokenPublish: make(chan struct{}),
@@ -200,7 +204,7 @@ func (r *Rebalancer) observeRebalance() {
 
 	<-r.waitForTokenPublish
 
-	err := metakv.RunObserveChildren(RebalanceMetakvDir, r.processTokens, r.metakvCancel)
+	err := c.Metakv().RunObserveChildren(RebalanceMetakvDir, r.processTokens, r.metakvCancel)
 	if err != nil {
 		l.Infof("Rebalancer::observeRebalance Exiting On Metakv Error %v", err)
 		r.finish(err)
@@ -410,47 +414,22 @@ func (r *Rebalancer) processTokenAsDest(ttid string, tt *c.TransferToken) bool {
 	switch tt.State {
 	case c.TransferTokenCreated:
 
-		indexDefn := tt.IndexInst.Defn
-		indexDefn.Nodes = nil
-		indexDefn.Deferred = true
-		indexDefn.InstId = tt.InstId
-
-		ir := manager.IndexRequest{Index: indexDefn}
-		body, err := json.Marshal(&ir)
-		if err != nil {
-			l.Errorf("Rebalancer::processTokenAsDest Error marshal clone index %v", err)
-			r.setTransferTokenError(ttid, tt, err.Error())
-			return true
-		}
-
-		bodybuf := bytes.NewBuffer(body)
-
-		url := "/createIndexRebalance"
-		resp, err := postWithAuth(r.localaddr+url, "application/json", bodybuf)
-		if err != nil {
-			l.Errorf("Rebalancer::processTokenAsDest Error register clone index on %v %v", r.localaddr+url, err)
-			r.setTransferTokenError(ttid, tt, err.Error())
-			return true
-		}
+		if tt.BuildSource == c.TokenBuildSourcePeer {
+			r.mu.Lock()
+			_, ok := r.peerTransfers[ttid]
+			if !ok {
+				r.peerTransfers[ttid] = true
+			}
+			r.mu.Unlock()
 
-		response := new(manager.IndexResponse)
-		if err := convertResponse(resp, response); err != nil {
-			l.Errorf("Rebalancer::processTokenAsDest Error unmarshal response %v %v", r.localaddr+url, err)
-			r.setTransferTokenError(ttid, tt, err.Error())
-			return true
-		}
-		if response.Code == manager.RESP_ERROR {
-			l.Errorf("Rebalancer::processTokenAsDest Error cloning index %v %v", r.localaddr+url, response.Error)
-			r.setTransferTokenError(ttid, tt, response.Error)
+			if ok || !r.addToWaitGroup() {
+				return true
+			}
+			go r.transferIndexSnapshot(ttid, tt)
 			return true
 		}
 
-		tt.State = c.TransferTokenAccepted
-		r.setTransferTokenInMetakv(ttid, tt)
-
-		r.mu.Lock()
-		r.acceptedTokens[ttid] = tt
-		r.mu.Unlock()
+		r.createIndexForTransfer(ttid, tt)
 
 	case c.TransferTokenInitate:
 
@@ -499,6 +478,150 @@ func (r *Rebalancer) processTokenAsDest(ttid string, tt *c.TransferToken) bool {
 	return true
 }
 
+//createIndexForTransfer creates the index of transfer token as deferred
+//and accepts the token.
+func (r *Rebalancer) createIndexForTransfer(ttid string, tt *c.TransferToken) {
+
+	indexDefn := tt.IndexInst.Defn
+	indexDefn.Nodes = nil
+	indexDefn.Deferred = true
+	indexDefn.InstId = tt.InstId
+
+	ir := manager.IndexRequest{Index: indexDefn}
+	body, err := json.Marshal(&ir)
+	if err != nil {
+		l.Errorf("Rebalancer::createIndexForTransfer Error marshal clone index %v", err)
+		r.setTransferTokenError(ttid, tt, err.Error())
+		return
+	}
+
+	bodybuf := bytes.NewBuffer(body)
+
+	url := "/createIndexRebalance"
+	resp, err := postWithAuth(r.localaddr+url, "application/json", bodybuf)
+	if err != nil {
+		l.Errorf("Rebalancer::createIndexForTransfer Error register clone index on %v %v", r.localaddr+url, err)
+		r.setTransferTokenError(ttid, tt, err.Error())
+		return
+	}
+
+	response := new(manager.IndexResponse)
+	if err := convertResponse(resp, response); err != nil {
+		l.Errorf("Rebalancer::createIndexForTransfer Error unmarshal response %v %v", r.localaddr+url, err)
+		r.setTransferTokenError(ttid, tt, err.Error())
+		return
+	}
+	if response.Code == manager.RESP_ERROR {
+		l.Errorf("Rebalancer::createIndexForTransfer Error cloning index %v %v", r.localaddr+url, response.Error)
+		r.setTransferTokenError(ttid, tt, response.Error)
+		return
+	}
+
+	tt.State = c.TransferTokenAccepted
+	r.setTransferTokenInMetakv(ttid, tt)
+
+	r.mu.Lock()
+	r.acceptedTokens[ttid] = tt
+	r.mu.Unlock()
+}
+
+//transferIndexSnapshot copies the persisted snapshots of the index from
+//the source node into the storage dir, before the index is created.
+//Index is built from DCP if snapshots could not be copied.
+func (r *Rebalancer) transferIndexSnapshot(ttid string, tt *c.TransferToken) {
+
+	defer r.wg.Done()
+
+	stopch := make(chan struct{})
+	donech := make(chan struct{})
+	defer close(donech)
+	go func() {
+		select {
+		case <-r.cancel:
+		case <-r.done:
+		case <-donech:
+			return
+		}
+		close(stopch)
+	}()
+
+	cfg := r.config.Load()
+	t0 := time.Now()
+
+	addr, err := r.getNodeAddr(tt.SourceId)
+	if err == nil {
+		inst := tt.IndexInst
+		inst.InstId = tt.InstId
+		err = transferSnapshots(addr, &inst, cfg["storage_dir"].String(), stopch)
+	}
+
+	select {
+	case <-stopch:
+		l.Infof("Rebalancer::transferIndexSnapshot Cancelled for Token %v", ttid)
+		return
+	default:
+	}
+
+	if err != nil {
+		l.Warnf("Rebalancer::transferIndexSnapshot Unable to copy snapshot for Token %v "+
+			"from %v. Index will be built from DCP. Err %v", ttid, tt.SourceId, err)
+		tt.BuildSource = c.TokenBuildSourceDcp
+	} else {
+		l.Infof("Rebalancer::transferIndexSnapshot Copied snapshot for Token %v from %v. "+
+			"Took %v", ttid, addr, time.Since(t0))
+	}
+
+	r.createIndexForTransfer(ttid, tt)
+}
+
+//getNodeAddr returns the http address of indexer node `nodeId`.
+func (r *Rebalancer) getNodeAddr(nodeId string) (string, error) {
+
+	r.mu.RLock()
+	addr, ok := r.nodeAddrs[nodeId]
+	r.mu.RUnlock()
+	if ok {
+		return addr, nil
+	}
+
+	cfg := r.config.Load()
+	url, err := c.ClusterAuthUrl(cfg["clusterAddr"].String())
+	if err != nil {
+		return "", err
+	}
+	cinfo, err := c.NewClusterInfoCache(url, DEFAULT_POOL)
+	if err != nil {
+		return "", err
+	}
+	if err := cinfo.Fetch(); err != nil {
+		return "", err
+	}
+
+	for _, nid := range cinfo.GetNodesByServiceType(c.INDEX_HTTP_SERVICE) {
+		haddr, err := cinfo.GetServiceAddress(nid, c.INDEX_HTTP_SERVICE)
+		if err != nil {
+			continue
+		}
+
+		resp, err := getWithAuth(haddr + "/nodeuuid")
+		if err != nil {
+			l.Warnf("Rebalancer::getNodeAddr Unable to Fetch Node UUID %v %v", haddr, err)
+			continue
+		}
+		bytes, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if string(bytes) == nodeId {
+			r.mu.Lock()
+			r.nodeAddrs[nodeId] = haddr
+			r.mu.Unlock()
+			return haddr, nil
+		}
+	}
+
+	return "", fmt.Errorf("Unable to find Index service for node %v", nodeId)
+}
+
 func (r *Rebalancer) checkValidNotifyStateDest(ttid string, tt *c.TransferToken) bool {
 
 	r.mu.Lock()
@@ -818,6 +818,9 @@ func (api *restServer) doMultiScan(w http.ResponseWriter, request *http.Request)
 	var ts *qclient.TsConsistency
 	distinct, limit, offset, stale, reverse := false, int64(100), int64(0), "ok", false
 	var projection *qclient.IndexProjection
+	var groupAggr *qclient.GroupAggr
+	var indexSort *qclient.IndexSort
+	var filter string
 
 	bytes, err := ioutil.ReadAll(request.Body)
 	if err := json.Unmarshal(bytes, &params); err != nil {
@@ -852,6 +855,42 @@ func (api *restServer) doMultiScan(w http.ResponseWriter, request *http.Request)
 		}
 	}
 
+	if value, ok = params["groupAggr"]; ok && value != nil {
+		if _, ok = value.(string); ok == false {
+			msg := "invalid groupAggr type"
+			http.Error(w, jsonstr(msg), http.StatusBadRequest)
+			return
+		}
+		groupAggr, err = getGroupAggr([]byte(value.(string)))
+		if err != nil {
+			msg := "invalid groupAggr: %v"
+			http.Error(w, jsonstr(msg, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if value, ok = params["sort"]; ok && value != nil {
+		if _, ok = value.(string); ok == false {
+			msg := "invalid sort type"
+			http.Error(w, jsonstr(msg), http.StatusBadRequest)
+			return
+		}
+		indexSort, err = getIndexSort([]byte(value.(string)))
+		if err != nil {
+			msg := "invalid sort: %v"
+			http.Error(w, jsonstr(msg, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if value, ok = params["filter"]; ok && value != nil {
+		if filter, ok = value.(string); ok == false {
+			msg := "invalid filter type"
+			http.Error(w, jsonstr(msg), http.StatusBadRequest)
+			return
+		}
+	}
+
 	if value, ok = params["reverse"]; ok && value != nil {
 		if _, ok = value.(bool); ok == false {
 			msg := "invalid reverse type"
@@ -932,8 +971,8 @@ func (api *restServer) doMultiScan(w http.ResponseWriter, request *http.Request)
 	err = nil
 	e := api.client.MultiScan(
 		uint64(index.Definition.DefnId), "", scans, reverse,
-		distinct, projection, offset, limit,
-		cons, ts,
+		distinct, projection, groupAggr, indexSort, filter, offset,
+		limit, cons, ts,
 		func(res qclient.ResponseReader) bool {
 			if err = res.Error(); err != nil {
 				return false
@@ -1363,6 +1402,22 @@ func getProjection(arg []byte) (*qclient.IndexProjection, error) {
 	return &proj, nil
 }
 
+func getGroupAggr(arg []byte) (*qclient.GroupAggr, error) {
+	var groupAggr qclient.GroupAggr
+	if err := json.Unmarshal(arg, &groupAggr); err != nil {
+		return nil, err
+	}
+	return &groupAggr, nil
+}
+
+func getIndexSort(arg []byte) (*qclient.IndexSort, error) {
+	var indexSort qclient.IndexSort
+	if err := json.Unmarshal(arg, &indexSort); err != nil {
+		return nil, err
+	}
+	return &indexSort, nil
+}
+
 var mstale2consistency = map[string]c.Consistency{
 	"ok":      c.AnyConsistency,
 	"false":   c.SessionConsistency,
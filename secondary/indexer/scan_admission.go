@@ -0,0 +1,194 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// ScanAdmissionController decides whether a scan request is served by
+// the indexer. Admitted requests must call the returned release function
+// once they are done. Rejected requests fail with common.ErrScanRejected,
+// which clients retry after backing off.
+type ScanAdmissionController interface {
+	Admit(req *ScanRequest) (release func(), err error)
+	UpdateConfig(config common.Config)
+}
+
+// Idle admission state of buckets and indexes is dropped at most once in
+// this interval.
+const admissionPruneInterval = time.Minute
+
+// scanAdmissionControl is the default admission controller, limiting the
+// number of concurrent scans and the rate of scans per bucket and per
+// index instance. Rates are enforced with token buckets, allowing bursts
+// of up to `burst` scans. Zero limits are unlimited.
+type scanAdmissionControl struct {
+	config atomic.Value // *admissionConfig
+
+	mu       sync.Mutex
+	buckets  map[string]*admissionState
+	indexes  map[common.IndexInstId]*admissionState
+	prunedAt time.Time
+}
+
+type admissionConfig struct {
+	bucket admissionLimits
+	index  admissionLimits
+}
+
+type admissionLimits struct {
+	concurrency int64
+	rate        float64 // scans per second
+	burst       float64
+}
+
+type admissionState struct {
+	active int64
+	tokens float64
+	last   time.Time
+}
+
+func NewScanAdmissionControl(config common.Config) ScanAdmissionController {
+	ac := &scanAdmissionControl{
+		buckets:  make(map[string]*admissionState),
+		indexes:  make(map[common.IndexInstId]*admissionState),
+		prunedAt: time.Now(),
+	}
+	ac.UpdateConfig(config)
+	return ac
+}
+
+func (ac *scanAdmissionControl) UpdateConfig(config common.Config) {
+	limits := func(prefix string) admissionLimits {
+		l := admissionLimits{
+			concurrency: int64(config[prefix+"_concurrency"].Int()),
+			rate:        float64(config[prefix+"_rate"].Int()),
+			burst:       float64(config[prefix+"_burst"].Int()),
+		}
+		if l.burst < 1 {
+			l.burst = l.rate
+		}
+		if l.burst < 1 {
+			l.burst = 1
+		}
+		return l
+	}
+	ac.config.Store(&admissionConfig{
+		bucket: limits("settings.scan_admission.bucket"),
+		index:  limits("settings.scan_admission.index"),
+	})
+}
+
+func (ac *scanAdmissionControl) Admit(req *ScanRequest) (func(), error) {
+	cfg := ac.config.Load().(*admissionConfig)
+	if !cfg.bucket.enabled() && !cfg.index.enabled() {
+		return func() {}, nil
+	}
+
+	now := time.Now()
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	if now.Sub(ac.prunedAt) > admissionPruneInterval {
+		ac.prune(cfg, now)
+	}
+
+	bs, ok := ac.buckets[req.Bucket]
+	if !ok {
+		bs = newAdmissionState(&cfg.bucket, now)
+		ac.buckets[req.Bucket] = bs
+	}
+	is, ok := ac.indexes[req.IndexInstId]
+	if !ok {
+		is = newAdmissionState(&cfg.index, now)
+		ac.indexes[req.IndexInstId] = is
+	}
+
+	// check both before taking from either, rejected scan must not
+	// consume tokens.
+	if !bs.allow(&cfg.bucket, now) || !is.allow(&cfg.index, now) {
+		return nil, common.ErrScanRejected
+	}
+	bs.admit(&cfg.bucket)
+	is.admit(&cfg.index)
+
+	release := func() {
+		ac.mu.Lock()
+		defer ac.mu.Unlock()
+		bs.active--
+		is.active--
+	}
+	return release, nil
+}
+
+// prune state that is identical to a newly created one.
+func (ac *scanAdmissionControl) prune(cfg *admissionConfig, now time.Time) {
+	for bucket, st := range ac.buckets {
+		if st.idle(&cfg.bucket, now) {
+			delete(ac.buckets, bucket)
+		}
+	}
+	for instId, st := range ac.indexes {
+		if st.idle(&cfg.index, now) {
+			delete(ac.indexes, instId)
+		}
+	}
+	ac.prunedAt = now
+}
+
+func (l *admissionLimits) enabled() bool {
+	return l.concurrency > 0 || l.rate > 0
+}
+
+func newAdmissionState(l *admissionLimits, now time.Time) *admissionState {
+	return &admissionState{tokens: l.burst, last: now}
+}
+
+// allow refills tokens accumulated since the last call and returns true
+// if a scan can be admitted within limits `l`.
+func (st *admissionState) allow(l *admissionLimits, now time.Time) bool {
+	if l.concurrency > 0 && st.active >= l.concurrency {
+		return false
+	}
+	if l.rate <= 0 {
+		return true
+	}
+	if elapsed := now.Sub(st.last).Seconds(); elapsed > 0 {
+		st.tokens += elapsed * l.rate
+		st.last = now
+	}
+	if st.tokens > l.burst {
+		st.tokens = l.burst
+	}
+	return st.tokens >= 1
+}
+
+func (st *admissionState) admit(l *admissionLimits) {
+	st.active++
+	if l.rate > 0 {
+		st.tokens--
+	}
+}
+
+func (st *admissionState) idle(l *admissionLimits, now time.Time) bool {
+	if st.active > 0 {
+		return false
+	}
+	if l.rate <= 0 {
+		return true
+	}
+	return st.tokens+now.Sub(st.last).Seconds()*l.rate >= l.burst
+}
@@ -0,0 +1,96 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestScanAdmissionConcurrency(t *testing.T) {
+	config := common.SystemConfig.SectionConfig("indexer.", true).Clone()
+	config.SetValue("settings.scan_admission.index_concurrency", 2)
+	config.SetValue("settings.scan_admission.bucket_concurrency", 3)
+	ac := NewScanAdmissionControl(config)
+
+	req1 := &ScanRequest{Bucket: "default", IndexInstId: 1}
+	req2 := &ScanRequest{Bucket: "default", IndexInstId: 2}
+
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := ac.Admit(req1)
+		if err != nil {
+			t.Fatalf("scan %v on index 1 rejected: %v", i, err)
+		}
+		releases = append(releases, release)
+	}
+	if _, err := ac.Admit(req1); err != common.ErrScanRejected {
+		t.Fatalf("expected %v for index limit, received %v",
+			common.ErrScanRejected, err)
+	}
+	release, err := ac.Admit(req2)
+	if err != nil {
+		t.Fatalf("scan on index 2 rejected: %v", err)
+	}
+	releases = append(releases, release)
+	if _, err := ac.Admit(req2); err != common.ErrScanRejected {
+		t.Fatalf("expected %v for bucket limit, received %v",
+			common.ErrScanRejected, err)
+	}
+
+	releases[0]()
+	if _, err := ac.Admit(req1); err != nil {
+		t.Fatalf("scan on index 1 rejected after release: %v", err)
+	}
+
+	// limits are disabled by config update.
+	config.SetValue("settings.scan_admission.index_concurrency", 0)
+	config.SetValue("settings.scan_admission.bucket_concurrency", 0)
+	ac.UpdateConfig(config)
+	if _, err := ac.Admit(req1); err != nil {
+		t.Fatalf("scan rejected without limits: %v", err)
+	}
+}
+
+func TestScanAdmissionRate(t *testing.T) {
+	limits := &admissionLimits{rate: 10, burst: 2}
+	now := time.Now()
+	st := newAdmissionState(limits, now)
+
+	for i := 0; i < 2; i++ {
+		if !st.allow(limits, now) {
+			t.Fatalf("scan %v within burst rejected", i)
+		}
+		st.admit(limits)
+		st.active--
+	}
+	if st.allow(limits, now) {
+		t.Fatalf("scan beyond burst admitted")
+	}
+	if st.idle(limits, now) {
+		t.Fatalf("expected state with consumed tokens to be busy")
+	}
+
+	now = now.Add(100 * time.Millisecond)
+	if !st.allow(limits, now) {
+		t.Fatalf("scan rejected after refill")
+	}
+	st.admit(limits)
+	st.active--
+	if st.allow(limits, now) {
+		t.Fatalf("scan admitted beyond refilled tokens")
+	}
+
+	// tokens do not accumulate beyond burst.
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if !st.allow(limits, now) {
+			t.Fatalf("scan %v within burst rejected", i)
+		}
+		st.admit(limits)
+		st.active--
+	}
+	if st.allow(limits, now) {
+		t.Fatalf("scan beyond burst admitted")
+	}
+}
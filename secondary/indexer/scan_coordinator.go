@@ -14,6 +14,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"sort"
 	"strings"
 	"sync"
@@ -37,6 +38,7 @@ var (
 	ErrSnapNotAvailable   = errors.New("No snapshot available for scan")
 	ErrUnsupportedRequest = errors.New("Unsupported query request")
 	ErrVbuuidMismatch     = errors.New("Mismatch in session vbuuids")
+	ErrVersionMismatch    = errors.New("Mismatch in index instance version")
 )
 
 var secKeyBufPool *common.BytesBufPool
@@ -54,8 +56,13 @@ const (
 	ScanAllReq                    = "scanAll"
 	HeloReq                       = "helo"
 	MultiScanCountReq             = "multiscancount"
+	PinSnapshotReq                = "pinSnapshot"
+	UnpinSnapshotReq              = "unpinSnapshot"
 )
 
+//interval at which expired snapshot leases are released
+const snapshotLeaseExpiryInterval = time.Second
+
 type ScanRequest struct {
 	ScanType    ScanReqType
 	DefnID      uint64
@@ -87,6 +94,16 @@ type ScanRequest struct {
 	Distinct          bool
 	Offset            int64
 	projectPrimaryKey bool
+	GroupAggr         *GroupAggr
+	PartitionIds      []common.PartitionId
+	Sort              *IndexSort
+	Resume            *ScanResume
+	Filter            *ScanFilter
+
+	// Scan the snapshot pinned by lease, instead of a snapshot
+	// satisfying the consistency
+	SnapshotLease uint64
+	leaseTime     time.Duration
 
 	// Rollback Time
 	rollbackTime int64
@@ -105,6 +122,10 @@ type ScanRequest struct {
 	sharedBufferLen int
 
 	hasRollback *atomic.Value
+
+	// outcome of the request, for the scan log
+	rowsReturned uint64
+	bytesRead    uint64
 }
 
 type Projection struct {
@@ -113,6 +134,39 @@ type Projection struct {
 	entryKeysEmpty bool
 }
 
+// GroupAggr is the group-by/aggregate spec of a scan. Rows qualifying
+// the scan are grouped on the index keys at the Group positions and one
+// row is returned per group, holding group key values followed by the
+// value of each of the Aggrs.
+type GroupAggr struct {
+	Group []int
+	Aggrs []Aggregate
+
+	// true if Group positions are a prefix of the index keys, so that
+	// entries of a group are adjacent in index order. The order holds
+	// within a slice and a scan only, groups are merged in full when
+	// the request spans more than one of either.
+	IsLeadingGroup bool
+}
+
+type Aggregate struct {
+	AggrFunc common.AggrFuncType
+	KeyPos   int // -1 for COUNT(*)
+	Distinct bool
+}
+
+// IndexSort orders the rows returned by a scan on one or more keys of
+// the row, instead of index order. Offset and limit apply to the sorted
+// rows, only the top offset+limit rows are retained while scanning.
+type IndexSort struct {
+	Keys []SortKey
+}
+
+type SortKey struct {
+	KeyPos int // position of key in the returned row
+	Desc   bool
+}
+
 type Scan struct {
 	Low      IndexKey  // Overall Low for a Span. Computed from composite filters (Ranges)
 	High     IndexKey  // Overall High for a Span. Computed from composite filters (Ranges)
@@ -274,6 +328,14 @@ func (r ScanRequest) String() string {
 		str += fmt.Sprintf(", consistency:%s", strings.ToLower(r.Consistency.String()))
 	}
 
+	if r.Resume != nil {
+		str += ", resumed"
+	}
+
+	if r.SnapshotLease != 0 {
+		str += fmt.Sprintf(", snapshotLease:%v", r.SnapshotLease)
+	}
+
 	if r.RequestId != "" {
 		str += fmt.Sprintf(", requestId:%v", r.RequestId)
 	}
@@ -387,6 +449,12 @@ type scanCoordinator struct {
 	stats IndexerStatsHolder
 
 	indexerState atomic.Value
+
+	admission ScanAdmissionController
+
+	pinned *pinnedSnapshotContainer
+
+	scanLog *scanLog
 }
 
 func (s *scanCoordinator) getIndexerState() common.IndexerState {
@@ -413,6 +481,9 @@ func NewScanCoordinator(supvCmdch MsgChannel, supvMsgch MsgChannel,
 		snapshotNotifych: snapshotNotifych,
 		logPrefix:        "ScanCoordinator",
 		reqCounter:       0,
+		admission:        NewScanAdmissionControl(config),
+		pinned:           newPinnedSnapshotContainer(),
+		scanLog:          newScanLog(config),
 	}
 
 	s.config.Store(config)
@@ -434,6 +505,8 @@ func NewScanCoordinator(supvCmdch MsgChannel, supvMsgch MsgChannel,
 
 	s.setIndexerState(common.INDEXER_BOOTSTRAP)
 
+	http.HandleFunc("/scanLog", s.scanLog.handleScanLog)
+
 	// main loop
 	go s.run()
 	go s.listenSnapshot()
@@ -474,6 +547,10 @@ func (s *scanCoordinator) handleStats(cmd Message) {
 	stats := s.stats.Get()
 	st := s.serv.Statistics()
 	stats.numConnections.Set(st.Connections)
+	stats.queryportSentRawBytes.Set(st.SentRawBytes)
+	stats.queryportSentCompBytes.Set(st.SentCompressedBytes)
+	stats.queryportRcvdRawBytes.Set(st.RcvdRawBytes)
+	stats.queryportRcvdCompBytes.Set(st.RcvdCompressedBytes)
 
 	// Compute counts asynchronously and reply to stats request
 	go func() {
@@ -502,9 +579,15 @@ func (s *scanCoordinator) handleStats(cmd Message) {
 }
 
 func (s *scanCoordinator) run() {
+	ticker := time.NewTicker(snapshotLeaseExpiryInterval)
+	defer ticker.Stop()
+
 loop:
 	for {
 		select {
+		case <-ticker.C:
+			s.expirePinnedSnapshots()
+
 		case cmd, ok := <-s.supvCmdch:
 			if ok {
 				if cmd.GetMsgType() == SCAN_COORD_SHUTDOWN {
@@ -1140,6 +1223,27 @@ func (s *scanCoordinator) newRequest(protoReq interface{},
 		}
 	}
 
+	// scans on a pinned snapshot don't wait for a snapshot
+	// satisfying the consistency.
+	setSnapshotLease := func(lease uint64,
+		cons common.Consistency, vector *protobuf.TsConsistency) {
+
+		r.SnapshotLease = lease
+		if lease != 0 {
+			cons, vector = common.AnyConsistency, nil
+		}
+		setConsistency(cons, vector)
+	}
+
+	// resume a scan that failed after returning entries, once the
+	// index and the scan are known.
+	setResume := func(resume *protobuf.ScanResume) {
+		if resume == nil || err != nil {
+			return
+		}
+		r.Resume, err = newScanResume(r, resume)
+	}
+
 	setIndexParams := func() {
 		var localErr error
 		defer func() {
@@ -1167,6 +1271,12 @@ func (s *scanCoordinator) newRequest(protoReq interface{},
 		}
 	}
 
+	checkInstVersion := func(version *uint64) {
+		if err == nil {
+			err = checkScanInstVersion(&r.IndexInst, version)
+		}
+	}
+
 	switch req := protoReq.(type) {
 	case *protobuf.HeloRequest:
 		r.ScanType = HeloReq
@@ -1200,7 +1310,8 @@ func (s *scanCoordinator) newRequest(protoReq interface{},
 		}
 
 		setIndexParams()
-		setConsistency(cons, vector)
+		checkInstVersion(req.InstVersion)
+		setSnapshotLease(req.GetSnapshotLease(), cons, vector)
 		fillRanges(
 			req.GetSpan().GetRange().GetLow(),
 			req.GetSpan().GetRange().GetHigh(),
@@ -1232,20 +1343,52 @@ func (s *scanCoordinator) newRequest(protoReq interface{},
 			return
 		}
 		setIndexParams()
-		setConsistency(cons, vector)
+		checkInstVersion(req.InstVersion)
+		setSnapshotLease(req.GetSnapshotLease(), cons, vector)
 		if proj != nil {
 			var localerr error
-			if r.Indexprojection, localerr = validateIndexProjection(proj, len(r.IndexInst.Defn.SecExprs)); localerr != nil {
+			if r.Indexprojection, localerr = validateIndexProjection(proj, len(r.IndexInst.Defn.SecExprs),
+				len(r.IndexInst.Defn.Include)); localerr != nil {
 				err = localerr
 				return
 			}
 			r.projectPrimaryKey = *proj.PrimaryKey
 		}
+		if groupAggr := req.GetGroupAggr(); groupAggr != nil {
+			var localerr error
+			if r.GroupAggr, localerr = validateGroupAggr(groupAggr, len(r.IndexInst.Defn.SecExprs), r.isPrimary); localerr != nil {
+				err = localerr
+				return
+			}
+			// Rows are fed to the aggregator in full, documents
+			// are not returned to the client.
+			r.Indexprojection = nil
+			r.projectPrimaryKey = false
+		}
+		for _, partnId := range req.GetPartitionIds() {
+			r.PartitionIds = append(r.PartitionIds, common.PartitionId(partnId))
+		}
+		r.PartitionIds = scanPartitionOrder(&r.IndexInst.Defn, r.PartitionIds)
+		if sort := req.GetSort(); sort != nil {
+			var localerr error
+			if r.Sort, localerr = validateIndexSort(sort, r); localerr != nil {
+				err = localerr
+				return
+			}
+		}
+		if filter := req.GetFilter(); filter != "" {
+			var localerr error
+			if r.Filter, localerr = newScanFilter(filter, r.isPrimary); localerr != nil {
+				err = localerr
+				return
+			}
+		}
 		fillRanges(
 			req.GetSpan().GetRange().GetLow(),
 			req.GetSpan().GetRange().GetHigh(),
 			req.GetSpan().GetEquals())
 		fillScans(req.GetScans())
+		setResume(req.GetResume())
 
 	case *protobuf.ScanAllRequest:
 		r.DefnID = req.GetDefnID()
@@ -1264,7 +1407,33 @@ func (s *scanCoordinator) newRequest(protoReq interface{},
 		}
 
 		setIndexParams()
-		setConsistency(cons, vector)
+		checkInstVersion(req.InstVersion)
+		setSnapshotLease(req.GetSnapshotLease(), cons, vector)
+		r.PartitionIds = scanPartitionOrder(&r.IndexInst.Defn, nil)
+		setResume(req.GetResume())
+
+	case *protobuf.PinSnapshotRequest:
+		r.DefnID = req.GetDefnID()
+		r.RequestId = req.GetRequestId()
+		r.rollbackTime = req.GetRollbackTime()
+		cons := common.Consistency(req.GetCons())
+		vector := req.GetVector()
+		r.ScanType = PinSnapshotReq
+		r.leaseTime = time.Duration(req.GetLeaseTime()) * time.Millisecond
+
+		if isBootstrapMode {
+			err = common.ErrIndexerInBootstrap
+			return
+		}
+
+		setIndexParams()
+		// lease id is specified to renew the lease of pinned snapshot
+		setSnapshotLease(req.GetLeaseId(), cons, vector)
+
+	case *protobuf.UnpinSnapshotRequest:
+		r.ScanType = UnpinSnapshotReq
+		r.SnapshotLease = req.GetLeaseId()
+
 	default:
 		err = ErrUnsupportedRequest
 	}
@@ -1272,15 +1441,17 @@ func (s *scanCoordinator) newRequest(protoReq interface{},
 	return
 }
 
-func validateIndexProjection(projection *protobuf.IndexProjection, cklen int) (*Projection, error) {
-	if len(projection.EntryKeys) > cklen {
+// Entry keys of projection at positions following the `cklen` index keys
+// refer to the `inclen` include columns of index, in their order.
+func validateIndexProjection(projection *protobuf.IndexProjection, cklen, inclen int) (*Projection, error) {
+	if len(projection.EntryKeys) > cklen+inclen {
 		e := errors.New(fmt.Sprintf("Invalid number of Entry Keys %v in IndexProjection", len(projection.EntryKeys)))
 		return nil, e
 	}
 
-	projectionKeys := make([]bool, cklen)
+	projectionKeys := make([]bool, cklen+inclen)
 	for _, position := range projection.EntryKeys {
-		if position >= int64(cklen) || position < 0 {
+		if position >= int64(cklen+inclen) || position < 0 {
 			e := errors.New(fmt.Sprintf("Invalid Entry Key %v in IndexProjection", position))
 			return nil, e
 		}
@@ -1288,8 +1459,8 @@ func validateIndexProjection(projection *protobuf.IndexProjection, cklen int) (*
 	}
 
 	projectAllSecKeys := true
-	for _, sp := range projectionKeys {
-		if sp == false {
+	for i, sp := range projectionKeys {
+		if (i < cklen && sp == false) || (i >= cklen && sp == true) {
 			projectAllSecKeys = false
 		}
 	}
@@ -1302,6 +1473,144 @@ func validateIndexProjection(projection *protobuf.IndexProjection, cklen int) (*
 	return indexProjection, nil
 }
 
+// scanPartitionOrder orders the partitions to be scanned for a range
+// partitioned index by their bounds, so that entries are returned in
+// index order when the leading key is the partition key. All partitions
+// hosted by the indexer are scanned if partnIds is empty.
+func scanPartitionOrder(defn *common.IndexDefn,
+	partnIds []common.PartitionId) []common.PartitionId {
+
+	if defn.PartitionScheme != common.RANGE {
+		return partnIds
+	}
+
+	if len(partnIds) == 0 {
+		partnIds = append(partnIds, defn.HostedPartitions()...)
+	}
+
+	if len(defn.Desc) > 0 && defn.Desc[0] {
+		sort.Sort(sort.Reverse(partitionIds(partnIds)))
+	} else {
+		sort.Sort(partitionIds(partnIds))
+	}
+	return partnIds
+}
+
+type partitionIds []common.PartitionId
+
+func (p partitionIds) Len() int           { return len(p) }
+func (p partitionIds) Less(i, j int) bool { return p[i] < p[j] }
+func (p partitionIds) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func validateGroupAggr(groupAggr *protobuf.GroupAggr, cklen int, isPrimary bool) (*GroupAggr, error) {
+	if isPrimary {
+		return nil, errors.New("GroupAggr is not supported on primary index")
+	}
+
+	if len(groupAggr.GetGroupKeys()) == 0 && len(groupAggr.GetAggrs()) == 0 {
+		return nil, errors.New("Invalid GroupAggr with no group keys and aggregates")
+	}
+
+	ga := &GroupAggr{IsLeadingGroup: true}
+	for i, gk := range groupAggr.GetGroupKeys() {
+		pos := int(gk.GetKeyPos())
+		if pos < 0 || pos >= cklen {
+			return nil, fmt.Errorf("Invalid group key position %v in GroupAggr", pos)
+		}
+		if pos != i {
+			ga.IsLeadingGroup = false
+		}
+		ga.Group = append(ga.Group, pos)
+	}
+
+	for _, aggr := range groupAggr.GetAggrs() {
+		fn := common.AggrFuncType(aggr.GetAggrFunc())
+		pos := int(aggr.GetKeyPos())
+		if fn > common.AGG_MAX {
+			return nil, fmt.Errorf("Invalid aggregate function %v in GroupAggr", aggr.GetAggrFunc())
+		}
+		if pos >= cklen || pos < -1 || (pos == -1 && fn != common.AGG_COUNT) {
+			return nil, fmt.Errorf("Invalid key position %v for aggregate %v in GroupAggr", pos, fn)
+		}
+		ga.Aggrs = append(ga.Aggrs, Aggregate{
+			AggrFunc: fn,
+			KeyPos:   pos,
+			Distinct: aggr.GetDistinct(),
+		})
+	}
+
+	return ga, nil
+}
+
+// validateIndexSort returns the sort spec of scan r, or nil if rows are
+// already returned in the requested order by scanning the index.
+func validateIndexSort(sort *protobuf.IndexSort, r *ScanRequest) (*IndexSort, error) {
+	if r.isPrimary {
+		return nil, errors.New("IndexSort is not supported on primary index")
+	}
+
+	if len(sort.GetSortKeys()) == 0 {
+		return nil, errors.New("Invalid IndexSort with no sort keys")
+	}
+
+	rowKeys := scanRowKeys(r)
+	inIndexOrder := rowKeys != nil &&
+		(r.IndexInst.Defn.GetNumPartitions() <= 1 || len(r.PartitionIds) == 1)
+
+	is := &IndexSort{}
+	for i, sk := range sort.GetSortKeys() {
+		pos := int(sk.GetKeyPos())
+		if pos < 0 || pos >= scanRowLen(r) {
+			return nil, fmt.Errorf("Invalid sort key position %v in IndexSort", pos)
+		}
+		desc := sk.GetDesc()
+		if inIndexOrder {
+			indexDesc := i < len(r.IndexInst.Defn.Desc) && r.IndexInst.Defn.Desc[i]
+			inIndexOrder = rowKeys[pos] == i && i < len(r.IndexInst.Defn.SecExprs) &&
+				desc == indexDesc
+		}
+		is.Keys = append(is.Keys, SortKey{KeyPos: pos, Desc: desc})
+	}
+
+	if inIndexOrder {
+		// entries are scanned in the requested order, offset and
+		// limit are pushed down to the scan as is.
+		return nil, nil
+	}
+	return is, nil
+}
+
+// scanRowKeys returns the index key position of every key in a row
+// returned by scan r, nil if rows are computed from index keys.
+func scanRowKeys(r *ScanRequest) []int {
+	if r.GroupAggr != nil {
+		return nil
+	}
+
+	var rowKeys []int
+	if r.Indexprojection == nil || !r.Indexprojection.projectSecKeys {
+		for i := range r.IndexInst.Defn.SecExprs {
+			rowKeys = append(rowKeys, i)
+		}
+		return rowKeys
+	}
+	// include columns are projected after the index keys
+	for i, projected := range r.Indexprojection.projectionKeys {
+		if projected {
+			rowKeys = append(rowKeys, i)
+		}
+	}
+	return rowKeys
+}
+
+// scanRowLen returns the number of keys in a row returned by scan r.
+func scanRowLen(r *ScanRequest) int {
+	if r.GroupAggr != nil {
+		return len(r.GroupAggr.Group) + len(r.GroupAggr.Aggrs)
+	}
+	return len(scanRowKeys(r))
+}
+
 // Before starting the index scan, we have to find out the snapshot timestamp
 // that can fullfil this query by considering atleast-timestamp provided in
 // the query request. A timestamp request message is sent to the storage
@@ -1311,6 +1620,10 @@ func validateIndexProjection(projection *protobuf.IndexProjection, cklen int) (*
 // will block wait.
 // This mechanism can be used to implement RYOW.
 func (s *scanCoordinator) getRequestedIndexSnapshot(r *ScanRequest) (snap IndexSnapshot, err error) {
+	if r.SnapshotLease != 0 {
+		return s.pinned.Get(r.SnapshotLease, r.IndexInstId)
+	}
+
 	snapshot, err := func() (IndexSnapshot, error) {
 		s.mu.RLock()
 		defer s.mu.RUnlock()
@@ -1432,6 +1745,11 @@ func (s *scanCoordinator) tryRespondWithError(w ScanResponseWriter, req *ScanReq
 		} else if err == common.ErrIndexNotFound {
 			stats := s.stats.Get()
 			stats.notFoundError.Add(1)
+		} else if err == common.ErrScanRejected {
+			if req.Stats != nil {
+				req.Stats.scanRejectedError.Add(1)
+			}
+			logging.Verbosef("%s RESPONSE status:(error = %s), requestId: %v", req.LogPrefix, err, req.RequestId)
 		} else if err == common.ErrIndexerInBootstrap {
 			logging.Verbosef("%s REQUEST %s", req.LogPrefix, req)
 			logging.Verbosef("%s RESPONSE status:(error = %s), requestId: %v", req.LogPrefix, err, req.RequestId)
@@ -1464,6 +1782,15 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, conn net.Conn,
 		return
 	}
 
+	if req.ScanType == UnpinSnapshotReq {
+		s.handleUnpinSnapshotRequest(req, w)
+		return
+	}
+
+	defer func() {
+		s.scanLog.Sample(protoReq, req, ttime, w.err)
+	}()
+
 	logging.Verbosef("%s REQUEST %s", req.LogPrefix, req)
 
 	if req.Consistency != nil {
@@ -1486,6 +1813,12 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, conn net.Conn,
 		return
 	}
 
+	release, err := s.admission.Admit(req)
+	if s.tryRespondWithError(w, req, err) {
+		return
+	}
+	defer release()
+
 	if req.Stats != nil {
 		req.Stats.numRequests.Add(1)
 		req.Stats.scanReqInitDuration.Add(time.Now().Sub(ttime).Nanoseconds())
@@ -1519,7 +1852,6 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, conn net.Conn,
 	if req.Ctx != nil {
 		req.Ctx.Done()
 	}
-
 }
 
 func (s *scanCoordinator) processRequest(req *ScanRequest, w ScanResponseWriter,
@@ -1534,6 +1866,8 @@ func (s *scanCoordinator) processRequest(req *ScanRequest, w ScanResponseWriter,
 		s.handleMultiScanCountRequest(req, w, is, t0)
 	case StatsReq:
 		s.handleStatsRequest(req, w, is)
+	case PinSnapshotReq:
+		s.handlePinSnapshotRequest(req, w, is)
 	}
 }
 
@@ -1542,6 +1876,80 @@ func (s *scanCoordinator) handleHeloRequest(req *ScanRequest, w ScanResponseWrit
 	s.handleError(req.LogPrefix, err)
 }
 
+// handlePinSnapshotRequest pins the snapshot for scans at the same point
+// in time, or renews the lease of an already pinned snapshot.
+func (s *scanCoordinator) handlePinSnapshotRequest(req *ScanRequest, w ScanResponseWriter,
+	is IndexSnapshot) {
+
+	cfg := s.config.Load()
+	leaseTime := req.leaseTime
+	if leaseTime == 0 {
+		leaseTime = time.Duration(cfg["settings.snapshot_lease.default_time"].Int()) * time.Millisecond
+	}
+	maxTime := time.Duration(cfg["settings.snapshot_lease.max_time"].Int()) * time.Millisecond
+	if maxTime > 0 && leaseTime > maxTime {
+		leaseTime = maxTime
+	}
+
+	var lease snapshotLease
+	var err error
+	if req.SnapshotLease != 0 {
+		lease, err = s.pinned.Renew(req.SnapshotLease, req.IndexInstId, leaseTime)
+	} else {
+		var deleteBytes int64
+		if req.Stats != nil {
+			deleteBytes = req.Stats.deleteBytes.Value()
+		}
+		maxLeases := cfg["settings.snapshot_lease.max_per_index"].Int()
+		lease, err = s.pinned.Pin(is, leaseTime, maxLeases, deleteBytes)
+	}
+	if s.tryRespondWithError(w, req, err) {
+		return
+	}
+
+	logging.Infof("%s Snapshot Pinned For Index %v, lease:%v, leaseTime:%v, requestId:%v",
+		req.LogPrefix, req.IndexInstId, lease.id, lease.leaseTime, req.RequestId)
+	s.handleError(req.LogPrefix,
+		w.PinSnapshot(lease.id, lease.snap.Timestamp(), lease.leaseTime))
+}
+
+func (s *scanCoordinator) handleUnpinSnapshotRequest(req *ScanRequest, w ScanResponseWriter) {
+	if s.tryRespondWithError(w, req, s.pinned.Unpin(req.SnapshotLease)) {
+		return
+	}
+	s.handleError(req.LogPrefix, w.UnpinSnapshot())
+}
+
+// expirePinnedSnapshots releases snapshots whose lease has expired and
+// updates pinned snapshot stats. Memory held by pinned snapshots is
+// estimated as the bytes deleted from index since the oldest snapshot
+// was pinned, which storage cannot reclaim while the snapshot is open.
+func (s *scanCoordinator) expirePinnedSnapshots() {
+	s.pinned.Expire(time.Now())
+
+	stats := s.stats.Get()
+	if stats == nil {
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pinned := s.pinned.Stats()
+	var total int64
+	for instId, idxStats := range stats.indexes {
+		st := pinned[instId]
+		var mem int64
+		if st.numPinned > 0 {
+			mem = postiveNum(idxStats.deleteBytes.Value() - st.deleteBytes)
+		}
+		idxStats.numPinnedSnapshots.Set(st.numPinned)
+		idxStats.pinnedSnapshotMemory.Set(mem)
+		total += mem
+	}
+	stats.pinnedSnapMemory.Set(total)
+}
+
 func (s *scanCoordinator) handleScanRequest(req *ScanRequest, w ScanResponseWriter,
 	is IndexSnapshot, t0 time.Time) {
 	waitTime := time.Now().Sub(t0)
@@ -1555,6 +1963,8 @@ func (s *scanCoordinator) handleScanRequest(req *ScanRequest, w ScanResponseWrit
 
 	err := scanPipeline.Execute()
 	scanTime := time.Now().Sub(t0)
+	req.rowsReturned = scanPipeline.RowsReturned()
+	req.bytesRead = scanPipeline.BytesRead()
 
 	if req.Stats != nil {
 		req.Stats.numRowsReturned.Add(int64(scanPipeline.RowsReturned()))
@@ -1618,6 +2028,7 @@ func (s *scanCoordinator) handleCountRequest(req *ScanRequest, w ScanResponseWri
 	}
 
 	logging.Verbosef("%s RESPONSE count:%d status:ok", req.LogPrefix, rows)
+	req.rowsReturned = rows
 	err = w.Count(rows)
 	s.handleError(req.LogPrefix, err)
 }
@@ -1663,6 +2074,7 @@ func (s *scanCoordinator) handleMultiScanCountRequest(req *ScanRequest, w ScanRe
 	}
 
 	logging.Verbosef("%s RESPONSE count:%d status:ok", req.LogPrefix, rows)
+	req.rowsReturned = rows
 	err = w.Count(rows)
 	s.handleError(req.LogPrefix, err)
 }
@@ -1680,10 +2092,18 @@ func (s *scanCoordinator) handleStatsRequest(req *ScanRequest, w ScanResponseWri
 	cancelCb.Run()
 	defer cancelCb.Done()
 
+	low, high := req.Low.Bytes(), req.High.Bytes()
+	if len(req.Keys) == 1 {
+		low, high = req.Keys[0].Bytes(), req.Keys[0].Bytes()
+	}
+	estimator := newKeyStatsEstimator(low, high)
+
 	for _, s := range GetSliceSnapshots(is) {
 		var r uint64
 		snap := s.Snapshot()
-		if req.Low.Bytes() == nil && req.Low.Bytes() == nil {
+		if len(req.Keys) > 0 {
+			r, err = snap.CountLookup(req.Ctx, req.Keys, stopch)
+		} else if req.Low.Bytes() == nil && req.High.Bytes() == nil {
 			r, err = snap.StatCountTotal()
 		} else {
 			r, err = snap.CountRange(req.Ctx, req.Low, req.High, req.Incl, stopch)
@@ -1694,32 +2114,131 @@ func (s *scanCoordinator) handleStatsRequest(req *ScanRequest, w ScanResponseWri
 		}
 
 		rows += r
+		estimator.Add(snap.KeyStatistics(), r)
 	}
 
 	if s.tryRespondWithError(w, req, err) {
 		return
 	}
 
+	var unique uint64
+	var min, max []byte
+	var bins []*protobuf.IndexStatistics
+	if req.IndexInst.Defn.IsPrimary {
+		unique = rows
+		min, max = NilJsonKey, NilJsonKey
+	} else {
+		unique, min, max, bins, err = keyStatsResponse(estimator, req.IndexInst.Defn.Desc)
+		if s.tryRespondWithError(w, req, err) {
+			return
+		}
+	}
+
 	logging.Verbosef("%s RESPONSE status:ok", req.LogPrefix)
-	err = w.Stats(rows, 0, nil, nil)
+	err = w.Stats(rows, unique, min, max, bins)
 	s.handleError(req.LogPrefix, err)
 }
 
+// keyStatsResponse returns distinct count, bounds and histogram bins of
+// estimated key statistics, bounds are JSON encoded.
+func keyStatsResponse(e *keyStatsEstimator, desc []bool) (
+	unique uint64, min, max []byte, bins []*protobuf.IndexStatistics, err error) {
+
+	unique = e.Distinct()
+	kmin, kmax := e.MinMax()
+	if min, err = decodeStatsKey(kmin, desc); err != nil {
+		return
+	}
+	if max, err = decodeStatsKey(kmax, desc); err != nil {
+		return
+	}
+
+	for _, bin := range e.Bins(keyStatsHistogramBins) {
+		pbin := &protobuf.IndexStatistics{
+			KeysCount:       proto.Uint64(bin.rows),
+			UniqueKeysCount: proto.Uint64(bin.distinct),
+		}
+		if pbin.KeyMin, err = decodeStatsKey(bin.min, desc); err != nil {
+			return
+		}
+		if pbin.KeyMax, err = decodeStatsKey(bin.max, desc); err != nil {
+			return
+		}
+		bins = append(bins, pbin)
+	}
+	return
+}
+
+// decodeStatsKey decode a collated key, stored in index order, to JSON.
+func decodeStatsKey(key []byte, desc []bool) ([]byte, error) {
+	if len(key) == 0 {
+		return NilJsonKey, nil
+	}
+	if desc != nil {
+		key = jsonEncoder.ReverseCollate(append([]byte(nil), key...), desc)
+	}
+	buf := make([]byte, 0, 3*len(key)+collatejson.MinBufferSize)
+	return jsonEncoder.Decode(key, buf)
+}
+
 // Find and return data structures for the specified index
 func (s *scanCoordinator) findIndexInstance(
 	defnID uint64) (*common.IndexInst, IndexReaderContext, error) {
 
-	for _, inst := range s.indexInstMap {
-		if inst.Defn.DefnId == common.IndexDefnId(defnID) {
-			if pmap, ok := s.indexPartnMap[inst.InstId]; ok {
-				ctx := pmap[0].Sc.GetSliceById(0).GetReaderContext()
+	found := findScanInst(s.indexInstMap, common.IndexDefnId(defnID))
+	if found == nil {
+		return nil, nil, common.ErrIndexNotFound
+	}
 
-				return &inst, ctx, nil
-			}
-			return nil, nil, ErrNotMyIndex
+	if pmap, ok := s.indexPartnMap[found.InstId]; ok {
+		ctx := pmap[0].Sc.GetSliceById(0).GetReaderContext()
+
+		return found, ctx, nil
+	}
+	return nil, nil, ErrNotMyIndex
+}
+
+//checkScanInstVersion returns error if the instance is not of the
+//version expected by client. Replicas of an altered index are swapped
+//in independently and the versions differ till all of them are swapped
+//in, client retries the scan on another replica.
+func checkScanInstVersion(inst *common.IndexInst, version *uint64) error {
+	if version != nil && uint64(inst.Version) != *version {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+//findScanInst returns the instance of index defnId serving scans.
+//While an index is being altered, there are two instances of the
+//definition. Scans are served by the active instance with the
+//highest version, the shadow instance stays in REBAL_PENDING till
+//it is swapped in.
+func findScanInst(instMap common.IndexInstMap, defnId common.IndexDefnId) *common.IndexInst {
+
+	var found *common.IndexInst
+	for _, inst := range instMap {
+		if inst.Defn.DefnId != defnId {
+			continue
+		}
+		inst := inst
+		if found == nil || isPreferredScanInst(&inst, found) {
+			found = &inst
 		}
 	}
-	return nil, nil, common.ErrIndexNotFound
+	return found
+}
+
+func isPreferredScanInst(inst, other *common.IndexInst) bool {
+
+	serving := func(i *common.IndexInst) bool {
+		return i.State != common.INDEX_STATE_DELETED && i.RState == common.REBAL_ACTIVE
+	}
+
+	if serving(inst) != serving(other) {
+		return serving(inst)
+	}
+	return inst.Version > other.Version
 }
 
 func (s *scanCoordinator) handleUpdateIndexInstMap(cmd Message) {
@@ -1731,6 +2250,7 @@ func (s *scanCoordinator) handleUpdateIndexInstMap(cmd Message) {
 	indexInstMap := req.GetIndexInstMap()
 	s.stats.Set(req.GetStatsObject())
 	s.indexInstMap = common.CopyIndexInstMap(indexInstMap)
+	s.pinned.UnpinMissing(s.indexInstMap)
 
 	if len(req.GetRollbackTimes()) != 0 {
 		logging.Infof("ScanCoordinator::initialize rollback times on new index inst map: %v", req.GetRollbackTimes())
@@ -1754,6 +2274,8 @@ func (s *scanCoordinator) handleUpdateIndexPartnMap(cmd Message) {
 func (s *scanCoordinator) handleConfigUpdate(cmd Message) {
 	cfgUpdate := cmd.(*MsgConfigUpdate)
 	s.config.Store(cfgUpdate.GetConfig())
+	s.admission.UpdateConfig(cfgUpdate.GetConfig())
+	s.scanLog.UpdateConfig(cfgUpdate.GetConfig())
 	s.supvCmdch <- &MsgSuccess{}
 }
 
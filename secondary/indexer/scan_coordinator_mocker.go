@@ -341,6 +341,10 @@ func (s *mockSnapshot) Info() SnapshotInfo {
 	return &mockSnapshotInfo{}
 }
 
+func (s *mockSnapshot) KeyStatistics() *keyStatsData {
+	return nil
+}
+
 type mockSnapshotInfo struct {
 }
 
@@ -0,0 +1,104 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/couchbase/indexing/secondary/collatejson"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+)
+
+var ErrFilterOnPrimary = errors.New("Filter is not supported on primary index")
+
+// ScanFilter is a N1QL predicate evaluated on every entry qualifying the
+// scans, entries for which the predicate is not true are skipped. Keys
+// of the entry, followed by include columns, are referred in the
+// predicate by position as self[i] and meta().id is the docid. For
+// array index, self[i] of the array key is the array item of the entry.
+type ScanFilter struct {
+	expr  interface{} // compiled N1QL expression
+	codec *collatejson.Codec
+	keys  [][]byte // decoded keys of entry, reused for every entry
+	text  []byte   // buffer for decoded keys
+}
+
+// newScanFilter compiles the filter of a scan request.
+func newScanFilter(filter string, isPrimary bool) (*ScanFilter, error) {
+	if isPrimary {
+		return nil, ErrFilterOnPrimary
+	}
+	cExprs, err := protobuf.CompileN1QLExpression([]string{filter})
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filter %q (%v)", filter, err)
+	}
+	return &ScanFilter{expr: cExprs[0], codec: collatejson.NewCodec(16)}, nil
+}
+
+// match returns true if secondary index `entry` satisfies the filter.
+// `compositekeys` are the exploded keys of the entry, if already
+// available, `buf` is used for decoding.
+func (f *ScanFilter) match(entry []byte, compositekeys [][]byte, buf []byte) (bool, error) {
+	var err error
+	if compositekeys == nil {
+		if compositekeys, err = f.codec.ExplodeArray(entry, buf); err != nil {
+			return false, err
+		}
+	}
+
+	e := secondaryIndexEntry(entry)
+	var includes [][]byte
+	if payload := e.ReadPayload(); payload != nil {
+		if includes, err = f.codec.ExplodeArray(payload, buf); err != nil {
+			return false, err
+		}
+	}
+
+	f.keys, f.text = f.keys[:0], f.text[:0]
+	for _, code := range compositekeys {
+		if f.keys, err = f.appendKey(f.keys, code); err != nil {
+			return false, err
+		}
+	}
+	for _, code := range includes {
+		if f.keys, err = f.appendKey(f.keys, code); err != nil {
+			return false, err
+		}
+	}
+
+	docid, err := e.ReadDocId(nil)
+	if err != nil {
+		return false, err
+	}
+	return protobuf.N1QLFilter(docid, f.keys, f.expr)
+}
+
+// appendKey decodes collatejson `code` of a key to JSON, missing key
+// is appended as nil.
+func (f *ScanFilter) appendKey(keys [][]byte, code []byte) ([][]byte, error) {
+	if len(code) > 0 && code[0] == collatejson.TypeMissing {
+		return append(keys, nil), nil
+	}
+	size := 3*len(code) + collatejson.MinBufferSize
+	if cap(f.text)-len(f.text) < size {
+		// keys decoded so far continue to refer the old buffer.
+		f.text = make([]byte, 0, 2*cap(f.text)+size)
+	}
+	text := f.text[len(f.text):]
+	key, err := f.codec.Decode(code, text)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) <= cap(text) { // decoded in place
+		f.text = f.text[:len(f.text)+len(key)]
+	}
+	return append(keys, key), nil
+}
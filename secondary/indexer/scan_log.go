@@ -0,0 +1,466 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+)
+
+// scanLogFile is the name of the scan log in diagnostics_dir, rotated
+// files are suffixed with .1, .2 and so on, .1 being the latest.
+const scanLogFile = "indexer_scan_log.json"
+
+// scanLogQueueSize is the number of entries buffered for the writer,
+// entries are dropped when it is full.
+const scanLogQueueSize = 1024
+
+// scanLog samples scan and count requests into a rotating log, one JSON
+// object per line in the query log format of cbindexperf, so that the
+// log can be replayed as a workload. Requests are sampled at a
+// configured rate, while requests slower than a threshold, failed and
+// rejected requests are always logged, once sampling or threshold is
+// enabled. Resumed scans are not logged, neither are group/aggregate
+// and sort specs of a scan.
+//
+// Entries are written by a writer goroutine, so that scans do not wait
+// on file I/O. Entries are dropped when the writer falls behind.
+type scanLog struct {
+	config  atomic.Value // *scanLogConfig
+	queue   chan *scanLogWrite
+	dropped uint64
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// scanLogWrite is an entry queued for the writer, or a flush request if
+// done is not nil.
+type scanLogWrite struct {
+	data []byte
+	done chan bool
+}
+
+type scanLogConfig struct {
+	dir           string
+	sampleRate    float64
+	slowThreshold time.Duration
+	maxFileSize   int64
+	numFiles      int
+}
+
+// scanLogEntry field names match cbindexperf's ScanConfig, outcome of
+// the request follows the spec.
+type scanLogEntry struct {
+	Timestamp       time.Time
+	RequestId       string `json:",omitempty"`
+	Type            string
+	Bucket          string
+	Index           string
+	DefnId          uint64
+	Low             json.RawMessage    `json:",omitempty"`
+	High            json.RawMessage    `json:",omitempty"`
+	Lookups         []json.RawMessage  `json:",omitempty"`
+	Inclusion       uint32             `json:",omitempty"`
+	Scans           []scanLogScan      `json:",omitempty"`
+	IndexProjection *scanLogProjection `json:",omitempty"`
+	Filter          string             `json:",omitempty"`
+	Distinct        bool               `json:",omitempty"`
+	Offset          int64              `json:",omitempty"`
+	Limit           int64              `json:",omitempty"`
+	Consistency     bool               // session or query consistency
+	ScanConsistency string
+
+	Latency int64  // nanoseconds
+	Rows    uint64 // the count, for count requests
+	Bytes   uint64
+	Error   string `json:",omitempty"`
+}
+
+// scanLogScan is a span of a multi-scan, unbounded filter keys are
+// omitted.
+type scanLogScan struct {
+	Seek   []json.RawMessage `json:",omitempty"`
+	Filter []scanLogFilter   `json:",omitempty"`
+}
+
+type scanLogFilter struct {
+	Low       json.RawMessage `json:",omitempty"`
+	High      json.RawMessage `json:",omitempty"`
+	Inclusion uint32
+}
+
+type scanLogProjection struct {
+	EntryKeys  []int64
+	PrimaryKey bool
+}
+
+func newScanLog(config common.Config) *scanLog {
+	l := &scanLog{queue: make(chan *scanLogWrite, scanLogQueueSize)}
+	l.UpdateConfig(config)
+	go l.run()
+	return l
+}
+
+func (l *scanLog) UpdateConfig(config common.Config) {
+	l.config.Store(&scanLogConfig{
+		dir:        config["diagnostics_dir"].String(),
+		sampleRate: config["settings.scan_log.sample_rate"].Float64(),
+		slowThreshold: time.Duration(
+			config["settings.scan_log.slow_threshold"].Int()) * time.Millisecond,
+		maxFileSize: int64(config["settings.scan_log.max_file_size"].Int()),
+		numFiles:    config["settings.scan_log.num_files"].Int(),
+	})
+}
+
+// Sample logs the request if it is picked at the sampling rate, if it
+// took longer than the slow threshold since `start`, or if it failed
+// with `reqErr`, including requests rejected by admission control.
+func (l *scanLog) Sample(protoReq interface{}, req *ScanRequest, start time.Time,
+	reqErr error) {
+
+	cfg := l.config.Load().(*scanLogConfig)
+	latency := time.Since(start)
+
+	if cfg.sampleRate <= 0 && cfg.slowThreshold <= 0 {
+		return
+	}
+	slow := cfg.slowThreshold > 0 && latency >= cfg.slowThreshold
+	if reqErr == nil && !slow && rand.Float64() >= cfg.sampleRate {
+		return
+	}
+	if req.Resume != nil {
+		return
+	}
+
+	entry := newScanLogEntry(protoReq, req)
+	if entry == nil {
+		return
+	}
+	entry.Timestamp = start
+	entry.Latency = latency.Nanoseconds()
+	if reqErr != nil {
+		entry.Error = reqErr.Error()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		logging.Warnf("%v scanLog: failed to encode request: %v", req.LogPrefix, err)
+		return
+	}
+
+	select {
+	case l.queue <- &scanLogWrite{data: append(data, '\n')}:
+	default:
+		atomic.AddUint64(&l.dropped, 1)
+	}
+}
+
+// run writes queued entries to the log, and reports entries dropped
+// while the queue was full.
+func (l *scanLog) run() {
+	for w := range l.queue {
+		if w.done != nil {
+			close(w.done)
+			continue
+		}
+
+		if err := l.write(l.config.Load().(*scanLogConfig), w.data); err != nil {
+			logging.Warnf("scanLog: %v", err)
+		}
+		if dropped := atomic.SwapUint64(&l.dropped, 0); dropped > 0 {
+			logging.Warnf("scanLog: dropped %v requests, writer is falling behind", dropped)
+		}
+	}
+}
+
+// flush waits for the entries queued so far to be written.
+func (l *scanLog) flush() {
+	done := make(chan bool)
+	l.queue <- &scanLogWrite{done: done}
+	<-done
+}
+
+func newScanLogEntry(protoReq interface{}, req *ScanRequest) *scanLogEntry {
+	e := &scanLogEntry{
+		RequestId: req.RequestId,
+		Bucket:    req.Bucket,
+		Index:     req.IndexName,
+		DefnId:    req.DefnID,
+		Rows:      req.rowsReturned,
+		Bytes:     req.bytesRead,
+	}
+	if req.Consistency != nil {
+		e.Consistency = *req.Consistency != common.AnyConsistency
+		e.ScanConsistency = strings.ToLower(req.Consistency.String())
+	}
+
+	// keys are JSON encoded by clients, except for primary keys.
+	key := func(k []byte) json.RawMessage {
+		if k == nil || !req.isPrimary {
+			return json.RawMessage(k)
+		}
+		data, _ := json.Marshal([]string{string(k)})
+		return json.RawMessage(data)
+	}
+	filterKey := func(k []byte) json.RawMessage {
+		if k == nil || !req.isPrimary {
+			return json.RawMessage(k)
+		}
+		data, _ := json.Marshal(string(k))
+		return json.RawMessage(data)
+	}
+	setSpan := func(span *protobuf.Span) {
+		if equals := span.GetEquals(); len(equals) > 0 {
+			for _, k := range equals {
+				e.Lookups = append(e.Lookups, key(k))
+			}
+			return
+		}
+		e.Low = key(span.GetRange().GetLow())
+		e.High = key(span.GetRange().GetHigh())
+		e.Inclusion = span.GetRange().GetInclusion()
+	}
+	setScans := func(scans []*protobuf.Scan) {
+		for _, scan := range scans {
+			var s scanLogScan
+			for _, k := range scan.GetEquals() {
+				s.Seek = append(s.Seek, filterKey(k))
+			}
+			for _, f := range scan.GetFilters() {
+				s.Filter = append(s.Filter, scanLogFilter{
+					Low:       filterKey(f.GetLow()),
+					High:      filterKey(f.GetHigh()),
+					Inclusion: f.GetInclusion(),
+				})
+			}
+			e.Scans = append(e.Scans, s)
+		}
+	}
+
+	switch r := protoReq.(type) {
+	case *protobuf.ScanAllRequest:
+		e.Type = "All"
+		e.Limit = r.GetLimit()
+
+	case *protobuf.ScanRequest:
+		e.Limit = r.GetLimit()
+		if len(r.GetScans()) > 0 {
+			e.Type = "MultiScan"
+			setScans(r.GetScans())
+			e.Filter = r.GetFilter()
+			e.Offset = r.GetOffset()
+			e.Distinct = r.GetDistinct()
+			if proj := r.GetIndexprojection(); proj != nil {
+				e.IndexProjection = &scanLogProjection{
+					EntryKeys:  proj.GetEntryKeys(),
+					PrimaryKey: proj.GetPrimaryKey(),
+				}
+			}
+		} else if len(r.GetSpan().GetEquals()) > 0 {
+			e.Type = "Lookup"
+			setSpan(r.GetSpan())
+		} else {
+			e.Type = "Range"
+			setSpan(r.GetSpan())
+		}
+
+	case *protobuf.CountRequest:
+		e.Type = "Count"
+		if len(r.GetScans()) > 0 {
+			setScans(r.GetScans())
+			e.Distinct = r.GetDistinct()
+		} else {
+			setSpan(r.GetSpan())
+		}
+
+	default:
+		return nil
+	}
+	return e
+}
+
+func (l *scanLog) write(cfg *scanLogConfig, data []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file != nil && cfg.maxFileSize > 0 &&
+		l.size+int64(len(data)) > cfg.maxFileSize {
+
+		l.file.Close()
+		l.file = nil
+		rotateScanLog(cfg.dir, cfg.numFiles)
+	}
+
+	if l.file == nil {
+		path := filepath.Join(cfg.dir, scanLogFile)
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return err
+		}
+		l.file, l.size = file, info.Size()
+	}
+
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	return err
+}
+
+// rotateScanLog shifts scan log files by one, dropping the oldest so
+// that numFiles remain including the one written next.
+func rotateScanLog(dir string, numFiles int) {
+	if numFiles < 1 {
+		numFiles = 1
+	}
+	os.Remove(scanLogPath(dir, numFiles-1))
+	for i := numFiles - 1; i > 0; i-- {
+		os.Rename(scanLogPath(dir, i-1), scanLogPath(dir, i))
+	}
+}
+
+// scanLogPath of the i-th rotated file, 0 is the file being written.
+func scanLogPath(dir string, i int) string {
+	path := filepath.Join(dir, scanLogFile)
+	if i > 0 {
+		path = fmt.Sprintf("%v.%v", path, i)
+	}
+	return path
+}
+
+// scanLogPaths returns the existing scan log files, oldest first.
+func scanLogPaths(dir string) []string {
+	paths := make([]string, 0)
+	for i := 0; ; i++ {
+		path := scanLogPath(dir, i)
+		if _, err := os.Stat(path); err != nil {
+			break
+		}
+		paths = append([]string{path}, paths...)
+	}
+	return paths
+}
+
+// Fetch writes the logged requests to w, oldest first. Files are opened
+// under the lock, so that they are not rotated in between, and are
+// copied without holding it.
+func (l *scanLog) Fetch(w io.Writer) error {
+	cfg := l.config.Load().(*scanLogConfig)
+
+	files, err := func() ([]*os.File, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		files := make([]*os.File, 0)
+		for _, path := range scanLogPaths(cfg.dir) {
+			file, err := os.Open(path)
+			if err != nil {
+				for _, f := range files {
+					f.Close()
+				}
+				return nil, err
+			}
+			files = append(files, file)
+		}
+		return files, nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, file := range files {
+		if _, err := io.Copy(w, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clear removes all logged requests, including the queued ones.
+func (l *scanLog) Clear() error {
+	cfg := l.config.Load().(*scanLogConfig)
+
+	l.flush()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	for _, path := range scanLogPaths(cfg.dir) {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleScanLog serves the scan log on GET and clears it on DELETE.
+func (l *scanLog) handleScanLog(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	} else if !valid {
+		w.WriteHeader(401)
+		w.Write([]byte("401 Unauthorized"))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		if !common.IsAllowed(creds, []string{"cluster.admin.internal.index!read"}, w) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		if err := l.Fetch(w); err != nil {
+			logging.Errorf("scanLog: failed to fetch: %v", err)
+		}
+	case "DELETE":
+		if !common.IsAllowed(creds, []string{"cluster.admin.internal.index!write"}, w) {
+			return
+		}
+		if err := l.Clear(); err != nil {
+			w.WriteHeader(500)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.WriteHeader(200)
+		w.Write([]byte("OK"))
+	default:
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported method"))
+	}
+}
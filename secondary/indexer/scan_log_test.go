@@ -0,0 +1,187 @@
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+	"github.com/golang/protobuf/proto"
+)
+
+func newTestScanLog(t *testing.T) (*scanLog, common.Config, string) {
+	dir, err := ioutil.TempDir("", "scanlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := common.SystemConfig.SectionConfig("indexer.", true).Clone()
+	config.SetValue("diagnostics_dir", dir)
+	config.SetValue("settings.scan_log.sample_rate", float64(1))
+	return newScanLog(config), config, dir
+}
+
+// readScanLog returns the logged entries, once queued entries are
+// written.
+func readScanLog(t *testing.T, l *scanLog) []*scanLogEntry {
+	l.flush()
+
+	var buf bytes.Buffer
+	if err := l.Fetch(&buf); err != nil {
+		t.Fatal(err)
+	}
+	entries := make([]*scanLogEntry, 0)
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		e := new(scanLogEntry)
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestScanLogEntry(t *testing.T) {
+	cons := common.SessionConsistency
+	req := &ScanRequest{
+		Bucket:       "default",
+		IndexName:    "idx",
+		DefnID:       10,
+		Consistency:  &cons,
+		rowsReturned: 5,
+	}
+	protoReq := &protobuf.ScanRequest{
+		Limit: proto.Int64(100),
+		Scans: []*protobuf.Scan{{
+			Filters: []*protobuf.CompositeElementFilter{{
+				Low:       []byte(`"a"`),
+				Inclusion: proto.Uint32(2),
+			}},
+		}},
+		Indexprojection: &protobuf.IndexProjection{
+			EntryKeys:  []int64{0},
+			PrimaryKey: proto.Bool(true),
+		},
+		Offset: proto.Int64(10),
+	}
+
+	e := newScanLogEntry(protoReq, req)
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Timestamp":"0001-01-01T00:00:00Z","Type":"MultiScan",` +
+		`"Bucket":"default","Index":"idx","DefnId":10,` +
+		`"Scans":[{"Filter":[{"Low":"a","Inclusion":2}]}],` +
+		`"IndexProjection":{"EntryKeys":[0],"PrimaryKey":true},` +
+		`"Offset":10,"Limit":100,"Consistency":true,` +
+		`"ScanConsistency":"session_consistency","Latency":0,"Rows":5,"Bytes":0}`
+	if string(data) != expected {
+		t.Fatalf("expected %v, got %v", expected, string(data))
+	}
+
+	// primary keys are not JSON encoded by clients.
+	req.isPrimary = true
+	e = newScanLogEntry(&protobuf.CountRequest{
+		Span: &protobuf.Span{Equals: [][]byte{[]byte("doc1")}},
+	}, req)
+	if e.Type != "Count" || len(e.Lookups) != 1 || string(e.Lookups[0]) != `["doc1"]` {
+		t.Fatalf("unexpected count entry %+v", e)
+	}
+}
+
+func TestScanLogRotate(t *testing.T) {
+	l, config, dir := newTestScanLog(t)
+	defer os.RemoveAll(dir)
+
+	req := &ScanRequest{Bucket: "default", IndexName: "idx"}
+	protoReq := &protobuf.ScanAllRequest{Limit: proto.Int64(1)}
+	l.Sample(protoReq, req, time.Now(), nil)
+	entries := readScanLog(t, l)
+	if len(entries) != 1 || entries[0].Type != "All" {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+
+	// every entry goes to a new file, only 2 files are retained.
+	config.SetValue("settings.scan_log.max_file_size", 1)
+	config.SetValue("settings.scan_log.num_files", 2)
+	l.UpdateConfig(config)
+	for i := 2; i <= 4; i++ {
+		protoReq.Limit = proto.Int64(int64(i))
+		l.Sample(protoReq, req, time.Now(), nil)
+	}
+	entries = readScanLog(t, l)
+	if len(entries) != 2 || entries[0].Limit != 3 || entries[1].Limit != 4 {
+		t.Fatalf("unexpected entries after rotation %v", entries)
+	}
+
+	// slow requests are logged irrespective of sampling.
+	config.SetValue("settings.scan_log.sample_rate", float64(0))
+	config.SetValue("settings.scan_log.slow_threshold", 10)
+	config.SetValue("settings.scan_log.max_file_size", 1024*1024)
+	l.UpdateConfig(config)
+	if err := l.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	l.Sample(protoReq, req, time.Now(), nil)
+	l.Sample(protoReq, req, time.Now().Add(-time.Second), nil)
+	if entries = readScanLog(t, l); len(entries) != 1 {
+		t.Fatalf("expected only the slow request, got %v", entries)
+	}
+}
+
+func TestScanLogFailed(t *testing.T) {
+	l, config, dir := newTestScanLog(t)
+	defer os.RemoveAll(dir)
+
+	req := &ScanRequest{Bucket: "default", IndexName: "idx"}
+	protoReq := &protobuf.ScanAllRequest{Limit: proto.Int64(1)}
+
+	// failed and rejected requests are logged irrespective of sampling.
+	config.SetValue("settings.scan_log.sample_rate", float64(0))
+	config.SetValue("settings.scan_log.slow_threshold", 1000)
+	l.UpdateConfig(config)
+	l.Sample(protoReq, req, time.Now(), nil)
+	l.Sample(protoReq, req, time.Now(), common.ErrScanRejected)
+	entries := readScanLog(t, l)
+	if len(entries) != 1 || entries[0].Error != common.ErrScanRejected.Error() {
+		t.Fatalf("expected only the rejected request, got %v", entries)
+	}
+
+	// nothing is logged while scan log is disabled.
+	config.SetValue("settings.scan_log.slow_threshold", 0)
+	l.UpdateConfig(config)
+	l.Sample(protoReq, req, time.Now(), common.ErrScanRejected)
+	if entries = readScanLog(t, l); len(entries) != 1 {
+		t.Fatalf("unexpected entries with scan log disabled %v", entries)
+	}
+}
+
+func TestScanLogDropped(t *testing.T) {
+	_, config, dir := newTestScanLog(t)
+	defer os.RemoveAll(dir)
+
+	// entries are dropped when the writer falls behind.
+	l := &scanLog{queue: make(chan *scanLogWrite, 1)}
+	l.UpdateConfig(config)
+	req := &ScanRequest{Bucket: "default", IndexName: "idx"}
+	for i := 0; i < 3; i++ {
+		l.Sample(&protobuf.ScanAllRequest{}, req, time.Now(), nil)
+	}
+	if len(l.queue) != 1 || l.dropped != 2 {
+		t.Fatalf("expected 2 dropped entries, got %v queued %v dropped", len(l.queue), l.dropped)
+	}
+
+	go l.run()
+	if entries := readScanLog(t, l); len(entries) != 1 {
+		t.Fatalf("expected the queued entry to be written, got %v", entries)
+	}
+	if l.dropped != 0 {
+		t.Fatalf("expected dropped entries to be reported")
+	}
+}
@@ -11,7 +11,13 @@ package indexer
 
 import (
 	"bytes"
+	"container/heap"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
 
 	"github.com/couchbase/indexing/secondary/collatejson"
 	c "github.com/couchbase/indexing/secondary/common"
@@ -59,11 +65,33 @@ func NewScanPipeline(req *ScanRequest, w ScanResponseWriter, is IndexSnapshot) *
 	wr.InitReader()
 
 	dec.SetSource(src)
-	wr.SetSource(dec)
 
 	scanPipeline.src = src
 	scanPipeline.object.AddSource("source", src)
 	scanPipeline.object.AddFilter("decoder", dec)
+
+	var last p.Writer = dec
+	if req.GroupAggr != nil {
+		// Entries of a group are adjacent only within a single range
+		// of a single slice, scans and partitions are read one after
+		// the other.
+		leading := req.GroupAggr.IsLeadingGroup && len(req.Scans) <= 1 &&
+			len(GetPartitionSliceSnapshots(is, req.PartitionIds)) <= 1
+		aggr := &IndexScanAggregator{p: scanPipeline, leading: leading}
+		aggr.InitReadWriter()
+		aggr.SetSource(last)
+		last = aggr
+		scanPipeline.object.AddFilter("aggregator", aggr)
+	}
+	if req.Sort != nil {
+		sorter := &IndexScanSorter{p: scanPipeline}
+		sorter.InitReadWriter()
+		sorter.SetSource(last)
+		last = sorter
+		scanPipeline.object.AddFilter("sorter", sorter)
+	}
+	wr.SetSource(last)
+
 	scanPipeline.object.AddSink("writer", wr)
 
 	return scanPipeline
@@ -81,6 +109,20 @@ type IndexScanDecoder struct {
 	p *ScanPipeline
 }
 
+type IndexScanAggregator struct {
+	p.ItemReadWriter
+	p *ScanPipeline
+
+	// true if entries of a group are read one after the other, see
+	// GroupAggr.IsLeadingGroup.
+	leading bool
+}
+
+type IndexScanSorter struct {
+	p.ItemReadWriter
+	p *ScanPipeline
+}
+
 type IndexScanWriter struct {
 	p.ItemReader
 	w ScanResponseWriter
@@ -105,6 +147,12 @@ func (s *IndexScanSource) Routine() error {
 	revbuf := secKeyBufPool.Get()
 	r.keyBufList = append(r.keyBufList, revbuf)
 
+	// entries up to the resume position were returned by an earlier
+	// scan, storage is scanned from the position and entries at the
+	// position are compared in storage format, till the first entry
+	// after the position.
+	resume := r.Resume
+
 	iterCount := 0
 	fn := func(entry []byte) error {
 		if iterCount%SCAN_ROLLBACK_ERROR_BATCHSIZE == 0 && r.hasRollback != nil && r.hasRollback.Load() == true {
@@ -112,6 +160,17 @@ func (s *IndexScanSource) Routine() error {
 		}
 		iterCount++
 
+		skipRows := 0
+		if resume != nil {
+			cmp := resume.compare(entry, r.isPrimary)
+			if cmp < 0 {
+				return nil
+			} else if cmp == 0 {
+				skipRows = resume.count
+			}
+			resume = nil
+		}
+
 		skipRow := false
 		var ck [][]byte
 
@@ -138,6 +197,19 @@ func (s *IndexScanSource) Routine() error {
 			return nil
 		}
 
+		if r.Filter != nil {
+			if len(entry) > cap(*buf) {
+				*buf = make([]byte, 0, len(entry)+1024)
+			}
+			match, err := r.Filter.match(entry, ck, (*buf)[:0])
+			if err != nil {
+				return err
+			}
+			if !match {
+				return nil
+			}
+		}
+
 		if !r.isPrimary && r.Indexprojection != nil && r.Indexprojection.projectSecKeys {
 			if ck == nil && len(entry) > cap(*buf) {
 				*buf = make([]byte, 0, len(entry)+1024)
@@ -163,6 +235,18 @@ func (s *IndexScanSource) Routine() error {
 			if r.Distinct && i > 0 {
 				break
 			}
+			if skipRows > 0 {
+				skipRows--
+				continue
+			}
+			if r.GroupAggr != nil || r.Sort != nil {
+				// offset and limit apply to groups or sorted rows,
+				// not to entries
+				if wrErr := s.WriteItem(entry); wrErr != nil {
+					return wrErr
+				}
+				continue
+			}
 			if currOffset >= r.Offset {
 				s.p.rowsReturned++
 				wrErr := s.WriteItem(entry)
@@ -184,13 +268,16 @@ func (s *IndexScanSource) Routine() error {
 		return nil
 	}
 
-	sliceSnapshots := GetSliceSnapshots(s.is)
+	sliceSnapshots := GetPartitionSliceSnapshots(s.is, r.PartitionIds)
 
 loop:
 	for _, scan := range r.Scans {
 		currentScan = scan
 		for _, snap := range sliceSnapshots {
-			if scan.ScanType == AllReq {
+			if resume != nil {
+				low, high, incl := resume.bounds(scan, r.isPrimary)
+				err = snap.Snapshot().Range(r.Ctx, low, high, incl, fn)
+			} else if scan.ScanType == AllReq {
 				err = snap.Snapshot().All(r.Ctx, fn)
 			} else if scan.ScanType == LookupReq {
 				err = snap.Snapshot().Lookup(r.Ctx, scan.Equals, fn)
@@ -254,6 +341,203 @@ loop:
 	return nil
 }
 
+func (a *IndexScanAggregator) Routine() error {
+	defer a.CloseWrite()
+	defer a.CloseRead()
+
+	r := a.p.req
+	ga := r.GroupAggr
+	currOffset := int64(0)
+	codec := collatejson.NewCodec(16)
+
+	var curr *aggrGroup
+	groups := make(map[string]*aggrGroup)
+	var order []*aggrGroup
+
+	emit := func(g *aggrGroup) error {
+		if r.Sort != nil {
+			// offset and limit apply to sorted groups
+			return a.WriteItem(g.row(ga.Aggrs), nil)
+		}
+		if currOffset < r.Offset {
+			currOffset++
+			return nil
+		}
+		a.p.rowsReturned++
+		if err := a.WriteItem(g.row(ga.Aggrs), nil); err != nil {
+			return err
+		}
+		if a.p.rowsReturned == uint64(r.Limit) {
+			return ErrLimitReached
+		}
+		return nil
+	}
+
+	var vals []json.RawMessage
+	var err error
+
+loop:
+	for {
+		var sk []byte
+		sk, err = a.ReadItem()
+		switch err {
+		case nil:
+		case p.ErrNoMoreItem:
+			err = nil
+			break loop
+		case p.ErrSupervisorKill:
+			return nil
+		default:
+			a.CloseWithError(err)
+			return nil
+		}
+
+		// docid, not required to compute aggregates
+		if _, err = a.ReadItem(); err != nil {
+			a.CloseWithError(err)
+			return nil
+		}
+
+		vals = vals[:0]
+		if err = json.Unmarshal(sk, &vals); err != nil {
+			a.CloseWithError(err)
+			return nil
+		}
+
+		var key string
+		if key, err = groupKey(vals, ga.Group); err != nil {
+			a.CloseWithError(err)
+			return nil
+		}
+
+		if a.leading {
+			// Entries of a group are adjacent, emit a group as
+			// soon as the next one begins.
+			if curr != nil && curr.key != key {
+				if err = emit(curr); err != nil {
+					break loop
+				}
+				curr = nil
+			}
+			if curr == nil {
+				curr = newAggrGroup(key, len(ga.Aggrs))
+			}
+		} else {
+			var ok bool
+			if curr, ok = groups[key]; !ok {
+				curr = newAggrGroup(key, len(ga.Aggrs))
+				groups[key] = curr
+				order = append(order, curr)
+			}
+		}
+
+		if err = curr.add(ga.Aggrs, vals, codec); err != nil {
+			a.CloseWithError(err)
+			return nil
+		}
+	}
+
+	if err == ErrLimitReached {
+		return nil
+	} else if err != nil {
+		a.CloseWithError(err)
+		return nil
+	}
+
+	if a.leading {
+		// Aggregates without group keys always return a row.
+		if curr == nil && len(ga.Group) == 0 {
+			curr = newAggrGroup("", len(ga.Aggrs))
+		}
+		if curr != nil {
+			if err = emit(curr); err != nil && err != ErrLimitReached {
+				a.CloseWithError(err)
+			}
+		}
+		return nil
+	}
+
+	if len(order) == 0 && len(ga.Group) == 0 {
+		order = append(order, newAggrGroup("", len(ga.Aggrs)))
+	}
+	for _, g := range order {
+		if err = emit(g); err != nil {
+			if err != ErrLimitReached {
+				a.CloseWithError(err)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+func (s *IndexScanSorter) Routine() error {
+	defer s.CloseWrite()
+	defer s.CloseRead()
+
+	r := s.p.req
+	rows := newTopNRows(sortRetainCount(r.Offset, r.Limit))
+	codec := collatejson.NewCodec(16)
+
+	var vals []json.RawMessage
+	var seq uint64
+
+loop:
+	for {
+		sk, err := s.ReadItem()
+		switch err {
+		case nil:
+		case p.ErrNoMoreItem:
+			break loop
+		case p.ErrSupervisorKill:
+			return nil
+		default:
+			s.CloseWithError(err)
+			return nil
+		}
+
+		docid, err := s.ReadItem()
+		if err != nil {
+			s.CloseWithError(err)
+			return nil
+		}
+
+		vals = vals[:0]
+		if err = json.Unmarshal(sk, &vals); err != nil {
+			s.CloseWithError(err)
+			return nil
+		}
+		code, err := sortCode(vals, r.Sort.Keys, codec)
+		if err != nil {
+			s.CloseWithError(err)
+			return nil
+		}
+
+		// rows are retained beyond the next read, copy them.
+		row := &sortRow{code: code, seq: seq, sk: copyKey(sk)}
+		if docid != nil {
+			row.docid = copyKey(docid)
+		}
+		rows.add(row)
+		seq++
+	}
+
+	for i, row := range rows.sorted() {
+		if int64(i) < r.Offset {
+			continue
+		}
+		s.p.rowsReturned++
+		if err := s.WriteItem(row.sk, row.docid); err != nil {
+			s.CloseWithError(err)
+			break
+		}
+		if s.p.rowsReturned == uint64(r.Limit) {
+			break
+		}
+	}
+	return nil
+}
+
 func (d *IndexScanWriter) Routine() error {
 	var err error
 	var sk, pk []byte
@@ -422,6 +706,10 @@ func distinctCompare(entryBytes1, entryBytes2 []byte) bool {
 	return false
 }
 
+// collate_json encoded missing value, projected for include columns
+// of an entry without payload.
+var missingCode = []byte{collatejson.TypeMissing, collatejson.Terminator}
+
 func projectKeys(compositekeys [][]byte, key, buf []byte, projection *Projection) ([]byte, error) {
 	var err error
 
@@ -439,10 +727,28 @@ func projectKeys(compositekeys [][]byte, key, buf []byte, projection *Projection
 		}
 	}
 
+	var includes [][]byte
+	if len(projection.projectionKeys) > len(compositekeys) {
+		// include columns are not part of the key, they are
+		// exploded from payload of the entry.
+		if payload := secondaryIndexEntry(key).ReadPayload(); payload != nil {
+			if includes, err = codec.ExplodeArray(payload, buf); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	var keysToJoin [][]byte
 	for i, projectKey := range projection.projectionKeys {
-		if projectKey {
+		if !projectKey {
+			continue
+		}
+		if i < len(compositekeys) {
 			keysToJoin = append(keysToJoin, compositekeys[i])
+		} else if j := i - len(compositekeys); j < len(includes) {
+			keysToJoin = append(keysToJoin, includes[j])
+		} else {
+			keysToJoin = append(keysToJoin, missingCode)
 		}
 	}
 	// Note: Reusing the same buf used for Explode in JoinArray as well
@@ -481,3 +787,253 @@ func projectLeadingKey(compositekeys [][]byte, key []byte, buf *[]byte) ([]byte,
 	*buf = append(*buf, key[entry.lenKey():]...)
 	return *buf, nil
 }
+
+// aggrGroup accumulates aggregates for a single group of a scan
+// with group-by/aggregate pushdown.
+type aggrGroup struct {
+	key  string // comma separated JSON values of group keys
+	accs []aggrAccumulator
+}
+
+type aggrAccumulator struct {
+	count  int64
+	sum    float64
+	hasSum bool
+	val    []byte // JSON value of MIN/MAX
+	code   []byte // collatejson encoded val, for comparison
+	seen   map[string]bool
+}
+
+func newAggrGroup(key string, numAggrs int) *aggrGroup {
+	return &aggrGroup{key: key, accs: make([]aggrAccumulator, numAggrs)}
+}
+
+func groupKey(vals []json.RawMessage, group []int) (string, error) {
+	if len(group) == 0 {
+		return "", nil
+	}
+
+	var buf []byte
+	for i, pos := range group {
+		if pos >= len(vals) {
+			return "", fmt.Errorf("Group key position %v is out of range", pos)
+		}
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, vals[pos]...)
+	}
+	return string(buf), nil
+}
+
+func (g *aggrGroup) add(aggrs []Aggregate, vals []json.RawMessage,
+	codec *collatejson.Codec) error {
+
+	for i, aggr := range aggrs {
+		acc := &g.accs[i]
+		if aggr.KeyPos == -1 { // COUNT(*)
+			acc.count++
+			continue
+		}
+
+		if aggr.KeyPos >= len(vals) {
+			return fmt.Errorf("Aggregate key position %v is out of range", aggr.KeyPos)
+		}
+		val := vals[aggr.KeyPos]
+		if isJSONNull(val) {
+			continue // aggregates ignore null values
+		}
+
+		if aggr.Distinct {
+			if acc.seen == nil {
+				acc.seen = make(map[string]bool)
+			}
+			if acc.seen[string(val)] {
+				continue
+			}
+			acc.seen[string(val)] = true
+		}
+
+		switch aggr.AggrFunc {
+		case c.AGG_COUNT:
+			acc.count++
+
+		case c.AGG_COUNTN:
+			if _, ok := jsonNumber(val); ok {
+				acc.count++
+			}
+
+		case c.AGG_SUM:
+			if n, ok := jsonNumber(val); ok {
+				acc.sum += n
+				acc.hasSum = true
+			}
+
+		case c.AGG_MIN, c.AGG_MAX:
+			code, err := codec.Encode(val, make([]byte, 0, len(val)*3))
+			if err != nil {
+				return err
+			}
+			if acc.code != nil {
+				cmp := bytes.Compare(code, acc.code)
+				if (aggr.AggrFunc == c.AGG_MIN && cmp >= 0) ||
+					(aggr.AggrFunc == c.AGG_MAX && cmp <= 0) {
+					continue
+				}
+			}
+			acc.code = code
+			acc.val = append(acc.val[:0], val...)
+		}
+	}
+	return nil
+}
+
+// row returns group key values followed by aggregate values as
+// a JSON array.
+func (g *aggrGroup) row(aggrs []Aggregate) []byte {
+	buf := make([]byte, 0, len(g.key)+len(g.accs)*16+2)
+	buf = append(buf, '[')
+	buf = append(buf, g.key...)
+
+	for i, aggr := range aggrs {
+		if i > 0 || len(g.key) > 0 {
+			buf = append(buf, ',')
+		}
+		buf = g.accs[i].appendValue(buf, aggr.AggrFunc)
+	}
+	return append(buf, ']')
+}
+
+func (acc *aggrAccumulator) appendValue(buf []byte, fn c.AggrFuncType) []byte {
+	switch fn {
+	case c.AGG_COUNT, c.AGG_COUNTN:
+		return strconv.AppendInt(buf, acc.count, 10)
+	case c.AGG_SUM:
+		if acc.hasSum {
+			return strconv.AppendFloat(buf, acc.sum, 'f', -1, 64)
+		}
+	case c.AGG_MIN, c.AGG_MAX:
+		if acc.val != nil {
+			return append(buf, acc.val...)
+		}
+	}
+	return append(buf, "null"...)
+}
+
+func isJSONNull(val json.RawMessage) bool {
+	return len(val) == 4 && string(val) == "null"
+}
+
+func jsonNumber(val json.RawMessage) (float64, bool) {
+	if len(val) == 0 || !(val[0] == '-' || (val[0] >= '0' && val[0] <= '9')) {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(string(val), 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// sortRetainCount returns the number of sorted rows to be retained for
+// offset and limit, 0 if all rows are to be retained.
+func sortRetainCount(offset, limit int64) int {
+	if limit <= 0 || offset < 0 || limit > math.MaxInt32-offset {
+		return 0
+	}
+	return int(offset + limit)
+}
+
+// sortRow is a row of a scan with IndexSort, code is the collatejson
+// encoded sort keys and seq is the position of the row in index order.
+type sortRow struct {
+	code  []byte
+	seq   uint64
+	sk    []byte
+	docid []byte
+}
+
+func (row *sortRow) less(other *sortRow) bool {
+	if cmp := bytes.Compare(row.code, other.code); cmp != 0 {
+		return cmp < 0
+	}
+	return row.seq < other.seq
+}
+
+// sortRows in sort order, ties are in index order.
+type sortRows []*sortRow
+
+func (rows sortRows) Len() int           { return len(rows) }
+func (rows sortRows) Less(i, j int) bool { return rows[i].less(rows[j]) }
+func (rows sortRows) Swap(i, j int)      { rows[i], rows[j] = rows[j], rows[i] }
+
+// topNRows retains the first n rows in sort order, using a max-heap
+// whose root is the last of the retained rows. All rows are retained
+// if n is 0.
+type topNRows struct {
+	n    int
+	rows sortRows
+}
+
+func newTopNRows(n int) *topNRows {
+	return &topNRows{n: n}
+}
+
+func (t *topNRows) Len() int           { return len(t.rows) }
+func (t *topNRows) Less(i, j int) bool { return t.rows[j].less(t.rows[i]) }
+func (t *topNRows) Swap(i, j int)      { t.rows.Swap(i, j) }
+
+func (t *topNRows) Push(x interface{}) {
+	t.rows = append(t.rows, x.(*sortRow))
+}
+
+func (t *topNRows) Pop() interface{} {
+	row := t.rows[len(t.rows)-1]
+	t.rows = t.rows[:len(t.rows)-1]
+	return row
+}
+
+func (t *topNRows) add(row *sortRow) {
+	if t.n == 0 {
+		t.rows = append(t.rows, row)
+	} else if len(t.rows) < t.n {
+		heap.Push(t, row)
+	} else if row.less(t.rows[0]) {
+		t.rows[0] = row
+		heap.Fix(t, 0)
+	}
+}
+
+// sorted returns the retained rows in sort order.
+func (t *topNRows) sorted() []*sortRow {
+	sort.Sort(t.rows)
+	return t.rows
+}
+
+// sortCode returns the collatejson encoding of sort keys picked from
+// row values, with descending keys reversed so that rows sort by
+// comparing their codes.
+func sortCode(vals []json.RawMessage, keys []SortKey,
+	codec *collatejson.Codec) ([]byte, error) {
+
+	text := make([]byte, 0, 64)
+	desc := make([]bool, len(keys))
+	text = append(text, '[')
+	for i, key := range keys {
+		if key.KeyPos >= len(vals) {
+			return nil, fmt.Errorf("Sort key position %v is out of range", key.KeyPos)
+		}
+		if i > 0 {
+			text = append(text, ',')
+		}
+		text = append(text, vals[key.KeyPos]...)
+		desc[i] = key.Desc
+	}
+	text = append(text, ']')
+
+	code, err := codec.Encode(text, make([]byte, 0, len(text)*3))
+	if err != nil {
+		return nil, err
+	}
+	return codec.ReverseCollate(code, desc), nil
+}
@@ -0,0 +1,306 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/collatejson"
+	c "github.com/couchbase/indexing/secondary/common"
+)
+
+func TestAggrGroup(t *testing.T) {
+	codec := collatejson.NewCodec(16)
+	aggrs := []Aggregate{
+		{AggrFunc: c.AGG_COUNT, KeyPos: -1},
+		{AggrFunc: c.AGG_COUNT, KeyPos: 1, Distinct: true},
+		{AggrFunc: c.AGG_COUNTN, KeyPos: 1},
+		{AggrFunc: c.AGG_SUM, KeyPos: 1},
+		{AggrFunc: c.AGG_MIN, KeyPos: 1},
+		{AggrFunc: c.AGG_MAX, KeyPos: 1},
+	}
+	rows := []string{
+		`["a",10]`,
+		`["a",2.5]`,
+		`["a",null]`,
+		`["a",10]`,
+		`["a","str"]`,
+	}
+
+	var key string
+	var g *aggrGroup
+	for _, row := range rows {
+		var vals []json.RawMessage
+		if err := json.Unmarshal([]byte(row), &vals); err != nil {
+			t.Fatal(err)
+		}
+		k, err := groupKey(vals, []int{0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g == nil {
+			key, g = k, newAggrGroup(k, len(aggrs))
+		} else if k != key {
+			t.Fatalf("Expected group key %v, received %v", key, k)
+		}
+		if err := g.add(aggrs, vals, codec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := `["a",5,3,3,22.5,2.5,"str"]`
+	if row := string(g.row(aggrs)); row != expected {
+		t.Errorf("Expected %v, received %v", expected, row)
+	}
+}
+
+func TestAggrGroupEmpty(t *testing.T) {
+	aggrs := []Aggregate{
+		{AggrFunc: c.AGG_COUNT, KeyPos: -1},
+		{AggrFunc: c.AGG_SUM, KeyPos: 0},
+		{AggrFunc: c.AGG_MIN, KeyPos: 0},
+	}
+
+	g := newAggrGroup("", len(aggrs))
+	expected := `[0,null,null]`
+	if row := string(g.row(aggrs)); row != expected {
+		t.Errorf("Expected %v, received %v", expected, row)
+	}
+}
+
+func TestTopNRows(t *testing.T) {
+	codec := collatejson.NewCodec(16)
+	keys := []SortKey{{KeyPos: 1, Desc: true}, {KeyPos: 0}}
+	rows := []string{
+		`["a",1]`,
+		`["b",3]`,
+		`["c",2]`,
+		`["d",3]`,
+		`["e",null]`,
+		`["f","str"]`,
+		`["a",3]`,
+	}
+
+	topN := newTopNRows(sortRetainCount(1, 3))
+	all := newTopNRows(sortRetainCount(0, 0))
+	for i, row := range rows {
+		var vals []json.RawMessage
+		if err := json.Unmarshal([]byte(row), &vals); err != nil {
+			t.Fatal(err)
+		}
+		code, err := sortCode(vals, keys, codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		topN.add(&sortRow{code: code, seq: uint64(i), sk: []byte(row)})
+		all.add(&sortRow{code: code, seq: uint64(i), sk: []byte(row)})
+	}
+
+	expected := []string{
+		`["f","str"]`, `["a",3]`, `["b",3]`, `["d",3]`,
+		`["c",2]`, `["a",1]`, `["e",null]`,
+	}
+	check := func(sorted []*sortRow, expected []string) {
+		if len(sorted) != len(expected) {
+			t.Fatalf("Expected %v rows, received %v", len(expected), len(sorted))
+		}
+		for i, row := range sorted {
+			if string(row.sk) != expected[i] {
+				t.Errorf("Expected %v at %v, received %s", expected[i], i, row.sk)
+			}
+		}
+	}
+	check(topN.sorted(), expected[:4])
+	check(all.sorted(), expected)
+
+	if _, err := sortCode(nil, keys, codec); err == nil {
+		t.Errorf("Expected error for sort key out of range")
+	}
+}
+
+func TestProjectIncludeKeys(t *testing.T) {
+	key := []byte(`["a","b"]`)
+	docid := []byte("doc-1")
+	payload := []byte(`["inc1","inc2"]`)
+
+	entry, err := NewSecondaryIndexEntry3(key, docid, payload, false, 1, nil,
+		make([]byte, 0, 4096), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry = append([]byte(nil), entry...)
+
+	// project 2nd key and 2nd include column
+	projection := &Projection{
+		projectSecKeys: true,
+		projectionKeys: []bool{false, true, false, true},
+	}
+	out, err := projectKeys(nil, entry, make([]byte, 0, 4096), projection)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sk, pk, _ := siSplitEntry(out, make([]byte, 0, 4096))
+	if string(sk) != `["b","inc2"]` {
+		t.Errorf("unexpected projected key %s", sk)
+	}
+	if string(pk) != string(docid) {
+		t.Errorf("unexpected docid %s", pk)
+	}
+
+	// entry without payload projects missing
+	entry2, _ := NewSecondaryIndexEntry(key, docid, false, 1, nil, make([]byte, 0, 4096))
+	out, err = projectKeys(nil, entry2, make([]byte, 0, 4096), projection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, _, _ = siSplitEntry(out, make([]byte, 0, 4096))
+	expected := `["b","` + string(collatejson.MissingLiteral) + `"]`
+	if string(sk) != expected {
+		t.Errorf("expected %s, received %s", expected, sk)
+	}
+}
+
+func TestScanFilter(t *testing.T) {
+	key := []byte(`["couchbase",10]`)
+	docid := []byte("doc-1")
+	payload := []byte(`["inc1"]`)
+
+	entry, err := NewSecondaryIndexEntry3(key, docid, payload, false, 1, nil,
+		make([]byte, 0, 4096), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry = append([]byte(nil), entry...)
+
+	testcases := []struct {
+		filter string
+		match  bool
+	}{
+		{`self[0] LIKE "couch%"`, true},
+		{`self[0] LIKE "index%"`, false},
+		{`self[1] IN [1, 5, 10]`, true},
+		{`self[1] * 2 > 30`, false},
+		{`self[2] = "inc1" AND meta().id = "doc-1"`, true},
+		{`self[3] = "inc1"`, false}, // missing is not true
+	}
+	for _, tc := range testcases {
+		f, err := newScanFilter(tc.filter, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		match, err := f.match(entry, nil, make([]byte, 0, 4096))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match != tc.match {
+			t.Errorf("filter %v, expected %v, received %v", tc.filter, tc.match, match)
+		}
+	}
+
+	if _, err := newScanFilter(`self[0] = "a"`, true); err != ErrFilterOnPrimary {
+		t.Errorf("expected %v, received %v", ErrFilterOnPrimary, err)
+	}
+}
+
+type entrySnapshot struct {
+	Snapshot
+	entries [][]byte
+}
+
+func (s *entrySnapshot) All(ctx IndexReaderContext, fn EntryCallback) error {
+	for _, entry := range s.entries {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type rowWriter struct {
+	ScanResponseWriter
+	rows []string
+	err  error
+}
+
+func (w *rowWriter) Row(pk, sk []byte) error {
+	w.rows = append(w.rows, string(sk))
+	return nil
+}
+
+func (w *rowWriter) Error(err error) error {
+	w.err = err
+	return nil
+}
+
+func TestAggrAcrossPartitions(t *testing.T) {
+	partns := map[c.PartitionId]PartitionSnapshot{}
+	for partnId, keys := range [][]string{
+		{`["a",1]`, `["b",2]`},
+		{`["a",3]`, `["b",4]`},
+	} {
+		snap := &entrySnapshot{}
+		for i, key := range keys {
+			docid := []byte(fmt.Sprintf("doc-%v-%v", partnId, i))
+			entry, err := NewSecondaryIndexEntry([]byte(key), docid, false, 1,
+				nil, make([]byte, 0, 4096))
+			if err != nil {
+				t.Fatal(err)
+			}
+			snap.entries = append(snap.entries, append([]byte(nil), entry...))
+		}
+		id := c.PartitionId(partnId)
+		partns[id] = &partitionSnapshot{
+			id:     id,
+			slices: map[SliceId]SliceSnapshot{0: &sliceSnapshot{id: 0, snap: snap}},
+		}
+	}
+	is := &indexSnapshot{ts: c.NewTsVbuuid("default", 4), partns: partns}
+
+	scan := func(partnIds []c.PartitionId, group []int) []string {
+		r := &ScanRequest{
+			Scans:        []Scan{{ScanType: AllReq}},
+			PartitionIds: partnIds,
+			Limit:        100,
+			GroupAggr: &GroupAggr{
+				Group: group,
+				Aggrs: []Aggregate{
+					{AggrFunc: c.AGG_COUNT, KeyPos: -1},
+					{AggrFunc: c.AGG_SUM, KeyPos: 1},
+				},
+				IsLeadingGroup: true,
+			},
+		}
+		r.IndexInst.Defn = c.IndexDefn{SecExprs: []string{"a", "b"}}
+		w := &rowWriter{}
+		if err := NewScanPipeline(r, w, is).Execute(); err != nil {
+			t.Fatal(err)
+		}
+		if w.err != nil {
+			t.Fatal(w.err)
+		}
+		return w.rows
+	}
+	check := func(rows, expected []string) {
+		if strings.Join(rows, " ") != strings.Join(expected, " ") {
+			t.Errorf("expected %v, received %v", expected, rows)
+		}
+	}
+
+	// groups of both partitions are merged.
+	all := []c.PartitionId{0, 1}
+	check(scan(all, []int{0}), []string{`["a",2,4]`, `["b",2,6]`})
+	check(scan(all, nil), []string{`[4,10]`})
+
+	// a single partition streams groups in index order.
+	check(scan([]c.PartitionId{1}, []int{0}), []string{`["a",1,3]`, `["b",1,4]`})
+
+	// aggregates without group keys return a row for no entries.
+	partns[2] = &partitionSnapshot{
+		id:     2,
+		slices: map[SliceId]SliceSnapshot{0: &sliceSnapshot{id: 0, snap: &entrySnapshot{}}},
+	}
+	partns[3] = partns[2]
+	check(scan([]c.PartitionId{2, 3}, nil), []string{`[0,null]`})
+}
@@ -16,16 +16,19 @@ import (
 	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 	"github.com/golang/protobuf/proto"
 	"net"
+	"time"
 )
 
 type ScanResponseWriter interface {
 	Error(err error) error
-	Stats(rows, unique uint64, min, max []byte) error
+	Stats(rows, unique uint64, min, max []byte, bins []*protobuf.IndexStatistics) error
 	Count(count uint64) error
 	RawBytes([]byte) error
 	Row(pk, sk []byte) error
 	Done() error
 	Helo() error
+	PinSnapshot(leaseId uint64, ts *common.TsVbuuid, leaseTime time.Duration) error
+	UnpinSnapshot() error
 }
 
 type protoResponseWriter struct {
@@ -35,6 +38,7 @@ type protoResponseWriter struct {
 	rowBuf     *[]byte
 	rowEntries []*protobuf.IndexEntry
 	rowSize    int
+	err        error // error responded with
 }
 
 func NewProtoWriter(t ScanReqType, conn net.Conn) *protoResponseWriter {
@@ -55,6 +59,7 @@ func (w *protoResponseWriter) writeLen(l int) error {
 func (w *protoResponseWriter) Error(err error) error {
 	var res interface{}
 	protoErr := &protobuf.Error{Error: proto.String(err.Error())}
+	w.err = err
 
 	// Drop all collected rows
 	w.rowEntries = nil
@@ -73,18 +78,29 @@ func (w *protoResponseWriter) Error(err error) error {
 		res = &protobuf.ResponseStream{
 			Err: protoErr,
 		}
+	case PinSnapshotReq:
+		res = &protobuf.PinSnapshotResponse{
+			Err: protoErr,
+		}
+	case UnpinSnapshotReq:
+		res = &protobuf.UnpinSnapshotResponse{
+			Err: protoErr,
+		}
 	}
 
 	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
 }
 
-func (w *protoResponseWriter) Stats(rows, unique uint64, min, max []byte) error {
+func (w *protoResponseWriter) Stats(rows, unique uint64, min, max []byte,
+	bins []*protobuf.IndexStatistics) error {
+
 	res := &protobuf.StatisticsResponse{
 		Stats: &protobuf.IndexStatistics{
 			KeysCount:       proto.Uint64(rows),
 			UniqueKeysCount: proto.Uint64(unique),
 			KeyMin:          min,
 			KeyMax:          max,
+			Histogram:       bins,
 		},
 	}
 
@@ -92,13 +108,39 @@ func (w *protoResponseWriter) Stats(rows, unique uint64, min, max []byte) error
 }
 
 func (w *protoResponseWriter) Helo() error {
+	features := protobuf.FeatureScanResume | protobuf.FeatureSnapshotLease |
+		protobuf.FeatureScanFilter
 	res := &protobuf.HeloResponse{
-		Version: proto.Uint32(common.INDEXER_CUR_VERSION),
+		Version:  proto.Uint32(common.INDEXER_CUR_VERSION),
+		Features: proto.Uint64(features),
+	}
+
+	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
+}
+
+func (w *protoResponseWriter) PinSnapshot(leaseId uint64, ts *common.TsVbuuid,
+	leaseTime time.Duration) error {
+
+	res := &protobuf.PinSnapshotResponse{
+		LeaseId:   proto.Uint64(leaseId),
+		LeaseTime: proto.Uint64(uint64(leaseTime / time.Millisecond)),
+	}
+	if ts != nil {
+		vbnos := make([]uint16, len(ts.Seqnos))
+		for i := range vbnos {
+			vbnos[i] = uint16(i)
+		}
+		res.Ts = protobuf.NewTsConsistency(vbnos, ts.Seqnos, ts.Vbuuids, ts.Crc64)
 	}
 
 	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
 }
 
+func (w *protoResponseWriter) UnpinSnapshot() error {
+	res := &protobuf.UnpinSnapshotResponse{}
+	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
+}
+
 func (w *protoResponseWriter) Count(c uint64) error {
 	res := &protobuf.CountResponse{
 		Count: proto.Int64(int64(c)),
@@ -0,0 +1,121 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/couchbase/indexing/secondary/collatejson"
+	"github.com/couchbase/indexing/secondary/common"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+)
+
+var ErrResumeNotSupported = errors.New("Scan resume is not supported for distinct, " +
+	"reverse, grouped, sorted or partitioned scans")
+
+// ScanResume is the position in index order after which a scan resumes,
+// when a scan that failed after returning entries is retried by the
+// client. Position is the last entry returned to the client, encoded
+// as a storage entry, so that it is compared in the order entries are
+// scanned.
+type ScanResume struct {
+	entry  []byte
+	prefix int // length of encoded key and docid in entry
+	count  int // rows of entry already returned
+}
+
+// newScanResume validates that entries of scan `r` are returned in index
+// order, and encodes the resume position.
+func newScanResume(r *ScanRequest, resume *protobuf.ScanResume) (*ScanResume, error) {
+	defn := &r.IndexInst.Defn
+	if r.Distinct || r.Reverse || r.GroupAggr != nil || r.Sort != nil || r.Offset != 0 {
+		return nil, ErrResumeNotSupported
+	}
+	if defn.PartitionScheme != common.RANGE && defn.GetNumPartitions() > 1 &&
+		len(r.PartitionIds) != 1 {
+		// entries of hash partitions are not scanned in index order.
+		return nil, ErrResumeNotSupported
+	}
+
+	docid := resume.GetPrimaryKey()
+	count := int(resume.GetCount())
+	if len(docid) == 0 || count < 1 {
+		return nil, errors.New("Invalid scan resume position")
+	}
+
+	if r.isPrimary {
+		entry, err := NewPrimaryIndexEntry(docid)
+		if err != nil {
+			return nil, err
+		}
+		return &ScanResume{entry: entry, prefix: len(entry), count: count}, nil
+	}
+
+	key := resume.GetEntryKey()
+	size := 3*len(key) + len(docid) + MAX_KEY_EXTRABYTES_LEN + collatejson.MinBufferSize
+	entry, err := NewSecondaryIndexEntry2(key, docid, defn.IsArrayIndex,
+		count, defn.Desc, make([]byte, 0, size), false)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid scan resume key %s (%v)", string(key), err)
+	}
+	prefix := entry.lenKey() + entry.lenDocId()
+	return &ScanResume{entry: entry, prefix: prefix, count: count}, nil
+}
+
+// compare storage `entry` with resume position, returns -1 if entry was
+// scanned before the position, 0 if entry is at the position and +1 if
+// entry is to be scanned.
+func (sr *ScanResume) compare(entry []byte, isPrimary bool) int {
+	prefix := len(entry)
+	if !isPrimary {
+		e := secondaryIndexEntry(entry)
+		prefix = e.lenKey() + e.lenDocId()
+	}
+	if bytes.Equal(entry[:prefix], sr.entry[:sr.prefix]) {
+		return 0
+	}
+	return bytes.Compare(entry, sr.entry)
+}
+
+// bounds to scan `scan` from the resume position, so that storage
+// iterators seek to the position instead of iterating entries returned
+// by the earlier scan. Scan is unchanged if it starts after the
+// position, lookups and full scans are converted to ranges.
+func (sr *ScanResume) bounds(scan Scan, isPrimary bool) (low, high IndexKey, incl Inclusion) {
+	switch scan.ScanType {
+	case AllReq:
+		low, high, incl = MinIndexKey, MaxIndexKey, Both
+	case LookupReq:
+		low, high, incl = scan.Equals, scan.Equals, Both
+	default:
+		low, high, incl = scan.Low, scan.High, scan.Incl
+	}
+
+	pos := sr.entry[:sr.prefix]
+	if b := low.Bytes(); b != nil && bytes.Compare(b, pos) >= 0 {
+		return low, high, incl
+	}
+
+	// entries before pos are excluded by the seek, entries at pos are
+	// skipped by count.
+	if incl == High || incl == Both {
+		incl = Both
+	} else {
+		incl = Low
+	}
+	if isPrimary {
+		k := primaryKey(pos)
+		return &k, high, incl
+	}
+	k := secondaryKey(pos)
+	return &k, high, incl
+}
@@ -0,0 +1,138 @@
+package indexer
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+	"github.com/golang/protobuf/proto"
+)
+
+type resumeRow struct {
+	key, docid string
+	entry      []byte
+}
+
+type resumeRows []resumeRow
+
+func (rows resumeRows) Len() int           { return len(rows) }
+func (rows resumeRows) Swap(i, j int)      { rows[i], rows[j] = rows[j], rows[i] }
+func (rows resumeRows) Less(i, j int) bool { return bytes.Compare(rows[i].entry, rows[j].entry) < 0 }
+
+func TestScanResumeCompare(t *testing.T) {
+	for _, desc := range [][]bool{nil, {true, false}} {
+		r := &ScanRequest{}
+		r.IndexInst.Defn = common.IndexDefn{SecExprs: []string{"a", "b"}, Desc: desc}
+
+		var rows resumeRows
+		for _, key := range []string{`[1,"x"]`, `[1,"y"]`, `[2,"x"]`, `[10,"a"]`} {
+			for _, docid := range []string{"doc1", "doc2"} {
+				buf := make([]byte, 0, 4096*3)
+				e, err := NewSecondaryIndexEntry([]byte(key), []byte(docid),
+					false, 1, desc, buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rows = append(rows, resumeRow{key, docid, e})
+			}
+		}
+		sort.Sort(rows)
+
+		for i, pos := range rows {
+			resume, err := newScanResume(r, &protobuf.ScanResume{
+				EntryKey:   []byte(pos.key),
+				PrimaryKey: []byte(pos.docid),
+				Count:      proto.Int64(1),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for j, other := range rows {
+				cmp := resume.compare(other.entry, false)
+				if (j < i && cmp >= 0) || (j == i && cmp != 0) || (j > i && cmp <= 0) {
+					t.Errorf("desc %v: %v %v compared %v with position %v %v",
+						desc, other.key, other.docid, cmp, pos.key, pos.docid)
+				}
+			}
+		}
+	}
+}
+
+func TestScanResumeNotSupported(t *testing.T) {
+	resume := &protobuf.ScanResume{
+		EntryKey:   []byte(`[1]`),
+		PrimaryKey: []byte("doc1"),
+		Count:      proto.Int64(1),
+	}
+
+	r := &ScanRequest{Distinct: true}
+	if _, err := newScanResume(r, resume); err != ErrResumeNotSupported {
+		t.Errorf("expected %v for distinct scan, received %v", ErrResumeNotSupported, err)
+	}
+
+	r = &ScanRequest{Offset: 10}
+	if _, err := newScanResume(r, resume); err != ErrResumeNotSupported {
+		t.Errorf("expected %v for scan with offset, received %v", ErrResumeNotSupported, err)
+	}
+
+	r = &ScanRequest{}
+	resume.Count = proto.Int64(0)
+	if _, err := newScanResume(r, resume); err == nil {
+		t.Errorf("expected error for invalid resume count")
+	}
+}
+
+func TestScanResumeBounds(t *testing.T) {
+	r := &ScanRequest{}
+	r.IndexInst.Defn = common.IndexDefn{SecExprs: []string{"a", "b"}}
+	resume, err := newScanResume(r, &protobuf.ScanResume{
+		EntryKey:   []byte(`[2,"x"]`),
+		PrimaryKey: []byte("doc1"),
+		Count:      proto.Int64(1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := resume.entry[:resume.prefix]
+	key := func(k string) IndexKey {
+		ik, err := NewSecondaryKey([]byte(k), make([]byte, 0, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ik
+	}
+
+	// full scan and scans starting before the position seek to it.
+	low, high, incl := resume.bounds(Scan{ScanType: AllReq}, false)
+	if !bytes.Equal(low.Bytes(), pos) || high != MaxIndexKey || incl != Both {
+		t.Errorf("unexpected bounds for full scan %v %v %v", low, high, incl)
+	}
+	scan := Scan{ScanType: RangeReq, Low: key(`[1]`), High: key(`[5]`), Incl: Neither}
+	low, high, incl = resume.bounds(scan, false)
+	if !bytes.Equal(low.Bytes(), pos) || high != scan.High || incl != Low {
+		t.Errorf("unexpected bounds for range %v %v %v", low, high, incl)
+	}
+	lookup := key(`[2]`)
+	low, high, incl = resume.bounds(Scan{ScanType: LookupReq, Equals: lookup}, false)
+	if !bytes.Equal(low.Bytes(), pos) || high != lookup || incl != Both {
+		t.Errorf("unexpected bounds for lookup %v %v %v", low, high, incl)
+	}
+
+	// scans after the position are not changed.
+	scan = Scan{ScanType: RangeReq, Low: key(`[3]`), High: key(`[5]`), Incl: High}
+	low, high, incl = resume.bounds(scan, false)
+	if low != scan.Low || high != scan.High || incl != High {
+		t.Errorf("unexpected bounds for range after position %v %v %v", low, high, incl)
+	}
+
+	r.Reverse = true
+	if _, err := newScanResume(r, &protobuf.ScanResume{
+		EntryKey:   []byte(`[2,"x"]`),
+		PrimaryKey: []byte("doc1"),
+		Count:      proto.Int64(1),
+	}); err != ErrResumeNotSupported {
+		t.Errorf("expected %v for reverse scan, received %v", ErrResumeNotSupported, err)
+	}
+}
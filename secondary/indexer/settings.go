@@ -15,7 +15,6 @@ import (
 	"fmt"
 
 	"github.com/couchbase/cbauth"
-	"github.com/couchbase/cbauth/metakv"
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/logging"
 	"github.com/couchbase/indexing/secondary/pipeline"
@@ -76,7 +75,7 @@ func NewSettingsManager(supvCmdch MsgChannel,
 			if r > 0 {
 				logging.Errorf("IndexerSettingsManager: metakv notifier failed (%v)..Restarting %v", err, r)
 			}
-			err = metakv.RunObserveChildren("/", s.metaKVCallback, s.cancelCh)
+			err = common.Metakv().RunObserveChildren("/", s.metaKVCallback, s.cancelCh)
 			return err
 		}
 		rh := common.NewRetryHelper(MAX_METAKV_RETRIES, time.Second, 2, fn)
@@ -140,7 +139,7 @@ func (s *settingsManager) handleSettingsReq(w http.ResponseWriter, r *http.Reque
 		}
 
 		config := s.config.FilterConfig(".settings.")
-		current, rev, err := metakv.Get(common.IndexingSettingsMetaPath)
+		current, rev, err := common.Metakv().Get(common.IndexingSettingsMetaPath)
 		if err == nil {
 			if len(current) > 0 {
 				config.Update(current)
@@ -155,7 +154,7 @@ func (s *settingsManager) handleSettingsReq(w http.ResponseWriter, r *http.Reque
 
 		//settingsConfig := config.FilterConfig(".settings.")
 		newSettingsBytes := config.Json()
-		if err = metakv.Set(common.IndexingSettingsMetaPath, newSettingsBytes, rev); err != nil {
+		if err = common.Metakv().Set(common.IndexingSettingsMetaPath, newSettingsBytes, rev); err != nil {
 			s.writeError(w, err)
 			return
 		}
@@ -193,14 +192,14 @@ func (s *settingsManager) handleCompactionTrigger(w http.ResponseWriter, r *http
 		return
 	}
 
-	_, rev, err := metakv.Get(indexCompactonMetaPath)
+	_, rev, err := common.Metakv().Get(indexCompactonMetaPath)
 	if err != nil {
 		s.writeError(w, err)
 		return
 	}
 
 	newToken := time.Now().String()
-	if err = metakv.Set(indexCompactonMetaPath, []byte(newToken), rev); err != nil {
+	if err = common.Metakv().Set(indexCompactonMetaPath, []byte(newToken), rev); err != nil {
 		s.writeError(w, err)
 		return
 	}
@@ -246,7 +245,7 @@ func (s *settingsManager) metaKVCallback(path string, value []byte, rev interfac
 
 		upgradedConfig, upgraded := tryUpgradeConfig(value)
 		if upgraded {
-			if err := metakv.Set(common.IndexingSettingsMetaPath, upgradedConfig, rev); err != nil {
+			if err := common.Metakv().Set(common.IndexingSettingsMetaPath, upgradedConfig, rev); err != nil {
 				return err
 			}
 			return nil
@@ -28,6 +28,9 @@ type Snapshot interface {
 	Timestamp() *common.TsVbuuid
 
 	Info() SnapshotInfo
+
+	// Estimates of key distribution, nil if not maintained by slice.
+	KeyStatistics() *keyStatsData
 }
 
 type SnapshotInfo interface {
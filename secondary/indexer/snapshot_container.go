@@ -11,6 +11,10 @@ package indexer
 
 import (
 	"container/list"
+	"errors"
+	"sync"
+	"time"
+
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/logging"
 )
@@ -166,3 +170,199 @@ func (sc *snapshotInfoContainer) GetOlderThanTS(tsVbuuid *common.TsVbuuid) Snaps
 	logging.Infof("SnapshotContainer::GetOlderThanTS Returning nil as no matching snapshot found")
 	return nil
 }
+
+// Errors on snapshots pinned for scans at a point in time
+var (
+	ErrSnapshotLeaseNotFound = errors.New("Snapshot lease not found or expired")
+	ErrSnapshotLeaseMismatch = errors.New("Snapshot lease does not belong to the index")
+	ErrSnapshotLeaseLimit    = errors.New("Too many snapshots pinned for the index")
+)
+
+//snapshotLease pins an index snapshot, so that scans at the same point
+//in time can be issued until the lease expires.
+type snapshotLease struct {
+	id        uint64
+	instId    common.IndexInstId
+	snap      IndexSnapshot
+	leaseTime time.Duration
+	expiry    time.Time
+
+	//delete_bytes of the index when the snapshot was pinned. Entries
+	//deleted afterwards are retained by storage for the pinned snapshot.
+	deleteBytes int64
+}
+
+//pinnedSnapshotStats of an index instance
+type pinnedSnapshotStats struct {
+	numPinned   int64
+	deleteBytes int64 //delete_bytes when the oldest snapshot was pinned
+}
+
+//pinnedSnapshotContainer retains index snapshots pinned by clients. Every
+//lease holds a clone of the snapshot, which is destroyed when the lease
+//is released or expires.
+type pinnedSnapshotContainer struct {
+	mu     sync.Mutex
+	leases map[uint64]*snapshotLease
+	nextId uint64
+}
+
+func newPinnedSnapshotContainer() *pinnedSnapshotContainer {
+	return &pinnedSnapshotContainer{
+		leases: make(map[uint64]*snapshotLease),
+		//lease ids are seeded from the clock, so that leases granted
+		//before a restart are not mistaken for new ones.
+		nextId: uint64(time.Now().UnixNano()),
+	}
+}
+
+//Pin retains a clone of the snapshot for leaseTime. maxLeases limits the
+//number of snapshots pinned for an index instance, 0 is unlimited.
+func (pc *pinnedSnapshotContainer) Pin(is IndexSnapshot, leaseTime time.Duration,
+	maxLeases int, deleteBytes int64) (snapshotLease, error) {
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if maxLeases > 0 && pc.numPinned(is.IndexInstId()) >= maxLeases {
+		return snapshotLease{}, ErrSnapshotLeaseLimit
+	}
+
+	pc.nextId++
+	lease := &snapshotLease{
+		id:          pc.nextId,
+		instId:      is.IndexInstId(),
+		snap:        CloneIndexSnapshot(is),
+		leaseTime:   leaseTime,
+		expiry:      time.Now().Add(leaseTime),
+		deleteBytes: deleteBytes,
+	}
+	pc.leases[lease.id] = lease
+	return *lease, nil
+}
+
+//Renew extends an unexpired lease by leaseTime from now.
+func (pc *pinnedSnapshotContainer) Renew(id uint64, instId common.IndexInstId,
+	leaseTime time.Duration) (snapshotLease, error) {
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	lease, err := pc.getLease(id, instId)
+	if err != nil {
+		return snapshotLease{}, err
+	}
+	lease.leaseTime = leaseTime
+	lease.expiry = time.Now().Add(leaseTime)
+	return *lease, nil
+}
+
+//Get returns a clone of the snapshot pinned by the lease, which has to
+//be destroyed by the caller once the scan is done.
+func (pc *pinnedSnapshotContainer) Get(id uint64,
+	instId common.IndexInstId) (IndexSnapshot, error) {
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	lease, err := pc.getLease(id, instId)
+	if err != nil {
+		return nil, err
+	}
+	return CloneIndexSnapshot(lease.snap), nil
+}
+
+//Unpin releases the snapshot pinned by the lease.
+func (pc *pinnedSnapshotContainer) Unpin(id uint64) error {
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	lease, ok := pc.leases[id]
+	if !ok {
+		return ErrSnapshotLeaseNotFound
+	}
+	pc.release(lease)
+	return nil
+}
+
+//Expire releases the snapshots whose lease has expired at now and
+//returns the number of snapshots released.
+func (pc *pinnedSnapshotContainer) Expire(now time.Time) int {
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	count := 0
+	for _, lease := range pc.leases {
+		if now.After(lease.expiry) {
+			logging.Infof("PinnedSnapshotContainer::Expire Lease %v Expired For "+
+				"Index %v Snapshot %v", lease.id, lease.instId, lease.snap.Timestamp())
+			pc.release(lease)
+			count++
+		}
+	}
+	return count
+}
+
+//UnpinMissing releases the snapshots of index instances no longer
+//present in indexInstMap.
+func (pc *pinnedSnapshotContainer) UnpinMissing(indexInstMap common.IndexInstMap) {
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	for _, lease := range pc.leases {
+		if _, ok := indexInstMap[lease.instId]; !ok {
+			pc.release(lease)
+		}
+	}
+}
+
+//Stats returns the pinned snapshot stats of every index instance
+//with snapshots pinned.
+func (pc *pinnedSnapshotContainer) Stats() map[common.IndexInstId]pinnedSnapshotStats {
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	stats := make(map[common.IndexInstId]pinnedSnapshotStats)
+	for _, lease := range pc.leases {
+		st, ok := stats[lease.instId]
+		if !ok || lease.deleteBytes < st.deleteBytes {
+			st.deleteBytes = lease.deleteBytes
+		}
+		st.numPinned++
+		stats[lease.instId] = st
+	}
+	return stats
+}
+
+func (pc *pinnedSnapshotContainer) getLease(id uint64,
+	instId common.IndexInstId) (*snapshotLease, error) {
+
+	lease, ok := pc.leases[id]
+	if !ok || time.Now().After(lease.expiry) {
+		return nil, ErrSnapshotLeaseNotFound
+	}
+	if lease.instId != instId {
+		return nil, ErrSnapshotLeaseMismatch
+	}
+	return lease, nil
+}
+
+func (pc *pinnedSnapshotContainer) numPinned(instId common.IndexInstId) int {
+
+	count := 0
+	for _, lease := range pc.leases {
+		if lease.instId == instId {
+			count++
+		}
+	}
+	return count
+}
+
+func (pc *pinnedSnapshotContainer) release(lease *snapshotLease) {
+	delete(pc.leases, lease.id)
+	DestroyIndexSnapshot(lease.snap)
+}
@@ -0,0 +1,119 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+type refCountSnapshot struct {
+	Snapshot
+	refs int
+}
+
+func (s *refCountSnapshot) Open() error {
+	s.refs++
+	return nil
+}
+
+func (s *refCountSnapshot) Close() error {
+	s.refs--
+	return nil
+}
+
+func newRefCountIndexSnapshot(instId common.IndexInstId) (IndexSnapshot, *refCountSnapshot) {
+	snap := &refCountSnapshot{refs: 1}
+	is := &indexSnapshot{
+		instId: instId,
+		ts:     common.NewTsVbuuid("default", 4),
+		partns: map[common.PartitionId]PartitionSnapshot{
+			0: &partitionSnapshot{
+				id:     0,
+				slices: map[SliceId]SliceSnapshot{0: &sliceSnapshot{id: 0, snap: snap}},
+			},
+		},
+	}
+	return is, snap
+}
+
+func TestPinnedSnapshotContainer(t *testing.T) {
+	pc := newPinnedSnapshotContainer()
+	is, snap := newRefCountIndexSnapshot(1)
+
+	lease, err := pc.Pin(is, time.Minute, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// snapshot is retained after the scan that pinned it is done
+	DestroyIndexSnapshot(is)
+	if snap.refs != 1 {
+		t.Fatalf("expected pinned snapshot to be open, refs %v", snap.refs)
+	}
+
+	if _, err := pc.Pin(is, time.Minute, 1, 100); err != ErrSnapshotLeaseLimit {
+		t.Fatalf("expected %v, received %v", ErrSnapshotLeaseLimit, err)
+	}
+
+	scanSnap, err := pc.Get(lease.id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.refs != 2 {
+		t.Fatalf("expected scan to clone pinned snapshot, refs %v", snap.refs)
+	}
+	DestroyIndexSnapshot(scanSnap)
+
+	if _, err := pc.Get(lease.id, 2); err != ErrSnapshotLeaseMismatch {
+		t.Fatalf("expected %v, received %v", ErrSnapshotLeaseMismatch, err)
+	}
+
+	stats := pc.Stats()
+	if st := stats[1]; st.numPinned != 1 || st.deleteBytes != 100 {
+		t.Fatalf("unexpected pinned snapshot stats %+v", st)
+	}
+
+	if err := pc.Unpin(lease.id); err != nil {
+		t.Fatal(err)
+	}
+	if snap.refs != 0 {
+		t.Fatalf("expected snapshot to be released, refs %v", snap.refs)
+	}
+	if err := pc.Unpin(lease.id); err != ErrSnapshotLeaseNotFound {
+		t.Fatalf("expected %v, received %v", ErrSnapshotLeaseNotFound, err)
+	}
+}
+
+func TestPinnedSnapshotExpiry(t *testing.T) {
+	pc := newPinnedSnapshotContainer()
+	is1, snap1 := newRefCountIndexSnapshot(1)
+	is2, snap2 := newRefCountIndexSnapshot(2)
+
+	lease1, _ := pc.Pin(is1, time.Minute, 0, 0)
+	lease2, _ := pc.Pin(is2, time.Minute, 0, 0)
+
+	if _, err := pc.Renew(lease1.id, 1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := pc.Expire(time.Now().Add(2 * time.Minute)); n != 1 {
+		t.Fatalf("expected 1 lease to expire, expired %v", n)
+	}
+	if snap1.refs != 2 || snap2.refs != 1 {
+		t.Fatalf("expected only unrenewed lease to expire, refs %v %v",
+			snap1.refs, snap2.refs)
+	}
+	if _, err := pc.Get(lease2.id, 2); err != ErrSnapshotLeaseNotFound {
+		t.Fatalf("expected %v, received %v", ErrSnapshotLeaseNotFound, err)
+	}
+
+	// snapshots of dropped index are released
+	pc.UnpinMissing(common.IndexInstMap{2: common.IndexInst{InstId: 2}})
+	if snap1.refs != 1 {
+		t.Fatalf("expected snapshot of dropped index to be released, refs %v",
+			snap1.refs)
+	}
+	if len(pc.Stats()) != 0 {
+		t.Fatalf("expected no pinned snapshots, found %v", pc.Stats())
+	}
+}
@@ -0,0 +1,781 @@
+// @copyright 2016 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/cbauth"
+	c "github.com/couchbase/indexing/secondary/common"
+	l "github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/memdb"
+)
+
+// Peer to peer snapshot transfer copies the latest persisted snapshot of
+// a memory optimized index from the source indexer to the destination
+// indexer during rebalance, so that the index catches up from the
+// snapshot timestamp instead of being built from scratch.
+//
+// Source stages the snapshot of every partition by hard linking its
+// files, so that they outlive snapshot cleanup for the duration of the
+// transfer, and serves a manifest of the files with their checksums.
+// A delta snapshot is staged along with every snapshot of its chain, down
+// to the full snapshot, each in a directory of the same name as in the
+// slice path, as deltas refer their parent by its relative path.
+// Destination downloads the files into the slice path of the partition
+// before the index is created, resuming partially downloaded files.
+//
+// Only memdb snapshots are transferred, their files are not modified once
+// persisted. Forestdb, lsm and plasma files are modified in place and
+// cannot be copied consistently while the index is being updated, so
+// indexes on them are never marked for peer transfer and are built from
+// DCP on the destination, as are indexes whose snapshots fail to copy.
+
+const (
+	snapTransferDir         = ".transfer"
+	snapTransferIdleTimeout = time.Hour
+	snapTransferRetries     = 3
+	snapTransferBufSize     = 1024 * 1024
+
+	// snapshots are transferred only between indexers, staged snapshots
+	// contain the entries of all documents indexed.
+	snapTransferPermission = "cluster.admin.internal.index!write"
+)
+
+var (
+	ErrSnapshotTransferNotSupported = errors.New("Snapshot transfer not supported for index")
+	ErrSnapshotNotFound             = errors.New("No persisted snapshot found for index")
+	ErrSnapshotNotStaged            = errors.New("Snapshot not staged for transfer")
+	ErrSnapshotChecksum             = errors.New("Snapshot file checksum mismatch")
+	ErrSnapshotTransferCancel       = errors.New("Snapshot transfer cancelled")
+)
+
+var snapCrcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// snapshotManifest lists the files of a persisted snapshot of an index
+// partition.
+type snapshotManifest struct {
+	InstId      c.IndexInstId
+	PartnId     c.PartitionId
+	StorageMode string
+	Snapshot    string   // staged snapshot to download files from
+	Dir         string   // snapshot directory in slice path
+	Chain       []string // snapshot directories to restore Dir, Dir last
+	Ts          *c.TsVbuuid
+	Files       []snapshotFile
+}
+
+type snapshotFile struct {
+	Name     string // path relative to slice path, e.g. snapshot.1/data/shard-0
+	Size     int64
+	Checksum uint32
+}
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  source
+//
+/////////////////////////////////////////////////////////////////////////
+
+type snapshotTransfer struct {
+	supvMsgch MsgChannel
+	config    c.ConfigHolder
+
+	stageMu sync.Mutex // serializes staging of snapshots
+
+	mu     sync.Mutex
+	stages map[string]*snapshotStage
+}
+
+type snapshotStage struct {
+	manifest *snapshotManifest
+	path     string
+	readers  int
+	access   time.Time
+}
+
+func newSnapshotTransfer(supvMsgch MsgChannel, config c.Config) *snapshotTransfer {
+
+	t := &snapshotTransfer{
+		supvMsgch: supvMsgch,
+		stages:    make(map[string]*snapshotStage),
+	}
+	t.config.Store(config)
+
+	//snapshots staged before restart are not tracked anymore
+	if err := os.RemoveAll(t.stageDir()); err != nil {
+		l.Errorf("SnapshotTransfer: Error cleaning up %v. Err %v", t.stageDir(), err)
+	}
+
+	go t.janitor()
+	return t
+}
+
+func (t *snapshotTransfer) stageDir() string {
+	cfg := t.config.Load()
+	return filepath.Join(cfg["storage_dir"].String(), snapTransferDir)
+}
+
+// handleManifest stages the latest persisted snapshot of an index
+// partition and responds with its manifest.
+func (t *snapshotTransfer) handleManifest(w http.ResponseWriter, r *http.Request) {
+
+	if !t.validateAuth(w, r) {
+		return
+	}
+
+	instId, err1 := strconv.ParseUint(r.FormValue("instId"), 10, 64)
+	partnId, err2 := strconv.ParseUint(r.FormValue("partnId"), 10, 64)
+	if err1 != nil || err2 != nil {
+		send(http.StatusBadRequest, w, "Bad Request - Invalid Index Instance or Partition")
+		return
+	}
+
+	slices := t.getIndexSlices(c.IndexInstId(instId))
+	if slices == nil {
+		send(http.StatusNotFound, w, c.ErrIndexNotFound.Error())
+		return
+	}
+
+	manifest, err := t.stage(slices, c.PartitionId(partnId))
+	if err != nil {
+		l.Errorf("SnapshotTransfer::handleManifest Index %v Partition %v. Err %v",
+			instId, partnId, err)
+		send(http.StatusNotFound, w, err.Error())
+		return
+	}
+
+	send(http.StatusOK, w, manifest)
+}
+
+// handleFile serves a file of a staged snapshot. Range requests are
+// supported to resume partially downloaded files.
+func (t *snapshotTransfer) handleFile(w http.ResponseWriter, r *http.Request) {
+
+	if !t.validateAuth(w, r) {
+		return
+	}
+
+	name := r.FormValue("file")
+	if !validSnapshotFileName(name) {
+		send(http.StatusBadRequest, w, "Bad Request - Invalid File")
+		return
+	}
+
+	stage := t.acquire(r.FormValue("snapshot"))
+	if stage == nil {
+		send(http.StatusNotFound, w, ErrSnapshotNotStaged.Error())
+		return
+	}
+	defer t.release(stage)
+
+	fd, err := os.Open(filepath.Join(stage.path, filepath.FromSlash(name)))
+	if err != nil {
+		send(http.StatusNotFound, w, err.Error())
+		return
+	}
+	defer fd.Close()
+
+	fi, err := fd.Stat()
+	if err != nil {
+		send(http.StatusInternalServerError, w, err.Error())
+		return
+	}
+
+	http.ServeContent(w, r, name, fi.ModTime(), fd)
+}
+
+// handleRelease removes a staged snapshot, once it has been copied.
+func (t *snapshotTransfer) handleRelease(w http.ResponseWriter, r *http.Request) {
+
+	if !t.validateAuth(w, r) {
+		return
+	}
+
+	name := r.FormValue("snapshot")
+
+	t.mu.Lock()
+	stage, ok := t.stages[name]
+	if ok && stage.readers == 0 {
+		delete(t.stages, name)
+	}
+	t.mu.Unlock()
+
+	if ok && stage.readers == 0 {
+		l.Infof("SnapshotTransfer::handleRelease Removing staged snapshot %v", stage.path)
+		os.RemoveAll(stage.path)
+	}
+	send(http.StatusOK, w, "OK")
+}
+
+// validateAuth returns true if the request is authenticated and allowed
+// to transfer snapshots, see snapTransferPermission.
+func (t *snapshotTransfer) validateAuth(w http.ResponseWriter, r *http.Request) bool {
+	creds, valid, err := c.IsAuthValid(r)
+	if err != nil {
+		send(http.StatusBadRequest, w, err.Error())
+		return false
+	} else if valid == false {
+		w.WriteHeader(401)
+		w.Write([]byte("401 Unauthorized\n"))
+		return false
+	}
+	return c.IsAllowed(creds, []string{snapTransferPermission}, w)
+}
+
+func (t *snapshotTransfer) getIndexSlices(instId c.IndexInstId) *indexSlices {
+	respch := make(chan *indexSlices)
+	t.supvMsgch <- &MsgIndexSlices{instId: instId, respch: respch}
+	return <-respch
+}
+
+// isSnapshotTransferSupported returns true if snapshots of index `defn`
+// can be transferred, see above.
+func isSnapshotTransferSupported(defn *c.IndexDefn) bool {
+	return c.IndexTypeToStorageMode(defn.Using) == c.MOI
+}
+
+// stage the latest persisted snapshot of partition `partnId`.
+func (t *snapshotTransfer) stage(slices *indexSlices,
+	partnId c.PartitionId) (*snapshotManifest, error) {
+
+	inst := slices.inst
+	if !isSnapshotTransferSupported(&inst.Defn) {
+		return nil, ErrSnapshotTransferNotSupported
+	}
+	if inst.State != c.INDEX_STATE_ACTIVE {
+		return nil, ErrSnapshotNotFound
+	}
+
+	slice, ok := slices.slices[partnId]
+	if !ok {
+		return nil, c.ErrIndexNotFound
+	}
+
+	mdb, ok := slice.(*memdbSlice)
+	if !ok {
+		return nil, ErrSnapshotTransferNotSupported
+	}
+
+	infos, err := mdb.GetSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, ErrSnapshotNotFound
+	}
+	info := infos[0].(*memdbSnapshotInfo)
+
+	dir := filepath.Base(info.dataPath)
+	name := fmt.Sprintf("%d_%d_%s", inst.InstId, partnId, dir)
+
+	t.stageMu.Lock()
+	defer t.stageMu.Unlock()
+
+	if stage := t.acquire(name); stage != nil {
+		t.release(stage)
+		return stage.manifest, nil
+	}
+
+	chain, err := memdb.SnapshotChain(info.dataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(t.stageDir(), name)
+	os.RemoveAll(path)
+	files, dirs, err := linkSnapshotChain(chain, path)
+	if err != nil {
+		os.RemoveAll(path)
+		return nil, err
+	}
+
+	manifest := &snapshotManifest{
+		InstId:      inst.InstId,
+		PartnId:     partnId,
+		StorageMode: c.IndexTypeToStorageMode(inst.Defn.Using).String(),
+		Snapshot:    name,
+		Dir:         dir,
+		Chain:       dirs,
+		Ts:          info.Timestamp(),
+		Files:       files,
+	}
+
+	t.mu.Lock()
+	t.stages[name] = &snapshotStage{
+		manifest: manifest,
+		path:     path,
+		access:   time.Now(),
+	}
+	t.mu.Unlock()
+
+	l.Infof("SnapshotTransfer::stage Index %v Partition %v Staged snapshot %v, chain %v, %v files",
+		inst.InstId, partnId, info.dataPath, dirs, len(files))
+	return manifest, nil
+}
+
+func (t *snapshotTransfer) acquire(name string) *snapshotStage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stage, ok := t.stages[name]
+	if !ok {
+		return nil
+	}
+	stage.readers++
+	stage.access = time.Now()
+	return stage
+}
+
+func (t *snapshotTransfer) release(stage *snapshotStage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stage.readers--
+	stage.access = time.Now()
+}
+
+// janitor removes staged snapshots that are not accessed for a while,
+// as destination may fail without releasing them.
+func (t *snapshotTransfer) janitor() {
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var expired []*snapshotStage
+
+		t.mu.Lock()
+		for name, stage := range t.stages {
+			if stage.readers == 0 && time.Since(stage.access) > snapTransferIdleTimeout {
+				delete(t.stages, name)
+				expired = append(expired, stage)
+			}
+		}
+		t.mu.Unlock()
+
+		for _, stage := range expired {
+			l.Infof("SnapshotTransfer::janitor Removing idle staged snapshot %v", stage.path)
+			os.RemoveAll(stage.path)
+		}
+	}
+}
+
+// linkSnapshotChain hard links files of the snapshot directories in
+// `chain` into directories of the same name under `dst`, returns the
+// files, named relative to `dst`, and the names of the directories.
+// Snapshots of a chain are siblings in the slice path.
+func linkSnapshotChain(chain []string, dst string) ([]snapshotFile, []string, error) {
+
+	var files []snapshotFile
+	var dirs []string
+	for _, src := range chain {
+		if filepath.Dir(src) != filepath.Dir(chain[len(chain)-1]) {
+			return nil, nil, ErrSnapshotTransferNotSupported
+		}
+
+		dir := filepath.Base(src)
+		linked, err := linkSnapshotFiles(src, filepath.Join(dst, dir))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, f := range linked {
+			f.Name = dir + "/" + f.Name
+			files = append(files, f)
+		}
+		dirs = append(dirs, dir)
+	}
+
+	return files, dirs, nil
+}
+
+// linkSnapshotFiles hard links files of snapshot directory `src` into
+// directory `dst`, returns the files with their checksums.
+func linkSnapshotFiles(src, dst string) ([]snapshotFile, error) {
+
+	var files []snapshotFile
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if fi.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		if err := os.Link(path, target); err != nil {
+			return err
+		}
+
+		checksum, size, err := fileChecksum(target)
+		if err != nil {
+			return err
+		}
+		files = append(files, snapshotFile{
+			Name:     filepath.ToSlash(rel),
+			Size:     size,
+			Checksum: checksum,
+		})
+		return nil
+	})
+
+	return files, err
+}
+
+func fileChecksum(path string) (uint32, int64, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer fd.Close()
+
+	h := crc32.New(snapCrcTable)
+	size, err := io.Copy(h, fd)
+	if err != nil {
+		return 0, 0, err
+	}
+	return h.Sum32(), size, nil
+}
+
+// validSnapshotFileName returns true if file `name` is within the
+// snapshot directory.
+func validSnapshotFileName(name string) bool {
+	if name == "" {
+		return false
+	}
+	name = filepath.Clean(filepath.FromSlash(name))
+	return !filepath.IsAbs(name) && name != ".." &&
+		!strings.HasPrefix(name, ".."+string(filepath.Separator))
+}
+
+// validSnapshotChain returns true if the snapshot directories in `chain`
+// are within the slice path and end with snapshot directory `dir`.
+func validSnapshotChain(chain []string, dir string) bool {
+	if len(chain) == 0 || chain[len(chain)-1] != dir {
+		return false
+	}
+	for _, name := range chain {
+		if !validSnapshotFileName(name) || filepath.Base(name) != name || name == "." {
+			return false
+		}
+	}
+	return true
+}
+
+/////////////////////////////////////////////////////////////////////////
+//
+//  destination
+//
+/////////////////////////////////////////////////////////////////////////
+
+// transferSnapshots copies the latest persisted snapshots of the
+// partitions hosted by index `inst` from indexer at `addr` into their slice
+// paths under `storageDir`. Partially copied snapshots are removed on
+// failure.
+func transferSnapshots(addr string, inst *c.IndexInst, storageDir string,
+	stopch <-chan struct{}) error {
+
+	if !isSnapshotTransferSupported(&inst.Defn) {
+		return ErrSnapshotTransferNotSupported
+	}
+
+	partnIds := inst.Defn.HostedPartitions()
+	for i, partnId := range partnIds {
+		var err error
+		for retry := 0; retry < snapTransferRetries; retry++ {
+			if retry != 0 {
+				l.Warnf("SnapshotTransfer::transferSnapshots Index %v Partition %v "+
+					"Retrying (%v). Err %v", inst.InstId, partnId, retry, err)
+				time.Sleep(time.Duration(retry) * time.Second)
+			}
+			if err = transferPartition(addr, inst, partnId, storageDir, stopch); err == nil ||
+				err == ErrSnapshotTransferCancel || err == ErrSnapshotTransferNotSupported {
+				break
+			}
+		}
+
+		if err != nil {
+			for _, id := range partnIds[:i+1] {
+				os.RemoveAll(filepath.Join(storageDir, IndexPath(inst, id, SliceId(0))))
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func transferPartition(addr string, inst *c.IndexInst, partnId c.PartitionId,
+	storageDir string, stopch <-chan struct{}) error {
+
+	manifest, err := fetchSnapshotManifest(addr, inst.InstId, partnId)
+	if err != nil {
+		return err
+	}
+
+	storageMode := c.IndexTypeToStorageMode(inst.Defn.Using).String()
+	if manifest.StorageMode != storageMode || manifest.Ts == nil ||
+		!validSnapshotChain(manifest.Chain, manifest.Dir) {
+		return ErrSnapshotTransferNotSupported
+	}
+
+	//snapshot is downloaded into a temporary directory, which is kept
+	//across retries of the same snapshot to resume the download
+	slicePath := filepath.Join(storageDir, IndexPath(inst, partnId, SliceId(0)))
+	tmpPath := filepath.Join(slicePath, tmpDirName+"."+manifest.Dir)
+	if err := cleanupSlicePath(slicePath, tmpPath); err != nil {
+		return err
+	}
+
+	t0 := time.Now()
+	var size int64
+	for _, f := range manifest.Files {
+		if !validSnapshotFileName(f.Name) {
+			return fmt.Errorf("Invalid snapshot file %v", f.Name)
+		}
+		path := filepath.Join(tmpPath, filepath.FromSlash(f.Name))
+		if err := downloadSnapshotFile(addr, manifest.Snapshot, f, path, stopch); err != nil {
+			return err
+		}
+		size += f.Size
+	}
+
+	for _, dir := range manifest.Chain {
+		if err := os.Rename(filepath.Join(tmpPath, dir), filepath.Join(slicePath, dir)); err != nil {
+			return err
+		}
+	}
+	os.RemoveAll(tmpPath)
+
+	l.Infof("SnapshotTransfer::transferPartition Index %v Partition %v Copied snapshot "+
+		"%v (chain %v) from %v, %v files %v bytes. Took %v", inst.InstId, partnId, manifest.Dir,
+		manifest.Chain, addr, len(manifest.Files), size, time.Since(t0))
+
+	releaseSnapshot(addr, manifest.Snapshot)
+	return nil
+}
+
+// cleanupSlicePath removes everything in slice path except partially
+// downloaded snapshot `tmpPath`.
+func cleanupSlicePath(slicePath, tmpPath string) error {
+
+	if err := os.MkdirAll(slicePath, 0755); err != nil {
+		return err
+	}
+
+	entries, err := filepath.Glob(filepath.Join(slicePath, "*"))
+	if err != nil {
+		return err
+	}
+	hidden, _ := filepath.Glob(filepath.Join(slicePath, ".*"))
+	for _, entry := range append(entries, hidden...) {
+		if entry != tmpPath {
+			if err := os.RemoveAll(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func fetchSnapshotManifest(addr string, instId c.IndexInstId,
+	partnId c.PartitionId) (*snapshotManifest, error) {
+
+	params := url.Values{}
+	params.Set("instId", fmt.Sprintf("%v", uint64(instId)))
+	params.Set("partnId", fmt.Sprintf("%v", uint64(partnId)))
+
+	resp, err := getWithAuth(addr + "/snapshotTransfer/manifest?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var reason string
+		convertResponse(resp, &reason)
+		if resp.StatusCode == http.StatusNotFound &&
+			(reason == "" || reason == ErrSnapshotTransferNotSupported.Error()) {
+			//source does not support snapshot transfer
+			return nil, ErrSnapshotTransferNotSupported
+		}
+		return nil, fmt.Errorf("Error fetching snapshot manifest from %v (%v) %v",
+			addr, resp.Status, reason)
+	}
+
+	manifest := new(snapshotManifest)
+	if err := convertResponse(resp, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// downloadSnapshotFile downloads file `f` of staged snapshot into `path`,
+// resuming from the end of an existing partial file.
+func downloadSnapshotFile(addr, snapshot string, f snapshotFile, path string,
+	stopch <-chan struct{}) error {
+
+	var offset int64
+	if fi, err := os.Stat(path); err == nil {
+		offset = fi.Size()
+	}
+
+	if offset == f.Size {
+		if checksum, _, err := fileChecksum(path); err == nil && checksum == f.Checksum {
+			return nil
+		}
+		offset = 0
+	} else if offset > f.Size {
+		offset = 0
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	if offset < f.Size {
+		params := url.Values{}
+		params.Set("snapshot", snapshot)
+		params.Set("file", f.Name)
+		if offset, err = fetchSnapshotFile(addr+"/snapshotTransfer/file?"+params.Encode(),
+			fd, offset, stopch); err != nil {
+			return err
+		}
+	}
+
+	if err := fd.Truncate(offset); err != nil {
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		return err
+	}
+
+	checksum, size, err := fileChecksum(path)
+	if err != nil {
+		return err
+	}
+	if size != f.Size || checksum != f.Checksum {
+		os.Remove(path)
+		return ErrSnapshotChecksum
+	}
+	return nil
+}
+
+// fetchSnapshotFile writes content of file at `url` from `offset` into
+// `fd`, returns the size written up to.
+func fetchSnapshotFile(url string, fd *os.File, offset int64,
+	stopch <-chan struct{}) (int64, error) {
+
+	req, err := http.NewRequest("GET", c.TransportHTTPURL(url), nil)
+	if err != nil {
+		return offset, err
+	}
+	if err := cbauth.SetRequestAuthVia(req, nil); err != nil {
+		return offset, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	//file downloads are not bound by the rebalance http timeout
+	resp, err := c.TransportHTTPClient(0).Do(req)
+	if err != nil {
+		return offset, err
+	}
+
+	donech := make(chan bool)
+	defer close(donech)
+	go func() {
+		select {
+		case <-stopch:
+			resp.Body.Close()
+		case <-donech:
+		}
+	}()
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		//range is not honored, file is served from the beginning
+		offset = 0
+	default:
+		return offset, fmt.Errorf("Error fetching snapshot file %v (%v)", url, resp.Status)
+	}
+
+	if _, err := fd.Seek(offset, 0); err != nil {
+		return offset, err
+	}
+
+	buf := make([]byte, snapTransferBufSize)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := fd.Write(buf[:n]); err != nil {
+				return offset, err
+			}
+			offset += int64(n)
+		}
+
+		select {
+		case <-stopch:
+			return offset, ErrSnapshotTransferCancel
+		default:
+		}
+
+		if err == io.EOF {
+			return offset, nil
+		} else if err != nil {
+			return offset, err
+		}
+	}
+}
+
+func releaseSnapshot(addr, snapshot string) {
+
+	params := url.Values{}
+	params.Set("snapshot", snapshot)
+
+	resp, err := postWithAuth(addr+"/snapshotTransfer/release?"+params.Encode(),
+		"application/json", strings.NewReader(""))
+	if err != nil {
+		l.Warnf("SnapshotTransfer::releaseSnapshot Error releasing %v on %v. Err %v",
+			snapshot, addr, err)
+		return
+	}
+	resp.Body.Close()
+}
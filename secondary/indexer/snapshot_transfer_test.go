@@ -0,0 +1,335 @@
+package indexer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/couchbase/cbauth"
+	"github.com/couchbase/cbauth/cbauthimpl"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/memdb"
+)
+
+func TestSnapshotFileName(t *testing.T) {
+	valid := []string{"manifest.json", "data/shard-0", "a/../b", "./x"}
+	for _, name := range valid {
+		if !validSnapshotFileName(name) {
+			t.Errorf("expected %q to be valid", name)
+		}
+	}
+	invalid := []string{"", "..", "../x", "a/../../x", "/etc/passwd"}
+	for _, name := range invalid {
+		if validSnapshotFileName(name) {
+			t.Errorf("expected %q to be invalid", name)
+		}
+	}
+}
+
+func TestSnapshotLinkFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snaptransfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "snapshot.1")
+	files := map[string]string{
+		"manifest.json":    `{"Ts":null}`,
+		"data/shard-0":     "abcdefgh",
+		"data/shard-1":     "",
+		"data/items/part0": "0123456789",
+	}
+	for name, content := range files {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := filepath.Join(dir, snapTransferDir, "1_0_snapshot.1")
+	linked, err := linkSnapshotFiles(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(linked) != len(files) {
+		t.Fatalf("expected %v files, got %v", len(files), len(linked))
+	}
+
+	var names []string
+	for _, f := range linked {
+		names = append(names, f.Name)
+		content, ok := files[f.Name]
+		if !ok {
+			t.Fatalf("unexpected file %v", f.Name)
+		}
+		if f.Size != int64(len(content)) {
+			t.Errorf("%v: expected size %v, got %v", f.Name, len(content), f.Size)
+		}
+
+		//staged file outlives removal of the snapshot
+		path := filepath.Join(dst, filepath.FromSlash(f.Name))
+		checksum, size, err := fileChecksum(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checksum != f.Checksum || size != f.Size {
+			t.Errorf("%v: checksum mismatch", f.Name)
+		}
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("expected files in walk order, got %v", names)
+	}
+
+	//complete file with matching checksum is not downloaded again
+	os.RemoveAll(src)
+	for _, f := range linked {
+		path := filepath.Join(dst, filepath.FromSlash(f.Name))
+		if err := downloadSnapshotFile("", "", f, path, nil); err != nil {
+			t.Errorf("%v: unexpected download %v", f.Name, err)
+		}
+	}
+}
+
+func TestSnapshotCleanupSlicePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snaptransfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	slicePath := filepath.Join(dir, "default_idx_1_0.index")
+	tmpPath := filepath.Join(slicePath, tmpDirName+".snapshot.2")
+	for _, path := range []string{
+		filepath.Join(slicePath, "snapshot.1"),
+		filepath.Join(slicePath, tmpDirName+".snapshot.1"),
+		filepath.Join(slicePath, tmpDirName),
+		tmpPath,
+	} {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpPath, "part"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cleanupSlicePath(slicePath, tmpPath); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ioutil.ReadDir(slicePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != filepath.Base(tmpPath) {
+		t.Fatalf("expected only %v to be kept, got %v entries", tmpPath, len(entries))
+	}
+	if _, err := os.Stat(filepath.Join(tmpPath, "part")); err != nil {
+		t.Fatalf("expected partial download to be kept, %v", err)
+	}
+}
+
+func TestSnapshotTransferNotSupported(t *testing.T) {
+	for _, using := range []common.IndexType{common.ForestDB, common.PlasmaDB} {
+		inst := &common.IndexInst{InstId: 1, Defn: common.IndexDefn{Using: using}}
+		// rejected without contacting the source.
+		err := transferSnapshots("127.0.0.1:0", inst, "", nil)
+		if err != ErrSnapshotTransferNotSupported {
+			t.Errorf("expected %v for %v, received %v", ErrSnapshotTransferNotSupported, using, err)
+		}
+	}
+	if !isSnapshotTransferSupported(&common.IndexDefn{Using: common.MemDB}) {
+		t.Errorf("expected snapshot transfer for memdb")
+	}
+}
+
+func TestSnapshotTransferDelta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snaptransfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := memdb.New()
+	defer db.Close()
+	w := db.NewWriter()
+
+	//full snapshot followed by a delta which deletes and inserts items
+	src := filepath.Join(dir, "src")
+	for i := 0; i < 100; i++ {
+		w.Put([]byte(fmt.Sprintf("item-%03d", i)))
+	}
+	base, _ := db.NewSnapshot()
+	base.Open()
+	if err := db.StoreToDisk(filepath.Join(src, "snapshot.1"), base, 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		w.Delete([]byte(fmt.Sprintf("item-%03d", i)))
+		w.Put([]byte(fmt.Sprintf("item-%03d", 100+i)))
+	}
+	snap, _ := db.NewSnapshot()
+	snap.Open()
+	if err := db.StoreDeltaToDisk(filepath.Join(src, "snapshot.2"), "snapshot.1",
+		base, snap, 2, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	base.Close()
+	defer snap.Close()
+
+	//delta is staged with the full snapshot it depends on
+	chain, err := memdb.SnapshotChain(filepath.Join(src, "snapshot.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged := filepath.Join(dir, snapTransferDir, "1_0_snapshot.2")
+	files, dirs, err := linkSnapshotChain(chain, staged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dirs, []string{"snapshot.1", "snapshot.2"}) {
+		t.Fatalf("unexpected chain %v", dirs)
+	}
+	if !validSnapshotChain(dirs, "snapshot.2") || validSnapshotChain(dirs, "snapshot.1") ||
+		validSnapshotChain([]string{"../snapshot.1", "snapshot.2"}, "snapshot.2") {
+		t.Fatalf("unexpected chain validation")
+	}
+
+	//copy staged files into slice path of destination, as downloaded
+	slicePath := filepath.Join(dir, "dst")
+	os.RemoveAll(src)
+	for _, f := range files {
+		if !validSnapshotFileName(f.Name) {
+			t.Fatalf("invalid file %v", f.Name)
+		}
+		bs, err := ioutil.ReadFile(filepath.Join(staged, filepath.FromSlash(f.Name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(slicePath, filepath.FromSlash(f.Name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, bs, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db2 := memdb.New()
+	defer db2.Close()
+	restored, err := db2.LoadFromDisk(filepath.Join(slicePath, "snapshot.2"), 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	var items []string
+	itr := restored.NewIterator()
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		items = append(items, string(itr.Get()))
+	}
+	itr.Close()
+	if len(items) != 100 || items[0] != "item-050" || items[99] != "item-149" {
+		t.Fatalf("unexpected items restored %v", items)
+	}
+}
+
+// testTransferAuth authenticates users by name, with the permissions
+// granted to each user.
+type testTransferAuth struct {
+	users map[string][]string
+}
+
+func (a *testTransferAuth) GetHTTPServiceAuth(hostport string) (string, string, error) {
+	return "", "", nil
+}
+
+func (a *testTransferAuth) GetMemcachedServiceAuth(hostport string) (string, string, error) {
+	return "", "", nil
+}
+
+func (a *testTransferAuth) AuthWebCreds(r *http.Request) (cbauth.Creds, error) {
+	user, _, ok := r.BasicAuth()
+	if _, known := a.users[user]; !ok || !known {
+		return nil, cbauthimpl.ErrNoAuth
+	}
+	return &testTransferCreds{permissions: a.users[user]}, nil
+}
+
+type testTransferCreds struct {
+	cbauth.Creds
+	permissions []string
+}
+
+func (c *testTransferCreds) IsAllowed(permission string) (bool, error) {
+	for _, p := range c.permissions {
+		if p == permission {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestSnapshotTransferAuth(t *testing.T) {
+	common.SetServiceAuth(&testTransferAuth{users: map[string][]string{
+		"indexer": {snapTransferPermission},
+		"reader":  {"cluster.bucket[default].n1ql.index!create"},
+	}})
+
+	dir, err := ioutil.TempDir("", "transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stage := filepath.Join(dir, "1_0_snapshot.1")
+	if err := os.MkdirAll(filepath.Join(stage, "snapshot.1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tr := &snapshotTransfer{
+		stages: map[string]*snapshotStage{"1_0_snapshot.1": {path: stage}},
+	}
+
+	request := func(handler http.HandlerFunc, url, user string) int {
+		r := httptest.NewRequest("GET", url, nil)
+		if user != "" {
+			r.SetBasicAuth(user, "password")
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	// endpoints are restricted to indexers, even for users allowed to
+	// create indexes on the bucket.
+	handlers := map[string]http.HandlerFunc{
+		"/snapshotTransfer/manifest?instId=1&partnId=0":                       tr.handleManifest,
+		"/snapshotTransfer/file?snapshot=1_0_snapshot.1&file=snapshot.1/data": tr.handleFile,
+		"/snapshotTransfer/release?snapshot=1_0_snapshot.1":                   tr.handleRelease,
+	}
+	for url, handler := range handlers {
+		for _, user := range []string{"", "unknown", "reader"} {
+			if code := request(handler, url, user); code != http.StatusUnauthorized {
+				t.Errorf("expected %v for %q on %v, received %v",
+					http.StatusUnauthorized, user, url, code)
+			}
+		}
+	}
+	if _, err := os.Stat(stage); err != nil || len(tr.stages) != 1 {
+		t.Fatalf("expected staged snapshot to be retained %v", err)
+	}
+
+	code := request(tr.handleRelease, "/snapshotTransfer/release?snapshot=1_0_snapshot.1", "indexer")
+	if code != http.StatusOK {
+		t.Fatalf("expected %v, received %v", http.StatusOK, code)
+	} else if _, err := os.Stat(stage); !os.IsNotExist(err) || len(tr.stages) != 0 {
+		t.Fatalf("expected staged snapshot to be removed %v", err)
+	}
+}
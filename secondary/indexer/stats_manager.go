@@ -43,6 +43,8 @@ type BucketStats struct {
 
 	tsQueueSize   stats.Int64Val
 	numNonAlignTS stats.Int64Val
+
+	numMissingStreamBegin stats.Int64Val
 }
 
 func (s *BucketStats) Init() {
@@ -51,6 +53,7 @@ func (s *BucketStats) Init() {
 	s.numMutationsQueued.Init()
 	s.tsQueueSize.Init()
 	s.numNonAlignTS.Init()
+	s.numMissingStreamBegin.Init()
 }
 
 type IndexTimingStats struct {
@@ -133,6 +136,7 @@ type IndexStats struct {
 	diskSnapLoadDuration  stats.Int64Val
 	notReadyError         stats.Int64Val
 	clientCancelError     stats.Int64Val
+	scanRejectedError     stats.Int64Val
 	avgScanRate           stats.Int64Val
 	avgMutationRate       stats.Int64Val
 	avgDrainRate          stats.Int64Val
@@ -146,6 +150,13 @@ type IndexStats struct {
 	residentPercent       stats.Int64Val
 	cacheHitPercent       stats.Int64Val
 
+	docidFilterLookups        stats.Int64Val
+	docidFilterSkips          stats.Int64Val
+	docidFilterFalsePositives stats.Int64Val
+
+	numPinnedSnapshots   stats.Int64Val
+	pinnedSnapshotMemory stats.Int64Val
+
 	Timings IndexTimingStats
 }
 
@@ -200,6 +211,7 @@ func (s *IndexStats) Init() {
 	s.diskSnapStoreDuration.Init()
 	s.diskSnapLoadDuration.Init()
 	s.notReadyError.Init()
+	s.scanRejectedError.Init()
 	s.clientCancelError.Init()
 	s.avgScanRate.Init()
 	s.avgMutationRate.Init()
@@ -213,6 +225,11 @@ func (s *IndexStats) Init() {
 	s.progressStatTime.Init()
 	s.residentPercent.Init()
 	s.cacheHitPercent.Init()
+	s.docidFilterLookups.Init()
+	s.docidFilterSkips.Init()
+	s.docidFilterFalsePositives.Init()
+	s.numPinnedSnapshots.Init()
+	s.pinnedSnapshotMemory.Init()
 
 	s.Timings.Init()
 }
@@ -221,14 +238,20 @@ type IndexerStats struct {
 	indexes map[common.IndexInstId]*IndexStats
 	buckets map[string]*BucketStats
 
-	numConnections    stats.Int64Val
-	memoryQuota       stats.Int64Val
-	memoryUsed        stats.Int64Val
-	memoryUsedStorage stats.Int64Val
-	memoryUsedQueue   stats.Int64Val
-	needsRestart      stats.BoolVal
-	statsResponse     stats.TimingStat
-	notFoundError     stats.Int64Val
+	numConnections         stats.Int64Val
+	queryportSentRawBytes  stats.Int64Val
+	queryportSentCompBytes stats.Int64Val
+	queryportRcvdRawBytes  stats.Int64Val
+	queryportRcvdCompBytes stats.Int64Val
+	memoryQuota            stats.Int64Val
+	memoryUsed             stats.Int64Val
+	memoryUsedStorage      stats.Int64Val
+	memoryUsedQueue        stats.Int64Val
+	needsRestart           stats.BoolVal
+	statsResponse          stats.TimingStat
+	notFoundError          stats.Int64Val
+	buildThrottlePct       stats.Int64Val
+	pinnedSnapMemory       stats.Int64Val
 
 	indexerState stats.Int64Val
 }
@@ -237,6 +260,10 @@ func (s *IndexerStats) Init() {
 	s.indexes = make(map[common.IndexInstId]*IndexStats)
 	s.buckets = make(map[string]*BucketStats)
 	s.numConnections.Init()
+	s.queryportSentRawBytes.Init()
+	s.queryportSentCompBytes.Init()
+	s.queryportRcvdRawBytes.Init()
+	s.queryportRcvdCompBytes.Init()
 	s.memoryQuota.Init()
 	s.memoryUsed.Init()
 	s.memoryUsedStorage.Init()
@@ -245,6 +272,9 @@ func (s *IndexerStats) Init() {
 	s.statsResponse.Init()
 	s.indexerState.Init()
 	s.notFoundError.Init()
+	s.buildThrottlePct.Init()
+	s.buildThrottlePct.Set(buildThrottleMaxPct)
+	s.pinnedSnapMemory.Init()
 }
 
 func (s *IndexerStats) Reset() {
@@ -296,6 +326,10 @@ func (is IndexerStats) GetStats() common.Statistics {
 
 	addStat("uptime", fmt.Sprintf("%s", time.Since(uptime)))
 	addStat("num_connections", is.numConnections.Value())
+	addStat("queryport_sent_raw_bytes", is.queryportSentRawBytes.Value())
+	addStat("queryport_sent_compressed_bytes", is.queryportSentCompBytes.Value())
+	addStat("queryport_rcvd_raw_bytes", is.queryportRcvdRawBytes.Value())
+	addStat("queryport_rcvd_compressed_bytes", is.queryportRcvdCompBytes.Value())
 	addStat("index_not_found_errcount", is.notFoundError.Value())
 	addStat("memory_quota", is.memoryQuota.Value())
 	addStat("memory_used", is.memoryUsed.Value())
@@ -306,6 +340,8 @@ func (is IndexerStats) GetStats() common.Statistics {
 	addStat("storage_mode", storageMode)
 	addStat("num_cpu_core", num_cpu_core)
 	addStat("cpu_utilization", getCpuPercent())
+	addStat("build_throttle_percent", is.buildThrottlePct.Value())
+	addStat("pinned_snapshot_memory", is.pinnedSnapMemory.Value())
 
 	indexerState := common.IndexerState(is.indexerState.Value())
 	if indexerState == common.INDEXER_PREPARE_UNPAUSE {
@@ -376,6 +412,7 @@ func (is IndexerStats) GetStats() common.Statistics {
 		addStat("disk_load_duration", s.diskSnapLoadDuration.Value())
 		addStat("not_ready_errcount", s.notReadyError.Value())
 		addStat("client_cancel_errcount", s.clientCancelError.Value())
+		addStat("scan_rejected_errcount", s.scanRejectedError.Value())
 		addStat("avg_scan_rate", s.avgScanRate.Value())
 		addStat("avg_mutation_rate", s.avgMutationRate.Value())
 		addStat("avg_drain_rate", s.avgDrainRate.Value())
@@ -383,6 +420,11 @@ func (is IndexerStats) GetStats() common.Statistics {
 		addStat("progress_stat_time", s.progressStatTime.Value())
 		addStat("resident_percent", s.residentPercent.Value())
 		addStat("cache_hit_percent", s.cacheHitPercent.Value())
+		addStat("docid_filter_lookups", s.docidFilterLookups.Value())
+		addStat("docid_filter_skips", s.docidFilterSkips.Value())
+		addStat("docid_filter_false_positives", s.docidFilterFalsePositives.Value())
+		addStat("num_pinned_snapshots", s.numPinnedSnapshots.Value())
+		addStat("pinned_snapshot_memory", s.pinnedSnapshotMemory.Value())
 
 		addStat("timings/dcp_getseqs", s.Timings.dcpSeqs.Value())
 		addStat("timings/storage_clone_handle", s.Timings.stCloneHandle.Value())
@@ -408,6 +450,7 @@ func (is IndexerStats) GetStats() common.Statistics {
 		addStat("num_mutations_queued", s.numMutationsQueued.Value())
 		addStat("ts_queue_size", s.tsQueueSize.Value())
 		addStat("num_nonalign_ts", s.numNonAlignTS.Value())
+		addStat("num_missing_stream_begin", s.numMissingStreamBegin.Value())
 		if st := common.BucketSeqsTiming(s.bucket); st != nil {
 			addStat("timings/dcp_getseqs", st.Value())
 		}
@@ -420,6 +463,172 @@ func (is IndexerStats) MarshalJSON() ([]byte, error) {
 	return json.Marshal(is.GetStats())
 }
 
+// indexMetrics are the per index statistics exported in prometheus format,
+// counters are the statistics that only increase until stats are reset.
+var indexMetrics = []struct {
+	name    string
+	counter bool
+	value   func(s *IndexStats) int64
+}{
+	{"total_scan_duration", true, func(s *IndexStats) int64 { return s.scanDuration.Value() }},
+	{"total_scan_request_duration", true, func(s *IndexStats) int64 { return s.scanReqDuration.Value() }},
+	{"scan_wait_duration", true, func(s *IndexStats) int64 { return s.scanWaitDuration.Value() }},
+	{"insert_bytes", true, func(s *IndexStats) int64 { return s.insertBytes.Value() }},
+	{"delete_bytes", true, func(s *IndexStats) int64 { return s.deleteBytes.Value() }},
+	{"get_bytes", true, func(s *IndexStats) int64 { return s.getBytes.Value() }},
+	{"scan_bytes_read", true, func(s *IndexStats) int64 { return s.scanBytesRead.Value() }},
+	{"num_docs_indexed", true, func(s *IndexStats) int64 { return s.numDocsIndexed.Value() }},
+	{"num_docs_processed", true, func(s *IndexStats) int64 { return s.numDocsProcessed.Value() }},
+	{"num_requests", true, func(s *IndexStats) int64 { return s.numRequests.Value() }},
+	{"num_completed_requests", true, func(s *IndexStats) int64 { return s.numCompletedRequests.Value() }},
+	{"num_rows_returned", true, func(s *IndexStats) int64 { return s.numRowsReturned.Value() }},
+	{"num_commits", true, func(s *IndexStats) int64 { return s.numCommits.Value() }},
+	{"num_snapshots", true, func(s *IndexStats) int64 { return s.numSnapshots.Value() }},
+	{"num_compactions", true, func(s *IndexStats) int64 { return s.numCompactions.Value() }},
+	{"num_items_flushed", true, func(s *IndexStats) int64 { return s.numItemsFlushed.Value() }},
+	{"num_flush_queued", true, func(s *IndexStats) int64 { return s.numDocsFlushQueued.Value() }},
+	{"num_items_restored", true, func(s *IndexStats) int64 { return s.numItemsRestored.Value() }},
+	{"not_ready_errcount", true, func(s *IndexStats) int64 { return s.notReadyError.Value() }},
+	{"client_cancel_errcount", true, func(s *IndexStats) int64 { return s.clientCancelError.Value() }},
+	{"scan_rejected_errcount", true, func(s *IndexStats) int64 { return s.scanRejectedError.Value() }},
+	{"docid_filter_lookups", true, func(s *IndexStats) int64 { return s.docidFilterLookups.Value() }},
+	{"docid_filter_skips", true, func(s *IndexStats) int64 { return s.docidFilterSkips.Value() }},
+	{"docid_filter_false_positives", true, func(s *IndexStats) int64 { return s.docidFilterFalsePositives.Value() }},
+	{"num_docs_pending", false, func(s *IndexStats) int64 { return s.numDocsPending.Value() }},
+	{"num_docs_queued", false, func(s *IndexStats) int64 { return s.numDocsQueued.Value() }},
+	{"flush_queue_size", false, func(s *IndexStats) int64 {
+		return postiveNum(s.numDocsFlushQueued.Value() - s.numDocsIndexed.Value())
+	}},
+	{"items_count", false, func(s *IndexStats) int64 { return s.itemsCount.Value() }},
+	{"disk_size", false, func(s *IndexStats) int64 { return s.diskSize.Value() }},
+	{"data_size", false, func(s *IndexStats) int64 { return s.dataSize.Value() }},
+	{"frag_percent", false, func(s *IndexStats) int64 { return s.fragPercent.Value() }},
+	{"build_progress", false, func(s *IndexStats) int64 { return s.buildProgress.Value() }},
+	{"completion_progress", false, func(s *IndexStats) int64 { return s.completionProgress.Value() }},
+	{"avg_ts_interval", false, func(s *IndexStats) int64 { return s.avgTsInterval.Value() }},
+	{"avg_ts_items_count", false, func(s *IndexStats) int64 { return s.avgTsItemsCount.Value() }},
+	{"since_last_snapshot", false, func(s *IndexStats) int64 { return s.sinceLastSnapshot.Value() }},
+	{"num_snapshot_waiters", false, func(s *IndexStats) int64 { return s.numSnapshotWaiters.Value() }},
+	{"num_last_snapshot_reply", false, func(s *IndexStats) int64 { return s.numLastSnapshotReply.Value() }},
+	{"disk_store_duration", false, func(s *IndexStats) int64 { return s.diskSnapStoreDuration.Value() }},
+	{"disk_load_duration", false, func(s *IndexStats) int64 { return s.diskSnapLoadDuration.Value() }},
+	{"avg_scan_rate", false, func(s *IndexStats) int64 { return s.avgScanRate.Value() }},
+	{"avg_mutation_rate", false, func(s *IndexStats) int64 { return s.avgMutationRate.Value() }},
+	{"avg_drain_rate", false, func(s *IndexStats) int64 { return s.avgDrainRate.Value() }},
+	{"resident_percent", false, func(s *IndexStats) int64 { return s.residentPercent.Value() }},
+	{"cache_hit_percent", false, func(s *IndexStats) int64 { return s.cacheHitPercent.Value() }},
+	{"num_pinned_snapshots", false, func(s *IndexStats) int64 { return s.numPinnedSnapshots.Value() }},
+	{"pinned_snapshot_memory", false, func(s *IndexStats) int64 { return s.pinnedSnapshotMemory.Value() }},
+}
+
+// WritePrometheus adds indexer, index and bucket statistics to `p`.
+// Index statistics are labelled with bucket, index and replica, bucket
+// statistics with bucket.
+func (is IndexerStats) WritePrometheus(p *stats.PromWriter) {
+	p.Gauge("indexer_uptime_seconds", time.Since(uptime).Seconds())
+	p.Gauge("indexer_num_connections", float64(is.numConnections.Value()))
+	p.Counter("indexer_queryport_sent_raw_bytes", float64(is.queryportSentRawBytes.Value()))
+	p.Counter("indexer_queryport_sent_compressed_bytes", float64(is.queryportSentCompBytes.Value()))
+	p.Counter("indexer_queryport_rcvd_raw_bytes", float64(is.queryportRcvdRawBytes.Value()))
+	p.Counter("indexer_queryport_rcvd_compressed_bytes", float64(is.queryportRcvdCompBytes.Value()))
+	p.Counter("indexer_index_not_found_errcount", float64(is.notFoundError.Value()))
+	p.Gauge("indexer_memory_quota", float64(is.memoryQuota.Value()))
+	p.Gauge("indexer_memory_used", float64(is.memoryUsed.Value()))
+	p.Gauge("indexer_memory_used_storage", float64(is.memoryUsedStorage.Value()))
+	p.Gauge("indexer_memory_used_queue", float64(is.memoryUsedQueue.Value()))
+	var needsRestart float64
+	if is.needsRestart.Value() {
+		needsRestart = 1
+	}
+	p.Gauge("indexer_needs_restart", needsRestart)
+	p.Gauge("indexer_num_cpu_core", float64(num_cpu_core))
+	p.Gauge("indexer_cpu_utilization", getCpuPercent())
+	p.Gauge("indexer_pinned_snapshot_memory", float64(is.pinnedSnapMemory.Value()))
+
+	indexerState := common.IndexerState(is.indexerState.Value())
+	if indexerState == common.INDEXER_PREPARE_UNPAUSE {
+		indexerState = common.INDEXER_PAUSED
+	}
+	p.Gauge("indexer_state", 1, "state", fmt.Sprintf("%s", indexerState))
+	p.Gauge("indexer_storage_mode", 1, "mode", fmt.Sprintf("%s", common.GetStorageMode()))
+	p.Timing("indexer_stats_response_seconds", &is.statsResponse)
+
+	for _, s := range is.indexes {
+		labels := []string{
+			"bucket", s.bucket, "index", s.name,
+			"replica", fmt.Sprintf("%d", s.replicaId),
+		}
+		for _, m := range indexMetrics {
+			if m.counter {
+				p.Counter("index_"+m.name, float64(m.value(s)), labels...)
+			} else {
+				p.Gauge("index_"+m.name, float64(m.value(s)), labels...)
+			}
+		}
+
+		timings := []struct {
+			name string
+			st   *stats.TimingStat
+		}{
+			{"dcp_getseqs", &s.Timings.dcpSeqs},
+			{"storage_clone_handle", &s.Timings.stCloneHandle},
+			{"storage_commit", &s.Timings.stCommit},
+			{"storage_new_iterator", &s.Timings.stNewIterator},
+			{"storage_snapshot_create", &s.Timings.stSnapshotCreate},
+			{"storage_snapshot_close", &s.Timings.stSnapshotClose},
+			{"storage_persist_snapshot_create", &s.Timings.stPersistSnapshotCreate},
+			{"storage_get", &s.Timings.stKVGet},
+			{"storage_set", &s.Timings.stKVSet},
+			{"storage_iterator_next", &s.Timings.stIteratorNext},
+			{"scan_pipeline_iterate", &s.Timings.stScanPipelineIterate},
+			{"storage_del", &s.Timings.stKVDelete},
+			{"storage_info", &s.Timings.stKVInfo},
+			{"storage_meta_get", &s.Timings.stKVMetaGet},
+			{"storage_meta_set", &s.Timings.stKVMetaSet},
+		}
+		for _, t := range timings {
+			p.Timing("index_timings_"+t.name+"_seconds", t.st, labels...)
+		}
+	}
+
+	for _, s := range is.buckets {
+		p.Counter("index_bucket_num_rollbacks", float64(s.numRollbacks.Value()), "bucket", s.bucket)
+		p.Gauge("index_bucket_mutation_queue_size", float64(s.mutationQueueSize.Value()), "bucket", s.bucket)
+		p.Counter("index_bucket_num_mutations_queued", float64(s.numMutationsQueued.Value()), "bucket", s.bucket)
+		p.Gauge("index_bucket_ts_queue_size", float64(s.tsQueueSize.Value()), "bucket", s.bucket)
+		p.Counter("index_bucket_num_nonalign_ts", float64(s.numNonAlignTS.Value()), "bucket", s.bucket)
+		p.Counter("index_bucket_num_missing_stream_begin", float64(s.numMissingStreamBegin.Value()), "bucket", s.bucket)
+		if st := common.BucketSeqsTiming(s.bucket); st != nil {
+			p.Timing("index_bucket_timings_dcp_getseqs_seconds", st, "bucket", s.bucket)
+		}
+	}
+}
+
+// writePartitionMetrics adds storage statistics of each index partition
+// to `p`, labelled with bucket, index, replica and partition.
+func writePartitionMetrics(
+	p *stats.PromWriter, is *IndexerStats, storageStats []IndexStorageStats) {
+
+	for _, st := range storageStats {
+		replicaId := 0
+		if s, ok := is.indexes[st.InstId]; ok {
+			replicaId = s.replicaId
+		}
+		for partnId, ps := range st.Partitions {
+			labels := []string{
+				"bucket", st.Bucket, "index", st.Name,
+				"replica", fmt.Sprintf("%d", replicaId),
+				"partition", fmt.Sprintf("%d", partnId),
+			}
+			p.Gauge("index_partition_data_size", float64(ps.DataSize), labels...)
+			p.Gauge("index_partition_disk_size", float64(ps.DiskSize), labels...)
+			p.Counter("index_partition_get_bytes", float64(ps.GetBytes), labels...)
+			p.Counter("index_partition_insert_bytes", float64(ps.InsertBytes), labels...)
+			p.Counter("index_partition_delete_bytes", float64(ps.DeleteBytes), labels...)
+		}
+	}
+}
+
 func (s IndexerStats) Clone() *IndexerStats {
 	var clone IndexerStats
 	clone = s
@@ -468,6 +677,7 @@ func NewStatsManager(supvCmdch MsgChannel,
 	http.HandleFunc("/stats/storage/mm", s.handleStorageMMStatsReq)
 	http.HandleFunc("/stats/storage", s.handleStorageStatsReq)
 	http.HandleFunc("/stats/reset", s.handleStatsResetReq)
+	http.HandleFunc("/metrics", s.handleMetricsReq)
 	go s.run()
 	go s.runStatsDumpLogger()
 	StartCpuCollector()
@@ -546,6 +756,34 @@ func (s *statsManager) handleStatsReq(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleMetricsReq serves indexer statistics in prometheus text format.
+func (s *statsManager) handleMetricsReq(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" || r.Method == "GET" {
+		is := s.stats.Get()
+		p := stats.NewPromWriter()
+
+		t0 := time.Now()
+		if common.IndexerState(is.indexerState.Value()) != common.INDEXER_BOOTSTRAP {
+			s.tryUpdateStats(false)
+			writePartitionMetrics(p, is, s.getIndexStorageStats())
+		}
+		is.WritePrometheus(p)
+		w.Header().Set("Content-Type", stats.PromContentType)
+		w.WriteHeader(200)
+		p.WriteTo(w)
+		is.statsResponse.Put(time.Since(t0))
+	} else {
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported method"))
+	}
+}
+
+func (s *statsManager) getIndexStorageStats() []IndexStorageStats {
+	replych := make(chan []IndexStorageStats)
+	s.supvMsgch <- &MsgIndexStorageStats{respch: replych}
+	return <-replych
+}
+
 func (s *statsManager) handleMemStatsReq(w http.ResponseWriter, r *http.Request) {
 	stats := new(runtime.MemStats)
 	if r.Method == "POST" || r.Method == "GET" {
@@ -561,10 +799,7 @@ func (s *statsManager) handleMemStatsReq(w http.ResponseWriter, r *http.Request)
 
 func (s *statsManager) getStorageStats() string {
 	var result string
-	replych := make(chan []IndexStorageStats)
-	statReq := &MsgIndexStorageStats{respch: replych}
-	s.supvMsgch <- statReq
-	res := <-replych
+	res := s.getIndexStorageStats()
 
 	result += "[\n"
 	for i, sts := range res {
@@ -187,6 +187,9 @@ func (s *storageMgr) handleSupvervisorCommands(cmd Message) {
 
 	case STORAGE_STATS:
 		s.handleStats(cmd)
+
+	case STORAGE_OPEN_SNAPSHOTS:
+		s.handleOpenSnapshots(cmd)
 	}
 }
 
@@ -686,6 +689,31 @@ func (s *storageMgr) handleUpdateIndexInstMap(cmd Message) {
 	s.supvCmdch <- &MsgSuccess{}
 }
 
+//handleOpenSnapshots opens the latest persisted snapshots of indexes,
+//whose snapshots were copied from a peer indexer during rebalance.
+//Indexes that already have a snapshot open are skipped.
+func (s *storageMgr) handleOpenSnapshots(cmd Message) {
+
+	instIds := cmd.(*MsgOpenSnapshots).GetInstIds()
+
+	indexPartnMap := make(IndexPartnMap)
+	s.muSnap.Lock()
+	for _, instId := range instIds {
+		if is, ok := s.indexSnapMap[instId]; ok && !is.IsEpoch() {
+			continue
+		}
+		if partnMap, ok := s.indexPartnMap[instId]; ok {
+			indexPartnMap[instId] = partnMap
+		}
+	}
+	s.muSnap.Unlock()
+
+	logging.Infof("StorageMgr::handleOpenSnapshots Opening snapshots of %v", instIds)
+	s.updateIndexSnapMap(indexPartnMap, common.ALL_STREAMS, "")
+
+	s.supvCmdch <- &MsgSuccess{}
+}
+
 func (s *storageMgr) handleUpdateIndexPartnMap(cmd Message) {
 
 	logging.Tracef("StorageMgr::handleUpdateIndexPartnMap %v", cmd)
@@ -817,8 +845,9 @@ func (s *storageMgr) getIndexStorageStats() []IndexStorageStats {
 		var getBytes, insertBytes, deleteBytes int64
 		var nslices int64
 		var needUpgrade = false
+		partnStats := make(map[common.PartitionId]StorageStatistics)
 	loop:
-		for _, partnInst := range partnMap {
+		for partnId, partnInst := range partnMap {
 			slices := partnInst.Sc.GetAllSlices()
 			nslices += int64(len(slices))
 			for _, slice := range slices {
@@ -827,6 +856,15 @@ func (s *storageMgr) getIndexStorageStats() []IndexStorageStats {
 					break loop
 				}
 
+				ps := partnStats[partnId]
+				ps.DataSize += sts.DataSize
+				ps.DiskSize += sts.DiskSize
+				ps.GetBytes += sts.GetBytes
+				ps.InsertBytes += sts.InsertBytes
+				ps.DeleteBytes += sts.DeleteBytes
+				ps.ExtraSnapDataSize += sts.ExtraSnapDataSize
+				partnStats[partnId] = ps
+
 				dataSz += sts.DataSize
 				diskSz += sts.DiskSize
 				getBytes += sts.GetBytes
@@ -854,6 +892,7 @@ func (s *storageMgr) getIndexStorageStats() []IndexStorageStats {
 					NeedUpgrade:       needUpgrade,
 					InternalData:      internalData,
 				},
+				Partitions: partnStats,
 			}
 
 			stats = append(stats, stat)
@@ -908,7 +947,7 @@ func (s *storageMgr) handleIndexCompaction(cmd Message) {
 // This function should be called only during initialization
 // of storage manager and during rollback.
 // FIXME: Current implementation makes major assumption that
-// single slice is supported.
+// single slice per partition is supported.
 func (s *storageMgr) updateIndexSnapMap(indexPartnMap IndexPartnMap,
 	streamId common.StreamId, bucket string) {
 
@@ -931,28 +970,39 @@ func (s *storageMgr) updateIndexSnapMap(indexPartnMap IndexPartnMap,
 			}
 		}
 
-		//there is only one partition for now
-		partnInst := partnMap[0]
-		sc := partnInst.Sc
-
-		//there is only one slice for now
-		slice := sc.GetSliceById(0)
-		infos, err := slice.GetSnapshots()
-		// TODO: Proper error handling if possible
-		if err != nil {
-			panic("Unable to read snapinfo -" + err.Error())
-		}
-
 		DestroyIndexSnapshot(s.indexSnapMap[idxInstId])
 		delete(s.indexSnapMap, idxInstId)
 		s.notifySnapshotDeletion(idxInstId)
 
-		snapInfoContainer := NewSnapshotInfoContainer(infos)
-		latestSnapshotInfo := snapInfoContainer.GetLatest()
+		//open latest snapshot of every partition, index snapshot is
+		//available only if all the partitions have one.
+		tsVbuuid = nil
+		partnSnaps := make(map[common.PartitionId]PartitionSnapshot)
+		for pid, partnInst := range partnMap {
+			sc := partnInst.Sc
+
+			//there is only one slice for now
+			slice := sc.GetSliceById(0)
+			infos, err := slice.GetSnapshots()
+			// TODO: Proper error handling if possible
+			if err != nil {
+				panic("Unable to read snapinfo -" + err.Error())
+			}
+
+			snapInfoContainer := NewSnapshotInfoContainer(infos)
+			latestSnapshotInfo := snapInfoContainer.GetLatest()
+			if latestSnapshotInfo == nil {
+				for _, ps := range partnSnaps {
+					for _, ss := range ps.Slices() {
+						ss.Snapshot().Close()
+					}
+				}
+				partnSnaps = nil
+				break
+			}
 
-		if latestSnapshotInfo != nil {
-			logging.Infof("StorageMgr::updateIndexSnapMap IndexInst:%v Attempting to open snapshot (%v)",
-				idxInstId, latestSnapshotInfo)
+			logging.Infof("StorageMgr::updateIndexSnapMap IndexInst:%v Partition:%v "+
+				"Attempting to open snapshot (%v)", idxInstId, pid, latestSnapshotInfo)
 			latestSnapshot, err := slice.OpenSnapshot(latestSnapshotInfo)
 			if err != nil {
 				panic("Unable to open snapshot -" + err.Error())
@@ -962,20 +1012,24 @@ func (s *storageMgr) updateIndexSnapMap(indexPartnMap IndexPartnMap,
 				snap: latestSnapshot,
 			}
 
-			tsVbuuid = latestSnapshotInfo.Timestamp()
-
-			sid := SliceId(0)
-			pid := common.PartitionId(0)
+			//partitions are committed one after the other, use the
+			//oldest timestamp so that no mutation is missed on restart.
+			partnTs := latestSnapshotInfo.Timestamp()
+			if tsVbuuid == nil || tsVbuuid.AsRecent(partnTs) {
+				tsVbuuid = partnTs
+			}
 
-			ps := &partitionSnapshot{
+			partnSnaps[pid] = &partitionSnapshot{
 				id:     pid,
-				slices: map[SliceId]SliceSnapshot{sid: ss},
+				slices: map[SliceId]SliceSnapshot{SliceId(0): ss},
 			}
+		}
 
+		if len(partnSnaps) > 0 {
 			is := &indexSnapshot{
 				instId: idxInstId,
 				ts:     tsVbuuid,
-				partns: map[common.PartitionId]PartitionSnapshot{pid: ps},
+				partns: partnSnaps,
 			}
 			s.indexSnapMap[idxInstId] = is
 			s.notifySnapshotCreation(is)
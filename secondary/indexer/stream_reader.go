@@ -577,6 +577,12 @@ func (w *streamWorker) handleSingleKeyVersion(bucket string, vbucket Vbucket, vb
 			mut := NewMutation()
 			mut.uuid = common.IndexInstId(kv.GetUuids()[i])
 			mut.key = append(mut.key, kv.GetKeys()[i]...)
+			if partnkeys := kv.GetPartnkeys(); i < len(partnkeys) {
+				mut.partnkey = append(mut.partnkey, partnkeys[i]...)
+			}
+			if payloads := kv.GetPayloads(); i < len(payloads) {
+				mut.payload = append(mut.payload, payloads[i]...)
+			}
 			mut.command = byte(kv.GetCommands()[i])
 
 			mutk.mut = append(mutk.mut, mut)
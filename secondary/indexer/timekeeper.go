@@ -1205,6 +1205,11 @@ func (tk *timekeeper) repairMissingStreamBegin(streamId common.StreamId) {
 						"Raise ConnectionError stream %v bucket %v vblist %v",
 						streamId, bucket, vbList)
 
+					stats := tk.stats.Get()
+					if stat, ok := stats.buckets[bucket]; ok {
+						stat.numMissingStreamBegin.Add(int64(len(vbList)))
+					}
+
 					msg := &MsgStreamInfo{mType: STREAM_READER_CONN_ERROR,
 						streamId: streamId,
 						bucket:   bucket,
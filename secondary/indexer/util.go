@@ -17,6 +17,7 @@ import (
 
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/logging"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
 )
 
 const (
@@ -69,8 +70,34 @@ func GetLocalIP() (net.IP, error) {
 	return nil, errors.New("cannot find local IP address")
 }
 
-func IndexPath(inst *common.IndexInst, sliceId SliceId) string {
-	return fmt.Sprintf("%s_%s_%d_%d.index", inst.Defn.Bucket, inst.Defn.Name, inst.InstId, sliceId)
+func IndexPath(inst *common.IndexInst, partnId common.PartitionId, sliceId SliceId) string {
+	if partnId == 0 {
+		return fmt.Sprintf("%s_%s_%d_%d.index", inst.Defn.Bucket, inst.Defn.Name, inst.InstId, sliceId)
+	}
+	//partitions other than the first one are only created for
+	//hash or range partitioned index
+	return fmt.Sprintf("%s_%s_%d_%d_%d.index", inst.Defn.Bucket, inst.Defn.Name, inst.InstId, partnId, sliceId)
+}
+
+//NewLocalPartitionContainer returns the partition container for an
+//index, with the partitions it hosts on the given endpoint. Returns
+//error if split points of a range partitioned index cannot be collated,
+//entries of such an index cannot be placed in partitions.
+func NewLocalPartitionContainer(defn *common.IndexDefn,
+	endpt common.Endpoint) (common.PartitionContainer, error) {
+
+	if defn.PartitionScheme == common.RANGE && len(defn.PartitionSplits) > 0 {
+		splits, err := protobuf.EncodeRangeSplits(defn.PartitionSplits)
+		if err != nil {
+			logging.Errorf("NewLocalPartitionContainer: Invalid split points %v "+
+				"for index %v. Error %v", defn.PartitionSplits, defn.DefnId, err)
+			return nil, err
+		}
+		return common.NewRangePartitionContainer(splits,
+			defn.HostedPartitions(), endpt), nil
+	}
+	return common.NewHashPartitionContainer(defn.GetNumPartitions(),
+		defn.HostedPartitions(), endpt), nil
 }
 
 func GetCurrentKVTs(cluster, pooln, bucketn string, numVbs int) (Timestamp, error) {
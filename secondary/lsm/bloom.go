@@ -0,0 +1,78 @@
+package lsm
+
+// bloom filter over the keys of a segment, lets point lookups skip
+// segments that do not have the key.
+type bloom struct {
+	bits []byte
+	k    uint8
+}
+
+func newBloom(hashes []uint64, bitsPerKey int) *bloom {
+	if bitsPerKey <= 0 {
+		return nil
+	}
+	nbits := len(hashes) * bitsPerKey
+	if nbits < 64 {
+		nbits = 64
+	}
+	// k = bitsPerKey * ln(2)
+	k := uint8(float64(bitsPerKey) * 0.69)
+	if k < 1 {
+		k = 1
+	} else if k > 30 {
+		k = 30
+	}
+
+	b := &bloom{bits: make([]byte, (nbits+7)/8), k: k}
+	nbits = len(b.bits) * 8
+	for _, h := range hashes {
+		delta := h>>33 | h<<31
+		for i := uint8(0); i < k; i++ {
+			pos := h % uint64(nbits)
+			b.bits[pos/8] |= 1 << (pos % 8)
+			h += delta
+		}
+	}
+	return b
+}
+
+func (b *bloom) mayContain(h uint64) bool {
+	if b == nil || len(b.bits) == 0 {
+		return true
+	}
+	nbits := uint64(len(b.bits) * 8)
+	delta := h>>33 | h<<31
+	for i := uint8(0); i < b.k; i++ {
+		pos := h % nbits
+		if b.bits[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+func (b *bloom) encode(buf []byte) []byte {
+	if b == nil {
+		return buf
+	}
+	buf = append(buf, b.k)
+	return append(buf, b.bits...)
+}
+
+func decodeBloom(data []byte) *bloom {
+	if len(data) < 2 {
+		return nil
+	}
+	return &bloom{k: data[0], bits: data[1:]}
+}
+
+// hashKey is 64 bit FNV-1a hash of key.
+func hashKey(key []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range key {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
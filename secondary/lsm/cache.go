@@ -0,0 +1,137 @@
+package lsm
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// Block cache is shared by all stores in the process. Blocks are
+// immutable once read from a segment, so evicting a block never
+// invalidates slices handed out to readers.
+
+const defaultBlockCacheSize = 64 * 1024 * 1024
+
+var gCache = newBlockCache(defaultBlockCacheSize)
+
+// memInUse is memory used by memtables, in-memory runs and segment
+// indexes/filters of all stores.
+var memInUse int64
+
+type cacheKey struct {
+	seg uint64 // process wide segment id
+	off int64
+}
+
+type cacheEntry struct {
+	key cacheKey
+	blk *block
+}
+
+type blockCache struct {
+	mu       sync.Mutex
+	capacity int64
+	used     int64
+	lru      *list.List
+	items    map[cacheKey]*list.Element
+
+	hits   int64
+	misses int64
+}
+
+func newBlockCache(capacity int64) *blockCache {
+	return &blockCache{
+		capacity: capacity,
+		lru:      list.New(),
+		items:    make(map[cacheKey]*list.Element),
+	}
+}
+
+func (c *blockCache) get(key cacheKey) *block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.lru.MoveToFront(e)
+		c.hits++
+		return e.Value.(*cacheEntry).blk
+	}
+	c.misses++
+	return nil
+}
+
+func (c *blockCache) put(key cacheKey, blk *block) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.items[key]; ok {
+		return
+	}
+	c.items[key] = c.lru.PushFront(&cacheEntry{key: key, blk: blk})
+	c.used += blk.size
+	c.evict()
+}
+
+// drop all blocks of segment `seg`.
+func (c *blockCache) drop(seg uint64, offsets []int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, off := range offsets {
+		if e, ok := c.items[cacheKey{seg, off}]; ok {
+			c.remove(e)
+		}
+	}
+}
+
+func (c *blockCache) setCapacity(capacity int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.capacity = capacity
+	c.evict()
+}
+
+func (c *blockCache) evict() {
+	for c.used > c.capacity {
+		e := c.lru.Back()
+		if e == nil {
+			return
+		}
+		c.remove(e)
+	}
+}
+
+func (c *blockCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.used -= entry.blk.size
+}
+
+func (c *blockCache) size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used
+}
+
+// SetBlockCacheSize sets the capacity of block cache in bytes.
+func SetBlockCacheSize(sz int64) {
+	gCache.setCapacity(sz)
+}
+
+// BlockCacheUsed returns bytes used by the block cache.
+func BlockCacheUsed() int64 {
+	return gCache.size()
+}
+
+// BlockCacheStats returns hits and misses of the block cache.
+func BlockCacheStats() (hits, misses int64) {
+	gCache.mu.Lock()
+	defer gCache.mu.Unlock()
+	return gCache.hits, gCache.misses
+}
+
+// MemoryInUse returns memory used by all stores including the block cache.
+func MemoryInUse() int64 {
+	return atomic.LoadInt64(&memInUse) + BlockCacheUsed()
+}
@@ -0,0 +1,209 @@
+package lsm
+
+import (
+	"sync/atomic"
+)
+
+// kick wakes up the maintenance routine.
+func (s *Store) kick() {
+	select {
+	case s.kickch <- true:
+	default:
+	}
+}
+
+// maintain freezes a full memtable, flushes or merges in-memory runs and
+// merges segments when there are too many.
+func (s *Store) maintain() {
+	defer close(s.donech)
+
+	for {
+		select {
+		case <-s.stopch:
+			return
+		case <-s.kickch:
+		}
+
+		err := s.flushRuns()
+		if err == nil {
+			for {
+				var done bool
+				if done, err = s.compactStep(false); err != nil || done {
+					break
+				}
+				select {
+				case <-s.stopch:
+					return
+				default:
+				}
+			}
+		}
+
+		if err != nil {
+			s.mu.Lock()
+			s.bgErr = err
+			s.mu.Unlock()
+		}
+	}
+}
+
+// flushRuns merges in-memory runs if there are too many, and writes
+// them to a segment if they exceed memtable size.
+func (s *Store) flushRuns() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.mt.size >= s.cfg.MemtableSize {
+		s.freeze()
+	}
+	v := s.current
+	v.incRef()
+	s.mu.Unlock()
+	defer v.decRef()
+
+	var size int64
+	for _, run := range v.runs {
+		size += run.size
+	}
+
+	if size >= s.cfg.MemtableSize {
+		seg, err := s.writeSegment(versionSources(v.runs, nil), len(v.segs) == 0)
+		if err != nil {
+			return err
+		}
+		s.replaceRuns(v.runs, nil, seg)
+		if seg != nil {
+			seg.decRef()
+		}
+		atomic.AddInt64(&s.numFlushes, 1)
+
+	} else if len(v.runs) > s.cfg.MaxRuns {
+		run := &memRun{refs: 1}
+		it := newMergeIter(versionSources(v.runs, nil), len(v.segs) != 0)
+		for it.seekFirst(); it.valid(); it.next() {
+			e := *it.entry()
+			run.ents = append(run.ents, e)
+			run.size += int64(len(e.key)+len(e.val)) + entryOverhead
+		}
+		atomic.AddInt64(&memInUse, run.size)
+		s.replaceRuns(v.runs, run, nil)
+		run.decRef()
+	}
+	return nil
+}
+
+// replaceRuns replaces `runs` in the current version by `run` or `seg`.
+// Called with flushMu held, so `runs` are the oldest runs of the current
+// version.
+func (s *Store) replaceRuns(runs []*memRun, run *memRun, seg *segment) {
+	s.mu.Lock()
+	old := s.current
+	if old == nil || !isSuffix(old.runs, runs) {
+		s.mu.Unlock()
+		return
+	}
+
+	newRuns := append([]*memRun(nil), old.runs[:len(old.runs)-len(runs)]...)
+	if run != nil {
+		newRuns = append(newRuns, run)
+	}
+	segs := old.segs
+	if seg != nil {
+		segs = append([]*segment{seg}, segs...)
+	}
+	s.current = newVersion(newRuns, segs)
+	s.mu.Unlock()
+
+	old.decRef()
+}
+
+// compactStep merges adjacent segments chosen by pickSegments. Returns
+// true if there was nothing to compact.
+func (s *Store) compactStep(full bool) (bool, error) {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	s.mu.Lock()
+	v := s.current
+	if s.closed || v == nil {
+		s.mu.Unlock()
+		return true, nil
+	}
+	v.incRef()
+	s.mu.Unlock()
+	defer v.decRef()
+
+	i, j := pickSegments(v.segs, s.cfg.MaxSegments, full)
+	if i == j {
+		return true, nil
+	}
+
+	inputs := v.segs[i:j]
+	seg, err := s.writeSegment(versionSources(nil, inputs), j == len(v.segs))
+	if err != nil {
+		return false, err
+	}
+	if seg != nil {
+		defer seg.decRef()
+	}
+
+	s.mu.Lock()
+	old := s.current
+	pos := findSegments(old.segs, inputs)
+	if pos < 0 {
+		// segments were changed by rollback
+		s.mu.Unlock()
+		return false, nil
+	}
+	segs := append([]*segment(nil), old.segs[:pos]...)
+	if seg != nil {
+		segs = append(segs, seg)
+	}
+	segs = append(segs, old.segs[pos+len(inputs):]...)
+	s.current = newVersion(old.runs, segs)
+	s.mu.Unlock()
+
+	old.decRef()
+	atomic.AddInt64(&s.numCompacts, 1)
+	return false, nil
+}
+
+// pickSegments returns range [i, j) of adjacent segments to merge. Pair
+// with the smallest total size is merged when there are more than
+// `maxSegments` segments, or when `full` compaction is requested. Full
+// compaction also rewrites the last segment with deletes.
+func pickSegments(segs []*segment, maxSegments int, full bool) (int, int) {
+	if len(segs) > maxSegments || (full && len(segs) > 1) {
+		best, bestSize := 0, int64(-1)
+		for i := 0; i+1 < len(segs); i++ {
+			size := segs[i].size + segs[i+1].size
+			if bestSize < 0 || size < bestSize {
+				best, bestSize = i, size
+			}
+		}
+		return best, best + 2
+	}
+
+	if full && len(segs) == 1 && segs[0].deletes > 0 {
+		return 0, 1
+	}
+	return 0, 0
+}
+
+func findSegments(segs, sub []*segment) int {
+loop:
+	for i := 0; i+len(sub) <= len(segs); i++ {
+		for j, seg := range sub {
+			if segs[i+j] != seg {
+				continue loop
+			}
+		}
+		return i
+	}
+	return -1
+}
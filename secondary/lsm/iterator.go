@@ -0,0 +1,192 @@
+package lsm
+
+import (
+	"bytes"
+	"container/heap"
+)
+
+// source is an iterator over sorted entries with unique keys.
+type source interface {
+	seekFirst()
+	seek(key []byte)
+	valid() bool
+	entry() *entry
+	next()
+	err() error
+}
+
+// mergeIter merges sources ordered from newest to oldest. For a key
+// present in multiple sources, entry from the newest source is returned.
+type mergeIter struct {
+	srcs        []source
+	h           mergeHeap
+	cur         *entry
+	keepDeletes bool
+	e           error
+}
+
+type mergeHeap struct {
+	idx  []int
+	srcs []source
+}
+
+func (h *mergeHeap) Len() int { return len(h.idx) }
+
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.idx[i], h.idx[j]
+	if c := bytes.Compare(h.srcs[a].entry().key, h.srcs[b].entry().key); c != 0 {
+		return c < 0
+	}
+	return a < b
+}
+
+func (h *mergeHeap) Swap(i, j int)      { h.idx[i], h.idx[j] = h.idx[j], h.idx[i] }
+func (h *mergeHeap) Push(x interface{}) { h.idx = append(h.idx, x.(int)) }
+
+func (h *mergeHeap) Pop() interface{} {
+	n := len(h.idx)
+	x := h.idx[n-1]
+	h.idx = h.idx[:n-1]
+	return x
+}
+
+func newMergeIter(srcs []source, keepDeletes bool) *mergeIter {
+	return &mergeIter{
+		srcs:        srcs,
+		h:           mergeHeap{srcs: srcs},
+		keepDeletes: keepDeletes,
+	}
+}
+
+// sources of a version from newest to oldest.
+func versionSources(runs []*memRun, segs []*segment) []source {
+	srcs := make([]source, 0, len(runs)+len(segs))
+	for _, run := range runs {
+		srcs = append(srcs, &runIter{run: run})
+	}
+	for _, seg := range segs {
+		srcs = append(srcs, &segmentIter{seg: seg})
+	}
+	return srcs
+}
+
+func (m *mergeIter) seekFirst() {
+	for _, src := range m.srcs {
+		src.seekFirst()
+	}
+	m.init()
+}
+
+func (m *mergeIter) seek(key []byte) {
+	for _, src := range m.srcs {
+		src.seek(key)
+	}
+	m.init()
+}
+
+func (m *mergeIter) init() {
+	m.e = nil
+	m.h.idx = m.h.idx[:0]
+	for i, src := range m.srcs {
+		if src.valid() {
+			m.h.idx = append(m.h.idx, i)
+		} else if err := src.err(); err != nil {
+			m.e = err
+		}
+	}
+	heap.Init(&m.h)
+	m.next()
+}
+
+func (m *mergeIter) valid() bool {
+	return m.cur != nil
+}
+
+func (m *mergeIter) entry() *entry {
+	return m.cur
+}
+
+func (m *mergeIter) err() error {
+	return m.e
+}
+
+// next moves to the next visible entry.
+func (m *mergeIter) next() {
+	for {
+		m.cur = nil
+		if m.e != nil || m.h.Len() == 0 {
+			return
+		}
+
+		e := m.srcs[m.h.idx[0]].entry()
+		// advance all sources positioned at the key, entries
+		// are immutable so e stays valid.
+		for m.h.Len() > 0 {
+			i := m.h.idx[0]
+			src := m.srcs[i]
+			if !bytes.Equal(src.entry().key, e.key) {
+				break
+			}
+			src.next()
+			if src.valid() {
+				heap.Fix(&m.h, 0)
+			} else {
+				heap.Pop(&m.h)
+				if err := src.err(); err != nil {
+					m.e = err
+					return
+				}
+			}
+		}
+
+		if !e.del || m.keepDeletes {
+			m.cur = e
+			return
+		}
+	}
+}
+
+// Iterator iterates live entries of a keyspace in a snapshot.
+// Key and Value returned are valid as long as the snapshot is open,
+// and must not be modified.
+type Iterator struct {
+	ks  Keyspace
+	m   *mergeIter
+	buf []byte
+}
+
+// SeekFirst positions iterator at the first key of the keyspace.
+func (it *Iterator) SeekFirst() {
+	it.m.seek([]byte{byte(it.ks)})
+}
+
+// Seek positions iterator at the first key >= `key`.
+func (it *Iterator) Seek(key []byte) {
+	it.buf = append(append(it.buf[:0], byte(it.ks)), key...)
+	it.m.seek(it.buf)
+}
+
+func (it *Iterator) Valid() bool {
+	return it.m.valid() && it.m.entry().key[0] == byte(it.ks)
+}
+
+func (it *Iterator) Key() []byte {
+	return it.m.entry().key[1:]
+}
+
+func (it *Iterator) Value() []byte {
+	return it.m.entry().val
+}
+
+func (it *Iterator) Next() {
+	it.m.next()
+}
+
+// Err returns the error that invalidated the iterator, if any.
+func (it *Iterator) Err() error {
+	return it.m.err()
+}
+
+func (it *Iterator) Close() {
+	it.m = nil
+}
@@ -0,0 +1,725 @@
+// Package lsm implements a log structured merge tree over append only
+// segment files.
+//
+// Writes go to a memtable, which is frozen into an immutable in-memory
+// run when a snapshot is created. Runs are flushed to sorted segment
+// files in the background or when a snapshot is persisted, segments are
+// merged by incremental compaction. A persisted snapshot is recorded in
+// a manifest file listing its segments, the last few manifests are
+// retained so that the store can be rolled back to them.
+//
+// A store has multiple keyspaces sharing the same memtable and segments,
+// so that all keyspaces are persisted atomically.
+package lsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrManifestNotFound = errors.New("lsm: manifest not found")
+	ErrClosed           = errors.New("lsm: store is closed")
+)
+
+const manifestPrefix = "manifest."
+const tmpSuffix = ".tmp"
+
+// Keyspace identifies a separate key space within a store.
+type Keyspace byte
+
+type Config struct {
+	// Memtable and in-memory runs are flushed to a segment after
+	// they exceed this size.
+	MemtableSize int64
+	// In-memory runs are merged when there are more runs.
+	MaxRuns int
+	// Adjacent segments are merged when there are more segments.
+	MaxSegments int
+	// Target size of a data block.
+	BlockSize int
+	// Bits per key in segment bloom filter, 0 disables the filter.
+	BloomBitsPerKey int
+	// Number of persisted snapshots retained for rollback.
+	KeepManifests int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MemtableSize:    16 * 1024 * 1024,
+		MaxRuns:         8,
+		MaxSegments:     8,
+		BlockSize:       16 * 1024,
+		BloomBitsPerKey: 10,
+		KeepManifests:   2,
+	}
+}
+
+// Manifest describes a persisted snapshot.
+type Manifest struct {
+	Seq  uint64
+	Meta []byte
+}
+
+type manifest struct {
+	Seq      uint64
+	Meta     []byte
+	Segments []uint64 // newest first
+
+	segs []*segment
+}
+
+func manifestFileName(seq uint64) string {
+	return fmt.Sprintf("%s%016x", manifestPrefix, seq)
+}
+
+// version is an immutable list of runs and segments, newest first.
+type version struct {
+	runs []*memRun
+	segs []*segment
+	refs int32
+}
+
+func newVersion(runs []*memRun, segs []*segment) *version {
+	for _, run := range runs {
+		run.incRef()
+	}
+	for _, seg := range segs {
+		seg.incRef()
+	}
+	return &version{runs: runs, segs: segs, refs: 1}
+}
+
+func (v *version) incRef() {
+	atomic.AddInt32(&v.refs, 1)
+}
+
+func (v *version) decRef() {
+	if atomic.AddInt32(&v.refs, -1) == 0 {
+		for _, run := range v.runs {
+			run.decRef()
+		}
+		for _, seg := range v.segs {
+			seg.decRef()
+		}
+	}
+}
+
+func (v *version) get(key []byte) ([]byte, bool, error) {
+	for _, run := range v.runs {
+		if e, ok := run.get(key); ok {
+			return e.val, !e.del, nil
+		}
+	}
+	h := hashKey(key)
+	for _, seg := range v.segs {
+		e, err := seg.get(key, h)
+		if err != nil {
+			return nil, false, err
+		}
+		if e != nil {
+			return e.val, !e.del, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// Stats of a store.
+type Stats struct {
+	DataSize     int64 // estimated live data in segments
+	DiskSize     int64 // size of all segment files
+	MemSize      int64 // memtable and in-memory runs
+	NumRuns      int
+	NumSegments  int
+	NumFlushes   int64
+	NumCompacts  int64
+	BytesWritten int64
+}
+
+// Store is an LSM tree in a directory.
+type Store struct {
+	dir string
+	cfg Config
+
+	mu        sync.Mutex
+	mt        *memtable
+	current   *version
+	manifests []*manifest // oldest first
+	nextSeg   uint64
+	nextSeq   uint64
+	closed    bool
+	bgErr     error
+
+	// serializes changes to runs of current version
+	flushMu sync.Mutex
+	// serializes compaction of segments
+	compactMu sync.Mutex
+
+	diskSize     int64
+	numFlushes   int64
+	numCompacts  int64
+	bytesWritten int64
+
+	kickch chan bool
+	stopch chan bool
+	donech chan bool
+}
+
+// Open store in directory `dir`, creating it if it does not exist.
+// Store is opened at the latest persisted snapshot.
+func Open(dir string, cfg Config) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		dir:    dir,
+		cfg:    cfg,
+		mt:     newMemtable(),
+		kickch: make(chan bool, 1),
+		stopch: make(chan bool),
+		donech: make(chan bool),
+	}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	go s.maintain()
+	return s, nil
+}
+
+// recover reads manifests and removes files not referenced by them.
+func (s *Store) recover() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	var segFiles []string
+	for _, fi := range files {
+		name := fi.Name()
+		switch {
+		case strings.HasSuffix(name, tmpSuffix):
+			os.Remove(filepath.Join(s.dir, name))
+
+		case strings.HasPrefix(name, manifestPrefix):
+			data, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+			if err != nil {
+				return err
+			}
+			m := &manifest{}
+			if err := json.Unmarshal(data, m); err != nil {
+				return fmt.Errorf("%v: %v", name, err)
+			}
+			s.manifests = append(s.manifests, m)
+
+		case strings.HasSuffix(name, ".seg"):
+			segFiles = append(segFiles, name)
+		}
+	}
+	sort.Sort(manifestsBySeq(s.manifests))
+
+	segs := make(map[uint64]*segment)
+	for _, m := range s.manifests {
+		for _, id := range m.Segments {
+			seg, ok := segs[id]
+			if !ok {
+				seg, err = openSegment(s, id, filepath.Join(s.dir, segmentFileName(id)))
+				if err != nil {
+					for _, seg := range segs {
+						seg.close()
+					}
+					s.manifests = nil
+					return err
+				}
+				segs[id] = seg
+				atomic.AddInt64(&s.diskSize, seg.size)
+			}
+			seg.incRef()
+			m.segs = append(m.segs, seg)
+		}
+		if m.Seq >= s.nextSeq {
+			s.nextSeq = m.Seq + 1
+		}
+	}
+
+	for _, name := range segFiles {
+		var id uint64
+		if _, err := fmt.Sscanf(name, "%016x.seg", &id); err != nil {
+			continue
+		}
+		if _, ok := segs[id]; !ok {
+			os.Remove(filepath.Join(s.dir, name))
+		}
+		if id >= s.nextSeg {
+			s.nextSeg = id + 1
+		}
+	}
+
+	if n := len(s.manifests); n > 0 {
+		s.current = newVersion(nil, s.manifests[n-1].segs)
+	} else {
+		s.current = newVersion(nil, nil)
+	}
+	// refs taken by manifests are held, drop the ones taken while opening
+	for _, seg := range segs {
+		seg.decRef()
+	}
+	return nil
+}
+
+type manifestsBySeq []*manifest
+
+func (ms manifestsBySeq) Len() int           { return len(ms) }
+func (ms manifestsBySeq) Less(i, j int) bool { return ms[i].Seq < ms[j].Seq }
+func (ms manifestsBySeq) Swap(i, j int)      { ms[i], ms[j] = ms[j], ms[i] }
+
+func internalKey(ks Keyspace, key []byte) []byte {
+	ikey := make([]byte, len(key)+1)
+	ikey[0] = byte(ks)
+	copy(ikey[1:], key)
+	return ikey
+}
+
+// Set `key` to `val` in keyspace `ks`.
+func (s *Store) Set(ks Keyspace, key, val []byte) {
+	s.write(ks, key, val, false)
+}
+
+// Delete `key` from keyspace `ks`.
+func (s *Store) Delete(ks Keyspace, key []byte) {
+	s.write(ks, key, nil, true)
+}
+
+func (s *Store) write(ks Keyspace, key, val []byte, del bool) {
+	s.mu.Lock()
+	s.mt.set(internalKey(ks, key), val, del)
+	full := s.mt.size >= s.cfg.MemtableSize
+	s.mu.Unlock()
+
+	if full {
+		s.kick()
+	}
+}
+
+// Get latest value of `key` in keyspace `ks`.
+func (s *Store) Get(ks Keyspace, key []byte) ([]byte, bool, error) {
+	ikey := internalKey(ks, key)
+
+	s.mu.Lock()
+	if e, ok := s.mt.get(ikey); ok {
+		s.mu.Unlock()
+		return e.val, !e.del, nil
+	}
+	v := s.current
+	v.incRef()
+	s.mu.Unlock()
+
+	defer v.decRef()
+	return v.get(ikey)
+}
+
+// Snapshot is a consistent read only view of the store.
+type Snapshot struct {
+	s *Store
+	v *version
+}
+
+// NewSnapshot returns a snapshot of all writes done so far.
+func (s *Store) NewSnapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.freeze()
+	s.current.incRef()
+	return &Snapshot{s: s, v: s.current}
+}
+
+// OpenSnapshot returns the persisted snapshot `seq`.
+func (s *Store) OpenSnapshot(seq uint64) (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.manifests {
+		if m.Seq == seq {
+			return &Snapshot{s: s, v: newVersion(nil, m.segs)}, nil
+		}
+	}
+	return nil, ErrManifestNotFound
+}
+
+// freeze memtable into a run of the current version. Called with
+// s.mu held.
+func (s *Store) freeze() {
+	if len(s.mt.items) == 0 {
+		return
+	}
+
+	run := s.mt.freeze()
+	old := s.current
+	runs := append([]*memRun{run}, old.runs...)
+	s.current = newVersion(runs, old.segs)
+	run.decRef() // ref taken by the version is held
+	s.mt = newMemtable()
+	// runs and segments are referenced by the new version
+	old.decRef()
+
+	if len(runs) > s.cfg.MaxRuns {
+		s.kick()
+	}
+}
+
+func (snap *Snapshot) Get(ks Keyspace, key []byte) ([]byte, bool, error) {
+	return snap.v.get(internalKey(ks, key))
+}
+
+// NewIterator returns an iterator over keyspace `ks`.
+func (snap *Snapshot) NewIterator(ks Keyspace) *Iterator {
+	srcs := versionSources(snap.v.runs, snap.v.segs)
+	return &Iterator{ks: ks, m: newMergeIter(srcs, false)}
+}
+
+func (snap *Snapshot) Close() {
+	snap.v.decRef()
+}
+
+// Persist snapshot `snap` with `meta` and returns sequence number of the
+// persisted snapshot.
+func (s *Store) Persist(snap *Snapshot, meta []byte) (uint64, error) {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	err := s.bgErr
+	if s.closed {
+		err = ErrClosed
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	v := snap.v
+	var seg *segment
+	if len(v.runs) > 0 {
+		srcs := versionSources(v.runs, nil)
+		if seg, err = s.writeSegment(srcs, len(v.segs) == 0); err != nil {
+			return 0, err
+		}
+	}
+
+	segs := v.segs
+	if seg != nil {
+		segs = append([]*segment{seg}, segs...)
+		defer seg.decRef()
+	}
+
+	s.mu.Lock()
+	m := &manifest{Seq: s.nextSeq, Meta: meta}
+	s.nextSeq++
+	s.mu.Unlock()
+
+	for _, seg := range segs {
+		m.Segments = append(m.Segments, seg.id)
+		seg.incRef()
+	}
+	m.segs = segs
+
+	if err := s.writeManifest(m); err != nil {
+		for _, seg := range segs {
+			seg.decRef()
+		}
+		return 0, err
+	}
+
+	s.mu.Lock()
+	s.manifests = append(s.manifests, m)
+	var dropped []*manifest
+	if n := len(s.manifests) - s.cfg.KeepManifests; n > 0 {
+		dropped = append(dropped, s.manifests[:n]...)
+		s.manifests = append([]*manifest(nil), s.manifests[n:]...)
+	}
+	s.mu.Unlock()
+
+	// runs persisted by the segment are no longer needed in memory,
+	// if they are still in the current version.
+	if seg != nil {
+		s.replaceRuns(v.runs, nil, seg)
+		atomic.AddInt64(&s.numFlushes, 1)
+	}
+	s.dropManifests(dropped)
+	return m.Seq, nil
+}
+
+func isSuffix(runs, suffix []*memRun) bool {
+	if len(suffix) > len(runs) {
+		return false
+	}
+	off := len(runs) - len(suffix)
+	for i, run := range suffix {
+		if runs[off+i] != run {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Store) writeManifest(m *manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.dir, manifestFileName(m.Seq))
+	tmp := path + tmpSuffix
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return s.syncDir()
+}
+
+func (s *Store) syncDir() error {
+	d, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// dropManifests removes manifest files and releases their segments.
+func (s *Store) dropManifests(ms []*manifest) {
+	for i := len(ms) - 1; i >= 0; i-- {
+		os.Remove(filepath.Join(s.dir, manifestFileName(ms[i].Seq)))
+	}
+	for _, m := range ms {
+		for _, seg := range m.segs {
+			seg.decRef()
+		}
+	}
+}
+
+// Manifests returns persisted snapshots, oldest first.
+func (s *Store) Manifests() []Manifest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ms := make([]Manifest, len(s.manifests))
+	for i, m := range s.manifests {
+		ms[i] = Manifest{Seq: m.Seq, Meta: m.Meta}
+	}
+	return ms
+}
+
+// Rollback store to persisted snapshot `seq`. Writes and snapshots
+// persisted after it are discarded.
+func (s *Store) Rollback(seq uint64) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	s.mu.Lock()
+	pos := -1
+	for i, m := range s.manifests {
+		if m.Seq == seq {
+			pos = i
+		}
+	}
+	if pos < 0 {
+		s.mu.Unlock()
+		return ErrManifestNotFound
+	}
+
+	newer := s.manifests[pos+1:]
+	s.manifests = append([]*manifest(nil), s.manifests[:pos+1]...)
+	old := s.reset(newVersion(nil, s.manifests[pos].segs))
+	s.mu.Unlock()
+
+	old.decRef()
+	s.dropManifests(newer)
+	return nil
+}
+
+// Reset discards all data and persisted snapshots of the store.
+func (s *Store) Reset() {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	s.mu.Lock()
+	dropped := s.manifests
+	s.manifests = nil
+	old := s.reset(newVersion(nil, nil))
+	s.mu.Unlock()
+
+	old.decRef()
+	s.dropManifests(dropped)
+}
+
+// reset memtable and current version, returns the previous version.
+// Called with s.mu held.
+func (s *Store) reset(v *version) *version {
+	s.mt.release()
+	s.mt = newMemtable()
+	s.bgErr = nil
+	old := s.current
+	s.current = v
+	return old
+}
+
+// Compact merges segments until there is a single segment without
+// deletes or `stop` returns true.
+func (s *Store) Compact(stop func() bool) error {
+	for !stop() {
+		done, err := s.compactStep(true)
+		if err != nil || done {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) Statistics() Stats {
+	s.mu.Lock()
+	v := s.current
+	v.incRef()
+	memSize := s.mt.size
+	s.mu.Unlock()
+	defer v.decRef()
+
+	sts := Stats{
+		DiskSize:     atomic.LoadInt64(&s.diskSize),
+		NumRuns:      len(v.runs),
+		NumSegments:  len(v.segs),
+		NumFlushes:   atomic.LoadInt64(&s.numFlushes),
+		NumCompacts:  atomic.LoadInt64(&s.numCompacts),
+		BytesWritten: atomic.LoadInt64(&s.bytesWritten),
+	}
+	for _, seg := range v.segs {
+		sts.DataSize += seg.liveBytes()
+	}
+	for _, run := range v.runs {
+		memSize += run.size
+	}
+	sts.MemSize = memSize
+	return sts
+}
+
+// Close the store. Snapshots must be closed before closing the store.
+func (s *Store) Close() {
+	close(s.stopch)
+	<-s.donech
+
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	s.release()
+}
+
+func (s *Store) release() {
+	s.mu.Lock()
+	s.closed = true
+	ms := s.manifests
+	s.manifests = nil
+	old := s.current
+	s.current = nil
+	s.mt.release()
+	s.mt = newMemtable()
+	s.mu.Unlock()
+
+	if old != nil {
+		old.decRef()
+	}
+	for _, m := range ms {
+		for _, seg := range m.segs {
+			seg.decRef()
+		}
+	}
+}
+
+// segmentReleased is called when a segment is no longer referenced by
+// any version or manifest.
+func (s *Store) segmentReleased(seg *segment) {
+	seg.close()
+	atomic.AddInt64(&s.diskSize, -seg.size)
+
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+
+	// segments of a closed store are left for the next open to clean up
+	if !closed {
+		os.Remove(seg.path)
+	}
+}
+
+// writeSegment writes entries of `srcs` to a new segment. Returns nil
+// segment if there are no entries to write.
+func (s *Store) writeSegment(srcs []source, dropDeletes bool) (*segment, error) {
+	s.mu.Lock()
+	id := s.nextSeg
+	s.nextSeg++
+	s.mu.Unlock()
+
+	path := filepath.Join(s.dir, segmentFileName(id))
+	w, err := newSegmentWriter(path, s.cfg.BlockSize, s.cfg.BloomBitsPerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	it := newMergeIter(srcs, !dropDeletes)
+	for it.seekFirst(); it.valid(); it.next() {
+		if err = w.add(it.entry()); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = it.err()
+	}
+	if err == nil && w.entries == 0 {
+		w.abort()
+		return nil, nil
+	}
+	if err == nil {
+		err = w.finish()
+	}
+	if err != nil {
+		w.abort()
+		return nil, err
+	}
+
+	seg, err := openSegment(s, id, path)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	atomic.AddInt64(&s.diskSize, seg.size)
+	atomic.AddInt64(&s.bytesWritten, seg.size)
+	return seg, nil
+}
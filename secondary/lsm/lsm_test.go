@@ -0,0 +1,482 @@
+package lsm
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+const ksMain = Keyspace(0)
+const ksBack = Keyspace(1)
+
+func testConfig() Config {
+	cfg := DefaultConfig()
+	cfg.MemtableSize = 64 * 1024
+	cfg.BlockSize = 512
+	cfg.MaxSegments = 4
+	cfg.KeepManifests = 3
+	return cfg
+}
+
+func openTestStore(t *testing.T) (*Store, string) {
+	dir, err := ioutil.TempDir("", "lsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(dir, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, dir
+}
+
+func key(i int) []byte {
+	return []byte(fmt.Sprintf("key-%08d", i))
+}
+
+// verify iteration of keyspace `ks` in snapshot matches `model`.
+func verify(t *testing.T, snap *Snapshot, ks Keyspace, model map[string]string) {
+	var keys []string
+	for k := range model {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	it := snap.NewIterator(ks)
+	defer it.Close()
+
+	i := 0
+	for it.SeekFirst(); it.Valid(); it.Next() {
+		if i >= len(keys) {
+			t.Fatalf("unexpected key %s", it.Key())
+		}
+		if string(it.Key()) != keys[i] || string(it.Value()) != model[keys[i]] {
+			t.Fatalf("expected %v=%v, got %s=%s", keys[i], model[keys[i]], it.Key(), it.Value())
+		}
+		i++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if i != len(keys) {
+		t.Fatalf("expected %v keys, got %v", len(keys), i)
+	}
+
+	for k, v := range model {
+		val, ok, err := snap.Get(ks, []byte(k))
+		if err != nil || !ok || string(val) != v {
+			t.Fatalf("get %v: expected %v, got %s %v %v", k, v, val, ok, err)
+		}
+	}
+}
+
+func TestSetGetDelete(t *testing.T) {
+	s, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	s.Set(ksMain, []byte("a"), []byte("1"))
+	s.Set(ksBack, []byte("a"), []byte("2"))
+	check := func(ks Keyspace, k string, exp string, expOk bool) {
+		val, ok, err := s.Get(ks, []byte(k))
+		if err != nil || ok != expOk || string(val) != exp {
+			t.Fatalf("get %v: expected %v %v, got %s %v %v", k, exp, expOk, val, ok, err)
+		}
+	}
+	check(ksMain, "a", "1", true)
+	check(ksBack, "a", "2", true)
+
+	snap := s.NewSnapshot()
+	if _, err := s.Persist(snap, nil); err != nil {
+		t.Fatal(err)
+	}
+	snap.Close()
+	check(ksMain, "a", "1", true)
+
+	s.Delete(ksMain, []byte("a"))
+	check(ksMain, "a", "", false)
+	check(ksBack, "a", "2", true)
+
+	snap = s.NewSnapshot()
+	defer snap.Close()
+	verify(t, snap, ksMain, map[string]string{})
+	verify(t, snap, ksBack, map[string]string{"a": "2"})
+}
+
+func TestRandomOps(t *testing.T) {
+	s, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	rnd := rand.New(rand.NewSource(1))
+	model := make(map[string]string)
+	for round := 0; round < 30; round++ {
+		for i := 0; i < 500; i++ {
+			k := key(rnd.Intn(2000))
+			if rnd.Intn(4) == 0 {
+				s.Delete(ksMain, k)
+				delete(model, string(k))
+			} else {
+				v := fmt.Sprintf("val-%v-%v", round, i)
+				s.Set(ksMain, k, []byte(v))
+				model[string(k)] = v
+			}
+		}
+
+		snap := s.NewSnapshot()
+		switch round % 3 {
+		case 0:
+			if _, err := s.Persist(snap, nil); err != nil {
+				t.Fatal(err)
+			}
+		case 1:
+			if err := s.flushRuns(); err != nil {
+				t.Fatal(err)
+			}
+		case 2:
+			if _, err := s.compactStep(false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		verify(t, snap, ksMain, model)
+		snap.Close()
+	}
+
+	if err := s.Compact(func() bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+	snap := s.NewSnapshot()
+	defer snap.Close()
+	verify(t, snap, ksMain, model)
+
+	sts := s.Statistics()
+	if sts.NumSegments > 1 {
+		t.Errorf("expected at most 1 segment after compaction, got %v", sts.NumSegments)
+	}
+}
+
+func TestSnapshotIsolation(t *testing.T) {
+	s, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	model := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		s.Set(ksMain, key(i), key(i))
+		model[string(key(i))] = string(key(i))
+	}
+	snap := s.NewSnapshot()
+	defer snap.Close()
+	if _, err := s.Persist(snap, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 1000; i++ {
+			if i%2 == 0 {
+				s.Delete(ksMain, key(i))
+			} else {
+				s.Set(ksMain, key(i), []byte("new"))
+			}
+		}
+		snap := s.NewSnapshot()
+		if _, err := s.Persist(snap, nil); err != nil {
+			t.Fatal(err)
+		}
+		snap.Close()
+	}
+	if err := s.Compact(func() bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+
+	verify(t, snap, ksMain, model)
+}
+
+func TestPersistReopen(t *testing.T) {
+	s, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+
+	model := make(map[string]string)
+	for i := 0; i < 5000; i++ {
+		s.Set(ksMain, key(i), []byte("v1"))
+		s.Set(ksBack, key(i), key(i))
+		model[string(key(i))] = "v1"
+	}
+	snap := s.NewSnapshot()
+	seq, err := s.Persist(snap, []byte("meta1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap.Close()
+
+	// unpersisted writes are lost on reopen
+	s.Set(ksMain, key(1), []byte("v2"))
+	s.Close()
+
+	s, err = Open(dir, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ms := s.Manifests()
+	if len(ms) != 1 || ms[0].Seq != seq || string(ms[0].Meta) != "meta1" {
+		t.Fatalf("unexpected manifests %+v", ms)
+	}
+	snap = s.NewSnapshot()
+	defer snap.Close()
+	verify(t, snap, ksMain, model)
+}
+
+func TestRollback(t *testing.T) {
+	s, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+
+	var seqs []uint64
+	var models []map[string]string
+	model := make(map[string]string)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 1000; i++ {
+			v := fmt.Sprintf("v%v", round)
+			s.Set(ksMain, key(i+round*500), []byte(v))
+			model[string(key(i+round*500))] = v
+		}
+		s.Delete(ksMain, key(round))
+		delete(model, string(key(round)))
+
+		snap := s.NewSnapshot()
+		seq, err := s.Persist(snap, []byte(fmt.Sprintf("%v", round)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		snap.Close()
+
+		seqs = append(seqs, seq)
+		m := make(map[string]string)
+		for k, v := range model {
+			m[k] = v
+		}
+		models = append(models, m)
+	}
+
+	// persisted snapshots can still be opened
+	for i, seq := range seqs {
+		snap, err := s.OpenSnapshot(seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verify(t, snap, ksMain, models[i])
+		snap.Close()
+	}
+
+	s.Set(ksMain, []byte("unpersisted"), nil)
+	if err := s.Rollback(seqs[0]); err != nil {
+		t.Fatal(err)
+	}
+	snap := s.NewSnapshot()
+	verify(t, snap, ksMain, models[0])
+	snap.Close()
+
+	if ms := s.Manifests(); len(ms) != 1 || ms[0].Seq != seqs[0] {
+		t.Fatalf("unexpected manifests after rollback %+v", ms)
+	}
+	if err := s.Rollback(seqs[1]); err != ErrManifestNotFound {
+		t.Fatalf("expected error rolling back to discarded snapshot, got %v", err)
+	}
+	s.Close()
+
+	s, err := Open(dir, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap = s.NewSnapshot()
+	verify(t, snap, ksMain, models[0])
+	snap.Close()
+
+	s.Reset()
+	snap = s.NewSnapshot()
+	verify(t, snap, ksMain, map[string]string{})
+	snap.Close()
+	s.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 0 {
+		t.Errorf("expected no files after reset, got %v", files)
+	}
+}
+
+func TestObsoleteFilesRemoved(t *testing.T) {
+	s, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 1000; i++ {
+			s.Set(ksMain, key(i), []byte(fmt.Sprintf("%v", round)))
+		}
+		snap := s.NewSnapshot()
+		if _, err := s.Persist(snap, nil); err != nil {
+			t.Fatal(err)
+		}
+		snap.Close()
+		if err := s.Compact(func() bool { return false }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifests, _ := filepath.Glob(filepath.Join(dir, manifestPrefix+"*"))
+	if len(manifests) != testConfig().KeepManifests {
+		t.Errorf("expected %v manifests, got %v", testConfig().KeepManifests, len(manifests))
+	}
+
+	// only segments of current version and retained manifests are kept,
+	// each manifest has the flushed segment and the one compacted before.
+	segs, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if len(segs) > 2*testConfig().KeepManifests+1 {
+		t.Errorf("expected obsolete segments to be removed, got %v", len(segs))
+	}
+
+	var size int64
+	for _, seg := range segs {
+		fi, err := os.Stat(seg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += fi.Size()
+	}
+	if sts := s.Statistics(); sts.DiskSize != size {
+		t.Errorf("expected disk size %v, got %v", size, sts.DiskSize)
+	}
+}
+
+func TestCorruptSegment(t *testing.T) {
+	s, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 1000; i++ {
+		s.Set(ksMain, key(i), key(i))
+	}
+	snap := s.NewSnapshot()
+	if _, err := s.Persist(snap, nil); err != nil {
+		t.Fatal(err)
+	}
+	snap.Close()
+	s.Close()
+
+	segs, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if len(segs) != 1 {
+		t.Fatalf("expected 1 segment, got %v", len(segs))
+	}
+	data, err := ioutil.ReadFile(segs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[10] ^= 0xff
+	if err := ioutil.WriteFile(segs[0], data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(dir, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	snap = s.NewSnapshot()
+	defer snap.Close()
+	it := snap.NewIterator(ksMain)
+	for it.SeekFirst(); it.Valid(); it.Next() {
+	}
+	if it.Err() != ErrCorruptSegment {
+		t.Fatalf("expected corruption error, got %v", it.Err())
+	}
+}
+
+func TestBlockCache(t *testing.T) {
+	c := newBlockCache(1000)
+	for i := 0; i < 20; i++ {
+		c.put(cacheKey{1, int64(i)}, &block{size: 100})
+	}
+	if c.size() != 1000 {
+		t.Errorf("expected cache size 1000, got %v", c.size())
+	}
+	if c.get(cacheKey{1, 0}) != nil {
+		t.Errorf("expected oldest block to be evicted")
+	}
+	if c.get(cacheKey{1, 19}) == nil {
+		t.Errorf("expected latest block to be cached")
+	}
+
+	c.drop(1, []int64{19, 18})
+	if c.size() != 800 {
+		t.Errorf("expected cache size 800, got %v", c.size())
+	}
+	c.setCapacity(300)
+	if c.size() != 300 {
+		t.Errorf("expected cache size 300, got %v", c.size())
+	}
+}
+
+func TestBloom(t *testing.T) {
+	var hashes []uint64
+	for i := 0; i < 1000; i++ {
+		hashes = append(hashes, hashKey(key(i)))
+	}
+	b := decodeBloom(newBloom(hashes, 10).encode(nil))
+	for i := 0; i < 1000; i++ {
+		if !b.mayContain(hashKey(key(i))) {
+			t.Fatalf("false negative for %s", key(i))
+		}
+	}
+	var fp int
+	for i := 1000; i < 11000; i++ {
+		if b.mayContain(hashKey(key(i))) {
+			fp++
+		}
+	}
+	if fp > 300 {
+		t.Errorf("too many false positives %v", fp)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	s, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	for i := 0; i < 1000; i += 2 {
+		s.Set(ksMain, key(i), nil)
+	}
+	snap := s.NewSnapshot()
+	if _, err := s.Persist(snap, nil); err != nil {
+		t.Fatal(err)
+	}
+	snap.Close()
+	for i := 1; i < 1000; i += 4 {
+		s.Set(ksMain, key(i), nil)
+	}
+	s.Set(ksBack, key(0), nil)
+
+	snap = s.NewSnapshot()
+	defer snap.Close()
+	it := snap.NewIterator(ksMain)
+	for _, i := range []int{0, 1, 3, 4, 997, 998} {
+		it.Seek(key(i))
+		exp := i
+		if i%4 == 3 {
+			exp = i + 1
+		}
+		if !it.Valid() || !bytes.Equal(it.Key(), key(exp)) {
+			t.Fatalf("seek %v: expected %s", i, key(exp))
+		}
+	}
+	it.Seek(key(999))
+	if it.Valid() {
+		t.Fatalf("expected iterator to stop at keyspace end, got %s", it.Key())
+	}
+}
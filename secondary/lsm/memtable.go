@@ -0,0 +1,107 @@
+package lsm
+
+import (
+	"bytes"
+	"sort"
+	"sync/atomic"
+)
+
+// memtable buffers writes until it is frozen into an immutable run
+// when a snapshot is created.
+type memtable struct {
+	items map[string]entry
+	size  int64
+}
+
+func newMemtable() *memtable {
+	return &memtable{items: make(map[string]entry)}
+}
+
+func (mt *memtable) set(key, val []byte, del bool) {
+	buf := make([]byte, len(key)+len(val))
+	copy(buf, key)
+	copy(buf[len(key):], val)
+	e := entry{
+		key: buf[:len(key):len(key)],
+		val: buf[len(key):],
+		del: del,
+	}
+
+	sz := int64(len(buf)) + entryOverhead
+	if old, ok := mt.items[string(e.key)]; ok {
+		sz -= int64(len(old.key)+len(old.val)) + entryOverhead
+	}
+	mt.items[string(e.key)] = e
+	mt.size += sz
+	atomic.AddInt64(&memInUse, sz)
+}
+
+func (mt *memtable) get(key []byte) (entry, bool) {
+	e, ok := mt.items[string(key)]
+	return e, ok
+}
+
+// release accounted memory of a memtable that is discarded.
+func (mt *memtable) release() {
+	atomic.AddInt64(&memInUse, -mt.size)
+}
+
+// freeze returns entries of the memtable as a sorted run, memory
+// accounted for the memtable is carried over to the run.
+func (mt *memtable) freeze() *memRun {
+	run := &memRun{ents: make(entries, 0, len(mt.items)), size: mt.size, refs: 1}
+	for _, e := range mt.items {
+		run.ents = append(run.ents, e)
+	}
+	sort.Sort(run.ents)
+	return run
+}
+
+type entries []entry
+
+func (es entries) Len() int           { return len(es) }
+func (es entries) Less(i, j int) bool { return bytes.Compare(es[i].key, es[j].key) < 0 }
+func (es entries) Swap(i, j int)      { es[i], es[j] = es[j], es[i] }
+
+// memRun is an immutable sorted run of entries.
+type memRun struct {
+	ents entries
+	size int64
+	refs int32
+}
+
+func (run *memRun) incRef() {
+	atomic.AddInt32(&run.refs, 1)
+}
+
+func (run *memRun) decRef() {
+	if atomic.AddInt32(&run.refs, -1) == 0 {
+		atomic.AddInt64(&memInUse, -run.size)
+	}
+}
+
+func (run *memRun) search(key []byte) int {
+	return sort.Search(len(run.ents), func(i int) bool {
+		return bytes.Compare(run.ents[i].key, key) >= 0
+	})
+}
+
+func (run *memRun) get(key []byte) (*entry, bool) {
+	i := run.search(key)
+	if i < len(run.ents) && bytes.Equal(run.ents[i].key, key) {
+		return &run.ents[i], true
+	}
+	return nil, false
+}
+
+type runIter struct {
+	run *memRun
+	pos int
+}
+
+func (it *runIter) seekFirst()      { it.pos = 0 }
+func (it *runIter) seek(key []byte) { it.pos = it.run.search(key) }
+func (it *runIter) valid() bool     { return it.pos < len(it.run.ents) }
+func (it *runIter) entry() *entry   { return &it.run.ents[it.pos] }
+func (it *runIter) next()           { it.pos++ }
+func (it *runIter) err() error      { return nil }
@@ -0,0 +1,506 @@
+package lsm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+	"sync/atomic"
+)
+
+// Segment file layout
+//
+//   data block 0 | ... | data block n | index block | bloom block | footer
+//
+// data block  : entries followed by crc32 of the entries, where entry is
+//               flags(1) | uvarint keylen | uvarint vallen | key | val
+// index block : for each data block, uvarint keylen | last key |
+//               uvarint offset | uvarint length, followed by crc32
+// bloom block : k(1) | filter bits, followed by crc32
+// footer      : index offset, index length, bloom offset, bloom length,
+//               entries, deletes, data bytes, magic as little endian uint64
+
+const segmentMagic = uint64(0x6c736d7365673031) // "lsmseg01"
+const footerSize = 8 * 8
+
+const flagDelete = byte(1)
+
+var (
+	ErrCorruptSegment = errors.New("lsm: corrupt segment")
+	crcTable          = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// process wide segment id, used as block cache key.
+var gSegmentId uint64
+
+type entry struct {
+	key []byte
+	val []byte
+	del bool
+}
+
+type block struct {
+	ents []entry
+	size int64
+}
+
+type blockHandle struct {
+	last []byte
+	off  int64
+	len  int64
+}
+
+type segment struct {
+	id   uint64 // file number within the store
+	cid  uint64 // process wide id
+	path string
+	f    *os.File
+
+	size      int64 // file size
+	dataBytes int64 // size of keys and values
+	entries   int64
+	deletes   int64
+	memSize   int64 // memory used by index and filter
+
+	index []blockHandle
+	bloom *bloom
+
+	refs  int32
+	store *Store
+}
+
+func segmentFileName(id uint64) string {
+	return fmt.Sprintf("%016x.seg", id)
+}
+
+func (seg *segment) incRef() {
+	atomic.AddInt32(&seg.refs, 1)
+}
+
+func (seg *segment) decRef() {
+	if atomic.AddInt32(&seg.refs, -1) == 0 {
+		seg.store.segmentReleased(seg)
+	}
+}
+
+// close file and drop cached blocks of the segment.
+func (seg *segment) close() {
+	offsets := make([]int64, len(seg.index))
+	for i, h := range seg.index {
+		offsets[i] = h.off
+	}
+	gCache.drop(seg.cid, offsets)
+	atomic.AddInt64(&memInUse, -seg.memSize)
+	seg.f.Close()
+}
+
+// liveBytes is an estimate of data bytes excluding tombstones.
+func (seg *segment) liveBytes() int64 {
+	if seg.entries == 0 {
+		return 0
+	}
+	return seg.dataBytes * (seg.entries - seg.deletes) / seg.entries
+}
+
+// get looks up `key` in the segment.
+func (seg *segment) get(key []byte, h uint64) (*entry, error) {
+	if !seg.bloom.mayContain(h) {
+		return nil, nil
+	}
+	bi := seg.findBlock(key)
+	if bi >= len(seg.index) {
+		return nil, nil
+	}
+	blk, err := seg.loadBlock(bi)
+	if err != nil {
+		return nil, err
+	}
+	i := blk.search(key)
+	if i < len(blk.ents) && bytes.Equal(blk.ents[i].key, key) {
+		return &blk.ents[i], nil
+	}
+	return nil, nil
+}
+
+// findBlock returns the first block with last key >= `key`.
+func (seg *segment) findBlock(key []byte) int {
+	return sort.Search(len(seg.index), func(i int) bool {
+		return bytes.Compare(seg.index[i].last, key) >= 0
+	})
+}
+
+func (seg *segment) loadBlock(bi int) (*block, error) {
+	h := seg.index[bi]
+	ckey := cacheKey{seg.cid, h.off}
+	if blk := gCache.get(ckey); blk != nil {
+		return blk, nil
+	}
+
+	buf := make([]byte, h.len)
+	if _, err := seg.f.ReadAt(buf, h.off); err != nil {
+		return nil, err
+	}
+	data, err := checkCrc(buf)
+	if err != nil {
+		return nil, err
+	}
+	blk, err := decodeBlock(data)
+	if err != nil {
+		return nil, err
+	}
+	blk.size = h.len + int64(len(blk.ents))*entryOverhead
+	gCache.put(ckey, blk)
+	return blk, nil
+}
+
+// approximate memory used by an entry other than key and value.
+const entryOverhead = 56
+
+func (blk *block) search(key []byte) int {
+	return sort.Search(len(blk.ents), func(i int) bool {
+		return bytes.Compare(blk.ents[i].key, key) >= 0
+	})
+}
+
+func decodeBlock(data []byte) (*block, error) {
+	blk := &block{}
+	for len(data) > 0 {
+		flags := data[0]
+		data = data[1:]
+		klen, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, ErrCorruptSegment
+		}
+		data = data[n:]
+		vlen, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < klen+vlen {
+			return nil, ErrCorruptSegment
+		}
+		data = data[n:]
+		e := entry{
+			key: data[:klen:klen],
+			val: data[klen : klen+vlen : klen+vlen],
+			del: flags&flagDelete != 0,
+		}
+		blk.ents = append(blk.ents, e)
+		data = data[klen+vlen:]
+	}
+	return blk, nil
+}
+
+func checkCrc(buf []byte) ([]byte, error) {
+	if len(buf) < 4 {
+		return nil, ErrCorruptSegment
+	}
+	data := buf[:len(buf)-4]
+	if crc32.Checksum(data, crcTable) != binary.LittleEndian.Uint32(buf[len(data):]) {
+		return nil, ErrCorruptSegment
+	}
+	return data, nil
+}
+
+func appendCrc(buf []byte) []byte {
+	var tmp [4]byte
+	binary.LittleEndian.PutUint32(tmp[:], crc32.Checksum(buf, crcTable))
+	return append(buf, tmp[:]...)
+}
+
+// openSegment reads index and filter of segment file `path`.
+func openSegment(s *Store, id uint64, path string) (*segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	seg, err := readSegment(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	seg.id, seg.path, seg.store = id, path, s
+	seg.cid = atomic.AddUint64(&gSegmentId, 1)
+	seg.refs = 1
+	atomic.AddInt64(&memInUse, seg.memSize)
+	return seg, nil
+}
+
+func readSegment(f *os.File) (*segment, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	if size < footerSize {
+		return nil, ErrCorruptSegment
+	}
+
+	var footer [footerSize]byte
+	if _, err := f.ReadAt(footer[:], size-footerSize); err != nil {
+		return nil, err
+	}
+	var vals [8]uint64
+	for i := range vals {
+		vals[i] = binary.LittleEndian.Uint64(footer[i*8:])
+	}
+	if vals[7] != segmentMagic {
+		return nil, ErrCorruptSegment
+	}
+	indexOff, indexLen, bloomOff, bloomLen := int64(vals[0]), int64(vals[1]), int64(vals[2]), int64(vals[3])
+	if indexOff+indexLen > size || bloomOff+bloomLen > size {
+		return nil, ErrCorruptSegment
+	}
+
+	seg := &segment{
+		f:         f,
+		size:      size,
+		entries:   int64(vals[4]),
+		deletes:   int64(vals[5]),
+		dataBytes: int64(vals[6]),
+	}
+
+	buf := make([]byte, indexLen)
+	if _, err := f.ReadAt(buf, indexOff); err != nil {
+		return nil, err
+	}
+	data, err := checkCrc(buf)
+	if err != nil {
+		return nil, err
+	}
+	for len(data) > 0 {
+		var h blockHandle
+		klen, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < klen {
+			return nil, ErrCorruptSegment
+		}
+		h.last = data[n : n+int(klen)]
+		data = data[n+int(klen):]
+		off, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, ErrCorruptSegment
+		}
+		data = data[n:]
+		l, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, ErrCorruptSegment
+		}
+		data = data[n:]
+		h.off, h.len = int64(off), int64(l)
+		seg.index = append(seg.index, h)
+	}
+	seg.memSize = indexLen + int64(len(seg.index))*entryOverhead
+
+	if bloomLen > 0 {
+		buf := make([]byte, bloomLen)
+		if _, err := f.ReadAt(buf, bloomOff); err != nil {
+			return nil, err
+		}
+		data, err := checkCrc(buf)
+		if err != nil {
+			return nil, err
+		}
+		seg.bloom = decodeBloom(data)
+		seg.memSize += bloomLen
+	}
+	return seg, nil
+}
+
+// segmentWriter writes a sorted sequence of entries to a segment file.
+type segmentWriter struct {
+	f   *os.File
+	w   *bufio.Writer
+	off int64
+
+	blockSize  int
+	bitsPerKey int
+
+	blk    []byte
+	last   []byte
+	index  []byte
+	hashes []uint64
+
+	entries   int64
+	deletes   int64
+	dataBytes int64
+}
+
+func newSegmentWriter(path string, blockSize, bitsPerKey int) (*segmentWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &segmentWriter{
+		f:          f,
+		w:          bufio.NewWriterSize(f, 256*1024),
+		blockSize:  blockSize,
+		bitsPerKey: bitsPerKey,
+	}
+	return w, nil
+}
+
+func (w *segmentWriter) add(e *entry) error {
+	var tmp [binary.MaxVarintLen64]byte
+
+	var flags byte
+	if e.del {
+		flags |= flagDelete
+		w.deletes++
+	}
+	w.blk = append(w.blk, flags)
+	n := binary.PutUvarint(tmp[:], uint64(len(e.key)))
+	w.blk = append(w.blk, tmp[:n]...)
+	n = binary.PutUvarint(tmp[:], uint64(len(e.val)))
+	w.blk = append(w.blk, tmp[:n]...)
+	w.blk = append(w.blk, e.key...)
+	w.blk = append(w.blk, e.val...)
+
+	w.last = append(w.last[:0], e.key...)
+	w.hashes = append(w.hashes, hashKey(e.key))
+	w.entries++
+	w.dataBytes += int64(len(e.key) + len(e.val))
+
+	if len(w.blk) >= w.blockSize {
+		return w.flushBlock()
+	}
+	return nil
+}
+
+func (w *segmentWriter) flushBlock() error {
+	if len(w.blk) == 0 {
+		return nil
+	}
+	w.blk = appendCrc(w.blk)
+
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], uint64(len(w.last)))
+	w.index = append(w.index, tmp[:n]...)
+	w.index = append(w.index, w.last...)
+	n = binary.PutUvarint(tmp[:], uint64(w.off))
+	w.index = append(w.index, tmp[:n]...)
+	n = binary.PutUvarint(tmp[:], uint64(len(w.blk)))
+	w.index = append(w.index, tmp[:n]...)
+
+	if err := w.write(w.blk); err != nil {
+		return err
+	}
+	w.blk = w.blk[:0]
+	return nil
+}
+
+func (w *segmentWriter) write(buf []byte) error {
+	n, err := w.w.Write(buf)
+	w.off += int64(n)
+	return err
+}
+
+// finish writes index, filter and footer and syncs the file.
+func (w *segmentWriter) finish() error {
+	if err := w.flushBlock(); err != nil {
+		return err
+	}
+
+	indexOff := w.off
+	index := appendCrc(w.index)
+	if err := w.write(index); err != nil {
+		return err
+	}
+
+	bloomOff := w.off
+	var bloomLen int64
+	if filter := newBloom(w.hashes, w.bitsPerKey); filter != nil {
+		buf := appendCrc(filter.encode(nil))
+		if err := w.write(buf); err != nil {
+			return err
+		}
+		bloomLen = int64(len(buf))
+	}
+
+	var footer [footerSize]byte
+	vals := []uint64{uint64(indexOff), uint64(len(index)), uint64(bloomOff),
+		uint64(bloomLen), uint64(w.entries), uint64(w.deletes),
+		uint64(w.dataBytes), segmentMagic}
+	for i, v := range vals {
+		binary.LittleEndian.PutUint64(footer[i*8:], v)
+	}
+	if err := w.write(footer[:]); err != nil {
+		return err
+	}
+
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	return w.f.Close()
+}
+
+func (w *segmentWriter) abort() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
+
+// segmentIter iterates entries of a segment.
+type segmentIter struct {
+	seg *segment
+	bi  int
+	blk *block
+	pos int
+	e   error
+}
+
+func (it *segmentIter) seekFirst() {
+	it.e = nil
+	it.setBlock(0)
+	it.pos = 0
+	it.skipEmpty()
+}
+
+func (it *segmentIter) seek(key []byte) {
+	it.e = nil
+	it.setBlock(it.seg.findBlock(key))
+	if it.blk != nil {
+		it.pos = it.blk.search(key)
+		it.skipEmpty()
+	}
+}
+
+func (it *segmentIter) setBlock(bi int) {
+	it.bi, it.blk = bi, nil
+	if bi >= len(it.seg.index) {
+		return
+	}
+	blk, err := it.seg.loadBlock(bi)
+	if err != nil {
+		it.e = err
+		return
+	}
+	it.blk = blk
+}
+
+func (it *segmentIter) skipEmpty() {
+	for it.blk != nil && it.pos >= len(it.blk.ents) {
+		it.setBlock(it.bi + 1)
+		it.pos = 0
+	}
+}
+
+func (it *segmentIter) valid() bool {
+	return it.blk != nil
+}
+
+func (it *segmentIter) entry() *entry {
+	return &it.blk.ents[it.pos]
+}
+
+func (it *segmentIter) next() {
+	it.pos++
+	it.skipEmpty()
+}
+
+func (it *segmentIter) err() error {
+	return it.e
+}
@@ -37,6 +37,9 @@ const (
 	OPCODE_BUILD_INDEX_RETRY                 = OPCODE_BROADCAST_STATS + 1
 	OPCODE_RESET_INDEX                       = OPCODE_BUILD_INDEX_RETRY + 1
 	OPCODE_CONFIG_UPDATE                     = OPCODE_RESET_INDEX + 1
+	OPCODE_ALTER_INDEX                       = OPCODE_CONFIG_UPDATE + 1
+	OPCODE_SWAP_ALTER_INDEX                  = OPCODE_ALTER_INDEX + 1
+	OPCODE_RESUME_ALTER_INDEX                = OPCODE_SWAP_ALTER_INDEX + 1
 )
 
 /////////////////////////////////////////////////////////////////////////
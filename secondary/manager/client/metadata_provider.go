@@ -10,12 +10,15 @@
 package client
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/couchbase/gometa/common"
 	gometaL "github.com/couchbase/gometa/log"
 	"github.com/couchbase/gometa/message"
 	"github.com/couchbase/gometa/protocol"
+	"github.com/couchbase/indexing/secondary/collatejson"
 	c "github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/common/queryutil"
 	"github.com/couchbase/indexing/secondary/logging"
@@ -136,6 +139,7 @@ type InstanceDefn struct {
 	RState      uint32
 	ReplicaId   uint64
 	StorageMode string
+	Partitions  []c.PartitionId // hosted partitions of a partitioned index
 }
 
 type event struct {
@@ -364,8 +368,19 @@ func (o *MetadataProvider) CreateIndexWithPlan(
 
 	key := fmt.Sprintf("%d", defnID)
 	errMap := make(map[string]bool)
+	// partitions of a partitioned index are placed across the nodes, each
+	// hosting an instance with a subset of partitions.
+	var placement [][]c.PartitionId
+	if idxDefn.GetNumPartitions() > 1 {
+		placement = idxDefn.PlacePartitions(len(watchers))
+	}
+
 	for replicaId, watcher := range watchers {
 		idxDefn.ReplicaId = replicaId
+		if placement != nil {
+			idxDefn.ReplicaId = 0
+			idxDefn.Partitions = placement[replicaId]
+		}
 
 		content, err := c.MarshallIndexDefn(idxDefn)
 		if err != nil {
@@ -425,6 +440,12 @@ func (o *MetadataProvider) PrepareIndexDefn(
 	var wait bool = true
 	var nodes []string = nil
 	var numReplica int = 0
+	var numPartns int = 0
+	var numPartition int = 0
+	var partnSplits []string = nil
+	var buildPriority int = 0
+	var include []string = nil
+	var expiry bool = false
 
 	version := o.GetIndexerVersion()
 	clusterVersion := o.GetClusterVersion()
@@ -475,6 +496,55 @@ func (o *MetadataProvider) PrepareIndexDefn(
 		if numReplica == 0 && len(nodes) != 0 {
 			numReplica = len(nodes) - 1
 		}
+
+		numPartition, err, retry = o.getNumPartitionParam(plan, partnExpr, isPrimary)
+		if err != nil {
+			return nil, err, retry
+		}
+
+		partnSplits, err, retry = o.getPartitionSplitsParam(plan, partnExpr, isPrimary)
+		if err != nil {
+			return nil, err, retry
+		}
+
+		if numPartition > 1 && len(partnSplits) != 0 {
+			return nil, errors.New("Fails to create index.  Parameter num_partition and partition_splits cannot be used together."), false
+		}
+
+		// partitions are spread across nodes, rather than replicated.
+		if len(partnSplits) != 0 {
+			numPartns = len(partnSplits) + 1
+		} else if numPartition > 1 {
+			numPartns = numPartition
+		}
+		if numPartns > 1 {
+			if _, ok := plan["num_replica"]; ok && numReplica != 0 {
+				return nil, errors.New("Fails to create index.  Parameter num_replica is not supported for partitioned index."), false
+			}
+			if len(nodes) > numPartns {
+				return nil, errors.New("Fails to create index.  Parameter nodes has more nodes than partitions."), false
+			}
+			numReplica = 0
+		}
+
+		buildPriority, err, retry = o.getBuildPriorityParam(plan)
+		if err != nil {
+			return nil, err, retry
+		}
+
+		include, err, retry = o.getIncludeParam(plan, isPrimary)
+		if err != nil {
+			return nil, err, retry
+		}
+
+		expiry, err, retry = o.getExpiryParam(plan, isPrimary, secExprs, whereExpr)
+		if err != nil {
+			return nil, err, retry
+		}
+		if expiry {
+			secExprs = []string{c.EXPIRY_INDEX_KEY}
+			whereExpr = c.EXPIRY_INDEX_WHERE
+		}
 	}
 
 	logging.Debugf("MetadataProvider:CreateIndex(): deferred_build %v sync %v nodes %v", deferred, wait, nodes)
@@ -483,7 +553,14 @@ func (o *MetadataProvider) PrepareIndexDefn(
 	// Get the list of Watchers
 	//
 
-	watchers, err, retry := o.findWatchersWithRetry(nodes, numReplica)
+	var watchers []*watcher
+	var err error
+	var retry bool
+	if numPartns > 1 {
+		watchers, err, retry = o.findWatchersForPartitions(nodes, numPartns)
+	} else {
+		watchers, err, retry = o.findWatchersWithRetry(nodes, numReplica)
+	}
 	if err != nil {
 		return nil, err, retry
 	}
@@ -535,6 +612,10 @@ func (o *MetadataProvider) PrepareIndexDefn(
 		return nil, errors.New("Fails to create index.  Multiple expressions with ALL are found. Only one array expression is supported per index."), false
 	}
 
+	if isArrayIndex && len(include) != 0 {
+		return nil, errors.New("Fails to create index.  Parameter include is not supported for array index."), false
+	}
+
 	if o.isDecending(desc) && (version < c.INDEXER_50_VERSION || clusterVersion < c.INDEXER_50_VERSION) {
 		return nil,
 			errors.New("Fail to create index with descending order. This option is enabled after cluster is fully upgraded and there is no failed node."),
@@ -545,6 +626,13 @@ func (o *MetadataProvider) PrepareIndexDefn(
 		return nil, errors.New("Fail to create index.  Collation order is required for all expressions in the index."), false
 	}
 
+	partnScheme := c.SINGLE
+	if numPartition > 1 {
+		partnScheme = c.HASH
+	} else if len(partnSplits) != 0 {
+		partnScheme = c.RANGE
+	}
+
 	idxDefn := &c.IndexDefn{
 		DefnId:          defnID,
 		Name:            name,
@@ -554,7 +642,7 @@ func (o *MetadataProvider) PrepareIndexDefn(
 		SecExprs:        secExprs,
 		Desc:            desc,
 		ExprType:        c.ExprType(exprType),
-		PartitionScheme: c.SINGLE,
+		PartitionScheme: c.PartitionScheme(partnScheme),
 		PartitionKey:    partnExpr,
 		WhereExpr:       whereExpr,
 		Deferred:        deferred,
@@ -562,6 +650,11 @@ func (o *MetadataProvider) PrepareIndexDefn(
 		Immutable:       immutable,
 		IsArrayIndex:    isArrayIndex,
 		NumReplica:      uint32(numReplica),
+		NumPartitions:   uint32(numPartition),
+		PartitionSplits: partnSplits,
+		BuildPriority:   buildPriority,
+		Include:         include,
+		Expiry:          expiry,
 	}
 
 	return idxDefn, nil, false
@@ -699,6 +792,249 @@ func (o *MetadataProvider) getReplicaParam(plan map[string]interface{}, version
 	return numReplica, nil, false
 }
 
+func (o *MetadataProvider) getNumPartitionParam(plan map[string]interface{},
+	partnExpr string, isPrimary bool) (int, error, bool) {
+
+	numPartition := int(0)
+
+	numPartition2, ok := plan["num_partition"].(float64)
+	if !ok {
+		numPartition_str, ok := plan["num_partition"].(string)
+		if ok {
+			numPartition3, err := strconv.ParseInt(numPartition_str, 10, 64)
+			if err != nil {
+				return 0, errors.New("Fails to create index.  Parameter num_partition must be a integer value."), false
+			}
+			numPartition = int(numPartition3)
+
+		} else if _, ok := plan["num_partition"]; ok {
+			return 0, errors.New("Fails to create index.  Parameter num_partition must be a integer value."), false
+		}
+	} else {
+		numPartition = int(numPartition2)
+	}
+
+	if numPartition < 0 {
+		return 0, errors.New("Fails to create index.  Parameter num_partition must be a positive value."), false
+	}
+
+	if numPartition > 1 {
+		if isPrimary {
+			return 0, errors.New("Fails to create index.  Primary index cannot be partitioned."), false
+		}
+		if len(partnExpr) == 0 {
+			return 0, errors.New("Fails to create index.  Parameter num_partition requires a partition key."), false
+		}
+	}
+
+	return numPartition, nil, false
+}
+
+//
+// Index with higher build priority is built ahead of other scheduled
+// index on the same indexer node, e.g. {"build_priority": 10}.
+//
+func (o *MetadataProvider) getBuildPriorityParam(plan map[string]interface{}) (int, error, bool) {
+
+	buildPriority := int(0)
+
+	buildPriority2, ok := plan["build_priority"].(float64)
+	if !ok {
+		buildPriority_str, ok := plan["build_priority"].(string)
+		if ok {
+			buildPriority3, err := strconv.ParseInt(buildPriority_str, 10, 64)
+			if err != nil {
+				return 0, errors.New("Fails to create index.  Parameter build_priority must be a integer value."), false
+			}
+			buildPriority = int(buildPriority3)
+
+		} else if _, ok := plan["build_priority"]; ok {
+			return 0, errors.New("Fails to create index.  Parameter build_priority must be a integer value."), false
+		}
+	} else {
+		buildPriority = int(buildPriority2)
+	}
+
+	return buildPriority, nil, false
+}
+
+//
+// Include columns of an index are given as an array of expressions, e.g.
+// {"include": ["name", "address.city"]}.  Their values are stored with each
+// index entry to cover a query, without being part of the index key.
+//
+func (o *MetadataProvider) getIncludeParam(plan map[string]interface{}, isPrimary bool) ([]string, error, bool) {
+
+	param, ok := plan["include"]
+	if !ok {
+		return nil, nil, false
+	}
+
+	exprs, ok := param.([]interface{})
+	if !ok {
+		expr_str, ok := param.(string)
+		if !ok {
+			return nil, errors.New("Fails to create index.  Parameter include must be an array of expressions."), false
+		}
+		exprs = []interface{}{expr_str}
+	}
+
+	if len(exprs) == 0 {
+		return nil, nil, false
+	}
+
+	if isPrimary {
+		return nil, errors.New("Fails to create index.  Parameter include is not supported for primary index."), false
+	}
+
+	include := make([]string, 0, len(exprs))
+	for _, e := range exprs {
+		expr, ok := e.(string)
+		if !ok || len(expr) == 0 {
+			return nil, errors.New(fmt.Sprintf("Fails to create index.  Include expression '%v' is not valid.", e)), false
+		}
+		isArray, _, err := queryutil.IsArrayExpression(expr)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Fails to create index.  Error in parsing include expression %v : %v", expr, err)), false
+		}
+		if isArray {
+			return nil, errors.New(fmt.Sprintf("Fails to create index.  Include expression %v cannot be an array expression.", expr)), false
+		}
+		include = append(include, expr)
+	}
+
+	return include, nil, false
+}
+
+//
+// Expiry index is created with {"expiry": true}, it indexes documents with
+// expiration on their expiry time so that documents expiring in a time window
+// can be scanned.  Since N1QL requires index keys, the index can be created
+// on (meta().expiration), optionally with where clause meta().expiration > 0.
+// Index without keys is also accepted.  Other keys or where clause are
+// rejected.
+//
+func (o *MetadataProvider) getExpiryParam(plan map[string]interface{}, isPrimary bool,
+	secExprs []string, whereExpr string) (bool, error, bool) {
+
+	expiry := false
+
+	expiry2, ok := plan["expiry"].(bool)
+	if !ok {
+		expiry_str, ok := plan["expiry"].(string)
+		if ok {
+			var err error
+			expiry2, err = strconv.ParseBool(expiry_str)
+			if err != nil {
+				return false, errors.New("Fails to create index.  Parameter expiry must be a boolean value of (true or false)."), false
+			}
+			expiry = expiry2
+
+		} else if _, ok := plan["expiry"]; ok {
+			return false, errors.New("Fails to create index.  Parameter expiry must be a boolean value of (true or false)."), false
+		}
+	} else {
+		expiry = expiry2
+	}
+
+	if expiry && isPrimary {
+		return false, errors.New("Fails to create index.  Parameter expiry is not supported for primary index."), false
+	}
+
+	if !expiry {
+		return false, nil, false
+	}
+
+	if len(secExprs) > 1 || (len(secExprs) == 1 && !isExpiryExpr(secExprs[0], c.EXPIRY_INDEX_KEY)) {
+		return false, errors.New(fmt.Sprintf("Fails to create index.  Parameter expiry can only be used with index key %v.", c.EXPIRY_INDEX_KEY)), false
+	}
+
+	if len(whereExpr) != 0 && !isExpiryExpr(whereExpr, c.EXPIRY_INDEX_WHERE) {
+		return false, errors.New(fmt.Sprintf("Fails to create index.  Parameter expiry can only be used with where clause %v.", c.EXPIRY_INDEX_WHERE)), false
+	}
+
+	return expiry, nil, false
+}
+
+//
+// Compare an expression given by N1QL, e.g. (meta().`expiration`), with
+// expression of expiry index, ignoring backticks, spaces, parentheses and
+// case of meta().
+//
+func isExpiryExpr(expr string, expected string) bool {
+
+	replacer := strings.NewReplacer("`", "", " ", "", "(", "", ")", "")
+	normalize := func(expr string) string {
+		expr = replacer.Replace(expr)
+		if len(expr) >= len("meta.") && strings.EqualFold(expr[:len("meta.")], "meta.") {
+			expr = "meta." + expr[len("meta."):]
+		}
+		return expr
+	}
+
+	return normalize(expr) == normalize(expected)
+}
+
+//
+// Split points of a range partitioned index is given as an array of values in
+// ascending order, e.g. {"partition_splits": [100, 200]}, each value is
+// stored as JSON.
+//
+func (o *MetadataProvider) getPartitionSplitsParam(plan map[string]interface{},
+	partnExpr string, isPrimary bool) ([]string, error, bool) {
+
+	param, ok := plan["partition_splits"]
+	if !ok {
+		return nil, nil, false
+	}
+
+	splits, ok := param.([]interface{})
+	if !ok {
+		splits_str, ok := param.(string)
+		if !ok || json.Unmarshal([]byte(splits_str), &splits) != nil {
+			return nil, errors.New("Fails to create index.  Parameter partition_splits must be an array of values."), false
+		}
+	}
+
+	if len(splits) == 0 {
+		return nil, nil, false
+	}
+
+	if isPrimary {
+		return nil, errors.New("Fails to create index.  Primary index cannot be partitioned."), false
+	}
+	if len(partnExpr) == 0 {
+		return nil, errors.New("Fails to create index.  Parameter partition_splits requires a partition key."), false
+	}
+
+	codec := collatejson.NewCodec(16)
+	partnSplits := make([]string, 0, len(splits))
+	var prev []byte
+	for _, split := range splits {
+		data, err := json.Marshal(split)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Fails to create index.  Invalid partition_splits value %v.", split)), false
+		}
+
+		size := 3 * len(data)
+		if size < collatejson.MinBufferSize {
+			size = collatejson.MinBufferSize
+		}
+		code, err := codec.Encode(data, make([]byte, 0, size))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Fails to create index.  Invalid partition_splits value %v.", split)), false
+		}
+		if prev != nil && bytes.Compare(prev, code) >= 0 {
+			return nil, errors.New("Fails to create index.  Parameter partition_splits must be in ascending order without duplicates."), false
+		}
+
+		prev = code
+		partnSplits = append(partnSplits, string(data))
+	}
+
+	return partnSplits, nil, false
+}
+
 func (o *MetadataProvider) findWatchersWithRetry(nodes []string, numReplica int) ([]*watcher, error, bool) {
 
 	var watchers []*watcher
@@ -763,6 +1099,29 @@ RETRY1:
 	return watchers, nil, false
 }
 
+// findWatchersForPartitions returns the watchers of nodes hosting the
+// partitions of a partitioned index, the given nodes or else the least
+// loaded nodes, one per partition at most.
+func (o *MetadataProvider) findWatchersForPartitions(nodes []string, numPartitions int) ([]*watcher, error, bool) {
+
+	watchers, err, retry := o.findWatchersWithRetry(nodes, 0)
+	if err != nil || len(nodes) != 0 {
+		return watchers, err, retry
+	}
+
+	for len(watchers) < numPartitions {
+		watcher, _ := o.findNextAvailWatcher(watchers, true)
+		if watcher == nil {
+			watcher, _ = o.findNextAvailWatcher(watchers, false)
+		}
+		if watcher == nil {
+			break
+		}
+		watchers = append(watchers, watcher)
+	}
+	return watchers, nil, false
+}
+
 func (o *MetadataProvider) DropIndex(defnID c.IndexDefnId) error {
 
 	// find index -- this method will not return the index if the index is in DELETED
@@ -813,6 +1172,64 @@ func (o *MetadataProvider) DropIndex(defnID c.IndexDefnId) error {
 	return nil
 }
 
+//
+// AlterIndex changes the key expressions of an index.  Each indexer hosting an
+// instance of the index builds a shadow instance of the new definition, while the
+// current instance keeps serving scans.  Indexer swaps in the shadow instance once
+// it has caught up, and the new definition is then picked up by metadata refresh.
+//
+func (o *MetadataProvider) AlterIndex(defnID c.IndexDefnId, secExprs []string, desc []bool, isArrayIndex bool) error {
+
+	meta := o.FindIndex(defnID)
+	if meta == nil {
+		return errors.New("Index does not exist.")
+	}
+
+	if len(meta.InstsInRebalance) != 0 {
+		return errors.New(fmt.Sprintf("Cannot alter index %s.  Index is currently being rebalanced.", meta.Definition.Name))
+	}
+
+	for _, inst := range meta.Instances {
+		if inst.State != c.INDEX_STATE_ACTIVE {
+			return errors.New(fmt.Sprintf("Cannot alter index %s.  Index is not built.", meta.Definition.Name))
+		}
+	}
+
+	watchers, err := o.findWatchersByDefnIdIgnoreStatus(defnID)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Cannot locate cluster node hosting Index %s.", meta.Definition.Name))
+	}
+
+	defn := meta.Definition.Clone()
+	defn.SecExprs = secExprs
+	defn.Desc = desc
+	defn.IsArrayIndex = isArrayIndex
+
+	content, err := c.MarshallIndexDefn(defn)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%d", defnID)
+	errMap := make(map[string]bool)
+	for _, watcher := range watchers {
+		_, err = watcher.makeRequest(OPCODE_ALTER_INDEX, key, content)
+		if err != nil {
+			errMap[err.Error()] = true
+		}
+	}
+
+	if len(errMap) != 0 {
+		errStr := ""
+		for msg, _ := range errMap {
+			errStr += msg + "\n"
+		}
+		return errors.New(fmt.Sprintf("Fail to alter index on some indexer nodes.  Error=%s.", errStr))
+	}
+
+	return nil
+}
+
 func (o *MetadataProvider) BuildIndexes(defnIDs []c.IndexDefnId) error {
 
 	watcherIndexMap := make(map[c.IndexerId][]c.IndexDefnId)
@@ -1984,6 +2401,7 @@ func (r *metadataRepo) makeInstanceDefn(defnId c.IndexDefnId, inst *IndexInstDis
 			idxInst.IndexerId = c.IndexerId(slice.IndexerId)
 			break
 		}
+		idxInst.Partitions = append(idxInst.Partitions, c.PartitionId(partition.PartId))
 	}
 
 	return idxInst
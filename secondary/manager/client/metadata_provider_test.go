@@ -0,0 +1,47 @@
+package client
+
+import (
+	"testing"
+
+	c "github.com/couchbase/indexing/secondary/common"
+)
+
+func TestExpiryParam(t *testing.T) {
+	o := &MetadataProvider{}
+
+	tests := []struct {
+		plan      map[string]interface{}
+		isPrimary bool
+		secExprs  []string
+		whereExpr string
+		expiry    bool
+		err       bool
+	}{
+		{map[string]interface{}{}, false, []string{"name"}, "", false, false},
+		{map[string]interface{}{"expiry": false}, false, []string{"name"}, "age > 10", false, false},
+		{map[string]interface{}{"expiry": true}, false, nil, "", true, false},
+		{map[string]interface{}{"expiry": "true"}, false, nil, "", true, false},
+		{map[string]interface{}{"expiry": "yes"}, false, nil, "", false, true},
+		{map[string]interface{}{"expiry": 1}, false, nil, "", false, true},
+		{map[string]interface{}{"expiry": true}, true, nil, "", false, true},
+		// keys supplied by N1QL.
+		{map[string]interface{}{"expiry": true}, false, []string{c.EXPIRY_INDEX_KEY}, "", true, false},
+		{map[string]interface{}{"expiry": true}, false, []string{"(meta().`expiration`)"}, "", true, false},
+		{map[string]interface{}{"expiry": true}, false, []string{"META().expiration"}, "", true, false},
+		{map[string]interface{}{"expiry": true}, false, []string{"meta().expiration"}, "(meta().`expiration` > 0)", true, false},
+		// other keys or where clause.
+		{map[string]interface{}{"expiry": true}, false, []string{"name"}, "", false, true},
+		{map[string]interface{}{"expiry": true}, false, []string{"meta().Expiration"}, "", false, true},
+		{map[string]interface{}{"expiry": true}, false, []string{"meta().expiration", "name"}, "", false, true},
+		{map[string]interface{}{"expiry": true}, false, []string{"meta().expiration"}, "age > 10", false, true},
+	}
+
+	for i, test := range tests {
+		expiry, err, _ := o.getExpiryParam(test.plan, test.isPrimary, test.secExprs, test.whereExpr)
+		if (err != nil) != test.err {
+			t.Errorf("test %v: unexpected error %v", i, err)
+		} else if err == nil && expiry != test.expiry {
+			t.Errorf("test %v: expected expiry %v, received %v", i, test.expiry, expiry)
+		}
+	}
+}
@@ -14,7 +14,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/couchbase/cbauth/metakv"
 	c "github.com/couchbase/gometa/common"
 	"github.com/couchbase/gometa/message"
 	"github.com/couchbase/gometa/protocol"
@@ -23,7 +22,10 @@ import (
 	"github.com/couchbase/indexing/secondary/logging"
 	"github.com/couchbase/indexing/secondary/manager/client"
 	"math"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 	//"runtime/debug"
@@ -47,6 +49,10 @@ type LifecycleMgr struct {
 	janitor       *janitor
 	updator       *updator
 	requestServer RequestServer
+
+	// alters with shadow instance being built, only accessed
+	// by request processing.
+	alters map[common.IndexDefnId]bool
 }
 
 type requestHolder struct {
@@ -65,11 +71,16 @@ type topologyChange struct {
 }
 
 type builder struct {
-	manager   *LifecycleMgr
-	pendings  map[string][]uint64
-	notifych  chan *common.IndexDefn
-	batchSize int32
-	disable   int32
+	manager    *LifecycleMgr
+	pendings   map[string][]uint64
+	priorities map[uint64]int
+	notifych   chan *common.IndexDefn
+	batchSize  int32
+	disable    int32
+
+	// snapshot of pending builds in build order, read by rest api
+	queueLock sync.RWMutex
+	queue     []common.IndexDefnId
 }
 
 type janitor struct {
@@ -103,6 +114,7 @@ func NewLifecycleMgr(notifier MetadataNotifier, clusterURL string) (*LifecycleMg
 		outgoings:    make(chan c.Packet, 1000),
 		killch:       make(chan bool),
 		bootstraps:   make(chan *requestHolder, 1000),
+		alters:       make(map[common.IndexDefnId]bool),
 		indexerReady: false}
 	mgr.builder = newBuilder(mgr)
 	mgr.janitor = newJanitor(mgr)
@@ -171,7 +183,8 @@ func (m *LifecycleMgr) OnNewRequest(fid string, request protocol.RequestMsg) {
 			if op == client.OPCODE_UPDATE_INDEX_INST ||
 				op == client.OPCODE_DELETE_BUCKET ||
 				op == client.OPCODE_CLEANUP_INDEX ||
-				op == client.OPCODE_RESET_INDEX {
+				op == client.OPCODE_RESET_INDEX ||
+				op == client.OPCODE_SWAP_ALTER_INDEX {
 				m.bootstraps <- req
 				return
 			}
@@ -277,6 +290,12 @@ func (m *LifecycleMgr) dispatchRequest(request *requestHolder, factory *message.
 		m.handleResetIndex(content)
 	case client.OPCODE_CONFIG_UPDATE:
 		m.handleConfigUpdate(content)
+	case client.OPCODE_ALTER_INDEX:
+		err = m.handleAlterIndex(content, common.NewUserRequestContext())
+	case client.OPCODE_SWAP_ALTER_INDEX:
+		err = m.handleSwapAlterIndex(content)
+	case client.OPCODE_RESUME_ALTER_INDEX:
+		err = m.handleResumeAlterIndex(key)
 	}
 
 	logging.Debugf("LifecycleMgr.dispatchRequest () : send response for requestId %d, op %d, len(result) %d", reqId, op, len(result))
@@ -411,12 +430,18 @@ func (m *LifecycleMgr) CreateIndex(defn *common.IndexDefn, scheduled bool,
 	replicaId := defn.ReplicaId
 	defn.ReplicaId = -1
 
+	// partitions hosted by this instance are kept in the topology, index
+	// definition is the same across the nodes hosting the index.
+	partitions := defn.Partitions
+	defn.Partitions = nil
+
 	// Create index definiton.   It will fail if there is another index defintion of the same
 	// index defnition id.
 	if err := m.repo.CreateIndex(defn); err != nil {
 		logging.Errorf("LifecycleMgr.handleCreateIndex() : createIndex fails. Reason = %v", err)
 		return err
 	}
+	defn.Partitions = partitions
 
 	// Create index instance
 	// If there is any dangling index instance of the same index name and bucket, this will first
@@ -737,6 +762,7 @@ func (m *LifecycleMgr) DeleteIndex(id common.IndexDefnId, notify bool,
 	}
 
 	m.repo.DropIndexById(defn.DefnId)
+	m.deletePendingAlter(defn.DefnId)
 
 	// If indexer crashes at this point, there is a chance topology may leave a orphan index
 	// instance.  But this index will consider invalid (state=DELETED + no index definition).
@@ -1007,6 +1033,272 @@ func (m *LifecycleMgr) handleResetIndex(content []byte) error {
 	return nil
 }
 
+//
+// handleAlterIndex changes the key expressions of an index without taking it offline.
+// Indexer builds a shadow instance of the altered definition, with a higher instance
+// version, while the current instance keeps serving scans.  Once the shadow instance
+// has caught up, indexer swaps it in and calls back with OPCODE_SWAP_ALTER_INDEX.
+// The alter is kept in local metadata till the swap, so that it is resumed if indexer
+// restarts before the shadow instance is swapped in.
+//
+func (m *LifecycleMgr) handleAlterIndex(content []byte, reqCtx *common.MetadataRequestContext) error {
+
+	defn, err := common.UnmarshallIndexDefn(content)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleAlterIndex() : Unable to unmarshall index definition. Reason = %v", err)
+		return err
+	}
+
+	if err := m.AlterIndex(defn, reqCtx); err != nil {
+		return err
+	}
+
+	if err := m.repo.SetLocalValue(alterIndexKey(defn.DefnId), string(content)); err != nil {
+		// Alter proceeds, it is not resumed if indexer restarts before the swap.
+		logging.Errorf("LifecycleMgr.handleAlterIndex() : Fail to save alter of index %v. Reason = %v", defn.DefnId, err)
+	}
+	return nil
+}
+
+//
+// handleResumeAlterIndex resumes the alter of an index interrupted by indexer restart.
+// Indexer removes the files of the interrupted shadow instance on bootstrap, the shadow
+// instance is built again from the start.  Janitor requests to resume pending alters
+// periodically, till indexer is able to build the shadow instance.
+//
+func (m *LifecycleMgr) handleResumeAlterIndex(key string) error {
+
+	id, err := indexDefnId(key)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleResumeAlterIndex() : resume alter index fails. Reason = %v", err)
+		return err
+	}
+
+	if m.alters[id] {
+		return nil
+	}
+
+	content, err := m.repo.GetLocalValue(alterIndexKey(id))
+	if err != nil {
+		// alter has completed
+		return nil
+	}
+
+	defn, err := common.UnmarshallIndexDefn([]byte(content))
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleResumeAlterIndex() : Unable to unmarshall index definition. Reason = %v", err)
+		m.deletePendingAlter(id)
+		return err
+	}
+
+	// Alter is abandoned if the index is dropped or it already has the altered keys.
+	oldDefn, err := m.repo.GetIndexDefnById(id)
+	if err != nil {
+		return err
+	}
+	if oldDefn == nil || validateAlterIndex(oldDefn, defn) != nil {
+		logging.Infof("LifecycleMgr.handleResumeAlterIndex() : index %v is dropped or already altered.", id)
+		m.deletePendingAlter(id)
+		return nil
+	}
+
+	logging.Infof("LifecycleMgr.handleResumeAlterIndex() : resume alter index (%v, %v)", oldDefn.Bucket, oldDefn.Name)
+
+	if err := m.AlterIndex(defn, common.NewUserRequestContext()); err != nil {
+		logging.Warnf("LifecycleMgr.handleResumeAlterIndex() : Fail to resume alter index (%v, %v).  Will retry.  Reason = %v",
+			oldDefn.Bucket, oldDefn.Name, err)
+		return err
+	}
+	return nil
+}
+
+//
+// AlterIndex asks indexer to build a shadow instance of the altered definition `defn`.
+//
+func (m *LifecycleMgr) AlterIndex(defn *common.IndexDefn, reqCtx *common.MetadataRequestContext) error {
+
+	oldDefn, err := m.repo.GetIndexDefnById(defn.DefnId)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.AlterIndex() : Fail to find index definition %v. Reason = %v", defn.DefnId, err)
+		return err
+	}
+	if oldDefn == nil {
+		return errors.New(fmt.Sprintf("Fail to alter index.  Index %v does not exist.", defn.DefnId))
+	}
+
+	if err := validateAlterIndex(oldDefn, defn); err != nil {
+		logging.Errorf("LifecycleMgr.AlterIndex() : Fail to alter index (%v, %v). Reason = %v", oldDefn.Bucket, oldDefn.Name, err)
+		return err
+	}
+
+	inst, err := m.FindLocalIndexInst(oldDefn.Bucket, oldDefn.DefnId)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.AlterIndex() : Fail to alter index (%v, %v). Reason = %v", oldDefn.Bucket, oldDefn.Name, err)
+		return err
+	}
+	if inst == nil {
+		return errors.New(fmt.Sprintf("Fail to alter index %s.%s.  Index instance does not exist.", oldDefn.Bucket, oldDefn.Name))
+	}
+
+	// The current instance must stay available for scans until the swap.  Instance under
+	// rebalance already has a copy with higher version.
+	if common.IndexState(inst.State) != common.INDEX_STATE_ACTIVE || inst.RState != uint32(common.REBAL_ACTIVE) {
+		return errors.New(fmt.Sprintf("Fail to alter index %s.%s.  Index is not active or is being rebalanced.",
+			oldDefn.Bucket, oldDefn.Name))
+	}
+
+	// Start from the current definition so only the key expressions can change.
+	shadow := oldDefn.Clone()
+	shadow.SecExprs = defn.SecExprs
+	shadow.Desc = defn.Desc
+	shadow.IsArrayIndex = defn.IsArrayIndex
+	shadow.InstVersion = int(inst.Version) + 1
+	shadow.ReplicaId = int(inst.ReplicaId)
+	if shadow.InstId, err = common.NewIndexInstId(); err != nil {
+		return err
+	}
+
+	logging.Infof("LifecycleMgr.AlterIndex() : alter index (%v, %v) inst %v with shadow inst %v version %v",
+		shadow.Bucket, shadow.Name, inst.InstId, shadow.InstId, shadow.InstVersion)
+
+	if m.notifier != nil {
+		if err := m.notifier.OnIndexAlter(shadow, common.IndexInstId(inst.InstId), reqCtx); err != nil {
+			logging.Errorf("LifecycleMgr.AlterIndex() : Fail to alter index (%v, %v). Reason = %v", shadow.Bucket, shadow.Name, err)
+			return err
+		}
+	}
+
+	m.alters[defn.DefnId] = true
+	return nil
+}
+
+//
+// Pending alter of an index is kept in local metadata under alterIndexKey.
+//
+func alterIndexKey(defnId common.IndexDefnId) string {
+	return fmt.Sprintf("AlterIndex/%v", defnId)
+}
+
+func (m *LifecycleMgr) deletePendingAlter(defnId common.IndexDefnId) {
+
+	delete(m.alters, defnId)
+
+	if _, err := m.repo.GetLocalValue(alterIndexKey(defnId)); err != nil {
+		return
+	}
+	if err := m.repo.DeleteLocalValue(alterIndexKey(defnId)); err != nil {
+		logging.Errorf("LifecycleMgr.deletePendingAlter() : Fail to delete alter of index %v. Reason = %v", defnId, err)
+	}
+}
+
+//
+// validateAlterIndex checks that the altered definition only changes the key
+// expressions of the index.
+//
+func validateAlterIndex(oldDefn, defn *common.IndexDefn) error {
+
+	if oldDefn.IsPrimary {
+		return errors.New("Alter index is not supported for primary index.")
+	}
+
+	if oldDefn.Expiry {
+		return errors.New("Alter index is not supported for expiry index.")
+	}
+
+	if (len(defn.Bucket) != 0 && defn.Bucket != oldDefn.Bucket) ||
+		(len(defn.Name) != 0 && defn.Name != oldDefn.Name) {
+		return errors.New("Alter index cannot change index name or bucket.")
+	}
+
+	if len(defn.SecExprs) == 0 {
+		return errors.New("Alter index requires at least one index key.")
+	}
+
+	if len(defn.Desc) != 0 && len(defn.Desc) != len(defn.SecExprs) {
+		return errors.New("Alter index has mismatched number of index keys and key orders.")
+	}
+
+	if defn.IsArrayIndex && len(oldDefn.Include) != 0 {
+		return errors.New("Alter index cannot change an index with include columns to array index.")
+	}
+
+	if reflect.DeepEqual(oldDefn.SecExprs, defn.SecExprs) && reflect.DeepEqual(oldDefn.Desc, defn.Desc) {
+		return errors.New("Alter index does not change the index keys.")
+	}
+
+	return nil
+}
+
+//
+// handleSwapAlterIndex makes the shadow instance of an altered index the local
+// instance of the index.  Indexer has already switched scans to the shadow instance.
+// The definition is updated before the instance so that a client never sees
+// the new instance with the old key expressions.
+//
+func (m *LifecycleMgr) handleSwapAlterIndex(content []byte) error {
+
+	defn, err := common.UnmarshallIndexDefn(content)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleSwapAlterIndex() : Unable to unmarshall index definition. Reason = %v", err)
+		return err
+	}
+
+	oldDefn, err := m.repo.GetIndexDefnById(defn.DefnId)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleSwapAlterIndex() : Fail to find index definition %v. Reason = %v", defn.DefnId, err)
+		return err
+	}
+	if oldDefn == nil {
+		logging.Warnf("LifecycleMgr.handleSwapAlterIndex() : index %v does not exist. Skip swap.", defn.DefnId)
+		return nil
+	}
+
+	topology, err := m.repo.GetTopologyByBucket(oldDefn.Bucket)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleSwapAlterIndex() : Fail to swap index (%v, %v). Reason = %v", oldDefn.Bucket, oldDefn.Name, err)
+		return err
+	}
+
+	var inst *IndexInstDistribution
+	if topology != nil {
+		inst = topology.GetIndexInstByDefn(oldDefn.DefnId)
+	}
+	if inst == nil || common.IndexState(inst.State) == common.INDEX_STATE_DELETED {
+		logging.Warnf("LifecycleMgr.handleSwapAlterIndex() : index (%v, %v) instance does not exist or is deleted. Skip swap.",
+			oldDefn.Bucket, oldDefn.Name)
+		return nil
+	}
+	if inst.Version >= uint64(defn.InstVersion) {
+		logging.Warnf("LifecycleMgr.handleSwapAlterIndex() : index (%v, %v) instance version %v is not older than %v. Skip swap.",
+			oldDefn.Bucket, oldDefn.Name, inst.Version, defn.InstVersion)
+		m.deletePendingAlter(defn.DefnId)
+		return nil
+	}
+
+	if err := m.repo.UpdateIndex(defn); err != nil {
+		logging.Errorf("LifecycleMgr.handleSwapAlterIndex() : Fail to swap index (%v, %v). Reason = %v", oldDefn.Bucket, oldDefn.Name, err)
+		return err
+	}
+
+	topology.UpdateInstIdForIndexInstByDefn(defn.DefnId, uint64(defn.InstId), uint64(defn.InstVersion))
+	topology.UpdateStateForIndexInstByDefn(defn.DefnId, common.INDEX_STATE_ACTIVE)
+	topology.UpdateRebalanceStateForIndexInstByDefn(defn.DefnId, common.REBAL_ACTIVE)
+	topology.UpdateStreamForIndexInstByDefn(defn.DefnId, common.MAINT_STREAM)
+	topology.SetErrorForIndexInstByDefn(defn.DefnId, "")
+
+	if err := m.repo.SetTopologyByBucket(oldDefn.Bucket, topology); err != nil {
+		// Topology update is in place.  If there is any error, SetTopologyByBucket will purge the cache copy.
+		logging.Errorf("LifecycleMgr.handleSwapAlterIndex() : index instance (%v, %v) update fails. Reason = %v", oldDefn.Bucket, oldDefn.Name, err)
+		return err
+	}
+
+	m.deletePendingAlter(defn.DefnId)
+
+	logging.Infof("LifecycleMgr.handleSwapAlterIndex() : index (%v, %v) swapped to inst %v version %v",
+		oldDefn.Bucket, oldDefn.Name, defn.InstId, defn.InstVersion)
+	return nil
+}
+
 func (m *LifecycleMgr) handleConfigUpdate(content []byte) error {
 
 	config := new(common.Config)
@@ -1325,7 +1617,7 @@ func (m *janitor) cleanup() {
 	//
 	logging.Infof("janitor: running cleanup.")
 
-	entries, err := metakv.ListAllChildren(client.DeleteDDLCommandTokenPath)
+	entries, err := common.Metakv().ListAllChildren(client.DeleteDDLCommandTokenPath)
 	if err != nil {
 		logging.Warnf("janitor: Fail to drop index upon cleanup.  Internal Error = %v", err)
 		return
@@ -1390,6 +1682,14 @@ func (m *janitor) cleanup() {
 				logging.Infof("janitor: Clean up deleted index (%v, %v) during periodic cleanup ", defn.Bucket, defn.Name)
 			}
 		}
+
+		// Resume alter of index interrupted by indexer restart.  Request is ignored if the alter
+		// is still in progress.
+		if _, err := m.manager.repo.GetLocalValue(alterIndexKey(defn.DefnId)); err == nil {
+			if err := m.manager.requestServer.MakeAsyncRequest(client.OPCODE_RESUME_ALTER_INDEX, fmt.Sprintf("%v", defn.DefnId), nil); err != nil {
+				logging.Warnf("janitor: Fail to resume alter index (%v, %v).  Internal Error = %v.", defn.Bucket, defn.Name, err)
+			}
+		}
 	}
 }
 
@@ -1439,6 +1739,7 @@ func (s *builder) run() {
 		case defn := <-s.notifych:
 			logging.Infof("builder:  Received new index build request %v.  Schedule to build index for bucket %v", defn.DefnId, defn.Bucket)
 			s.addPending(defn.Bucket, uint64(defn.DefnId))
+			s.refreshQueue()
 
 		case <-ticker.C:
 			s.processBuildToken(false)
@@ -1453,6 +1754,7 @@ func (s *builder) run() {
 			for _, bucket := range buildList {
 				quota = s.tryBuildIndex(bucket, quota)
 			}
+			s.refreshQueue()
 
 		case <-s.manager.killch:
 			logging.Infof("builder: Index builder terminates.")
@@ -1473,35 +1775,57 @@ func (s *builder) getBuildList() ([]string, int32) {
 		}
 	}
 
-	// sort buildList by ascending order
-	for i := 0; i < len(buildList)-1; i++ {
-		for j := i + 1; j < len(buildList); j++ {
-			bucket_i := buildList[i]
-			bucket_j := buildList[j]
+	s.sortBuildList(buildList, quota)
+	return buildList, quota
+}
 
-			if len(s.pendings[bucket_i]) < len(s.pendings[bucket_j]) {
-				tmp := buildList[i]
-				buildList[i] = buildList[j]
-				buildList[j] = tmp
-			}
-		}
-	}
+//
+// sortBuildList orders buckets by the highest priority index pending for the bucket,
+// then by closest to quota, then by the number of pending indexes.  Sorts are stable,
+// so each sort keeps the order of the previous sort for buckets that are equal.
+//
+func (s *builder) sortBuildList(buildList []string, quota int32) {
 
-	// sort buildList based on closest to quota
-	for i := 0; i < len(buildList)-1; i++ {
-		for j := i + 1; j < len(buildList); j++ {
-			bucket_i := buildList[i]
-			bucket_j := buildList[j]
+	sort.Stable(&bucketSorter{buckets: buildList, less: func(i, j string) bool {
+		return len(s.pendings[i]) > len(s.pendings[j])
+	}})
 
-			if math.Abs(float64(len(s.pendings[bucket_i])-int(quota))) > math.Abs(float64(len(s.pendings[bucket_j])-int(quota))) {
-				tmp := buildList[i]
-				buildList[i] = buildList[j]
-				buildList[j] = tmp
-			}
-		}
-	}
+	sort.Stable(&bucketSorter{buckets: buildList, less: func(i, j string) bool {
+		return math.Abs(float64(len(s.pendings[i])-int(quota))) < math.Abs(float64(len(s.pendings[j])-int(quota)))
+	}})
 
-	return buildList, quota
+	// pending list of each bucket is kept in descending order of priority.
+	sort.Stable(&bucketSorter{buckets: buildList, less: func(i, j string) bool {
+		return s.topPriority(i) > s.topPriority(j)
+	}})
+}
+
+//
+// bucketSorter sorts bucket names with the given less function.
+//
+type bucketSorter struct {
+	buckets []string
+	less    func(i, j string) bool
+}
+
+func (b *bucketSorter) Len() int {
+	return len(b.buckets)
+}
+
+func (b *bucketSorter) Less(i, j int) bool {
+	return b.less(b.buckets[i], b.buckets[j])
+}
+
+func (b *bucketSorter) Swap(i, j int) {
+	b.buckets[i], b.buckets[j] = b.buckets[j], b.buckets[i]
+}
+
+func (s *builder) topPriority(bucket string) int {
+
+	if len(s.pendings[bucket]) == 0 {
+		return 0
+	}
+	return s.priorities[s.pendings[bucket][0]]
 }
 
 func (s *builder) addPending(bucket string, id uint64) bool {
@@ -1512,10 +1836,79 @@ func (s *builder) addPending(bucket string, id uint64) bool {
 		}
 	}
 
-	s.pendings[bucket] = append(s.pendings[bucket], uint64(id))
+	priority := 0
+	if defn, err := s.manager.repo.GetIndexDefnById(common.IndexDefnId(id)); err == nil && defn != nil {
+		priority = defn.BuildPriority
+	}
+	s.priorities[id] = priority
+
+	// index with higher priority is built first.  Index with same
+	// priority is built in the order they are scheduled.
+	pendings := s.pendings[bucket]
+	pos := len(pendings)
+	for i, id2 := range pendings {
+		if s.priorities[id2] < priority {
+			pos = i
+			break
+		}
+	}
+
+	pendings = append(pendings, 0)
+	copy(pendings[pos+1:], pendings[pos:])
+	pendings[pos] = id
+	s.pendings[bucket] = pendings
+
 	return true
 }
 
+//
+// Take a snapshot of the pending index builds of this node in the order
+// they are going to be built, across buckets.
+//
+func (s *builder) refreshQueue() {
+
+	buckets := make([]string, 0, len(s.pendings))
+	for bucket, _ := range s.pendings {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+
+	queue := make(buildQueue, 0)
+	for _, bucket := range buckets {
+		for _, defnId := range s.pendings[bucket] {
+			queue = append(queue, buildQueueEntry{defnId: common.IndexDefnId(defnId), priority: s.priorities[defnId]})
+		}
+	}
+
+	for defnId, _ := range s.priorities {
+		if !queue.contains(common.IndexDefnId(defnId)) {
+			delete(s.priorities, defnId)
+		}
+	}
+
+	// index with same priority keeps the order it is scheduled in
+	sort.Stable(queue)
+
+	result := make([]common.IndexDefnId, len(queue))
+	for i, entry := range queue {
+		result[i] = entry.defnId
+	}
+
+	s.queueLock.Lock()
+	defer s.queueLock.Unlock()
+	s.queue = result
+}
+
+//
+// Return the pending index builds of this node in build order.
+//
+func (s *builder) getBuildQueue() []common.IndexDefnId {
+
+	s.queueLock.RLock()
+	defer s.queueLock.RUnlock()
+	return s.queue
+}
+
 func (s *builder) tryBuildIndex(bucket string, quota int32) int32 {
 
 	newQuota := quota
@@ -1645,7 +2038,7 @@ func (s *builder) getQuota() (int32, map[string]bool) {
 
 func (s *builder) processBuildToken(bootstrap bool) {
 
-	entries, err := metakv.ListAllChildren(client.BuildDDLCommandTokenPath)
+	entries, err := common.Metakv().ListAllChildren(client.BuildDDLCommandTokenPath)
 	if err != nil {
 		logging.Warnf("builder: Fail to get command token from metakv.  Internal Error = %v", err)
 		entries = nil
@@ -1763,10 +2156,11 @@ func (s *builder) disableBuild() bool {
 func newBuilder(mgr *LifecycleMgr) *builder {
 
 	builder := &builder{
-		manager:   mgr,
-		pendings:  make(map[string][]uint64),
-		notifych:  make(chan *common.IndexDefn, 10000),
-		batchSize: int32(common.SystemConfig["indexer.settings.build.batch_size"].Int()),
+		manager:    mgr,
+		pendings:   make(map[string][]uint64),
+		priorities: make(map[uint64]int),
+		notifych:   make(chan *common.IndexDefn, 10000),
+		batchSize:  int32(common.SystemConfig["indexer.settings.build.batch_size"].Int()),
 	}
 
 	disable := common.SystemConfig["indexer.build.background.disable"].Bool()
@@ -1778,6 +2172,26 @@ func newBuilder(mgr *LifecycleMgr) *builder {
 	return builder
 }
 
+type buildQueueEntry struct {
+	defnId   common.IndexDefnId
+	priority int
+}
+
+type buildQueue []buildQueueEntry
+
+func (q buildQueue) Len() int           { return len(q) }
+func (q buildQueue) Less(i, j int) bool { return q[i].priority > q[j].priority }
+func (q buildQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q buildQueue) contains(defnId common.IndexDefnId) bool {
+	for _, entry := range q {
+		if entry.defnId == defnId {
+			return true
+		}
+	}
+	return false
+}
+
 //////////////////////////////////////////////////////////////
 // Lifecycle Mgr - udpator
 //////////////////////////////////////////////////////////////
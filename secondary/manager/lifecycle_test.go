@@ -0,0 +1,102 @@
+package manager
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestValidateAlterIndex(t *testing.T) {
+	oldDefn := &common.IndexDefn{
+		DefnId:   common.IndexDefnId(1),
+		Bucket:   "default",
+		Name:     "idx",
+		SecExprs: []string{"`a`", "`b`"},
+		Desc:     []bool{false, false},
+	}
+
+	tests := []struct {
+		defn  common.IndexDefn
+		valid bool
+	}{
+		{common.IndexDefn{SecExprs: []string{"`a`", "`c`"}}, true},
+		{common.IndexDefn{Bucket: "default", Name: "idx", SecExprs: []string{"`a`"}}, true},
+		{common.IndexDefn{SecExprs: []string{"`a`", "`b`"}, Desc: []bool{false, true}}, true},
+		{common.IndexDefn{Bucket: "other", SecExprs: []string{"`a`"}}, false},
+		{common.IndexDefn{Name: "other", SecExprs: []string{"`a`"}}, false},
+		{common.IndexDefn{}, false},
+		{common.IndexDefn{SecExprs: []string{"`a`", "`c`"}, Desc: []bool{false}}, false},
+		{common.IndexDefn{SecExprs: []string{"`a`", "`b`"}, Desc: []bool{false, false}}, false},
+	}
+	for i, test := range tests {
+		err := validateAlterIndex(oldDefn, &test.defn)
+		if (err == nil) != test.valid {
+			t.Errorf("test %v: expected valid %v, received %v", i, test.valid, err)
+		}
+	}
+
+	primary := &common.IndexDefn{Bucket: "default", Name: "#primary", IsPrimary: true}
+	if err := validateAlterIndex(primary, &common.IndexDefn{SecExprs: []string{"`a`"}}); err == nil {
+		t.Errorf("expected alter of primary index to fail")
+	}
+}
+
+func TestSwapAlteredIndexTopology(t *testing.T) {
+	topology := &IndexTopology{
+		Bucket: "default",
+		Definitions: []IndexDefnDistribution{{
+			Bucket: "default",
+			Name:   "idx",
+			DefnId: 1,
+			Instances: []IndexInstDistribution{{
+				InstId:  10,
+				State:   uint32(common.INDEX_STATE_ACTIVE),
+				RState:  uint32(common.REBAL_ACTIVE),
+				Version: 0,
+			}},
+		}},
+	}
+
+	// instance of the index is replaced by the shadow instance.
+	if !topology.UpdateInstIdForIndexInstByDefn(common.IndexDefnId(1), 20, 1) {
+		t.Fatalf("expected topology to change")
+	}
+	inst := topology.GetIndexInstByDefn(common.IndexDefnId(1))
+	if inst.InstId != 20 || inst.Version != 1 {
+		t.Fatalf("unexpected instance %v version %v", inst.InstId, inst.Version)
+	}
+	if common.IndexState(inst.State) != common.INDEX_STATE_ACTIVE {
+		t.Fatalf("unexpected state %v", inst.State)
+	}
+
+	// swap is idempotent.
+	if topology.UpdateInstIdForIndexInstByDefn(common.IndexDefnId(1), 20, 1) {
+		t.Fatalf("unexpected change to topology")
+	}
+	if topology.UpdateInstIdForIndexInstByDefn(common.IndexDefnId(2), 30, 1) {
+		t.Fatalf("unexpected change for unknown index")
+	}
+}
+
+func TestSortBuildList(t *testing.T) {
+	s := &builder{
+		pendings: map[string][]uint64{
+			"b1": {1},
+			"b2": {2, 3, 4},
+			"b3": {5, 6},
+			"b4": {7, 8, 9, 10},
+			"b5": {11},
+		},
+		priorities: map[uint64]int{11: 5},
+	}
+
+	// closest to quota of 2 first, more pending indexes first for
+	// buckets equally close to quota, index with priority goes first.
+	buildList := []string{"b1", "b2", "b3", "b4", "b5"}
+	s.sortBuildList(buildList, 2)
+	expected := []string{"b5", "b3", "b2", "b1", "b4"}
+	if !reflect.DeepEqual(buildList, expected) {
+		t.Fatalf("expected %v, received %v", expected, buildList)
+	}
+}
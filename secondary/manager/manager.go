@@ -93,6 +93,7 @@ type MetadataNotifier interface {
 	OnIndexCreate(*common.IndexDefn, common.IndexInstId, int, *common.MetadataRequestContext) error
 	OnIndexDelete(common.IndexInstId, string, *common.MetadataRequestContext) error
 	OnIndexBuild([]common.IndexInstId, []string, *common.MetadataRequestContext) map[common.IndexInstId]error
+	OnIndexAlter(*common.IndexDefn, common.IndexInstId, *common.MetadataRequestContext) error
 	OnFetchStats() error
 }
 
@@ -491,6 +492,22 @@ func (m *IndexManager) ResetIndex(index common.IndexDefn) error {
 	return m.requestServer.MakeRequest(client.OPCODE_RESET_INDEX, fmt.Sprintf("%v", index.DefnId), content)
 }
 
+//
+// SwapAlteredIndex makes the shadow instance built for an altered index
+// definition the local instance of the index.  The definition carries the
+// shadow instance id and version.
+//
+func (m *IndexManager) SwapAlteredIndex(index common.IndexDefn) error {
+
+	content, err := common.MarshallIndexDefn(&index)
+	if err != nil {
+		return err
+	}
+
+	logging.Debugf("IndexManager.SwapAlteredIndex(): making request for swapping altered index")
+	return m.requestServer.MakeAsyncRequest(client.OPCODE_SWAP_ALTER_INDEX, fmt.Sprintf("%v", index.DefnId), content)
+}
+
 func (m *IndexManager) DeleteIndexForBucket(bucket string, streamId common.StreamId) error {
 
 	logging.Debugf("IndexManager.DeleteIndexForBucket(): making request for deleting index for bucket")
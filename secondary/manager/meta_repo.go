@@ -1061,10 +1061,15 @@ func (m *MetadataRepo) addIndexToTopology(defn *common.IndexDefn, instId common.
 		rState = uint32(common.REBAL_PENDING)
 	}
 
+	var partitions []uint64
+	for _, partnId := range defn.Partitions {
+		partitions = append(partitions, uint64(partnId))
+	}
+
 	topology.AddIndexDefinition(defn.Bucket, defn.Name, uint64(defn.DefnId),
 		uint64(instId), uint32(common.INDEX_STATE_CREATED), string(indexerId),
 		uint64(defn.InstVersion), rState, uint64(replicaId), scheduled,
-		string(defn.Using))
+		string(defn.Using), partitions)
 
 	// Add a reference of the bucket-level topology to the global topology.
 	// If it fails later to create bucket-level topology, it will have
@@ -63,11 +63,12 @@ type IndexResponse struct {
 //
 
 type LocalIndexMetadata struct {
-	IndexerId        string             `json:"indexerId,omitempty"`
-	NodeUUID         string             `json:"nodeUUID,omitempty"`
-	StorageMode      string             `json:"storageMode,omitempty"`
-	IndexTopologies  []IndexTopology    `json:"topologies,omitempty"`
-	IndexDefinitions []common.IndexDefn `json:"definitions,omitempty"`
+	IndexerId        string               `json:"indexerId,omitempty"`
+	NodeUUID         string               `json:"nodeUUID,omitempty"`
+	StorageMode      string               `json:"storageMode,omitempty"`
+	IndexTopologies  []IndexTopology      `json:"topologies,omitempty"`
+	IndexDefinitions []common.IndexDefn   `json:"definitions,omitempty"`
+	BuildQueue       []common.IndexDefnId `json:"buildQueue,omitempty"`
 }
 
 type ClusterIndexMetadata struct {
@@ -114,6 +115,8 @@ type IndexStatus struct {
 	Completion int                `json:"completion"`
 	Progress   float64            `json:"progress"`
 	Scheduled  bool               `json:"scheduled"`
+	Priority   int                `json:"buildPriority,omitempty"`
+	QueuePos   int                `json:"queuePosition,omitempty"`
 }
 
 type indexStatusSorter []IndexStatus
@@ -509,6 +512,17 @@ func (m *requestHandlerContext) getIndexStatus(creds cbauth.Creds, bucket string
 								Completion: completion,
 								Progress:   progress,
 								Scheduled:  instance.Scheduled,
+								Priority:   defn.BuildPriority,
+							}
+
+							// position of scheduled index in the build queue of its indexer node
+							if state == common.INDEX_STATE_READY && instance.Scheduled {
+								for i, defnId := range localMeta.BuildQueue {
+									if defnId == defn.DefnId {
+										status.QueuePos = i + 1
+										break
+									}
+								}
 							}
 
 							list = append(list, status)
@@ -765,6 +779,10 @@ func (m *requestHandlerContext) getLocalIndexMetadata(creds cbauth.Creds, bucket
 		topology, err = iter1.Next()
 	}
 
+	if len(bucket) == 0 {
+		meta.BuildQueue = m.mgr.getLifecycleMgr().builder.getBuildQueue()
+	}
+
 	return meta, nil
 }
 
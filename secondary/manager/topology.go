@@ -118,7 +118,7 @@ func (g *GlobalTopology) RemoveTopologyKey(key string) {
 // Add an index definition to Topology.
 //
 func (t *IndexTopology) AddIndexDefinition(bucket string, name string, defnId uint64, instId uint64, state uint32, indexerId string,
-	instVersion uint64, rState uint32, replicaId uint64, scheduled bool, storageMode string) {
+	instVersion uint64, rState uint32, replicaId uint64, scheduled bool, storageMode string, partitions []uint64) {
 
 	t.RemoveIndexDefinition(bucket, name)
 
@@ -127,9 +127,18 @@ func (t *IndexTopology) AddIndexDefinition(bucket string, name string, defnId ui
 	slice.IndexerId = indexerId
 	slice.State = state
 
-	part := new(IndexPartDistribution)
-	part.PartId = 0
-	part.SinglePartition.Slices = append(part.SinglePartition.Slices, *slice)
+	// partitions hosted by the indexer, a single partition 0 unless
+	// the index is partitioned.
+	if len(partitions) == 0 {
+		partitions = []uint64{0}
+	}
+	var parts []IndexPartDistribution
+	for _, partId := range partitions {
+		part := new(IndexPartDistribution)
+		part.PartId = partId
+		part.SinglePartition.Slices = append(part.SinglePartition.Slices, *slice)
+		parts = append(parts, *part)
+	}
 
 	inst := new(IndexInstDistribution)
 	inst.InstId = instId
@@ -139,7 +148,7 @@ func (t *IndexTopology) AddIndexDefinition(bucket string, name string, defnId ui
 	inst.ReplicaId = replicaId
 	inst.Scheduled = scheduled
 	inst.StorageMode = storageMode
-	inst.Partitions = append(inst.Partitions, *part)
+	inst.Partitions = parts
 
 	defn := new(IndexDefnDistribution)
 	defn.Bucket = bucket
@@ -286,6 +295,30 @@ func (t *IndexTopology) UpdateRebalanceStateForIndexInstByDefn(defnId common.Ind
 	return changed
 }
 
+//
+// Replace the instance id and version of the instance, e.g. when the
+// instance built for an altered index definition takes over
+//
+func (t *IndexTopology) UpdateInstIdForIndexInstByDefn(defnId common.IndexDefnId, instId uint64, version uint64) bool {
+
+	changed := false
+	for i, _ := range t.Definitions {
+		if t.Definitions[i].DefnId == uint64(defnId) {
+			for j, _ := range t.Definitions[i].Instances {
+				if t.Definitions[i].Instances[j].InstId != instId ||
+					t.Definitions[i].Instances[j].Version != version {
+					logging.Debugf("IndexTopology.UpdateInstIdForIndexInstByDefn(): Update index '%v' inst '%v' to inst '%v' version '%v'",
+						defnId, t.Definitions[i].Instances[j].InstId, instId, version)
+					t.Definitions[i].Instances[j].InstId = instId
+					t.Definitions[i].Instances[j].Version = version
+					changed = true
+				}
+			}
+		}
+	}
+	return changed
+}
+
 //
 // Update Storage Mode on instance
 //
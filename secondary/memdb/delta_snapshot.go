@@ -0,0 +1,347 @@
+package memdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/couchbase/indexing/secondary/memdb/skiplist"
+)
+
+// A delta snapshot persists only the items inserted and deleted since an
+// earlier persisted snapshot, its parent. Its manifest refers the parent
+// directory, which may itself be a delta snapshot. LoadFromDisk restores
+// the full snapshot at the root of the chain and replays every delta on
+// top of it, in order.
+
+var (
+	ErrInvalidDeltaBase     = fmt.Errorf("Delta base snapshot is not older than snapshot")
+	ErrCorruptDeltaSnapshot = fmt.Errorf("Deleted item of delta snapshot not found")
+	ErrSnapshotChainTooLong = fmt.Errorf("Snapshot chain too long")
+)
+
+const maxSnapshotChainLen = 1024
+
+type diskManifest struct {
+	Version int    `json:"version"`
+	Parent  string `json:"parent,omitempty"`
+}
+
+func readManifest(dir string) (diskManifest, error) {
+	var manifest diskManifest
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "nitro.json"))
+	if err == nil {
+		err = json.Unmarshal(bs, &manifest)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+
+	return manifest, err
+}
+
+// SnapshotChain returns the directories of persisted snapshots needed to
+// restore the snapshot persisted in dir, starting with the full snapshot.
+func SnapshotChain(dir string) ([]string, error) {
+	chain := []string{dir}
+	for {
+		manifest, err := readManifest(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		if manifest.Parent == "" {
+			return chain, nil
+		}
+
+		if len(chain) >= maxSnapshotChainLen {
+			return nil, ErrSnapshotChainTooLong
+		}
+
+		parent := manifest.Parent
+		if !filepath.IsAbs(parent) {
+			parent = filepath.Join(filepath.Dir(dir), parent)
+		}
+		dir = parent
+		chain = append([]string{dir}, chain...)
+	}
+}
+
+// StoreDeltaToDisk persists items inserted and deleted between base and
+// snap into dir, as a delta of the snapshot persisted in parent. base
+// should be the snapshot which was persisted in parent and it should be
+// kept open until snap is persisted, unlike snap which is closed on
+// return. A relative parent is relative to the directory containing dir.
+// itmCallback is invoked for the inserted items and delCallback for the
+// deleted items, which are held back from garbage collection as long as
+// base is open.
+func (m *MemDB) StoreDeltaToDisk(dir, parent string, base, snap *Snapshot,
+	concurr int, itmCallback, delCallback ItemCallback) (err error) {
+
+	defer snap.Close()
+
+	if base.sn >= snap.sn {
+		return ErrInvalidDeltaBase
+	}
+
+	if m.useMemoryMgmt {
+		m.shutdownWg1.Add(1)
+		defer m.shutdownWg1.Done()
+	}
+
+	shards := runtime.NumCPU()
+	datadir := filepath.Join(dir, "data")
+	deleteddir := filepath.Join(dir, "deleted")
+	os.MkdirAll(datadir, 0755)
+	os.MkdirAll(deleteddir, 0755)
+
+	writers := make([]FileWriter, shards)
+	deletedWriters := make([]FileWriter, shards)
+	files := make([]string, shards)
+	defer func() {
+		for i := 0; i < shards; i++ {
+			if writers[i] != nil {
+				writers[i].Close()
+			}
+			if deletedWriters[i] != nil {
+				deletedWriters[i].Close()
+			}
+		}
+	}()
+
+	for shard := 0; shard < shards; shard++ {
+		file := fmt.Sprintf("shard-%d", shard)
+		w := m.newFileWriter(m.fileType)
+		if err := w.Open(filepath.Join(datadir, file)); err != nil {
+			return err
+		}
+		writers[shard] = w
+
+		dw := m.newFileWriter(m.fileType)
+		if err := dw.Open(filepath.Join(deleteddir, file)); err != nil {
+			return err
+		}
+		deletedWriters[shard] = dw
+
+		files[shard] = file
+	}
+
+	visitorCallback := func(itm *Item, shard int) error {
+		if m.hasShutdown {
+			return ErrShutdown
+		}
+
+		if itm.bornSn <= base.sn {
+			if err := deletedWriters[shard].WriteItem(itm); err != nil {
+				return err
+			}
+
+			if delCallback != nil {
+				delCallback(&ItemEntry{itm: itm, n: nil})
+			}
+
+			return nil
+		}
+
+		if err := writers[shard].WriteItem(itm); err != nil {
+			return err
+		}
+
+		if itmCallback != nil {
+			itmCallback(&ItemEntry{itm: itm, n: nil})
+		}
+
+		return nil
+	}
+
+	manifest, _ := json.Marshal(diskManifest{Version: version, Parent: parent})
+	if err = ioutil.WriteFile(filepath.Join(dir, "nitro.json"), manifest, 0660); err != nil {
+		return err
+	}
+
+	if err = m.visitor(snap, base, visitorCallback, shards, concurr); err != nil {
+		return err
+	}
+
+	bs, _ := json.Marshal(files)
+	if err = ioutil.WriteFile(filepath.Join(datadir, "files.json"), bs, 0660); err == nil {
+		err = ioutil.WriteFile(filepath.Join(deleteddir, "files.json"), bs, 0660)
+	}
+
+	return err
+}
+
+// loadChainFromDisk restores the full snapshot at the head of chain and
+// replays the delta snapshots which follow. Since replay can delete items
+// restored earlier, callb is invoked only after all deltas are replayed.
+func (m *MemDB) loadChainFromDisk(chain []string, concurr int, callb ItemCallback) error {
+	if err := m.loadFromDisk(chain[0], concurr, nil); err != nil {
+		return err
+	}
+
+	for _, dir := range chain[1:] {
+		if err := m.loadDeltaFromDisk(dir, concurr); err != nil {
+			return err
+		}
+	}
+
+	if callb != nil {
+		buf := m.store.MakeBuf()
+		defer m.store.FreeBuf(buf)
+
+		iter := m.store.NewIterator(m.iterCmp, buf)
+		defer iter.Close()
+		for iter.SeekFirst(); iter.Valid(); iter.Next() {
+			n := iter.GetNode()
+			callb(&ItemEntry{itm: (*Item)(n.Item()), n: n})
+		}
+	}
+
+	return nil
+}
+
+// loadDeltaFromDisk removes the deleted items of delta snapshot in dir
+// and then adds its inserted items.
+func (m *MemDB) loadDeltaFromDisk(dir string, concurr int) error {
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	writers := make([]*Writer, concurr)
+	freelists := make([]*skiplist.Node, concurr)
+	for i := 0; i < concurr; i++ {
+		writers[i] = m.newWriter()
+	}
+
+	deleteItem := func(itm *Item, id int) error {
+		w := writers[id]
+		defer m.freeItem(itm)
+
+		iter := m.store.NewIterator(m.iterCmp, w.buf)
+		found := iter.SeekWithCmp(unsafe.Pointer(itm), m.insCmp, m.existCmp)
+		n := iter.GetNode()
+		iter.Close()
+
+		if !found || !m.store.DeleteNode(n, m.insCmp, w.buf, &w.slSts1) {
+			return ErrCorruptDeltaSnapshot
+		}
+
+		// Nodes are freed once all workers are done
+		n.GClink = freelists[id]
+		freelists[id] = n
+		return nil
+	}
+
+	insertItem := func(itm *Item, id int) error {
+		w := writers[id]
+		if _, success := w.store.Insert2(unsafe.Pointer(itm),
+			w.insCmp, w.existCmp, w.buf, w.rand.Float32, &w.slSts1); !success {
+			m.freeItem(itm)
+		}
+		return nil
+	}
+
+	err = m.replayFiles(filepath.Join(dir, "deleted"), manifest.Version, deleteItem, writers)
+	for id, w := range writers {
+		for n := freelists[id]; n != nil; {
+			dnode := n
+			n = n.GClink
+
+			m.freeItem((*Item)(dnode.Item()))
+			m.store.FreeNode(dnode, &w.slSts1)
+		}
+	}
+
+	if err == nil {
+		err = m.replayFiles(filepath.Join(dir, "data"), manifest.Version, insertItem, writers)
+	}
+
+	for _, w := range writers {
+		m.store.Stats.Merge(&w.slSts1)
+	}
+
+	return err
+}
+
+// replayFiles invokes fn for every item in the files listed in datadir,
+// reading files concurrently with one worker per writer.
+func (m *MemDB) replayFiles(datadir string, ver int,
+	fn func(itm *Item, id int) error, writers []*Writer) error {
+
+	var wg sync.WaitGroup
+	var files []string
+
+	if bs, err := ioutil.ReadFile(filepath.Join(datadir, "files.json")); err != nil {
+		return err
+	} else if err = json.Unmarshal(bs, &files); err != nil {
+		return err
+	}
+
+	wchan := make(chan int)
+	readers := make([]FileReader, len(files))
+	errors := make([]error, len(files))
+
+	defer func() {
+		for _, r := range readers {
+			if r != nil {
+				r.Close()
+			}
+		}
+	}()
+
+	for i, file := range files {
+		r := m.newFileReader(m.fileType, ver)
+		if err := r.Open(filepath.Join(datadir, file)); err != nil {
+			return err
+		}
+
+		readers[i] = r
+	}
+
+	for i := range writers {
+		wg.Add(1)
+		go func(wg *sync.WaitGroup, id int) {
+			defer wg.Done()
+
+			for shard := range wchan {
+				r := readers[shard]
+			loop:
+				for {
+					itm, err := r.ReadItem()
+					if err != nil {
+						errors[shard] = err
+						break loop
+					}
+
+					if itm == nil {
+						break loop
+					}
+
+					if err := fn(itm, id); err != nil {
+						errors[shard] = err
+						break loop
+					}
+				}
+			}
+		}(&wg, i)
+	}
+
+	for i := range files {
+		wchan <- i
+	}
+	close(wchan)
+	wg.Wait()
+
+	for _, err := range errors {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
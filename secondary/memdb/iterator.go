@@ -10,6 +10,7 @@ type Iterator struct {
 	refreshRate int
 
 	snap *Snapshot
+	base *Snapshot // if set, only items changed since base are visible
 	iter *skiplist.Iterator
 	buf  *skiplist.ActionBuffer
 }
@@ -20,13 +21,24 @@ loop:
 		return
 	}
 	itm := (*Item)(it.iter.Get())
-	if itm.bornSn > it.snap.sn || (itm.deadSn > 0 && itm.deadSn <= it.snap.sn) {
+	if !it.visible(itm) {
 		it.iter.Next()
 		it.count++
 		goto loop
 	}
 }
 
+func (it *Iterator) visible(itm *Item) bool {
+	if itm.bornSn > it.snap.sn || (itm.deadSn > 0 && itm.deadSn <= it.snap.sn) {
+		// Item is not live in snap, but it was deleted since base
+		return it.base != nil && itm.bornSn <= it.base.sn &&
+			itm.deadSn > it.base.sn && itm.deadSn <= it.snap.sn
+	}
+
+	// Item is live in snap, but it was inserted before base
+	return it.base == nil || itm.bornSn > it.base.sn
+}
+
 func (it *Iterator) SeekFirst() {
 	it.iter.SeekFirst()
 	it.skipUnwanted()
@@ -81,12 +93,19 @@ func (it *Iterator) Close() {
 }
 
 func (m *MemDB) NewIterator(snap *Snapshot) *Iterator {
+	return m.newIterator(snap, nil)
+}
+
+// newIterator returns an iterator over items of snap, or if base is
+// not nil, over items inserted or deleted between base and snap.
+func (m *MemDB) newIterator(snap, base *Snapshot) *Iterator {
 	if !snap.Open() {
 		return nil
 	}
 	buf := snap.db.store.MakeBuf()
 	return &Iterator{
 		snap: snap,
+		base: base,
 		iter: m.store.NewIterator(m.iterCmp, buf),
 		buf:  buf,
 	}
@@ -702,6 +702,12 @@ func (m *MemDB) ptrToItem(itmPtr unsafe.Pointer) *Item {
 }
 
 func (m *MemDB) Visitor(snap *Snapshot, callb VisitorCallback, shards int, concurrency int) error {
+	return m.visitor(snap, nil, callb, shards, concurrency)
+}
+
+// visitor visits items of snap, or if base is not nil, items inserted
+// or deleted between base and snap.
+func (m *MemDB) visitor(snap, base *Snapshot, callb VisitorCallback, shards int, concurrency int) error {
 	var wg sync.WaitGroup
 	var pivotItems []*Item
 
@@ -712,7 +718,7 @@ func (m *MemDB) Visitor(snap *Snapshot, callb VisitorCallback, shards int, concu
 	}
 
 	func() {
-		tmpIter := m.NewIterator(snap)
+		tmpIter := m.newIterator(snap, base)
 		if tmpIter == nil {
 			panic("iterator cannot be nil")
 		}
@@ -750,7 +756,7 @@ func (m *MemDB) Visitor(snap *Snapshot, callb VisitorCallback, shards int, concu
 				startItem := pivotItems[shard]
 				endItem := pivotItems[shard+1]
 
-				itr := m.NewIterator(snap)
+				itr := m.newIterator(snap, base)
 				if itr == nil {
 					panic("iterator cannot be nil")
 				}
@@ -955,26 +961,43 @@ func (m *MemDB) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 	return err
 }
 
+// LoadFromDisk restores the snapshot persisted in dir by StoreToDisk or
+// StoreDeltaToDisk.
 func (m *MemDB) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snapshot, error) {
+	chain, err := SnapshotChain(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chain) == 1 {
+		err = m.loadFromDisk(dir, concurr, callb)
+	} else {
+		err = m.loadChainFromDisk(chain, concurr, callb)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	stats := m.store.GetStats()
+	m.itemsCount = int64(stats.NodeCount)
+	return m.NewSnapshot()
+}
+
+func (m *MemDB) loadFromDisk(dir string, concurr int, callb ItemCallback) error {
 	var wg sync.WaitGroup
 	datadir := filepath.Join(dir, "data")
 	var files []string
-	manifestdir := dir
-	var version int
 
 	// Read file version
-	if bs, err := ioutil.ReadFile(filepath.Join(manifestdir, "nitro.json")); err == nil {
-		mMap := make(map[string]int)
-		if err = json.Unmarshal(bs, &mMap); err != nil {
-			return nil, err
-		}
-		version = mMap["version"]
-	} else if !os.IsNotExist(err) {
-		return nil, err
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return err
 	}
+	version := manifest.Version
 
 	if bs, err := ioutil.ReadFile(filepath.Join(datadir, "files.json")); err != nil {
-		return nil, err
+		return err
 	} else {
 		json.Unmarshal(bs, &files)
 	}
@@ -1007,7 +1030,7 @@ func (m *MemDB) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 		r := m.newFileReader(m.fileType, version)
 		datafile := filepath.Join(datadir, file)
 		if err := r.Open(datafile); err != nil {
-			return nil, err
+			return err
 		}
 
 		readers[i] = r
@@ -1045,7 +1068,7 @@ func (m *MemDB) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 
 	for _, err := range errors {
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
@@ -1079,7 +1102,7 @@ func (m *MemDB) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 			r := m.newFileReader(m.fileType, version)
 			deltafile := filepath.Join(deltadir, file)
 			if err := r.Open(deltafile); err != nil {
-				return nil, err
+				return err
 			}
 
 			readers[i] = r
@@ -1136,14 +1159,12 @@ func (m *MemDB) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 
 		for _, err := range errors {
 			if err != nil {
-				return nil, err
+				return err
 			}
 		}
 	}
 
-	stats := m.store.GetStats()
-	m.itemsCount = int64(stats.NodeCount)
-	return m.NewSnapshot()
+	return nil
 }
 
 func (m *MemDB) DumpStats() string {
@@ -658,6 +658,124 @@ func TestLoadDeltaStoreDisk(t *testing.T) {
 	fmt.Println("RestoredFailed", db.DeltaRestoreFailed)
 }
 
+func snapItems(snap *Snapshot) []string {
+	var items []string
+	itr := snap.NewIterator()
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		items = append(items, string(itr.Get()))
+	}
+	itr.Close()
+	return items
+}
+
+func TestStoreDeltaToDisk(t *testing.T) {
+	os.RemoveAll("db.dump")
+	defer os.RemoveAll("db.dump")
+	db := NewWithConfig(DefaultConfig())
+	defer db.Close()
+
+	var writers []*Writer
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		writers = append(writers, db.NewWriter())
+	}
+
+	n := 100000
+	doMutate := func(count int, version int) *Snapshot {
+		var wg sync.WaitGroup
+		chunk := count / len(writers)
+		for i, w := range writers {
+			wg.Add(1)
+			go doUpdate(db, &wg, w, i*chunk, (i+1)*chunk, version)
+		}
+		wg.Wait()
+
+		snap, _ := db.NewSnapshot()
+		return snap
+	}
+
+	// Full snapshot followed by two deltas
+	var expected [][]string
+	base := doMutate(n, 1)
+	expected = append(expected, snapItems(base))
+	base.Open()
+	if err := db.StoreToDisk("db.dump/snap-1", base, 8, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	for v := 2; v <= 3; v++ {
+		snap := doMutate(n/v, v)
+		expected = append(expected, snapItems(snap))
+		dir := fmt.Sprintf("db.dump/snap-%d", v)
+		parent := fmt.Sprintf("snap-%d", v-1)
+		// Items of base missing in snap are deleted by the delta
+		live := make(map[string]bool)
+		for _, itm := range expected[len(expected)-1] {
+			live[itm] = true
+		}
+		var deleted int
+		for _, itm := range expected[len(expected)-2] {
+			if !live[itm] {
+				deleted++
+			}
+		}
+
+		var delCount int64
+		delCallback := func(e *ItemEntry) {
+			atomic.AddInt64(&delCount, 1)
+		}
+
+		snap.Open()
+		if err := db.StoreDeltaToDisk(dir, parent, base, snap, 8, nil, delCallback); err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+		if int(delCount) != deleted {
+			t.Errorf("Expected %d deleted items, got %d", deleted, delCount)
+		}
+		base.Close()
+		base = snap
+	}
+
+	base.Open()
+	if err := db.StoreDeltaToDisk("db.dump/snap-x", "snap-3", base, base, 8, nil, nil); err != ErrInvalidDeltaBase {
+		t.Errorf("Expected ErrInvalidDeltaBase. got=%v", err)
+	}
+	base.Close()
+
+	for i, exp := range expected {
+		dir := fmt.Sprintf("db.dump/snap-%d", i+1)
+		chain, err := SnapshotChain(dir)
+		if err != nil || len(chain) != i+1 {
+			t.Errorf("Expected chain of %d snapshots, got %v (%v)", i+1, chain, err)
+		}
+
+		var count int
+		callb := func(e *ItemEntry) {
+			count++
+		}
+
+		db2 := NewWithConfig(DefaultConfig())
+		snap, err := db2.LoadFromDisk(dir, 8, callb)
+		if err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+
+		got := snapItems(snap)
+		if len(got) != len(exp) || int(snap.Count()) != len(exp) || count != len(exp) {
+			t.Errorf("%s: expected %d items, got %d (count %d, callback %d)",
+				dir, len(exp), len(got), snap.Count(), count)
+		} else {
+			for j := range exp {
+				if got[j] != exp[j] {
+					t.Errorf("%s: expected item %x, got %x", dir, exp[j], got[j])
+					break
+				}
+			}
+		}
+		snap.Close()
+		db2.Close()
+	}
+}
+
 func TestExecuteConcurrGCWorkers(t *testing.T) {
 	db := NewWithConfig(testConf)
 	defer db.Close()
@@ -119,29 +119,46 @@ func (nt *NodeTable) Update(key []byte, nptr unsafe.Pointer) (updated bool, oldP
 	} else {
 		// Insert new key
 		updated = false
-		newSlowValue := res.fastHTHasEntry && !res.hasConflict
-		// Key needs to be inserted into slowHT
-		if res.hasConflict || newSlowValue {
-			slowHTValues := nt.slowHT[res.hash]
-			slowHTValues = append(slowHTValues, encodePointer(nptr, false))
-			nt.slowHT[res.hash] = slowHTValues
-			// There is an entry already in the fastHT for same crc32 hash
-			// We have inserted first entry into the slowHT. Now mark conflict bit.
-			if newSlowValue {
-				nt.fastHT[res.hash] = encodePointer(decodePointer(nt.fastHT[res.hash]), true)
-				nt.conflicts++
-			}
-			nt.slowHTCount++
-		} else {
-			// Insert new item into fastHT
-			nt.fastHT[res.hash] = encodePointer(nptr, false)
-			nt.fastHTCount++
-		}
+		nt.insert(res, nptr)
 	}
 
 	return
 }
 
+// Insert a key that is known to be absent in the table. Unlike Update,
+// existing entries with the same hash are not compared with the key.
+func (nt *NodeTable) Insert(key []byte, nptr unsafe.Pointer) {
+	nt.res = emptyResult
+	res := &nt.res
+	res.hash = nt.hash(key)
+	if v, ok := nt.fastHT[res.hash]; ok {
+		res.fastHTHasEntry = true
+		res.hasConflict = nt.hasConflict(v)
+	}
+	nt.insert(res, nptr)
+}
+
+func (nt *NodeTable) insert(res *ntResult, nptr unsafe.Pointer) {
+	newSlowValue := res.fastHTHasEntry && !res.hasConflict
+	// Key needs to be inserted into slowHT
+	if res.hasConflict || newSlowValue {
+		slowHTValues := nt.slowHT[res.hash]
+		slowHTValues = append(slowHTValues, encodePointer(nptr, false))
+		nt.slowHT[res.hash] = slowHTValues
+		// There is an entry already in the fastHT for same crc32 hash
+		// We have inserted first entry into the slowHT. Now mark conflict bit.
+		if newSlowValue {
+			nt.fastHT[res.hash] = encodePointer(decodePointer(nt.fastHT[res.hash]), true)
+			nt.conflicts++
+		}
+		nt.slowHTCount++
+	} else {
+		// Insert new item into fastHT
+		nt.fastHT[res.hash] = encodePointer(nptr, false)
+		nt.fastHTCount++
+	}
+}
+
 func (nt *NodeTable) Remove(key []byte) (success bool, nptr unsafe.Pointer) {
 	res := nt.find(key)
 	if res.status&ntFoundMask == ntFoundMask {
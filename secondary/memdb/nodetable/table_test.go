@@ -95,6 +95,26 @@ func TestInsertSlowHT(t *testing.T) {
 	}
 }
 
+func TestInsertAbsent(t *testing.T) {
+	table := New(mkHashFun(100), equalObject)
+	o1 := mkObject("key1", 1000)
+	o2 := mkObject("key2", 2000)
+	o3 := mkObject("key3", 3000)
+	table.Insert(o1.key, unsafe.Pointer(o1))
+	table.Insert(o2.key, unsafe.Pointer(o2))
+	table.Update(o3.key, unsafe.Pointer(o3))
+	ro1 := (*object)(table.Get(o1.key))
+	ro2 := (*object)(table.Get(o2.key))
+	ro3 := (*object)(table.Get(o3.key))
+	if o1 != ro1 || o2 != ro2 || o3 != ro3 {
+		t.Errorf("Expected same objects %p!=%p, %p!=%p, %p!=%p", o1, ro1, o2, ro2, o3, ro3)
+	}
+
+	if table.fastHTCount != 1 || table.slowHTCount != 2 || table.conflicts != 1 {
+		t.Errorf("Unexpected counts %v %v %v", table.fastHTCount, table.slowHTCount, table.conflicts)
+	}
+}
+
 func TestUpdateFastHT(t *testing.T) {
 	table := New(mkHashFun(100), equalObject)
 	o1 := mkObject("key", 1000)
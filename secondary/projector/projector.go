@@ -18,6 +18,7 @@ import projC "github.com/couchbase/indexing/secondary/projector/client"
 import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
 import "github.com/golang/protobuf/proto"
 import "github.com/couchbase/indexing/secondary/logging"
+import "github.com/couchbase/indexing/secondary/stats"
 
 // Projector data structure, a projector is connected to
 // one or more upstream kv-nodes. Works in tandem with
@@ -63,6 +64,9 @@ func NewProjector(maxvbs int, config c.Config) *Projector {
 	p.config = config
 	p.ResetConfig(config)
 
+	// TLS for adminport listener and dataport connections with indexer.
+	c.CrashOnError(c.SetupTransportTLSFromConfig(pconfig))
+
 	p.logPrefix = fmt.Sprintf("PROJ[%s]", p.adminport)
 
 	cluster := p.clusterAddr
@@ -652,6 +656,74 @@ func (p *Projector) handleStats(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "%s", c.Statistics(stats).Lines())
 }
 
+// handle projector statistics in prometheus text format, feed statistics
+// are labelled with topic and bucket.
+func (p *Projector) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	logging.Tracef("%s Request %q\n", p.logPrefix, r.URL.Path)
+
+	pw := stats.NewPromWriter()
+	feeds := p.GetFeeds()
+	pw.Gauge("projector_num_feeds", float64(len(feeds)))
+	for _, feed := range feeds {
+		if feedStats := feed.GetStatistics(); feedStats != nil {
+			writeFeedMetrics(pw, feedStats)
+		}
+	}
+	w.Header().Set("Content-Type", stats.PromContentType)
+	pw.WriteTo(w)
+}
+
+// writeFeedMetrics adds statistics of a feed, as returned by
+// Feed.GetStatistics(), to `pw`. Per vbucket statistics are summed up
+// for each bucket.
+func writeFeedMetrics(pw *stats.PromWriter, feedStats c.Statistics) {
+	topic, _ := feedStats["topic"].(string)
+	if engines, ok := feedStats["engines"].([]string); ok {
+		pw.Gauge("projector_feed_engines", float64(len(engines)), "topic", topic)
+	}
+	if endpoints := statsMap(feedStats["endpoints"]); endpoints != nil {
+		pw.Gauge("projector_feed_endpoints", float64(len(endpoints)), "topic", topic)
+	}
+
+	for key, value := range feedStats {
+		if !strings.HasPrefix(key, "bucket-") {
+			continue
+		}
+		bucket := key[len("bucket-"):]
+		kvStats := statsMap(value)
+		for name, v := range kvStats {
+			if n, ok := v.(float64); ok {
+				pw.Counter("projector_kvdata_"+name, n, "topic", topic, "bucket", bucket)
+			}
+		}
+
+		vbuckets := statsMap(kvStats["vbuckets"])
+		vbTotals := make(map[string]float64)
+		for _, vbStats := range vbuckets {
+			for name, v := range statsMap(vbStats) {
+				if n, ok := v.(float64); ok {
+					vbTotals[name] += n
+				}
+			}
+		}
+		pw.Gauge("projector_kvdata_vbuckets", float64(len(vbuckets)),
+			"topic", topic, "bucket", bucket)
+		for name, n := range vbTotals {
+			pw.Counter("projector_vbucket_"+name, n, "topic", topic, "bucket", bucket)
+		}
+	}
+}
+
+func statsMap(v interface{}) map[string]interface{} {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m
+	case c.Statistics:
+		return m
+	}
+	return nil
+}
+
 // handle settings
 func (p *Projector) handleSettings(w http.ResponseWriter, r *http.Request) {
 	logging.Infof("%s Request %q %q\n", p.logPrefix, r.Method, r.URL.Path)
@@ -213,6 +213,7 @@ type KeyVersions struct {
 	Keys             [][]byte `protobuf:"bytes,5,rep,name=keys" json:"keys,omitempty"`
 	Oldkeys          [][]byte `protobuf:"bytes,6,rep,name=oldkeys" json:"oldkeys,omitempty"`
 	Partnkeys        [][]byte `protobuf:"bytes,7,rep,name=partnkeys" json:"partnkeys,omitempty"`
+	Payloads         [][]byte `protobuf:"bytes,8,rep,name=payloads" json:"payloads,omitempty"`
 	XXX_unrecognized []byte   `json:"-"`
 }
 
@@ -269,6 +270,13 @@ func (m *KeyVersions) GetPartnkeys() [][]byte {
 	return nil
 }
 
+func (m *KeyVersions) GetPayloads() [][]byte {
+	if m != nil {
+		return m.Payloads
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("protobuf.Command", Command_name, Command_value)
 }
@@ -8,6 +8,8 @@ Package protobuf is a generated protocol buffer package.
 It is generated from these files:
 	common.proto
 	index.proto
+	partn_hash.proto
+	partn_range.proto
 	partn_single.proto
 	partn_tp.proto
 	projector.proto
@@ -3,6 +3,7 @@ package protobuf
 import "fmt"
 
 import "github.com/couchbase/indexing/secondary/logging"
+import "github.com/couchbase/indexing/secondary/collatejson"
 import c "github.com/couchbase/indexing/secondary/common"
 import mcd "github.com/couchbase/indexing/secondary/dcp/transport"
 import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
@@ -26,6 +27,15 @@ type Partition interface {
 	DeletionEndpoints(i *IndexInst, m *mc.DcpEvent, partKey, oldKey []byte) []string
 }
 
+// Partitioned is implemented by partitions that spread the entries of
+// an index across more than one partition.
+type Partitioned interface {
+	// OtherPartitionEndpoints return a list of endpoints <host:port>
+	// hosting a partition other than the one `partKey` belongs to,
+	// to which UpsertDeletion message will be published on Upsert.
+	OtherPartitionEndpoints(partKey []byte) []string
+}
+
 // Bucket implements Router{} interface.
 func (instance *IndexInst) Bucket() string {
 	return instance.GetDefinition().GetBucket()
@@ -82,9 +92,9 @@ func (instance *IndexInst) GetPartitionObject() Partition {
 	case PartitionScheme_KEY:
 		// return instance.GetKeyPartn()
 	case PartitionScheme_HASH:
-		// return instance.GetHashPartn()
+		return instance.GetHashPartn()
 	case PartitionScheme_RANGE:
-		// return instance.GetRangePartn()
+		return instance.GetRangePartn()
 	}
 	return nil
 }
@@ -95,6 +105,8 @@ type IndexEvaluator struct {
 	skExprs  []interface{} // compiled expression
 	pkExpr   interface{}   // compiled expression
 	whExpr   interface{}   // compiled expression
+	inExprs  []interface{} // compiled expression
+	codec    *collatejson.Codec
 	instance *IndexInst
 	version  FeedVersion
 }
@@ -106,7 +118,11 @@ func NewIndexEvaluator(instance *IndexInst,
 
 	var err error
 
-	ie := &IndexEvaluator{instance: instance, version: version}
+	ie := &IndexEvaluator{
+		instance: instance,
+		version:  version,
+		codec:    collatejson.NewCodec(16),
+	}
 	// compile expressions once and reuse it many times.
 	defn := ie.instance.GetDefinition()
 	exprtype := defn.GetExprType()
@@ -138,6 +154,13 @@ func NewIndexEvaluator(instance *IndexInst,
 				ie.whExpr = cExprs[0]
 			}
 		}
+		// expressions to evaluate include payload
+		if exprs := defn.GetIncludeExpressions(); len(exprs) > 0 {
+			ie.inExprs, err = CompileN1QLExpression(exprs)
+			if err != nil {
+				return nil, err
+			}
+		}
 
 	default:
 		logging.Errorf("invalid expression type %v\n", exprtype)
@@ -207,7 +230,7 @@ func (ie *IndexEvaluator) TransformRoute(
 	}
 
 	var npkey /*new-partition*/, opkey /*old-partition*/, nkey, okey []byte
-	var newBuf []byte
+	var payload, newBuf []byte
 	instn := ie.instance
 
 	meta := dcpEvent2Meta(m)
@@ -223,6 +246,13 @@ func (ie *IndexEvaluator) TransformRoute(
 		if nkey, newBuf, err = ie.evaluate(m.Key, m.Value, meta, encodeBuf); err != nil {
 			return nil, err
 		}
+		if nkey != nil && len(ie.inExprs) > 0 {
+			if payload, err = ie.includePayload(m.Key, m.Value, meta, encodeBuf); err != nil {
+				return nil, err
+			} else if payload == nil { // skip document, as for secondary key
+				nkey = nil
+			}
+		}
 	}
 	if len(m.OldValue) > 0 { // project old secondary key
 		if opkey, err = ie.partitionKey(m.OldValue, meta, encodeBuf); err != nil {
@@ -256,8 +286,19 @@ func (ie *IndexEvaluator) TransformRoute(
 				} else {
 					dkv.Kv.AddUpsert(uuid, nkey, okey)
 				}
+				if npkey != nil {
+					dkv.Kv.AddPartnKey(npkey)
+				}
+				if payload != nil {
+					dkv.Kv.AddPayload(payload)
+				}
 				data[raddr] = dkv
 			}
+			if ie.partitioned() {
+				// document might have moved from another partition, remove
+				// it from endpoints hosting other partitions.
+				ie.upsertDeletionOthers(vbuuid, m, npkey, okey, data)
+			}
 		} else { // if WHERE is false, broadcast upsertdelete.
 			// NOTE: downstream can use upsertdelete and immutable flag
 			// to optimize out back-index lookup.
@@ -287,12 +328,55 @@ func (ie *IndexEvaluator) TransformRoute(
 			} else {
 				dkv.Kv.AddDeletion(uuid, okey)
 			}
+			if opkey != nil {
+				dkv.Kv.AddPartnKey(opkey)
+			}
 			data[raddr] = dkv
 		}
 	}
 	return newBuf, nil
 }
 
+// upsertDeletionOthers publish UpsertDeletion to endpoints hosting a
+// partition other than the one document is upserted into, any of which
+// might host an older version of the document. Partition key of the
+// upsert is sent along, so that an endpoint hosting that partition as
+// well removes the document only from its other partitions.
+func (ie *IndexEvaluator) upsertDeletionOthers(
+	vbuuid uint64, m *mc.DcpEvent, npkey, okey []byte,
+	data map[string]interface{}) {
+
+	instn := ie.instance
+	p, ok := instn.GetPartitionObject().(Partitioned)
+	if !ok {
+		return
+	}
+	uuid, bucket := instn.GetInstId(), ie.Bucket()
+	vbno, seqno := m.VBucket, m.Seqno
+
+	for _, raddr := range p.OtherPartitionEndpoints(npkey) {
+		dkv, ok := data[raddr].(*c.DataportKeyVersions)
+		if !ok {
+			kv := c.NewKeyVersions(seqno, m.Key, 4, m.Ctime)
+			kv.AddUpsertDeletion(uuid, okey)
+			dkv = &c.DataportKeyVersions{bucket, vbno, vbuuid, kv}
+		} else {
+			dkv.Kv.AddUpsertDeletion(uuid, okey)
+		}
+		dkv.Kv.AddPartnKey(npkey)
+		data[raddr] = dkv
+	}
+}
+
+func (ie *IndexEvaluator) partitioned() bool {
+	defn := ie.instance.GetDefinition()
+	switch defn.GetPartitionScheme() {
+	case PartitionScheme_HASH, PartitionScheme_RANGE:
+		return true
+	}
+	return false
+}
+
 func (ie *IndexEvaluator) evaluate(
 	docid, doc []byte, meta map[string]interface{}, encodeBuf []byte) ([]byte, []byte, error) {
 
@@ -309,6 +393,18 @@ func (ie *IndexEvaluator) evaluate(
 	return nil, nil, nil
 }
 
+func (ie *IndexEvaluator) includePayload(
+	docid, doc []byte, meta map[string]interface{}, encodeBuf []byte) ([]byte, error) {
+
+	defn := ie.instance.GetDefinition()
+	exprType := defn.GetExprType()
+	switch exprType {
+	case ExprType_N1QL:
+		return N1QLPayload(docid, doc, ie.inExprs, meta, encodeBuf)
+	}
+	return nil, nil
+}
+
 func (ie *IndexEvaluator) partitionKey(
 	doc []byte, meta map[string]interface{}, encodeBuf []byte) ([]byte, error) {
 
@@ -322,7 +418,14 @@ func (ie *IndexEvaluator) partitionKey(
 	exprType := defn.GetExprType()
 	switch exprType {
 	case ExprType_N1QL:
-		out, _, err := N1QLTransform(nil, doc, []interface{}{ie.whExpr}, meta, encodeBuf)
+		out, _, err := N1QLTransform(nil, doc, []interface{}{ie.pkExpr}, meta, encodeBuf)
+		if err != nil || out == nil {
+			return out, err
+		}
+		if defn.GetPartitionScheme() == PartitionScheme_RANGE {
+			// range partitions are located by collated partition key.
+			return encodeRangeKey(ie.codec, out)
+		}
 		return out, err
 	}
 	return nil, nil
@@ -354,15 +457,22 @@ func (ie *IndexEvaluator) wherePredicate(
 }
 
 // helper functions
+
+// dcpEvent2Meta returns document metadata of DCP event, as returned by
+// meta() in index expressions. Numeric fields are converted to int64,
+// so that N1QL evaluates them as numbers. `expiration` is the absolute
+// expiry time of document in seconds since epoch, 0 if document does
+// not expire.
 func dcpEvent2Meta(m *mc.DcpEvent) map[string]interface{} {
 	return map[string]interface{}{
 		"id":         string(m.Key),
-		"byseqno":    m.Seqno,
-		"revseqno":   m.RevSeqno,
-		"flags":      m.Flags,
-		"expiration": m.Expiry,
-		"locktime":   m.LockTime,
-		"nru":        m.Nru,
-		"cas":        m.Cas,
+		"seqno":      int64(m.Seqno),
+		"byseqno":    int64(m.Seqno),
+		"revseqno":   int64(m.RevSeqno),
+		"flags":      int64(m.Flags),
+		"expiration": int64(m.Expiry),
+		"locktime":   int64(m.LockTime),
+		"nru":        int64(m.Nru),
+		"cas":        int64(m.Cas),
 	}
 }
@@ -185,6 +185,8 @@ type IndexInst struct {
 	Definition       *IndexDefn       `protobuf:"bytes,3,req,name=definition" json:"definition,omitempty"`
 	Tp               *TestPartition   `protobuf:"bytes,4,opt,name=tp" json:"tp,omitempty"`
 	SinglePartn      *SinglePartition `protobuf:"bytes,5,opt,name=singlePartn" json:"singlePartn,omitempty"`
+	HashPartn        *HashPartition   `protobuf:"bytes,7,opt,name=hashPartn" json:"hashPartn,omitempty"`
+	RangePartn       *RangePartition  `protobuf:"bytes,8,opt,name=rangePartn" json:"rangePartn,omitempty"`
 	XXX_unrecognized []byte           `json:"-"`
 }
 
@@ -227,19 +229,34 @@ func (m *IndexInst) GetSinglePartn() *SinglePartition {
 	return nil
 }
 
+func (m *IndexInst) GetHashPartn() *HashPartition {
+	if m != nil {
+		return m.HashPartn
+	}
+	return nil
+}
+
+func (m *IndexInst) GetRangePartn() *RangePartition {
+	if m != nil {
+		return m.RangePartn
+	}
+	return nil
+}
+
 // Index DDL from create index statement.
 type IndexDefn struct {
-	DefnID           *uint64          `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
-	Bucket           *string          `protobuf:"bytes,2,req,name=bucket" json:"bucket,omitempty"`
-	IsPrimary        *bool            `protobuf:"varint,3,req,name=isPrimary" json:"isPrimary,omitempty"`
-	Name             *string          `protobuf:"bytes,4,req,name=name" json:"name,omitempty"`
-	Using            *StorageType     `protobuf:"varint,5,req,name=using,enum=protobuf.StorageType" json:"using,omitempty"`
-	ExprType         *ExprType        `protobuf:"varint,6,req,name=exprType,enum=protobuf.ExprType" json:"exprType,omitempty"`
-	SecExpressions   []string         `protobuf:"bytes,7,rep,name=secExpressions" json:"secExpressions,omitempty"`
-	PartitionScheme  *PartitionScheme `protobuf:"varint,8,opt,name=partitionScheme,enum=protobuf.PartitionScheme" json:"partitionScheme,omitempty"`
-	PartnExpression  *string          `protobuf:"bytes,9,opt,name=partnExpression" json:"partnExpression,omitempty"`
-	WhereExpression  *string          `protobuf:"bytes,10,opt,name=whereExpression" json:"whereExpression,omitempty"`
-	XXX_unrecognized []byte           `json:"-"`
+	DefnID             *uint64          `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
+	Bucket             *string          `protobuf:"bytes,2,req,name=bucket" json:"bucket,omitempty"`
+	IsPrimary          *bool            `protobuf:"varint,3,req,name=isPrimary" json:"isPrimary,omitempty"`
+	Name               *string          `protobuf:"bytes,4,req,name=name" json:"name,omitempty"`
+	Using              *StorageType     `protobuf:"varint,5,req,name=using,enum=protobuf.StorageType" json:"using,omitempty"`
+	ExprType           *ExprType        `protobuf:"varint,6,req,name=exprType,enum=protobuf.ExprType" json:"exprType,omitempty"`
+	SecExpressions     []string         `protobuf:"bytes,7,rep,name=secExpressions" json:"secExpressions,omitempty"`
+	PartitionScheme    *PartitionScheme `protobuf:"varint,8,opt,name=partitionScheme,enum=protobuf.PartitionScheme" json:"partitionScheme,omitempty"`
+	PartnExpression    *string          `protobuf:"bytes,9,opt,name=partnExpression" json:"partnExpression,omitempty"`
+	WhereExpression    *string          `protobuf:"bytes,10,opt,name=whereExpression" json:"whereExpression,omitempty"`
+	IncludeExpressions []string         `protobuf:"bytes,11,rep,name=includeExpressions" json:"includeExpressions,omitempty"`
+	XXX_unrecognized   []byte           `json:"-"`
 }
 
 func (m *IndexDefn) Reset()         { *m = IndexDefn{} }
@@ -316,6 +333,13 @@ func (m *IndexDefn) GetWhereExpression() string {
 	return ""
 }
 
+func (m *IndexDefn) GetIncludeExpressions() []string {
+	if m != nil {
+		return m.IncludeExpressions
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("protobuf.IndexState", IndexState_name, IndexState_value)
 	proto.RegisterEnum("protobuf.StorageType", StorageType_name, StorageType_value)
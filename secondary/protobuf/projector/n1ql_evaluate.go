@@ -27,8 +27,8 @@ var missing = qvalue.NewValue(string(collatejson.MissingLiteral))
 // statement and evaluate a document using them to return a secondary
 // key as JSON object.
 // `meta` supplies a dictionary of,
-//      `id`, `byseqno`, `revseqno`, `flags`, `expiration`, `locktime`,
-//      `nru`, `cas`
+//      `id`, `seqno`, `byseqno`, `revseqno`, `flags`, `expiration`,
+//      `locktime`, `nru`, `cas`
 func N1QLTransform(
 	docid, doc []byte, cExprs []interface{},
 	meta map[string]interface{}, encodeBuf []byte) ([]byte, []byte, error) {
@@ -130,6 +130,71 @@ func N1QLTransform(
 	return nil, nil, nil
 }
 
+// N1QLPayload will use compiled list of include expressions from N1QL's
+// DDL statement and evaluate a document using them to return the payload
+// stored alongside its index entry. Unlike secondary key, a missing value
+// does not skip the document. Payload is returned as collated JSON array
+// if `encodeBuf` is supplied, as JSON array otherwise.
+func N1QLPayload(
+	docid, doc []byte, cExprs []interface{},
+	meta map[string]interface{}, encodeBuf []byte) ([]byte, error) {
+
+	arrValue := make([]interface{}, 0, len(cExprs))
+	context := qexpr.NewIndexContext()
+	docval := qvalue.NewAnnotatedValue(doc)
+	docval.SetAttachment("meta", meta)
+	for _, cExpr := range cExprs {
+		expr := cExpr.(qexpr.Expression)
+		val, err := expr.Evaluate(docval, context)
+		if err != nil || val == nil {
+			exprstr := qexpr.NewStringer().Visit(expr)
+			fmsg := "Evaluate(%q) for docid %v, err: %v skip document"
+			logging.Errorf(fmsg, exprstr, string(docid), err)
+			return nil, nil
+		}
+		if val.Type() == qvalue.MISSING {
+			val = missing
+		}
+		arrValue = append(arrValue, val)
+	}
+
+	if encodeBuf != nil {
+		out, _, err := CollateJSONEncode(qvalue.NewValue(arrValue), encodeBuf)
+		if err != nil {
+			fmsg := "CollateJSONEncode: include payload for docid: %s (err: %v) skip document"
+			logging.Errorf(fmsg, docid, err)
+			return nil, nil
+		}
+		return out, nil // return as collated JSON array
+	}
+	return qvalue.NewValue(arrValue).MarshalJSON() // return as JSON array
+}
+
+// N1QLFilter will evaluate a compiled N1QL predicate on the values of an
+// index entry, used by indexer to filter scanned entries. `keys` are the
+// values as JSON, nil for missing value, and are referred in predicate
+// by position as self[i]. meta().id evaluates to `docid`. Returns true
+// only if predicate evaluates to boolean true, MISSING and NULL are
+// treated as false.
+func N1QLFilter(docid []byte, keys [][]byte, cExpr interface{}) (bool, error) {
+	arrValue := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		if key == nil {
+			arrValue = append(arrValue, qvalue.NewMissingValue())
+			continue
+		}
+		arrValue = append(arrValue, qvalue.NewValue(key))
+	}
+	context := qexpr.NewIndexContext()
+	docval := qvalue.NewAnnotatedValue(qvalue.NewValue(arrValue))
+	docval.SetAttachment("meta", map[string]interface{}{"id": string(docid)})
+	val, err := cExpr.(qexpr.Expression).Evaluate(docval, context)
+	if err != nil {
+		return false, err
+	}
+	return val.Type() == qvalue.BOOLEAN && val.Truth(), nil
+}
+
 func CollateJSONEncode(val qvalue.Value, encodeBuf []byte) ([]byte, []byte, error) {
 	codec := collatejson.NewCodec(16)
 	encoded, err := codec.EncodeN1QLValue(val, encodeBuf[:0])
@@ -5,6 +5,7 @@ import (
 	"compress/bzip2"
 	"encoding/json"
 	"github.com/couchbase/indexing/secondary/collatejson"
+	mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -101,6 +102,25 @@ func TestN1QLTransform2000(t *testing.T) {
 	}
 }
 
+func TestN1QLTransformMeta(t *testing.T) {
+	cExprs, err := CompileN1QLExpression([]string{
+		`meta().expiration`, `meta().cas`, `meta().flags`, `meta().seqno`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mc.DcpEvent{
+		Key: []byte("docid"), Expiry: 1500000000, Cas: 1234, Flags: 2, Seqno: 10,
+	}
+	secKey, _, err := N1QLTransform(m.Key, doc150, cExprs, dcpEvent2Meta(m), buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(secKey, encodeJSON(`[1500000000,1234,2,10]`)) {
+		t.Fatalf("evaluation failed %v", decodeCollateJSON(secKey))
+	}
+}
+
 func BenchmarkCompileN1QLExpression(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		CompileN1QLExpression([]string{`age`})
@@ -0,0 +1,137 @@
+package protobuf
+
+import "github.com/golang/protobuf/proto"
+import c "github.com/couchbase/indexing/secondary/common"
+import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+
+// NewHashPartition return a new partition instance, with `numPartitions`
+// partitions and no endpoints.
+func NewHashPartition(numPartitions uint32) *HashPartition {
+	return &HashPartition{NumPartitions: proto.Uint32(numPartitions)}
+}
+
+// AddPartitionEndpoints add a list of hosts serving partition `partnId`.
+func (p *HashPartition) AddPartitionEndpoints(
+	partnId uint64, endpoints []string) *HashPartition {
+
+	p.Partitions = addPartitionEndpoints(p.Partitions, partnId, endpoints)
+	return p
+}
+
+// SetCoordinatorEndpoint will set coordinator endpoint, that is different
+// from other endpoints.
+func (p *HashPartition) SetCoordinatorEndpoint(endpoint string) *HashPartition {
+	p.CoordEndpoint = proto.String(endpoint)
+	return p
+}
+
+// Hosts implements Partition{} interface.
+func (p *HashPartition) Hosts(inst *IndexInst) []string {
+	endpoints := allEndpoints(p.GetPartitions())
+	if p.GetCoordEndpoint() != "" {
+		endpoints = append(endpoints, p.GetCoordEndpoint())
+	}
+	return endpoints
+}
+
+// UpsertEndpoints implements Partition{} interface.
+// - sent only if where clause is true.
+// - `partKey` is hashed to locate the partition, and only endpoints
+//   hosting that partition shall receive the Upsert.
+// - for now, `oldKey` is ignored.
+func (p *HashPartition) UpsertEndpoints(
+	inst *IndexInst, m *mc.DcpEvent, partKey, key, oldKey []byte) []string {
+
+	return p.partitionEndpoints(partKey)
+}
+
+// UpsertDeletionEndpoints implements Partition{} interface.
+// - partition that hosted the previous version of the document is not
+//   known, hence broadcast to all endpoints.
+// - `key` is always nil
+// - for now, `oldKey` is ignored.
+func (p *HashPartition) UpsertDeletionEndpoints(
+	inst *IndexInst, m *mc.DcpEvent, oldPartKey, key, oldKey []byte) []string {
+
+	return allEndpoints(p.GetPartitions())
+}
+
+// DeletionEndpoints implements Partition{} interface.
+// - not sent to coordinator-endpoint
+// - if `oldPartKey` is available, sent only to endpoints hosting the
+//   partition, otherwise broadcast to all endpoints.
+// - for now, `oldKey` is ignored.
+func (p *HashPartition) DeletionEndpoints(
+	inst *IndexInst, m *mc.DcpEvent, oldPartKey, oldKey []byte) []string {
+
+	if len(oldPartKey) == 0 {
+		return allEndpoints(p.GetPartitions())
+	}
+	return p.partitionEndpoints(oldPartKey)
+}
+
+// OtherPartitionEndpoints implements Partitioned{} interface.
+func (p *HashPartition) OtherPartitionEndpoints(partKey []byte) []string {
+	partnId := c.HashKeyPartition(partKey, int(p.GetNumPartitions()))
+	return otherEndpoints(p.GetPartitions(), uint64(partnId))
+}
+
+func (p *HashPartition) partitionEndpoints(partKey []byte) []string {
+	partnId := c.HashKeyPartition(partKey, int(p.GetNumPartitions()))
+	return idEndpoints(p.GetPartitions(), uint64(partnId))
+}
+
+func addPartitionEndpoints(partns []*PartitionEndpoint,
+	partnId uint64, endpoints []string) []*PartitionEndpoint {
+
+	for _, partn := range partns {
+		if partn.GetPartnId() == partnId {
+			partn.Endpoints = append(partn.Endpoints, endpoints...)
+			return partns
+		}
+	}
+	partn := &PartitionEndpoint{
+		PartnId:   proto.Uint64(partnId),
+		Endpoints: endpoints,
+	}
+	return append(partns, partn)
+}
+
+// idEndpoints return endpoints hosting partition `partnId`.
+func idEndpoints(partns []*PartitionEndpoint, partnId uint64) []string {
+	for _, partn := range partns {
+		if partn.GetPartnId() == partnId {
+			return partn.GetEndpoints()
+		}
+	}
+	return nil
+}
+
+// otherEndpoints return unique list of endpoints hosting a partition
+// other than `partnId`, including endpoints that host `partnId` too.
+func otherEndpoints(partns []*PartitionEndpoint, partnId uint64) []string {
+	others := make([]*PartitionEndpoint, 0, len(partns))
+	for _, partn := range partns {
+		if partn.GetPartnId() != partnId {
+			others = append(others, partn)
+		}
+	}
+	return allEndpoints(others)
+}
+
+// allEndpoints return unique list of endpoints across all partitions.
+func allEndpoints(partns []*PartitionEndpoint) []string {
+	endpoints := make([]string, 0)
+	for _, partn := range partns {
+	loop:
+		for _, endpoint := range partn.GetEndpoints() {
+			for _, e := range endpoints {
+				if e == endpoint {
+					continue loop
+				}
+			}
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
@@ -0,0 +1,74 @@
+// Code generated by protoc-gen-go.
+// source: partn_hash.proto
+// DO NOT EDIT!
+
+package protobuf
+
+import proto "github.com/golang/protobuf/proto"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = math.Inf
+
+// HashPartition distributes index entries across a fixed number of
+// partitions, by hashing the partition key of a document.
+type HashPartition struct {
+	NumPartitions    *uint32              `protobuf:"varint,1,req,name=numPartitions" json:"numPartitions,omitempty"`
+	Partitions       []*PartitionEndpoint `protobuf:"bytes,2,rep,name=partitions" json:"partitions,omitempty"`
+	CoordEndpoint    *string              `protobuf:"bytes,3,opt,name=coordEndpoint" json:"coordEndpoint,omitempty"`
+	XXX_unrecognized []byte               `json:"-"`
+}
+
+func (m *HashPartition) Reset()         { *m = HashPartition{} }
+func (m *HashPartition) String() string { return proto.CompactTextString(m) }
+func (*HashPartition) ProtoMessage()    {}
+
+func (m *HashPartition) GetNumPartitions() uint32 {
+	if m != nil && m.NumPartitions != nil {
+		return *m.NumPartitions
+	}
+	return 0
+}
+
+func (m *HashPartition) GetPartitions() []*PartitionEndpoint {
+	if m != nil {
+		return m.Partitions
+	}
+	return nil
+}
+
+func (m *HashPartition) GetCoordEndpoint() string {
+	if m != nil && m.CoordEndpoint != nil {
+		return *m.CoordEndpoint
+	}
+	return ""
+}
+
+// PartitionEndpoint list the endpoints hosting a single partition.
+type PartitionEndpoint struct {
+	PartnId          *uint64  `protobuf:"varint,1,req,name=partnId" json:"partnId,omitempty"`
+	Endpoints        []string `protobuf:"bytes,2,rep,name=endpoints" json:"endpoints,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *PartitionEndpoint) Reset()         { *m = PartitionEndpoint{} }
+func (m *PartitionEndpoint) String() string { return proto.CompactTextString(m) }
+func (*PartitionEndpoint) ProtoMessage()    {}
+
+func (m *PartitionEndpoint) GetPartnId() uint64 {
+	if m != nil && m.PartnId != nil {
+		return *m.PartnId
+	}
+	return 0
+}
+
+func (m *PartitionEndpoint) GetEndpoints() []string {
+	if m != nil {
+		return m.Endpoints
+	}
+	return nil
+}
+
+func init() {
+}
@@ -0,0 +1,110 @@
+package protobuf
+
+import "github.com/golang/protobuf/proto"
+import "github.com/couchbase/indexing/secondary/collatejson"
+import c "github.com/couchbase/indexing/secondary/common"
+import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+
+// NewRangePartition return a new partition instance, with len(splits)+1
+// partitions and no endpoints. `splits` are collated split points in
+// ascending order.
+func NewRangePartition(splits [][]byte) *RangePartition {
+	return &RangePartition{Splits: splits}
+}
+
+// AddPartitionEndpoints add a list of hosts serving partition `partnId`.
+func (p *RangePartition) AddPartitionEndpoints(
+	partnId uint64, endpoints []string) *RangePartition {
+
+	p.Partitions = addPartitionEndpoints(p.Partitions, partnId, endpoints)
+	return p
+}
+
+// SetCoordinatorEndpoint will set coordinator endpoint, that is different
+// from other endpoints.
+func (p *RangePartition) SetCoordinatorEndpoint(endpoint string) *RangePartition {
+	p.CoordEndpoint = proto.String(endpoint)
+	return p
+}
+
+// Hosts implements Partition{} interface.
+func (p *RangePartition) Hosts(inst *IndexInst) []string {
+	endpoints := allEndpoints(p.GetPartitions())
+	if p.GetCoordEndpoint() != "" {
+		endpoints = append(endpoints, p.GetCoordEndpoint())
+	}
+	return endpoints
+}
+
+// UpsertEndpoints implements Partition{} interface.
+// - sent only if where clause is true.
+// - collated `partKey` is compared with split points to locate the
+//   partition, and only endpoints hosting that partition shall receive
+//   the Upsert.
+// - for now, `oldKey` is ignored.
+func (p *RangePartition) UpsertEndpoints(
+	inst *IndexInst, m *mc.DcpEvent, partKey, key, oldKey []byte) []string {
+
+	return p.partitionEndpoints(partKey)
+}
+
+// UpsertDeletionEndpoints implements Partition{} interface.
+// - partition that hosted the previous version of the document is not
+//   known, hence broadcast to all endpoints.
+// - `key` is always nil
+// - for now, `oldKey` is ignored.
+func (p *RangePartition) UpsertDeletionEndpoints(
+	inst *IndexInst, m *mc.DcpEvent, oldPartKey, key, oldKey []byte) []string {
+
+	return allEndpoints(p.GetPartitions())
+}
+
+// DeletionEndpoints implements Partition{} interface.
+// - not sent to coordinator-endpoint
+// - if `oldPartKey` is available, sent only to endpoints hosting the
+//   partition, otherwise broadcast to all endpoints.
+// - for now, `oldKey` is ignored.
+func (p *RangePartition) DeletionEndpoints(
+	inst *IndexInst, m *mc.DcpEvent, oldPartKey, oldKey []byte) []string {
+
+	if len(oldPartKey) == 0 {
+		return allEndpoints(p.GetPartitions())
+	}
+	return p.partitionEndpoints(oldPartKey)
+}
+
+// OtherPartitionEndpoints implements Partitioned{} interface.
+func (p *RangePartition) OtherPartitionEndpoints(partKey []byte) []string {
+	partnId := c.RangeKeyPartition(partKey, p.GetSplits())
+	return otherEndpoints(p.GetPartitions(), uint64(partnId))
+}
+
+func (p *RangePartition) partitionEndpoints(partKey []byte) []string {
+	partnId := c.RangeKeyPartition(partKey, p.GetSplits())
+	return idEndpoints(p.GetPartitions(), uint64(partnId))
+}
+
+// EncodeRangeSplits collate split points, each a JSON value, for range
+// partitions. Indexer and projector shall compare collated partition
+// keys with the collated split points.
+func EncodeRangeSplits(splits []string) ([][]byte, error) {
+	codec := collatejson.NewCodec(16)
+	collated := make([][]byte, 0, len(splits))
+	for _, split := range splits {
+		code, err := encodeRangeKey(codec, []byte(split))
+		if err != nil {
+			return nil, err
+		}
+		collated = append(collated, code)
+	}
+	return collated, nil
+}
+
+// encodeRangeKey collate the JSON value of a partition key.
+func encodeRangeKey(codec *collatejson.Codec, key []byte) ([]byte, error) {
+	size := 3 * len(key)
+	if size < collatejson.MinBufferSize {
+		size = collatejson.MinBufferSize
+	}
+	return codec.Encode(key, make([]byte, 0, size))
+}
@@ -0,0 +1,51 @@
+// Code generated by protoc-gen-go.
+// source: partn_range.proto
+// DO NOT EDIT!
+
+package protobuf
+
+import proto "github.com/golang/protobuf/proto"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = math.Inf
+
+// RangePartition distributes index entries across partitions, by
+// comparing the collated partition key of a document with split points.
+// N split points, in ascending order, make N+1 partitions where
+// partition i holds keys in [splits[i-1], splits[i]).
+type RangePartition struct {
+	Splits           [][]byte             `protobuf:"bytes,1,rep,name=splits" json:"splits,omitempty"`
+	Partitions       []*PartitionEndpoint `protobuf:"bytes,2,rep,name=partitions" json:"partitions,omitempty"`
+	CoordEndpoint    *string              `protobuf:"bytes,3,opt,name=coordEndpoint" json:"coordEndpoint,omitempty"`
+	XXX_unrecognized []byte               `json:"-"`
+}
+
+func (m *RangePartition) Reset()         { *m = RangePartition{} }
+func (m *RangePartition) String() string { return proto.CompactTextString(m) }
+func (*RangePartition) ProtoMessage()    {}
+
+func (m *RangePartition) GetSplits() [][]byte {
+	if m != nil {
+		return m.Splits
+	}
+	return nil
+}
+
+func (m *RangePartition) GetPartitions() []*PartitionEndpoint {
+	if m != nil {
+		return m.Partitions
+	}
+	return nil
+}
+
+func (m *RangePartition) GetCoordEndpoint() string {
+	if m != nil && m.CoordEndpoint != nil {
+		return *m.CoordEndpoint
+	}
+	return ""
+}
+
+func init() {
+}
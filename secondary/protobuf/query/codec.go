@@ -39,6 +39,12 @@ func ProtobufEncodeInBuf(payload interface{}, buf []byte) (data []byte, err erro
 	case *EndStreamRequest:
 		pl.EndStream = val
 
+	case *PinSnapshotRequest:
+		pl.PinSnapshotRequest = val
+
+	case *UnpinSnapshotRequest:
+		pl.UnpinSnapshotRequest = val
+
 	// response
 	case *StatisticsResponse:
 		pl.Statistics = val
@@ -58,6 +64,12 @@ func ProtobufEncodeInBuf(payload interface{}, buf []byte) (data []byte, err erro
 	case *HeloResponse:
 		pl.HeloResponse = val
 
+	case *PinSnapshotResponse:
+		pl.PinSnapshotResponse = val
+
+	case *UnpinSnapshotResponse:
+		pl.UnpinSnapshotResponse = val
+
 	default:
 		return nil, ErrorMissingPayload
 	}
@@ -110,6 +122,14 @@ func ProtobufDecode(data []byte) (value interface{}, err error) {
 		return val, nil
 	} else if val := pl.GetHeloResponse(); val != nil {
 		return val, nil
+	} else if val := pl.GetPinSnapshotRequest(); val != nil {
+		return val, nil
+	} else if val := pl.GetPinSnapshotResponse(); val != nil {
+		return val, nil
+	} else if val := pl.GetUnpinSnapshotRequest(); val != nil {
+		return val, nil
+	} else if val := pl.GetUnpinSnapshotResponse(); val != nil {
+		return val, nil
 	}
 	return nil, ErrorMissingPayload
 }
@@ -18,6 +18,10 @@ func EncodeAndWrite(conn net.Conn, buf []byte, r interface{}) (err error) {
 		return
 	}
 	flags := transport.TransportFlag(0).SetProtobuf()
+	if tconn, ok := conn.(*transport.Conn); ok { // compress if negotiated
+		err = tconn.Send(buf, flags, data)
+		return
+	}
 	err = transport.Send(conn, buf, flags, data)
 	return
 }
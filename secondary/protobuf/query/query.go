@@ -6,6 +6,18 @@ import json "github.com/couchbase/indexing/secondary/common/json"
 import c "github.com/couchbase/indexing/secondary/common"
 import "github.com/golang/protobuf/proto"
 
+// Scan features supported by indexer, advertised in HeloResponse.
+const (
+	// FeatureScanResume, indexer resumes a scan after ScanResume.
+	FeatureScanResume uint64 = 1 << iota
+	// FeatureSnapshotLease, indexer pins snapshots for scans at a
+	// point in time.
+	FeatureSnapshotLease
+	// FeatureScanFilter, indexer evaluates N1QL filter of ScanRequest
+	// on index entries.
+	FeatureScanFilter
+)
+
 // GetEntries implements queryport.client.ResponseReader{} method.
 func (r *ResponseStream) GetEntries() ([]c.SecondaryKey, [][]byte, error) {
 	entries := r.GetIndexEntries()
@@ -82,7 +94,15 @@ func (s *IndexStatistics) DistinctCount() (int64, error) {
 
 // Bins implements common.IndexStatistics{} method.
 func (s *IndexStatistics) Bins() ([]c.IndexStatistics, error) {
-	return nil, nil
+	bins := s.GetHistogram()
+	if len(bins) == 0 {
+		return nil, nil
+	}
+	stats := make([]c.IndexStatistics, 0, len(bins))
+	for _, bin := range bins {
+		stats = append(stats, bin)
+	}
+	return stats, nil
 }
 
 func NewTsConsistency(
@@ -23,11 +23,21 @@ It has these top-level messages:
 	StreamEndResponse
 	CountRequest
 	CountResponse
+	PinSnapshotRequest
+	PinSnapshotResponse
+	UnpinSnapshotRequest
+	UnpinSnapshotResponse
 	Span
 	Range
 	CompositeElementFilter
 	Scan
 	IndexProjection
+	GroupAggr
+	GroupKey
+	Aggregate
+	IndexSort
+	SortKey
+	ScanResume
 	IndexEntry
 	IndexStatistics
 */
@@ -104,19 +114,23 @@ func (m *TsConsistency) GetCrc64() uint64 {
 
 // Request can be one of the optional field.
 type QueryPayload struct {
-	Version           *uint32             `protobuf:"varint,1,req,name=version" json:"version,omitempty"`
-	StatisticsRequest *StatisticsRequest  `protobuf:"bytes,2,opt,name=statisticsRequest" json:"statisticsRequest,omitempty"`
-	Statistics        *StatisticsResponse `protobuf:"bytes,3,opt,name=statistics" json:"statistics,omitempty"`
-	ScanRequest       *ScanRequest        `protobuf:"bytes,4,opt,name=scanRequest" json:"scanRequest,omitempty"`
-	ScanAllRequest    *ScanAllRequest     `protobuf:"bytes,5,opt,name=scanAllRequest" json:"scanAllRequest,omitempty"`
-	Stream            *ResponseStream     `protobuf:"bytes,6,opt,name=stream" json:"stream,omitempty"`
-	CountRequest      *CountRequest       `protobuf:"bytes,7,opt,name=countRequest" json:"countRequest,omitempty"`
-	CountResponse     *CountResponse      `protobuf:"bytes,8,opt,name=countResponse" json:"countResponse,omitempty"`
-	EndStream         *EndStreamRequest   `protobuf:"bytes,9,opt,name=endStream" json:"endStream,omitempty"`
-	StreamEnd         *StreamEndResponse  `protobuf:"bytes,10,opt,name=streamEnd" json:"streamEnd,omitempty"`
-	HeloRequest       *HeloRequest        `protobuf:"bytes,11,opt,name=heloRequest" json:"heloRequest,omitempty"`
-	HeloResponse      *HeloResponse       `protobuf:"bytes,12,opt,name=heloResponse" json:"heloResponse,omitempty"`
-	XXX_unrecognized  []byte              `json:"-"`
+	Version               *uint32                `protobuf:"varint,1,req,name=version" json:"version,omitempty"`
+	StatisticsRequest     *StatisticsRequest     `protobuf:"bytes,2,opt,name=statisticsRequest" json:"statisticsRequest,omitempty"`
+	Statistics            *StatisticsResponse    `protobuf:"bytes,3,opt,name=statistics" json:"statistics,omitempty"`
+	ScanRequest           *ScanRequest           `protobuf:"bytes,4,opt,name=scanRequest" json:"scanRequest,omitempty"`
+	ScanAllRequest        *ScanAllRequest        `protobuf:"bytes,5,opt,name=scanAllRequest" json:"scanAllRequest,omitempty"`
+	Stream                *ResponseStream        `protobuf:"bytes,6,opt,name=stream" json:"stream,omitempty"`
+	CountRequest          *CountRequest          `protobuf:"bytes,7,opt,name=countRequest" json:"countRequest,omitempty"`
+	CountResponse         *CountResponse         `protobuf:"bytes,8,opt,name=countResponse" json:"countResponse,omitempty"`
+	EndStream             *EndStreamRequest      `protobuf:"bytes,9,opt,name=endStream" json:"endStream,omitempty"`
+	StreamEnd             *StreamEndResponse     `protobuf:"bytes,10,opt,name=streamEnd" json:"streamEnd,omitempty"`
+	HeloRequest           *HeloRequest           `protobuf:"bytes,11,opt,name=heloRequest" json:"heloRequest,omitempty"`
+	HeloResponse          *HeloResponse          `protobuf:"bytes,12,opt,name=heloResponse" json:"heloResponse,omitempty"`
+	PinSnapshotRequest    *PinSnapshotRequest    `protobuf:"bytes,13,opt,name=pinSnapshotRequest" json:"pinSnapshotRequest,omitempty"`
+	PinSnapshotResponse   *PinSnapshotResponse   `protobuf:"bytes,14,opt,name=pinSnapshotResponse" json:"pinSnapshotResponse,omitempty"`
+	UnpinSnapshotRequest  *UnpinSnapshotRequest  `protobuf:"bytes,15,opt,name=unpinSnapshotRequest" json:"unpinSnapshotRequest,omitempty"`
+	UnpinSnapshotResponse *UnpinSnapshotResponse `protobuf:"bytes,16,opt,name=unpinSnapshotResponse" json:"unpinSnapshotResponse,omitempty"`
+	XXX_unrecognized      []byte                 `json:"-"`
 }
 
 func (m *QueryPayload) Reset()         { *m = QueryPayload{} }
@@ -207,6 +221,34 @@ func (m *QueryPayload) GetHeloResponse() *HeloResponse {
 	return nil
 }
 
+func (m *QueryPayload) GetPinSnapshotRequest() *PinSnapshotRequest {
+	if m != nil {
+		return m.PinSnapshotRequest
+	}
+	return nil
+}
+
+func (m *QueryPayload) GetPinSnapshotResponse() *PinSnapshotResponse {
+	if m != nil {
+		return m.PinSnapshotResponse
+	}
+	return nil
+}
+
+func (m *QueryPayload) GetUnpinSnapshotRequest() *UnpinSnapshotRequest {
+	if m != nil {
+		return m.UnpinSnapshotRequest
+	}
+	return nil
+}
+
+func (m *QueryPayload) GetUnpinSnapshotResponse() *UnpinSnapshotResponse {
+	if m != nil {
+		return m.UnpinSnapshotResponse
+	}
+	return nil
+}
+
 // Get current server version/capabilities
 type HeloRequest struct {
 	Version          *uint32 `protobuf:"varint,1,req,name=version" json:"version,omitempty"`
@@ -226,6 +268,7 @@ func (m *HeloRequest) GetVersion() uint32 {
 
 type HeloResponse struct {
 	Version          *uint32 `protobuf:"varint,1,req,name=version" json:"version,omitempty"`
+	Features         *uint64 `protobuf:"varint,2,opt,name=features" json:"features,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -240,6 +283,13 @@ func (m *HeloResponse) GetVersion() uint32 {
 	return 0
 }
 
+func (m *HeloResponse) GetFeatures() uint64 {
+	if m != nil && m.Features != nil {
+		return *m.Features
+	}
+	return 0
+}
+
 // Get Index statistics. StatisticsResponse is returned back from indexer.
 type StatisticsRequest struct {
 	DefnID           *uint64 `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
@@ -311,6 +361,13 @@ type ScanRequest struct {
 	Reverse          *bool            `protobuf:"varint,10,opt,name=reverse" json:"reverse,omitempty"`
 	Offset           *int64           `protobuf:"varint,11,opt,name=offset" json:"offset,omitempty"`
 	RollbackTime     *int64           `protobuf:"varint,12,opt,name=rollbackTime" json:"rollbackTime,omitempty"`
+	GroupAggr        *GroupAggr       `protobuf:"bytes,13,opt,name=groupAggr" json:"groupAggr,omitempty"`
+	PartitionIds     []uint64         `protobuf:"varint,14,rep,name=partitionIds" json:"partitionIds,omitempty"`
+	Sort             *IndexSort       `protobuf:"bytes,15,opt,name=sort" json:"sort,omitempty"`
+	Resume           *ScanResume      `protobuf:"bytes,16,opt,name=resume" json:"resume,omitempty"`
+	SnapshotLease    *uint64          `protobuf:"varint,17,opt,name=snapshotLease" json:"snapshotLease,omitempty"`
+	Filter           *string          `protobuf:"bytes,18,opt,name=filter" json:"filter,omitempty"`
+	InstVersion      *uint64          `protobuf:"varint,19,opt,name=instVersion" json:"instVersion,omitempty"`
 	XXX_unrecognized []byte           `json:"-"`
 }
 
@@ -402,6 +459,55 @@ func (m *ScanRequest) GetRollbackTime() int64 {
 	return 0
 }
 
+func (m *ScanRequest) GetGroupAggr() *GroupAggr {
+	if m != nil {
+		return m.GroupAggr
+	}
+	return nil
+}
+
+func (m *ScanRequest) GetPartitionIds() []uint64 {
+	if m != nil {
+		return m.PartitionIds
+	}
+	return nil
+}
+
+func (m *ScanRequest) GetSort() *IndexSort {
+	if m != nil {
+		return m.Sort
+	}
+	return nil
+}
+
+func (m *ScanRequest) GetResume() *ScanResume {
+	if m != nil {
+		return m.Resume
+	}
+	return nil
+}
+
+func (m *ScanRequest) GetSnapshotLease() uint64 {
+	if m != nil && m.SnapshotLease != nil {
+		return *m.SnapshotLease
+	}
+	return 0
+}
+
+func (m *ScanRequest) GetFilter() string {
+	if m != nil && m.Filter != nil {
+		return *m.Filter
+	}
+	return ""
+}
+
+func (m *ScanRequest) GetInstVersion() uint64 {
+	if m != nil && m.InstVersion != nil {
+		return *m.InstVersion
+	}
+	return 0
+}
+
 // Full table scan request from indexer.
 type ScanAllRequest struct {
 	DefnID           *uint64        `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
@@ -410,6 +516,9 @@ type ScanAllRequest struct {
 	Vector           *TsConsistency `protobuf:"bytes,4,opt,name=vector" json:"vector,omitempty"`
 	RequestId        *string        `protobuf:"bytes,5,opt,name=requestId" json:"requestId,omitempty"`
 	RollbackTime     *int64         `protobuf:"varint,6,opt,name=rollbackTime" json:"rollbackTime,omitempty"`
+	Resume           *ScanResume    `protobuf:"bytes,7,opt,name=resume" json:"resume,omitempty"`
+	SnapshotLease    *uint64        `protobuf:"varint,8,opt,name=snapshotLease" json:"snapshotLease,omitempty"`
+	InstVersion      *uint64        `protobuf:"varint,9,opt,name=instVersion" json:"instVersion,omitempty"`
 	XXX_unrecognized []byte         `json:"-"`
 }
 
@@ -459,6 +568,27 @@ func (m *ScanAllRequest) GetRollbackTime() int64 {
 	return 0
 }
 
+func (m *ScanAllRequest) GetResume() *ScanResume {
+	if m != nil {
+		return m.Resume
+	}
+	return nil
+}
+
+func (m *ScanAllRequest) GetSnapshotLease() uint64 {
+	if m != nil && m.SnapshotLease != nil {
+		return *m.SnapshotLease
+	}
+	return 0
+}
+
+func (m *ScanAllRequest) GetInstVersion() uint64 {
+	if m != nil && m.InstVersion != nil {
+		return *m.InstVersion
+	}
+	return 0
+}
+
 // Request by client to stop streaming the query results.
 type EndStreamRequest struct {
 	XXX_unrecognized []byte `json:"-"`
@@ -519,6 +649,8 @@ type CountRequest struct {
 	Distinct         *bool          `protobuf:"varint,6,opt,name=distinct" json:"distinct,omitempty"`
 	Scans            []*Scan        `protobuf:"bytes,7,rep,name=scans" json:"scans,omitempty"`
 	RollbackTime     *int64         `protobuf:"varint,8,opt,name=rollbackTime" json:"rollbackTime,omitempty"`
+	SnapshotLease    *uint64        `protobuf:"varint,9,opt,name=snapshotLease" json:"snapshotLease,omitempty"`
+	InstVersion      *uint64        `protobuf:"varint,10,opt,name=instVersion" json:"instVersion,omitempty"`
 	XXX_unrecognized []byte         `json:"-"`
 }
 
@@ -582,6 +714,20 @@ func (m *CountRequest) GetRollbackTime() int64 {
 	return 0
 }
 
+func (m *CountRequest) GetSnapshotLease() uint64 {
+	if m != nil && m.SnapshotLease != nil {
+		return *m.SnapshotLease
+	}
+	return 0
+}
+
+func (m *CountRequest) GetInstVersion() uint64 {
+	if m != nil && m.InstVersion != nil {
+		return *m.InstVersion
+	}
+	return 0
+}
+
 // total number of entries in index.
 type CountResponse struct {
 	Count            *int64 `protobuf:"varint,1,req,name=count" json:"count,omitempty"`
@@ -607,6 +753,147 @@ func (m *CountResponse) GetErr() *Error {
 	return nil
 }
 
+// Pin a snapshot of index, satisfying the consistency, for scans at a
+// point in time. Indexer retains the snapshot until the lease expires or
+// is released by UnpinSnapshotRequest. If leaseId is specified, lease of
+// an already pinned snapshot is renewed.
+type PinSnapshotRequest struct {
+	DefnID           *uint64        `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
+	Cons             *uint32        `protobuf:"varint,2,req,name=cons" json:"cons,omitempty"`
+	Vector           *TsConsistency `protobuf:"bytes,3,opt,name=vector" json:"vector,omitempty"`
+	RequestId        *string        `protobuf:"bytes,4,opt,name=requestId" json:"requestId,omitempty"`
+	LeaseTime        *uint64        `protobuf:"varint,5,opt,name=leaseTime" json:"leaseTime,omitempty"`
+	LeaseId          *uint64        `protobuf:"varint,6,opt,name=leaseId" json:"leaseId,omitempty"`
+	RollbackTime     *int64         `protobuf:"varint,7,opt,name=rollbackTime" json:"rollbackTime,omitempty"`
+	XXX_unrecognized []byte         `json:"-"`
+}
+
+func (m *PinSnapshotRequest) Reset()         { *m = PinSnapshotRequest{} }
+func (m *PinSnapshotRequest) String() string { return proto.CompactTextString(m) }
+func (*PinSnapshotRequest) ProtoMessage()    {}
+
+func (m *PinSnapshotRequest) GetDefnID() uint64 {
+	if m != nil && m.DefnID != nil {
+		return *m.DefnID
+	}
+	return 0
+}
+
+func (m *PinSnapshotRequest) GetCons() uint32 {
+	if m != nil && m.Cons != nil {
+		return *m.Cons
+	}
+	return 0
+}
+
+func (m *PinSnapshotRequest) GetVector() *TsConsistency {
+	if m != nil {
+		return m.Vector
+	}
+	return nil
+}
+
+func (m *PinSnapshotRequest) GetRequestId() string {
+	if m != nil && m.RequestId != nil {
+		return *m.RequestId
+	}
+	return ""
+}
+
+func (m *PinSnapshotRequest) GetLeaseTime() uint64 {
+	if m != nil && m.LeaseTime != nil {
+		return *m.LeaseTime
+	}
+	return 0
+}
+
+func (m *PinSnapshotRequest) GetLeaseId() uint64 {
+	if m != nil && m.LeaseId != nil {
+		return *m.LeaseId
+	}
+	return 0
+}
+
+func (m *PinSnapshotRequest) GetRollbackTime() int64 {
+	if m != nil && m.RollbackTime != nil {
+		return *m.RollbackTime
+	}
+	return 0
+}
+
+type PinSnapshotResponse struct {
+	LeaseId          *uint64        `protobuf:"varint,1,opt,name=leaseId" json:"leaseId,omitempty"`
+	Ts               *TsConsistency `protobuf:"bytes,2,opt,name=ts" json:"ts,omitempty"`
+	LeaseTime        *uint64        `protobuf:"varint,3,opt,name=leaseTime" json:"leaseTime,omitempty"`
+	Err              *Error         `protobuf:"bytes,4,opt,name=err" json:"err,omitempty"`
+	XXX_unrecognized []byte         `json:"-"`
+}
+
+func (m *PinSnapshotResponse) Reset()         { *m = PinSnapshotResponse{} }
+func (m *PinSnapshotResponse) String() string { return proto.CompactTextString(m) }
+func (*PinSnapshotResponse) ProtoMessage()    {}
+
+func (m *PinSnapshotResponse) GetLeaseId() uint64 {
+	if m != nil && m.LeaseId != nil {
+		return *m.LeaseId
+	}
+	return 0
+}
+
+func (m *PinSnapshotResponse) GetTs() *TsConsistency {
+	if m != nil {
+		return m.Ts
+	}
+	return nil
+}
+
+func (m *PinSnapshotResponse) GetLeaseTime() uint64 {
+	if m != nil && m.LeaseTime != nil {
+		return *m.LeaseTime
+	}
+	return 0
+}
+
+func (m *PinSnapshotResponse) GetErr() *Error {
+	if m != nil {
+		return m.Err
+	}
+	return nil
+}
+
+// Release snapshot pinned by PinSnapshotRequest.
+type UnpinSnapshotRequest struct {
+	LeaseId          *uint64 `protobuf:"varint,1,req,name=leaseId" json:"leaseId,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *UnpinSnapshotRequest) Reset()         { *m = UnpinSnapshotRequest{} }
+func (m *UnpinSnapshotRequest) String() string { return proto.CompactTextString(m) }
+func (*UnpinSnapshotRequest) ProtoMessage()    {}
+
+func (m *UnpinSnapshotRequest) GetLeaseId() uint64 {
+	if m != nil && m.LeaseId != nil {
+		return *m.LeaseId
+	}
+	return 0
+}
+
+type UnpinSnapshotResponse struct {
+	Err              *Error `protobuf:"bytes,1,opt,name=err" json:"err,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *UnpinSnapshotResponse) Reset()         { *m = UnpinSnapshotResponse{} }
+func (m *UnpinSnapshotResponse) String() string { return proto.CompactTextString(m) }
+func (*UnpinSnapshotResponse) ProtoMessage()    {}
+
+func (m *UnpinSnapshotResponse) GetErr() *Error {
+	if m != nil {
+		return m.Err
+	}
+	return nil
+}
+
 type Span struct {
 	Range            *Range   `protobuf:"bytes,1,opt,name=range" json:"range,omitempty"`
 	Equals           [][]byte `protobuf:"bytes,2,rep,name=equals" json:"equals,omitempty"`
@@ -743,6 +1030,158 @@ func (m *IndexProjection) GetPrimaryKey() bool {
 	return false
 }
 
+// Group-by and aggregates computed by indexer, one row is returned per
+// group with group key values followed by aggregate values.
+type GroupAggr struct {
+	GroupKeys        []*GroupKey  `protobuf:"bytes,1,rep,name=groupKeys" json:"groupKeys,omitempty"`
+	Aggrs            []*Aggregate `protobuf:"bytes,2,rep,name=aggrs" json:"aggrs,omitempty"`
+	XXX_unrecognized []byte       `json:"-"`
+}
+
+func (m *GroupAggr) Reset()         { *m = GroupAggr{} }
+func (m *GroupAggr) String() string { return proto.CompactTextString(m) }
+func (*GroupAggr) ProtoMessage()    {}
+
+func (m *GroupAggr) GetGroupKeys() []*GroupKey {
+	if m != nil {
+		return m.GroupKeys
+	}
+	return nil
+}
+
+func (m *GroupAggr) GetAggrs() []*Aggregate {
+	if m != nil {
+		return m.Aggrs
+	}
+	return nil
+}
+
+type GroupKey struct {
+	KeyPos           *int32 `protobuf:"varint,1,req,name=keyPos" json:"keyPos,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *GroupKey) Reset()         { *m = GroupKey{} }
+func (m *GroupKey) String() string { return proto.CompactTextString(m) }
+func (*GroupKey) ProtoMessage()    {}
+
+func (m *GroupKey) GetKeyPos() int32 {
+	if m != nil && m.KeyPos != nil {
+		return *m.KeyPos
+	}
+	return 0
+}
+
+type Aggregate struct {
+	AggrFunc         *uint32 `protobuf:"varint,1,req,name=aggrFunc" json:"aggrFunc,omitempty"`
+	KeyPos           *int32  `protobuf:"varint,2,req,name=keyPos" json:"keyPos,omitempty"`
+	Distinct         *bool   `protobuf:"varint,3,opt,name=distinct" json:"distinct,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *Aggregate) Reset()         { *m = Aggregate{} }
+func (m *Aggregate) String() string { return proto.CompactTextString(m) }
+func (*Aggregate) ProtoMessage()    {}
+
+func (m *Aggregate) GetAggrFunc() uint32 {
+	if m != nil && m.AggrFunc != nil {
+		return *m.AggrFunc
+	}
+	return 0
+}
+
+func (m *Aggregate) GetKeyPos() int32 {
+	if m != nil && m.KeyPos != nil {
+		return *m.KeyPos
+	}
+	return 0
+}
+
+func (m *Aggregate) GetDistinct() bool {
+	if m != nil && m.Distinct != nil {
+		return *m.Distinct
+	}
+	return false
+}
+
+// Order rows returned by indexer on one or more keys of the row, only
+// the top offset+limit rows are retained while scanning.
+type IndexSort struct {
+	SortKeys         []*SortKey `protobuf:"bytes,1,rep,name=sortKeys" json:"sortKeys,omitempty"`
+	XXX_unrecognized []byte     `json:"-"`
+}
+
+func (m *IndexSort) Reset()         { *m = IndexSort{} }
+func (m *IndexSort) String() string { return proto.CompactTextString(m) }
+func (*IndexSort) ProtoMessage()    {}
+
+func (m *IndexSort) GetSortKeys() []*SortKey {
+	if m != nil {
+		return m.SortKeys
+	}
+	return nil
+}
+
+type SortKey struct {
+	KeyPos           *int32 `protobuf:"varint,1,req,name=keyPos" json:"keyPos,omitempty"`
+	Desc             *bool  `protobuf:"varint,2,opt,name=desc" json:"desc,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *SortKey) Reset()         { *m = SortKey{} }
+func (m *SortKey) String() string { return proto.CompactTextString(m) }
+func (*SortKey) ProtoMessage()    {}
+
+func (m *SortKey) GetKeyPos() int32 {
+	if m != nil && m.KeyPos != nil {
+		return *m.KeyPos
+	}
+	return 0
+}
+
+func (m *SortKey) GetDesc() bool {
+	if m != nil && m.Desc != nil {
+		return *m.Desc
+	}
+	return false
+}
+
+// Position in index order, to resume a scan that failed after returning
+// entries. Scan resumes after the entry, count is the number of times
+// the entry was already returned, array entries can be returned more
+// than once.
+type ScanResume struct {
+	EntryKey         []byte `protobuf:"bytes,1,opt,name=entryKey" json:"entryKey,omitempty"`
+	PrimaryKey       []byte `protobuf:"bytes,2,req,name=primaryKey" json:"primaryKey,omitempty"`
+	Count            *int64 `protobuf:"varint,3,req,name=count" json:"count,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *ScanResume) Reset()         { *m = ScanResume{} }
+func (m *ScanResume) String() string { return proto.CompactTextString(m) }
+func (*ScanResume) ProtoMessage()    {}
+
+func (m *ScanResume) GetEntryKey() []byte {
+	if m != nil {
+		return m.EntryKey
+	}
+	return nil
+}
+
+func (m *ScanResume) GetPrimaryKey() []byte {
+	if m != nil {
+		return m.PrimaryKey
+	}
+	return nil
+}
+
+func (m *ScanResume) GetCount() int64 {
+	if m != nil && m.Count != nil {
+		return *m.Count
+	}
+	return 0
+}
+
 type IndexEntry struct {
 	EntryKey         []byte `protobuf:"bytes,1,opt,name=entryKey" json:"entryKey,omitempty"`
 	PrimaryKey       []byte `protobuf:"bytes,2,req,name=primaryKey" json:"primaryKey,omitempty"`
@@ -769,11 +1208,12 @@ func (m *IndexEntry) GetPrimaryKey() []byte {
 
 // Statistics of a given index.
 type IndexStatistics struct {
-	KeysCount        *uint64 `protobuf:"varint,1,req,name=keysCount" json:"keysCount,omitempty"`
-	UniqueKeysCount  *uint64 `protobuf:"varint,2,req,name=uniqueKeysCount" json:"uniqueKeysCount,omitempty"`
-	KeyMin           []byte  `protobuf:"bytes,3,req,name=keyMin" json:"keyMin,omitempty"`
-	KeyMax           []byte  `protobuf:"bytes,4,req,name=keyMax" json:"keyMax,omitempty"`
-	XXX_unrecognized []byte  `json:"-"`
+	KeysCount        *uint64            `protobuf:"varint,1,req,name=keysCount" json:"keysCount,omitempty"`
+	UniqueKeysCount  *uint64            `protobuf:"varint,2,req,name=uniqueKeysCount" json:"uniqueKeysCount,omitempty"`
+	KeyMin           []byte             `protobuf:"bytes,3,req,name=keyMin" json:"keyMin,omitempty"`
+	KeyMax           []byte             `protobuf:"bytes,4,req,name=keyMax" json:"keyMax,omitempty"`
+	Histogram        []*IndexStatistics `protobuf:"bytes,5,rep,name=histogram" json:"histogram,omitempty"`
+	XXX_unrecognized []byte             `json:"-"`
 }
 
 func (m *IndexStatistics) Reset()         { *m = IndexStatistics{} }
@@ -808,5 +1248,12 @@ func (m *IndexStatistics) GetKeyMax() []byte {
 	return nil
 }
 
+func (m *IndexStatistics) GetHistogram() []*IndexStatistics {
+	if m != nil {
+		return m.Histogram
+	}
+	return nil
+}
+
 func init() {
 }
@@ -210,6 +210,13 @@ func (b *cbqClient) DropIndex(defnID uint64) error {
 	return err
 }
 
+// AlterIndex implement BridgeAccessor{} interface.
+func (b *cbqClient) AlterIndex(
+	defnID uint64, secExprs []string, desc []bool, isArrayIndex bool) error {
+
+	return ErrorNotImplemented
+}
+
 // GetScanports implement BridgeAccessor{} interface.
 func (b *cbqClient) GetScanports() (queryports []string) {
 	return []string{b.queryport}
@@ -224,11 +231,23 @@ func (b *cbqClient) GetScanport(
 	return b.queryport, defnID, 0, int64(math.MaxInt64), true
 }
 
+// GetPartitionScanports implement BridgeAccessor{} interface.
+func (b *cbqClient) GetPartitionScanports(
+	defnID uint64) (queryports map[common.PartitionId]string, ok bool) {
+
+	return nil, false
+}
+
 // GetIndexDefn implements BridgeAccessor{} interface.
 func (b *cbqClient) GetIndexDefn(defnID uint64) *common.IndexDefn {
 	panic("cbqClient does not implement GetIndexDefn")
 }
 
+// GetIndexInstVersion implements BridgeAccessor{} interface.
+func (b *cbqClient) GetIndexInstVersion(instID uint64) (uint64, bool) {
+	return 0, false
+}
+
 // Timeit implement BridgeAccessor{} interface.
 func (b *cbqClient) Timeit(defnID uint64, value float64) {
 	// TODO: do nothing ?
@@ -3,6 +3,9 @@ package client
 import "time"
 import "unsafe"
 import "io"
+import "math"
+import "math/rand"
+import "sync"
 import "sync/atomic"
 import "fmt"
 
@@ -53,11 +56,49 @@ type CompositeElementFilter struct {
 	Inclusion Inclusion
 }
 
+// IndexProjection of scan results. EntryKeys are positions of index keys
+// to return, positions following the index keys refer to INCLUDE columns
+// of the index in their order, those are returned only when projected.
 type IndexProjection struct {
 	EntryKeys  []int64
 	PrimaryKey bool
 }
 
+// GroupAggr to push down group-by and aggregates to indexer, a single
+// row is returned for each group, with values of Group keys followed by
+// values of Aggrs, in that order.
+type GroupAggr struct {
+	Group []*GroupKey
+	Aggrs []*Aggregate
+}
+
+// GroupKey specifies an index key, by position, to group by.
+type GroupKey struct {
+	KeyPos int32
+}
+
+// Aggregate specifies an aggregate function over an index key. KeyPos
+// -1 is only valid for AGG_COUNT and counts all entries of the group.
+type Aggregate struct {
+	AggrFunc common.AggrFuncType
+	KeyPos   int32
+	Distinct bool
+}
+
+// IndexSort to return rows ordered on one or more keys of the row,
+// instead of index order. Offset and limit apply to sorted rows, so
+// that ORDER BY ... LIMIT n does not stream the whole range.
+type IndexSort struct {
+	Keys []*SortKey
+}
+
+// SortKey specifies a key, by its position in the returned row, to
+// sort by.
+type SortKey struct {
+	KeyPos int32
+	Desc   bool
+}
+
 const (
 	// Neither does not include low-key and high-key
 	Neither Inclusion = iota
@@ -123,6 +164,11 @@ type BridgeAccessor interface {
 	//   from deferred list.
 	DropIndex(defnID uint64) error
 
+	// AlterIndex to change the key expressions of index specified by
+	// `defnID`. Index keeps serving scans with the old keys until
+	// the index is rebuilt with the new keys in the background.
+	AlterIndex(defnID uint64, secExprs []string, desc []bool, isArrayIndex bool) error
+
 	// GetScanports shall return list of queryports for all indexer in
 	// the cluster.
 	GetScanports() (queryports []string)
@@ -137,9 +183,19 @@ type BridgeAccessor interface {
 		retry int,
 		excludes map[uint64]bool) (queryport string, targetDefnID uint64, targetInstID uint64, rollbackTime int64, ok bool)
 
+	// GetPartitionScanports shall return the queryport of the indexer
+	// hosting each partition of a partitioned index `defnID`, ok is
+	// false if placement of partitions is not known.
+	GetPartitionScanports(
+		defnID uint64) (queryports map[common.PartitionId]string, ok bool)
+
 	// GetIndex will return the index-definition structure for defnID.
 	GetIndexDefn(defnID uint64) *common.IndexDefn
 
+	// GetIndexInstVersion returns the version of index instance `instID`,
+	// ok is false if the instance is not known.
+	GetIndexInstVersion(instID uint64) (version uint64, ok bool)
+
 	// IndexState returns the current state of index `defnID` and error.
 	IndexState(defnID uint64) (common.IndexState, error)
 
@@ -186,11 +242,13 @@ type GsiAccessor interface {
 		cons common.Consistency, vector *TsConsistency,
 		callb ResponseHandler) error
 
-	// Multiple scans with composite index filters
+	// Multiple scans with composite index filters, optionally
+	// filtered, grouped, aggregated and sorted by indexer.
 	MultiScan(
 		defnID uint64, requestId string, scans Scans,
-		reverse, distinct bool, projection *IndexProjection, offset, limit int64,
-		cons common.Consistency, vector *TsConsistency,
+		reverse, distinct bool, projection *IndexProjection,
+		groupAggr *GroupAggr, sort *IndexSort, filter string,
+		offset, limit int64, cons common.Consistency, vector *TsConsistency,
 		callb ResponseHandler) error
 
 	// CountLookup of all entries in index.
@@ -234,6 +292,8 @@ type GsiClient struct {
 	metaCh       chan bool      // listen to metadata changes
 	settings     *ClientSettings
 	killch       chan bool
+	hedger       *scanHedger
+	lease        *SnapshotLease // scans are served from pinned snapshot
 }
 
 // NewGsiClient returns client to access GSI cluster.
@@ -411,6 +471,20 @@ func (c *GsiClient) DropIndex(defnID uint64) error {
 	return err
 }
 
+// AlterIndex implements BridgeAccessor{} interface.
+func (c *GsiClient) AlterIndex(
+	defnID uint64, secExprs []string, desc []bool, isArrayIndex bool) error {
+
+	if c.bridge == nil {
+		return ErrorClientUninitialized
+	}
+	begin := time.Now()
+	err := c.bridge.AlterIndex(defnID, secExprs, desc, isArrayIndex)
+	fmsg := "AlterIndex %v secExprs:%v desc:%v isArrayIndex:%v - elapsed(%v), err(%v)"
+	logging.Infof(fmsg, defnID, secExprs, desc, isArrayIndex, time.Since(begin), err)
+	return err
+}
+
 // LookupStatistics for a single secondary-key.
 func (c *GsiClient) LookupStatistics(
 	defnID uint64, requestId string, value common.SecondaryKey) (common.IndexStatistics, error) {
@@ -448,18 +522,48 @@ func (c *GsiClient) Lookup(
 
 	begin := time.Now()
 
+	sr := newScanResume(callb)
 	err = c.doScan(
-		defnID, requestId,
-		func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64) (error, bool) {
-			var err error
+		defnID, requestId, sr.handler,
+		func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64,
+			handler ResponseHandler) (error, bool) {
 
-			vector, err = c.getConsistency(qc, cons, vector, index.Bucket)
+			vector, err := sr.consistency(c, qc, cons, vector, index.Bucket)
+			if err != nil {
+				return err, false
+			}
+			nodes, err := c.partitionNodes(index, qc, nil)
 			if err != nil {
 				return err, false
 			}
-			return qc.Lookup(
-				uint64(index.DefnId), requestId, values, distinct, limit, cons,
-				vector, callb, rollbackTime)
+			qc = nodes[0].qc
+			resumable := isResumableScan(index, false, distinct, nil, nil, nil)
+			resume, err := sr.position(qc)
+			if err != nil {
+				return err, true
+			}
+			_, scanLimit, ok := sr.offsetLimit(0, limit)
+			if !ok {
+				handler(&protobuf.StreamEndResponse{})
+				return nil, false
+			}
+			if len(nodes) > 1 {
+				// every indexer looks up the partitions it hosts.
+				return c.scanNodes(
+					index, nodes, 0, limit, distinct, nil, handler,
+					func(qc *GsiScanClient, _ []common.PartitionId,
+						limit int64, handler ResponseHandler) error {
+
+						err, _ := qc.Lookup(
+							uint64(index.DefnId), requestId, values, distinct,
+							limit, cons, vector, handler, rollbackTime, nil)
+						return err
+					})
+			}
+			err, partial := qc.Lookup(
+				uint64(index.DefnId), requestId, values, distinct, scanLimit,
+				cons, vector, handler, rollbackTime, resume)
+			return sr.result(qc, requestId, resumable, err, partial)
 		})
 
 	if err != nil { // callback with error
@@ -496,16 +600,43 @@ func (c *GsiClient) Range(
 
 	begin := time.Now()
 
+	sr := newScanResume(callb)
 	err = c.doScan(
-		defnID, requestId,
-		func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64) (error, bool) {
-			var err error
+		defnID, requestId, sr.handler,
+		func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64,
+			handler ResponseHandler) (error, bool) {
 
-			vector, err = c.getConsistency(qc, cons, vector, index.Bucket)
+			vector, err := sr.consistency(c, qc, cons, vector, index.Bucket)
 			if err != nil {
 				return err, false
 			}
-			if c.bridge.IsPrimary(uint64(index.DefnId)) {
+			isPrimary := c.bridge.IsPrimary(uint64(index.DefnId))
+			// only partitions intersecting the span are scanned for
+			// range partitioned secondary index.
+			var partitions []common.PartitionId
+			var nodes []*nodePartitions
+			if !isPrimary {
+				partitions = rangePartitionsForSpan(index, low, high)
+				if partitions != nil && len(partitions) == 0 {
+					handler(&protobuf.StreamEndResponse{})
+					return nil, false
+				}
+				if nodes, err = c.partitionNodes(index, qc, partitions); err != nil {
+					return err, false
+				}
+				qc = nodes[0].qc
+			}
+			resumable := isResumableScan(index, false, distinct, nil, nil, nil)
+			resume, err := sr.position(qc)
+			if err != nil {
+				return err, true
+			}
+			_, scanLimit, ok := sr.offsetLimit(0, limit)
+			if !ok {
+				handler(&protobuf.StreamEndResponse{})
+				return nil, false
+			}
+			if isPrimary {
 				var l, h []byte
 				var what string
 				// primary keys are plain sequence of binary.
@@ -519,14 +650,30 @@ func (c *GsiClient) Range(
 						return nil, true
 					}
 				}
-				return qc.RangePrimary(
+				err, partial := qc.RangePrimary(
 					uint64(index.DefnId), requestId, l, h, inclusion, distinct,
-					limit, cons, vector, callb, rollbackTime)
+					scanLimit, cons, vector, handler, rollbackTime, resume)
+				return sr.result(qc, requestId, resumable, err, partial)
 			}
 			// dealing with secondary index.
-			return qc.Range(
+			if len(nodes) > 1 {
+				return c.scanNodes(
+					index, nodes, 0, limit, distinct, nil, handler,
+					func(qc *GsiScanClient, partitions []common.PartitionId,
+						limit int64, handler ResponseHandler) error {
+
+						err, _ := qc.Range(
+							uint64(index.DefnId), requestId, low, high,
+							inclusion, distinct, partitions, limit, cons,
+							vector, handler, rollbackTime, nil)
+						return err
+					})
+			}
+			err, partial := qc.Range(
 				uint64(index.DefnId), requestId, low, high, inclusion, distinct,
-				limit, cons, vector, callb, rollbackTime)
+				partitions, scanLimit, cons, vector, handler, rollbackTime,
+				resume)
+			return sr.result(qc, requestId, resumable, err, partial)
 		})
 
 	if err != nil { // callback with error
@@ -562,16 +709,48 @@ func (c *GsiClient) ScanAll(
 
 	begin := time.Now()
 
+	sr := newScanResume(callb)
 	err = c.doScan(
-		defnID, requestId,
-		func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64) (error, bool) {
-			var err error
+		defnID, requestId, sr.handler,
+		func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64,
+			handler ResponseHandler) (error, bool) {
 
-			vector, err = c.getConsistency(qc, cons, vector, index.Bucket)
+			vector, err := sr.consistency(c, qc, cons, vector, index.Bucket)
 			if err != nil {
 				return err, false
 			}
-			return qc.ScanAll(uint64(index.DefnId), requestId, limit, cons, vector, callb, rollbackTime)
+			nodes, err := c.partitionNodes(index, qc, nil)
+			if err != nil {
+				return err, false
+			}
+			qc = nodes[0].qc
+			resumable := isResumableScan(index, false, false, nil, nil, nil)
+			resume, err := sr.position(qc)
+			if err != nil {
+				return err, true
+			}
+			_, scanLimit, ok := sr.offsetLimit(0, limit)
+			if !ok {
+				handler(&protobuf.StreamEndResponse{})
+				return nil, false
+			}
+			if len(nodes) > 1 {
+				// every indexer scans the partitions it hosts.
+				return c.scanNodes(
+					index, nodes, 0, limit, false, nil, handler,
+					func(qc *GsiScanClient, _ []common.PartitionId,
+						limit int64, handler ResponseHandler) error {
+
+						err, _ := qc.ScanAll(
+							uint64(index.DefnId), requestId, limit, cons,
+							vector, handler, rollbackTime, nil)
+						return err
+					})
+			}
+			err, partial := qc.ScanAll(
+				uint64(index.DefnId), requestId, scanLimit, cons, vector,
+				handler, rollbackTime, resume)
+			return sr.result(qc, requestId, resumable, err, partial)
 		})
 
 	if err != nil { // callback with error
@@ -586,10 +765,18 @@ func (c *GsiClient) ScanAll(
 	return
 }
 
+// MultiScan scans index with composite index filters. If groupAggr is
+// not nil, indexer returns a row per group instead of index entries.
+// If sort is not nil, rows are returned in sort order instead of index
+// order. If filter is not empty, it is a N1QL predicate on the keys of
+// index entries, referred by position as self[i], and indexer skips
+// entries for which the predicate is not true, before grouping and
+// sorting.
 func (c *GsiClient) MultiScan(
 	defnID uint64, requestId string, scans Scans, reverse,
-	distinct bool, projection *IndexProjection, offset, limit int64,
-	cons common.Consistency, vector *TsConsistency,
+	distinct bool, projection *IndexProjection,
+	groupAggr *GroupAggr, sort *IndexSort, filter string,
+	offset, limit int64, cons common.Consistency, vector *TsConsistency,
 	callb ResponseHandler) (err error) {
 
 	if c.bridge == nil {
@@ -605,27 +792,146 @@ func (c *GsiClient) MultiScan(
 		return
 	}
 
+	if groupAggr != nil && c.bridge.IsPrimary(defnID) {
+		err = ErrorGroupAggrOnPrimary
+		protoResp := &protobuf.ResponseStream{
+			Err: &protobuf.Error{Error: proto.String(err.Error())},
+		}
+		callb(protoResp)
+		return
+	}
+
+	if sort != nil && c.bridge.IsPrimary(defnID) {
+		err = ErrorSortOnPrimary
+		protoResp := &protobuf.ResponseStream{
+			Err: &protobuf.Error{Error: proto.String(err.Error())},
+		}
+		callb(protoResp)
+		return
+	}
+
+	if filter != "" && c.bridge.IsPrimary(defnID) {
+		err = ErrorFilterOnPrimary
+		protoResp := &protobuf.ResponseStream{
+			Err: &protobuf.Error{Error: proto.String(err.Error())},
+		}
+		callb(protoResp)
+		return
+	}
+
 	begin := time.Now()
 
+	sr := newScanResume(callb)
 	err = c.doScan(
-		defnID, requestId,
-		func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64) (error, bool) {
-			var err error
+		defnID, requestId, sr.handler,
+		func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64,
+			handler ResponseHandler) (error, bool) {
 
-			vector, err = c.getConsistency(qc, cons, vector, index.Bucket)
+			if filter != "" && !qc.SupportsScanFilter() {
+				return ErrorScanFilterUnsupported, false
+			}
+			vector, err := sr.consistency(c, qc, cons, vector, index.Bucket)
 			if err != nil {
 				return err, false
 			}
+			// only partitions intersecting the scans are scanned for
+			// range partitioned index. Partitions of a hash partitioned
+			// index are gathered, except for aggregates which are
+			// computed by the indexers hosting the partitions.
+			isPrimary := c.bridge.IsPrimary(uint64(index.DefnId))
+			var partitions []common.PartitionId
+			var nodes []*nodePartitions
+			if !isPrimary && index.PartitionScheme == common.RANGE {
+				partitions = rangePartitions(index, scans)
+				if partitions == nil || len(partitions) > 0 {
+					nodes, err = c.partitionNodes(index, qc, partitions)
+					if err != nil {
+						return err, false
+					}
+					qc = nodes[0].qc
+				}
+			} else if !isPrimary && groupAggr != nil {
+				if nodes, err = c.partitionNodes(index, qc, nil); err != nil {
+					return err, false
+				}
+				qc = nodes[0].qc
+			}
+			resumable := isResumableScan(
+				index, reverse, distinct, projection, groupAggr, sort)
+			resume, err := sr.position(qc)
+			if err != nil {
+				return err, true
+			}
+			scanOffset, scanLimit, ok := sr.offsetLimit(offset, limit)
+			if !ok {
+				handler(&protobuf.StreamEndResponse{})
+				return nil, false
+			}
 
-			if c.bridge.IsPrimary(uint64(index.DefnId)) {
-				return qc.MultiScanPrimary(
+			if isPrimary {
+				err, partial := qc.MultiScanPrimary(
 					uint64(index.DefnId), requestId, scans, reverse, distinct,
-					projection, offset, limit, cons, vector, callb, rollbackTime)
+					projection, scanOffset, scanLimit, cons, vector,
+					handler, rollbackTime, resume)
+				return sr.result(qc, requestId, resumable, err, partial)
+			}
+
+			// aggregates computed by indexers hosting the partitions
+			// are merged by the client.
+			if groupAggr != nil && len(nodes) > 1 {
+				return c.multiScanGroups(
+					index, nodes, requestId, scans, reverse, distinct,
+					projection, groupAggr, sort, filter, offset, limit, cons,
+					vector, handler, rollbackTime)
 			}
 
-			return qc.MultiScan(
+			// partitions of range partitioned index are scanned in a
+			// single request to each indexer hosting them, so that
+			// indexer returns entries in index order.
+			if index.PartitionScheme == common.RANGE {
+				if partitions != nil && len(partitions) == 0 {
+					if groupAggr == nil {
+						handler(&protobuf.StreamEndResponse{})
+						return nil, false
+					}
+					// scan all, indexer shall return aggregates over no entries.
+					partitions = nil
+				}
+				if len(nodes) > 1 {
+					return c.scanNodes(
+						index, nodes, offset, limit, distinct && projection == nil,
+						sort, handler,
+						func(qc *GsiScanClient, partitions []common.PartitionId,
+							limit int64, handler ResponseHandler) error {
+
+							err, _ := qc.MultiScan(
+								uint64(index.DefnId), requestId, scans, reverse,
+								distinct, projection, nil, sort, filter, partitions,
+								0, limit, cons, vector, handler, rollbackTime, nil)
+							return err
+						})
+				}
+				err, partial := qc.MultiScan(
+					uint64(index.DefnId), requestId, scans, reverse, distinct,
+					projection, groupAggr, sort, filter, partitions, scanOffset,
+					scanLimit, cons, vector, handler, rollbackTime, resume)
+				return sr.result(qc, requestId, resumable, err, partial)
+			}
+
+			// indexer aggregates across partitions, gather is only
+			// required for index entries.
+			if index.GetNumPartitions() > 1 && groupAggr == nil {
+				return c.multiScanPartitions(
+					qc, index, requestId, scans, reverse, distinct,
+					projection, sort, filter, offset, limit, cons, vector,
+					handler, rollbackTime)
+			}
+
+			err, partial := qc.MultiScan(
 				uint64(index.DefnId), requestId, scans, reverse, distinct,
-				projection, offset, limit, cons, vector, callb, rollbackTime)
+				projection, groupAggr, sort, filter, nil, scanOffset, scanLimit,
+				cons, vector, handler, rollbackTime, resume)
+			return sr.result(qc, requestId, resumable, err, partial)
 		})
 
 	if err != nil { // callback with error
@@ -657,8 +963,9 @@ func (c *GsiClient) CountLookup(
 	begin := time.Now()
 
 	err = c.doScan(
-		defnID, requestId,
-		func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64) (error, bool) {
+		defnID, requestId, nil,
+		func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64,
+			_ ResponseHandler) (error, bool) {
 			var err error
 
 			vector, err = c.getConsistency(qc, cons, vector, index.Bucket)
@@ -679,8 +986,20 @@ func (c *GsiClient) CountLookup(
 				return err, false
 			}
 
-			count, err = qc.CountLookup(uint64(index.DefnId), requestId, values, cons, vector, rollbackTime)
-			return err, false
+			// entries of partitions are counted by the indexers hosting them.
+			qcs, err := c.nodeClients(index, qc)
+			if err != nil {
+				return err, false
+			}
+			count = 0
+			for _, qc := range qcs {
+				n, err := qc.CountLookup(uint64(index.DefnId), requestId, values, cons, vector, rollbackTime)
+				if err != nil {
+					return err, false
+				}
+				count += n
+			}
+			return nil, false
 		})
 
 	fmsg := "CountLookup {%v,%v} - elapsed(%v) err(%v)"
@@ -707,8 +1026,9 @@ func (c *GsiClient) CountRange(
 	begin := time.Now()
 
 	err = c.doScan(
-		defnID, requestId,
-		func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64) (error, bool) {
+		defnID, requestId, nil,
+		func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64,
+			_ ResponseHandler) (error, bool) {
 			var err error
 
 			vector, err = c.getConsistency(qc, cons, vector, index.Bucket)
@@ -734,9 +1054,21 @@ func (c *GsiClient) CountRange(
 				return err, false
 			}
 
-			count, err = qc.CountRange(
-				uint64(index.DefnId), requestId, low, high, inclusion, cons, vector, rollbackTime)
-			return err, false
+			// entries of partitions are counted by the indexers hosting them.
+			qcs, err := c.nodeClients(index, qc)
+			if err != nil {
+				return err, false
+			}
+			count = 0
+			for _, qc := range qcs {
+				n, err := qc.CountRange(
+					uint64(index.DefnId), requestId, low, high, inclusion, cons, vector, rollbackTime)
+				if err != nil {
+					return err, false
+				}
+				count += n
+			}
+			return nil, false
 		})
 
 	fmsg := "CountRange {%v,%v} - elapsed(%v) err(%v)"
@@ -761,8 +1093,9 @@ func (c *GsiClient) MultiScanCount(
 	begin := time.Now()
 
 	err = c.doScan(
-		defnID, requestId,
-		func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64) (error, bool) {
+		defnID, requestId, nil,
+		func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64,
+			_ ResponseHandler) (error, bool) {
 			var err error
 
 			vector, err = c.getConsistency(qc, cons, vector, index.Bucket)
@@ -775,9 +1108,33 @@ func (c *GsiClient) MultiScanCount(
 				return err, false
 			}
 
-			count, err = qc.MultiScanCount(
-				uint64(index.DefnId), requestId, scans, distinct, cons, vector, rollbackTime)
-			return err, false
+			// entries of partitions are counted by the indexers hosting
+			// them, distinct entries across indexers are gathered and
+			// counted by the client.
+			if distinct {
+				nodes, err := c.partitionNodes(index, qc, nil)
+				if err != nil {
+					return err, false
+				} else if len(nodes) > 1 {
+					return c.multiScanCountNodes(
+						index, nodes, requestId, scans, cons, vector,
+						rollbackTime, &count), false
+				}
+			}
+			qcs, err := c.nodeClients(index, qc)
+			if err != nil {
+				return err, false
+			}
+			count = 0
+			for _, qc := range qcs {
+				n, err := qc.MultiScanCount(
+					uint64(index.DefnId), requestId, scans, distinct, cons, vector, rollbackTime)
+				if err != nil {
+					return err, false
+				}
+				count += n
+			}
+			return nil, false
 		})
 
 	fmsg := "MultiScanCount {%v,%v} - elapsed(%v) err(%v)"
@@ -856,9 +1213,17 @@ func (c *GsiClient) updateScanClients() {
 	}
 }
 
+// doScan runs `callb` on a replica of index `defnID`, retrying on other
+// replicas on failure, or on the indexer hosting the pinned snapshot for
+// client returned by WithSnapshot. Scans streaming responses to `handler` are hedged
+// if enabled, `handler` is nil for other scans.
 func (c *GsiClient) doScan(
-	defnID uint64, requestId string,
-	callb func(*GsiScanClient, *common.IndexDefn, int64) (error, bool)) (err error) {
+	defnID uint64, requestId string, handler ResponseHandler,
+	callb func(*GsiScanClient, *common.IndexDefn, int64, ResponseHandler) (error, bool)) (err error) {
+
+	if c.lease != nil {
+		return c.doSnapshotScan(defnID, handler, callb)
+	}
 
 	var qc *GsiScanClient
 	var ok1, ok2, partial bool
@@ -872,14 +1237,36 @@ func (c *GsiClient) doScan(
 	wait := c.config["retryIntervalScanport"].Int()
 	retry := c.config["retryScanPort"].Int()
 	evictRetry := c.config["settings.poolSize"].Int()
+	rejectRetry := c.config["scanRejectRetries"].Int()
+	backoff := time.Duration(c.config["scanRejectBackoff"].Int()) * time.Millisecond
+	maxBackoff := time.Duration(c.config["scanRejectMaxBackoff"].Int()) * time.Millisecond
+	hedge := handler != nil && c.hedgeScans()
 	for i := 0; true; {
 		qcs :=
 			*((*map[string]*GsiScanClient)(atomic.LoadPointer(&c.queryClients)))
 		if queryport, targetDefnID, targetInstID, rollbackTime, ok1 = c.bridge.GetScanport(defnID, i, excludes); ok1 {
 			index := c.bridge.GetIndexDefn(targetDefnID)
 			if qc, ok2 = qcs[queryport]; ok2 {
+				qc = c.instanceClient(qc, targetInstID)
 				begin := time.Now()
-				scan_err, partial = callb(qc, index, rollbackTime)
+				if hedge {
+					// only the first attempt is hedged.
+					hedge = false
+					target := hedgeTarget{
+						qc:           qc,
+						index:        index,
+						queryport:    queryport,
+						defnID:       targetDefnID,
+						instID:       targetInstID,
+						rollbackTime: rollbackTime,
+					}
+					scan_err, partial, target = c.hedgedScan(
+						defnID, requestId, target, excludes, handler, callb)
+					index, queryport = target.index, target.queryport
+					targetDefnID, targetInstID = target.defnID, target.instID
+				} else {
+					scan_err, partial = callb(qc, index, rollbackTime, handler)
+				}
 				if c.isTimeit(scan_err) {
 					c.bridge.Timeit(targetInstID, float64(time.Since(begin)))
 					return scan_err
@@ -916,6 +1303,22 @@ func (c *GsiClient) doScan(
 			continue
 		}
 
+		// If indexer rejected the scan and there is no other replica to try,
+		// back off before retrying, so that an overloaded indexer can catch up.
+		if isScanRejected(scan_err) && rejectRetry > 0 {
+			rejectRetry--
+			excludes = nil
+			wait := backoff + time.Duration(rand.Int63n(int64(backoff)/2+1))
+			logging.Warnf(
+				"Scan rejected for index %v:%v, reqId:%v, retrying after %v ...\n",
+				targetDefnID, targetInstID, requestId, wait)
+			time.Sleep(wait)
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+
 		// If we cannot find a valid scansport, then retry up to retryScanport by refreshing
 		// the clients.
 		if i = i + 1; i < retry {
@@ -935,6 +1338,343 @@ func (c *GsiClient) doScan(
 	return ErrorNoHost
 }
 
+// multiScanPartitions scatter the scan to every partition of a hash
+// partitioned index and gather the entries into `callb`. Entries are
+// not ordered across partitions unless sorted, offset and limit are
+// applied on the gathered entries.
+func (c *GsiClient) multiScanPartitions(
+	qc *GsiScanClient, index *common.IndexDefn, requestId string,
+	scans Scans, reverse, distinct bool, projection *IndexProjection,
+	sort *IndexSort, filter string, offset, limit int64,
+	cons common.Consistency, vector *TsConsistency, callb ResponseHandler,
+	rollbackTime int64) (error, bool) {
+
+	numPartitions := index.GetNumPartitions()
+	clients, err := c.partitionClients(index, qc)
+	if err != nil {
+		return err, false
+	}
+	// indexer applies distinct only when there is no projection.
+	gather := newPartitionGather(
+		offset, limit, distinct && projection == nil, sort, callb)
+
+	// every partition shall return enough entries to satisfy offset+limit.
+	partnLimit := limit
+	if offset > 0 && limit > 0 && limit <= math.MaxInt64-offset {
+		partnLimit = offset + limit
+	}
+
+	errs := make([]error, numPartitions)
+	var wg sync.WaitGroup
+	for i := 0; i < numPartitions; i++ {
+		wg.Add(1)
+		go func(partnId common.PartitionId) {
+			defer wg.Done()
+			errs[partnId], _ = clients[partnId].MultiScan(
+				uint64(index.DefnId), requestId, scans, reverse, distinct,
+				projection, nil, sort, filter, []common.PartitionId{partnId},
+				0, partnLimit, cons, vector, gather.handler, rollbackTime, nil)
+		}(common.PartitionId(i))
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err, gather.forwarded()
+		}
+	}
+	// sorted entries are forwarded only after all partitions are
+	// gathered, a failed scan is retried on another replica.
+	gather.flush()
+	if gather.complete() {
+		callb(&protobuf.StreamEndResponse{})
+	}
+	return nil, false
+}
+
+// scanNodes scatters a scan to the indexers hosting partitions of index
+// and gathers their entries into `callb`, applying offset, limit, distinct
+// and sort across the indexers. `scan` is called for each of `nodes`
+// with the limit of entries to return. Indexers of a range partitioned
+// index are scanned one after another, so that entries are gathered in
+// index order unless sorted, indexers of a hash partitioned index are
+// scanned concurrently.
+func (c *GsiClient) scanNodes(
+	index *common.IndexDefn, nodes []*nodePartitions,
+	offset, limit int64, distinct bool, sort *IndexSort,
+	callb ResponseHandler,
+	scan func(qc *GsiScanClient, partitions []common.PartitionId,
+		limit int64, handler ResponseHandler) error) (error, bool) {
+
+	gather := newPartitionGather(offset, limit, distinct, sort, callb)
+
+	// every indexer shall return enough entries to satisfy offset+limit.
+	nodeLimit := limit
+	if offset > 0 && limit > 0 && limit <= math.MaxInt64-offset {
+		nodeLimit = offset + limit
+	}
+
+	if index.PartitionScheme == common.RANGE && sort == nil {
+		for _, node := range nodes {
+			if gather.ended() {
+				break
+			}
+			err := scan(node.qc, node.partitions, nodeLimit, gather.handler)
+			if err != nil {
+				return err, gather.forwarded()
+			}
+		}
+
+	} else {
+		errs := make([]error, len(nodes))
+		var wg sync.WaitGroup
+		for i, node := range nodes {
+			wg.Add(1)
+			go func(i int, node *nodePartitions) {
+				defer wg.Done()
+				errs[i] = scan(node.qc, node.partitions, nodeLimit, gather.handler)
+			}(i, node)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			if err != nil {
+				return err, gather.forwarded()
+			}
+		}
+	}
+
+	gather.flush()
+	if gather.complete() {
+		callb(&protobuf.StreamEndResponse{})
+	}
+	return nil, false
+}
+
+// multiScanGroups scatters a scan with group-by/aggregate pushdown to
+// the indexers hosting partitions of index, and merges the rows of each
+// group returned by them into `callb`. Offset and limit apply to merged
+// rows, which are sorted on the group keys unless sorted otherwise.
+func (c *GsiClient) multiScanGroups(
+	index *common.IndexDefn, nodes []*nodePartitions, requestId string,
+	scans Scans, reverse, distinct bool, projection *IndexProjection,
+	groupAggr *GroupAggr, sort *IndexSort, filter string,
+	offset, limit int64, cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler, rollbackTime int64) (error, bool) {
+
+	merge := newGroupMerge(groupAggr)
+	err, _ := c.scanNodes(
+		index, nodes, 0, 0, false, nil, merge.handler,
+		func(qc *GsiScanClient, partitions []common.PartitionId,
+			limit int64, handler ResponseHandler) error {
+
+			if merge.entries {
+				// entries are aggregated by the client.
+				err, _ := qc.MultiScan(
+					uint64(index.DefnId), requestId, scans, false, false, nil,
+					nil, nil, filter, partitions, 0, limit, cons, vector,
+					handler, rollbackTime, nil)
+				return err
+			}
+			err, _ := qc.MultiScan(
+				uint64(index.DefnId), requestId, scans, reverse, distinct,
+				projection, groupAggr, nil, filter, partitions, 0, limit,
+				cons, vector, handler, rollbackTime, nil)
+			return err
+		})
+	if err != nil {
+		return err, false
+	}
+	rows, err := merge.rows()
+	if err != nil {
+		return err, false
+	}
+
+	// rows are forwarded only after all indexers are merged, a failed
+	// scan is retried on another replica.
+	if sort == nil {
+		sort = groupSort(index, groupAggr)
+	}
+	gather := newPartitionGather(offset, limit, false, sort, callb)
+	if len(rows) > 0 {
+		gather.handler(&partitionResponse{skeys: rows})
+	}
+	gather.flush()
+	if gather.complete() {
+		callb(&protobuf.StreamEndResponse{})
+	}
+	return nil, false
+}
+
+// multiScanCountNodes counts distinct entries of index hosted across
+// indexers into `count`, by gathering the distinct entries of each
+// indexer.
+func (c *GsiClient) multiScanCountNodes(
+	index *common.IndexDefn, nodes []*nodePartitions, requestId string,
+	scans Scans, cons common.Consistency, vector *TsConsistency,
+	rollbackTime int64, count *int64) error {
+
+	*count = 0
+	err, _ := c.scanNodes(
+		index, nodes, 0, 0, true, nil,
+		func(resp ResponseReader) bool {
+			if skeys, _, err := resp.GetEntries(); err == nil {
+				*count += int64(len(skeys))
+			}
+			return true
+		},
+		func(qc *GsiScanClient, partitions []common.PartitionId,
+			limit int64, handler ResponseHandler) error {
+
+			err, _ := qc.MultiScan(
+				uint64(index.DefnId), requestId, scans, false, true, nil, nil,
+				nil, "", partitions, 0, limit, cons, vector, handler,
+				rollbackTime, nil)
+			return err
+		})
+	return err
+}
+
+// partitionClients returns the scan client of the indexer hosting each
+// partition of a partitioned index. Partitions are hosted by the indexer
+// of `qc`, picked for the scan, unless metadata places them across
+// indexers. Scans on other indexers are ended along with the scans of
+// `qc`, see withCancel(), and are rejected by indexers serving another
+// version of the index instance, see withInstVersion(). Scans on a pinned
+// snapshot fail, since other indexers don't have the snapshot.
+func (c *GsiClient) partitionClients(
+	index *common.IndexDefn,
+	qc *GsiScanClient) (map[common.PartitionId]*GsiScanClient, error) {
+
+	numPartitions := index.GetNumPartitions()
+	clients := make(map[common.PartitionId]*GsiScanClient)
+	queryports, ok := c.bridge.GetPartitionScanports(uint64(index.DefnId))
+	if !ok || len(queryports) <= 1 {
+		// placement not known, or index created by older indexer.
+		for i := 0; i < numPartitions; i++ {
+			clients[common.PartitionId(i)] = qc
+		}
+		return clients, nil
+	}
+
+	qcs := *((*map[string]*GsiScanClient)(atomic.LoadPointer(&c.queryClients)))
+	for i := 0; i < numPartitions; i++ {
+		partnId := common.PartitionId(i)
+		queryport, ok := queryports[partnId]
+		if !ok {
+			return nil, ErrorPartitionUnavailable
+		} else if queryport == qc.queryport {
+			clients[partnId] = qc
+			continue
+		}
+		pqc, ok := qcs[queryport]
+		if !ok {
+			return nil, ErrorPartitionUnavailable
+		} else if qc.leaseId != 0 {
+			// partitions moved since the snapshot was pinned.
+			return nil, ErrorSnapshotLeaseAcrossNodes
+		}
+		pqc = pqc.withCancel(qc.cancelch)
+		if qc.instVersion != nil {
+			pqc = pqc.withInstVersion(*qc.instVersion)
+		}
+		clients[partnId] = pqc
+	}
+	return clients, nil
+}
+
+// instanceClient returns scan client `qc` for scans on index instance
+// `instID`. Indexer rejects the scans if it serves another version of the
+// instance, so that replicas of an altered index are not scanned with the
+// key expressions of the other version while the alter is in progress.
+func (c *GsiClient) instanceClient(qc *GsiScanClient, instID uint64) *GsiScanClient {
+	if version, ok := c.bridge.GetIndexInstVersion(instID); ok {
+		return qc.withInstVersion(version)
+	}
+	return qc
+}
+
+// nodePartitions are the partitions of an index hosted by the indexer
+// of scan client qc.
+type nodePartitions struct {
+	qc         *GsiScanClient
+	partitions []common.PartitionId
+}
+
+// partitionNodes groups partitions `partnIds` of index, all partitions
+// if nil, by the indexer hosting them. Indexers are ordered by their
+// first partition in the order indexer scans partitions, since range
+// partitions are placed on indexers in contiguous runs, entries of the
+// indexers in that order are in index order.
+func (c *GsiClient) partitionNodes(
+	index *common.IndexDefn, qc *GsiScanClient,
+	partnIds []common.PartitionId) ([]*nodePartitions, error) {
+
+	if index.GetNumPartitions() <= 1 {
+		return []*nodePartitions{{qc: qc, partitions: partnIds}}, nil
+	}
+	clients, err := c.partitionClients(index, qc)
+	if err != nil {
+		return nil, err
+	}
+	if partnIds == nil {
+		for i := 0; i < index.GetNumPartitions(); i++ {
+			partnIds = append(partnIds, common.PartitionId(i))
+		}
+	}
+	ordered := make([]common.PartitionId, len(partnIds))
+	copy(ordered, partnIds)
+	if index.PartitionScheme == common.RANGE && len(index.Desc) > 0 && index.Desc[0] {
+		for i, j := 0, len(ordered)-1; i < j; i, j = i+1, j-1 {
+			ordered[i], ordered[j] = ordered[j], ordered[i]
+		}
+	}
+
+	nodes := make([]*nodePartitions, 0)
+	for _, partnId := range ordered {
+		pqc, ok := clients[partnId]
+		if !ok {
+			return nil, ErrorPartitionUnavailable
+		}
+		var node *nodePartitions
+		for _, n := range nodes {
+			if n.qc == pqc {
+				node = n
+				break
+			}
+		}
+		if node == nil {
+			node = &nodePartitions{qc: pqc}
+			nodes = append(nodes, node)
+		}
+		node.partitions = append(node.partitions, partnId)
+	}
+	return nodes, nil
+}
+
+// nodeClients returns the scan clients of indexers hosting partitions
+// of index, count of entries in the index is the sum across them.
+func (c *GsiClient) nodeClients(
+	index *common.IndexDefn, qc *GsiScanClient) ([]*GsiScanClient, error) {
+
+	if index.GetNumPartitions() <= 1 {
+		return []*GsiScanClient{qc}, nil
+	}
+	clients, err := c.partitionClients(index, qc)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[*GsiScanClient]bool)
+	qcs := make([]*GsiScanClient, 0)
+	for _, pqc := range clients {
+		if !seen[pqc] {
+			seen[pqc] = true
+			qcs = append(qcs, pqc)
+		}
+	}
+	return qcs, nil
+}
+
 func (c *GsiClient) isTimeit(err error) bool {
 	if err == nil {
 		return true
@@ -944,6 +1684,12 @@ func (c *GsiClient) isTimeit(err error) bool {
 	return false
 }
 
+// isScanRejected returns true if indexer rejected the scan by admission
+// control, which is retried after backing off.
+func isScanRejected(err error) bool {
+	return err != nil && err.Error() == ErrScanRejected.Error()
+}
+
 func (c *GsiClient) getConsistency(
 	qc *GsiScanClient, cons common.Consistency,
 	vector *TsConsistency, bucket string) (*TsConsistency, error) {
@@ -1040,6 +1786,7 @@ func makeWithMetaProvider(
 		metaCh:       make(chan bool, 1),
 		settings:     NewClientSettings(needRefresh),
 		killch:       make(chan bool, 1),
+		hedger:       newScanHedger(),
 	}
 	atomic.StorePointer(&c.bucketHash, (unsafe.Pointer)(new(map[string]uint64)))
 	c.bridge, err = newMetaBridgeClient(cluster, config, c.metaCh, c.settings)
@@ -1,5 +1,6 @@
 package client
 
+import "crypto/tls"
 import "errors"
 import "fmt"
 import "net"
@@ -27,6 +28,7 @@ type connectionPool struct {
 	maxPayload   int
 	timeout      time.Duration
 	availTimeout time.Duration
+	tlsConfig    *tls.Config // nil for plaintext connections
 	logPrefix    string
 }
 
@@ -59,7 +61,13 @@ var ConnPoolCallback func(host string, source string, start time.Time, err error
 
 func (cp *connectionPool) defaultMkConn(host string) (*connection, error) {
 	logging.Infof("%v open new connection ...\n", cp.logPrefix)
-	conn, err := net.Dial("tcp", host)
+	var conn net.Conn
+	var err error
+	if cp.tlsConfig != nil {
+		conn, err = tls.Dial("tcp", host, cp.tlsConfig)
+	} else {
+		conn, err = net.Dial("tcp", host)
+	}
 	if err != nil {
 		return nil, err
 	}
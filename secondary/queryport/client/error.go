@@ -27,20 +27,66 @@ var ErrorInvalidConsistency = errors.New("queryport.invalidConsistency")
 // ErrorExpectedTimestamp
 var ErrorExpectedTimestamp = errors.New("queryport.expectedTimestamp")
 
-// These error strings need to be in sync with common.ErrIndexNotFound
-// and common.ErrIndexNotReady.
+// ErrorGroupAggrOnPrimary
+var ErrorGroupAggrOnPrimary = errors.New("queryport.groupAggrOnPrimary")
+
+// ErrorSortOnPrimary
+var ErrorSortOnPrimary = errors.New("queryport.sortOnPrimary")
+
+// ErrorInvalidSortKey
+var ErrorInvalidSortKey = errors.New("queryport.invalidSortKey")
+
+// ErrorSnapshotLeaseUnsupported
+var ErrorSnapshotLeaseUnsupported = errors.New("queryport.snapshotLeaseUnsupported")
+
+// ErrorSnapshotLeaseMismatch
+var ErrorSnapshotLeaseMismatch = errors.New("queryport.snapshotLeaseMismatch")
+
+// ErrorSnapshotLeaseAcrossNodes
+var ErrorSnapshotLeaseAcrossNodes = errors.New("queryport.snapshotLeaseAcrossNodes")
+
+// ErrorFilterOnPrimary
+var ErrorFilterOnPrimary = errors.New("queryport.filterOnPrimary")
+
+// ErrorScanFilterUnsupported
+var ErrorScanFilterUnsupported = errors.New("queryport.scanFilterUnsupported")
+
+// ErrorNotExpiryIndex
+var ErrorNotExpiryIndex = errors.New("queryport.notExpiryIndex")
+
+// ErrorPartitionUnavailable
+var ErrorPartitionUnavailable = errors.New("queryport.partitionUnavailable")
+
+// ErrorInvalidGroupRow
+var ErrorInvalidGroupRow = errors.New("queryport.invalidGroupRow")
+
+// These error strings need to be in sync with common.ErrIndexNotFound,
+// common.ErrIndexNotReady and common.ErrScanRejected.
 var ErrIndexNotFound = fmt.Errorf("Index not found")
 var ErrIndexNotReady = fmt.Errorf("Index not ready for serving queries")
+var ErrScanRejected = fmt.Errorf("Index scan rejected by admission control. Please retry the request later.")
 
 var errorDescriptions = map[string]string{
-	ErrorProtocol.Error():            "fatal protocol error with server",
-	ErrorNoHost.Error():              "All indexer replica is down or unavailable or unable to process request",
-	ErrorIndexNotFound.Error():       "index deleted or node hosting the index is down",
-	ErrorInstanceNotFound.Error():    "no instance available for the index",
-	ErrorClientUninitialized.Error(): "gsi client is not initialized",
-	ErrorNotImplemented.Error():      "client API not implemented",
-	ErrorInvalidConsistency.Error():  "supplied consistency is invalid",
-	ErrorExpectedTimestamp.Error():   "consistency timestamp is expected",
-	ErrIndexNotFound.Error():         "index is deleted or node hosting index is down",
-	ErrIndexNotReady.Error():         ErrIndexNotReady.Error(),
+	ErrorProtocol.Error():                 "fatal protocol error with server",
+	ErrorNoHost.Error():                   "All indexer replica is down or unavailable or unable to process request",
+	ErrorIndexNotFound.Error():            "index deleted or node hosting the index is down",
+	ErrorInstanceNotFound.Error():         "no instance available for the index",
+	ErrorClientUninitialized.Error():      "gsi client is not initialized",
+	ErrorNotImplemented.Error():           "client API not implemented",
+	ErrorInvalidConsistency.Error():       "supplied consistency is invalid",
+	ErrorExpectedTimestamp.Error():        "consistency timestamp is expected",
+	ErrorGroupAggrOnPrimary.Error():       "group by and aggregates are not supported on primary index",
+	ErrorSortOnPrimary.Error():            "sort is not supported on primary index",
+	ErrorInvalidSortKey.Error():           "sort key position is out of range for the returned entry",
+	ErrorSnapshotLeaseUnsupported.Error(): "indexer does not support pinning snapshots",
+	ErrorSnapshotLeaseMismatch.Error():    "snapshot lease is not for the scanned index",
+	ErrorSnapshotLeaseAcrossNodes.Error(): "snapshot cannot be pinned on partitions hosted by more than one indexer",
+	ErrorFilterOnPrimary.Error():          "filter is not supported on primary index",
+	ErrorScanFilterUnsupported.Error():    "indexer does not support filtering index entries",
+	ErrorNotExpiryIndex.Error():           "index is not an expiry index",
+	ErrorPartitionUnavailable.Error():     "no indexer available for a partition of the index",
+	ErrorInvalidGroupRow.Error():          "row returned for group by and aggregates does not match the request",
+	ErrIndexNotFound.Error():              "index is deleted or node hosting index is down",
+	ErrIndexNotReady.Error():              ErrIndexNotReady.Error(),
+	ErrScanRejected.Error():               "indexer is overloaded with scans on the bucket or index",
 }
@@ -0,0 +1,32 @@
+package client
+
+import "time"
+
+import "github.com/couchbase/indexing/secondary/common"
+
+// ScanExpiring scans expiry index `defnID` for documents expiring in the
+// window [from, to), in the order of their expiry time. Expiry index is
+// created with {"expiry": true} and its entries are keyed on document
+// expiry time, truncated to seconds.
+func (c *GsiClient) ScanExpiring(
+	defnID uint64, requestId string, from, to time.Time, limit int64,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler) error {
+
+	if c.bridge == nil {
+		return ErrorClientUninitialized
+	}
+
+	index := c.bridge.GetIndexDefn(defnID)
+	if index == nil {
+		return ErrorIndexNotFound
+	}
+	if !index.Expiry {
+		return ErrorNotExpiryIndex
+	}
+
+	low := common.SecondaryKey{from.Unix()}
+	high := common.SecondaryKey{to.Unix()}
+	return c.Range(
+		defnID, requestId, low, high, Low, false, limit, cons, vector, callb)
+}
@@ -155,6 +155,22 @@ func (b *metadataClient) GetIndexDefn(defnID uint64) *common.IndexDefn {
 	return nil
 }
 
+// GetIndexInstVersion implements BridgeAccessor{} interface.
+func (b *metadataClient) GetIndexInstVersion(instID uint64) (uint64, bool) {
+	currmeta := (*indexTopology)(atomic.LoadPointer(&b.indexers))
+	if inst, ok := currmeta.insts[common.IndexInstId(instID)]; ok {
+		return inst.Version, true
+	}
+	for _, insts := range currmeta.replicasInRebal {
+		for _, inst := range insts {
+			if inst.InstId == common.IndexInstId(instID) {
+				return inst.Version, true
+			}
+		}
+	}
+	return 0, false
+}
+
 // CreateIndex implements BridgeAccessor{} interface.
 func (b *metadataClient) CreateIndex(
 	indexName, bucket, using, exprType, partnExpr, whereExpr string,
@@ -271,6 +287,18 @@ func (b *metadataClient) DropIndex(defnID uint64) error {
 	return err
 }
 
+// AlterIndex implements BridgeAccessor{} interface.
+func (b *metadataClient) AlterIndex(
+	defnID uint64, secExprs []string, desc []bool, isArrayIndex bool) error {
+
+	err := b.mdClient.AlterIndex(
+		common.IndexDefnId(defnID), secExprs, desc, isArrayIndex)
+	if err == nil { // refresh index local cache.
+		b.safeupdate(nil, false /*force*/)
+	}
+	return err
+}
+
 // GetScanports implements BridgeAccessor{} interface.
 func (b *metadataClient) GetScanports() (queryports []string) {
 	currmeta := (*indexTopology)(atomic.LoadPointer(&b.indexers))
@@ -319,6 +347,32 @@ func (b *metadataClient) GetScanport(
 	return qp, targetDefnID, targetInstID, rollbackTime, true
 }
 
+// GetPartitionScanports implements BridgeAccessor{} interface.
+func (b *metadataClient) GetPartitionScanports(
+	defnID uint64) (queryports map[common.PartitionId]string, ok bool) {
+
+	currmeta := (*indexTopology)(atomic.LoadPointer(&b.indexers))
+	index, ok := currmeta.defns[common.IndexDefnId(defnID)]
+	if !ok {
+		return nil, false
+	}
+
+	queryports = make(map[common.PartitionId]string)
+	for _, inst := range index.Instances {
+		if inst.State != common.INDEX_STATE_ACTIVE {
+			continue
+		}
+		qp, ok := currmeta.queryports[inst.IndexerId]
+		if !ok {
+			continue
+		}
+		for _, partnId := range inst.Partitions {
+			queryports[partnId] = qp
+		}
+	}
+	return queryports, true
+}
+
 // Timeit implement BridgeAccessor{} interface.
 func (b *metadataClient) Timeit(instID uint64, value float64) {
 
@@ -484,6 +538,16 @@ func (b *metadataClient) equivalentIndex(
 		}
 	}
 
+	if len(d1.Include) != len(d2.Include) {
+		return false
+	}
+
+	for i, s1 := range d1.Include {
+		if s1 != d2.Include[i] {
+			return false
+		}
+	}
+
 	return true
 }
 
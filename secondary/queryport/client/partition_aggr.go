@@ -0,0 +1,267 @@
+package client
+
+import "bytes"
+import "sync"
+
+import "github.com/couchbase/indexing/secondary/collatejson"
+import "github.com/couchbase/indexing/secondary/common"
+import json "github.com/couchbase/indexing/secondary/common/json"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+
+// groupMerge merges the rows of a scan with group-by/aggregate pushdown,
+// returned by the indexers hosting partitions of an index. Rows of the
+// same group, by values of its group keys, are merged by adding COUNT,
+// COUNTN and SUM, and by retaining the least MIN and the greatest MAX.
+// Aggregates over distinct values cannot be merged across indexers, for
+// them indexers return index entries that are aggregated by the client.
+type groupMerge struct {
+	mu        sync.Mutex
+	groupAggr *GroupAggr
+	entries   bool // aggregate index entries instead of rows
+	codec     *collatejson.Codec
+	groups    map[string]*mergedGroup
+	order     []*mergedGroup
+	err       error
+}
+
+// mergedGroup is a row of group key values followed by aggregate
+// values, codes are the collated values of MIN and MAX aggregates and
+// seen are the values of distinct aggregates.
+type mergedGroup struct {
+	row   common.SecondaryKey
+	codes [][]byte
+	seen  []map[string]bool
+}
+
+func newGroupMerge(groupAggr *GroupAggr) *groupMerge {
+	m := &groupMerge{
+		groupAggr: groupAggr,
+		codec:     collatejson.NewCodec(16),
+		groups:    make(map[string]*mergedGroup),
+	}
+	for _, aggr := range groupAggr.Aggrs {
+		m.entries = m.entries || aggr.Distinct
+	}
+	return m
+}
+
+// handler is the ResponseHandler for the scan of each indexer.
+func (m *groupMerge) handler(resp ResponseReader) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := resp.(*protobuf.StreamEndResponse); ok {
+		return true
+	} else if m.err != nil {
+		return false
+	}
+	if m.err = resp.Error(); m.err != nil {
+		return false
+	}
+	skeys, _, err := resp.GetEntries()
+	if err != nil {
+		m.err = err
+		return false
+	}
+	for _, skey := range skeys {
+		if m.entries {
+			m.err = m.addEntry(skey)
+		} else {
+			m.err = m.addRow(skey)
+		}
+		if m.err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// addRow merges a row returned by indexer into its group.
+func (m *groupMerge) addRow(row common.SecondaryKey) error {
+	numGroup := len(m.groupAggr.Group)
+	if len(row) != numGroup+len(m.groupAggr.Aggrs) {
+		return ErrorInvalidGroupRow
+	}
+	g, err := m.group(row[:numGroup])
+	if err != nil {
+		return err
+	}
+	for i := range m.groupAggr.Aggrs {
+		if err := m.merge(g, i, row[numGroup+i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addEntry aggregates an index entry into its group, same as indexer
+// aggregates the entry.
+func (m *groupMerge) addEntry(skey common.SecondaryKey) error {
+	vals := make(common.SecondaryKey, 0, len(m.groupAggr.Group))
+	for _, key := range m.groupAggr.Group {
+		if key.KeyPos < 0 || int(key.KeyPos) >= len(skey) {
+			return ErrorInvalidGroupRow
+		}
+		vals = append(vals, skey[key.KeyPos])
+	}
+	g, err := m.group(vals)
+	if err != nil {
+		return err
+	}
+
+	for i, aggr := range m.groupAggr.Aggrs {
+		if aggr.KeyPos == -1 { // COUNT(*)
+			if err := m.merge(g, i, float64(1)); err != nil {
+				return err
+			}
+			continue
+		}
+		if aggr.KeyPos < 0 || int(aggr.KeyPos) >= len(skey) {
+			return ErrorInvalidGroupRow
+		}
+		val := skey[aggr.KeyPos]
+		if val == nil {
+			continue // aggregates ignore null values
+		}
+		if aggr.Distinct {
+			data, err := json.Marshal(val)
+			if err != nil {
+				return err
+			}
+			if g.seen[i][string(data)] {
+				continue
+			}
+			g.seen[i][string(data)] = true
+		}
+
+		switch aggr.AggrFunc {
+		case common.AGG_COUNT:
+			val = float64(1)
+		case common.AGG_COUNTN:
+			if _, ok := val.(float64); !ok {
+				continue
+			}
+			val = float64(1)
+		case common.AGG_SUM:
+			if _, ok := val.(float64); !ok {
+				continue
+			}
+		}
+		if err := m.merge(g, i, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// group returns the group for values of group keys, a group without
+// entries has counts of 0 and null for other aggregates.
+func (m *groupMerge) group(vals common.SecondaryKey) (*mergedGroup, error) {
+	data, err := json.Marshal(vals)
+	if err != nil {
+		return nil, err
+	}
+	if g, ok := m.groups[string(data)]; ok {
+		return g, nil
+	}
+
+	numAggrs := len(m.groupAggr.Aggrs)
+	g := &mergedGroup{
+		row:   make(common.SecondaryKey, 0, len(vals)+numAggrs),
+		codes: make([][]byte, numAggrs),
+		seen:  make([]map[string]bool, numAggrs),
+	}
+	g.row = append(g.row, vals...)
+	for i, aggr := range m.groupAggr.Aggrs {
+		var val interface{}
+		switch aggr.AggrFunc {
+		case common.AGG_COUNT, common.AGG_COUNTN:
+			val = float64(0)
+		}
+		g.row = append(g.row, val)
+		if aggr.Distinct {
+			g.seen[i] = make(map[string]bool)
+		}
+	}
+	m.groups[string(data)] = g
+	m.order = append(m.order, g)
+	return g, nil
+}
+
+// merge value of aggregate i into group g.
+func (m *groupMerge) merge(g *mergedGroup, i int, val interface{}) error {
+	if val == nil {
+		return nil
+	}
+	pos := len(m.groupAggr.Group) + i
+	switch fn := m.groupAggr.Aggrs[i].AggrFunc; fn {
+	case common.AGG_COUNT, common.AGG_COUNTN, common.AGG_SUM:
+		n, ok := val.(float64)
+		if !ok {
+			return ErrorInvalidGroupRow
+		}
+		if sum, ok := g.row[pos].(float64); ok {
+			n += sum
+		}
+		g.row[pos] = n
+
+	case common.AGG_MIN, common.AGG_MAX:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return err
+		}
+		code, err := m.codec.Encode(data, make([]byte, 0, len(data)*3))
+		if err != nil {
+			return err
+		}
+		if g.codes[i] != nil {
+			cmp := bytes.Compare(code, g.codes[i])
+			if (fn == common.AGG_MIN && cmp >= 0) ||
+				(fn == common.AGG_MAX && cmp <= 0) {
+				return nil
+			}
+		}
+		g.codes[i] = code
+		g.row[pos] = val
+	}
+	return nil
+}
+
+// rows returns the merged row of each group, in the order groups were
+// returned. Aggregates without group keys have a single row even if no
+// entries were aggregated.
+func (m *groupMerge) rows() ([]common.SecondaryKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return nil, m.err
+	}
+	if len(m.order) == 0 && len(m.groupAggr.Group) == 0 {
+		if _, err := m.group(common.SecondaryKey{}); err != nil {
+			return nil, err
+		}
+	}
+	rows := make([]common.SecondaryKey, 0, len(m.order))
+	for _, g := range m.order {
+		rows = append(rows, g.row)
+	}
+	return rows, nil
+}
+
+// groupSort returns the sort order of merged rows on their group keys,
+// same as indexer returns groups in index order. Nil if there are no
+// group keys.
+func groupSort(index *common.IndexDefn, groupAggr *GroupAggr) *IndexSort {
+	if len(groupAggr.Group) == 0 {
+		return nil
+	}
+	indexSort := &IndexSort{}
+	for i, key := range groupAggr.Group {
+		desc := key.KeyPos >= 0 && int(key.KeyPos) < len(index.Desc) &&
+			index.Desc[key.KeyPos]
+		indexSort.Keys = append(
+			indexSort.Keys, &SortKey{KeyPos: int32(i), Desc: desc})
+	}
+	return indexSort
+}
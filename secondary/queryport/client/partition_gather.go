@@ -0,0 +1,318 @@
+package client
+
+import "bytes"
+import "container/heap"
+import "math"
+import "sort"
+import "sync"
+
+import "github.com/couchbase/indexing/secondary/collatejson"
+import "github.com/couchbase/indexing/secondary/common"
+import json "github.com/couchbase/indexing/secondary/common/json"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+import "github.com/golang/protobuf/proto"
+
+// partitionGather serializes responses from concurrent partition scans
+// into a single ResponseHandler, applying offset, limit and distinct
+// across partitions. For sorted scans, every partition returns its top
+// offset+limit entries in sort order, which are merged and forwarded
+// on flush.
+type partitionGather struct {
+	mu       sync.Mutex
+	offset   int64
+	limit    int64
+	distinct bool
+	seen     map[string]bool
+	callb    ResponseHandler
+
+	indexSort *IndexSort
+	codec     *collatejson.Codec
+	rows      *sortedEntries
+
+	skipped int64
+	count   int64
+	done    bool // stop all partition scans
+	stopped bool // stopped by caller or on error
+}
+
+func newPartitionGather(
+	offset, limit int64, distinct bool, indexSort *IndexSort,
+	callb ResponseHandler) *partitionGather {
+
+	g := &partitionGather{
+		offset:    offset,
+		limit:     limit,
+		distinct:  distinct,
+		callb:     callb,
+		indexSort: indexSort,
+	}
+	if distinct {
+		g.seen = make(map[string]bool)
+	}
+	if indexSort != nil {
+		g.codec = collatejson.NewCodec(16)
+		g.rows = newSortedEntries(offset, limit)
+	}
+	return g
+}
+
+// handler is the ResponseHandler for each partition scan, returning
+// false stops the partition scan.
+func (g *partitionGather) handler(resp ResponseReader) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.done {
+		return false
+	}
+
+	switch resp.(type) {
+	case *protobuf.StreamEndResponse:
+		// end of a single partition, gathered stream is ended by caller.
+		return true
+	}
+
+	if err := resp.Error(); err != nil {
+		g.done, g.stopped = true, true
+		g.callb(resp)
+		return false
+	}
+
+	skeys, pkeys, err := resp.GetEntries()
+	if err != nil {
+		g.done, g.stopped = true, true
+		g.callb(resp)
+		return false
+	}
+
+	outskeys := make([]common.SecondaryKey, 0, len(skeys))
+	outpkeys := make([][]byte, 0, len(pkeys))
+	for i, skey := range skeys {
+		if g.rows == nil && g.limit > 0 && g.count >= g.limit {
+			break
+		}
+		if g.distinct {
+			data, err := json.Marshal(skey)
+			if err != nil {
+				g.done, g.stopped = true, true
+				g.callb(&protobuf.ResponseStream{
+					Err: &protobuf.Error{Error: proto.String(err.Error())},
+				})
+				return false
+			}
+			if g.seen[string(data)] {
+				continue
+			}
+			g.seen[string(data)] = true
+		}
+		if g.rows != nil {
+			var pkey []byte
+			if i < len(pkeys) {
+				pkey = pkeys[i]
+			}
+			if err := g.rows.add(g.codec, g.indexSort, skey, pkey); err != nil {
+				g.done, g.stopped = true, true
+				g.callb(&protobuf.ResponseStream{
+					Err: &protobuf.Error{Error: proto.String(err.Error())},
+				})
+				return false
+			}
+			continue
+		}
+		if g.skipped < g.offset {
+			g.skipped++
+			continue
+		}
+		outskeys = append(outskeys, skey)
+		if i < len(pkeys) {
+			outpkeys = append(outpkeys, pkeys[i])
+		}
+		g.count++
+	}
+
+	if len(outskeys) > 0 {
+		resp := &partitionResponse{skeys: outskeys, pkeys: outpkeys}
+		if !g.callb(resp) {
+			g.done, g.stopped = true, true
+			return false
+		}
+	}
+	if g.limit > 0 && g.count >= g.limit {
+		g.done = true
+		return false
+	}
+	return true
+}
+
+// flush forwards the top entries of a sorted scan, gathered from all
+// partitions, to the caller.
+func (g *partitionGather) flush() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.rows == nil || g.done {
+		return
+	}
+	g.done = true
+
+	entries := g.rows.sorted()
+	if int64(len(entries)) <= g.offset {
+		return
+	}
+	entries = entries[g.offset:]
+	if g.limit > 0 && int64(len(entries)) > g.limit {
+		entries = entries[:g.limit]
+	}
+
+	resp := &partitionResponse{
+		skeys: make([]common.SecondaryKey, 0, len(entries)),
+		pkeys: make([][]byte, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		resp.skeys = append(resp.skeys, entry.skey)
+		resp.pkeys = append(resp.pkeys, entry.pkey)
+	}
+	g.count = int64(len(entries))
+	if !g.callb(resp) {
+		g.stopped = true
+	}
+}
+
+// forwarded returns true if some entries are already passed on
+// to the caller.
+func (g *partitionGather) forwarded() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.count > 0
+}
+
+// ended returns true if no more entries are gathered, limit is reached
+// or the gathered stream was stopped.
+func (g *partitionGather) ended() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.done
+}
+
+// complete returns true if the gathered stream was not stopped
+// by the caller or on error.
+func (g *partitionGather) complete() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return !g.stopped
+}
+
+// partitionResponse implements ResponseReader for gathered entries.
+type partitionResponse struct {
+	skeys []common.SecondaryKey
+	pkeys [][]byte
+}
+
+func (r *partitionResponse) GetEntries() ([]common.SecondaryKey, [][]byte, error) {
+	return r.skeys, r.pkeys, nil
+}
+
+func (r *partitionResponse) Error() error {
+	return nil
+}
+
+// sortedEntry is an entry gathered for a sorted scan, code is the
+// collatejson encoding of its sort keys and seq is the order in which
+// it was gathered.
+type sortedEntry struct {
+	code []byte
+	seq  uint64
+	skey common.SecondaryKey
+	pkey []byte
+}
+
+func (e *sortedEntry) less(other *sortedEntry) bool {
+	if cmp := bytes.Compare(e.code, other.code); cmp != 0 {
+		return cmp < 0
+	}
+	return e.seq < other.seq
+}
+
+// sortedEntries retains the top n entries in sort order, using a
+// max-heap whose root is the last of the retained entries. All entries
+// are retained if n is 0.
+type sortedEntries struct {
+	n       int64
+	seq     uint64
+	entries []*sortedEntry
+}
+
+func newSortedEntries(offset, limit int64) *sortedEntries {
+	t := &sortedEntries{}
+	if limit > 0 && offset >= 0 && limit <= math.MaxInt64-offset {
+		t.n = offset + limit
+	}
+	return t
+}
+
+func (t *sortedEntries) Len() int           { return len(t.entries) }
+func (t *sortedEntries) Less(i, j int) bool { return t.entries[j].less(t.entries[i]) }
+func (t *sortedEntries) Swap(i, j int)      { t.entries[i], t.entries[j] = t.entries[j], t.entries[i] }
+
+func (t *sortedEntries) Push(x interface{}) {
+	t.entries = append(t.entries, x.(*sortedEntry))
+}
+
+func (t *sortedEntries) Pop() interface{} {
+	e := t.entries[len(t.entries)-1]
+	t.entries = t.entries[:len(t.entries)-1]
+	return e
+}
+
+func (t *sortedEntries) add(
+	codec *collatejson.Codec, indexSort *IndexSort,
+	skey common.SecondaryKey, pkey []byte) error {
+
+	code, err := sortCode(codec, indexSort, skey)
+	if err != nil {
+		return err
+	}
+	e := &sortedEntry{code: code, seq: t.seq, skey: skey, pkey: pkey}
+	t.seq++
+
+	if t.n == 0 || int64(len(t.entries)) < t.n {
+		heap.Push(t, e)
+	} else if e.less(t.entries[0]) {
+		t.entries[0] = e
+		heap.Fix(t, 0)
+	}
+	return nil
+}
+
+// sorted returns the retained entries in sort order.
+func (t *sortedEntries) sorted() []*sortedEntry {
+	sort.Sort(sort.Reverse(t))
+	return t.entries
+}
+
+// sortCode returns the collatejson encoding of the sort keys of skey,
+// with descending keys reversed, same as indexer orders the rows of a
+// sorted scan.
+func sortCode(
+	codec *collatejson.Codec, indexSort *IndexSort,
+	skey common.SecondaryKey) ([]byte, error) {
+
+	vals := make([]interface{}, 0, len(indexSort.Keys))
+	desc := make([]bool, 0, len(indexSort.Keys))
+	for _, k := range indexSort.Keys {
+		if k.KeyPos < 0 || int(k.KeyPos) >= len(skey) {
+			return nil, ErrorInvalidSortKey
+		}
+		vals = append(vals, skey[k.KeyPos])
+		desc = append(desc, k.Desc)
+	}
+	text, err := json.Marshal(vals)
+	if err != nil {
+		return nil, err
+	}
+	code, err := codec.Encode(text, make([]byte, 0, len(text)*3))
+	if err != nil {
+		return nil, err
+	}
+	return codec.ReverseCollate(code, desc), nil
+}
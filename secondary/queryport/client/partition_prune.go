@@ -0,0 +1,156 @@
+package client
+
+import "bytes"
+
+import "github.com/couchbase/indexing/secondary/collatejson"
+import "github.com/couchbase/indexing/secondary/common"
+import json "github.com/couchbase/indexing/secondary/common/json"
+
+// rangePartitions return the partitions of a range partitioned index
+// whose bounds intersect with `scans`, in partition order. Returns nil
+// if scans cannot be pruned, in which case all partitions are scanned.
+//
+// Pruning applies only when the partition key is one of the index keys,
+// filters on that key bound the partitions to scan. Partition keys that
+// are not index keys, like an expression over index keys, are not
+// pruned. Inclusion is ignored, a partition that only shares the low or
+// high value of a scan is scanned as well.
+func rangePartitions(index *common.IndexDefn, scans Scans) []common.PartitionId {
+	codec, splits, pos := rangeSplits(index)
+	if splits == nil {
+		return nil
+	}
+
+	selected := make([]bool, len(splits)+1)
+	for _, scan := range scans {
+		if scan == nil {
+			return nil
+		}
+
+		var low, high interface{} = common.MinUnbounded, common.MaxUnbounded
+		if len(scan.Seek) > pos {
+			low, high = scan.Seek[pos], scan.Seek[pos]
+		} else if len(scan.Filter) > pos && scan.Filter[pos] != nil {
+			low, high = scan.Filter[pos].Low, scan.Filter[pos].High
+		}
+
+		if !selectPartitions(codec, splits, low, high, selected) {
+			return nil
+		}
+	}
+	return selectedPartitions(selected)
+}
+
+// rangePartitionsForSpan is rangePartitions for a range scan between
+// composite keys low and high, which bound only the leading key. Hence
+// pruning applies only when the partition key is the leading key.
+func rangePartitionsForSpan(
+	index *common.IndexDefn, low, high common.SecondaryKey) []common.PartitionId {
+
+	codec, splits, pos := rangeSplits(index)
+	if splits == nil || pos != 0 {
+		return nil
+	}
+
+	var l, h interface{} = common.MinUnbounded, common.MaxUnbounded
+	if len(low) > 0 {
+		l = low[0]
+	}
+	if len(high) > 0 {
+		h = high[0]
+	}
+
+	selected := make([]bool, len(splits)+1)
+	if !selectPartitions(codec, splits, l, h, selected) {
+		return nil
+	}
+	return selectedPartitions(selected)
+}
+
+// rangeSplits return collated split points of index and position of the
+// partition key among index keys, nil if scans on the index cannot be
+// pruned.
+func rangeSplits(index *common.IndexDefn) (*collatejson.Codec, [][]byte, int) {
+	if index.PartitionScheme != common.RANGE || index.GetNumPartitions() <= 1 {
+		return nil, nil, -1
+	}
+	pos := -1
+	for i, expr := range index.SecExprs {
+		if expr == index.PartitionKey {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		return nil, nil, -1
+	}
+
+	codec := collatejson.NewCodec(16)
+	splits := make([][]byte, 0, len(index.PartitionSplits))
+	for _, split := range index.PartitionSplits {
+		code, err := encodeRangeKey(codec, []byte(split))
+		if err != nil {
+			return nil, nil, -1
+		}
+		splits = append(splits, code)
+	}
+	return codec, splits, pos
+}
+
+// selectPartitions mark partitions intersecting [low, high] as selected,
+// returns false if bounds cannot be collated.
+func selectPartitions(
+	codec *collatejson.Codec, splits [][]byte,
+	low, high interface{}, selected []bool) bool {
+
+	from, till := 0, len(splits)
+	var l, h []byte // collated bounds, nil if unbounded
+	var err error
+	if low != common.MinUnbounded {
+		if l, err = encodeRangeValue(codec, low); err != nil {
+			return false
+		}
+		from = int(common.RangeKeyPartition(l, splits))
+	}
+	if high != common.MaxUnbounded {
+		if h, err = encodeRangeValue(codec, high); err != nil {
+			return false
+		}
+		till = int(common.RangeKeyPartition(h, splits))
+	}
+	if l != nil && h != nil && bytes.Compare(l, h) > 0 {
+		return true // empty range
+	}
+	for i := from; i <= till; i++ {
+		selected[i] = true
+	}
+	return true
+}
+
+func selectedPartitions(selected []bool) []common.PartitionId {
+	partitions := make([]common.PartitionId, 0, len(selected))
+	for i, ok := range selected {
+		if ok {
+			partitions = append(partitions, common.PartitionId(i))
+		}
+	}
+	return partitions
+}
+
+func encodeRangeValue(codec *collatejson.Codec, value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return encodeRangeKey(codec, data)
+}
+
+// encodeRangeKey collate the JSON value of a partition key, same as
+// projector and indexer do for partition keys and split points.
+func encodeRangeKey(codec *collatejson.Codec, key []byte) ([]byte, error) {
+	size := 3 * len(key)
+	if size < collatejson.MinBufferSize {
+		size = collatejson.MinBufferSize
+	}
+	return codec.Encode(key, make([]byte, 0, size))
+}
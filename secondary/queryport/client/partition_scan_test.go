@@ -0,0 +1,585 @@
+package client
+
+import "fmt"
+import "math"
+import "net"
+import "sort"
+import "sync"
+import "sync/atomic"
+import "testing"
+import "unsafe"
+
+import "github.com/couchbase/indexing/secondary/common"
+import json "github.com/couchbase/indexing/secondary/common/json"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+import "github.com/couchbase/indexing/secondary/transport"
+import "github.com/golang/protobuf/proto"
+
+// testBridge places the partitions of a single index on test indexers.
+type testBridge struct {
+	BridgeAccessor
+	index      *common.IndexDefn
+	instID     uint64
+	version    *uint64
+	queryport  string // picked for scans
+	queryports map[common.PartitionId]string
+}
+
+func (b *testBridge) IndexState(defnID uint64) (common.IndexState, error) {
+	return common.INDEX_STATE_ACTIVE, nil
+}
+
+func (b *testBridge) IsPrimary(defnID uint64) bool {
+	return false
+}
+
+func (b *testBridge) GetScanport(
+	defnID uint64, retry int,
+	excludes map[uint64]bool) (string, uint64, uint64, int64, bool) {
+
+	return b.queryport, uint64(b.index.DefnId), b.instID, math.MaxInt64, true
+}
+
+func (b *testBridge) GetPartitionScanports(
+	defnID uint64) (map[common.PartitionId]string, bool) {
+
+	return b.queryports, b.queryports != nil
+}
+
+func (b *testBridge) GetIndexDefn(defnID uint64) *common.IndexDefn {
+	return b.index
+}
+
+func (b *testBridge) GetIndexInstVersion(instID uint64) (uint64, bool) {
+	if b.version == nil || instID != b.instID {
+		return 0, false
+	}
+	return *b.version, true
+}
+
+func (b *testBridge) Timeit(instID uint64, value float64) {
+}
+
+// testIndexer serves requests of a scan client over in-memory
+// connections, responding with the responses returned by serve.
+type testIndexer struct {
+	qc    *GsiScanClient
+	serve func(req interface{}) []interface{}
+	endch chan bool // notified on protobuf.EndStreamRequest
+
+	mu       sync.Mutex
+	requests []interface{}
+}
+
+func newTestIndexer(
+	queryport string, serve func(req interface{}) []interface{}) *testIndexer {
+
+	ti := &testIndexer{serve: serve, endch: make(chan bool, 16)}
+	qc := &GsiScanClient{
+		queryport:  queryport,
+		maxPayload: 1024 * 1024,
+		logPrefix:  queryport,
+	}
+	qc.pool = newConnectionPool(queryport, 4, 4, qc.maxPayload, 1000, 1)
+	qc.pool.mkConn = func(host string) (*connection, error) {
+		client, server := net.Pipe()
+		go ti.handleConnection(server)
+		flags := transport.TransportFlag(0).SetProtobuf()
+		pkt := transport.NewTransportPacket(qc.maxPayload, flags)
+		pkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
+		pkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
+		return &connection{client, pkt}, nil
+	}
+	ti.qc = qc
+	return ti
+}
+
+func (ti *testIndexer) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	// requests are received while responses are sent, like
+	// EndStreamRequest of a stopped stream.
+	reqch := make(chan interface{}, 16)
+	go func() {
+		defer close(reqch)
+		flags := transport.TransportFlag(0).SetProtobuf()
+		pkt := transport.NewTransportPacket(ti.qc.maxPayload, flags)
+		pkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
+		for {
+			req, err := pkt.Receive(conn)
+			if err != nil {
+				return
+			}
+			if _, ok := req.(*protobuf.EndStreamRequest); ok {
+				select {
+				case ti.endch <- true:
+				default:
+				}
+			}
+			reqch <- req
+		}
+	}()
+
+	flags := transport.TransportFlag(0).SetProtobuf()
+	pkt := transport.NewTransportPacket(ti.qc.maxPayload, flags)
+	pkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
+	for req := range reqch {
+		if _, ok := req.(*protobuf.EndStreamRequest); ok {
+			continue
+		}
+		ti.mu.Lock()
+		ti.requests = append(ti.requests, req)
+		ti.mu.Unlock()
+		for _, resp := range ti.serve(req) {
+			if err := pkt.Send(conn, resp); err != nil {
+				return
+			}
+		}
+		if err := transport.SendResponseEnd(conn); err != nil {
+			return
+		}
+	}
+}
+
+func (ti *testIndexer) received() []interface{} {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	return append([]interface{}(nil), ti.requests...)
+}
+
+// testPartitionedClient returns a client for index, whose partitions
+// are hosted by an indexer for each of placement. Scans are picked
+// for the first indexer.
+func testPartitionedClient(
+	index *common.IndexDefn, placement [][]common.PartitionId,
+	serve func(node int, req interface{}) []interface{}) (*GsiClient, []*testIndexer) {
+
+	bridge := &testBridge{
+		index:      index,
+		instID:     uint64(index.DefnId) + 1,
+		queryports: make(map[common.PartitionId]string),
+	}
+	qcs := make(map[string]*GsiScanClient)
+	indexers := make([]*testIndexer, 0, len(placement))
+	for node, partnIds := range placement {
+		queryport := fmt.Sprintf("node%v:9101", node)
+		for _, partnId := range partnIds {
+			bridge.queryports[partnId] = queryport
+		}
+		node := node
+		ti := newTestIndexer(queryport, func(req interface{}) []interface{} {
+			return serve(node, req)
+		})
+		qcs[queryport] = ti.qc
+		indexers = append(indexers, ti)
+	}
+	bridge.queryport = indexers[0].qc.queryport
+
+	c := &GsiClient{
+		bridge: bridge,
+		config: common.SystemConfig.SectionConfig("queryport.client.", true),
+	}
+	atomic.StorePointer(&c.queryClients, unsafe.Pointer(&qcs))
+	return c, indexers
+}
+
+// servePartitions serves scans from entries of partitions, as pairs of
+// entry key and primary key, scanning the requested partitions or all
+// hosted partitions.
+func servePartitions(
+	entries map[common.PartitionId][]string,
+	placement [][]common.PartitionId) func(int, interface{}) []interface{} {
+
+	return func(node int, req interface{}) []interface{} {
+		partnIds, limit := placement[node], int64(0)
+		switch r := req.(type) {
+		case *protobuf.ScanRequest:
+			if ids := r.GetPartitionIds(); len(ids) > 0 {
+				partnIds = nil
+				for _, id := range ids {
+					partnIds = append(partnIds, common.PartitionId(id))
+				}
+			}
+			limit = r.GetLimit()
+		case *protobuf.ScanAllRequest:
+			limit = r.GetLimit()
+		default:
+			return nil
+		}
+		resp := &protobuf.ResponseStream{}
+		for _, partnId := range partnIds {
+			stream := resumeStream(entries[partnId]...)
+			resp.IndexEntries = append(resp.IndexEntries, stream.IndexEntries...)
+		}
+		if limit > 0 && int64(len(resp.IndexEntries)) > limit {
+			resp.IndexEntries = resp.IndexEntries[:limit]
+		}
+		return []interface{}{resp}
+	}
+}
+
+// testGathered collects entry keys of a scan as JSON.
+type testGathered struct {
+	keys  []string
+	ended bool
+	err   error
+}
+
+func (g *testGathered) handler(resp ResponseReader) bool {
+	if _, ok := resp.(*protobuf.StreamEndResponse); ok {
+		g.ended = true
+		return true
+	}
+	if err := resp.Error(); err != nil {
+		g.err = err
+		return false
+	}
+	skeys, _, err := resp.GetEntries()
+	if err != nil {
+		g.err = err
+		return false
+	}
+	for _, skey := range skeys {
+		data, _ := json.Marshal(skey)
+		g.keys = append(g.keys, string(data))
+	}
+	return true
+}
+
+func (g *testGathered) sorted() string {
+	keys := append([]string(nil), g.keys...)
+	sort.Strings(keys)
+	return fmt.Sprint(keys)
+}
+
+func TestScanHashPartitionsAcrossNodes(t *testing.T) {
+	index := &common.IndexDefn{
+		DefnId:          1,
+		Bucket:          "default",
+		SecExprs:        []string{"a"},
+		PartitionScheme: common.HASH,
+		PartitionKey:    "a",
+		NumPartitions:   4,
+	}
+	placement := index.PlacePartitions(2)
+	entries := map[common.PartitionId][]string{
+		0: {`[1]`, "doc1", `[5]`, "doc5"},
+		1: {`[2]`, "doc2"},
+		2: {`[3]`, "doc3"},
+		3: {`[4]`, "doc4", `[1]`, "doc6"},
+	}
+	c, indexers := testPartitionedClient(
+		index, placement, servePartitions(entries, placement))
+
+	g := &testGathered{}
+	err := c.ScanAll(
+		1, "scanall", math.MaxInt64, common.AnyConsistency, nil, g.handler)
+	if err != nil || g.err != nil || !g.ended {
+		t.Fatalf("unexpected result %v %v %v", err, g.err, g.ended)
+	} else if s := g.sorted(); s != "[[1] [1] [2] [3] [4] [5]]" {
+		t.Fatalf("unexpected entries %v", s)
+	}
+	for i, ti := range indexers {
+		if reqs := ti.received(); len(reqs) != 1 {
+			t.Fatalf("expected a request to node %v, received %v", i, reqs)
+		}
+	}
+
+	// limit applies across indexers.
+	g = &testGathered{}
+	err = c.Range(
+		1, "range", nil, nil, Both, false, 4, common.AnyConsistency, nil,
+		g.handler)
+	if err != nil || g.err != nil || !g.ended || len(g.keys) != 4 {
+		t.Fatalf("unexpected result %v %v %v %v", err, g.err, g.ended, g.keys)
+	}
+
+	// distinct entries across indexers.
+	g = &testGathered{}
+	err = c.Lookup(
+		1, "lookup", []common.SecondaryKey{{1}}, true, math.MaxInt64,
+		common.AnyConsistency, nil, g.handler)
+	if err != nil || g.err != nil || !g.ended {
+		t.Fatalf("unexpected result %v %v %v", err, g.err, g.ended)
+	} else if s := g.sorted(); s != "[[1] [2] [3] [4] [5]]" {
+		t.Fatalf("unexpected entries %v", s)
+	}
+
+	count, err := c.MultiScanCount(
+		1, "count", Scans{&Scan{}}, true, common.AnyConsistency, nil)
+	if err != nil || count != 5 {
+		t.Fatalf("expected 5 distinct entries, received %v %v", count, err)
+	}
+}
+
+func TestScanRangePartitionsAcrossNodes(t *testing.T) {
+	index := &common.IndexDefn{
+		DefnId:          1,
+		Bucket:          "default",
+		SecExprs:        []string{"a"},
+		PartitionScheme: common.RANGE,
+		PartitionKey:    "a",
+		PartitionSplits: []string{"10", "20", "30"},
+	}
+	placement := index.PlacePartitions(2)
+	entries := map[common.PartitionId][]string{
+		0: {`[5]`, "doc5"},
+		1: {`[12]`, "doc12", `[15]`, "doc15"},
+		2: {`[22]`, "doc22"},
+		3: {`[35]`, "doc35"},
+	}
+	c, indexers := testPartitionedClient(
+		index, placement, servePartitions(entries, placement))
+
+	// pruned partitions 1 and 2 are hosted by either indexer, entries
+	// are returned in index order.
+	g := &testGathered{}
+	err := c.Range(
+		1, "range", common.SecondaryKey{15}, common.SecondaryKey{25}, Both,
+		false, math.MaxInt64, common.AnyConsistency, nil, g.handler)
+	if err != nil || g.err != nil || !g.ended {
+		t.Fatalf("unexpected result %v %v %v", err, g.err, g.ended)
+	} else if s := fmt.Sprint(g.keys); s != "[[12] [15] [22]]" {
+		t.Fatalf("unexpected entries %v", s)
+	}
+	for i, partnId := range []uint64{1, 2} {
+		reqs := indexers[i].received()
+		if len(reqs) != 1 {
+			t.Fatalf("expected a request to node %v, received %v", i, reqs)
+		}
+		ids := reqs[0].(*protobuf.ScanRequest).GetPartitionIds()
+		if len(ids) != 1 || ids[0] != partnId {
+			t.Fatalf("expected partition %v on node %v, received %v", partnId, i, ids)
+		}
+	}
+
+	// offset and limit apply across indexers.
+	g = &testGathered{}
+	scans := Scans{&Scan{
+		Filter: []*CompositeElementFilter{{Low: 15, High: 25, Inclusion: Both}},
+	}}
+	err = c.MultiScan(
+		1, "multiscan", scans, false, false, nil, nil, nil, "", 1, 2,
+		common.AnyConsistency, nil, g.handler)
+	if err != nil || g.err != nil || !g.ended {
+		t.Fatalf("unexpected result %v %v %v", err, g.err, g.ended)
+	} else if s := fmt.Sprint(g.keys); s != "[[15] [22]]" {
+		t.Fatalf("unexpected entries %v", s)
+	}
+}
+
+func TestScanGroupAggrAcrossNodes(t *testing.T) {
+	index := &common.IndexDefn{
+		DefnId:          1,
+		Bucket:          "default",
+		SecExprs:        []string{"a", "b"},
+		PartitionScheme: common.HASH,
+		PartitionKey:    "b",
+		NumPartitions:   4,
+	}
+	placement := index.PlacePartitions(2)
+
+	// rows of each indexer for group by a, and index entries for
+	// aggregates over distinct values.
+	rows := [][]string{
+		{`[1,2,10,3,7]`, "", `[2,1,5,5,5]`, ""},
+		{`[1,1,4,4,4]`, "", `[3,1,1,1,1]`, ""},
+	}
+	entries := [][]string{
+		{`[1,5]`, "doc1", `[1,6]`, "doc2", `[2,5]`, "doc3"},
+		{`[1,5]`, "doc4", `[1,7]`, "doc5"},
+	}
+	c, indexers := testPartitionedClient(index, placement,
+		func(node int, req interface{}) []interface{} {
+			r, ok := req.(*protobuf.ScanRequest)
+			if !ok {
+				return nil
+			} else if r.GetGroupAggr() != nil {
+				return []interface{}{resumeStream(rows[node]...)}
+			}
+			return []interface{}{resumeStream(entries[node]...)}
+		})
+
+	groupAggr := &GroupAggr{
+		Group: []*GroupKey{{KeyPos: 0}},
+		Aggrs: []*Aggregate{
+			{AggrFunc: common.AGG_COUNT, KeyPos: -1},
+			{AggrFunc: common.AGG_SUM, KeyPos: 1},
+			{AggrFunc: common.AGG_MIN, KeyPos: 1},
+			{AggrFunc: common.AGG_MAX, KeyPos: 1},
+		},
+	}
+	g := &testGathered{}
+	err := c.MultiScan(
+		1, "groupaggr", Scans{&Scan{}}, false, false, nil, groupAggr, nil, "",
+		0, math.MaxInt64, common.AnyConsistency, nil, g.handler)
+	if err != nil || g.err != nil || !g.ended {
+		t.Fatalf("unexpected result %v %v %v", err, g.err, g.ended)
+	}
+	expected := "[[1,3,14,3,7] [2,1,5,5,5] [3,1,1,1,1]]"
+	if s := fmt.Sprint(g.keys); s != expected {
+		t.Fatalf("expected merged groups %v, received %v", expected, s)
+	}
+	for i, ti := range indexers {
+		reqs := ti.received()
+		if len(reqs) != 1 || reqs[0].(*protobuf.ScanRequest).GetGroupAggr() == nil {
+			t.Fatalf("expected aggregates pushed down to node %v", i)
+		}
+	}
+
+	// offset and limit apply to merged groups.
+	g = &testGathered{}
+	err = c.MultiScan(
+		1, "groupaggr", Scans{&Scan{}}, false, false, nil, groupAggr, nil, "",
+		1, 1, common.AnyConsistency, nil, g.handler)
+	if err != nil || g.err != nil {
+		t.Fatalf("unexpected result %v %v", err, g.err)
+	} else if s := fmt.Sprint(g.keys); s != "[[2,1,5,5,5]]" {
+		t.Fatalf("unexpected groups %v", s)
+	}
+
+	// distinct values are aggregated from index entries.
+	groupAggr = &GroupAggr{
+		Group: []*GroupKey{{KeyPos: 0}},
+		Aggrs: []*Aggregate{
+			{AggrFunc: common.AGG_COUNT, KeyPos: 1, Distinct: true},
+			{AggrFunc: common.AGG_SUM, KeyPos: 1},
+		},
+	}
+	g = &testGathered{}
+	err = c.MultiScan(
+		1, "distinct", Scans{&Scan{}}, false, false, nil, groupAggr, nil, "",
+		0, math.MaxInt64, common.AnyConsistency, nil, g.handler)
+	if err != nil || g.err != nil || !g.ended {
+		t.Fatalf("unexpected result %v %v %v", err, g.err, g.ended)
+	} else if s := fmt.Sprint(g.keys); s != "[[1,3,23] [2,1,5]]" {
+		t.Fatalf("unexpected distinct groups %v", s)
+	}
+}
+
+func TestScanAlteredPartitionsAcrossNodes(t *testing.T) {
+	index := &common.IndexDefn{
+		DefnId:          1,
+		Bucket:          "default",
+		SecExprs:        []string{"a"},
+		PartitionScheme: common.HASH,
+		PartitionKey:    "a",
+		NumPartitions:   4,
+	}
+	placement := index.PlacePartitions(2)
+	entries := map[common.PartitionId][]string{
+		0: {`[1]`, "doc1"}, 1: {`[2]`, "doc2"},
+		2: {`[3]`, "doc3"}, 3: {`[4]`, "doc4"},
+	}
+
+	// altered instance is swapped in on the second indexer only, that
+	// rejects scans on the previous version like indexer does.
+	versions := []uint64{1, 2}
+	serve := servePartitions(entries, placement)
+	c, indexers := testPartitionedClient(index, placement,
+		func(node int, req interface{}) []interface{} {
+			r, ok := req.(*protobuf.ScanAllRequest)
+			if ok && r.InstVersion != nil && *r.InstVersion != versions[node] {
+				return []interface{}{&protobuf.ResponseStream{
+					Err: &protobuf.Error{
+						Error: proto.String("Mismatch in index instance version"),
+					},
+				}}
+			}
+			return serve(node, req)
+		})
+	version := uint64(1)
+	c.bridge.(*testBridge).version = &version
+
+	qc := c.instanceClient(indexers[0].qc, uint64(index.DefnId)+1)
+	nodes, err := c.partitionNodes(index, qc, nil)
+	if err != nil || len(nodes) != 2 {
+		t.Fatalf("unexpected nodes %v %v", nodes, err)
+	}
+	g := &testGathered{}
+	err, _ = c.scanNodes(
+		index, nodes, 0, 0, false, nil, g.handler,
+		func(qc *GsiScanClient, _ []common.PartitionId,
+			limit int64, handler ResponseHandler) error {
+
+			err, _ := qc.ScanAll(
+				uint64(index.DefnId), "scanall", limit, common.AnyConsistency,
+				nil, handler, 0, nil)
+			return err
+		})
+	if err == nil && g.err == nil {
+		t.Fatalf("expected scan of the previous version to be rejected %v", g.keys)
+	}
+	for i, ti := range indexers {
+		reqs := ti.received()
+		if len(reqs) != 1 {
+			t.Fatalf("expected a request to node %v, received %v", i, reqs)
+		}
+		r := reqs[0].(*protobuf.ScanAllRequest)
+		if r.InstVersion == nil || *r.InstVersion != version {
+			t.Fatalf("expected version %v on node %v, received %v",
+				version, i, r.InstVersion)
+		}
+	}
+}
+
+func TestScanPinnedPartitions(t *testing.T) {
+	index := &common.IndexDefn{
+		DefnId:          1,
+		Bucket:          "default",
+		SecExprs:        []string{"a"},
+		PartitionScheme: common.HASH,
+		PartitionKey:    "a",
+		NumPartitions:   4,
+	}
+
+	// pinned snapshot has entries of partitions before the mutation of
+	// doc2, later snapshots have both.
+	serve := func(node int, req interface{}) []interface{} {
+		r, ok := req.(*protobuf.ScanAllRequest)
+		if !ok {
+			return nil
+		} else if r.GetSnapshotLease() == 7 {
+			return []interface{}{resumeStream(`[1]`, "doc1")}
+		}
+		return []interface{}{resumeStream(`[1]`, "doc1", `[2]`, "doc2")}
+	}
+	lease := &SnapshotLease{
+		Id: 7, DefnID: 1, Queryport: "node0:9101", targetDefnID: 1,
+	}
+
+	c, _ := testPartitionedClient(index, index.PlacePartitions(1), serve)
+	g := &testGathered{}
+	err := c.WithSnapshot(lease).ScanAll(
+		1, "pinned", math.MaxInt64, common.AnyConsistency, nil, g.handler)
+	if err != nil || g.err != nil {
+		t.Fatalf("unexpected error %v %v", err, g.err)
+	} else if s := fmt.Sprint(g.keys); s != "[[1]]" {
+		t.Fatalf("unexpected entries of pinned snapshot %v", s)
+	}
+	g = &testGathered{}
+	err = c.ScanAll(
+		1, "unpinned", math.MaxInt64, common.AnyConsistency, nil, g.handler)
+	if err != nil || g.err != nil {
+		t.Fatalf("unexpected error %v %v", err, g.err)
+	} else if s := fmt.Sprint(g.keys); s != "[[1] [2]]" {
+		t.Fatalf("unexpected entries %v", s)
+	}
+
+	// partitions hosted by another indexer are not in the snapshot.
+	c, indexers := testPartitionedClient(index, index.PlacePartitions(2), serve)
+	_, err = c.PinSnapshot(
+		1, "pin", common.AnyConsistency, nil, 0)
+	if err != ErrorSnapshotLeaseAcrossNodes {
+		t.Fatalf("expected %v, received %v", ErrorSnapshotLeaseAcrossNodes, err)
+	}
+	g = &testGathered{}
+	err = c.WithSnapshot(lease).ScanAll(
+		1, "pinned", math.MaxInt64, common.AnyConsistency, nil, g.handler)
+	if err != ErrorSnapshotLeaseAcrossNodes {
+		t.Fatalf("expected %v, received %v", ErrorSnapshotLeaseAcrossNodes, err)
+	}
+	if reqs := indexers[1].received(); len(reqs) != 0 {
+		t.Fatalf("unexpected requests to other indexer %v", reqs)
+	}
+}
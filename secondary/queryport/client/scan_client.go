@@ -10,10 +10,12 @@
 
 package client
 
+import "crypto/tls"
 import "errors"
 import "fmt"
 import "io"
 import "net"
+import "sync"
 import "time"
 import json "github.com/couchbase/indexing/secondary/common/json"
 import "sync/atomic"
@@ -38,7 +40,12 @@ type GsiScanClient struct {
 	cpAvailWaitTimeout time.Duration
 	logPrefix          string
 
-	serverVersion uint32
+	serverVersion  uint32
+	serverFeatures uint64
+
+	cancelch    <-chan bool // scans are ended when closed, see withCancel()
+	leaseId     uint64      // scans are served from pinned snapshot, see withSnapshot()
+	instVersion *uint64     // scans of another instance version are rejected, see withInstVersion()
 }
 
 func NewGsiScanClient(queryport string, config common.Config) (*GsiScanClient, error) {
@@ -57,6 +64,12 @@ func NewGsiScanClient(queryport string, config common.Config) (*GsiScanClient, e
 	c.pool = newConnectionPool(
 		queryport, c.poolSize, c.poolOverflow, c.maxPayload, c.cpTimeout,
 		c.cpAvailWaitTimeout)
+	tlsConfig, err := scanClientTLSConfig(queryport, config)
+	if err != nil {
+		c.pool.Close()
+		return nil, fmt.Errorf("%s: tls configuration: %v", queryport, err)
+	}
+	c.pool.tlsConfig = tlsConfig
 	logging.Infof("%v started ...\n", c.logPrefix)
 
 	if version, err := c.Helo(); err == nil || err == io.EOF {
@@ -69,6 +82,23 @@ func NewGsiScanClient(queryport string, config common.Config) (*GsiScanClient, e
 	return c, nil
 }
 
+// scanClientTLSConfig returns TLS configuration to connect with
+// queryport, nil if transport is not encrypted. Client running in
+// a process with encrypted transport, like indexer, uses the transport
+// configuration of that process.
+func scanClientTLSConfig(
+	queryport string, config common.Config) (*tls.Config, error) {
+
+	if cv, ok := config["encryptTransport"]; ok && cv.Bool() {
+		tlsConfig, err := common.ClientTLSConfig(config["caFile"].String())
+		if err != nil {
+			return nil, err
+		}
+		return common.TLSConfigForAddr(tlsConfig, queryport), nil
+	}
+	return common.TransportClientTLSConfig(queryport), nil
+}
+
 func (c *GsiScanClient) RefreshServerVersion() {
 	// refresh the version ONLY IF there is no error, so we absolutely
 	// know we have right version.
@@ -83,6 +113,53 @@ func (c *GsiScanClient) NeedSessionConsVector() bool {
 	return atomic.LoadUint32(&c.serverVersion) == 0
 }
 
+// SupportsScanResume returns true if server can resume a scan after an
+// entry returned by an earlier scan.
+func (c *GsiScanClient) SupportsScanResume() bool {
+	features := atomic.LoadUint64(&c.serverFeatures)
+	return features&protobuf.FeatureScanResume != 0
+}
+
+// withCancel returns a copy of the scan client, whose scans are ended
+// by sending protobuf.EndStreamRequest when `cancelch` is closed, even
+// if server has not responded yet.
+func (c *GsiScanClient) withCancel(cancelch <-chan bool) *GsiScanClient {
+	qc := *c
+	qc.cancelch = cancelch
+	return &qc
+}
+
+// SupportsSnapshotLease returns true if server can pin snapshots for
+// scans at a point in time.
+func (c *GsiScanClient) SupportsSnapshotLease() bool {
+	features := atomic.LoadUint64(&c.serverFeatures)
+	return features&protobuf.FeatureSnapshotLease != 0
+}
+
+// SupportsScanFilter returns true if server can filter index entries
+// on a N1QL predicate.
+func (c *GsiScanClient) SupportsScanFilter() bool {
+	features := atomic.LoadUint64(&c.serverFeatures)
+	return features&protobuf.FeatureScanFilter != 0
+}
+
+// withSnapshot returns a copy of the scan client, whose scans are served
+// from the snapshot pinned by lease `leaseId`.
+func (c *GsiScanClient) withSnapshot(leaseId uint64) *GsiScanClient {
+	qc := *c
+	qc.leaseId = leaseId
+	return &qc
+}
+
+// withInstVersion returns a copy of the scan client, whose scans are
+// rejected by indexer if it serves another version of the index instance,
+// like an altered index swapped in on some replicas and not on others.
+func (c *GsiScanClient) withInstVersion(version uint64) *GsiScanClient {
+	qc := *c
+	qc.instVersion = proto.Uint64(version)
+	return &qc
+}
+
 func (c *GsiScanClient) Helo() (uint32, error) {
 	req := &protobuf.HeloRequest{
 		Version: proto.Uint32(uint32(protobuf.ProtobufVersion())),
@@ -93,9 +170,56 @@ func (c *GsiScanClient) Helo() (uint32, error) {
 		return 0, err
 	}
 	heloResp := resp.(*protobuf.HeloResponse)
+	atomic.StoreUint64(&c.serverFeatures, heloResp.GetFeatures())
 	return heloResp.GetVersion(), nil
 }
 
+// PinSnapshot pins a snapshot of index satisfying the consistency, or
+// renews the lease of an already pinned snapshot if `leaseId` is not 0.
+func (c *GsiScanClient) PinSnapshot(
+	defnID uint64, requestId string, cons common.Consistency,
+	vector *TsConsistency, leaseTime time.Duration, leaseId uint64,
+	rollbackTime int64) (*protobuf.PinSnapshotResponse, error) {
+
+	req := &protobuf.PinSnapshotRequest{
+		DefnID:       proto.Uint64(defnID),
+		RequestId:    proto.String(requestId),
+		Cons:         proto.Uint32(uint32(cons)),
+		LeaseTime:    proto.Uint64(uint64(leaseTime / time.Millisecond)),
+		LeaseId:      proto.Uint64(leaseId),
+		RollbackTime: proto.Int64(rollbackTime),
+	}
+	if vector != nil {
+		req.Vector = protobuf.NewTsConsistency(
+			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
+	}
+	resp, err := c.doRequestResponse(req, requestId)
+	if err != nil {
+		return nil, err
+	}
+	pinResp := resp.(*protobuf.PinSnapshotResponse)
+	if pinResp.GetErr() != nil {
+		return nil, errors.New(pinResp.GetErr().GetError())
+	}
+	return pinResp, nil
+}
+
+// UnpinSnapshot releases the snapshot pinned by lease `leaseId`.
+func (c *GsiScanClient) UnpinSnapshot(leaseId uint64) error {
+	req := &protobuf.UnpinSnapshotRequest{
+		LeaseId: proto.Uint64(leaseId),
+	}
+	resp, err := c.doRequestResponse(req, "")
+	if err != nil {
+		return err
+	}
+	unpinResp := resp.(*protobuf.UnpinSnapshotResponse)
+	if unpinResp.GetErr() != nil {
+		return errors.New(unpinResp.GetErr().GetError())
+	}
+	return nil
+}
+
 // LookupStatistics for a single secondary-key.
 func (c *GsiScanClient) LookupStatistics(
 	defnID uint64, value common.SecondaryKey) (common.IndexStatistics, error) {
@@ -162,7 +286,7 @@ func (c *GsiScanClient) Lookup(
 	distinct bool, limit int64,
 	cons common.Consistency, vector *TsConsistency,
 	callb ResponseHandler,
-	rollbackTime int64) (error, bool) {
+	rollbackTime int64, resume *protobuf.ScanResume) (error, bool) {
 
 	// serialize lookup value.
 	equals := make([][]byte, 0, len(values))
@@ -191,6 +315,7 @@ func (c *GsiScanClient) Lookup(
 		Limit:        proto.Int64(limit),
 		Cons:         proto.Uint32(uint32(cons)),
 		RollbackTime: proto.Int64(rollbackTime),
+		Resume:       resume,
 	}
 	if vector != nil {
 		req.Vector = protobuf.NewTsConsistency(
@@ -205,10 +330,13 @@ func (c *GsiScanClient) Lookup(
 		return err, false
 	}
 
+	sc := c.watchCancel(conn, requestId)
+	defer sc.stop()
+
 	cont, partial := true, false
 	for cont {
 		// <--- protobuf.ResponseStream
-		cont, healthy, err = c.streamResponse(conn, pkt, callb, requestId)
+		cont, healthy, err = c.streamResponse(conn, pkt, callb, requestId, sc)
 		if err != nil { // if err, cont should have been set to false
 			fmsg := "%v Lookup(%s) response failed `%v`\n"
 			logging.Errorf(fmsg, c.logPrefix, requestId, err)
@@ -222,8 +350,10 @@ func (c *GsiScanClient) Lookup(
 // Range scan index between low and high.
 func (c *GsiScanClient) Range(
 	defnID uint64, requestId string, low, high common.SecondaryKey, inclusion Inclusion,
-	distinct bool, limit int64, cons common.Consistency, vector *TsConsistency,
-	callb ResponseHandler, rollbackTime int64) (error, bool) {
+	distinct bool, partitions []common.PartitionId, limit int64,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler, rollbackTime int64,
+	resume *protobuf.ScanResume) (error, bool) {
 
 	// serialize low and high values.
 	l, err := json.Marshal(low)
@@ -256,6 +386,10 @@ func (c *GsiScanClient) Range(
 		Limit:        proto.Int64(limit),
 		Cons:         proto.Uint32(uint32(cons)),
 		RollbackTime: proto.Int64(rollbackTime),
+		Resume:       resume,
+	}
+	for _, partnId := range partitions {
+		req.PartitionIds = append(req.PartitionIds, uint64(partnId))
 	}
 	if vector != nil {
 		req.Vector = protobuf.NewTsConsistency(
@@ -269,10 +403,13 @@ func (c *GsiScanClient) Range(
 		return err, false
 	}
 
+	sc := c.watchCancel(conn, requestId)
+	defer sc.stop()
+
 	cont, partial := true, false
 	for cont {
 		// <--- protobuf.ResponseStream
-		cont, healthy, err = c.streamResponse(conn, pkt, callb, requestId)
+		cont, healthy, err = c.streamResponse(conn, pkt, callb, requestId, sc)
 		if err != nil { // if err, cont should have been set to false
 			fmsg := "%v Range(%v) response failed `%v`\n"
 			logging.Errorf(fmsg, c.logPrefix, requestId, err)
@@ -287,7 +424,8 @@ func (c *GsiScanClient) Range(
 func (c *GsiScanClient) RangePrimary(
 	defnID uint64, requestId string, low, high []byte, inclusion Inclusion,
 	distinct bool, limit int64, cons common.Consistency, vector *TsConsistency,
-	callb ResponseHandler, rollbackTime int64) (error, bool) {
+	callb ResponseHandler, rollbackTime int64,
+	resume *protobuf.ScanResume) (error, bool) {
 
 	connectn, err := c.pool.Get()
 	if err != nil {
@@ -311,6 +449,7 @@ func (c *GsiScanClient) RangePrimary(
 		Limit:        proto.Int64(limit),
 		Cons:         proto.Uint32(uint32(cons)),
 		RollbackTime: proto.Int64(rollbackTime),
+		Resume:       resume,
 	}
 	if vector != nil {
 		req.Vector = protobuf.NewTsConsistency(
@@ -324,10 +463,13 @@ func (c *GsiScanClient) RangePrimary(
 		return err, false
 	}
 
+	sc := c.watchCancel(conn, requestId)
+	defer sc.stop()
+
 	cont, partial := true, false
 	for cont {
 		// <--- protobuf.ResponseStream
-		cont, healthy, err = c.streamResponse(conn, pkt, callb, requestId)
+		cont, healthy, err = c.streamResponse(conn, pkt, callb, requestId, sc)
 		if err != nil { // if err, cont should have been set to false
 			fmsg := "%v RangePrimary(%v) response failed `%v`\n"
 			logging.Errorf(fmsg, c.logPrefix, requestId, err)
@@ -342,7 +484,8 @@ func (c *GsiScanClient) RangePrimary(
 func (c *GsiScanClient) ScanAll(
 	defnID uint64, requestId string, limit int64,
 	cons common.Consistency, vector *TsConsistency,
-	callb ResponseHandler, rollbackTime int64) (error, bool) {
+	callb ResponseHandler, rollbackTime int64,
+	resume *protobuf.ScanResume) (error, bool) {
 
 	connectn, err := c.pool.Get()
 	if err != nil {
@@ -359,6 +502,7 @@ func (c *GsiScanClient) ScanAll(
 		Limit:        proto.Int64(limit),
 		Cons:         proto.Uint32(uint32(cons)),
 		RollbackTime: proto.Int64(rollbackTime),
+		Resume:       resume,
 	}
 	if vector != nil {
 		req.Vector = protobuf.NewTsConsistency(
@@ -371,10 +515,13 @@ func (c *GsiScanClient) ScanAll(
 		return err, false
 	}
 
+	sc := c.watchCancel(conn, requestId)
+	defer sc.stop()
+
 	cont, partial := true, false
 	for cont {
 		// <--- protobuf.ResponseStream
-		cont, healthy, err = c.streamResponse(conn, pkt, callb, requestId)
+		cont, healthy, err = c.streamResponse(conn, pkt, callb, requestId, sc)
 		if err != nil { // if err, cont should have been set to false
 			fmsg := "%v ScanAll(%v) response failed `%v`\n"
 			logging.Errorf(fmsg, c.logPrefix, requestId, err)
@@ -387,9 +534,12 @@ func (c *GsiScanClient) ScanAll(
 
 func (c *GsiScanClient) MultiScan(
 	defnID uint64, requestId string, scans Scans,
-	reverse, distinct bool, projection *IndexProjection, offset, limit int64,
+	reverse, distinct bool, projection *IndexProjection,
+	groupAggr *GroupAggr, sort *IndexSort, filter string,
+	partitions []common.PartitionId, offset, limit int64,
 	cons common.Consistency, vector *TsConsistency,
-	callb ResponseHandler, rollbackTime int64) (error, bool) {
+	callb ResponseHandler, rollbackTime int64,
+	resume *protobuf.ScanResume) (error, bool) {
 
 	// serialize scans
 	protoScans := make([]*protobuf.Scan, len(scans))
@@ -475,6 +625,15 @@ func (c *GsiScanClient) MultiScan(
 		Reverse:         proto.Bool(reverse),
 		Offset:          proto.Int64(offset),
 		RollbackTime:    proto.Int64(rollbackTime),
+		Resume:          resume,
+		GroupAggr:       groupAggr2Proto(groupAggr),
+		Sort:            indexSort2Proto(sort),
+	}
+	if filter != "" {
+		req.Filter = proto.String(filter)
+	}
+	for _, partnId := range partitions {
+		req.PartitionIds = append(req.PartitionIds, uint64(partnId))
 	}
 	if vector != nil {
 		req.Vector = protobuf.NewTsConsistency(
@@ -488,10 +647,13 @@ func (c *GsiScanClient) MultiScan(
 		return err, false
 	}
 
+	sc := c.watchCancel(conn, requestId)
+	defer sc.stop()
+
 	cont, partial := true, false
 	for cont {
 		// <--- protobuf.ResponseStream
-		cont, healthy, err = c.streamResponse(conn, pkt, callb, requestId)
+		cont, healthy, err = c.streamResponse(conn, pkt, callb, requestId, sc)
 		if err != nil { // if err, cont should have been set to false
 			fmsg := "%v Scans(%v) response failed `%v`\n"
 			logging.Errorf(fmsg, c.logPrefix, requestId, err)
@@ -506,7 +668,8 @@ func (c *GsiScanClient) MultiScanPrimary(
 	defnID uint64, requestId string, scans Scans,
 	reverse, distinct bool, projection *IndexProjection, offset, limit int64,
 	cons common.Consistency, vector *TsConsistency,
-	callb ResponseHandler, rollbackTime int64) (error, bool) {
+	callb ResponseHandler, rollbackTime int64,
+	resume *protobuf.ScanResume) (error, bool) {
 	var what string
 	// serialize scans
 	protoScans := make([]*protobuf.Scan, len(scans))
@@ -595,6 +758,7 @@ func (c *GsiScanClient) MultiScanPrimary(
 		Reverse:         proto.Bool(reverse),
 		Offset:          proto.Int64(offset),
 		RollbackTime:    proto.Int64(rollbackTime),
+		Resume:          resume,
 	}
 	if vector != nil {
 		req.Vector = protobuf.NewTsConsistency(
@@ -608,10 +772,13 @@ func (c *GsiScanClient) MultiScanPrimary(
 		return err, false
 	}
 
+	sc := c.watchCancel(conn, requestId)
+	defer sc.stop()
+
 	cont, partial := true, false
 	for cont {
 		// <--- protobuf.ResponseStream
-		cont, healthy, err = c.streamResponse(conn, pkt, callb, requestId)
+		cont, healthy, err = c.streamResponse(conn, pkt, callb, requestId, sc)
 		if err != nil { // if err, cont should have been set to false
 			fmsg := "%v Scans(%v) response failed `%v`\n"
 			logging.Errorf(fmsg, c.logPrefix, requestId, err)
@@ -938,6 +1105,48 @@ func (c *GsiScanClient) MultiScanCountPrimary(
 	return countResp.GetCount(), nil
 }
 
+func groupAggr2Proto(groupAggr *GroupAggr) *protobuf.GroupAggr {
+	if groupAggr == nil {
+		return nil
+	}
+
+	protoGroupAggr := &protobuf.GroupAggr{
+		GroupKeys: make([]*protobuf.GroupKey, 0, len(groupAggr.Group)),
+		Aggrs:     make([]*protobuf.Aggregate, 0, len(groupAggr.Aggrs)),
+	}
+	for _, g := range groupAggr.Group {
+		protoGroupAggr.GroupKeys = append(protoGroupAggr.GroupKeys,
+			&protobuf.GroupKey{KeyPos: proto.Int32(g.KeyPos)})
+	}
+	for _, a := range groupAggr.Aggrs {
+		protoGroupAggr.Aggrs = append(protoGroupAggr.Aggrs,
+			&protobuf.Aggregate{
+				AggrFunc: proto.Uint32(uint32(a.AggrFunc)),
+				KeyPos:   proto.Int32(a.KeyPos),
+				Distinct: proto.Bool(a.Distinct),
+			})
+	}
+	return protoGroupAggr
+}
+
+func indexSort2Proto(sort *IndexSort) *protobuf.IndexSort {
+	if sort == nil {
+		return nil
+	}
+
+	protoSort := &protobuf.IndexSort{
+		SortKeys: make([]*protobuf.SortKey, 0, len(sort.Keys)),
+	}
+	for _, k := range sort.Keys {
+		protoSort.SortKeys = append(protoSort.SortKeys,
+			&protobuf.SortKey{
+				KeyPos: proto.Int32(k.KeyPos),
+				Desc:   proto.Bool(k.Desc),
+			})
+	}
+	return protoSort
+}
+
 func (c *GsiScanClient) Close() error {
 	return c.pool.Close()
 }
@@ -990,6 +1199,27 @@ func (c *GsiScanClient) doRequestResponse(
 func (c *GsiScanClient) sendRequest(
 	conn net.Conn, pkt *transport.TransportPacket, req interface{}) (err error) {
 
+	if c.leaseId != 0 {
+		switch r := req.(type) {
+		case *protobuf.ScanRequest:
+			r.SnapshotLease = proto.Uint64(c.leaseId)
+		case *protobuf.ScanAllRequest:
+			r.SnapshotLease = proto.Uint64(c.leaseId)
+		case *protobuf.CountRequest:
+			r.SnapshotLease = proto.Uint64(c.leaseId)
+		}
+	}
+	if c.instVersion != nil {
+		switch r := req.(type) {
+		case *protobuf.ScanRequest:
+			r.InstVersion = c.instVersion
+		case *protobuf.ScanAllRequest:
+			r.InstVersion = c.instVersion
+		case *protobuf.CountRequest:
+			r.InstVersion = c.instVersion
+		}
+	}
+
 	c.trySetDeadline(conn, c.writeDeadline)
 	return pkt.Send(conn, req)
 }
@@ -997,7 +1227,8 @@ func (c *GsiScanClient) sendRequest(
 func (c *GsiScanClient) streamResponse(
 	conn net.Conn,
 	pkt *transport.TransportPacket,
-	callb ResponseHandler, requestId string) (cont bool, healthy bool, err error) {
+	callb ResponseHandler, requestId string,
+	sc *streamCancel) (cont bool, healthy bool, err error) {
 
 	var resp interface{}
 	var finish bool
@@ -1035,7 +1266,7 @@ func (c *GsiScanClient) streamResponse(
 
 	var closeErr error
 	if cont == false && healthy == true && finish == false {
-		closeErr, healthy = c.closeStream(conn, pkt, requestId)
+		closeErr, healthy = c.closeStream(conn, pkt, requestId, sc)
 		if err == nil {
 			err = closeErr
 		}
@@ -1045,21 +1276,24 @@ func (c *GsiScanClient) streamResponse(
 
 func (c *GsiScanClient) closeStream(
 	conn net.Conn, pkt *transport.TransportPacket,
-	requestId string) (err error, healthy bool) {
+	requestId string, sc *streamCancel) (err error, healthy bool) {
 
 	var resp interface{}
 	laddr := conn.LocalAddr()
 	healthy = true
-	// request server to end the stream.
-	err = c.sendRequest(conn, pkt, &protobuf.EndStreamRequest{})
-	if err != nil {
-		fmsg := "%v closeStream(%v) request transport failed `%v`\n"
-		logging.Errorf(fmsg, c.logPrefix, requestId, err)
-		healthy = false
-		return
+	// request server to end the stream, unless already requested on
+	// cancel.
+	if sc.endStream() {
+		err = c.sendRequest(conn, pkt, &protobuf.EndStreamRequest{})
+		if err != nil {
+			fmsg := "%v closeStream(%v) request transport failed `%v`\n"
+			logging.Errorf(fmsg, c.logPrefix, requestId, err)
+			healthy = false
+			return
+		}
+		fmsg := "%v req(%v) connection %q transmitted protobuf.EndStreamRequest"
+		logging.Tracef(fmsg, c.logPrefix, requestId, laddr)
 	}
-	fmsg := "%v req(%v) connection %q transmitted protobuf.EndStreamRequest"
-	logging.Tracef(fmsg, c.logPrefix, requestId, laddr)
 
 	// flush the connection until stream has ended.
 	for true {
@@ -1083,6 +1317,76 @@ func (c *GsiScanClient) closeStream(
 	return
 }
 
+// streamCancel ends a scan stream when scan client is cancelled, while
+// the stream is waiting for a response. Server fails the stream if
+// protobuf.EndStreamRequest is received more than once, it is sent
+// either by streamCancel or by closeStream.
+type streamCancel struct {
+	mu     sync.Mutex
+	ended  bool // protobuf.EndStreamRequest sent
+	donech chan bool
+	wg     sync.WaitGroup
+}
+
+// watchCancel of scan stream on `conn`, returns nil if scan client
+// cannot be cancelled.
+func (c *GsiScanClient) watchCancel(
+	conn net.Conn, requestId string) *streamCancel {
+
+	if c.cancelch == nil {
+		return nil
+	}
+	sc := &streamCancel{donech: make(chan bool)}
+	sc.wg.Add(1)
+	go func() {
+		defer sc.wg.Done()
+		select {
+		case <-c.cancelch:
+		case <-sc.donech:
+			return
+		}
+		if !sc.endStream() {
+			return
+		}
+		// connection's packet is in use by the stream.
+		flags := transport.TransportFlag(0).SetProtobuf()
+		pkt := transport.NewTransportPacket(c.maxPayload, flags)
+		pkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
+		if err := pkt.Send(conn, &protobuf.EndStreamRequest{}); err != nil {
+			fmsg := "%v cancel(%v) request transport failed `%v`\n"
+			logging.Errorf(fmsg, c.logPrefix, requestId, err)
+			return
+		}
+		fmsg := "%v req(%v) connection %q cancelled, transmitted protobuf.EndStreamRequest"
+		logging.Tracef(fmsg, c.logPrefix, requestId, conn.LocalAddr())
+	}()
+	return sc
+}
+
+// endStream returns true if protobuf.EndStreamRequest is to be sent
+// for the stream.
+func (sc *streamCancel) endStream() bool {
+	if sc == nil {
+		return true
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.ended {
+		return false
+	}
+	sc.ended = true
+	return true
+}
+
+// stop watching for cancel, connection can be reused once stopped.
+func (sc *streamCancel) stop() {
+	if sc == nil {
+		return
+	}
+	close(sc.donech)
+	sc.wg.Wait()
+}
+
 func (c *GsiScanClient) trySetDeadline(conn net.Conn, deadline time.Duration) {
 	if deadline > time.Duration(0) {
 		timeoutMs := deadline * time.Millisecond
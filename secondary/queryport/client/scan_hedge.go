@@ -0,0 +1,370 @@
+package client
+
+import "math"
+import "sort"
+import "sync"
+import "sync/atomic"
+import "time"
+
+import "github.com/couchbase/indexing/secondary/common"
+import "github.com/couchbase/indexing/secondary/logging"
+
+// Hedged scans, when enabled, send a scan that has not received a response
+// from the chosen replica within hedge delay to another replica of the
+// index. Replica that responds first serves the scan, scan on the other
+// replica is cancelled. Hedge delay of a replica is a percentile of the
+// latency of its first response, replica is hedged only after enough
+// latencies are sampled.
+
+const (
+	hedgeLatencySamples = 128 // latencies sampled per replica
+	hedgeMinSamples     = 16  // samples required to compute hedge delay
+	hedgeComputeSamples = 16  // samples between hedge delay computations
+	hedgeIdleTimeout    = 10 * time.Minute
+	hedgePruneInterval  = time.Minute
+)
+
+// HedgeStats of hedged scans.
+type HedgeStats struct {
+	Fired int64 // scans sent to another replica
+	Won   int64 // hedged scans served by the other replica
+}
+
+// scanHedger samples first response latency of replicas and counts
+// hedged scans.
+type scanHedger struct {
+	fired int64
+	won   int64
+
+	mu        sync.Mutex
+	latencies map[uint64]*latencyWindow // instID -> latencies
+	prunedAt  time.Time
+}
+
+type latencyWindow struct {
+	samples    []time.Duration // ring of latest samples
+	next       int
+	computed   int // samples since delay was computed
+	percentile float64
+	delay      time.Duration
+	ready      bool
+	last       time.Time
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+func newScanHedger() *scanHedger {
+	return &scanHedger{
+		latencies: make(map[uint64]*latencyWindow),
+		prunedAt:  time.Now(),
+	}
+}
+
+// record first response `latency` of replica `instID`.
+func (h *scanHedger) record(
+	instID uint64, latency time.Duration, percentile float64) {
+
+	now := time.Now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if now.Sub(h.prunedAt) > hedgePruneInterval {
+		for id, w := range h.latencies {
+			if now.Sub(w.last) > hedgeIdleTimeout {
+				delete(h.latencies, id)
+			}
+		}
+		h.prunedAt = now
+	}
+
+	w, ok := h.latencies[instID]
+	if !ok {
+		w = &latencyWindow{}
+		h.latencies[instID] = w
+	}
+	w.add(latency, percentile, now)
+}
+
+// delay to hedge a scan on replica `instID`, returns false if not
+// enough latencies are sampled for the replica.
+func (h *scanHedger) delay(
+	instID uint64, minDelay time.Duration) (time.Duration, bool) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	w, ok := h.latencies[instID]
+	if !ok || !w.ready {
+		return 0, false
+	}
+	if w.delay < minDelay {
+		return minDelay, true
+	}
+	return w.delay, true
+}
+
+func (h *scanHedger) stats() HedgeStats {
+	return HedgeStats{
+		Fired: atomic.LoadInt64(&h.fired),
+		Won:   atomic.LoadInt64(&h.won),
+	}
+}
+
+func (w *latencyWindow) add(
+	latency time.Duration, percentile float64, now time.Time) {
+
+	if len(w.samples) < hedgeLatencySamples {
+		w.samples = append(w.samples, latency)
+	} else {
+		w.samples[w.next] = latency
+		w.next = (w.next + 1) % len(w.samples)
+	}
+	w.last = now
+	w.computed++
+
+	if len(w.samples) < hedgeMinSamples {
+		return
+	}
+	if w.ready && w.computed < hedgeComputeSamples && w.percentile == percentile {
+		return
+	}
+	sorted := make(durations, len(w.samples))
+	copy(sorted, w.samples)
+	sort.Sort(sorted)
+	i := int(math.Ceil(percentile*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	w.delay, w.percentile, w.ready = sorted[i], percentile, true
+	w.computed = 0
+}
+
+// hedgeTarget is a replica to scan.
+type hedgeTarget struct {
+	qc           *GsiScanClient
+	index        *common.IndexDefn
+	queryport    string
+	defnID       uint64
+	instID       uint64
+	rollbackTime int64
+}
+
+// hedgeAttempt is a scan on a replica.
+type hedgeAttempt struct {
+	target    hedgeTarget
+	begin     time.Time
+	responded bool
+	cancelch  chan bool
+	donech    chan bool
+	err       error
+	partial   bool
+}
+
+// scanHedge forwards responses from the attempt that responds first, or
+// that completes first without responses.
+type scanHedge struct {
+	mu         sync.Mutex
+	handler    ResponseHandler
+	hedger     *scanHedger
+	percentile float64
+	winner     *hedgeAttempt
+	decidech   chan bool // closed once winner is decided
+}
+
+// start scan `callb` on `target` replica.
+func (h *scanHedge) start(
+	target hedgeTarget,
+	callb func(*GsiScanClient, *common.IndexDefn, int64, ResponseHandler) (error, bool)) *hedgeAttempt {
+
+	a := &hedgeAttempt{
+		target:   target,
+		begin:    time.Now(),
+		cancelch: make(chan bool),
+		donech:   make(chan bool),
+	}
+	qc := target.qc.withCancel(a.cancelch)
+	handler := func(resp ResponseReader) bool {
+		if !h.respond(a) {
+			// drain the cancelled scan.
+			return true
+		}
+		return h.handler(resp)
+	}
+	go func() {
+		err, partial := callb(qc, target.index, target.rollbackTime, handler)
+		h.finish(a, err, partial)
+	}()
+	return a
+}
+
+// respond returns true if responses of attempt `a` are to be forwarded.
+func (h *scanHedge) respond(a *hedgeAttempt) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !a.responded {
+		a.responded = true
+		h.hedger.record(a.target.instID, time.Since(a.begin), h.percentile)
+	}
+	if h.winner == nil {
+		h.winner = a
+		close(h.decidech)
+	}
+	return h.winner == a
+}
+
+func (h *scanHedge) finish(a *hedgeAttempt, err error, partial bool) {
+	h.mu.Lock()
+	a.err, a.partial = err, partial
+	if h.winner == nil && err == nil {
+		h.winner = a
+		close(h.decidech)
+	}
+	h.mu.Unlock()
+	close(a.donech)
+}
+
+func (h *scanHedge) decided() *hedgeAttempt {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.winner
+}
+
+// wait for an attempt to win, returns nil if all attempts failed.
+func (h *scanHedge) wait(first, second *hedgeAttempt) *hedgeAttempt {
+	donech1, donech2 := first.donech, second.donech
+	for donech1 != nil || donech2 != nil {
+		select {
+		case <-h.decidech:
+			return h.decided()
+		case <-donech1:
+			donech1 = nil
+		case <-donech2:
+			donech2 = nil
+		}
+	}
+	return h.decided()
+}
+
+// race waits for an attempt to win and cancels the other, so that its
+// stream is ended and its responses are drained. Returns the winner once
+// it completes, nil if all attempts failed.
+func (h *scanHedge) race(first, second *hedgeAttempt) *hedgeAttempt {
+	winner := h.wait(first, second)
+	if winner == nil {
+		return nil
+	}
+	for _, a := range []*hedgeAttempt{first, second} {
+		if a != winner {
+			close(a.cancelch)
+		}
+	}
+	<-winner.donech
+	return winner
+}
+
+// hedgedScan runs scan `callb` on `target` replica, and on another
+// replica of index `defnID` if target has not responded within hedge
+// delay. Returns the result of the replica that responded first, or of
+// `target` if scans on both replicas failed.
+func (c *GsiClient) hedgedScan(
+	defnID uint64, requestId string, target hedgeTarget,
+	excludes map[uint64]bool, handler ResponseHandler,
+	callb func(*GsiScanClient, *common.IndexDefn, int64, ResponseHandler) (error, bool)) (error, bool, hedgeTarget) {
+
+	h := &scanHedge{
+		handler:    handler,
+		hedger:     c.hedger,
+		percentile: c.settings.ScanHedgePercentile(),
+		decidech:   make(chan bool),
+	}
+	first := h.start(target, callb)
+
+	delay, ok := c.hedger.delay(target.instID, c.settings.ScanHedgeMinDelay())
+	if ok {
+		timer := time.NewTimer(delay)
+		select {
+		case <-h.decidech:
+			ok = false
+		case <-first.donech:
+			ok = false
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+	var other hedgeTarget
+	if ok && h.decided() == nil {
+		other, ok = c.hedgeTarget(defnID, target, excludes)
+	} else {
+		ok = false
+	}
+	if !ok {
+		<-first.donech
+		return first.err, first.partial, target
+	}
+
+	atomic.AddInt64(&c.hedger.fired, 1)
+	fmsg := "Hedge scan for index %v:%v after %v to index %v:%v, reqId:%v\n"
+	logging.Verbosef(
+		fmsg, target.defnID, target.instID, delay, other.defnID, other.instID,
+		requestId)
+	second := h.start(other, callb)
+
+	winner := h.race(first, second)
+	if winner == nil {
+		return first.err, first.partial, target
+	}
+	if winner == second {
+		atomic.AddInt64(&c.hedger.won, 1)
+	}
+	return winner.err, winner.partial, winner.target
+}
+
+// hedgeTarget picks another replica of index `defnID` to hedge a scan on
+// `target`.
+func (c *GsiClient) hedgeTarget(
+	defnID uint64, target hedgeTarget,
+	excludes map[uint64]bool) (hedgeTarget, bool) {
+
+	hedgeExcludes := map[uint64]bool{target.instID: true}
+	for instID := range excludes {
+		hedgeExcludes[instID] = true
+	}
+	queryport, targetDefnID, targetInstID, rollbackTime, ok :=
+		c.bridge.GetScanport(defnID, 0, hedgeExcludes)
+	if !ok || queryport == target.queryport {
+		return hedgeTarget{}, false
+	}
+	qcs := *((*map[string]*GsiScanClient)(atomic.LoadPointer(&c.queryClients)))
+	qc, ok := qcs[queryport]
+	if !ok {
+		return hedgeTarget{}, false
+	}
+	other := hedgeTarget{
+		qc:           c.instanceClient(qc, targetInstID),
+		index:        c.bridge.GetIndexDefn(targetDefnID),
+		queryport:    queryport,
+		defnID:       targetDefnID,
+		instID:       targetInstID,
+		rollbackTime: rollbackTime,
+	}
+	return other, true
+}
+
+// hedgeScans returns true if scans are to be hedged.
+func (c *GsiClient) hedgeScans() bool {
+	return c.hedger != nil && c.settings != nil && c.settings.ScanHedge()
+}
+
+// HedgeStats returns statistics of hedged scans.
+func (c *GsiClient) HedgeStats() HedgeStats {
+	if c.hedger == nil {
+		return HedgeStats{}
+	}
+	return c.hedger.stats()
+}
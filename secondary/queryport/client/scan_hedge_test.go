@@ -0,0 +1,243 @@
+package client
+
+import "errors"
+import "net"
+import "testing"
+import "time"
+
+import "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+import "github.com/couchbase/indexing/secondary/transport"
+
+func TestLatencyWindow(t *testing.T) {
+	h := newScanHedger()
+	for i := 1; i < hedgeMinSamples; i++ {
+		h.record(1, time.Duration(i)*time.Millisecond, 0.9)
+	}
+	if _, ok := h.delay(1, 0); ok {
+		t.Fatalf("unexpected delay before %v samples", hedgeMinSamples)
+	}
+
+	// 90th percentile of 1ms..16ms.
+	h.record(1, hedgeMinSamples*time.Millisecond, 0.9)
+	if delay, ok := h.delay(1, 0); !ok || delay != 15*time.Millisecond {
+		t.Fatalf("expected delay 15ms, received %v %v", delay, ok)
+	}
+	if delay, ok := h.delay(1, 20*time.Millisecond); !ok || delay != 20*time.Millisecond {
+		t.Fatalf("expected minimum delay, received %v %v", delay, ok)
+	}
+	if _, ok := h.delay(2, 0); ok {
+		t.Fatalf("unexpected delay for replica without samples")
+	}
+
+	// delay is computed again when percentile changes.
+	h.record(1, time.Millisecond, 0.5)
+	if delay, _ := h.delay(1, 0); delay != 8*time.Millisecond {
+		t.Fatalf("expected median delay 8ms, received %v", delay)
+	}
+
+	// oldest samples are replaced, delay is computed every
+	// hedgeComputeSamples samples.
+	for i := 0; i < hedgeLatencySamples; i++ {
+		h.record(1, 100*time.Millisecond, 0.5)
+	}
+	if delay, _ := h.delay(1, 0); delay != 100*time.Millisecond {
+		t.Fatalf("expected delay 100ms, received %v", delay)
+	}
+	w := h.latencies[1]
+	if len(w.samples) != hedgeLatencySamples || w.computed >= hedgeComputeSamples {
+		t.Fatalf("unexpected window %v %v", len(w.samples), w.computed)
+	}
+
+	// idle replicas are pruned.
+	w.last = time.Now().Add(-2 * hedgeIdleTimeout)
+	h.prunedAt = time.Now().Add(-2 * hedgePruneInterval)
+	h.record(2, time.Millisecond, 0.5)
+	if _, ok := h.latencies[1]; ok {
+		t.Fatalf("expected idle replica to be pruned")
+	}
+}
+
+func newTestHedge(handler ResponseHandler) *scanHedge {
+	return &scanHedge{
+		handler:    handler,
+		hedger:     newScanHedger(),
+		percentile: 0.9,
+		decidech:   make(chan bool),
+	}
+}
+
+type hedgeCallback func(*GsiScanClient, *common.IndexDefn, int64, ResponseHandler) (error, bool)
+
+func TestScanHedgeWinner(t *testing.T) {
+	var rows int
+	h := newTestHedge(func(resp ResponseReader) bool {
+		rows += len(resp.(*protobuf.ResponseStream).GetIndexEntries())
+		return true
+	})
+	qc := &GsiScanClient{maxPayload: 1024 * 1024, logPrefix: "test"}
+
+	// slow replica responds only after it is cancelled.
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	var slow hedgeCallback = func(
+		qc *GsiScanClient, _ *common.IndexDefn, _ int64,
+		handler ResponseHandler) (error, bool) {
+
+		sc := qc.watchCancel(client, "req")
+		<-qc.cancelch
+		sc.wg.Wait()
+		handler(resumeStream(`[1]`, "doc1", `[2]`, "doc2"))
+		return nil, false
+	}
+	var fast hedgeCallback = func(
+		qc *GsiScanClient, _ *common.IndexDefn, _ int64,
+		handler ResponseHandler) (error, bool) {
+
+		handler(resumeStream(`[1]`, "doc1"))
+		return nil, false
+	}
+
+	first := h.start(hedgeTarget{qc: qc, instID: 1}, slow)
+	second := h.start(hedgeTarget{qc: qc, instID: 2}, fast)
+
+	// loser's stream is ended with protobuf.EndStreamRequest.
+	endch := make(chan interface{}, 1)
+	go func() {
+		flags := transport.TransportFlag(0).SetProtobuf()
+		pkt := transport.NewTransportPacket(qc.maxPayload, flags)
+		pkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
+		req, err := pkt.Receive(server)
+		if err != nil {
+			endch <- err
+			return
+		}
+		endch <- req
+	}()
+
+	if winner := h.race(first, second); winner != second {
+		t.Fatalf("expected fast replica to win")
+	}
+	select {
+	case req := <-endch:
+		if _, ok := req.(*protobuf.EndStreamRequest); !ok {
+			t.Fatalf("expected EndStreamRequest, received %v", req)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for EndStreamRequest")
+	}
+
+	// rows of the loser never reach the handler.
+	<-first.donech
+	if rows != 1 {
+		t.Fatalf("expected rows of the winner only, received %v", rows)
+	}
+	if _, ok := h.hedger.latencies[2]; !ok {
+		t.Fatalf("expected latency of the winner to be sampled")
+	}
+}
+
+func TestScanHedgeFailed(t *testing.T) {
+	h := newTestHedge(func(resp ResponseReader) bool { return true })
+	qc := &GsiScanClient{logPrefix: "test"}
+
+	failed := errors.New("failed")
+	var fail hedgeCallback = func(
+		*GsiScanClient, *common.IndexDefn, int64, ResponseHandler) (error, bool) {
+		return failed, false
+	}
+	first := h.start(hedgeTarget{qc: qc, instID: 1}, fail)
+	second := h.start(hedgeTarget{qc: qc, instID: 2}, fail)
+	if winner := h.race(first, second); winner != nil {
+		t.Fatalf("unexpected winner %v", winner.target.instID)
+	}
+	if first.err != failed || second.err != failed {
+		t.Fatalf("unexpected errors %v %v", first.err, second.err)
+	}
+
+	// replica that completes without responses wins.
+	h = newTestHedge(func(resp ResponseReader) bool { return true })
+	var empty hedgeCallback = func(
+		*GsiScanClient, *common.IndexDefn, int64, ResponseHandler) (error, bool) {
+		return nil, false
+	}
+	first = h.start(hedgeTarget{qc: qc, instID: 1}, fail)
+	second = h.start(hedgeTarget{qc: qc, instID: 2}, empty)
+	if winner := h.race(first, second); winner != second {
+		t.Fatalf("expected replica without responses to win")
+	}
+}
+
+func TestScanHedgePartitioned(t *testing.T) {
+	index := &common.IndexDefn{
+		DefnId:          1,
+		Bucket:          "default",
+		SecExprs:        []string{"a"},
+		PartitionScheme: common.HASH,
+		PartitionKey:    "a",
+		NumPartitions:   4,
+	}
+	placement := index.PlacePartitions(2)
+
+	// indexers hosting the partitions respond only after their streams
+	// are ended.
+	var indexers []*testIndexer
+	c, indexers := testPartitionedClient(index, placement,
+		func(node int, req interface{}) []interface{} {
+			if _, ok := req.(*protobuf.ScanAllRequest); !ok {
+				return nil
+			}
+			<-indexers[node].endch
+			return []interface{}{resumeStream(`[1]`, "doc1")}
+		})
+
+	var rows int
+	h := newTestHedge(func(resp ResponseReader) bool {
+		rows += len(resp.(*protobuf.ResponseStream).GetIndexEntries())
+		return true
+	})
+	var slow hedgeCallback = func(
+		qc *GsiScanClient, index *common.IndexDefn, _ int64,
+		handler ResponseHandler) (error, bool) {
+
+		nodes, err := c.partitionNodes(index, qc, nil)
+		if err != nil {
+			return err, false
+		}
+		return c.scanNodes(
+			index, nodes, 0, 0, false, nil, handler,
+			func(qc *GsiScanClient, _ []common.PartitionId,
+				limit int64, handler ResponseHandler) error {
+
+				err, _ := qc.ScanAll(
+					uint64(index.DefnId), "req", limit, common.AnyConsistency,
+					nil, handler, 0, nil)
+				return err
+			})
+	}
+	var fast hedgeCallback = func(
+		qc *GsiScanClient, _ *common.IndexDefn, _ int64,
+		handler ResponseHandler) (error, bool) {
+
+		handler(resumeStream(`[2]`, "doc2"))
+		return nil, false
+	}
+
+	qc := indexers[0].qc
+	first := h.start(hedgeTarget{qc: qc, index: index, instID: 1}, slow)
+	second := h.start(hedgeTarget{qc: qc, index: index, instID: 2}, fast)
+	if winner := h.race(first, second); winner != second {
+		t.Fatalf("expected fast replica to win")
+	}
+
+	// streams of the loser are ended on every indexer it scans.
+	select {
+	case <-first.donech:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for partitioned scan to be cancelled")
+	}
+	if rows != 1 {
+		t.Fatalf("expected rows of the winner only, received %v", rows)
+	}
+}
@@ -0,0 +1,177 @@
+package client
+
+import "bytes"
+import "io"
+import "net"
+import "sync"
+
+import "github.com/couchbase/indexing/secondary/common"
+import "github.com/couchbase/indexing/secondary/logging"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+import "github.com/golang/protobuf/proto"
+
+// scanResume tracks entries returned to the caller of a scan, so that a
+// scan that fails midway, after returning entries, can be resumed on
+// another replica after the last returned entry instead of failing the
+// query. Scan can be resumed only if entries are returned in index
+// order, with full entry key and primary key. Concurrent attempts of
+// a hedged scan share the scanResume.
+//
+// Consistency vector is computed once for all attempts, so that the
+// resumed scan sees the same mutations as the scan it resumes.
+type scanResume struct {
+	mu    sync.Mutex
+	callb ResponseHandler
+
+	vector    *TsConsistency
+	hasVector bool
+
+	entryKey   []byte // last returned entry
+	primaryKey []byte
+	count      int64 // number of times last entry was returned
+	rows       int64 // number of rows returned
+	err        error // error that failed the scan
+}
+
+func newScanResume(callb ResponseHandler) *scanResume {
+	return &scanResume{callb: callb}
+}
+
+// isResumableScan returns true if entries of a scan on `index` are
+// returned in index order with full entry key and primary key.
+func isResumableScan(
+	index *common.IndexDefn, reverse, distinct bool,
+	projection *IndexProjection, groupAggr *GroupAggr, sort *IndexSort) bool {
+
+	if reverse || distinct || groupAggr != nil || sort != nil {
+		return false
+	}
+	if index.PartitionScheme != common.RANGE && index.GetNumPartitions() > 1 {
+		// entries of hash partitions are not returned in index order.
+		return false
+	}
+	if projection != nil && !index.IsPrimary {
+		if !projection.PrimaryKey ||
+			len(projection.EntryKeys) != len(index.SecExprs) {
+			return false
+		}
+		for i, pos := range projection.EntryKeys {
+			if pos != int64(i) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// consistency vector for a scan attempt on `qc`, computed by the first
+// attempt and reused by later attempts.
+func (sr *scanResume) consistency(
+	c *GsiClient, qc *GsiScanClient, cons common.Consistency,
+	vector *TsConsistency, bucket string) (*TsConsistency, error) {
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	if sr.hasVector {
+		return sr.vector, nil
+	}
+	vector, err := c.getConsistency(qc, cons, vector, bucket)
+	if err != nil {
+		return nil, err
+	}
+	sr.vector, sr.hasVector = vector, true
+	return vector, nil
+}
+
+// handler forwards responses to the caller, recording the last entry.
+func (sr *scanResume) handler(resp ResponseReader) bool {
+	if stream, ok := resp.(*protobuf.ResponseStream); ok && stream.Error() == nil {
+		sr.mu.Lock()
+		for _, entry := range stream.GetIndexEntries() {
+			sr.record(entry.GetEntryKey(), entry.GetPrimaryKey())
+		}
+		sr.mu.Unlock()
+	}
+	return sr.callb(resp)
+}
+
+func (sr *scanResume) record(entryKey, primaryKey []byte) {
+	sr.rows++
+	if sr.count > 0 && bytes.Equal(primaryKey, sr.primaryKey) &&
+		bytes.Equal(entryKey, sr.entryKey) {
+		sr.count++
+		return
+	}
+	sr.entryKey = append(sr.entryKey[:0], entryKey...)
+	sr.primaryKey = append(sr.primaryKey[:0], primaryKey...)
+	sr.count = 1
+}
+
+// position to resume the scan on `qc` from, nil if scan is to start
+// from the beginning. Returns the error that failed the scan, if it
+// cannot be resumed on `qc`.
+func (sr *scanResume) position(qc *GsiScanClient) (*protobuf.ScanResume, error) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	if sr.rows == 0 {
+		return nil, nil
+	}
+	if !qc.SupportsScanResume() {
+		return nil, sr.err
+	}
+	return &protobuf.ScanResume{
+		EntryKey:   append([]byte(nil), sr.entryKey...),
+		PrimaryKey: append([]byte(nil), sr.primaryKey...),
+		Count:      proto.Int64(sr.count),
+	}, nil
+}
+
+// offsetLimit returns offset and limit for the resumed scan, offset is
+// already applied to returned entries. Returns false if there are no
+// more entries to return.
+func (sr *scanResume) offsetLimit(offset, limit int64) (int64, int64, bool) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	if sr.rows == 0 {
+		return offset, limit, true
+	}
+	if limit > 0 {
+		if limit -= sr.rows; limit <= 0 {
+			return 0, 0, false
+		}
+	}
+	return 0, limit, true
+}
+
+// result of a scan attempt on `qc`. Scan that failed after returning
+// entries is not partial if it is `resumable`, so that it is retried on
+// another replica.
+func (sr *scanResume) result(
+	qc *GsiScanClient, requestId string, resumable bool,
+	err error, partial bool) (error, bool) {
+
+	if !resumable || err == nil || !partial || !isResumableError(err) {
+		return err, partial
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	sr.err = err
+	fmsg := "%v scan(%v) failed after %v rows, resuming on replica: %v\n"
+	logging.Warnf(fmsg, qc.logPrefix, requestId, sr.rows, err)
+	return err, false
+}
+
+// isResumableError returns true for transport failures, where indexer
+// may not be able to serve the scan any more.
+func isResumableError(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
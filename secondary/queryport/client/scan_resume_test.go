@@ -0,0 +1,171 @@
+package client
+
+import "errors"
+import "io"
+import "testing"
+
+import "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+import "github.com/golang/protobuf/proto"
+
+func resumeStream(entries ...string) *protobuf.ResponseStream {
+	resp := &protobuf.ResponseStream{}
+	for i := 0; i < len(entries); i += 2 {
+		resp.IndexEntries = append(resp.IndexEntries, &protobuf.IndexEntry{
+			EntryKey:   []byte(entries[i]),
+			PrimaryKey: []byte(entries[i+1]),
+		})
+	}
+	return resp
+}
+
+func TestScanResumePosition(t *testing.T) {
+	var received int
+	sr := newScanResume(func(resp ResponseReader) bool {
+		received++
+		return true
+	})
+	qc := &GsiScanClient{serverFeatures: protobuf.FeatureScanResume}
+
+	if resume, err := sr.position(qc); resume != nil || err != nil {
+		t.Fatalf("expected scan from the beginning, received %v %v", resume, err)
+	}
+
+	// array entries are returned once for every matching element.
+	sr.handler(resumeStream(`[1]`, "doc1", `[2]`, "doc2"))
+	sr.handler(resumeStream(`[2]`, "doc2", `[2]`, "doc2"))
+	if received != 2 {
+		t.Fatalf("expected responses to be forwarded, received %v", received)
+	}
+	resume, err := sr.position(qc)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(resume.GetEntryKey()) != `[2]` ||
+		string(resume.GetPrimaryKey()) != "doc2" || resume.GetCount() != 3 {
+		t.Fatalf("unexpected position %v", resume)
+	}
+
+	// responses with error are not recorded.
+	sr.handler(&protobuf.ResponseStream{
+		IndexEntries: resumeStream(`[3]`, "doc3").IndexEntries,
+		Err:          &protobuf.Error{Error: proto.String("failed")},
+	})
+	if resume, _ = sr.position(qc); string(resume.GetEntryKey()) != `[2]` {
+		t.Fatalf("unexpected position %v", resume)
+	}
+
+	// indexer without resume fails with the error that failed the scan.
+	err, partial := sr.result(qc, "req", true, io.EOF, true)
+	if err != io.EOF || partial {
+		t.Fatalf("expected resumable failure, received %v %v", err, partial)
+	}
+	if _, err := sr.position(&GsiScanClient{}); err != io.EOF {
+		t.Fatalf("expected %v, received %v", io.EOF, err)
+	}
+}
+
+func TestScanResumeResult(t *testing.T) {
+	sr := newScanResume(func(ResponseReader) bool { return true })
+	qc := &GsiScanClient{}
+	failed := errors.New("failed")
+
+	tests := []struct {
+		resumable bool
+		err       error
+		partial   bool
+	}{
+		{false, io.EOF, true}, // scan is not resumable
+		{true, failed, true},  // indexer failed the scan
+		{true, io.EOF, false}, // failed before returning entries
+		{true, nil, false},    // scan succeeded
+	}
+	for _, test := range tests {
+		err, partial := sr.result(qc, "req", test.resumable, test.err, test.partial)
+		if err != test.err || partial != test.partial {
+			t.Errorf("unexpected result %v %v for %v", err, partial, test)
+		}
+	}
+}
+
+func TestScanResumeOffsetLimit(t *testing.T) {
+	sr := newScanResume(func(ResponseReader) bool { return true })
+	if offset, limit, ok := sr.offsetLimit(5, 10); offset != 5 || limit != 10 || !ok {
+		t.Fatalf("unexpected offset/limit %v %v %v", offset, limit, ok)
+	}
+
+	sr.handler(resumeStream(`[1]`, "doc1", `[2]`, "doc2"))
+	if offset, limit, ok := sr.offsetLimit(5, 10); offset != 0 || limit != 8 || !ok {
+		t.Fatalf("unexpected offset/limit %v %v %v", offset, limit, ok)
+	}
+	if offset, limit, ok := sr.offsetLimit(5, 0); offset != 0 || limit != 0 || !ok {
+		t.Fatalf("unexpected offset/limit without limit %v %v %v", offset, limit, ok)
+	}
+	if _, _, ok := sr.offsetLimit(0, 2); ok {
+		t.Fatalf("expected limit to be reached")
+	}
+}
+
+func TestScanResumeConsistency(t *testing.T) {
+	sr := newScanResume(func(ResponseReader) bool { return true })
+	c, qc := &GsiClient{}, &GsiScanClient{}
+
+	first := &TsConsistency{Vbnos: []uint16{0}, Seqnos: []uint64{10}}
+	vector, err := sr.consistency(c, qc, common.QueryConsistency, first, "default")
+	if err != nil || vector != first {
+		t.Fatalf("unexpected vector %v %v", vector, err)
+	}
+
+	// resumed scans use the vector of the first attempt.
+	second := &TsConsistency{Vbnos: []uint16{0}, Seqnos: []uint64{20}}
+	vector, err = sr.consistency(c, qc, common.QueryConsistency, second, "default")
+	if err != nil || vector != first {
+		t.Fatalf("expected vector of the first attempt, received %v %v", vector, err)
+	}
+
+	sr = newScanResume(func(ResponseReader) bool { return true })
+	if _, err := sr.consistency(c, qc, common.QueryConsistency, nil, "default"); err != ErrorExpectedTimestamp {
+		t.Fatalf("expected %v, received %v", ErrorExpectedTimestamp, err)
+	}
+	if vector, err := sr.consistency(c, qc, common.AnyConsistency, nil, "default"); vector != nil || err != nil {
+		t.Fatalf("unexpected vector %v %v", vector, err)
+	}
+}
+
+func TestIsResumableScan(t *testing.T) {
+	index := &common.IndexDefn{SecExprs: []string{"a", "b"}}
+	full := &IndexProjection{EntryKeys: []int64{0, 1}, PrimaryKey: true}
+
+	tests := []struct {
+		reverse, distinct bool
+		projection        *IndexProjection
+		groupAggr         *GroupAggr
+		sort              *IndexSort
+		resumable         bool
+	}{
+		{false, false, nil, nil, nil, true},
+		{false, false, full, nil, nil, true},
+		{true, false, nil, nil, nil, false},
+		{false, true, nil, nil, nil, false},
+		{false, false, &IndexProjection{EntryKeys: []int64{0}, PrimaryKey: true}, nil, nil, false},
+		{false, false, &IndexProjection{EntryKeys: []int64{0, 1}}, nil, nil, false},
+		{false, false, nil, &GroupAggr{}, nil, false},
+		{false, false, nil, nil, &IndexSort{}, false},
+	}
+	for i, test := range tests {
+		resumable := isResumableScan(index, test.reverse, test.distinct,
+			test.projection, test.groupAggr, test.sort)
+		if resumable != test.resumable {
+			t.Errorf("test %v: expected resumable %v", i, test.resumable)
+		}
+	}
+
+	// entries of hash partitions are not returned in index order.
+	index.PartitionScheme, index.NumPartitions = common.HASH, 8
+	if isResumableScan(index, false, false, nil, nil, nil) {
+		t.Errorf("expected hash partitioned scan to be not resumable")
+	}
+	index.PartitionScheme = common.RANGE
+	if !isResumableScan(index, false, false, nil, nil, nil) {
+		t.Errorf("expected range partitioned scan to be resumable")
+	}
+}
@@ -10,7 +10,6 @@
 package client
 
 import (
-	"github.com/couchbase/cbauth/metakv"
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/logging"
 	"math"
@@ -26,6 +25,10 @@ type ClientSettings struct {
 	prune_replica  int32
 	config         common.Config
 	cancelCh       chan struct{}
+
+	scanHedge           int32
+	scanHedgePercentile uint64
+	scanHedgeMinDelay   int64 // time.Duration
 }
 
 func NewClientSettings(needRefresh bool) *ClientSettings {
@@ -54,7 +57,7 @@ func NewClientSettings(needRefresh bool) *ClientSettings {
 				if r > 0 {
 					logging.Errorf("ClientSettings: metakv notifier failed (%v)..Restarting %v", err, r)
 				}
-				err = metakv.RunObserveChildren(common.IndexingSettingsMetaDir, s.metaKVCallback, s.cancelCh)
+				err = common.Metakv().RunObserveChildren(common.IndexingSettingsMetaDir, s.metaKVCallback, s.cancelCh)
 				return err
 			}
 			rh := common.NewRetryHelper(200, time.Second, 2, fn)
@@ -126,6 +129,27 @@ func (s *ClientSettings) handleSettings(config common.Config) {
 	} else {
 		atomic.StoreInt32(&s.prune_replica, int32(0))
 	}
+
+	if config["queryport.client.scanHedge"].Bool() {
+		atomic.StoreInt32(&s.scanHedge, int32(1))
+	} else {
+		atomic.StoreInt32(&s.scanHedge, int32(0))
+	}
+
+	scanHedgePercentile := config["queryport.client.scanHedgePercentile"].Float64()
+	if scanHedgePercentile > 0 && scanHedgePercentile <= 1.0 {
+		atomic.StoreUint64(&s.scanHedgePercentile, math.Float64bits(scanHedgePercentile))
+	} else {
+		logging.Errorf("ClientSettings: invalid setting value for scanHedgePercentile=%v", scanHedgePercentile)
+	}
+
+	scanHedgeMinDelay := config["queryport.client.scanHedgeMinDelay"].Int()
+	if scanHedgeMinDelay >= 0 {
+		delay := time.Duration(scanHedgeMinDelay) * time.Millisecond
+		atomic.StoreInt64(&s.scanHedgeMinDelay, int64(delay))
+	} else {
+		logging.Errorf("ClientSettings: invalid setting value for scanHedgeMinDelay=%v", scanHedgeMinDelay)
+	}
 }
 
 func (s *ClientSettings) NumReplica() int32 {
@@ -151,3 +175,16 @@ func (s *ClientSettings) DisablePruneReplica() bool {
 	}
 	return false
 }
+
+func (s *ClientSettings) ScanHedge() bool {
+	return atomic.LoadInt32(&s.scanHedge) == 1
+}
+
+func (s *ClientSettings) ScanHedgePercentile() float64 {
+	bits := atomic.LoadUint64(&s.scanHedgePercentile)
+	return math.Float64frombits(bits)
+}
+
+func (s *ClientSettings) ScanHedgeMinDelay() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.scanHedgeMinDelay))
+}
@@ -0,0 +1,186 @@
+package client
+
+import "sync/atomic"
+import "time"
+
+import "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+
+// Scans at a point in time. PinSnapshot pins a snapshot of the index on
+// one of its replicas and returns a lease on it. Scans issued through the
+// client returned by WithSnapshot are served from exactly that snapshot,
+// irrespective of their consistency, until the lease expires or is
+// released by UnpinSnapshot. Scans on an expired lease fail, they are
+// not retried on other replicas since the snapshot exists only on the
+// indexer that pinned it. For the same reason, snapshots of partitioned
+// indexes with partitions hosted by more than one indexer are not pinned.
+
+// SnapshotLease on a snapshot pinned by indexer.
+type SnapshotLease struct {
+	Id        uint64
+	DefnID    uint64         // index requested to be pinned
+	Queryport string         // indexer hosting the pinned snapshot
+	Ts        *TsConsistency // timestamp of the pinned snapshot
+	Expiry    time.Time      // as observed by client
+
+	targetDefnID uint64 // index, or equivalent index, pinned
+	rollbackTime int64
+}
+
+// PinSnapshot pins a snapshot of index `defnID` satisfying the
+// consistency. `leaseTime` of 0 uses indexer default, indexer may
+// grant a shorter lease than requested.
+func (c *GsiClient) PinSnapshot(
+	defnID uint64, requestId string, cons common.Consistency,
+	vector *TsConsistency, leaseTime time.Duration) (*SnapshotLease, error) {
+
+	if c.bridge == nil {
+		return nil, ErrorClientUninitialized
+	}
+
+	// check whether the index is present and available.
+	if _, err := c.bridge.IndexState(defnID); err != nil {
+		return nil, err
+	}
+	if c.partitionsAcrossNodes(defnID) {
+		return nil, ErrorSnapshotLeaseAcrossNodes
+	}
+
+	var lease *SnapshotLease
+	err := c.doScan(
+		defnID, requestId, nil,
+		func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64,
+			_ ResponseHandler) (error, bool) {
+
+			if !qc.SupportsSnapshotLease() {
+				return ErrorSnapshotLeaseUnsupported, false
+			}
+			vector, err := c.getConsistency(qc, cons, vector, index.Bucket)
+			if err != nil {
+				return err, false
+			}
+			begin := time.Now()
+			resp, err := qc.PinSnapshot(
+				uint64(index.DefnId), requestId, cons, vector, leaseTime, 0,
+				rollbackTime)
+			if err != nil {
+				return err, false
+			}
+			lease = &SnapshotLease{
+				Id:           resp.GetLeaseId(),
+				DefnID:       defnID,
+				Queryport:    qc.queryport,
+				Ts:           leaseTsConsistency(resp.GetTs()),
+				Expiry:       leaseExpiry(begin, resp.GetLeaseTime()),
+				targetDefnID: uint64(index.DefnId),
+				rollbackTime: rollbackTime,
+			}
+			return nil, false
+		})
+	if err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// RenewSnapshot extends the lease of pinned snapshot by `leaseTime` from
+// now and returns the renewed lease.
+func (c *GsiClient) RenewSnapshot(
+	lease *SnapshotLease, leaseTime time.Duration) (*SnapshotLease, error) {
+
+	qc, err := c.leaseScanClient(lease)
+	if err != nil {
+		return nil, err
+	}
+	begin := time.Now()
+	resp, err := qc.PinSnapshot(
+		lease.targetDefnID, "", common.AnyConsistency, nil, leaseTime,
+		lease.Id, lease.rollbackTime)
+	if err != nil {
+		return nil, err
+	}
+	renewed := *lease
+	renewed.Expiry = leaseExpiry(begin, resp.GetLeaseTime())
+	return &renewed, nil
+}
+
+// UnpinSnapshot releases the snapshot pinned by lease.
+func (c *GsiClient) UnpinSnapshot(lease *SnapshotLease) error {
+	qc, err := c.leaseScanClient(lease)
+	if err != nil {
+		return err
+	}
+	return qc.UnpinSnapshot(lease.Id)
+}
+
+// WithSnapshot returns a client whose scans on index `lease.DefnID` are
+// served from the snapshot pinned by lease. Returned client shares its
+// connections with `c` and shall not be closed.
+func (c *GsiClient) WithSnapshot(lease *SnapshotLease) *GsiClient {
+	lc := *c
+	lc.lease = lease
+	return &lc
+}
+
+// doSnapshotScan runs `callb` on the indexer hosting the snapshot
+// pinned by lease, without retrying on other replicas.
+func (c *GsiClient) doSnapshotScan(
+	defnID uint64, handler ResponseHandler,
+	callb func(*GsiScanClient, *common.IndexDefn, int64, ResponseHandler) (error, bool)) error {
+
+	lease := c.lease
+	if defnID != lease.DefnID {
+		return ErrorSnapshotLeaseMismatch
+	}
+	qc, err := c.leaseScanClient(lease)
+	if err != nil {
+		return err
+	}
+	index := c.bridge.GetIndexDefn(lease.targetDefnID)
+	if index == nil {
+		return ErrorIndexNotFound
+	}
+	err, _ = callb(qc.withSnapshot(lease.Id), index, lease.rollbackTime, handler)
+	return err
+}
+
+func (c *GsiClient) leaseScanClient(lease *SnapshotLease) (*GsiScanClient, error) {
+	qcs := *((*map[string]*GsiScanClient)(atomic.LoadPointer(&c.queryClients)))
+	if qc, ok := qcs[lease.Queryport]; ok {
+		return qc, nil
+	}
+	return nil, ErrorNoHost
+}
+
+// partitionsAcrossNodes returns true if partitions of index `defnID`
+// are hosted by more than one indexer.
+func (c *GsiClient) partitionsAcrossNodes(defnID uint64) bool {
+	queryports, ok := c.bridge.GetPartitionScanports(defnID)
+	if !ok {
+		return false
+	}
+	var first string
+	for _, queryport := range queryports {
+		if first == "" {
+			first = queryport
+		} else if queryport != first {
+			return true
+		}
+	}
+	return false
+}
+
+func leaseExpiry(begin time.Time, leaseTime uint64) time.Time {
+	return begin.Add(time.Duration(leaseTime) * time.Millisecond)
+}
+
+func leaseTsConsistency(ts *protobuf.TsConsistency) *TsConsistency {
+	if ts == nil {
+		return nil
+	}
+	vbnos := make([]uint16, len(ts.GetVbnos()))
+	for i, vbno := range ts.GetVbnos() {
+		vbnos[i] = uint16(vbno)
+	}
+	return NewTsConsistency(vbnos, ts.GetSeqnos(), ts.GetVbuuids())
+}
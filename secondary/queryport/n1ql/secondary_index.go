@@ -854,6 +854,49 @@ func (si *secondaryIndex2) Scan2(
 	cons datastore.ScanConsistency, vector timestamp.Vector,
 	conn *datastore.IndexConnection) {
 
+	si.doScan2(
+		requestId, spans, reverse, distinct, projection, nil, nil, offset,
+		limit, cons, vector, conn)
+}
+
+// ScanGroupAggr is same as Scan2, except that index entries are grouped
+// and aggregated by indexer as specified by groupAggr. Each entry sent
+// on the connection has the values of group keys followed by values of
+// aggregates as its EntryKey, and an empty PrimaryKey.
+func (si *secondaryIndex2) ScanGroupAggr(
+	requestId string, spans datastore.Spans2, reverse, distinct bool,
+	projection *datastore.IndexProjection, groupAggr *qclient.GroupAggr,
+	offset, limit int64,
+	cons datastore.ScanConsistency, vector timestamp.Vector,
+	conn *datastore.IndexConnection) {
+
+	si.doScan2(
+		requestId, spans, reverse, distinct, projection, groupAggr, nil,
+		offset, limit, cons, vector, conn)
+}
+
+// ScanSorted is same as ScanGroupAggr, except that entries are sent on
+// the connection in the order specified by sort, instead of index
+// order. Offset and limit apply to sorted entries, groupAggr can be nil.
+func (si *secondaryIndex2) ScanSorted(
+	requestId string, spans datastore.Spans2, reverse, distinct bool,
+	projection *datastore.IndexProjection, groupAggr *qclient.GroupAggr,
+	sort *qclient.IndexSort, offset, limit int64,
+	cons datastore.ScanConsistency, vector timestamp.Vector,
+	conn *datastore.IndexConnection) {
+
+	si.doScan2(
+		requestId, spans, reverse, distinct, projection, groupAggr, sort,
+		offset, limit, cons, vector, conn)
+}
+
+func (si *secondaryIndex2) doScan2(
+	requestId string, spans datastore.Spans2, reverse, distinct bool,
+	projection *datastore.IndexProjection, groupAggr *qclient.GroupAggr,
+	sort *qclient.IndexSort, offset, limit int64,
+	cons datastore.ScanConsistency, vector timestamp.Vector,
+	conn *datastore.IndexConnection) {
+
 	entryChannel := conn.EntryChannel()
 	var tmpfile *os.File
 	var backfillSync int64
@@ -887,7 +930,7 @@ func (si *secondaryIndex2) Scan2(
 	gsiprojection := n1qlprojectiontogsi(projection)
 	client.MultiScan(
 		si.defnID, requestId, gsiscans, reverse, distinct,
-		gsiprojection, offset, limit,
+		gsiprojection, groupAggr, sort, "", offset, limit,
 		n1ql2GsiConsistency[cons], vector2ts(vector),
 		makeResponsehandler(
 			requestId,
@@ -1164,6 +1207,7 @@ type statistics struct {
 	uniqueKeys int64
 	min        value.Values
 	max        value.Values
+	bins       []datastore.Statistics
 }
 
 // return an
@@ -1176,6 +1220,10 @@ func newStatistics(pstats c.IndexStatistics) datastore.Statistics {
 	stats.min = skey2Values(min)
 	max, _ := pstats.MaxKey()
 	stats.max = skey2Values(max)
+	bins, _ := pstats.Bins()
+	for _, bin := range bins {
+		stats.bins = append(stats.bins, newStatistics(bin))
+	}
 	return stats
 }
 
@@ -1201,7 +1249,7 @@ func (stats *statistics) Max() (value.Values, errors.Error) {
 
 // Bins implement Statistics{} interface.
 func (stats *statistics) Bins() ([]datastore.Statistics, errors.Error) {
-	return nil, nil
+	return stats.bins, nil
 }
 
 //------------------
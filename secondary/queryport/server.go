@@ -25,11 +25,13 @@ type RequestHandler func(
 
 type request struct {
 	r      interface{}
+	peer   transport.TransportFlag // compressions accepted by client
 	quitch chan bool
 }
 
-func newRequest(r interface{}) (req request) {
+func newRequest(r interface{}, peer transport.TransportFlag) (req request) {
 	req.r = r
+	req.peer = peer
 	req.quitch = make(chan bool)
 	return
 }
@@ -46,12 +48,21 @@ type Server struct {
 	readDeadline   time.Duration
 	writeDeadline  time.Duration
 	streamChanSize int
+	compression    byte
 	logPrefix      string
 	nConnections   int64
+	sentStats      transport.CompressionStats // responses
+	rcvdStats      transport.CompressionStats // requests
 }
 
+// ServerStats with payload bytes before compression (raw) and on the
+// wire (compressed), for responses sent and requests received.
 type ServerStats struct {
-	Connections int64
+	Connections         int64
+	SentRawBytes        int64
+	SentCompressedBytes int64
+	RcvdRawBytes        int64
+	RcvdCompressedBytes int64
 }
 
 // NewServer creates a new queryport daemon.
@@ -69,7 +80,13 @@ func NewServer(
 		logPrefix:      fmt.Sprintf("[Queryport %q]", laddr),
 		nConnections:   0,
 	}
-	if s.lis, err = net.Listen("tcp", laddr); err != nil {
+	name := config["compression"].String()
+	if s.compression, err = transport.ParseCompression(name); err != nil {
+		fmsg := "%v compression %q: %v, responding uncompressed\n"
+		logging.Errorf(fmsg, s.logPrefix, name, err)
+		err = nil
+	}
+	if s.lis, err = c.TransportListen(laddr); err != nil {
 		logging.Errorf("%v failed starting %v !!\n", s.logPrefix, err)
 		return nil, err
 	}
@@ -81,7 +98,11 @@ func NewServer(
 
 func (s *Server) Statistics() ServerStats {
 	return ServerStats{
-		Connections: atomic.LoadInt64(&s.nConnections),
+		Connections:         atomic.LoadInt64(&s.nConnections),
+		SentRawBytes:        s.sentStats.RawBytes(),
+		SentCompressedBytes: s.sentStats.CompressedBytes(),
+		RcvdRawBytes:        s.rcvdStats.RawBytes(),
+		RcvdCompressedBytes: s.rcvdStats.CompressedBytes(),
 	}
 }
 
@@ -151,7 +172,8 @@ func (s *Server) handleConnection(conn net.Conn) {
 	go s.doReceive(conn, rcvch)
 
 	for req := range rcvch {
-		s.callb(req.r, conn, req.quitch) // blocking call
+		tconn := transport.NewConn(conn, s.compression, req.peer, &s.sentStats)
+		s.callb(req.r, tconn, req.quitch) // blocking call
 		transport.SendResponseEnd(conn)
 	}
 }
@@ -165,6 +187,7 @@ func (s *Server) doReceive(conn net.Conn, rcvch chan<- request) {
 	flags := transport.TransportFlag(0).SetProtobuf()
 	rpkt := transport.NewTransportPacket(s.maxPayload, flags)
 	rpkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
+	rpkt.SetStats(nil, &s.rcvdStats)
 
 	logging.Infof("%v connection %q doReceive() ...\n", s.logPrefix, raddr)
 
@@ -193,7 +216,7 @@ loop:
 			logging.Debugf(format, s.logPrefix, raddr)
 			close(currRequest.quitch)
 		} else {
-			currRequest = newRequest(reqMsg)
+			currRequest = newRequest(reqMsg, rpkt.Peer())
 			rcvch <- currRequest
 		}
 	}
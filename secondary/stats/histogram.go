@@ -9,6 +9,7 @@ import (
 type Histogram struct {
 	buckets    []int64
 	vals       []int64
+	sum        *int64
 	humanizeFn func(int64) string
 }
 
@@ -19,6 +20,7 @@ func (h *Histogram) Init(buckets []int64, humanizeFn func(int64) string) {
 	h.buckets[0] = math.MinInt64
 	h.buckets[l] = math.MaxInt64
 	h.vals = make([]int64, l)
+	h.sum = new(int64)
 
 	if humanizeFn == nil {
 		humanizeFn = func(v int64) string { return fmt.Sprint(v) }
@@ -30,6 +32,7 @@ func (h *Histogram) Init(buckets []int64, humanizeFn func(int64) string) {
 func (h *Histogram) Add(val int64) {
 	i := h.findBucket(val)
 	atomic.AddInt64(&h.vals[i], 1)
+	atomic.AddInt64(h.sum, val)
 }
 
 func (h *Histogram) findBucket(val int64) int {
@@ -46,6 +49,31 @@ func (h *Histogram) findBucket(val int64) int {
 	return 0
 }
 
+// Percentile returns the upper bound of the bucket holding the p-th
+// percentile of values added, math.MaxInt64 if it falls beyond the last
+// bucket and zero if the histogram is empty.
+func (h *Histogram) Percentile(p float64) int64 {
+	var total int64
+	for i := range h.vals {
+		total += atomic.LoadInt64(&h.vals[i])
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(p / 100 * float64(total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var count int64
+	for i := range h.vals {
+		if count += atomic.LoadInt64(&h.vals[i]); count >= rank {
+			return h.buckets[i+1]
+		}
+	}
+	return math.MaxInt64
+}
+
 func (h Histogram) String() string {
 	s := "\""
 	l := len(h.vals)
@@ -0,0 +1,31 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHistogramPercentile(t *testing.T) {
+	var h Histogram
+	h.Init([]int64{0, 10, 100, 1000}, nil)
+	if v := h.Percentile(50); v != 0 {
+		t.Fatalf("expected 0 for empty histogram, got %v", v)
+	}
+
+	for i := 0; i < 90; i++ {
+		h.Add(5)
+	}
+	for i := 0; i < 9; i++ {
+		h.Add(50)
+	}
+	h.Add(5000)
+
+	expected := map[float64]int64{
+		0: 10, 50: 10, 90: 10, 95: 100, 99: 100, 100: math.MaxInt64,
+	}
+	for p, bound := range expected {
+		if v := h.Percentile(p); v != bound {
+			t.Errorf("p%v: expected %v, got %v", p, bound, v)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package stats
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Metric types in prometheus text exposition format.
+const (
+	PromGauge     = "gauge"
+	PromCounter   = "counter"
+	PromHistogram = "histogram"
+	PromSummary   = "summary"
+)
+
+// PromContentType of the text exposition format.
+const PromContentType = "text/plain; version=0.0.4"
+
+// PromWriter accumulates samples and writes them in prometheus text
+// exposition format. Samples of a metric family can be added in any order,
+// they are grouped under a single TYPE line when written. Labels are given
+// as name, value pairs.
+type PromWriter struct {
+	families map[string]*promFamily
+}
+
+type promFamily struct {
+	name, help, typ string
+	samples         bytes.Buffer
+}
+
+func NewPromWriter() *PromWriter {
+	return &PromWriter{families: make(map[string]*promFamily)}
+}
+
+// Help sets the HELP text of metric family `name`.
+func (p *PromWriter) Help(name, help string) {
+	p.family(name, "").help = help
+}
+
+func (p *PromWriter) Gauge(name string, val float64, labels ...string) {
+	f := p.family(name, PromGauge)
+	f.sample(f.name, "", val, labels)
+}
+
+func (p *PromWriter) Counter(name string, val float64, labels ...string) {
+	f := p.family(name, PromCounter)
+	f.sample(f.name, "", val, labels)
+}
+
+// Histogram adds cumulative buckets, count and sum of `h`. Bucket
+// boundaries and sum are multiplied by `scale`, for instance 1e-9 to
+// export nanosecond latencies in seconds.
+func (p *PromWriter) Histogram(
+	name string, h *Histogram, scale float64, labels ...string) {
+
+	f := p.family(name, PromHistogram)
+	var count int64
+	for i := range h.vals {
+		count += atomic.LoadInt64(&h.vals[i])
+		le := math.Inf(1)
+		if upper := h.buckets[i+1]; upper != math.MaxInt64 {
+			le = float64(upper) * scale
+		}
+		f.sample(f.name+"_bucket", formatPromValue(le), float64(count), labels)
+	}
+	if len(h.vals) == 0 { // not initialized
+		f.sample(f.name+"_bucket", "+Inf", 0, labels)
+	}
+	var sum int64
+	if h.sum != nil {
+		sum = atomic.LoadInt64(h.sum)
+	}
+	f.sample(f.name+"_sum", "", float64(sum)*scale, labels)
+	f.sample(f.name+"_count", "", float64(count), labels)
+}
+
+// Timing adds count and sum of `t` as a summary without quantiles,
+// durations are exported in seconds.
+func (p *PromWriter) Timing(name string, t *TimingStat, labels ...string) {
+	if t.Count.val == nil {
+		return
+	}
+	f := p.family(name, PromSummary)
+	sum := float64(t.Sum.Value()) / 1e9
+	f.sample(f.name+"_sum", "", sum, labels)
+	f.sample(f.name+"_count", "", float64(t.Count.Value()), labels)
+}
+
+// WriteTo writes all metric families sorted by name.
+func (p *PromWriter) WriteTo(w io.Writer) (int64, error) {
+	names := make([]string, 0, len(p.families))
+	for name := range p.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		f := p.families[name]
+		if f.samples.Len() == 0 {
+			continue
+		}
+		if f.help != "" {
+			buf.WriteString("# HELP " + name + " " + escapePromHelp(f.help) + "\n")
+		}
+		buf.WriteString("# TYPE " + name + " " + f.typ + "\n")
+		buf.Write(f.samples.Bytes())
+	}
+	return buf.WriteTo(w)
+}
+
+func (p *PromWriter) family(name, typ string) *promFamily {
+	name = PromName(name)
+	f, ok := p.families[name]
+	if !ok {
+		f = &promFamily{name: name, typ: PromGauge}
+		p.families[name] = f
+	}
+	if typ != "" {
+		f.typ = typ
+	}
+	return f
+}
+
+func (f *promFamily) sample(name, le string, val float64, labels []string) {
+	f.samples.WriteString(name)
+	if len(labels) > 1 || le != "" {
+		f.samples.WriteByte('{')
+		sep := ""
+		for i := 0; i+1 < len(labels); i += 2 {
+			f.samples.WriteString(sep + PromName(labels[i]) + "=\"")
+			f.samples.WriteString(escapePromLabel(labels[i+1]) + "\"")
+			sep = ","
+		}
+		if le != "" {
+			f.samples.WriteString(sep + "le=\"" + le + "\"")
+		}
+		f.samples.WriteByte('}')
+	}
+	f.samples.WriteString(" " + formatPromValue(val) + "\n")
+}
+
+// PromName replaces characters not allowed in metric and label names
+// with underscore.
+func PromName(name string) string {
+	valid := func(i int, r rune) bool {
+		return r == '_' || r == ':' ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(i > 0 && r >= '0' && r <= '9')
+	}
+	buf := make([]rune, 0, len(name))
+	for i, r := range name {
+		if !valid(i, r) {
+			r = '_'
+		}
+		buf = append(buf, r)
+	}
+	return string(buf)
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var promHelpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapePromLabel(s string) string {
+	return promLabelEscaper.Replace(s)
+}
+
+func escapePromHelp(s string) string {
+	return promHelpEscaper.Replace(s)
+}
+
+func formatPromValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
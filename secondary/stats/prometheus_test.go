@@ -0,0 +1,52 @@
+package stats
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPromWriter(t *testing.T) {
+	var h Histogram
+	h.Init([]int64{0, 10, 100, 1000}, nil)
+	for _, v := range []int64{5, 10, 50, 500, 5000} {
+		h.Add(v)
+	}
+	var ts TimingStat
+	ts.Init()
+	ts.Put(time.Second)
+	ts.Put(500 * time.Millisecond)
+
+	p := NewPromWriter()
+	p.Gauge("index_items_count", 10, "bucket", "default", "index", "idx1")
+	p.Counter("num_requests", 3)
+	p.Help("index_items_count", "Number of items")
+	p.Gauge("index_items_count", 20, "bucket", "b\"2", "index", "idx\\2")
+	p.Histogram("latency", &h, 1, "op", "scan")
+	p.Timing("commit-time", &ts)
+
+	var buf bytes.Buffer
+	if _, err := p.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# TYPE commit_time summary
+commit_time_sum 1.5
+commit_time_count 2
+# HELP index_items_count Number of items
+# TYPE index_items_count gauge
+index_items_count{bucket="default",index="idx1"} 10
+index_items_count{bucket="b\"2",index="idx\\2"} 20
+# TYPE latency histogram
+latency_bucket{op="scan",le="0"} 0
+latency_bucket{op="scan",le="10"} 2
+latency_bucket{op="scan",le="100"} 3
+latency_bucket{op="scan",le="+Inf"} 5
+latency_sum{op="scan"} 5565
+latency_count{op="scan"} 5
+# TYPE num_requests counter
+num_requests 3
+`
+	if buf.String() != expected {
+		t.Errorf("Expected\n%v\nreceived\n%v", expected, buf.String())
+	}
+}
@@ -0,0 +1,416 @@
+package fakekv
+
+import (
+	"encoding/binary"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/dcp/transport"
+)
+
+// Snapshot types sent in DCP_SNAPSHOT markers.
+const (
+	SnapshotMemory = uint32(0x01)
+	SnapshotDisk   = uint32(0x02)
+)
+
+// Flags sent in DCP_STREAMEND.
+const (
+	StreamEndOK           = uint32(0x00)
+	StreamEndClosed       = uint32(0x01)
+	StreamEndStateChanged = uint32(0x02)
+	StreamEndDisconnected = uint32(0x03)
+	StreamEndTooSlow      = uint32(0x04)
+)
+
+// Bucket is a set of vbuckets holding documents in memory. Every
+// mutation is assigned the next seqno of its vbucket and is pushed to
+// the DCP streams open on that vbucket.
+type Bucket struct {
+	name string
+	uuid string
+	vbs  []*vbucket
+
+	mu      sync.Mutex
+	scripts map[*Script]bool
+	stalled map[uint16]bool
+	pending []*streamRequest // stream requests held back by stalled vbs
+}
+
+type vbucket struct {
+	mu      sync.Mutex
+	vbno    uint16
+	seqno   uint64
+	flog    [][2]uint64 // {vbuuid, seqno}, latest first
+	docs    map[string]*document
+	streams map[*stream]bool
+}
+
+type document struct {
+	key      []byte
+	value    []byte
+	flags    uint32
+	expiry   uint32
+	cas      uint64
+	seqno    uint64
+	revSeqno uint64
+	deleted  bool
+}
+
+type stream struct {
+	c      *conn
+	vbno   uint16
+	opaque uint32
+	end    uint64
+}
+
+type streamRequest struct {
+	c   *conn
+	req *transport.MCRequest
+}
+
+func newBucket(name, uuid string, numVbuckets int) *Bucket {
+	b := &Bucket{
+		name:    name,
+		uuid:    uuid,
+		vbs:     make([]*vbucket, numVbuckets),
+		scripts: make(map[*Script]bool),
+		stalled: make(map[uint16]bool),
+	}
+	for i := range b.vbs {
+		b.vbs[i] = &vbucket{
+			vbno:    uint16(i),
+			flog:    [][2]uint64{{newVbuuid(), 0}},
+			docs:    make(map[string]*document),
+			streams: make(map[*stream]bool),
+		}
+	}
+	return b
+}
+
+// Name of the bucket.
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+// UUID of the bucket.
+func (b *Bucket) UUID() string {
+	return b.uuid
+}
+
+// NumVbuckets hosted by the bucket.
+func (b *Bucket) NumVbuckets() int {
+	return len(b.vbs)
+}
+
+// Set stores value for key in vbucket vb and returns its seqno.
+func (b *Bucket) Set(vb uint16, key string, flags, expiry uint32, value []byte) uint64 {
+	v := b.vbs[vb]
+	v.mu.Lock()
+	seqno := v.mutate([]byte(key), value, flags, expiry, false).seqno
+	v.mu.Unlock()
+	b.mutated(vb, seqno)
+	return seqno
+}
+
+// Delete removes key from vbucket vb and returns the seqno of deletion.
+// Returns false if key does not exist.
+func (b *Bucket) Delete(vb uint16, key string) (uint64, bool) {
+	v := b.vbs[vb]
+	v.mu.Lock()
+	if doc, ok := v.docs[key]; !ok || doc.deleted {
+		v.mu.Unlock()
+		return 0, false
+	}
+	seqno := v.mutate([]byte(key), nil, 0, 0, true).seqno
+	v.mu.Unlock()
+	b.mutated(vb, seqno)
+	return seqno, true
+}
+
+// Seqnos returns the high seqno of every vbucket.
+func (b *Bucket) Seqnos() []uint64 {
+	seqnos := make([]uint64, len(b.vbs))
+	for i, v := range b.vbs {
+		v.mu.Lock()
+		seqnos[i] = v.seqno
+		v.mu.Unlock()
+	}
+	return seqnos
+}
+
+// FailoverLog returns the failover log of vbucket vb, latest first.
+func (b *Bucket) FailoverLog(vb uint16) [][2]uint64 {
+	v := b.vbs[vb]
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([][2]uint64(nil), v.flog...)
+}
+
+// Failover switches vbucket vb to a new branch starting at its current
+// seqno, as a replica taking over would. Open streams end with
+// StreamEndStateChanged.
+func (b *Bucket) Failover(vb uint16) {
+	v := b.vbs[vb]
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.flog = append([][2]uint64{{newVbuuid(), v.seqno}}, v.flog...)
+	v.endStreams(StreamEndStateChanged)
+}
+
+// Rollback discards the mutations of vbucket vb after seqno and starts a
+// new branch from there, as a replica taking over without having
+// received them would. Documents last mutated after seqno are lost. Open
+// streams end with StreamEndStateChanged, and requests to resume from
+// the discarded history get a rollback response.
+func (b *Bucket) Rollback(vb uint16, seqno uint64) {
+	v := b.vbs[vb]
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if seqno > v.seqno {
+		seqno = v.seqno
+	}
+	for key, doc := range v.docs {
+		if doc.seqno > seqno {
+			delete(v.docs, key)
+		}
+	}
+	v.seqno = seqno
+
+	flog := [][2]uint64{{newVbuuid(), seqno}}
+	for _, entry := range v.flog {
+		if entry[1] <= seqno {
+			flog = append(flog, entry)
+		}
+	}
+	v.flog = flog
+	v.endStreams(StreamEndStateChanged)
+}
+
+// EndStreams ends every stream open on vbucket vb with flags.
+func (b *Bucket) EndStreams(vb uint16, flags uint32) {
+	v := b.vbs[vb]
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.endStreams(flags)
+}
+
+// SendSnapshot sends a snapshot marker to every stream open on vbucket
+// vb, irrespective of the mutations that follow.
+func (b *Bucket) SendSnapshot(vb uint16, start, end uint64, typ uint32) {
+	v := b.vbs[vb]
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for s := range v.streams {
+		s.c.send(snapshotPacket(s, start, end, typ))
+	}
+}
+
+// DropConnections closes every connection streaming from the bucket,
+// without sending DCP_STREAMEND.
+func (b *Bucket) DropConnections() {
+	conns := make(map[*conn]bool)
+	for _, v := range b.vbs {
+		v.mu.Lock()
+		for s := range v.streams {
+			conns[s.c] = true
+		}
+		v.mu.Unlock()
+	}
+	for c := range conns {
+		c.rwc.Close()
+	}
+}
+
+// StallStreamRequests holds back stream requests for vbuckets vbs, they
+// get no response until ResumeStreamRequests is called.
+func (b *Bucket) StallStreamRequests(vbs ...uint16) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, vb := range vbs {
+		b.stalled[vb] = true
+	}
+}
+
+// ResumeStreamRequests handles the stream requests held back for
+// vbuckets vbs, in the order they were received.
+func (b *Bucket) ResumeStreamRequests(vbs ...uint16) {
+	b.mu.Lock()
+	for _, vb := range vbs {
+		delete(b.stalled, vb)
+	}
+	resume, pending := make([]*streamRequest, 0), make([]*streamRequest, 0)
+	for _, sr := range b.pending {
+		if b.stalled[sr.req.VBucket] {
+			pending = append(pending, sr)
+		} else {
+			resume = append(resume, sr)
+		}
+	}
+	b.pending = pending
+	b.mu.Unlock()
+
+	for _, sr := range resume {
+		sr.c.handleStreamRequest(b.vbs[sr.req.VBucket], sr.req)
+	}
+}
+
+// stall holds back req if its vbucket is stalled.
+func (b *Bucket) stall(c *conn, req *transport.MCRequest) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.stalled[req.VBucket] {
+		return false
+	}
+	b.pending = append(b.pending, &streamRequest{c: c, req: req})
+	return true
+}
+
+func (v *vbucket) mutate(
+	key, value []byte, flags, expiry uint32, deleted bool) *document {
+
+	v.seqno++
+	doc, ok := v.docs[string(key)]
+	if !ok {
+		doc = &document{key: key}
+		v.docs[string(key)] = doc
+	}
+	doc.value, doc.flags, doc.expiry, doc.deleted = value, flags, expiry, deleted
+	doc.cas = uint64(time.Now().UnixNano())
+	doc.seqno = v.seqno
+	doc.revSeqno++
+
+	for s := range v.streams {
+		s.c.send(snapshotPacket(s, v.seqno, v.seqno, SnapshotMemory))
+		s.c.send(documentPacket(s, doc))
+		if v.seqno >= s.end {
+			v.endStream(s, StreamEndOK)
+		}
+	}
+	return doc
+}
+
+// rollbackSeqno returns the seqno to rollback to, if a stream request
+// from seqno `start` on the branch identified by `vbuuid` diverges from
+// the history of vbucket.
+func (v *vbucket) rollbackSeqno(vbuuid, start uint64) (uint64, bool) {
+	if start == 0 {
+		return 0, false
+	}
+	for i, entry := range v.flog {
+		if entry[0] != vbuuid {
+			continue
+		}
+		upto := v.seqno
+		if i > 0 {
+			upto = v.flog[i-1][1]
+		}
+		if start > upto {
+			return upto, true
+		}
+		return 0, false
+	}
+	return 0, true
+}
+
+// backfill sends the documents mutated after `start` as a single disk
+// snapshot and returns the last seqno sent.
+func (v *vbucket) backfill(s *stream, start uint64) uint64 {
+	docs := make([]*document, 0)
+	for _, doc := range v.docs {
+		if doc.seqno > start && doc.seqno <= s.end {
+			docs = append(docs, doc)
+		}
+	}
+	if len(docs) == 0 {
+		return start
+	}
+	sort.Sort(bySeqno(docs))
+
+	last := docs[len(docs)-1].seqno
+	s.c.send(snapshotPacket(s, start, last, SnapshotDisk))
+	for _, doc := range docs {
+		s.c.send(documentPacket(s, doc))
+	}
+	return last
+}
+
+func (v *vbucket) endStreams(flags uint32) {
+	for s := range v.streams {
+		v.endStream(s, flags)
+	}
+}
+
+// endStream sends DCP_STREAMEND with flags and forgets the stream, vb
+// lock should be held by the caller.
+func (v *vbucket) endStream(s *stream, flags uint32) {
+	extras := make([]byte, 4)
+	binary.BigEndian.PutUint32(extras, flags)
+	s.c.send(&transport.MCRequest{
+		Opcode:  transport.DCP_STREAMEND,
+		VBucket: s.vbno,
+		Opaque:  s.opaque,
+		Extras:  extras,
+	})
+	v.removeStream(s)
+}
+
+func (v *vbucket) removeStream(s *stream) {
+	delete(v.streams, s)
+	s.c.mu.Lock()
+	if s.c.streams[s.vbno] == s {
+		delete(s.c.streams, s.vbno)
+	}
+	s.c.mu.Unlock()
+}
+
+func (v *vbucket) failoverLogBody() []byte {
+	body := make([]byte, 16*len(v.flog))
+	for i, entry := range v.flog {
+		binary.BigEndian.PutUint64(body[i*16:], entry[0])
+		binary.BigEndian.PutUint64(body[i*16+8:], entry[1])
+	}
+	return body
+}
+
+func snapshotPacket(s *stream, start, end uint64, typ uint32) *transport.MCRequest {
+	extras := make([]byte, 20)
+	binary.BigEndian.PutUint64(extras[0:8], start)
+	binary.BigEndian.PutUint64(extras[8:16], end)
+	binary.BigEndian.PutUint32(extras[16:20], typ)
+	return &transport.MCRequest{
+		Opcode:  transport.DCP_SNAPSHOT,
+		VBucket: s.vbno,
+		Opaque:  s.opaque,
+		Extras:  extras,
+	}
+}
+
+func documentPacket(s *stream, doc *document) *transport.MCRequest {
+	pkt := &transport.MCRequest{
+		VBucket: s.vbno,
+		Opaque:  s.opaque,
+		Cas:     doc.cas,
+		Key:     doc.key,
+	}
+	if doc.deleted {
+		pkt.Opcode = transport.DCP_DELETION
+		pkt.Extras = make([]byte, 18)
+	} else {
+		pkt.Opcode = transport.DCP_MUTATION
+		pkt.Extras = make([]byte, 31)
+		binary.BigEndian.PutUint32(pkt.Extras[16:20], doc.flags)
+		binary.BigEndian.PutUint32(pkt.Extras[20:24], doc.expiry)
+		pkt.Body = doc.value
+	}
+	binary.BigEndian.PutUint64(pkt.Extras[0:8], doc.seqno)
+	binary.BigEndian.PutUint64(pkt.Extras[8:16], doc.revSeqno)
+	return pkt
+}
+
+type bySeqno []*document
+
+func (docs bySeqno) Len() int           { return len(docs) }
+func (docs bySeqno) Less(i, j int) bool { return docs[i].seqno < docs[j].seqno }
+func (docs bySeqno) Swap(i, j int)      { docs[i], docs[j] = docs[j], docs[i] }
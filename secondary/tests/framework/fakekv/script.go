@@ -0,0 +1,241 @@
+package fakekv
+
+import (
+	"sync"
+	"time"
+)
+
+// Fault is a disruption of the DCP producer injected by a Script.
+type Fault func(b *Bucket)
+
+// Failover faults switch vbuckets vbs to a new vbuuid, see
+// Bucket.Failover.
+func Failover(vbs ...uint16) Fault {
+	return func(b *Bucket) {
+		for _, vb := range vbs {
+			b.Failover(vb)
+		}
+	}
+}
+
+// Rollback faults discard mutations of vbucket vb after seqno, see
+// Bucket.Rollback.
+func Rollback(vb uint16, seqno uint64) Fault {
+	return func(b *Bucket) { b.Rollback(vb, seqno) }
+}
+
+// EndStreams faults end streams open on vbuckets vbs with flags.
+func EndStreams(flags uint32, vbs ...uint16) Fault {
+	return func(b *Bucket) {
+		for _, vb := range vbs {
+			b.EndStreams(vb, flags)
+		}
+	}
+}
+
+// Snapshot faults send a snapshot marker on streams of vbucket vb.
+func Snapshot(vb uint16, start, end uint64, typ uint32) Fault {
+	return func(b *Bucket) { b.SendSnapshot(vb, start, end, typ) }
+}
+
+// DropConnections faults close connections streaming from the bucket.
+func DropConnections() Fault {
+	return func(b *Bucket) { b.DropConnections() }
+}
+
+// StallStreamRequests faults hold back stream requests for vbuckets vbs,
+// which shows up downstream as a missing DCP_STREAMREQ response and
+// hence a missing StreamBegin.
+func StallStreamRequests(vbs ...uint16) Fault {
+	return func(b *Bucket) { b.StallStreamRequests(vbs...) }
+}
+
+// ResumeStreamRequests faults handle the held back stream requests for
+// vbuckets vbs.
+func ResumeStreamRequests(vbs ...uint16) Fault {
+	return func(b *Bucket) { b.ResumeStreamRequests(vbs...) }
+}
+
+const (
+	triggerAfter = iota + 1
+	triggerSeqno
+	triggerStreamRequest
+)
+
+// Trigger decides when a step of a Script fires.
+type Trigger struct {
+	kind  int
+	after time.Duration
+	vb    uint16
+	seqno uint64
+	count int
+}
+
+// After triggers d after the script is started.
+func After(d time.Duration) Trigger {
+	return Trigger{kind: triggerAfter, after: d}
+}
+
+// AtSeqno triggers once vbucket vb reaches seqno. Faults are injected
+// after the mutation is pushed to streams and before the client setting
+// it gets a response.
+func AtSeqno(vb uint16, seqno uint64) Trigger {
+	return Trigger{kind: triggerSeqno, vb: vb, seqno: seqno}
+}
+
+// OnStreamRequest triggers on the n-th stream request for vbucket vb,
+// counting from 1, since the script is started. Faults are injected
+// before the request is handled.
+func OnStreamRequest(vb uint16, n int) Trigger {
+	return Trigger{kind: triggerStreamRequest, vb: vb, count: n}
+}
+
+// Script is a schedule of faults injected into a bucket, to drive the
+// recovery paths of DCP consumers deterministically. Each step fires
+// once, faults of a step are injected in order.
+type Script struct {
+	b *Bucket
+
+	mu       sync.Mutex
+	steps    []*step
+	requests map[uint16]int // stream requests received per vbucket
+	pending  int            // steps yet to fire
+	timers   []*time.Timer
+	done     chan struct{}
+}
+
+type step struct {
+	trigger Trigger
+	faults  []Fault
+	fired   bool
+}
+
+// NewScript returns an empty script for bucket b.
+func NewScript(b *Bucket) *Script {
+	return &Script{
+		b:        b,
+		requests: make(map[uint16]int),
+		done:     make(chan struct{}),
+	}
+}
+
+// On adds a step injecting faults when trigger fires, steps should be
+// added before the script is started.
+func (sc *Script) On(trigger Trigger, faults ...Fault) *Script {
+	sc.steps = append(sc.steps, &step{trigger: trigger, faults: faults})
+	sc.pending++
+	return sc
+}
+
+// Start watching the bucket for triggers.
+func (sc *Script) Start() *Script {
+	sc.b.mu.Lock()
+	sc.b.scripts[sc] = true
+	sc.b.mu.Unlock()
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.pending == 0 {
+		close(sc.done)
+	}
+	for _, st := range sc.steps {
+		if st.trigger.kind == triggerAfter {
+			st := st
+			timer := time.AfterFunc(st.trigger.after, func() {
+				sc.fire(func(s *step) bool { return s == st })
+			})
+			sc.timers = append(sc.timers, timer)
+		}
+	}
+	return sc
+}
+
+// Stop the script, steps yet to fire are dropped.
+func (sc *Script) Stop() {
+	sc.b.mu.Lock()
+	delete(sc.b.scripts, sc)
+	sc.b.mu.Unlock()
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, timer := range sc.timers {
+		timer.Stop()
+	}
+}
+
+// Done is closed once all steps are fired and their faults injected.
+func (sc *Script) Done() <-chan struct{} {
+	return sc.done
+}
+
+// fire the steps selected by match and inject their faults.
+func (sc *Script) fire(match func(s *step) bool) {
+	sc.mu.Lock()
+	fired := make([]*step, 0)
+	for _, st := range sc.steps {
+		if !st.fired && match(st) {
+			st.fired = true
+			fired = append(fired, st)
+		}
+	}
+	sc.mu.Unlock()
+
+	for _, st := range fired {
+		for _, fault := range st.faults {
+			fault(sc.b)
+		}
+	}
+
+	if len(fired) > 0 {
+		sc.mu.Lock()
+		sc.pending -= len(fired)
+		if sc.pending == 0 {
+			close(sc.done)
+		}
+		sc.mu.Unlock()
+	}
+}
+
+func (sc *Script) mutated(vb uint16, seqno uint64) {
+	sc.fire(func(s *step) bool {
+		t := s.trigger
+		return t.kind == triggerSeqno && t.vb == vb && seqno >= t.seqno
+	})
+}
+
+func (sc *Script) streamRequested(vb uint16) {
+	sc.mu.Lock()
+	sc.requests[vb]++
+	n := sc.requests[vb]
+	sc.mu.Unlock()
+
+	sc.fire(func(s *step) bool {
+		t := s.trigger
+		return t.kind == triggerStreamRequest && t.vb == vb && t.count == n
+	})
+}
+
+func (b *Bucket) activeScripts() []*Script {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	scripts := make([]*Script, 0, len(b.scripts))
+	for sc := range b.scripts {
+		scripts = append(scripts, sc)
+	}
+	return scripts
+}
+
+// mutated fires script steps triggered by vbucket vb reaching seqno.
+func (b *Bucket) mutated(vb uint16, seqno uint64) {
+	for _, sc := range b.activeScripts() {
+		sc.mutated(vb, seqno)
+	}
+}
+
+// streamRequested fires script steps triggered by a stream request for
+// vbucket vb.
+func (b *Bucket) streamRequested(vb uint16) {
+	for _, sc := range b.activeScripts() {
+		sc.streamRequested(vb)
+	}
+}
@@ -0,0 +1,177 @@
+package fakekv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/dcp/transport"
+	mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+)
+
+func newTestServer(t *testing.T) (*Server, *Bucket) {
+	s, err := NewServer("127.0.0.1:0", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, s.CreateBucket("default")
+}
+
+func requestStream(t *testing.T, feed *mc.DcpFeed, outch chan *mc.DcpEvent,
+	vb uint16, vbuuid, start uint64) *mc.DcpEvent {
+
+	err := feed.DcpRequestStream(vb, 10, 0, vbuuid, start, 0xFFFFFFFFFFFFFFFF, start, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return expectEvent(t, outch, transport.DCP_STREAMREQ)
+}
+
+func waitScript(t *testing.T, sc *Script) {
+	select {
+	case <-sc.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for script")
+	}
+}
+
+func TestScriptFailover(t *testing.T) {
+	s, b := newTestServer(t)
+	defer s.Close()
+	sc := NewScript(b).On(AtSeqno(1, 2), Failover(1)).Start()
+	defer sc.Stop()
+
+	feed, outch := startFeed(t, s, "default")
+	defer feed.Close()
+
+	vbuuid := b.FailoverLog(1)[0][0]
+	if e := requestStream(t, feed, outch, 1, vbuuid, 0); e.Status != transport.SUCCESS {
+		t.Fatalf("unexpected stream response %v", e)
+	}
+	b.Set(1, "doc1", 0, 0, []byte(`{}`))
+	b.Set(1, "doc2", 0, 0, []byte(`{}`))
+	waitScript(t, sc)
+	for i := 0; i < 2; i++ {
+		expectEvent(t, outch, transport.DCP_SNAPSHOT)
+		expectEvent(t, outch, transport.DCP_MUTATION)
+	}
+	expectEvent(t, outch, transport.DCP_STREAMEND)
+
+	// resuming on the old branch is possible up to the failover seqno.
+	flog := b.FailoverLog(1)
+	if len(flog) != 2 || flog[1][0] != vbuuid || flog[0][1] != 2 {
+		t.Fatalf("unexpected failover log %v", flog)
+	}
+	e := requestStream(t, feed, outch, 1, vbuuid, 2)
+	if e.Status != transport.SUCCESS || len(*e.FailoverLog) != 2 {
+		t.Fatalf("unexpected stream response %v", e)
+	}
+}
+
+func TestScriptRollback(t *testing.T) {
+	s, b := newTestServer(t)
+	defer s.Close()
+	sc := NewScript(b).On(AtSeqno(2, 3), Rollback(2, 1)).Start()
+	defer sc.Stop()
+
+	vbuuid := b.FailoverLog(2)[0][0]
+	for _, key := range []string{"doc1", "doc2", "doc3"} {
+		b.Set(2, key, 0, 0, []byte(`{}`))
+	}
+	waitScript(t, sc)
+	if seqno := b.Seqnos()[2]; seqno != 1 {
+		t.Fatalf("expected seqno 1, got %v", seqno)
+	}
+
+	feed, outch := startFeed(t, s, "default")
+	defer feed.Close()
+
+	e := requestStream(t, feed, outch, 2, vbuuid, 3)
+	if e.Status != transport.ROLLBACK || e.Seqno != 1 {
+		t.Fatalf("unexpected stream response %v", e)
+	}
+	e = requestStream(t, feed, outch, 2, vbuuid, 1)
+	if e.Status != transport.SUCCESS {
+		t.Fatalf("unexpected stream response %v", e)
+	}
+	if b.Set(2, "doc4", 0, 0, []byte(`{}`)) != 2 {
+		t.Fatalf("expected mutations to continue from rollback seqno")
+	}
+	expectEvent(t, outch, transport.DCP_SNAPSHOT)
+	if e = expectEvent(t, outch, transport.DCP_MUTATION); string(e.Key) != "doc4" {
+		t.Fatalf("unexpected mutation %v", e)
+	}
+}
+
+func TestScriptStreamEnd(t *testing.T) {
+	s, b := newTestServer(t)
+	defer s.Close()
+	sc := NewScript(b).
+		On(After(100*time.Millisecond),
+			Snapshot(3, 5, 10, SnapshotMemory),
+			EndStreams(StreamEndTooSlow, 3))
+
+	feed, outch := startFeed(t, s, "default")
+	defer feed.Close()
+
+	vbuuid := b.FailoverLog(3)[0][0]
+	if e := requestStream(t, feed, outch, 3, vbuuid, 0); e.Status != transport.SUCCESS {
+		t.Fatalf("unexpected stream response %v", e)
+	}
+	sc.Start()
+	defer sc.Stop()
+	waitScript(t, sc)
+
+	e := expectEvent(t, outch, transport.DCP_SNAPSHOT)
+	if e.SnapstartSeq != 5 || e.SnapendSeq != 10 {
+		t.Fatalf("unexpected snapshot %v", e)
+	}
+	expectEvent(t, outch, transport.DCP_STREAMEND)
+}
+
+func TestScriptStallAndDrop(t *testing.T) {
+	s, b := newTestServer(t)
+	defer s.Close()
+	sc := NewScript(b).
+		On(OnStreamRequest(0, 1), StallStreamRequests(0)).
+		On(OnStreamRequest(1, 1), ResumeStreamRequests(0)).
+		Start()
+	defer sc.Stop()
+
+	feed, outch := startFeed(t, s, "default")
+	defer feed.Close()
+
+	vbuuid := b.FailoverLog(0)[0][0]
+	err := feed.DcpRequestStream(0, 10, 0, vbuuid, 0, 0xFFFFFFFFFFFFFFFF, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-outch:
+		t.Fatalf("unexpected event for stalled request %v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// stream request for vb 1 resumes the one for vb 0.
+	vbuuid = b.FailoverLog(1)[0][0]
+	err = feed.DcpRequestStream(1, 10, 0, vbuuid, 0, 0xFFFFFFFFFFFFFFFF, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitScript(t, sc)
+	for _, vb := range []uint16{0, 1} {
+		e := expectEvent(t, outch, transport.DCP_STREAMREQ)
+		if e.VBucket != vb || e.Status != transport.SUCCESS {
+			t.Fatalf("unexpected stream response %v", e)
+		}
+	}
+
+	// dropped connection ends all streams of the feed.
+	b.DropConnections()
+	ended := make(map[uint16]bool)
+	for i := 0; i < 2; i++ {
+		ended[expectEvent(t, outch, transport.DCP_STREAMEND).VBucket] = true
+	}
+	if !ended[0] || !ended[1] {
+		t.Fatalf("expected streams to end, got %v", ended)
+	}
+}
@@ -0,0 +1,425 @@
+// Package fakekv implements an in-process stand-in for a KV node, serving
+// memcached binary protocol built on dcp/transport/server. It supports
+// the document operations used by bucket clients, STAT and GET_SEQNO
+// queries used by indexer, and DCP producer connections used by
+// projector.
+package fakekv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/dcp/transport"
+	"github.com/couchbase/indexing/secondary/dcp/transport/server"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// Server accepts memcached connections for a set of buckets, each
+// hosting all vbuckets.
+type Server struct {
+	lis  net.Listener
+	nvbs int
+
+	mu      sync.Mutex
+	buckets map[string]*Bucket
+	conns   map[*conn]bool
+	closed  bool
+}
+
+type conn struct {
+	s      *Server
+	rwc    net.Conn
+	wmu    sync.Mutex
+	bucket *Bucket
+
+	mu      sync.Mutex
+	streams map[uint16]*stream
+	closed  bool
+}
+
+var uuidRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+var uuidMu sync.Mutex
+
+func newVbuuid() uint64 {
+	uuidMu.Lock()
+	defer uuidMu.Unlock()
+	return uint64(uuidRand.Int63()) + 1
+}
+
+// NewServer starts listening on addr, like "127.0.0.1:0", for
+// connections to buckets with numVbuckets vbuckets.
+func NewServer(addr string, numVbuckets int) (*Server, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		lis:     lis,
+		nvbs:    numVbuckets,
+		buckets: make(map[string]*Bucket),
+		conns:   make(map[*conn]bool),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() string {
+	return s.lis.Addr().String()
+}
+
+// NumVbuckets hosted by every bucket.
+func (s *Server) NumVbuckets() int {
+	return s.nvbs
+}
+
+// CreateBucket creates an empty bucket, returns the existing bucket if
+// one with the same name was already created.
+func (s *Server) CreateBucket(name string) *Bucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.buckets[name]; ok {
+		return b
+	}
+	uuid := fmt.Sprintf("%016x", newVbuuid())
+	b := newBucket(name, uuid, s.nvbs)
+	s.buckets[name] = b
+	return b
+}
+
+// Bucket returns the bucket called name, nil if it does not exist.
+func (s *Server) Bucket(name string) *Bucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buckets[name]
+}
+
+// Buckets returns the names of all buckets in sort order.
+func (s *Server) Buckets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.buckets))
+	for name := range s.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close stops listening and drops all connections.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	s.lis.Close()
+	for _, c := range conns {
+		c.rwc.Close()
+	}
+}
+
+func (s *Server) run() {
+	for {
+		rwc, err := s.lis.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{s: s, rwc: rwc, streams: make(map[uint16]*stream)}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			rwc.Close()
+			return
+		}
+		s.conns[c] = true
+		s.mu.Unlock()
+
+		go c.run()
+	}
+}
+
+func (c *conn) run() {
+	defer c.close()
+	for {
+		req, err := memcached.ReadPacket(c.rwc)
+		if err != nil {
+			return
+		}
+		if res := c.handle(&req); res != nil {
+			res.Opcode, res.Opaque = req.Opcode, req.Opaque
+			c.send(res)
+		}
+	}
+}
+
+func (c *conn) close() {
+	c.rwc.Close()
+
+	c.s.mu.Lock()
+	delete(c.s.conns, c)
+	c.s.mu.Unlock()
+
+	c.mu.Lock()
+	c.closed = true
+	streams := make([]*stream, 0, len(c.streams))
+	for _, s := range c.streams {
+		streams = append(streams, s)
+	}
+	c.mu.Unlock()
+
+	for _, s := range streams {
+		v := c.bucket.vbs[s.vbno]
+		v.mu.Lock()
+		v.removeStream(s)
+		v.mu.Unlock()
+	}
+}
+
+// packet is either a request pushed by the server or a response.
+type packet interface {
+	Bytes() []byte
+}
+
+// send writes a packet to connection, write errors are detected by the
+// reader which closes the connection.
+func (c *conn) send(pkt packet) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.rwc.Write(pkt.Bytes())
+}
+
+func (c *conn) selectBucket(name string) bool {
+	if b := c.s.Bucket(name); b != nil {
+		c.bucket = b
+		return true
+	}
+	return false
+}
+
+// handle a request, returns nil if request needs no response or if the
+// response was already sent.
+func (c *conn) handle(req *transport.MCRequest) *transport.MCResponse {
+	switch req.Opcode {
+	case transport.SASL_LIST_MECHS:
+		return &transport.MCResponse{Body: []byte("PLAIN")}
+
+	case transport.SASL_AUTH:
+		// body is "\x00user\x00password", user may be a bucket name.
+		if parts := bytes.Split(req.Body, []byte{0}); len(parts) == 3 {
+			c.selectBucket(string(parts[1]))
+		}
+		return &transport.MCResponse{}
+
+	case transport.SELECT_BUCKET:
+		if !c.selectBucket(string(req.Key)) {
+			return &transport.MCResponse{Status: transport.KEY_ENOENT}
+		}
+		return &transport.MCResponse{}
+
+	case transport.NOOP:
+		return &transport.MCResponse{}
+
+	case transport.STAT:
+		c.handleStats(req)
+		return nil
+
+	case transport.DCP_NOOP, transport.DCP_BUFFERACK:
+		return nil
+
+	case transport.DCP_OPEN, transport.DCP_CONTROL:
+		return &transport.MCResponse{}
+	}
+
+	if c.bucket == nil {
+		return &transport.MCResponse{Status: transport.EINVAL}
+	}
+
+	switch req.Opcode {
+	case transport.DCP_GET_SEQNO:
+		seqnos := c.bucket.Seqnos()
+		body := make([]byte, 10*len(seqnos))
+		for vb, seqno := range seqnos {
+			binary.BigEndian.PutUint16(body[vb*10:], uint16(vb))
+			binary.BigEndian.PutUint64(body[vb*10+2:], seqno)
+		}
+		return &transport.MCResponse{Body: body}
+	}
+
+	if int(req.VBucket) >= len(c.bucket.vbs) {
+		return &transport.MCResponse{Status: transport.NOT_MY_VBUCKET}
+	}
+	v := c.bucket.vbs[req.VBucket]
+
+	switch req.Opcode {
+	case transport.GET, transport.SET, transport.ADD, transport.REPLACE,
+		transport.DELETE:
+		res, seqno := c.handleDocument(v, req)
+		if seqno > 0 {
+			c.bucket.mutated(req.VBucket, seqno)
+		}
+		return res
+
+	case transport.DCP_FAILOVERLOG:
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		return &transport.MCResponse{Body: v.failoverLogBody()}
+
+	case transport.DCP_STREAMREQ:
+		c.bucket.streamRequested(req.VBucket)
+		if !c.bucket.stall(c, req) {
+			c.handleStreamRequest(v, req)
+		}
+		return nil
+
+	case transport.DCP_CLOSESTREAM:
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		c.mu.Lock()
+		s, ok := c.streams[req.VBucket]
+		c.mu.Unlock()
+		if !ok {
+			return &transport.MCResponse{Status: transport.KEY_ENOENT}
+		}
+		v.removeStream(s)
+		return &transport.MCResponse{}
+	}
+
+	logging.Warnf("fakekv: unknown command %v", req.Opcode)
+	return &transport.MCResponse{Status: transport.UNKNOWN_COMMAND}
+}
+
+// handleDocument returns the response to a document operation, along
+// with the seqno of the resulting mutation if any.
+func (c *conn) handleDocument(
+	v *vbucket, req *transport.MCRequest) (*transport.MCResponse, uint64) {
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	doc, ok := v.docs[string(req.Key)]
+	exists := ok && !doc.deleted
+
+	switch req.Opcode {
+	case transport.GET:
+		if !exists {
+			return &transport.MCResponse{Status: transport.KEY_ENOENT}, 0
+		}
+		extras := make([]byte, 4)
+		binary.BigEndian.PutUint32(extras, doc.flags)
+		return &transport.MCResponse{Cas: doc.cas, Extras: extras, Body: doc.value}, 0
+
+	case transport.DELETE:
+		if !exists {
+			return &transport.MCResponse{Status: transport.KEY_ENOENT}, 0
+		}
+		doc = v.mutate(req.Key, nil, 0, 0, true)
+		return &transport.MCResponse{Cas: doc.cas}, doc.seqno
+	}
+
+	switch {
+	case req.Opcode == transport.ADD && exists:
+		return &transport.MCResponse{Status: transport.KEY_EEXISTS}, 0
+	case req.Opcode == transport.REPLACE && !exists:
+		return &transport.MCResponse{Status: transport.KEY_ENOENT}, 0
+	case req.Cas != 0 && (!exists || req.Cas != doc.cas):
+		return &transport.MCResponse{Status: transport.KEY_EEXISTS}, 0
+	}
+
+	var flags, expiry uint32
+	if len(req.Extras) >= 8 {
+		flags = binary.BigEndian.Uint32(req.Extras[0:4])
+		expiry = binary.BigEndian.Uint32(req.Extras[4:8])
+	}
+	doc = v.mutate(req.Key, req.Body, flags, expiry, false)
+	return &transport.MCResponse{Cas: doc.cas}, doc.seqno
+}
+
+func (c *conn) handleStreamRequest(v *vbucket, req *transport.MCRequest) {
+	res := &transport.MCResponse{Opcode: req.Opcode, Opaque: req.Opaque}
+	if len(req.Extras) < 48 {
+		res.Status = transport.EINVAL
+		c.send(res)
+		return
+	}
+	start := binary.BigEndian.Uint64(req.Extras[8:16])
+	end := binary.BigEndian.Uint64(req.Extras[16:24])
+	vbuuid := binary.BigEndian.Uint64(req.Extras[24:32])
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	c.mu.Lock()
+	_, active := c.streams[req.VBucket]
+	c.mu.Unlock()
+	if active {
+		res.Status = transport.KEY_EEXISTS
+		c.send(res)
+		return
+	}
+
+	if seqno, rollback := v.rollbackSeqno(vbuuid, start); rollback {
+		res.Status = transport.ROLLBACK
+		res.Body = make([]byte, 8)
+		binary.BigEndian.PutUint64(res.Body, seqno)
+		c.send(res)
+		return
+	}
+
+	res.Body = v.failoverLogBody()
+	c.send(res)
+
+	s := &stream{c: c, vbno: req.VBucket, opaque: req.Opaque, end: end}
+	if last := v.backfill(s, start); last >= end {
+		v.endStream(s, StreamEndOK)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed { // request was held back until after disconnect.
+		return
+	}
+	v.streams[s] = true
+	c.streams[req.VBucket] = s
+}
+
+// handleStats sends a response for every stat followed by a response
+// with empty key.
+func (c *conn) handleStats(req *transport.MCRequest) {
+	stats := make([][2]string, 0)
+	if string(req.Key) == "vbucket-details" && c.bucket != nil {
+		for vb, v := range c.bucket.vbs {
+			v.mu.Lock()
+			prefix := "vb_" + strconv.Itoa(vb)
+			stats = append(stats,
+				[2]string{prefix, "active"},
+				[2]string{prefix + ":high_seqno", strconv.FormatUint(v.seqno, 10)},
+				[2]string{prefix + ":uuid", strconv.FormatUint(v.flog[0][0], 10)})
+			v.mu.Unlock()
+		}
+	}
+	for _, stat := range stats {
+		c.send(&transport.MCResponse{
+			Opcode: req.Opcode,
+			Opaque: req.Opaque,
+			Key:    []byte(stat[0]),
+			Body:   []byte(stat[1]),
+		})
+	}
+	c.send(&transport.MCResponse{Opcode: req.Opcode, Opaque: req.Opaque})
+}
@@ -0,0 +1,158 @@
+package fakekv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/dcp/transport"
+	mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+)
+
+var feedConfig = map[string]interface{}{
+	"genChanSize":  100,
+	"dataChanSize": 100,
+}
+
+func startFeed(t *testing.T, s *Server, bucket string) (*mc.DcpFeed, chan *mc.DcpEvent) {
+	conn, err := mc.Connect("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.SelectBucket(bucket); err != nil {
+		t.Fatal(err)
+	}
+	outch := make(chan *mc.DcpEvent, 100)
+	feed, err := mc.NewDcpFeed(conn, "test", outch, 1, feedConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := feed.DcpOpen("test", 0, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	return feed, outch
+}
+
+func expectEvent(t *testing.T, outch chan *mc.DcpEvent,
+	opcode transport.CommandCode) *mc.DcpEvent {
+
+	select {
+	case e := <-outch:
+		if e.Opcode != opcode {
+			t.Fatalf("expected %v, got %v", opcode, e)
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for %v", opcode)
+	}
+	return nil
+}
+
+func TestDcpStream(t *testing.T) {
+	s, err := NewServer("127.0.0.1:0", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	b := s.CreateBucket("default")
+
+	conn, err := mc.Connect("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.SelectBucket("default"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Set(1, "doc1", 0, 0, []byte(`{"a":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Set(1, "doc2", 0, 0, []byte(`{"a":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := conn.Get(1, "doc1"); err != nil || string(res.Body) != `{"a":1}` {
+		t.Fatalf("unexpected get response %v, %v", res, err)
+	}
+
+	feed, outch := startFeed(t, s, "default")
+	defer feed.Close()
+
+	seqnos, err := feed.DcpGetSeqnos()
+	if err != nil {
+		t.Fatal(err)
+	} else if seqnos[1] != 2 || seqnos[0] != 0 {
+		t.Fatalf("unexpected seqnos %v", seqnos)
+	}
+
+	vbuuid := b.FailoverLog(1)[0][0]
+	err = feed.DcpRequestStream(1, 10, 0, vbuuid, 0, 0xFFFFFFFFFFFFFFFF, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := expectEvent(t, outch, transport.DCP_STREAMREQ)
+	if e.Status != transport.SUCCESS || e.Opaque != 10 {
+		t.Fatalf("unexpected stream response %v", e)
+	}
+	e = expectEvent(t, outch, transport.DCP_SNAPSHOT)
+	if e.SnapstartSeq != 0 || e.SnapendSeq != 2 {
+		t.Fatalf("unexpected backfill snapshot %v", e)
+	}
+	for _, key := range []string{"doc1", "doc2"} {
+		e = expectEvent(t, outch, transport.DCP_MUTATION)
+		if string(e.Key) != key {
+			t.Fatalf("expected %v, got %v", key, e)
+		}
+	}
+
+	if _, err := conn.Del(1, "doc1"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, outch, transport.DCP_SNAPSHOT)
+	if e = expectEvent(t, outch, transport.DCP_DELETION); e.Seqno != 3 {
+		t.Fatalf("unexpected deletion %v", e)
+	}
+
+	if err := feed.CloseStream(1, 11); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, outch, transport.DCP_STREAMEND)
+}
+
+func TestDcpRollback(t *testing.T) {
+	s, err := NewServer("127.0.0.1:0", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	b := s.CreateBucket("default")
+	for _, key := range []string{"doc1", "doc2", "doc3"} {
+		b.Set(2, key, 0, 0, []byte(`{}`))
+	}
+
+	feed, outch := startFeed(t, s, "default")
+	defer feed.Close()
+
+	// unknown vbuuid rolls back to zero.
+	err = feed.DcpRequestStream(2, 10, 0, 1234, 2, 0xFFFFFFFFFFFFFFFF, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := expectEvent(t, outch, transport.DCP_STREAMREQ)
+	if e.Status != transport.ROLLBACK || e.Seqno != 0 {
+		t.Fatalf("unexpected stream response %v", e)
+	}
+
+	// stream ends once end seqno is reached.
+	vbuuid := b.FailoverLog(2)[0][0]
+	err = feed.DcpRequestStream(2, 10, 0, vbuuid, 2, 3, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e = expectEvent(t, outch, transport.DCP_STREAMREQ); e.Status != transport.SUCCESS {
+		t.Fatalf("unexpected stream response %v", e)
+	}
+	expectEvent(t, outch, transport.DCP_SNAPSHOT)
+	if e = expectEvent(t, outch, transport.DCP_MUTATION); string(e.Key) != "doc3" {
+		t.Fatalf("unexpected mutation %v", e)
+	}
+	expectEvent(t, outch, transport.DCP_STREAMEND)
+}
@@ -0,0 +1,294 @@
+// Package localcluster boots projector, indexer and index manager in the
+// test process against local stand-ins for the rest of the cluster: a
+// fake KV node speaking memcached/DCP, a stub of ns_server's REST API and
+// an in-memory metakv. Helpers in tests/framework can be pointed at
+// ClusterAddr() to run functional tests with no outside services.
+//
+// Indexer and projector can not be shut down in-process, hence a cluster
+// should be started once per test binary, typically from TestMain.
+package localcluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/couchbase/cbauth"
+	"github.com/couchbase/cbauth/cbauthimpl"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/dataport"
+	"github.com/couchbase/indexing/secondary/indexer"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/projector"
+	"github.com/couchbase/indexing/secondary/tests/framework/fakekv"
+)
+
+// ErrAlreadyStarted is returned when a cluster is started more than once
+// in a process.
+var ErrAlreadyStarted = errors.New("localcluster: cluster already started")
+
+// ErrIndexerTimeout is returned when indexer does not start listening
+// within Config.StartTimeout.
+var ErrIndexerTimeout = errors.New("localcluster: timeout waiting for indexer")
+
+// Config for a local cluster, zero values are replaced by defaults.
+type Config struct {
+	NumVbuckets  int           // vbuckets per bucket, default 64
+	Buckets      []string      // buckets to create, default ["default"]
+	StorageMode  string        // index storage mode, default memory_optimized
+	StorageDir   string        // indexer storage dir, default a temp dir
+	Username     string        // cluster credentials, default Administrator
+	Password     string        // default asdasd
+	LogLevel     string        // default Info
+	StartTimeout time.Duration // default 2 minutes
+}
+
+// Cluster is a single node cluster running in this process.
+type Cluster struct {
+	KV     *fakekv.Server
+	Metakv *Metakv
+
+	config Config
+	ns     *nsServer
+}
+
+var started bool
+
+// Start the cluster and wait for indexer to listen on its ports.
+func Start(config Config) (*Cluster, error) {
+	if started {
+		return nil, ErrAlreadyStarted
+	}
+	started = true
+
+	config = config.withDefaults()
+	logging.SetLogLevel(logging.Level(config.LogLevel))
+	if config.StorageDir == "" {
+		dir, err := ioutil.TempDir("", "localcluster")
+		if err != nil {
+			return nil, err
+		}
+		config.StorageDir = dir
+	}
+
+	kv, err := fakekv.NewServer("127.0.0.1:0", config.NumVbuckets)
+	if err != nil {
+		return nil, err
+	}
+	for _, bucket := range config.Buckets {
+		kv.CreateBucket(bucket)
+	}
+
+	// ports of node services, as published by ns_server.
+	services := make(map[string]int)
+	_, kvport, _ := net.SplitHostPort(kv.Addr())
+	services["kv"], _ = strconv.Atoi(kvport)
+	for _, service := range []string{"projector",
+		common.INDEX_ADMIN_SERVICE, common.INDEX_SCAN_SERVICE,
+		common.INDEX_HTTP_SERVICE, "indexStreamInit", "indexStreamCatchup",
+		"indexStreamMaint"} {
+
+		if services[service], err = freePort(); err != nil {
+			kv.Close()
+			return nil, err
+		}
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		kv.Close()
+		return nil, err
+	}
+	_, mgmtport, _ := net.SplitHostPort(lis.Addr().String())
+	services["mgmt"], _ = strconv.Atoi(mgmtport)
+
+	c := &Cluster{
+		KV:     kv,
+		Metakv: NewMetakv(),
+		config: config,
+		ns:     newNsServer(lis, kv, services),
+	}
+
+	common.SetMetakvStore(c.Metakv)
+	common.SetServiceAuth(&serviceAuth{config.Username, config.Password})
+	settings, _ := json.Marshal(map[string]interface{}{
+		"indexer.settings.storage_mode": config.StorageMode,
+	})
+	c.Metakv.Set(common.IndexingSettingsMetaPath, settings, nil)
+
+	c.startProjector(services)
+	go c.startIndexer(services)
+
+	addr := net.JoinHostPort("127.0.0.1", fmt.Sprint(services[common.INDEX_HTTP_SERVICE]))
+	if err := waitForListen(addr, config.StartTimeout); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (config Config) withDefaults() Config {
+	if config.NumVbuckets == 0 {
+		config.NumVbuckets = 64
+	}
+	if len(config.Buckets) == 0 {
+		config.Buckets = []string{"default"}
+	}
+	if config.StorageMode == "" {
+		config.StorageMode = common.MemoryOptimized
+	}
+	if config.Username == "" {
+		config.Username, config.Password = "Administrator", "asdasd"
+	}
+	if config.LogLevel == "" {
+		config.LogLevel = "Info"
+	}
+	if config.StartTimeout == 0 {
+		config.StartTimeout = 2 * time.Minute
+	}
+	return config
+}
+
+// ClusterAddr returns host:port of cluster's REST endpoint, to be passed
+// as the server address to tests/framework helpers.
+func (c *Cluster) ClusterAddr() string {
+	return c.ns.addr()
+}
+
+// Username to authenticate with the cluster.
+func (c *Cluster) Username() string {
+	return c.config.Username
+}
+
+// Password to authenticate with the cluster.
+func (c *Cluster) Password() string {
+	return c.config.Password
+}
+
+// StorageDir is the indexer storage directory.
+func (c *Cluster) StorageDir() string {
+	return c.config.StorageDir
+}
+
+// Close stops KV node and REST endpoint. Indexer and projector keep
+// running until the process exits.
+func (c *Cluster) Close() {
+	c.ns.close()
+	c.KV.Close()
+}
+
+func (c *Cluster) startProjector(services map[string]int) {
+	cluster := c.ClusterAddr()
+	nvbs := c.config.NumVbuckets
+
+	config := common.SystemConfig.Clone()
+	config.SetValue("maxVbuckets", nvbs)
+	config.SetValue("projector.clusterAddr", cluster)
+	config.SetValue("projector.adminport.listenAddr",
+		net.JoinHostPort("127.0.0.1", fmt.Sprint(services["projector"])))
+	config.SetValue("projector.diagnostics_dir", c.config.StorageDir)
+
+	epfactory := func(topic, endpointType, addr string,
+		config common.Config) (common.RouterEndpoint, error) {
+
+		if endpointType != "dataport" {
+			return nil, fmt.Errorf("unknown endpoint type %v", endpointType)
+		}
+		return dataport.NewRouterEndpoint(cluster, topic, addr, nvbs, config)
+	}
+	config.SetValue("projector.routerEndpointFactory",
+		common.RouterEndpointFactory(epfactory))
+
+	projector.NewProjector(nvbs, config)
+}
+
+func (c *Cluster) startIndexer(services map[string]int) {
+	port := func(service string) string {
+		return fmt.Sprint(services[service])
+	}
+
+	config := common.SystemConfig.Clone()
+	config.SetValue("indexer.clusterAddr", c.ClusterAddr())
+	config.SetValue("indexer.numVbuckets", c.config.NumVbuckets)
+	config.SetValue("indexer.enableManager", true)
+	config.SetValue("indexer.adminPort", port(common.INDEX_ADMIN_SERVICE))
+	config.SetValue("indexer.scanPort", port(common.INDEX_SCAN_SERVICE))
+	config.SetValue("indexer.httpPort", port(common.INDEX_HTTP_SERVICE))
+	config.SetValue("indexer.streamInitPort", port("indexStreamInit"))
+	config.SetValue("indexer.streamCatchupPort", port("indexStreamCatchup"))
+	config.SetValue("indexer.streamMaintPort", port("indexStreamMaint"))
+	config.SetValue("indexer.storage_dir", c.config.StorageDir)
+	config.SetValue("indexer.diagnostics_dir", c.config.StorageDir)
+	config.SetValue("indexer.nodeuuid", "localcluster")
+	config.SetValue("indexer.settings.storage_mode", c.config.StorageMode)
+	common.SetClusterStorageModeStr(c.config.StorageMode)
+
+	if _, msg := indexer.NewIndexer(config); msg.GetMsgType() != indexer.MSG_SUCCESS {
+		logging.Errorf("localcluster: indexer failure %v", msg)
+	}
+}
+
+func freePort() (int, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port, nil
+}
+
+func waitForListen(addr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return ErrIndexerTimeout
+}
+
+// serviceAuth hands out a fixed set of credentials and permits everything
+// to requests carrying them. Since cbauth is not initialized, requests
+// between in-process services carry no credentials, and are permitted.
+type serviceAuth struct {
+	username, password string
+}
+
+func (a *serviceAuth) GetHTTPServiceAuth(hostport string) (string, string, error) {
+	return a.username, a.password, nil
+}
+
+func (a *serviceAuth) GetMemcachedServiceAuth(hostport string) (string, string, error) {
+	return a.username, a.password, nil
+}
+
+func (a *serviceAuth) AuthWebCreds(r *http.Request) (cbauth.Creds, error) {
+	if u, p, ok := r.BasicAuth(); ok && (u != a.username || p != a.password) {
+		return nil, cbauthimpl.ErrNoAuth
+	}
+	return &creds{name: a.username}, nil
+}
+
+// creds of cluster administrator, unimplemented methods of cbauth.Creds
+// panic.
+type creds struct {
+	cbauth.Creds
+	name string
+}
+
+func (c *creds) Name() string {
+	return c.name
+}
+
+func (c *creds) Domain() string {
+	return "admin"
+}
+
+func (c *creds) IsAllowed(permission string) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,279 @@
+package localcluster
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	c "github.com/couchbase/indexing/secondary/common"
+	tc "github.com/couchbase/indexing/secondary/tests/framework/common"
+	"github.com/couchbase/indexing/secondary/tests/framework/fakekv"
+	"github.com/couchbase/indexing/secondary/tests/framework/kvutility"
+	"github.com/couchbase/indexing/secondary/tests/framework/secondaryindex"
+)
+
+var cluster *Cluster
+
+func TestMain(m *testing.M) {
+	var err error
+	if cluster, err = Start(Config{LogLevel: "Warn"}); err != nil {
+		log.Fatalf("Failed to start local cluster: %v", err)
+	}
+	code := m.Run()
+	cluster.Close()
+	os.Exit(code)
+}
+
+func TestMetakvObserve(t *testing.T) {
+	m := NewMetakv()
+	m.Set("/a/1", []byte("1"), nil)
+
+	events := make(chan string, 10)
+	cancel := make(chan struct{})
+	callb := func(path string, value []byte, rev interface{}) error {
+		events <- path + "=" + string(value)
+		return nil
+	}
+	go m.RunObserveChildren("/a/", callb, cancel)
+	defer close(cancel)
+
+	expect := func(event string) {
+		select {
+		case e := <-events:
+			if e != event {
+				t.Fatalf("expected %v, got %v", event, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %v", event)
+		}
+	}
+	expect("/a/1=1")
+
+	_, rev, _ := m.Get("/a/1")
+	if err := m.Set("/a/1", []byte("2"), rev); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("/a/1", []byte("3"), rev); err == nil {
+		t.Fatalf("expected revision mismatch")
+	}
+	m.Set("/b/1", []byte("1"), nil)
+	m.RecursiveDelete("/a/")
+	expect("/a/1=2")
+	expect("/a/1=")
+}
+
+func TestIndexScan(t *testing.T) {
+	server := cluster.ClusterAddr()
+	if err := secondaryindex.WaitTillAllIndexNodesActive(server, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	docs := tc.KeyValues{
+		"doc1": map[string]interface{}{"age": 10},
+		"doc2": map[string]interface{}{"age": 20},
+		"doc3": map[string]interface{}{"age": 30},
+		"doc4": map[string]interface{}{"name": "noage"},
+	}
+	kvutility.SetKeyValues(docs, "default", "", server)
+
+	err := secondaryindex.CreateSecondaryIndex("index_age", "default", server,
+		"", []string{"age"}, false, nil, true, 60, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kvutility.SetKeyValues(tc.KeyValues{
+		"doc5": map[string]interface{}{"age": 40},
+	}, "default", "", server)
+
+	res, err := secondaryindex.Range("index_age", "default", server,
+		[]interface{}{15}, []interface{}{100}, 3, false, 1000,
+		c.SessionConsistency, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(res) != 3 {
+		t.Fatalf("expected 3 entries, got %v", res)
+	}
+}
+
+func TestRecoveryAfterFailover(t *testing.T) {
+	vbs := allVbuckets()
+	testRecovery(t, "failover", fakekv.Failover(vbs...))
+}
+
+func TestRecoveryAfterStreamEnd(t *testing.T) {
+	vbs := allVbuckets()
+	testRecovery(t, "streamend", fakekv.EndStreams(fakekv.StreamEndStateChanged, vbs...))
+}
+
+func TestRecoveryAfterConnectionDrop(t *testing.T) {
+	testRecovery(t, "conndrop", fakekv.DropConnections())
+}
+
+func TestRecoveryAfterStalledStreamRequest(t *testing.T) {
+	before := bucketStat(t, "num_missing_stream_begin")
+
+	// stream request of vbucket 0 is held back after connections drop,
+	// till timekeeper finds its StreamBegin missing and requests the
+	// stream again.
+	testScriptedRecovery(t, "stall", func(script *fakekv.Script) {
+		script.On(fakekv.After(0), fakekv.DropConnections()).
+			On(fakekv.OnStreamRequest(0, 1), fakekv.StallStreamRequests(0)).
+			On(fakekv.OnStreamRequest(0, 2), fakekv.ResumeStreamRequests(0))
+	})
+
+	if after := bucketStat(t, "num_missing_stream_begin"); after <= before {
+		t.Fatalf("expected missing StreamBegin to be repaired, num_missing_stream_begin %v", after)
+	}
+}
+
+func TestRecoveryAfterRollback(t *testing.T) {
+	server := cluster.ClusterAddr()
+	if err := secondaryindex.WaitTillAllIndexNodesActive(server, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	b := cluster.KV.Bucket("default")
+	set := func(from, to int) {
+		for i := from; i < to; i++ {
+			value := fmt.Sprintf(`{"rollback":%v}`, i)
+			b.Set(0, fmt.Sprintf("rollback%v", i), 0, 0, []byte(value))
+		}
+	}
+	set(0, 10)
+	seqno := b.Seqnos()[0]
+
+	err := secondaryindex.CreateSecondaryIndex("index_rollback", "default", server,
+		"", []string{"rollback"}, false, nil, true, 60, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := bucketStat(t, "num_rollbacks")
+
+	// mutations after seqno are streamed to indexer and then lost by
+	// a rollback of vbucket 0, indexer has to roll back along.
+	script := fakekv.NewScript(b)
+	script.On(fakekv.AtSeqno(0, seqno+5), fakekv.Rollback(0, seqno)).Start()
+	defer script.Stop()
+	set(10, 15)
+	waitScript(t, script)
+	set(15, 20)
+
+	waitEntries(t, "index_rollback", 15)
+	if after := bucketStat(t, "num_rollbacks"); after <= before {
+		t.Fatalf("expected indexer to roll back, num_rollbacks %v", after)
+	}
+	if pending := bucketStat(t, "index_rollback:num_docs_pending"); pending != 0 {
+		t.Fatalf("expected no pending mutations, num_docs_pending %v", pending)
+	}
+}
+
+// testRecovery indexes documents on field, injects faults into the
+// maintenance stream and expects mutations after the faults to be
+// indexed once the stream is repaired.
+func testRecovery(t *testing.T, field string, faults ...fakekv.Fault) {
+	testScriptedRecovery(t, field, func(script *fakekv.Script) {
+		script.On(fakekv.After(0), faults...)
+	})
+}
+
+// testScriptedRecovery is testRecovery with the faults injected by the
+// steps that steps adds to a script.
+func testScriptedRecovery(t *testing.T, field string, steps func(*fakekv.Script)) {
+	server := cluster.ClusterAddr()
+	if err := secondaryindex.WaitTillAllIndexNodesActive(server, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	docs := func(from, to int) tc.KeyValues {
+		kvs := make(tc.KeyValues)
+		for i := from; i < to; i++ {
+			kvs[fmt.Sprintf("%v%v", field, i)] = map[string]interface{}{field: i}
+		}
+		return kvs
+	}
+	kvutility.SetKeyValues(docs(0, 10), "default", "", server)
+
+	index := "index_" + field
+	err := secondaryindex.CreateSecondaryIndex(index, "default", server,
+		"", []string{field}, false, nil, true, 60, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := fakekv.NewScript(cluster.KV.Bucket("default"))
+	steps(script)
+	script.Start()
+	defer script.Stop()
+	waitScript(t, script)
+
+	kvutility.SetKeyValues(docs(10, 20), "default", "", server)
+	res, err := secondaryindex.Range(index, "default", server,
+		[]interface{}{0}, []interface{}{100}, 3, false, 1000,
+		c.SessionConsistency, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(res) != 20 {
+		t.Fatalf("expected 20 entries, got %v", len(res))
+	}
+	if size := bucketStat(t, "mutation_queue_size"); size != 0 {
+		t.Fatalf("expected stream to be drained, mutation_queue_size %v", size)
+	}
+}
+
+// waitScript waits for faults of script to be injected, repair of a
+// missing StreamBegin takes timekeeper more than 30 seconds.
+func waitScript(t *testing.T, script *fakekv.Script) {
+	select {
+	case <-script.Done():
+	case <-time.After(2 * time.Minute):
+		t.Fatalf("timeout injecting faults")
+	}
+}
+
+// waitEntries scans index for values in [0, 100] till it has n entries.
+func waitEntries(t *testing.T, index string, n int) {
+	server := cluster.ClusterAddr()
+	var res tc.ScanResponse
+	var err error
+	for i := 0; i < 60; i++ {
+		res, err = secondaryindex.Range(index, "default", server,
+			[]interface{}{0}, []interface{}{100}, 3, false, 1000,
+			c.SessionConsistency, nil)
+		if err == nil && len(res) == n {
+			return
+		}
+		time.Sleep(time.Second)
+	}
+	t.Fatalf("expected %v entries, got %v (%v)", n, len(res), err)
+}
+
+// bucketStat sums stat `name` of bucket default over index nodes, stats
+// of an index are named "index:stat".
+func bucketStat(t *testing.T, name string) float64 {
+	server := cluster.ClusterAddr()
+	addrs, err := secondaryindex.GetIndexerNodesHttpAddresses(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var value float64
+	for _, addr := range addrs {
+		stats := secondaryindex.GetStatsForIndexerHttpAddress(addr,
+			cluster.Username(), cluster.Password())
+		if v, ok := stats["default:"+name].(float64); ok {
+			value += v
+		}
+	}
+	return value
+}
+
+func allVbuckets() []uint16 {
+	vbs := make([]uint16, cluster.KV.NumVbuckets())
+	for i := range vbs {
+		vbs[i] = uint16(i)
+	}
+	return vbs
+}
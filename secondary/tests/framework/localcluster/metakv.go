@@ -0,0 +1,166 @@
+package localcluster
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/cbauth/metakv"
+)
+
+// Metakv is an in-memory implementation of common.MetakvStore, with the
+// same semantics as ns_server's metakv for a single node.
+type Metakv struct {
+	mu        sync.Mutex
+	rev       uint64
+	entries   map[string]*metakv.KVEntry
+	observers map[*observer]bool
+}
+
+type observer struct {
+	dirpath string
+	mu      sync.Mutex
+	pending []metakv.KVEntry
+	notify  chan struct{}
+}
+
+// NewMetakv returns an empty store.
+func NewMetakv() *Metakv {
+	return &Metakv{
+		entries:   make(map[string]*metakv.KVEntry),
+		observers: make(map[*observer]bool),
+	}
+}
+
+// Get returns value and revision of path, nil if it does not exist.
+func (m *Metakv) Get(path string) ([]byte, interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[path]; ok {
+		return e.Value, e.Rev, nil
+	}
+	return nil, nil, nil
+}
+
+// Set value of path, if rev is not nil it should match the current
+// revision of path.
+func (m *Metakv) Set(path string, value []byte, rev interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[path]; rev != nil && (!ok || e.Rev != rev) {
+		return metakv.ErrRevMismatch
+	}
+	m.rev++
+	e := &metakv.KVEntry{Path: path, Value: value, Rev: m.rev}
+	m.entries[path] = e
+	m.publish(*e)
+	return nil
+}
+
+// Delete path, if rev is not nil it should match the current revision.
+func (m *Metakv) Delete(path string, rev interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[path]
+	if rev != nil && (!ok || e.Rev != rev) {
+		return metakv.ErrRevMismatch
+	} else if ok {
+		m.delete(path)
+	}
+	return nil
+}
+
+// RecursiveDelete deletes all paths under dirpath.
+func (m *Metakv) RecursiveDelete(dirpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.children(dirpath) {
+		m.delete(e.Path)
+	}
+	return nil
+}
+
+// ListAllChildren returns all entries under dirpath, recursively.
+func (m *Metakv) ListAllChildren(dirpath string) ([]metakv.KVEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.children(dirpath), nil
+}
+
+// RunObserveChildren invokes callb for all entries under dirpath, and
+// then for every change to them until cancel is closed or callb returns
+// error. Deleted entries are notified with nil value.
+func (m *Metakv) RunObserveChildren(dirpath string,
+	callb func(path string, value []byte, rev interface{}) error,
+	cancel <-chan struct{}) error {
+
+	o := &observer{dirpath: dirpath, notify: make(chan struct{}, 1)}
+
+	m.mu.Lock()
+	o.pending = m.children(dirpath)
+	o.notify <- struct{}{}
+	m.observers[o] = true
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.observers, o)
+		m.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-cancel:
+			return nil
+		case <-o.notify:
+		}
+
+		o.mu.Lock()
+		pending := o.pending
+		o.pending = nil
+		o.mu.Unlock()
+
+		for _, e := range pending {
+			if err := callb(e.Path, e.Value, e.Rev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (m *Metakv) children(dirpath string) []metakv.KVEntry {
+	entries := make([]metakv.KVEntry, 0)
+	for path, e := range m.entries {
+		if strings.HasPrefix(path, dirpath) {
+			entries = append(entries, *e)
+		}
+	}
+	sort.Sort(byPath(entries))
+	return entries
+}
+
+func (m *Metakv) delete(path string) {
+	delete(m.entries, path)
+	m.publish(metakv.KVEntry{Path: path})
+}
+
+func (m *Metakv) publish(e metakv.KVEntry) {
+	for o := range m.observers {
+		if !strings.HasPrefix(e.Path, o.dirpath) {
+			continue
+		}
+		o.mu.Lock()
+		o.pending = append(o.pending, e)
+		o.mu.Unlock()
+		select {
+		case o.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+type byPath []metakv.KVEntry
+
+func (entries byPath) Len() int           { return len(entries) }
+func (entries byPath) Less(i, j int) bool { return entries[i].Path < entries[j].Path }
+func (entries byPath) Swap(i, j int)      { entries[i], entries[j] = entries[j], entries[i] }
@@ -0,0 +1,181 @@
+package localcluster
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	couchbase "github.com/couchbase/indexing/secondary/dcp"
+	"github.com/couchbase/indexing/secondary/tests/framework/fakekv"
+)
+
+// clusterCompatibility advertised by the node, version 5.0.
+const clusterCompatibility = 5 * 0x10000
+
+// nsServer is a stub of ns_server's REST API serving a single node
+// cluster, as read by common.ClusterInfoCache and bucket clients.
+type nsServer struct {
+	lis      net.Listener
+	kv       *fakekv.Server
+	services map[string]int
+	quitch   chan struct{}
+}
+
+func newNsServer(lis net.Listener, kv *fakekv.Server, services map[string]int) *nsServer {
+	ns := &nsServer{
+		lis:      lis,
+		kv:       kv,
+		services: services,
+		quitch:   make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pools", ns.handlePools)
+	mux.HandleFunc("/pools/default", ns.handlePool)
+	mux.HandleFunc("/poolsStreaming/default", ns.handlePool)
+	mux.HandleFunc("/pools/default/buckets", ns.handleBuckets)
+	mux.HandleFunc("/pools/default/buckets/", ns.handleBucket)
+	mux.HandleFunc("/pools/default/b/", ns.handleBucket)
+	mux.HandleFunc("/pools/default/nodeServices", ns.handleNodeServices)
+	mux.HandleFunc("/pools/default/nodeServicesStreaming", ns.handleNodeServices)
+	mux.HandleFunc("/pools/default/serverGroups", ns.handleServerGroups)
+	go http.Serve(lis, mux)
+	return ns
+}
+
+func (ns *nsServer) addr() string {
+	return ns.lis.Addr().String()
+}
+
+func (ns *nsServer) close() {
+	close(ns.quitch)
+	ns.lis.Close()
+}
+
+func (ns *nsServer) node() couchbase.Node {
+	return couchbase.Node{
+		ClusterCompatibility: clusterCompatibility,
+		ClusterMembership:    "active",
+		Hostname:             ns.addr(),
+		Status:               "healthy",
+		ThisNode:             true,
+		Version:              "5.0.0-0000-enterprise",
+		Services:             []string{"kv", "index"},
+	}
+}
+
+func (ns *nsServer) bucket(name string) *couchbase.Bucket {
+	b := ns.kv.Bucket(name)
+	if b == nil {
+		return nil
+	}
+	vbmap := make([][]int, b.NumVbuckets())
+	for i := range vbmap {
+		vbmap[i] = []int{0}
+	}
+	return &couchbase.Bucket{
+		AuthType:    "sasl",
+		Type:        "membase",
+		Name:        name,
+		NodeLocator: "vbucket",
+		URI:         "/pools/default/buckets/" + name + "?bucket_uuid=" + b.UUID(),
+		UUID:        b.UUID(),
+		VBSMJson: couchbase.VBucketServerMap{
+			HashAlgorithm: "CRC",
+			ServerList:    []string{ns.kv.Addr()},
+			VBucketMap:    vbmap,
+		},
+		NodesJSON: []couchbase.Node{ns.node()},
+	}
+}
+
+func (ns *nsServer) handlePools(w http.ResponseWriter, r *http.Request) {
+	ns.reply(w, r, &couchbase.Pools{
+		ImplementationVersion: "5.0.0-0000-enterprise",
+		IsAdmin:               true,
+		UUID:                  "localcluster",
+		Pools: []couchbase.RestPool{{
+			Name:         "default",
+			StreamingURI: "/poolsStreaming/default",
+			URI:          "/pools/default",
+		}},
+	})
+}
+
+func (ns *nsServer) handlePool(w http.ResponseWriter, r *http.Request) {
+	ns.reply(w, r, map[string]interface{}{
+		"name":  "default",
+		"nodes": []couchbase.Node{ns.node()},
+		"buckets": map[string]string{
+			"uri":              "/pools/default/buckets",
+			"terseBucketsBase": "/pools/default/b/",
+		},
+		"serverGroupsUri": "/pools/default/serverGroups",
+	})
+}
+
+func (ns *nsServer) handleBuckets(w http.ResponseWriter, r *http.Request) {
+	buckets := make([]*couchbase.Bucket, 0)
+	for _, name := range ns.kv.Buckets() {
+		buckets = append(buckets, ns.bucket(name))
+	}
+	ns.reply(w, r, buckets)
+}
+
+func (ns *nsServer) handleBucket(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	if b := ns.bucket(name); b != nil {
+		ns.reply(w, r, b)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (ns *nsServer) handleNodeServices(w http.ResponseWriter, r *http.Request) {
+	ns.reply(w, r, &couchbase.PoolServices{
+		Rev: 1,
+		NodesExt: []couchbase.NodeServices{{
+			Services: ns.services,
+			Hostname: "127.0.0.1",
+			ThisNode: true,
+		}},
+	})
+}
+
+func (ns *nsServer) handleServerGroups(w http.ResponseWriter, r *http.Request) {
+	ns.reply(w, r, &couchbase.ServerGroups{
+		Groups: []couchbase.ServerGroup{{
+			Name:  "Group 1",
+			Nodes: []couchbase.Node{ns.node()},
+		}},
+	})
+}
+
+// reply with v as JSON. Streaming endpoints send the newline terminated
+// value and keep the connection open, as topology never changes.
+func (ns *nsServer) reply(w http.ResponseWriter, r *http.Request, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !strings.Contains(r.URL.Path, "Streaming") {
+		w.Write(data)
+		return
+	}
+
+	w.Write(append(data, '\n', '\n'))
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	var closech <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		closech = cn.CloseNotify()
+	}
+	select {
+	case <-closech:
+	case <-ns.quitch:
+	}
+}
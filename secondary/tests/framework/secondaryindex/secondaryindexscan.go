@@ -357,7 +357,7 @@ func Scans(indexName, bucketName, server string, scans qc.Scans, reverse, distin
 	count := 0
 	start := time.Now()
 	connErr := client.MultiScan(
-		defnID, "", scans, reverse, distinct, projection, offset, limit,
+		defnID, "", scans, reverse, distinct, projection, nil, nil, "", offset, limit,
 		consistency, vector,
 		func(response qc.ResponseReader) bool {
 			if err := response.Error(); err != nil {
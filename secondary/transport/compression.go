@@ -0,0 +1,213 @@
+package transport
+
+import "bytes"
+import "compress/bzip2"
+import "compress/gzip"
+import "encoding/binary"
+import "errors"
+import "io"
+import "io/ioutil"
+import "net"
+import "strings"
+import "sync/atomic"
+
+import "github.com/golang/snappy"
+
+// ErrorCompressionUnknown for unknown compression.
+var ErrorCompressionUnknown = errors.New("transport.compressionUnknown")
+
+// ErrorCompressionUnsupported for compression that can only be
+// decompressed by this end.
+var ErrorCompressionUnsupported = errors.New("transport.compressionUnsupported")
+
+// ParseCompression returns the compression for a configured name, which
+// can be "none", "snappy" or "gzip". bzip2 packets are accepted from the
+// other end but cannot be sent.
+func ParseCompression(name string) (byte, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return CompressionNone, nil
+	case "snappy":
+		return CompressionSnappy, nil
+	case "gzip":
+		return CompressionGzip, nil
+	case "bzip2":
+		return CompressionNone, ErrorCompressionUnsupported
+	}
+	return CompressionNone, ErrorCompressionUnknown
+}
+
+// Compress payload using `compression`, returns the payload as is for
+// CompressionNone.
+func Compress(compression byte, big []byte) (small []byte, err error) {
+	switch compression {
+	case CompressionNone:
+		return big, nil
+
+	case CompressionSnappy:
+		return snappy.Encode(nil, big), nil
+
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err = w.Write(big); err != nil {
+			return nil, err
+		}
+		if err = w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+
+	case CompressionBzip2:
+		return nil, ErrorCompressionUnsupported
+	}
+	return nil, ErrorCompressionUnknown
+}
+
+// Decompress payload compressed with `compression`. Payloads that
+// decompress to more than `maxPayload` bytes fail with ErrorPacketOverflow,
+// without being decompressed in full.
+func Decompress(compression byte, small []byte, maxPayload int) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return small, nil
+
+	case CompressionSnappy:
+		n, err := snappy.DecodedLen(small)
+		if err != nil {
+			return nil, err
+		} else if n > maxPayload {
+			return nil, ErrorPacketOverflow
+		}
+		return snappy.Decode(nil, small)
+
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(small))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readPayload(r, maxPayload)
+
+	case CompressionBzip2:
+		return readPayload(bzip2.NewReader(bytes.NewReader(small)), maxPayload)
+	}
+	return nil, ErrorCompressionUnknown
+}
+
+// readPayload reads decompressed payload from r, upto maxPayload bytes.
+func readPayload(r io.Reader, maxPayload int) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(maxPayload)+1))
+	if err != nil {
+		return nil, err
+	} else if len(data) > maxPayload {
+		return nil, ErrorPacketOverflow
+	}
+	return data, nil
+}
+
+// CompressionStats accumulate payload size before compression (raw) and
+// on the wire (compressed), for packets in one direction. Safe for
+// concurrent use.
+type CompressionStats struct {
+	rawBytes        int64
+	compressedBytes int64
+}
+
+// Add a payload of `raw` bytes, that was `compressed` bytes on the wire.
+func (s *CompressionStats) Add(raw, compressed int) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.rawBytes, int64(raw))
+	atomic.AddInt64(&s.compressedBytes, int64(compressed))
+}
+
+// RawBytes returns the total size of payloads before compression.
+func (s *CompressionStats) RawBytes() int64 {
+	return atomic.LoadInt64(&s.rawBytes)
+}
+
+// CompressedBytes returns the total size of payloads on the wire.
+func (s *CompressionStats) CompressedBytes() int64 {
+	return atomic.LoadInt64(&s.compressedBytes)
+}
+
+// compressPayload compress payload if `compression` is accepted by the
+// other end and if it makes the payload smaller, returns flags for the
+// payload.
+func compressPayload(
+	flags TransportFlag, compression byte, peer TransportFlag,
+	payload []byte, stats *CompressionStats) (TransportFlag, []byte, error) {
+
+	flags = flags.SetCompression(CompressionNone).SetAccept(AcceptCompressions)
+	data := payload
+	if compression != CompressionNone && peer.Accepts(compression) {
+		small, err := Compress(compression, payload)
+		if err != nil {
+			return flags, nil, err
+		}
+		if len(small) < len(payload) {
+			flags, data = flags.SetCompression(compression), small
+		}
+	}
+	stats.Add(len(payload), len(data))
+	return flags, data, nil
+}
+
+// Conn is a connection to the other end with the compression negotiated
+// for packets sent on it. Typically used by servers to respond with
+// the compression accepted by the client that sent the request.
+type Conn struct {
+	net.Conn
+	compression byte
+	peer        TransportFlag
+	stats       *CompressionStats
+}
+
+// NewConn returns a connection that shall compress packets using
+// `compression` if it is accepted by `peer`, the flags of a packet
+// received from the other end.
+func NewConn(
+	conn net.Conn, compression byte, peer TransportFlag,
+	stats *CompressionStats) *Conn {
+
+	return &Conn{
+		Conn:        conn,
+		compression: compression,
+		peer:        peer,
+		stats:       stats,
+	}
+}
+
+// Send payload to the other end, compressed when negotiated, `buf` is
+// used for transport framing.
+func (c *Conn) Send(buf []byte, flags TransportFlag, payload []byte) error {
+	flags, data, err := compressPayload(flags, c.compression, c.peer, payload, c.stats)
+	if err != nil {
+		return err
+	}
+	return Send(c.Conn, buf, flags, data)
+}
+
+// SendAccept advertise compressions accepted by this end, with a packet
+// that carries no payload. Used by receiving end of one way connections.
+func SendAccept(conn transporter) error {
+	buf := make([]byte, pktLenSize+pktFlagSize)
+	return Send(conn, buf, TransportFlag(0).SetAccept(AcceptCompressions), nil)
+}
+
+// ReceiveAccept wait for the other end to advertise compressions using
+// SendAccept, returns flags of the advertisement.
+func ReceiveAccept(conn transporter) (TransportFlag, error) {
+	buf := make([]byte, pktDataOffset)
+	if err := fullRead(conn, buf); err != nil {
+		return 0, err
+	}
+	a, b := pktLenOffset, pktLenOffset+pktLenSize
+	if pktlen := binary.BigEndian.Uint32(buf[a:b]); pktlen != 0 {
+		return 0, ErrorPacketOverflow
+	}
+	a, b = pktFlagOffset, pktFlagOffset+pktFlagSize
+	return TransportFlag(binary.BigEndian.Uint16(buf[a:b])), nil
+}
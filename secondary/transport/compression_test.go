@@ -0,0 +1,174 @@
+package transport
+
+import "bytes"
+import "net"
+import "testing"
+
+// testConn loops packets written to it back to the reader.
+type testConn struct {
+	bytes.Buffer
+}
+
+func (tc *testConn) LocalAddr() net.Addr  { return &net.TCPAddr{} }
+func (tc *testConn) RemoteAddr() net.Addr { return &net.TCPAddr{} }
+
+func newTestPacket(compression byte) *TransportPacket {
+	pkt := NewTransportPacket(1024*1024, TransportFlag(0).SetProtobuf())
+	pkt.SetEncoder(EncodingProtobuf, func(payload interface{}) ([]byte, error) {
+		return payload.([]byte), nil
+	})
+	pkt.SetDecoder(EncodingProtobuf, func(data []byte) (interface{}, error) {
+		return data, nil
+	})
+	return pkt.SetCompression(compression)
+}
+
+func sendReceive(
+	t *testing.T, tx, rx *TransportPacket, payload []byte) TransportFlag {
+
+	tc := &testConn{}
+	if err := tx.Send(tc, payload); err != nil {
+		t.Fatal(err)
+	}
+	buf := tc.Bytes()
+	flags := TransportFlag(uint16(buf[pktFlagOffset])<<8 | uint16(buf[pktFlagOffset+1]))
+	data, err := rx.Receive(tc)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data.([]byte), payload) {
+		t.Fatalf("payload mismatch")
+	}
+	return flags
+}
+
+func TestFlagsAccept(t *testing.T) {
+	flags := TransportFlag(0).SetProtobuf().SetAccept(AcceptCompressions)
+	for _, compression := range []byte{CompressionSnappy, CompressionGzip, CompressionBzip2} {
+		if !flags.Accepts(compression) {
+			t.Fatalf("expected compression %v to be accepted", compression)
+		}
+		if TransportFlag(0).Accepts(compression) {
+			t.Fatalf("unexpected compression %v accepted without ACCEPT", compression)
+		}
+	}
+	if !TransportFlag(0).Accepts(CompressionNone) {
+		t.Fatalf("expected no compression to be always accepted")
+	}
+
+	flags = flags.SetCompression(CompressionGzip)
+	if flags.GetCompression() != CompressionGzip ||
+		flags.GetEncoding() != EncodingProtobuf ||
+		flags.GetAccept() != AcceptCompressions {
+		t.Fatalf("unexpected flags %x", flags)
+	}
+}
+
+func TestPacketNegotiation(t *testing.T) {
+	payload := bytes.Repeat([]byte("compressible"), 1000)
+	for _, compression := range []byte{CompressionSnappy, CompressionGzip} {
+		sent, rcvd := &CompressionStats{}, &CompressionStats{}
+		tx := newTestPacket(compression).SetStats(sent, nil)
+		rx := newTestPacket(CompressionNone).SetStats(nil, rcvd)
+
+		// not compressed until the other end accepts the compression,
+		// every packet advertises compressions accepted by the sender.
+		flags := sendReceive(t, tx, rx, payload)
+		if flags.GetCompression() != CompressionNone {
+			t.Fatalf("unexpected compression %v before negotiation", flags)
+		} else if flags.GetAccept() != AcceptCompressions {
+			t.Fatalf("expected sender to advertise compressions, %x", flags)
+		} else if sent.RawBytes() != sent.CompressedBytes() {
+			t.Fatalf("unexpected sent stats %v %v", sent.RawBytes(), sent.CompressedBytes())
+		}
+
+		// receiving end of one way connection advertises compressions.
+		tc := &testConn{}
+		if err := SendAccept(tc); err != nil {
+			t.Fatal(err)
+		}
+		if payload, err := tx.Receive(tc); err != nil || payload != nil {
+			t.Fatalf("unexpected payload %v, err %v", payload, err)
+		}
+		if !tx.Peer().Accepts(compression) {
+			t.Fatalf("expected peer to accept %v", compression)
+		}
+
+		*sent, *rcvd = CompressionStats{}, CompressionStats{}
+		if flags = sendReceive(t, tx, rx, payload); flags.GetCompression() != compression {
+			t.Fatalf("expected compression %v, got %x", compression, flags)
+		}
+		if sent.RawBytes() != int64(len(payload)) ||
+			sent.CompressedBytes() >= sent.RawBytes() {
+			t.Fatalf("unexpected sent stats %v %v", sent.RawBytes(), sent.CompressedBytes())
+		}
+		if rcvd.RawBytes() != sent.RawBytes() ||
+			rcvd.CompressedBytes() != sent.CompressedBytes() {
+			t.Fatalf("unexpected received stats %v %v", rcvd.RawBytes(), rcvd.CompressedBytes())
+		}
+	}
+}
+
+func TestPacketFallback(t *testing.T) {
+	payload := bytes.Repeat([]byte("compressible"), 1000)
+	tx := newTestPacket(CompressionSnappy)
+	rx := newTestPacket(CompressionNone)
+
+	// peer that doesn't accept the compression gets uncompressed packets.
+	tx.SetPeer(TransportFlag(0).SetAccept(1 << CompressionGzip))
+	if flags := sendReceive(t, tx, rx, payload); flags.GetCompression() != CompressionNone {
+		t.Fatalf("unexpected compression %x", flags)
+	}
+
+	// packets without ACCEPT, from older peers, don't reset negotiation.
+	tx.SetPeer(TransportFlag(0).SetProtobuf())
+	if tx.Peer().GetAccept() != 1<<CompressionGzip {
+		t.Fatalf("unexpected peer %x", tx.Peer())
+	}
+
+	// payload is sent as is if compression doesn't make it smaller.
+	tx.SetCompression(CompressionGzip)
+	if flags := sendReceive(t, tx, rx, []byte("x")); flags.GetCompression() != CompressionNone {
+		t.Fatalf("unexpected compression %x", flags)
+	}
+	if flags := sendReceive(t, tx, rx, payload); flags.GetCompression() != CompressionGzip {
+		t.Fatalf("expected gzip compression, got %x", flags)
+	}
+}
+
+func TestDecompressBomb(t *testing.T) {
+	maxPayload := 1024 * 1024
+	bomb := make([]byte, 16*maxPayload)
+	for _, compression := range []byte{CompressionSnappy, CompressionGzip} {
+		small, err := Compress(compression, bomb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Decompress(compression, small, maxPayload); err != ErrorPacketOverflow {
+			t.Fatalf("expected %v for compression %v, got %v", ErrorPacketOverflow, compression, err)
+		}
+
+		// payload of maxPayload bytes is accepted.
+		small, err = Compress(compression, bomb[:maxPayload])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, err := Decompress(compression, small, maxPayload); err != nil || len(data) != maxPayload {
+			t.Fatalf("unexpected decompression %v %v", len(data), err)
+		}
+	}
+
+	// packet whose payload decompresses beyond the packet buffer.
+	small, err := Compress(CompressionGzip, bomb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := &testConn{}
+	flags := TransportFlag(0).SetProtobuf().SetCompression(CompressionGzip)
+	if err := Send(tc, make([]byte, pktDataOffset), flags, small); err != nil {
+		t.Fatal(err)
+	}
+	rx := newTestPacket(CompressionNone)
+	if _, err := rx.Receive(tc); err != ErrorPacketOverflow {
+		t.Fatalf("expected %v, got %v", ErrorPacketOverflow, err)
+	}
+}
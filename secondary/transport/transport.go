@@ -5,11 +5,13 @@
 //      where, packetlen == len(mutation)
 //
 // `flags` used for specifying encoding format, compression etc.
+// Compression is negotiated, refer to transport_flags.go.
 
 package transport
 
 import "errors"
 import "net"
+import "sync/atomic"
 import "github.com/couchbase/indexing/secondary/logging"
 
 // error codes
@@ -46,10 +48,14 @@ type transporter interface { // facilitates unit testing
 // TransportPacket to send and receive mutation packets between router
 // and downstream client.
 type TransportPacket struct {
-	flags    TransportFlag
-	buf      []byte
-	encoders map[byte]Encoder
-	decoders map[byte]Decoder
+	flags       TransportFlag
+	compression byte
+	peer        uint32 // TransportFlag with compressions accepted by peer
+	buf         []byte
+	encoders    map[byte]Encoder
+	decoders    map[byte]Decoder
+	sentStats   *CompressionStats
+	rcvdStats   *CompressionStats
 }
 
 // Encoder callback
@@ -65,13 +71,15 @@ type Decoder func(data []byte) (payload interface{}, err error)
 //
 // maxlen, maximum size of internal buffer used to marshal and unmarshal
 //         packets.
-// flags,  specifying encoding and compression.
+// flags,  specifying encoding and compression, compression is applied
+//         only after the other end has accepted it.
 func NewTransportPacket(maxlen int, flags TransportFlag) *TransportPacket {
 	pkt := &TransportPacket{
-		flags:    flags,
-		buf:      make([]byte, maxlen),
-		encoders: make(map[byte]Encoder),
-		decoders: make(map[byte]Decoder),
+		flags:       flags,
+		compression: flags.GetCompression(),
+		buf:         make([]byte, maxlen),
+		encoders:    make(map[byte]Encoder),
+		decoders:    make(map[byte]Decoder),
 	}
 	pkt.encoders[EncodingNone] = nil
 	pkt.decoders[EncodingNone] = nil
@@ -90,20 +98,51 @@ func (pkt *TransportPacket) SetDecoder(typ byte, callb Decoder) *TransportPacket
 	return pkt
 }
 
+// SetCompression for packets sent hereafter.
+func (pkt *TransportPacket) SetCompression(compression byte) *TransportPacket {
+	pkt.compression = compression
+	return pkt
+}
+
+// SetStats to accumulate compression statistics for packets sent and
+// for packets received, either can be nil.
+func (pkt *TransportPacket) SetStats(sent, received *CompressionStats) *TransportPacket {
+	pkt.sentStats, pkt.rcvdStats = sent, received
+	return pkt
+}
+
+// SetPeer with flags received from the other end, advertising the
+// compressions it accepts. Safe to call concurrently with Send.
+func (pkt *TransportPacket) SetPeer(flags TransportFlag) *TransportPacket {
+	if flags.GetAccept() != 0 {
+		atomic.StoreUint32(&pkt.peer, uint32(flags))
+	}
+	return pkt
+}
+
+// Peer returns flags advertising compressions accepted by the other end,
+// as received with the latest packet.
+func (pkt *TransportPacket) Peer() TransportFlag {
+	return TransportFlag(atomic.LoadUint32(&pkt.peer))
+}
+
 // Send payload to the other end using sufficient encoding and compression.
 func (pkt *TransportPacket) Send(conn transporter, payload interface{}) (err error) {
 	var data []byte
+	var flags TransportFlag
 
 	// encode
 	if data, err = pkt.encode(payload); err != nil {
 		return
 	}
 	// compress
-	if data, err = pkt.compress(data); err != nil {
+	flags, data, err = compressPayload(
+		pkt.flags, pkt.compression, pkt.Peer(), data, pkt.sentStats)
+	if err != nil {
 		return
 	}
 
-	err = Send(conn, pkt.buf, pkt.flags, data)
+	err = Send(conn, pkt.buf, flags, data)
 	return
 }
 
@@ -118,22 +157,25 @@ func (pkt *TransportPacket) Receive(conn transporter) (payload interface{}, err
 		return
 	}
 
-	// Special packet to indicate end response
-	if len(data) == 0 && flags == 0 {
+	pkt.SetPeer(flags)
+
+	// Special packet to indicate end response, or to advertise
+	// accepted compressions.
+	if len(data) == 0 && flags.GetEncoding() == EncodingNone {
 		return nil, nil
 	}
 
-	pkt.flags = flags
-
 	laddr, raddr := conn.LocalAddr(), conn.RemoteAddr()
 	logging.Tracef("read %v bytes on connection %v<-%v", len(data), laddr, raddr)
 
 	// de-compression
-	if data, err = pkt.decompress(data); err != nil {
+	size := len(data)
+	if data, err = Decompress(flags.GetCompression(), data, len(pkt.buf)); err != nil {
 		return
 	}
+	pkt.rcvdStats.Add(len(data), size)
 	// decoding
-	if payload, err = pkt.decode(data); err != nil {
+	if payload, err = pkt.decode(flags.GetEncoding(), data); err != nil {
 		return
 	}
 	return
@@ -153,32 +195,13 @@ func (pkt *TransportPacket) encode(payload interface{}) (data []byte, err error)
 
 // decode array of bytes back to payload, if callback was specified `nil` for
 // a valid type then return `data` as `payload`.
-func (pkt *TransportPacket) decode(data []byte) (payload interface{}, err error) {
-	typ := pkt.flags.GetEncoding()
+func (pkt *TransportPacket) decode(typ byte, data []byte) (payload interface{}, err error) {
 	if callb, ok := pkt.decoders[typ]; ok && callb != nil {
 		return callb(data)
 	}
 	return nil, ErrorDecoderUnknown
 }
 
-// compress array of bytes.
-func (pkt *TransportPacket) compress(big []byte) (small []byte, err error) {
-	switch pkt.flags.GetCompression() {
-	case CompressionNone:
-		small = big
-	}
-	return
-}
-
-// decompress array of bytes.
-func (pkt *TransportPacket) decompress(small []byte) (big []byte, err error) {
-	switch pkt.flags.GetCompression() {
-	case CompressionNone:
-		big = small
-	}
-	return
-}
-
 // read len(buf) bytes from `conn`.
 func fullRead(conn transporter, buf []byte) error {
 	size, start := 0, 0
@@ -3,9 +3,15 @@
 //       byte|       0       |       1       |
 //           +---------------+---------------+
 //       bits|0 1 2 3 4 5 6 7|0 1 2 3 4 5 6 7|
-//           +-------+-------+---------------+  COMP. - Compression
-//          0| COMP. |  ENC. | undefined     |  ENC.  - Encoding
-//           +-------+-------+---------------+
+//           +-------+-------+---------------+  COMP.  - Compression
+//          0| COMP. |  ENC. |    ACCEPT     |  ENC.   - Encoding
+//           +-------+-------+---------------+  ACCEPT - Compressions
+//                                                       accepted by sender
+//
+// ACCEPT has bit (1 << compression) set for every compression the
+// sender of a packet can decompress. A packet is compressed only when
+// the other end has advertised the compression, peers that don't
+// advertise anything shall receive uncompressed packets.
 
 package transport
 
@@ -27,6 +33,11 @@ const ( // types of compression over the wire.
 	CompressionBzip2 = 3
 )
 
+// AcceptCompressions is the ACCEPT mask for all compressions that can
+// be decompressed by this end.
+const AcceptCompressions byte = (1 << CompressionSnappy) |
+	(1 << CompressionGzip) | (1 << CompressionBzip2)
+
 // TransportFlag tell packet encoding and compression formats.
 type TransportFlag uint16
 
@@ -35,6 +46,11 @@ func (flags TransportFlag) GetCompression() byte {
 	return byte(flags & TransportFlag(0x000F))
 }
 
+// SetCompression will set packet compression
+func (flags TransportFlag) SetCompression(compression byte) TransportFlag {
+	return (flags & TransportFlag(0xFFF0)) | TransportFlag(compression&0x0F)
+}
+
 // SetSnappy will set packet compression to snappy
 func (flags TransportFlag) SetSnappy() TransportFlag {
 	return (flags & TransportFlag(0xFFF0)) | TransportFlag(CompressionSnappy)
@@ -59,3 +75,21 @@ func (flags TransportFlag) GetEncoding() byte {
 func (flags TransportFlag) SetProtobuf() TransportFlag {
 	return (flags & TransportFlag(0xFF0F)) | TransportFlag(EncodingProtobuf)
 }
+
+// GetAccept will get the mask of compressions accepted by the sender.
+func (flags TransportFlag) GetAccept() byte {
+	return byte(flags >> 8)
+}
+
+// SetAccept will set the mask of compressions accepted by the sender.
+func (flags TransportFlag) SetAccept(accept byte) TransportFlag {
+	return (flags & TransportFlag(0x00FF)) | (TransportFlag(accept) << 8)
+}
+
+// Accepts tell whether `compression` is accepted by the sender.
+func (flags TransportFlag) Accepts(compression byte) bool {
+	if compression == CompressionNone {
+		return true
+	}
+	return (flags.GetAccept() & (1 << compression)) != 0
+}